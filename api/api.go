@@ -0,0 +1,887 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+)
+
+/**
+ * REST layer over a notes datastore
+ *  - routes are declared once in a table (see routes()), which drives both dispatching and
+ *    the OpenAPI document served at '/openapi.json'
+ *  - request and response bodies are JSON (but for notes rendered as HTML); failures are answered
+ *    with an ErrorResponse
+ *  - a web UI is served under UIPath (see ui.go)
+ */
+type Handler struct {
+	db      models.Datastore
+	routes  []route
+	openAPI []byte
+}
+
+/**
+ * A route of the API
+ *  - pattern is a path whose '{param}' segments match any (single, escaped) segment
+ *  - request / response are values of the types of the request / response body (nil if there's none);
+ *    they are only used to describe the route in the OpenAPI document
+ */
+type route struct {
+	method   string
+	pattern  string
+	summary  string
+	query    []queryParam
+	header   []queryParam
+	request  interface{}
+	response interface{}
+	status   int
+	// scope a token needs for the route (see auth.go); none for routes any token may use
+	access models.ScopeLevel
+	// hidden routes are left out of the OpenAPI document
+	hidden bool
+	handle func(w http.ResponseWriter, r *http.Request, params map[string]string) error
+}
+
+/**
+ * A query (or header) parameter of a route
+ */
+type queryParam struct {
+	name        string
+	description string
+	kind        reflect.Kind
+}
+
+/**
+ * Body of every error response
+ *  - Error has the error's code (see models.ErrorCodeOf) and message, and the notebook / note it's about if known
+ *  - Current is the note as currently stored, for updates whose If-Match no longer matches (412)
+ */
+type ErrorResponse struct {
+	Error   models.ErrorInfo `json:"error"`
+	Current *models.Note     `json:"current,omitempty"`
+}
+
+/**
+ * Body of POST /notebooks/{name}/notes
+ *  - Kind is one of "text" (the default), "markdown" and "json"; content of JSON notes must be valid JSON
+ *  - without Title, the note's title is inferred from content if title inference is on
+ */
+type NoteInput struct {
+	Title   string   `json:"title,omitempty"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags,omitempty"`
+	Kind    string   `json:"kind,omitempty"`
+}
+
+/**
+ * Body of PUT /notebooks/{name}/notes/{id}
+ *  - with an If-Match header (the ETag of the note as last read, see revisionETag), the update only happens
+ *    if the note is still at that revision: otherwise the response is a 412 carrying the current note
+ *  - notes locked read-only can't be updated (nor deleted) over the API: the response is a 409
+ */
+type NoteUpdate struct {
+	Content string `json:"content"`
+}
+
+/**
+ * Body of POST /favorites
+ *  - with Before set, the note (a favorite already, or added) is moved right before that favorite
+ */
+type FavoriteInput struct {
+	Ref    models.NoteRef  `json:"ref"`
+	Before *models.NoteRef `json:"before,omitempty"`
+}
+
+/**
+ * Page of notes answered by GET /notebooks/{name}/notes when paginated (with limit or cursor)
+ *  - NextCursor is absent on the last page
+ *  - cursors belong to the sort order and filters they were answered for: passing one along
+ *    with others is answered with a 400
+ *  - notes deleted between pages don't make notes sorted after them skipped or repeated
+ */
+type NotesPage struct {
+	Notes      []models.Note `json:"notes"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+/**
+ * Same as NotesPage, answered unless 'full' is asked for
+ */
+type SummariesPage struct {
+	Notes      []models.NoteSummary `json:"notes"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+/**
+ * Returned (and answered with 400) for requests that are malformed
+ */
+var errBadRequest = errors.New("bad request")
+
+/**
+ * <Constructor for Handler>
+ */
+func NewHandler(db models.Datastore) *Handler {
+	h := &Handler{db: db}
+	h.routes = append(h.routeTable(), h.uiRoutes()...)
+	encoded, err := json.MarshalIndent(h.openAPIDocument(), "", "  ")
+	if err != nil {
+		// can't happen: the document is made of maps, slices and strings only
+		panic(err)
+	}
+	h.openAPI = encoded
+	return h
+}
+
+func (h *Handler) routeTable() []route {
+	return []route{
+		{method: http.MethodGet, pattern: "/", summary: "Index of endpoints", hidden: true, handle: h.index},
+		{method: http.MethodGet, pattern: "/openapi.json", summary: "OpenAPI document of this API", hidden: true, handle: h.serveOpenAPI},
+		{method: http.MethodGet, pattern: "/notebooks", summary: "List names of notebooks",
+			access: models.ScopeRead, query: []queryParam{{name: "archived", description: "include archived notebooks", kind: reflect.Bool}},
+			response: []string{}, status: http.StatusOK, handle: h.listNotebooks},
+		{method: http.MethodGet, pattern: "/notebooks/{name}", summary: "Get details of a notebook",
+			access: models.ScopeRead, response: models.NotebookInfo{}, status: http.StatusOK, handle: h.getNotebook},
+		{method: http.MethodPost, pattern: "/notebooks/{name}/archive", summary: "Archive a notebook (making it read-only)",
+			access: models.ScopeAdmin, response: models.NotebookInfo{}, status: http.StatusOK, handle: h.archiveNotebook},
+		{method: http.MethodPost, pattern: "/notebooks/{name}/unarchive", summary: "Unarchive a notebook",
+			access: models.ScopeAdmin, response: models.NotebookInfo{}, status: http.StatusOK, handle: h.unarchiveNotebook},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/notes", summary: "List summaries of notes of a notebook, or notes with 'full' (a SummariesPage or NotesPage if paginated)",
+			access: models.ScopeRead, query: []queryParam{
+				{name: "expired", description: "include expired notes", kind: reflect.Bool},
+				{name: "tag", description: "only notes having this tag", kind: reflect.String},
+				{name: "created_since", description: "only notes created since: a duration ago (30d, 12h), a day (yesterday, last monday) or a date", kind: reflect.String},
+				{name: "updated_since", description: "only notes updated since (as created_since)", kind: reflect.String},
+				{name: "sort", description: "order: id, created_at or updated_at, prefixed with '-' for descending", kind: reflect.String},
+				{name: "limit", description: "paginate, answering at most this many notes per page", kind: reflect.Int},
+				{name: "cursor", description: "continue with the page after this cursor (next_cursor of the previous page)", kind: reflect.String},
+				{name: "full", description: "answer notes in full rather than summaries", kind: reflect.Bool},
+				{name: "preview", description: "characters of content previewed by summaries (100 by default)", kind: reflect.Int},
+			},
+			response: []models.NoteSummary{}, status: http.StatusOK, handle: h.listNotes},
+		{method: http.MethodPost, pattern: "/notebooks/{name}/notes", summary: "Add a note (creating the notebook if needed)",
+			access: models.ScopeReadWrite, request: NoteInput{}, response: models.Note{}, status: http.StatusCreated, handle: h.addNote},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/notes/{id}", summary: "Get a note (with its revision as ETag)",
+			access: models.ScopeRead, response: models.Note{}, status: http.StatusOK, handle: h.getNote},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/notes/{id}/html", summary: "Get a note rendered as an HTML fragment",
+			access: models.ScopeRead, status: http.StatusOK, handle: h.renderNote},
+		{method: http.MethodPut, pattern: "/notebooks/{name}/notes/{id}", summary: "Update content of a note",
+			access: models.ScopeReadWrite, header: []queryParam{{name: "If-Match", description: "only update the note if it's " +
+				"still at the revision of this ETag (answering 412 otherwise)", kind: reflect.String}}, request: NoteUpdate{}, response: models.Note{}, status: http.StatusOK, handle: h.updateNote},
+		{method: http.MethodDelete, pattern: "/notebooks/{name}/notes/{id}", summary: "Delete a note",
+			access: models.ScopeReadWrite, status: http.StatusNoContent, handle: h.deleteNote},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/notes/{id}/attachments/{attachment}", summary: "Download an attachment of a note (answering Range requests, with the hash of its content as ETag)",
+			access: models.ScopeRead, status: http.StatusOK, handle: h.getAttachment},
+		{method: http.MethodPut, pattern: "/notebooks/{name}/notes/{id}/attachments/{attachment}", summary: "Attach the file of the raw body to a note (replacing any attachment of the same name)",
+			access: models.ScopeReadWrite, response: models.Attachment{}, status: http.StatusOK, handle: h.putAttachment},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/suggestions/tags", summary: "Suggest tags of a notebook, most used first",
+			access: models.ScopeRead, query: suggestionParams, response: []string{}, status: http.StatusOK, handle: h.suggestTags},
+		{method: http.MethodGet, pattern: "/notebooks/{name}/suggestions/titles", summary: "Suggest titles of notes of a notebook, most recent first",
+			access: models.ScopeRead, query: suggestionParams, response: []string{}, status: http.StatusOK, handle: h.suggestTitles},
+		{method: http.MethodGet, pattern: "/search", summary: "Search notes of one or all notebooks",
+			access: models.ScopeRead, query: []queryParam{
+				{name: "q", description: "text to look for", kind: reflect.String},
+				{name: "notebook", description: "notebook to search (all notebooks if omitted)", kind: reflect.String},
+				{name: "min_score", description: "leave out results scoring less", kind: reflect.Float64},
+				{name: "archived", description: "also search archived notebooks (when searching all notebooks)", kind: reflect.Bool},
+				{name: "skip_binary", description: "leave out binary-ish notes, their number being answered in the " +
+					"X-Skipped-Binary-Notes header", kind: reflect.Bool},
+				{name: "limit", description: "answer the first this many results found, in order of ids rather than ranked " +
+					"(searching stops there)", kind: reflect.Int},
+			},
+			response: []models.SearchResult{}, status: http.StatusOK, handle: h.search},
+		{method: http.MethodGet, pattern: "/favorites", summary: "List favorite notes (of any notebooks), in their order",
+			access: models.ScopeRead, response: []models.SearchResult{}, status: http.StatusOK, handle: h.listFavorites},
+		{method: http.MethodPost, pattern: "/favorites", summary: "Add a note to the end of favorites (or move it before another favorite)",
+			access: models.ScopeReadWrite, request: FavoriteInput{}, status: http.StatusNoContent, handle: h.addFavorite},
+		{method: http.MethodDelete, pattern: "/favorites/{notebook}/{id}", summary: "Remove a note from favorites",
+			access: models.ScopeReadWrite, status: http.StatusNoContent, handle: h.removeFavorite},
+		{method: http.MethodGet, pattern: "/debug/slow-ops", summary: "List operations slower than the slow-op threshold (see `serve --slow-op-threshold`), newest first",
+			access: models.ScopeAdmin, query: []queryParam{{name: "limit", description: "operations answered at most (all of those kept in memory if omitted)", kind: reflect.Int}},
+			response: []models.SlowOp{}, status: http.StatusOK, handle: h.listSlowOps},
+		{method: http.MethodGet, pattern: "/debug/top-notebooks", summary: "Rank notebooks by operations on their notes recorded in the changelog, busiest first",
+			access: models.ScopeAdmin, query: []queryParam{{name: "window", description: "how far back operations are counted, like 1h or 7d (24h by default)", kind: reflect.String}},
+			response: []models.NotebookOpCount{}, status: http.StatusOK, handle: h.topNotebooks},
+		{method: http.MethodGet, pattern: "/debug/index-maintenance", summary: "Tell what the index maintainer (see `serve --index-maintenance`) checked and repaired",
+			access: models.ScopeAdmin, response: models.MaintenanceStats{}, status: http.StatusOK, handle: h.maintenanceStats},
+		{method: http.MethodGet, pattern: SharedNotesPath + "{token}", summary: "Get a note shared through a link, as an HTML page or as JSON if accepted (needs no API token)",
+			response: models.Note{}, status: http.StatusOK, handle: h.viewSharedNote},
+	}
+}
+
+/**
+ * Dispatches a request to the route matching its method and path
+ */
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathMatched := false
+	for _, rt := range h.routes {
+		params, ok := matchPath(rt.pattern, r.URL.EscapedPath())
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if rt.method != r.Method {
+			continue
+		}
+		if err := authorize(r, rt, params); err != nil {
+			writeError(w, err, params)
+			return
+		}
+		if err := rt.handle(w, r, params); err != nil {
+			writeError(w, err, params)
+		}
+		return
+	}
+	if pathMatched {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: models.ErrorInfo{Code: models.CodeValidation, Message: "method not allowed"}})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: models.ErrorInfo{Code: models.CodeNotFound, Message: "no such endpoint"}})
+}
+
+/**
+ * Matches an escaped path against a route pattern, returning (unescaped) values of its params
+ */
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(pathSegments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (h *Handler) listNotebooks(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var opts []models.NotebookOption
+	if archived, _ := strconv.ParseBool(r.URL.Query().Get("archived")); archived {
+		opts = append(opts, models.WithArchivedNotebooks())
+	}
+	names, err := h.db.GetAllNotebookNames(opts...)
+	if err != nil {
+		return err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return writeJSON(w, http.StatusOK, names)
+}
+
+func (h *Handler) getNotebook(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	info, err := h.db.GetNotebookInfo(params["name"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, info)
+}
+
+func (h *Handler) archiveNotebook(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := h.db.ArchiveNotebook(params["name"]); err != nil {
+		return err
+	}
+	return h.getNotebook(w, r, params)
+}
+
+func (h *Handler) unarchiveNotebook(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := h.db.UnarchiveNotebook(params["name"]); err != nil {
+		return err
+	}
+	return h.getNotebook(w, r, params)
+}
+
+func (h *Handler) listNotes(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	var opts []models.ListOption
+	if expired, _ := strconv.ParseBool(query.Get("expired")); expired {
+		opts = append(opts, models.WithExpired())
+	}
+	if tag := query.Get("tag"); tag != "" {
+		opts = append(opts, models.WithTag(tag))
+	}
+	now := time.Now()
+	for param, sinceOption := range map[string]func(since string, from time.Time) models.ListOption{
+		"created_since": models.CreatedSince, "updated_since": models.UpdatedSince,
+	} {
+		if since := query.Get(param); since != "" {
+			from, err := utils.ParseSince(since, now, time.Local)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", errBadRequest, param, err)
+			}
+			// (relative bounds resolve to later times page after page, which cursors must stay valid for)
+			opts = append(opts, sinceOption(since, from))
+		}
+	}
+	full, _ := strconv.ParseBool(query.Get("full"))
+	previewLength := models.DefaultPreviewLength
+	if preview := query.Get("preview"); preview != "" {
+		n, err := strconv.Atoi(preview)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%w: invalid preview '%s'", errBadRequest, preview)
+		}
+		previewLength = n
+	}
+	if query.Get("limit") == "" && query.Get("cursor") == "" && query.Get("sort") == "" {
+		if !full {
+			summaries, err := h.db.ListNoteSummaries(params["name"], previewLength, opts...)
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, http.StatusOK, summaries)
+		}
+		notes, err := h.db.ListNotes(params["name"], opts...)
+		if err != nil {
+			return err
+		}
+		if notes == nil {
+			notes = []models.Note{}
+		}
+		return writeJSON(w, http.StatusOK, notes)
+	}
+
+	notesQuery := h.db.Query(params["name"]).Filter(opts...).After(models.Cursor(query.Get("cursor")))
+	if sort := query.Get("sort"); sort != "" {
+		order, ok := sortOrders[sort]
+		if !ok {
+			return fmt.Errorf("%w: invalid sort '%s'", errBadRequest, sort)
+		}
+		notesQuery.SortBy(order)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%w: invalid limit '%s'", errBadRequest, limit)
+		}
+		notesQuery.Limit(n)
+	}
+	if !full {
+		summaries, next, err := notesQuery.ExecuteSummaries(previewLength)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, SummariesPage{Notes: summaries, NextCursor: string(next)})
+	}
+	notes, next, err := notesQuery.Execute()
+	if err != nil {
+		return err
+	}
+	if notes == nil {
+		notes = []models.Note{}
+	}
+	return writeJSON(w, http.StatusOK, NotesPage{Notes: notes, NextCursor: string(next)})
+}
+
+/**
+ * Sort orders accepted by 'sort' of GET /notebooks/{name}/notes, by name
+ */
+var sortOrders = map[string]models.SortOrder{}
+
+func init() {
+	for _, order := range []models.SortOrder{models.IdAsc, models.IdDesc, models.CreatedAtAsc,
+		models.CreatedAtDesc, models.UpdatedAtAsc, models.UpdatedAtDesc} {
+		sortOrders[order.String()] = order
+	}
+}
+
+func (h *Handler) addNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var input NoteInput
+	if err := decodeBody(r, &input); err != nil {
+		return err
+	}
+	note, err := h.db.AddNote(params["name"], models.Note{TitleText: input.Title, Content: input.Content, Tags: input.Tags, Kind: input.Kind})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, note)
+}
+
+func (h *Handler) getNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	note, err := h.db.GetNote(params["name"], noteId)
+	if err != nil {
+		return err
+	}
+	if note.Id == 0 {
+		return fmt.Errorf("%w: %d in notebook '%s'", models.ErrNoteNotFound, noteId, params["name"])
+	}
+	w.Header().Set("ETag", revisionETag(note.Revision))
+	return writeJSON(w, http.StatusOK, note)
+}
+
+func (h *Handler) renderNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	note, err := h.db.GetNote(params["name"], noteId)
+	if err != nil {
+		return err
+	}
+	if note.Id == 0 {
+		return fmt.Errorf("%w: %d in notebook '%s'", models.ErrNoteNotFound, noteId, params["name"])
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.WriteString(w, models.RenderHTML(note))
+	return err
+}
+
+func (h *Handler) updateNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	var update NoteUpdate
+	if err := decodeBody(r, &update); err != nil {
+		return err
+	}
+	revision, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	var note models.Note
+	if revision != 0 {
+		note, err = h.db.UpdateNoteIfRevision(params["name"], noteId, revision, update.Content)
+	} else {
+		note, err = h.db.UpdateNote(params["name"], noteId, update.Content)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", revisionETag(note.Revision))
+	return writeJSON(w, http.StatusOK, note)
+}
+
+/**
+ * ETag of a note at given revision, as answered by GET and PUT /notebooks/{name}/notes/{id}
+ */
+func revisionETag(revision uint64) string {
+	return `"` + strconv.FormatUint(revision, 10) + `"`
+}
+
+/**
+ * Parses an If-Match header holding the ETag of a revision (see revisionETag)
+ * return: (uint64, error) The revision, 0 if the header is absent or '*' (any revision)
+ */
+func parseIfMatch(ifMatch string) (uint64, error) {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseUint(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || revision == 0 || len(ifMatch) < 3 || ifMatch[0] != '"' || ifMatch[len(ifMatch)-1] != '"' {
+		return 0, fmt.Errorf("%w: If-Match must be the ETag of a revision, like '\"3\"', not '%s'", errBadRequest, ifMatch)
+	}
+	return revision, nil
+}
+
+func (h *Handler) deleteNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	if exists, err := h.db.NoteExists(params["name"], noteId); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %d in notebook '%s'", models.ErrNoteNotFound, noteId, params["name"])
+		}
+		return err
+	}
+	if err := h.db.DeleteNotes(params["name"], noteId); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) getAttachment(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	reader, attachment, err := h.db.GetAttachment(params["name"], noteId, params["attachment"])
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	contentType := mime.TypeByExtension(filepath.Ext(attachment.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	// attachments are whatever was attached: never sniffed, nor run as pages of this origin
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("ETag", `"`+attachment.Hash+`"`)
+	// (answers Range requests with 206 and If-None-Match ones with 304, seeking the reader)
+	http.ServeContent(w, r, attachment.Name, attachment.AddedAt, reader)
+	return nil
+}
+
+func (h *Handler) putAttachment(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.requireNotebook(params["name"]); err != nil {
+		return err
+	}
+	// the body is streamed into the database as it's read (see models.AddAttachment)
+	attachment, err := h.db.AddAttachment(params["name"], noteId, params["attachment"], r.Body)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, attachment)
+}
+
+func (h *Handler) search(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	query := r.URL.Query()
+	if query.Get("q") == "" {
+		return fmt.Errorf("%w: missing query parameter 'q'", errBadRequest)
+	}
+	var opts []models.SearchOption
+	if minScore := query.Get("min_score"); minScore != "" {
+		score, err := strconv.ParseFloat(minScore, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid min_score '%s'", errBadRequest, minScore)
+		}
+		opts = append(opts, models.MinScore(score))
+	}
+	archived, _ := strconv.ParseBool(query.Get("archived"))
+	if archived {
+		opts = append(opts, models.IncludeArchivedNotebooks())
+	}
+	skippedBinary := 0
+	skipBinary, _ := strconv.ParseBool(query.Get("skip_binary"))
+	if skipBinary {
+		opts = append(opts, models.SkipBinaryNotes(&skippedBinary))
+	}
+	var results []models.SearchResult
+	var err error
+	switch limit, notebookName := query.Get("limit"), query.Get("notebook"); {
+	case limit != "":
+		// (ranking would need every result, so a limited search answers results as they're found)
+		n, convErr := strconv.Atoi(limit)
+		if convErr != nil || n < 1 {
+			return fmt.Errorf("%w: invalid limit '%s'", errBadRequest, limit)
+		}
+		if notebookName == "" {
+			results, err = h.searchAllNotebooksLimited(r, query.Get("q"), n, archived, opts)
+			break
+		}
+		results, err = h.searchLimited(notebookName, query.Get("q"), n, opts)
+	case notebookName != "":
+		results, err = h.db.SearchNotes(notebookName, query.Get("q"), opts...)
+	default:
+		results, err = h.db.SearchAllNotebooksContext(r.Context(), query.Get("q"), opts...)
+	}
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	if skipBinary {
+		w.Header().Set("X-Skipped-Binary-Notes", strconv.Itoa(skippedBinary))
+	}
+	return writeJSON(w, http.StatusOK, results)
+}
+
+/**
+ * First results of a search of a notebook, as they're found: the search stops at the limit-th one
+ */
+func (h *Handler) searchLimited(notebookName, q string, limit int, opts []models.SearchOption) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	err := h.db.SearchStream(notebookName, q, func(result models.SearchResult) (bool, error) {
+		results = append(results, result)
+		return len(results) < limit, nil
+	}, opts...)
+	return results, err
+}
+
+/**
+ * First results of a search of all notebooks, taken in turns from every notebook having any (so that
+ * the first notebooks searched don't crowd out the others), at most limit of them
+ *  - no notebook is searched past its limit-th result, and notebooks locked (see models.DB.EncryptNotebook)
+ *    are left out as in searches of all notebooks
+ */
+func (h *Handler) searchAllNotebooksLimited(r *http.Request, q string, limit int, archived bool, opts []models.SearchOption) ([]models.SearchResult, error) {
+	var notebookOpts []models.NotebookOption
+	if archived {
+		notebookOpts = append(notebookOpts, models.WithArchivedNotebooks())
+	}
+	names, err := h.db.GetAllNotebookNames(notebookOpts...)
+	if err != nil {
+		return nil, err
+	}
+	var found [][]models.SearchResult
+	for _, name := range names {
+		if err := r.Context().Err(); err != nil {
+			return nil, err
+		}
+		results, err := h.searchLimited(name, q, limit, opts)
+		if errors.Is(err, models.ErrNotebookLocked) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, results)
+	}
+	var results []models.SearchResult
+	for turn := 0; len(results) < limit; turn++ {
+		taken := false
+		for _, notebookResults := range found {
+			if turn < len(notebookResults) && len(results) < limit {
+				results = append(results, notebookResults[turn])
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+	}
+	return results, nil
+}
+
+// query parameters of suggestion routes
+var suggestionParams = []queryParam{
+	{name: "prefix", description: "what suggestions start with (case-insensitively); the top ones if omitted", kind: reflect.String},
+	{name: "limit", description: "suggestions answered at most (10 by default)", kind: reflect.Int},
+}
+
+// suggestions answered unless 'limit' says otherwise
+const defaultSuggestionLimit = 10
+
+func (h *Handler) suggestTags(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return suggest(w, r, params, h.db.SuggestTags)
+}
+
+func (h *Handler) suggestTitles(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return suggest(w, r, params, h.db.SuggestTitles)
+}
+
+/**
+ * Answers suggestions of given kind for the 'prefix' and 'limit' query parameters
+ *  - none are answered for DBs lacking the index, with header 'X-Index-Not-Built' telling so
+ */
+func suggest(w http.ResponseWriter, r *http.Request, params map[string]string, suggestions func(notebookName, prefix string, limit int) ([]string, error)) error {
+	query := r.URL.Query()
+	limit := defaultSuggestionLimit
+	if param := query.Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%w: invalid limit '%s'", errBadRequest, param)
+		}
+		limit = n
+	}
+	suggested, err := suggestions(params["name"], query.Get("prefix"), limit)
+	if errors.Is(err, models.ErrIndexNotBuilt) {
+		// (nothing to suggest from, which isn't the client's fault)
+		w.Header().Set("X-Index-Not-Built", "true")
+	} else if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, suggested)
+}
+
+func (h *Handler) listFavorites(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	favorites, err := h.db.ListFavorites()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, favorites)
+}
+
+func (h *Handler) addFavorite(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var input FavoriteInput
+	if err := decodeBody(r, &input); err != nil {
+		return err
+	}
+	if err := h.db.AddFavorite(input.Ref); err != nil {
+		return err
+	}
+	if input.Before != nil {
+		if err := h.db.MoveFavorite(input.Ref, *input.Before); err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) removeFavorite(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := h.db.RemoveFavorite(models.NoteRef{Notebook: params["notebook"], Id: noteId}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) listSlowOps(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	limit := 0
+	if param := r.URL.Query().Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%w: invalid limit '%s'", errBadRequest, param)
+		}
+		limit = n
+	}
+	return writeJSON(w, http.StatusOK, h.db.SlowOps(limit))
+}
+
+func (h *Handler) maintenanceStats(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return writeJSON(w, http.StatusOK, h.db.MaintenanceStats())
+}
+
+// window of time of top notebooks, unless 'window' says otherwise
+const defaultTopNotebooksWindow = 24 * time.Hour
+
+func (h *Handler) topNotebooks(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	window := defaultTopNotebooksWindow
+	if param := r.URL.Query().Get("window"); param != "" {
+		d, err := utils.ParseDuration(param)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%w: invalid window '%s'", errBadRequest, param)
+		}
+		window = d
+	}
+	ranked, err := h.db.TopNotebooksByOps(window)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, ranked)
+}
+
+/**
+ * Fails with ErrNotebookNotFound if given notebook doesn't exist
+ */
+func (h *Handler) requireNotebook(notebookName string) error {
+	exists, err := h.db.NotebookExists(notebookName)
+	if err == nil && !exists {
+		err = fmt.Errorf("%w: '%s'", models.ErrNotebookNotFound, notebookName)
+	}
+	return err
+}
+
+func parseNoteId(s string) (uint64, error) {
+	noteId, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid note id '%s'", errBadRequest, s)
+	}
+	return noteId, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid body: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+/**
+ * Statuses of error codes (see models.ErrorCodeOf)
+ */
+var codeStatuses = map[models.ErrorCode]int{
+	models.CodeNotFound:         http.StatusNotFound,
+	models.CodeNotebookNotFound: http.StatusNotFound,
+	models.CodeConflict:         http.StatusConflict,
+	models.CodeQuotaExceeded:    http.StatusRequestEntityTooLarge,
+	models.CodeReadOnly:         http.StatusConflict,
+	models.CodeValidation:       http.StatusBadRequest,
+	models.CodeLocked:           http.StatusLocked,
+	models.CodeCorrupt:          http.StatusInternalServerError,
+	models.CodeInternal:         http.StatusInternalServerError,
+}
+
+/**
+ * Answers a failed request with the status matching the error's code
+ *  - some errors are answered with a more specific status of their code's class (410 for shares
+ *    no longer available, 401 for invalid tokens, 403 for access not granted, 412 for revision
+ *    conflicts of If-Match updates, 503 for writes past their time budget and for workspace files
+ *    that couldn't be opened)
+ *  - errors not telling the notebook / note they're about are taken to be about the route's (params)
+ */
+func writeError(w http.ResponseWriter, err error, params map[string]string) {
+	response := ErrorResponse{Error: models.DescribeError(err)}
+	if errors.Is(err, errBadRequest) {
+		response.Error.Code = models.CodeValidation
+	}
+	if response.Error.Notebook == "" {
+		response.Error.Notebook = params["name"]
+		if response.Error.Notebook == "" {
+			response.Error.Notebook = params["notebook"]
+		}
+	}
+	if response.Error.NoteId == 0 {
+		response.Error.NoteId, _ = strconv.ParseUint(params["id"], 10, 64)
+	}
+	status := codeStatuses[response.Error.Code]
+	var conflict *models.RevisionConflictError
+	switch {
+	case errors.Is(err, models.ErrNoteShareGone):
+		status = http.StatusGone
+	case errors.Is(err, models.ErrInvalidAPIToken):
+		status = http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, models.ErrWriteTimeout), errors.Is(err, models.ErrDatabaseUnavailable):
+		status = http.StatusServiceUnavailable
+	case errors.As(err, &conflict):
+		status = http.StatusPreconditionFailed
+		response.Current = &conflict.Current
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Creates a handler over a throwaway DB
+ */
+func newTestHandler(t *testing.T) (*Handler, *models.DB) {
+	t.Helper()
+	db := notestest.NewDB(t)
+	return NewHandler(db), db
+}
+
+/**
+ * Serves a request, body (if not nil) being sent as JSON unless it's already a reader
+ */
+func serve(t *testing.T, h http.Handler, method, target string, header http.Header, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = body
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestNoteETagAndIfMatch(t *testing.T) {
+	h, db := newTestHandler(t)
+	note := notestest.MustAdd(t, db, "work", "first")
+
+	w := serve(t, h, http.MethodGet, "/notebooks/work/notes/1", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: %d %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != revisionETag(note.Revision) {
+		t.Fatalf("ETag = %s, want %s", etag, revisionETag(note.Revision))
+	}
+
+	w = serve(t, h, http.MethodPut, "/notebooks/work/notes/1", http.Header{"If-Match": {etag}}, NoteUpdate{Content: "second"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with a current If-Match: %d %s", w.Code, w.Body)
+	}
+	var updated models.Note
+	decodeResponse(t, w, &updated)
+	if updated.Content != "second" || w.Header().Get("ETag") != revisionETag(updated.Revision) || updated.Revision == note.Revision {
+		t.Errorf("PUT answered %+v with ETag %s", updated, w.Header().Get("ETag"))
+	}
+
+	// the ETag read first is stale by now
+	w = serve(t, h, http.MethodPut, "/notebooks/work/notes/1", http.Header{"If-Match": {etag}}, NoteUpdate{Content: "clobbered"})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with a stale If-Match: %d %s, want 412", w.Code, w.Body)
+	}
+	var response ErrorResponse
+	decodeResponse(t, w, &response)
+	if response.Current == nil || response.Current.Content != "second" || response.Current.Revision != updated.Revision {
+		t.Errorf("412 carries current note %+v, want the one at revision %d", response.Current, updated.Revision)
+	}
+	if current, err := db.GetNote("work", 1); err != nil || current.Content != "second" {
+		t.Errorf("note after a failed conditional PUT = %q (%v)", current.Content, err)
+	}
+
+	// without If-Match (or with '*'), the last write wins
+	for _, header := range []http.Header{nil, {"If-Match": {"*"}}} {
+		if w := serve(t, h, http.MethodPut, "/notebooks/work/notes/1", header, NoteUpdate{Content: "overwritten"}); w.Code != http.StatusOK {
+			t.Errorf("PUT with If-Match %v: %d %s", header, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMalformedIfMatch(t *testing.T) {
+	h, db := newTestHandler(t)
+	notestest.MustAdd(t, db, "work", "first")
+	for _, ifMatch := range []string{"1", `W/"1"`, `"one"`, `"0"`, `"`} {
+		w := serve(t, h, http.MethodPut, "/notebooks/work/notes/1", http.Header{"If-Match": {ifMatch}}, NoteUpdate{Content: "second"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PUT with If-Match %s: %d, want 400", ifMatch, w.Code)
+		}
+	}
+}
+
+func TestDeleteNoteOfUnknownNotebook(t *testing.T) {
+	h, db := newTestHandler(t)
+	notestest.MustAdd(t, db, "work", "first")
+	for _, c := range []struct {
+		target string
+		status int
+		code   models.ErrorCode
+	}{
+		{"/notebooks/missing/notes/1", http.StatusNotFound, models.CodeNotebookNotFound},
+		{"/notebooks/work/notes/2", http.StatusNotFound, models.CodeNotFound},
+	} {
+		w := serve(t, h, http.MethodDelete, c.target, nil, nil)
+		if w.Code != c.status {
+			t.Errorf("DELETE %s: %d %s, want %d", c.target, w.Code, w.Body, c.status)
+			continue
+		}
+		var response ErrorResponse
+		decodeResponse(t, w, &response)
+		if response.Error.Code != c.code {
+			t.Errorf("DELETE %s answered code %s, want %s", c.target, response.Error.Code, c.code)
+		}
+	}
+	if w := serve(t, h, http.MethodDelete, "/notebooks/work/notes/1", nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE of a note: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUISaveNoteConflict(t *testing.T) {
+	h, db := newTestHandler(t)
+	note := notestest.MustAdd(t, db, "work", "first")
+	if _, err := db.UpdateNote("work", note.Id, "changed meanwhile"); err != nil {
+		t.Fatal(err)
+	}
+	// the form was for revision 1
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	w := serve(t, h, http.MethodPost, UIPath+"/notebooks/work/notes/1/edit", header, strings.NewReader("content=mine&revision=1"))
+	if w.Code != http.StatusPreconditionFailed || !bytes.Contains(w.Body.Bytes(), []byte("changed meanwhile")) {
+		t.Errorf("saving a stale form: %d, want 412 showing the form again", w.Code)
+	}
+	if current, _ := db.GetNote("work", note.Id); current.Content != "changed meanwhile" {
+		t.Errorf("stale form overwrote the note: %q", current.Content)
+	}
+}
+
+func TestWriteErrorStatuses(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		code   models.ErrorCode
+		status int
+	}{
+		{fmt.Errorf("%w: 120 notes of 'work'", models.ErrConfirmationRequired), models.CodeConflict, http.StatusConflict},
+		{&models.WriteTimeoutError{Budget: time.Second, Elapsed: 2 * time.Second, Completed: 3, Total: 10}, models.CodeInternal, http.StatusServiceUnavailable},
+		{models.ErrWriteTimeout, models.CodeInternal, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: mount 'archive'", models.ErrDatabaseUnavailable), models.CodeInternal, http.StatusServiceUnavailable},
+		{&models.WorkspaceUnavailableError{Mounts: []string{"archive"}}, models.CodeInternal, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: 'https://example.com' answered 502 Bad Gateway", models.ErrClipFailed), models.CodeInternal, http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		writeError(w, c.err, map[string]string{"name": "work"})
+		var response ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != c.status || response.Error.Code != c.code {
+			t.Errorf("%v: answered %d %s, want %d %s", c.err, w.Code, response.Error.Code, c.status, c.code)
+		}
+	}
+}
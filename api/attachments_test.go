@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// size of the chunks attachments are stored in (see models/chunks.go), which ranges start and end within
+const attachmentChunk = 256 << 10
+
+/**
+ * Attaches content of given size to a new note of 'work' through the API, returning its path and content
+ */
+func putTestAttachment(t *testing.T, h http.Handler, db *models.DB, name string, size int) (string, []byte) {
+	t.Helper()
+	note := notestest.MustAdd(t, db, "work", "scans")
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7 % 251)
+	}
+	path := fmt.Sprintf("/notebooks/work/notes/%d/attachments/%s", note.Id, name)
+	w := serve(t, h, http.MethodPut, path, nil, bytes.NewReader(content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT %s: %d %s", path, w.Code, w.Body)
+	}
+	return path, content
+}
+
+func rangeHeader(ranges string) http.Header {
+	return http.Header{"Range": {ranges}}
+}
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	h, db := newTestHandler(t)
+	path, content := putTestAttachment(t, h, db, "scan.pdf", 3*attachmentChunk+100)
+
+	w := serve(t, h, http.MethodGet, path, nil, nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Fatalf("GET %s: %d, %d bytes of %d", path, w.Code, w.Body.Len(), len(content))
+	}
+	attachments, err := db.ListAttachments("work", 1)
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("attachments %+v (%v)", attachments, err)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":            "application/pdf",
+		"Content-Length":          fmt.Sprint(len(content)),
+		"Accept-Ranges":           "bytes",
+		"ETag":                    `"` + attachments[0].Hash + `"`,
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+	if w := serve(t, h, http.MethodGet, path, http.Header{"If-None-Match": {w.Header().Get("ETag")}}, nil); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("GET with the attachment's ETag: %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	// attaching other content under the same name replaces it
+	replaced := []byte("second version")
+	if w := serve(t, h, http.MethodPut, path, nil, bytes.NewReader(replaced)); w.Code != http.StatusOK {
+		t.Fatalf("PUT again: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, h, http.MethodGet, path, nil, nil); !bytes.Equal(w.Body.Bytes(), replaced) {
+		t.Errorf("GET of the replaced attachment: %q", w.Body)
+	}
+	if w := serve(t, h, http.MethodGet, "/notebooks/work/notes/1/attachments/other.pdf", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing attachment: %d", w.Code)
+	}
+}
+
+func TestAttachmentRanges(t *testing.T) {
+	h, db := newTestHandler(t)
+	path, content := putTestAttachment(t, h, db, "scan.bin", 3*attachmentChunk+100)
+	size := len(content)
+	for _, r := range []struct {
+		ranges     string
+		start, end int
+	}{
+		{"bytes=0-99", 0, 99},
+		{"bytes=0-0", 0, 0},
+		// within a chunk, then across the end of one
+		{"bytes=300000-300099", 300000, 300099},
+		{fmt.Sprintf("bytes=%d-%d", attachmentChunk-10, attachmentChunk+9), attachmentChunk - 10, attachmentChunk + 9},
+		// from an offset on, and the last N bytes (the last chunk being short)
+		{fmt.Sprintf("bytes=%d-", 2*attachmentChunk+5), 2*attachmentChunk + 5, size - 1},
+		{"bytes=-500", size - 500, size - 1},
+		{fmt.Sprintf("bytes=-%d", size+10), 0, size - 1},
+		// ends past the end are cut to it
+		{fmt.Sprintf("bytes=%d-%d", size-10, size+1000), size - 10, size - 1},
+	} {
+		w := serve(t, h, http.MethodGet, path, rangeHeader(r.ranges), nil)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("%s: %d, want 206", r.ranges, w.Code)
+			continue
+		}
+		if want := fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size); w.Header().Get("Content-Range") != want {
+			t.Errorf("%s: Content-Range %q, want %q", r.ranges, w.Header().Get("Content-Range"), want)
+		}
+		if !bytes.Equal(w.Body.Bytes(), content[r.start:r.end+1]) {
+			t.Errorf("%s: %d bytes that differ from bytes %d to %d", r.ranges, w.Body.Len(), r.start, r.end)
+		}
+	}
+
+	// several ranges are answered as parts of a multipart body
+	w := serve(t, h, http.MethodGet, path, rangeHeader("bytes=10-19,-5"), nil)
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("several ranges: %d %s (%v)", w.Code, w.Header().Get("Content-Type"), err)
+	}
+	parts := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range [][]byte{content[10:20], content[size-5:]} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(part); !bytes.Equal(got, want) {
+			t.Errorf("part %s: %v, want %v", part.Header.Get("Content-Range"), got, want)
+		}
+	}
+}
+
+func TestAttachmentMalformedAndUnsatisfiableRanges(t *testing.T) {
+	h, db := newTestHandler(t)
+	path, content := putTestAttachment(t, h, db, "scan.bin", attachmentChunk+1)
+	for _, ranges := range []string{
+		"bytes=abc",
+		"bytes=10-5",
+		"bytes=-",
+		"bytes=--5",
+		"items=0-1",
+		fmt.Sprintf("bytes=%d-", len(content)),
+		fmt.Sprintf("bytes=%d-%d", len(content)+10, len(content)+20),
+	} {
+		w := serve(t, h, http.MethodGet, path, rangeHeader(ranges), nil)
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("%s: %d, want 416", ranges, w.Code)
+			continue
+		}
+		if bytes.Contains(w.Body.Bytes(), content[:100]) {
+			t.Errorf("%s: answered content along with the 416", ranges)
+		}
+	}
+	w := serve(t, h, http.MethodGet, path, rangeHeader(fmt.Sprintf("bytes=%d-", len(content))), nil)
+	if want := fmt.Sprintf("bytes */%d", len(content)); w.Header().Get("Content-Range") != want {
+		t.Errorf("unsatisfiable range: Content-Range %q, want %q", w.Header().Get("Content-Range"), want)
+	}
+}
+
+func TestAttachmentTooLarge(t *testing.T) {
+	h, db := newTestHandler(t)
+	db.SetAttachmentLimit(attachmentChunk)
+	note := notestest.MustAdd(t, db, "work", "scans")
+	path := fmt.Sprintf("/notebooks/work/notes/%d/attachments/big.bin", note.Id)
+	w := serve(t, h, http.MethodPut, path, nil, bytes.NewReader(make([]byte, attachmentChunk+1)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT over the limit: %d %s, want 413", w.Code, w.Body)
+	}
+	if attachments, err := db.ListAttachments("work", note.Id); err != nil || len(attachments) != 0 {
+		t.Errorf("attachments after an upload over the limit %+v (%v)", attachments, err)
+	}
+	if problems, err := db.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity problems after an upload over the limit %+v (%v)", problems, err)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Authentication of API clients by API tokens (see models.CreateAPIToken)
+ *  - Authenticate answers requests without a valid token with a 401, and attaches the token
+ *    of the others to their context
+ *  - Handler then checks the token's scopes against the route: reading needs ScopeRead,
+ *    changing notes ScopeReadWrite and managing notebooks ScopeAdmin; a scope confined to
+ *    a notebook only covers routes on that notebook (routes across notebooks, like listing
+ *    them, need a scope on all notebooks). Requests lacking scope are answered with a 403
+ *  - a Handler serving requests that didn't go through Authenticate checks nothing
+ *  - notes shared through links (under SharedNotesPath) are served without a token
+ *  - requests for the web UI (under UIPath) are challenged for basic authentication, for browsers to ask for a token
+ */
+
+/**
+ * Store of API tokens
+ */
+type TokenAuthenticator interface {
+	AuthenticateAPIToken(token string) (models.APIToken, error)
+}
+
+type tokenContextKey struct{}
+
+/**
+ * Middleware accepting requests carrying a valid API token, either as 'Authorization: Bearer <token>'
+ * or as password of basic authentication (the user name is ignored); requests for shared notes need none
+ * param: TokenAuthenticator tokens
+ * param: http.Handler       next
+ * return: http.Handler
+ */
+func Authenticate(tokens TokenAuthenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, SharedNotesPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var presented string
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			presented = strings.TrimSpace(header[len("Bearer "):])
+		} else if _, password, ok := r.BasicAuth(); ok {
+			presented = password
+		}
+		if presented == "" {
+			unauthorized(w, r, "missing API token")
+			return
+		}
+		token, err := tokens.AuthenticateAPIToken(presented)
+		if errors.Is(err, models.ErrInvalidAPIToken) {
+			unauthorized(w, r, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, err, nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+	})
+}
+
+/**
+ * Token a request was authenticated with (see Authenticate)
+ * param: context.Context ctx
+ * return: (models.APIToken, bool)
+ */
+func TokenFromContext(ctx context.Context) (models.APIToken, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(models.APIToken)
+	return token, ok
+}
+
+/**
+ * Fails with models.ErrForbidden if the request's token lacks the scope a route needs
+ */
+func authorize(r *http.Request, rt route, params map[string]string) error {
+	token, ok := TokenFromContext(r.Context())
+	if !ok || rt.access == "" {
+		return nil
+	}
+	notebookName := params["name"]
+	if notebookName == "" {
+		notebookName = r.URL.Query().Get("notebook")
+	}
+	if token.Allows(rt.access, notebookName) {
+		return nil
+	}
+	if notebookName == "" {
+		return fmt.Errorf("%w: token '%s' needs %s scope on all notebooks", models.ErrForbidden, token.Name, rt.access)
+	}
+	return fmt.Errorf("%w: token '%s' needs %s scope on notebook '%s'", models.ErrForbidden, token.Name, rt.access, notebookName)
+}
+
+/**
+ * Answers a 401 (coded LOCKED, as access not granted is), challenging browsers visiting the web UI for basic authentication (for them to ask for a token)
+ */
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	if r.URL.Path == UIPath || strings.HasPrefix(r.URL.Path, UIPath+"/") {
+		w.Header().Set("WWW-Authenticate", `Basic realm="notes", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="notes"`)
+	}
+	writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: models.ErrorInfo{Code: models.CodeLocked, Message: message}})
+}
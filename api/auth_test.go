@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Handler over a throwaway DB behind Authenticate, with notebooks 'work' and 'home' of a note each
+ */
+func newAuthenticatedHandler(t *testing.T) (http.Handler, *models.DB) {
+	t.Helper()
+	h, db := newTestHandler(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"plan"}, "home": {"groceries"}}})
+	return Authenticate(db, h), db
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestScopeEnforcementByRouteClass(t *testing.T) {
+	// routes of every class: reading across notebooks, reading a notebook, writing to it, and admin
+	// routes on a notebook and across notebooks
+	routes := []struct {
+		class  string
+		method string
+		target string
+		body   interface{}
+		status int
+	}{
+		{"read all", http.MethodGet, "/notebooks", nil, http.StatusOK},
+		{"read", http.MethodGet, "/notebooks/work/notes", nil, http.StatusOK},
+		{"read search", http.MethodGet, "/search?q=plan&notebook=work", nil, http.StatusOK},
+		{"write", http.MethodPost, "/notebooks/work/notes", NoteInput{Content: "more"}, http.StatusCreated},
+		{"write delete", http.MethodDelete, "/notebooks/work/notes/1", nil, http.StatusNoContent},
+		{"admin", http.MethodPost, "/notebooks/work/archive", nil, http.StatusOK},
+		{"admin all", http.MethodGet, "/debug/index-maintenance", nil, http.StatusOK},
+	}
+	for _, test := range []struct {
+		scope   string
+		allowed string
+	}{
+		{"read", "read all, read, read search"},
+		{"read-write", "read all, read, read search, write, write delete"},
+		{"admin", "read all, read, read search, write, write delete, admin, admin all"},
+		// confined to the notebook: nothing across notebooks
+		{"read:work", "read, read search"},
+		{"read-write:work", "read, read search, write, write delete"},
+		{"admin:work", "read, read search, write, write delete, admin"},
+		// confined to another notebook
+		{"admin:home", ""},
+	} {
+		allowed := make(map[string]bool)
+		for _, class := range strings.Split(test.allowed, ", ") {
+			allowed[class] = true
+		}
+		for _, rt := range routes {
+			t.Run(test.scope+"/"+rt.class, func(t *testing.T) {
+				h, db := newAuthenticatedHandler(t)
+				scope, err := models.ParseScope(test.scope)
+				if err != nil {
+					t.Fatal(err)
+				}
+				token, err := db.CreateAPIToken("client", []models.Scope{scope})
+				if err != nil {
+					t.Fatal(err)
+				}
+				w := serve(t, h, rt.method, rt.target, bearer(token), rt.body)
+				want := http.StatusForbidden
+				if allowed[rt.class] {
+					want = rt.status
+				}
+				if w.Code != want {
+					t.Fatalf("%s %s with scope %s: %d %s, want %d", rt.method, rt.target, test.scope, w.Code, w.Body, want)
+				}
+				if want == http.StatusForbidden {
+					var response ErrorResponse
+					decodeResponse(t, w, &response)
+					if response.Error.Code != models.CodeLocked {
+						t.Errorf("forbidden request answered with code %s", response.Error.Code)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestAuthenticateRejectsMissingAndInvalidTokens(t *testing.T) {
+	h, db := newAuthenticatedHandler(t)
+	token, err := db.CreateAPIToken("client", []models.Scope{{Level: models.ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := db.CreateAPIToken("old", []models.Scope{{Level: models.ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeAPIToken("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, header := range map[string]http.Header{
+		"no token":          nil,
+		"empty bearer":      {"Authorization": {"Bearer "}},
+		"malformed":         bearer("not-a-token"),
+		"wrong secret":      bearer(token + "x"),
+		"revoked":           bearer(revoked),
+		"other scheme":      {"Authorization": {"Token " + token}},
+		"wrong basic token": {"Authorization": {"Basic dXNlcjpub3Blcw=="}},
+	} {
+		w := serve(t, h, http.MethodGet, "/notebooks", header, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", name, w.Code)
+			continue
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Bearer ") {
+			t.Errorf("%s: challenged with %q", name, challenge)
+		}
+		var response ErrorResponse
+		decodeResponse(t, w, &response)
+		if response.Error.Code != models.CodeLocked {
+			t.Errorf("%s: answered with code %s, want %s", name, response.Error.Code, models.CodeLocked)
+		}
+	}
+
+	// the token as password of basic authentication, any user name
+	basic := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("anyone:"+token))}}
+	if w := serve(t, h, http.MethodGet, "/notebooks", basic, nil); w.Code != http.StatusOK {
+		t.Errorf("token as basic authentication password: %d", w.Code)
+	}
+	// the web UI challenges browsers for basic authentication
+	if w := serve(t, h, http.MethodGet, UIPath, nil, nil); w.Code != http.StatusUnauthorized ||
+		!strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Errorf("web UI without a token: %d challenged with %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestSharedNotesNeedNoToken(t *testing.T) {
+	h, db := newAuthenticatedHandler(t)
+	shareToken, err := db.CreateNoteShareLink("work", 1, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(t, h, http.MethodGet, SharedNotesPath+shareToken, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("shared note without a token: %d %s", w.Code, w.Body)
+	}
+}
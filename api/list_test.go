@@ -0,0 +1,181 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Handler over a notebook 'big' of n notes ('note 1'..), created a minute apart
+ */
+func newPagingHandler(t *testing.T, n int) (*Handler, *models.DB) {
+	t.Helper()
+	h, db := newTestHandler(t)
+	contents := make([]string, n)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("note %d", i+1)
+	}
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"big": contents}})
+	return h, db
+}
+
+/**
+ * Walks GET /notebooks/big/notes page by page with given query, calling between (if not nil) after
+ * every page; returns ids of notes answered, in order, and the number of pages
+ */
+func walkPages(t *testing.T, h *Handler, query url.Values, between func(page int)) ([]uint64, int) {
+	t.Helper()
+	var ids []uint64
+	pages := 0
+	for cursor := ""; ; {
+		query.Set("cursor", cursor)
+		w := serve(t, h, http.MethodGet, "/notebooks/big/notes?"+query.Encode(), nil, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("page %d: %d %s", pages+1, w.Code, w.Body)
+		}
+		var page SummariesPage
+		decodeResponse(t, w, &page)
+		pages++
+		for _, note := range page.Notes {
+			ids = append(ids, note.Id)
+		}
+		if page.NextCursor == "" {
+			return ids, pages
+		}
+		if pages > 1000 {
+			t.Fatal("pagination doesn't end")
+		}
+		cursor = page.NextCursor
+		if between != nil {
+			between(pages)
+		}
+	}
+}
+
+func TestListNotesPagesOfSeven(t *testing.T) {
+	h, _ := newPagingHandler(t, 500)
+	for _, test := range []struct {
+		sort  string
+		first uint64
+		step  int
+	}{
+		{"id", 1, 1},
+		{"-id", 500, -1},
+		{"created_at", 1, 1},
+		{"-created_at", 500, -1},
+	} {
+		t.Run(test.sort, func(t *testing.T) {
+			ids, pages := walkPages(t, h, url.Values{"limit": {"7"}, "sort": {test.sort}}, nil)
+			// 71 full pages and one of 3
+			if pages != 72 || len(ids) != 500 {
+				t.Fatalf("%d notes in %d pages, want 500 in 72", len(ids), pages)
+			}
+			for i, id := range ids {
+				if want := uint64(int(test.first) + i*test.step); id != want {
+					t.Fatalf("note %d of the walk is %d, want %d", i, id, want)
+				}
+			}
+		})
+	}
+}
+
+func TestListNotesPagesAcrossDeletions(t *testing.T) {
+	h, db := newPagingHandler(t, 500)
+	deleted := make(map[uint64]bool)
+	ids, _ := walkPages(t, h, url.Values{"limit": {"7"}}, func(page int) {
+		// delete the last note seen, and two notes of the next page
+		for _, id := range []uint64{uint64(page * 7), uint64(page*7 + 1), uint64(page*7 + 4)} {
+			if id > 500 || deleted[id] {
+				continue
+			}
+			if err := db.DeleteNotes("big", id); err != nil {
+				t.Fatal(err)
+			}
+			deleted[id] = true
+		}
+	})
+
+	seen := make(map[uint64]bool)
+	for i, id := range ids {
+		if seen[id] {
+			t.Errorf("note %d answered twice", id)
+		}
+		seen[id] = true
+		if i > 0 && id <= ids[i-1] {
+			t.Errorf("note %d answered after %d", id, ids[i-1])
+		}
+	}
+	// every note not deleted before its page was answered
+	for id := uint64(1); id <= 500; id++ {
+		if !seen[id] && !deleted[id] {
+			t.Errorf("note %d skipped", id)
+		}
+	}
+}
+
+func TestListNotesPagesSinceRelativeTime(t *testing.T) {
+	h, db := newPagingHandler(t, 10)
+	// notes 11 to 17 are recent, the first 10 years old
+	recent := make([]string, 7)
+	for i := range recent {
+		recent[i] = fmt.Sprintf("recent note %d", i+1)
+	}
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"big": recent}, Start: time.Now().Add(-time.Hour)})
+
+	// '3d' resolves to a later time on every page, which mustn't invalidate cursors
+	for _, param := range []string{"created_since", "updated_since"} {
+		ids, pages := walkPages(t, h, url.Values{"limit": {"2"}, "sort": {"id"}, param: {"3d"}}, nil)
+		if want := []uint64{11, 12, 13, 14, 15, 16, 17}; pages != 4 || !reflect.DeepEqual(ids, want) {
+			t.Errorf("notes since 3d by %s: %v in %d pages, want %v in 4", param, ids, pages, want)
+		}
+	}
+
+	// while a cursor of a query since another time still belongs to another query
+	w := serve(t, h, http.MethodGet, "/notebooks/big/notes?limit=2&created_since=3d", nil, nil)
+	var page SummariesPage
+	decodeResponse(t, w, &page)
+	target := "/notebooks/big/notes?limit=2&created_since=5d&cursor=" + url.QueryEscape(page.NextCursor)
+	if w := serve(t, h, http.MethodGet, target, nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("cursor of a query since 3d, since 5d: %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+func TestListNotesRejectsForeignCursors(t *testing.T) {
+	h, db := newPagingHandler(t, 20)
+	notestest.MustAddNote(t, db, "big", models.Note{Content: "tagged", Tags: []string{"x"}})
+	w := serve(t, h, http.MethodGet, "/notebooks/big/notes?limit=7&sort=id", nil, nil)
+	var page SummariesPage
+	decodeResponse(t, w, &page)
+	if page.NextCursor == "" {
+		t.Fatal("no next cursor on the first page")
+	}
+	cursor := url.QueryEscape(page.NextCursor)
+	for name, target := range map[string]string{
+		"another sort":   "/notebooks/big/notes?limit=7&sort=-id&cursor=" + cursor,
+		"another filter": "/notebooks/big/notes?limit=7&sort=id&tag=x&cursor=" + cursor,
+		"garbage":        "/notebooks/big/notes?limit=7&cursor=not-a-cursor",
+		"bad limit":      "/notebooks/big/notes?limit=0",
+	} {
+		w := serve(t, h, http.MethodGet, target, nil, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s, want 400", name, w.Code, w.Body)
+			continue
+		}
+		var response ErrorResponse
+		decodeResponse(t, w, &response)
+		if response.Error.Code != models.CodeValidation {
+			t.Errorf("%s: answered with code %s", name, response.Error.Code)
+		}
+	}
+	// the same cursor with the same sort and filters goes on
+	if w := serve(t, h, http.MethodGet, "/notebooks/big/notes?limit=7&sort=id&cursor="+cursor, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("continuing with the cursor: %d %s", w.Code, w.Body)
+	}
+}
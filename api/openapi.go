@@ -0,0 +1,260 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * OpenAPI document of the API, built from the route table
+ *  - schemas of bodies are derived by reflection from the Go types (and their json tags),
+ *    so that they can't drift from the structs actually encoded
+ *  - fields without 'omitempty' are required; pointers are nullable
+ */
+func (h *Handler) openAPIDocument() map[string]interface{} {
+	schemas := schemaSet{schemas: make(map[string]interface{})}
+	errorResponse := map[string]interface{}{
+		"description": "error",
+		"content":     jsonContent(schemas.of(reflect.TypeOf(ErrorResponse{}))),
+	}
+
+	paths := make(map[string]interface{})
+	for _, rt := range h.routes {
+		if rt.hidden {
+			continue
+		}
+		operation := map[string]interface{}{
+			"summary":     rt.summary,
+			"operationId": operationId(rt),
+		}
+
+		var parameters []interface{}
+		for _, segment := range strings.Split(rt.pattern, "/") {
+			if !strings.HasPrefix(segment, "{") {
+				continue
+			}
+			name := strings.Trim(segment, "{}")
+			schema := map[string]interface{}{"type": "string"}
+			if name == "id" {
+				schema = map[string]interface{}{"type": "integer", "format": "int64", "minimum": 1}
+			}
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+		for _, param := range rt.query {
+			schema := map[string]interface{}{"type": "string"}
+			switch param.kind {
+			case reflect.Bool:
+				schema = map[string]interface{}{"type": "boolean"}
+			case reflect.Int:
+				schema = map[string]interface{}{"type": "integer", "minimum": 1}
+			case reflect.Float64:
+				schema = map[string]interface{}{"type": "number"}
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name": param.name, "in": "query", "description": param.description, "schema": schema,
+			})
+		}
+		for _, param := range rt.header {
+			parameters = append(parameters, map[string]interface{}{
+				"name": param.name, "in": "header", "description": param.description, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		if rt.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.of(reflect.TypeOf(rt.request))),
+			}
+		}
+		success := map[string]interface{}{"description": http.StatusText(rt.status)}
+		if rt.response != nil {
+			success["content"] = jsonContent(schemas.of(reflect.TypeOf(rt.response)))
+		}
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(rt.status): success,
+			"default":               errorResponse,
+		}
+
+		pathItem, ok := paths[rt.pattern].(map[string]interface{})
+		if !ok {
+			pathItem = make(map[string]interface{})
+			paths[rt.pattern] = pathItem
+		}
+		pathItem[strings.ToLower(rt.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "notes",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas.schemas},
+	}
+}
+
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(h.openAPI)
+	return err
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>notes API</title></head>
+<body>
+<h1>notes API</h1>
+<p>Machine-readable description: <a href="/openapi.json">/openapi.json</a></p>
+<table>
+{{range .}}<tr><td><code>{{.Method}}</code></td><td><code>{{.Pattern}}</code></td><td>{{.Summary}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+/**
+ * Serves a plain HTML page listing the endpoints
+ */
+func (h *Handler) index(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	type endpoint struct {
+		Method, Pattern, Summary string
+	}
+	var endpoints []endpoint
+	for _, rt := range h.routes {
+		if !rt.hidden {
+			endpoints = append(endpoints, endpoint{Method: rt.method, Pattern: rt.pattern, Summary: rt.summary})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return indexTemplate.Execute(w, endpoints)
+}
+
+/**
+ * Name of an operation, like 'getNotebooksNameNotes' for GET /notebooks/{name}/notes
+ */
+func operationId(rt route) string {
+	id := strings.ToLower(rt.method)
+	for _, segment := range strings.Split(rt.pattern, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment != "" {
+			id += strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return id
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+/**
+ * Schemas of named struct types, collected as they're referenced (components/schemas)
+ */
+type schemaSet struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+/**
+ * Schema of a Go type, as encoding/json encodes it
+ * Named structs become references to components/schemas
+ */
+func (s *schemaSet) of(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := s.of(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice, t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.schemas[t.Name()]; !ok {
+			// register before descending, so that recursive types terminate
+			s.schemas[t.Name()] = nil
+			s.schemas[t.Name()] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	// interfaces and anything else: any value
+	return map[string]interface{}{}
+}
+
+/**
+ * Object schema of a struct's exported fields, named after their json tags
+ * (fields of embedded structs without a tag are inlined, as encoding/json does)
+ */
+func (s *schemaSet) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	s.addFields(t, properties, &required)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s *schemaSet) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+		if !strings.Contains(","+options+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Validates a decoded JSON value against a schema of the document, as far as the schemas built by
+ * schemaSet go (types, formats, minimums, required and known properties, nullability, references)
+ */
+type schemaValidator struct {
+	schemas map[string]interface{}
+}
+
+func (v schemaValidator) validate(path string, value interface{}, schema map[string]interface{}) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, ok := v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: dangling reference %s", path, ref)
+		}
+		return v.validate(path, value, target)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null, but not nullable", path)
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if err := v.validate(path, value, sub.(map[string]interface{})); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch schema["type"] {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v isn't a boolean", path, value)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && n != float64(int64(n))) {
+			return fmt.Errorf("%s: %v isn't an %s", path, value, schema["type"])
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			return fmt.Errorf("%s: %v is less than %v", path, n, minimum)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v isn't a string", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v isn't an array", path, value)
+		}
+		for i, item := range items {
+			if err := v.validate(fmt.Sprintf("%s[%d]", path, i), item, schema["items"].(map[string]interface{})); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v isn't an object", path, value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: required property '%s' is missing", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok && additional == nil {
+				return fmt.Errorf("%s: property '%s' isn't in the schema", path, name)
+			}
+			if !ok {
+				propertySchema = additional
+			}
+			if err := v.validate(path+"."+name, property, propertySchema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * Fetches the OpenAPI document the way clients do
+ */
+func fetchOpenAPI(t *testing.T) (map[string]interface{}, schemaValidator) {
+	t.Helper()
+	h, _ := newTestHandler(t)
+	w := serve(t, h, http.MethodGet, "/openapi.json", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: %d", w.Code)
+	}
+	var document map[string]interface{}
+	decodeResponse(t, w, &document)
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	return document, schemaValidator{schemas: schemas}
+}
+
+/**
+ * Encodes value as the API does, and validates it against the named schema
+ */
+func validateEncoded(t *testing.T, v schemaValidator, schemaName string, value interface{}) error {
+	t.Helper()
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return v.validate(schemaName, decoded, map[string]interface{}{"$ref": "#/components/schemas/" + schemaName})
+}
+
+func TestNoteRoundTripsThroughSchema(t *testing.T) {
+	_, v := fetchOpenAPI(t)
+	expiresAt := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	note := models.Note{
+		Id: 7, Revision: 3, Content: "# Launch", Tags: []string{"launch"}, ExpiresAt: &expiresAt,
+		CreatedAt: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC), UpdatedAt: time.Date(2024, time.March, 2, 9, 30, 0, 0, time.UTC),
+		Chunks: &models.ChunkDescriptor{}, Language: "en", ReadOnly: true, Kind: models.KindMarkdown, Position: 1.5,
+		TitleText: "Launch", TitleInferred: true, Fingerprint: "fp", Clock: models.VectorClock{"laptop": 2},
+		SourceURL: "https://example.com",
+	}
+	if err := validateEncoded(t, v, "Note", note); err != nil {
+		t.Fatalf("note doesn't match its schema: %v", err)
+	}
+	// every field is described (a field missing from the schema would have failed above), and
+	// decoding gives back the note
+	encoded, _ := json.Marshal(note)
+	var decoded models.Note
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, note) {
+		t.Errorf("note decoded as %+v, want %+v", decoded, note)
+	}
+
+	// the note as stored and served validates too, with its optional fields left out
+	h, db := newTestHandler(t)
+	notestest.MustAdd(t, db, "work", "plain")
+	w := serve(t, h, http.MethodGet, "/notebooks/work/notes/1", nil, nil)
+	var served interface{}
+	decodeResponse(t, w, &served)
+	if err := v.validate("Note", served, map[string]interface{}{"$ref": "#/components/schemas/Note"}); err != nil {
+		t.Errorf("served note doesn't match its schema: %v", err)
+	}
+}
+
+func TestSchemaRejectsMismatchingNotes(t *testing.T) {
+	_, v := fetchOpenAPI(t)
+	for name, note := range map[string]map[string]interface{}{
+		"content not a string":  {"id": 1, "revision": 1, "content": 42, "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"},
+		"missing content":       {"id": 1, "revision": 1, "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"},
+		"unknown property":      {"id": 1, "revision": 1, "content": "", "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z", "colour": "red"},
+		"time not a date-time":  {"id": 1, "revision": 1, "content": "", "created_at": "yesterday", "updated_at": "2024-01-01T00:00:00Z"},
+		"negative id":           {"id": -1, "revision": 1, "content": "", "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"},
+		"tags not strings":      {"id": 1, "revision": 1, "content": "", "tags": []int{1}, "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"},
+		"null non-nullable tag": {"id": 1, "revision": 1, "content": "", "tags": []interface{}{nil}, "created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"},
+	} {
+		if err := validateEncoded(t, v, "Note", note); err == nil {
+			t.Errorf("%s: validated", name)
+		}
+	}
+}
+
+func TestSchemasOfOtherBodies(t *testing.T) {
+	_, v := fetchOpenAPI(t)
+	bodies := map[string]interface{}{
+		"NotebookInfo":  models.NotebookInfo{Name: "work"},
+		"ErrorResponse": ErrorResponse{Error: models.ErrorInfo{Code: models.CodeNotFound, Message: "note not found", Notebook: "work", NoteId: 1}},
+	}
+	names := make([]string, 0, len(bodies))
+	for name := range bodies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := validateEncoded(t, v, name, bodies[name]); err != nil {
+			t.Errorf("%s doesn't match its schema: %v", name, err)
+		}
+	}
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	document, _ := fetchOpenAPI(t)
+	paths := document["paths"].(map[string]interface{})
+	h, _ := newTestHandler(t)
+	for _, rt := range h.routes {
+		item, _ := paths[rt.pattern].(map[string]interface{})
+		_, described := item[strings.ToLower(rt.method)]
+		if described == rt.hidden {
+			t.Errorf("%s %s: described = %v, hidden = %v", rt.method, rt.pattern, described, rt.hidden)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Refs of the notes a search answers with
+ */
+func searchRefs(t *testing.T, h *Handler, target string) []string {
+	t.Helper()
+	w := serve(t, h, http.MethodGet, target, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: %d %s", target, w.Code, w.Body)
+	}
+	var results []models.SearchResult
+	decodeResponse(t, w, &results)
+	refs := []string{}
+	for _, result := range results {
+		refs = append(refs, result.Ref.String())
+	}
+	return refs
+}
+
+func TestLimitedSearch(t *testing.T) {
+	h, db := newTestHandler(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"a": {"needle 1", "needle 2", "needle 3", "needle 4"},
+		"b": {"needle 5", "hay"},
+		"c": {"hay", "needle 6"},
+		"d": {"needle in a locked notebook"},
+	}})
+	if err := db.EncryptNotebook("d", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LockNotebook("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		target string
+		want   []string
+	}{
+		// every notebook having results is answered from, in turns
+		{"/search?q=needle&limit=5", []string{"a/1", "b/1", "c/2", "a/2", "a/3"}},
+		{"/search?q=needle&limit=3", []string{"a/1", "b/1", "c/2"}},
+		{"/search?q=needle&limit=2", []string{"a/1", "b/1"}},
+		{"/search?q=needle&limit=100", []string{"a/1", "b/1", "c/2", "a/2", "a/3", "a/4"}},
+		{"/search?q=needle&notebook=a&limit=2", []string{"a/1", "a/2"}},
+		{"/search?q=nothing&limit=2", []string{}},
+	} {
+		if got := searchRefs(t, h, test.target); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s answered %v, want %v", test.target, got, test.want)
+		}
+	}
+	if w := serve(t, h, http.MethodGet, "/search?q=needle&limit=0", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("limit 0: %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+func TestLimitedSearchAnswersFirstHitsById(t *testing.T) {
+	h, db := newTestHandler(t)
+	// more notes than ids of one digit, which keys of notes sort apart from ("10" before "2")
+	hits := make([]string, 12)
+	for i := range hits {
+		hits[i] = fmt.Sprintf("hit %d", i+1)
+	}
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"nb": hits}})
+	for _, test := range []struct {
+		target string
+		want   []string
+	}{
+		{"/search?notebook=nb&q=hit&limit=3", []string{"nb/1", "nb/2", "nb/3"}},
+		{"/search?notebook=nb&q=hit&limit=11", []string{"nb/1", "nb/2", "nb/3", "nb/4", "nb/5", "nb/6", "nb/7", "nb/8", "nb/9", "nb/10", "nb/11"}},
+		{"/search?q=hit&limit=10", []string{"nb/1", "nb/2", "nb/3", "nb/4", "nb/5", "nb/6", "nb/7", "nb/8", "nb/9", "nb/10"}},
+	} {
+		if got := searchRefs(t, h, test.target); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s answered %v, want %v", test.target, got, test.want)
+		}
+	}
+}
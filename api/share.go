@@ -0,0 +1,62 @@
+package api
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Notes shared through links (see models.CreateNoteShareLink) are served at SharedNotesPath + token
+ *  - to anyone holding the link: Authenticate lets these requests through without an API token
+ *  - as an HTML page, or as a models.Note for clients accepting JSON (but not HTML first)
+ *  - links that expired or ran out of views are answered with a 410, unknown ones with a 404
+ */
+const SharedNotesPath = "/shared/"
+
+var sharedNoteTemplate = template.Must(template.New("shared").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+{{.Body}}</body>
+</html>
+`))
+
+func (h *Handler) viewSharedNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	note, err := h.db.ViewSharedNote(params["token"])
+	if err != nil {
+		return err
+	}
+	// every request counts as a view, so answers mustn't be served from caches
+	w.Header().Set("Cache-Control", "no-store")
+	if prefersJSON(r) {
+		return writeJSON(w, http.StatusOK, note)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return sharedNoteTemplate.Execute(w, struct {
+		Title string
+		Body  template.HTML
+	}{Title: note.Title(), Body: template.HTML(models.RenderHTML(note))})
+}
+
+/**
+ * Whether a request's Accept header lists JSON before (or without) HTML
+ */
+func prefersJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
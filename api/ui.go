@@ -0,0 +1,436 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Web UI served under UIPath, for reading and writing notes from a browser
+ *  - pages are rendered on the server from embedded templates (see ui/), with a stylesheet and no scripts
+ *  - every page gets what it shows through the API routes (dispatched in-process with the request's
+ *    context), and forms are relayed to them: the UI can't do anything its visitor's token couldn't do
+ *    over the API, and with `--auth` the browser logs in with any user name and a token as password
+ *  - all content is escaped by html/template, notes being shown as rendered by /notebooks/{name}/notes/{id}/html
+ *  - forms posted from other sites are answered with a 403
+ *  - pages failing to render are answered with a 500, the cause being logged
+ */
+const UIPath = "/ui"
+
+// notes listed per page of a notebook
+const uiPageSize = 25
+
+// characters of content previewed by note lists
+const uiPreviewLength = 200
+
+//go:embed ui
+var uiFiles embed.FS
+
+var uiFuncs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"when": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04")
+	},
+	"preview": func(content string) string {
+		if utf8.RuneCountInString(content) <= uiPreviewLength {
+			return content
+		}
+		return string([]rune(content)[:uiPreviewLength]) + "…"
+	},
+}
+
+/**
+ * Templates of UI pages by name, each of them along with the layout
+ */
+var uiPages = func() map[string]*template.Template {
+	pages := make(map[string]*template.Template)
+	for _, name := range []string{"notebooks", "notes", "note", "edit", "search", "error"} {
+		pages[name] = template.Must(template.New(name).Funcs(uiFuncs).ParseFS(uiFiles,
+			"ui/templates/layout.html", "ui/templates/"+name+".html"))
+	}
+	return pages
+}()
+
+var uiStatic = func() http.Handler {
+	static, err := fs.Sub(uiFiles, "ui/static")
+	if err != nil {
+		// can't happen: the directory is embedded
+		panic(err)
+	}
+	return http.StripPrefix(UIPath+"/static/", http.FileServer(http.FS(static)))
+}()
+
+/**
+ * Answer of an API route called by the UI failing
+ */
+type uiAPIError struct {
+	Status   int
+	Response ErrorResponse
+}
+
+func (e *uiAPIError) Error() string {
+	return e.Response.Error.Message
+}
+
+func (h *Handler) uiRoutes() []route {
+	return []route{
+		{method: http.MethodGet, pattern: UIPath, summary: "Web UI: notebooks", hidden: true, handle: h.uiPage(h.uiNotebooks)},
+		{method: http.MethodGet, pattern: UIPath + "/static/{file}", summary: "Web UI: assets", hidden: true, handle: h.uiAsset},
+		{method: http.MethodGet, pattern: UIPath + "/notebooks/{name}", summary: "Web UI: notes of a notebook", hidden: true, handle: h.uiPage(h.uiNotes)},
+		{method: http.MethodGet, pattern: UIPath + "/notebooks/{name}/notes/{id}", summary: "Web UI: a note", hidden: true, handle: h.uiPage(h.uiNote)},
+		{method: http.MethodGet, pattern: UIPath + "/notebooks/{name}/notes/{id}/edit", summary: "Web UI: editing a note", hidden: true, handle: h.uiPage(h.uiEditNote)},
+		{method: http.MethodPost, pattern: UIPath + "/notebooks/{name}/notes/{id}/edit", summary: "Web UI: saving a note", hidden: true, handle: h.uiPage(h.uiSaveNote)},
+		{method: http.MethodGet, pattern: UIPath + "/new", summary: "Web UI: writing a note", hidden: true, handle: h.uiPage(h.uiNewNote)},
+		{method: http.MethodPost, pattern: UIPath + "/new", summary: "Web UI: adding a note", hidden: true, handle: h.uiPage(h.uiAddNote)},
+		{method: http.MethodGet, pattern: UIPath + "/search", summary: "Web UI: search", hidden: true, handle: h.uiPage(h.uiSearch)},
+	}
+}
+
+/**
+ * Wraps a UI page, answering its failures with an error page (rather than JSON) and posts from other sites with a 403
+ */
+func (h *Handler) uiPage(page func(w http.ResponseWriter, r *http.Request, params map[string]string) error) func(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		err := sameOrigin(r)
+		if err == nil {
+			err = page(w, r, params)
+		}
+		if err == nil {
+			return nil
+		}
+		status, message := http.StatusInternalServerError, err.Error()
+		var apiErr *uiAPIError
+		switch {
+		case errors.As(err, &apiErr):
+			status = apiErr.Status
+		case errors.Is(err, errBadRequest):
+			status = http.StatusBadRequest
+		default:
+			if s, ok := codeStatuses[models.ErrorCodeOf(err)]; ok {
+				status = s
+			}
+			if errors.Is(err, models.ErrForbidden) {
+				status = http.StatusForbidden
+			}
+		}
+		renderUIPage(w, status, "error", struct {
+			Status  string
+			Message string
+		}{Status: fmt.Sprintf("%d %s", status, http.StatusText(status)), Message: message})
+		return nil
+	}
+}
+
+/**
+ * Fails with models.ErrForbidden for posts whose Origin (or Referer) is another site than the UI's
+ * (browsers send the UI's credentials along with forms posted from anywhere)
+ */
+func sameOrigin(r *http.Request) error {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	return fmt.Errorf("%w: form posted from another site", models.ErrForbidden)
+}
+
+/**
+ * Renders a page in full before answering it, so that a template failing answers a 500 (its cause being logged)
+ * rather than half a page
+ */
+func renderUIPage(w http.ResponseWriter, status int, name string, data interface{}) {
+	var page bytes.Buffer
+	if err := uiPages[name].ExecuteTemplate(&page, "layout", data); err != nil {
+		log.Printf("ui: rendering page '%s': %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// no scripts at all: whatever gets past escaping can't run
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'none'")
+	w.WriteHeader(status)
+	page.WriteTo(w)
+}
+
+/**
+ * Calls an API route in-process on behalf of a UI request (with its context, so the same token and scopes apply)
+ *  - header (if any) is added to the request's, and body (if any) is sent as JSON
+ *  - out gets the answer: decoded from JSON, or as is for a *string
+ * Fails with a *uiAPIError if the route answers an error
+ */
+func (h *Handler) callAPI(r *http.Request, method, path string, query url.Values, header http.Header, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(r.Context())
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	answer := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	h.ServeHTTP(answer, req)
+
+	if answer.status >= http.StatusBadRequest {
+		apiErr := &uiAPIError{Status: answer.status}
+		if err := json.Unmarshal(answer.body.Bytes(), &apiErr.Response); err != nil || apiErr.Response.Error.Message == "" {
+			apiErr.Response.Error.Message = http.StatusText(answer.status)
+		}
+		return apiErr
+	}
+	switch v := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		*v = answer.body.String()
+		return nil
+	}
+	return json.Unmarshal(answer.body.Bytes(), out)
+}
+
+/**
+ * Path of an API route, with its segments escaped, like apiPath("notebooks", "a/b", "notes")
+ */
+func apiPath(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+/**
+ * ResponseWriter keeping what an API route answers to the UI
+ */
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status, b.wroteHeader = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
+
+func (h *Handler) uiAsset(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	uiStatic.ServeHTTP(w, r)
+	return nil
+}
+
+func (h *Handler) uiNotebooks(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var names []string
+	if err := h.callAPI(r, http.MethodGet, "/notebooks", nil, nil, nil, &names); err != nil {
+		return err
+	}
+	renderUIPage(w, http.StatusOK, "notebooks", names)
+	return nil
+}
+
+func (h *Handler) uiNotes(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	query := url.Values{
+		"limit":   {strconv.Itoa(uiPageSize)},
+		"sort":    {models.UpdatedAtDesc.String()},
+		"preview": {strconv.Itoa(uiPreviewLength)},
+	}
+	cursor := r.URL.Query().Get("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	var page SummariesPage
+	if err := h.callAPI(r, http.MethodGet, apiPath("notebooks", params["name"], "notes"), query, nil, nil, &page); err != nil {
+		return err
+	}
+	renderUIPage(w, http.StatusOK, "notes", struct {
+		Notebook   string
+		Notes      []models.NoteSummary
+		Cursor     string
+		NextCursor string
+	}{Notebook: params["name"], Notes: page.Notes, Cursor: cursor, NextCursor: page.NextCursor})
+	return nil
+}
+
+func (h *Handler) uiNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var note models.Note
+	if err := h.callAPI(r, http.MethodGet, apiPath("notebooks", params["name"], "notes", params["id"]), nil, nil, nil, &note); err != nil {
+		return err
+	}
+	var body string
+	if err := h.callAPI(r, http.MethodGet, apiPath("notebooks", params["name"], "notes", params["id"], "html"), nil, nil, nil, &body); err != nil {
+		return err
+	}
+	renderUIPage(w, http.StatusOK, "note", struct {
+		Notebook string
+		Note     models.Note
+		Body     template.HTML
+	}{Notebook: params["name"], Note: note, Body: template.HTML(body)})
+	return nil
+}
+
+/**
+ * What the note form shows: a new note when Id is 0, the content of an existing one otherwise
+ */
+type uiNoteForm struct {
+	Notebook string
+	Id       uint64
+	Revision uint64
+	Title    string
+	Tags     string
+	Kind     string
+	Content  string
+	Error    string
+}
+
+func (h *Handler) uiNewNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	renderUIPage(w, http.StatusOK, "edit", uiNoteForm{Notebook: r.URL.Query().Get("notebook")})
+	return nil
+}
+
+func (h *Handler) uiAddNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	form := uiNoteForm{
+		Notebook: strings.TrimSpace(r.PostForm.Get("notebook")),
+		Title:    r.PostForm.Get("title"),
+		Tags:     r.PostForm.Get("tags"),
+		Kind:     r.PostForm.Get("kind"),
+		Content:  r.PostForm.Get("content"),
+	}
+	if form.Notebook == "" {
+		form.Error = "A notebook is needed"
+		renderUIPage(w, http.StatusBadRequest, "edit", form)
+		return nil
+	}
+	input := NoteInput{Title: form.Title, Content: form.Content, Tags: strings.Fields(strings.Replace(form.Tags, ",", " ", -1)), Kind: form.Kind}
+	var note models.Note
+	err := h.callAPI(r, http.MethodPost, apiPath("notebooks", form.Notebook, "notes"), nil, nil, input, &note)
+	var apiErr *uiAPIError
+	if errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError {
+		form.Error = apiErr.Error()
+		renderUIPage(w, apiErr.Status, "edit", form)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, UIPath+apiPath("notebooks", form.Notebook, "notes", strconv.FormatUint(note.Id, 10)), http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) uiEditNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var note models.Note
+	if err := h.callAPI(r, http.MethodGet, apiPath("notebooks", params["name"], "notes", params["id"]), nil, nil, nil, &note); err != nil {
+		return err
+	}
+	renderUIPage(w, http.StatusOK, "edit", uiNoteForm{Notebook: params["name"], Id: note.Id, Revision: note.Revision, Content: note.Content})
+	return nil
+}
+
+/**
+ * Saves an edited note, if it wasn't changed meanwhile: otherwise the form is shown again, saving it then
+ * overwriting the other change
+ */
+func (h *Handler) uiSaveNote(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	noteId, err := parseNoteId(params["id"])
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	form := uiNoteForm{Notebook: params["name"], Id: noteId, Content: r.PostForm.Get("content")}
+	if revision := r.PostForm.Get("revision"); revision != "" {
+		if form.Revision, err = strconv.ParseUint(revision, 10, 64); err != nil {
+			return fmt.Errorf("%w: invalid revision '%s'", errBadRequest, revision)
+		}
+	}
+	var header http.Header
+	if form.Revision != 0 {
+		header = http.Header{"If-Match": {revisionETag(form.Revision)}}
+	}
+	err = h.callAPI(r, http.MethodPut, apiPath("notebooks", params["name"], "notes", params["id"]), nil, header,
+		NoteUpdate{Content: form.Content}, nil)
+	var apiErr *uiAPIError
+	if errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError && apiErr.Status != http.StatusNotFound {
+		form.Error = apiErr.Error()
+		if current := apiErr.Response.Current; current != nil {
+			form.Error = "The note was changed meanwhile: saving again overwrites that change"
+			form.Revision = current.Revision
+		}
+		renderUIPage(w, apiErr.Status, "edit", form)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, UIPath+apiPath("notebooks", params["name"], "notes", params["id"]), http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) uiSearch(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	data := struct {
+		Notebook string
+		Query    string
+		Results  []models.SearchResult
+	}{Notebook: r.URL.Query().Get("notebook"), Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if data.Query != "" {
+		query := url.Values{"q": {data.Query}}
+		if data.Notebook != "" {
+			query.Set("notebook", data.Notebook)
+		}
+		if err := h.callAPI(r, http.MethodGet, "/search", query, nil, nil, &data.Results); err != nil {
+			return err
+		}
+	}
+	renderUIPage(w, http.StatusOK, "search", data)
+	return nil
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// a note trying to get markup into pages through every field the UI shows
+const hostileContent = `<script>alert("content")</script> & more`
+
+var formHeader = http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+/**
+ * GETs a UI page, failing the test unless it's answered with a 200; returns the page
+ */
+func getPage(t *testing.T, h http.Handler, header http.Header, target string) string {
+	t.Helper()
+	w := serve(t, h, http.MethodGet, target, header, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Fatalf("GET %s: answered %s", target, contentType)
+	}
+	return w.Body.String()
+}
+
+func TestUIPagesRenderNotes(t *testing.T) {
+	h, db := newTestHandler(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"home": {"Groceries\nmilk, eggs and bread"},
+		"work": {"Standup\nshipped the web UI"},
+	}})
+	plan := notestest.MustAddNote(t, db, "work", models.Note{Content: "# Launch plan\n- **draft** the post", Kind: models.KindMarkdown, Tags: []string{"launch"}})
+
+	notebooks := getPage(t, h, nil, UIPath)
+	for _, want := range []string{`href="/ui/notebooks/home"`, `href="/ui/notebooks/work"`} {
+		if !strings.Contains(notebooks, want) {
+			t.Errorf("notebooks page lacks %s:\n%s", want, notebooks)
+		}
+	}
+	notes := getPage(t, h, nil, UIPath+"/notebooks/work")
+	for _, want := range []string{"Standup", "shipped the web UI", "Launch plan", "#launch", fmt.Sprintf(`href="/ui/notebooks/work/notes/%d"`, plan.Id)} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("notes page lacks %q:\n%s", want, notes)
+		}
+	}
+	if strings.Contains(notes, "Groceries") {
+		t.Error("notes page of 'work' shows a note of 'home'")
+	}
+	// Markdown notes are shown rendered
+	note := getPage(t, h, nil, fmt.Sprintf("%s/notebooks/work/notes/%d", UIPath, plan.Id))
+	if !strings.Contains(note, "<strong>draft</strong>") || !strings.Contains(note, "<h1>Launch plan</h1>") {
+		t.Errorf("note page doesn't render Markdown:\n%s", note)
+	}
+	search := getPage(t, h, nil, UIPath+"/search?q=milk")
+	if !strings.Contains(search, "Groceries") || strings.Contains(search, "Standup") {
+		t.Errorf("search page:\n%s", search)
+	}
+	if edit := getPage(t, h, nil, fmt.Sprintf("%s/notebooks/work/notes/%d/edit", UIPath, plan.Id)); !strings.Contains(edit, "- **draft** the post</textarea>") {
+		t.Errorf("edit page doesn't hold the content:\n%s", edit)
+	}
+	if w := serve(t, h, http.MethodGet, UIPath+"/static/style.css", nil, nil); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("stylesheet: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := serve(t, h, http.MethodGet, UIPath+"/notebooks/work/notes/999", nil, nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "404 Not Found") {
+		t.Errorf("page of a missing note: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUINotesPages(t *testing.T) {
+	h, db := newPagingHandler(t, uiPageSize+5)
+	first := getPage(t, h, nil, UIPath+"/notebooks/big")
+	// newest first: the 5 oldest notes are on the next page
+	if !strings.Contains(first, fmt.Sprintf("note %d<", uiPageSize+5)) || strings.Contains(first, "note 5<") {
+		t.Errorf("first page:\n%s", first)
+	}
+	i := strings.Index(first, `/ui/notebooks/big?cursor=`)
+	if i < 0 {
+		t.Fatalf("first page lacks a link to the next one:\n%s", first)
+	}
+	next := first[i : strings.Index(first[i:], `"`)+i]
+	second := getPage(t, h, nil, strings.Replace(next, "&amp;", "&", -1))
+	for n := 1; n <= 5; n++ {
+		if !strings.Contains(second, fmt.Sprintf("note %d<", n)) {
+			t.Errorf("second page lacks note %d:\n%s", n, second)
+		}
+	}
+	if strings.Contains(second, "Next page") || !strings.Contains(second, "First page") {
+		t.Errorf("links of the last page:\n%s", second)
+	}
+	if notes, _ := db.ListNotes("big"); len(notes) != uiPageSize+5 {
+		t.Errorf("%d notes after browsing", len(notes))
+	}
+}
+
+func TestUIEscapesNoteContent(t *testing.T) {
+	h, db := newTestHandler(t)
+	note := notestest.MustAddNote(t, db, "work", models.Note{
+		TitleText: `<img src=x onerror="alert('title')">`,
+		Content:   hostileContent,
+		Tags:      []string{"<b>tag</b>"},
+	})
+	markdown := notestest.MustAddNote(t, db, "work", models.Note{Content: "# Notes\n" + hostileContent, Kind: models.KindMarkdown})
+	for _, target := range []string{
+		UIPath + "/notebooks/work",
+		fmt.Sprintf("%s/notebooks/work/notes/%d", UIPath, note.Id),
+		fmt.Sprintf("%s/notebooks/work/notes/%d", UIPath, markdown.Id),
+		fmt.Sprintf("%s/notebooks/work/notes/%d/edit", UIPath, note.Id),
+		UIPath + "/search?q=" + url.QueryEscape("alert"),
+		UIPath + "/search?q=" + url.QueryEscape(`"><script>alert("query")</script>`),
+	} {
+		page := getPage(t, h, nil, target)
+		for _, raw := range []string{"<script", "<img", "<b>tag"} {
+			if strings.Contains(page, raw) {
+				t.Errorf("%s holds %s unescaped:\n%s", target, raw, page)
+			}
+		}
+		if !strings.Contains(page, "&lt;script&gt;") {
+			t.Errorf("%s doesn't show the escaped script:\n%s", target, page)
+		}
+	}
+	w := serve(t, h, http.MethodGet, UIPath+"/notebooks/work", nil, nil)
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'none'") {
+		t.Errorf("pages served with Content-Security-Policy %q", csp)
+	}
+}
+
+func TestUIFormsGoThroughTheAPI(t *testing.T) {
+	h, db := newTestHandler(t)
+	form := url.Values{"notebook": {"home"}, "title": {"Chores"}, "tags": {"weekly, house"}, "kind": {"markdown"}, "content": {"- [ ] vacuum"}}
+	w := serve(t, h, http.MethodPost, UIPath+"/new", formHeader, strings.NewReader(form.Encode()))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != UIPath+"/notebooks/home/notes/1" {
+		t.Fatalf("adding a note: %d to %q %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	added, err := db.GetNote("home", 1)
+	if err != nil || added.Title() != "Chores" || added.Kind != models.KindMarkdown || strings.Join(added.Tags, " ") != "weekly house" {
+		t.Errorf("note added %+v (%v)", added, err)
+	}
+
+	edit := url.Values{"content": {"- [x] vacuum"}, "revision": {fmt.Sprint(added.Revision)}}
+	if w := serve(t, h, http.MethodPost, UIPath+"/notebooks/home/notes/1/edit", formHeader, strings.NewReader(edit.Encode())); w.Code != http.StatusSeeOther {
+		t.Errorf("saving a note: %d %s", w.Code, w.Body)
+	}
+	if saved, _ := db.GetNote("home", 1); saved.Content != "- [x] vacuum" {
+		t.Errorf("note saved as %q", saved.Content)
+	}
+
+	// a form missing its notebook is shown again, and one posted from another site refused
+	if w := serve(t, h, http.MethodPost, UIPath+"/new", formHeader, strings.NewReader("content=orphan")); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "A notebook is needed") || !strings.Contains(w.Body.String(), ">orphan</textarea>") {
+		t.Errorf("form without a notebook: %d %s", w.Code, w.Body)
+	}
+	header := http.Header{"Content-Type": formHeader["Content-Type"], "Origin": {"https://elsewhere.example"}}
+	if w := serve(t, h, http.MethodPost, UIPath+"/new", header, strings.NewReader(form.Encode())); w.Code != http.StatusForbidden {
+		t.Errorf("form posted from another site: %d", w.Code)
+	}
+	if notes, _ := db.ListNotes("home"); len(notes) != 1 {
+		t.Errorf("%d notes in 'home', want only the one added", len(notes))
+	}
+}
+
+func TestUIRespectsTokenScopes(t *testing.T) {
+	h, db := newAuthenticatedHandler(t)
+	token, err := db.CreateAPIToken("reader", []models.Scope{{Level: models.ScopeRead, Notebook: "work"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// browsers send the token as password of basic authentication
+	basic := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("household:"+token))}}
+	if page := getPage(t, h, basic, UIPath+"/notebooks/work"); !strings.Contains(page, "plan") {
+		t.Errorf("notes page of a readable notebook:\n%s", page)
+	}
+	if w := serve(t, h, http.MethodGet, UIPath+"/notebooks/home", basic, nil); w.Code != http.StatusForbidden {
+		t.Errorf("notes page of a notebook out of the token's scope: %d", w.Code)
+	}
+	header := http.Header{"Authorization": basic["Authorization"], "Content-Type": formHeader["Content-Type"]}
+	if w := serve(t, h, http.MethodPost, UIPath+"/new", header, strings.NewReader("notebook=work&content=sneaky")); w.Code != http.StatusForbidden {
+		t.Errorf("adding a note with a read token: %d %s", w.Code, w.Body)
+	}
+	if notes, _ := db.ListNotes("work"); len(notes) != 1 {
+		t.Errorf("%d notes in 'work' after a refused form", len(notes))
+	}
+}
+
+func TestUITemplateErrorAnswers500(t *testing.T) {
+	h, db := newTestHandler(t)
+	notestest.MustAdd(t, db, "work", "plan")
+	// a layout reading a field notebook pages don't have fails once rendering started
+	broken := template.Must(template.New("notebooks").Parse(`{{define "layout"}}<h1>partial</h1>{{.Missing}}{{end}}`))
+	saved := uiPages["notebooks"]
+	uiPages["notebooks"] = broken
+	t.Cleanup(func() { uiPages["notebooks"] = saved })
+	var logged bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(output) })
+
+	w := serve(t, h, http.MethodGet, UIPath, nil, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("page failing to render: %d, want 500", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "partial") {
+		t.Errorf("half a page answered: %q", w.Body)
+	}
+	if !strings.Contains(logged.String(), "rendering page 'notebooks'") || !strings.Contains(logged.String(), "Missing") {
+		t.Errorf("cause not logged: %q", logged.String())
+	}
+	// other pages render still
+	getPage(t, h, nil, UIPath+"/notebooks/work")
+}
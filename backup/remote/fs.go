@@ -0,0 +1,111 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/**
+ * Prefix of files holding uploads in progress; they're never listed
+ */
+const partialPrefix = ".partial-"
+
+/**
+ * ObjectStore keeping objects as files under a directory (keys map onto relative paths)
+ * Useful for backing up to a mounted drive, and for trying things out without a cloud account
+ */
+type FSStore struct {
+	dir string
+}
+
+/**
+ * <Constructor for FSStore>
+ * Creates the directory if it doesn't exist
+ */
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FSStore{dir: dir}, nil
+}
+
+/**
+ * Streams r into a temporary file which is renamed to the key only once complete
+ */
+func (s *FSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), partialPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, &ctxReader{ctx: ctx, r: r})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FSStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), partialPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (s *FSStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FSStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+/**
+ * Reader failing once its context is done, so that long copies can be interrupted
+ */
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,117 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Layout of the timestamp within backup keys; sorts lexicographically in time order
+ */
+const keyTimeLayout = "20060102T150405.000000000Z"
+
+/**
+ * Suffix of backup keys
+ */
+const keySuffix = ".db"
+
+/**
+ * An object in an ObjectStore
+ */
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+/**
+ * Minimal interface of an object store (S3 and the like) backups are uploaded to
+ *  - Put must consume r as a stream, and must not make an object visible (to List)
+ *    unless it has been uploaded completely
+ *  - List returns objects whose keys start with prefix
+ */
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+/**
+ * Uploads a backup of the DB to '<prefix>notes-<timestamp>.db' and prunes backups beyond
+ * the newest `keep` ones (keep <= 0 keeps everything)
+ *  - the backup is streamed into the store as it is being taken (never buffered as a whole)
+ *  - pruning happens only after a successful upload, and only considers complete backups,
+ *    so an interrupted upload never pushes a good backup out
+ * param: context.Context ctx
+ * param: *models.DB      db
+ * param: ObjectStore     store
+ * param: string          prefix
+ * param: int             keep
+ * return: error
+ */
+func BackupRemote(ctx context.Context, db *models.DB, store ObjectStore, prefix string, keep int) error {
+	key := prefix + "notes-" + time.Now().UTC().Format(keyTimeLayout) + keySuffix
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := db.Backup(pw)
+		pw.CloseWithError(err)
+	}()
+	if err := store.Put(ctx, key, pr); err != nil {
+		// unblock the backup if the store gave up before consuming everything
+		pr.CloseWithError(err)
+		return fmt.Errorf("uploading backup '%s': %w", key, err)
+	}
+	pr.Close()
+
+	if keep <= 0 {
+		return nil
+	}
+	return prune(ctx, store, prefix, keep)
+}
+
+/**
+ * Lists backups under prefix, oldest first
+ */
+func ListBackups(ctx context.Context, store ObjectStore, prefix string) ([]ObjectInfo, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []ObjectInfo
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, prefix)
+		if !strings.HasPrefix(name, "notes-") || !strings.HasSuffix(name, keySuffix) {
+			continue
+		}
+		if _, err := time.Parse(keyTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, "notes-"), keySuffix)); err != nil {
+			continue
+		}
+		backups = append(backups, object)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Key < backups[j].Key })
+	return backups, nil
+}
+
+/**
+ * Deletes all but the newest `keep` backups under prefix
+ */
+func prune(ctx context.Context, store ObjectStore, prefix string, keep int) error {
+	backups, err := ListBackups(ctx, store, prefix)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := store.Delete(ctx, backups[0].Key); err != nil {
+			return fmt.Errorf("pruning backup '%s': %w", backups[0].Key, err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func newTestStore(t *testing.T) (*FSStore, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "backups")
+	store, err := NewFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func TestBackupRemoteRestores(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"first", "second"}}})
+	store, dir := newTestStore(t)
+
+	if err := BackupRemote(context.Background(), db, store, "daily/", 0); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackups(context.Background(), store, "daily/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("%d backups, want 1", len(backups))
+	}
+
+	restored, err := models.GetOrCreateDB(filepath.Join(dir, filepath.FromSlash(backups[0].Key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	notes, err := restored.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Content != "first" || notes[1].Content != "second" {
+		t.Errorf("restored backup has notes %+v, want 'first' and 'second'", notes)
+	}
+}
+
+func TestBackupRemoteRetention(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.MustAdd(t, db, "work", "content")
+	store, _ := newTestStore(t)
+	// objects which aren't backups, or are under another prefix, are left alone
+	for _, key := range []string{"daily/README", "weekly/notes-20200101T000000.000000000Z.db"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("other")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		if err := BackupRemote(context.Background(), db, store, "daily/", 3); err != nil {
+			t.Fatal(err)
+		}
+		backups, err := ListBackups(context.Background(), store, "daily/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, backups[len(backups)-1].Key)
+	}
+
+	backups, err := ListBackups(context.Background(), store, "daily/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("%d backups kept, want 3", len(backups))
+	}
+	for i, backup := range backups {
+		if backup.Key != keys[2+i] {
+			t.Errorf("backup %d kept = '%s', want the newest ones %v", i, backup.Key, keys[2:])
+		}
+	}
+	objects, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 5 {
+		t.Errorf("%d objects in store, want 3 backups and 2 other objects: %+v", len(objects), objects)
+	}
+}
+
+/**
+ * ObjectStore failing uploads after consuming `after` bytes of them
+ */
+type failingStore struct {
+	*FSStore
+	after int64
+}
+
+var errUploadInterrupted = errors.New("upload interrupted")
+
+func (s *failingStore) Put(ctx context.Context, key string, r io.Reader) error {
+	return s.FSStore.Put(ctx, key, io.MultiReader(io.LimitReader(r, s.after), &errReader{errUploadInterrupted}))
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestInterruptedUploadDoesNotCount(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.MustAdd(t, db, "work", "content")
+	store, dir := newTestStore(t)
+	for i := 0; i < 2; i++ {
+		if err := BackupRemote(context.Background(), db, store, "", 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	good, err := ListBackups(context.Background(), store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := &failingStore{FSStore: store, after: 4096}
+	if err := BackupRemote(context.Background(), db, failing, "", 2); !errors.Is(err, errUploadInterrupted) {
+		t.Fatalf("interrupted backup: %v, want errUploadInterrupted", err)
+	}
+	backups, err := ListBackups(context.Background(), store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Key != good[0].Key || backups[1].Key != good[1].Key {
+		t.Errorf("backups after an interrupted upload = %+v, want the good ones %+v", backups, good)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), partialPrefix) {
+			t.Errorf("partial upload '%s' left behind", entry.Name())
+		}
+	}
+}
+
+func TestFSStorePutHonoursContext(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.Put(ctx, "notes-cancelled.db", strings.NewReader("content")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Put with a cancelled context: %v, want context.Canceled", err)
+	}
+	objects, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("cancelled upload listed: %+v", objects)
+	}
+	// deleting what isn't there is fine
+	if err := store.Delete(context.Background(), "notes-cancelled.db"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+//go:build s3
+// +build s3
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Size of the parts uploads are split into (S3 requires at least 5MB for all but the last part);
+ * memory use of an upload is bounded by one part
+ */
+const s3PartSize = 8 << 20
+
+/**
+ * Hash of an empty payload, as used in signatures
+ */
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+/**
+ * Settings of an S3 (or S3-compatible, like MinIO) bucket
+ *  - Endpoint is like 'https://s3.eu-west-1.amazonaws.com' or 'http://localhost:9000'
+ *  - PathStyle addresses the bucket as '<endpoint>/<bucket>' rather than '<bucket>.<endpoint host>'
+ *    (needed by most S3-compatible servers)
+ */
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+/**
+ * ObjectStore backed by an S3 bucket (built with the 's3' build tag)
+ * Requests are signed with AWS Signature Version 4; no SDK is needed
+ */
+type S3Store struct {
+	config S3Config
+	client *http.Client
+}
+
+/**
+ * <Constructor for S3Store>
+ */
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if _, err := url.Parse(config.Endpoint); err != nil {
+		return nil, err
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3Store{config: config, client: http.DefaultClient}, nil
+}
+
+/**
+ * Uploads r in parts of s3PartSize (a single PUT when it fits in one part)
+ * An interrupted multipart upload is aborted, and never becomes a visible object either way
+ */
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err := s.do(ctx, http.MethodPut, key, nil, part[:n])
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var initiated struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return err
+	}
+	uploadQuery := url.Values{"uploadId": {initiated.UploadId}}
+	abort := func(err error) error {
+		// best-effort: the upload is invisible either way, aborting just frees its parts
+		s.do(context.Background(), http.MethodDelete, key, uploadQuery, nil)
+		return err
+	}
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {initiated.UploadId}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, part[:n])
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		n, err = io.ReadFull(r, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return abort(err)
+	}
+	if _, err := s.do(ctx, http.MethodPost, key, uploadQuery, complete); err != nil {
+		return abort(err)
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := s.doXML(ctx, http.MethodGet, "", query, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	return err
+}
+
+/**
+ * Performs a request and decodes its XML response into v
+ */
+func (s *S3Store) doXML(ctx context.Context, method, key string, query url.Values, body []byte, v interface{}) error {
+	resp, err := s.do(ctx, method, key, query, body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(resp.body, v)
+}
+
+type s3Response struct {
+	Header http.Header
+	body   []byte
+}
+
+/**
+ * Performs a signed request against the bucket; non-2xx responses become errors
+ */
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (s3Response, error) {
+	endpoint, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return s3Response{}, err
+	}
+	u := *endpoint
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + endpoint.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return s3Response{}, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	s.sign(req, u, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return s3Response{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return s3Response{}, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return s3Response{}, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return s3Response{Header: resp.Header, body: respBody}, nil
+}
+
+/**
+ * Signs a request with AWS Signature Version 4 (headers host, x-amz-content-sha256 and x-amz-date)
+ */
+func (s *S3Store) sign(req *http.Request, u url.URL, body []byte, now time.Time) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + u.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method, u.RawPath, u.RawQuery, canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/**
+ * URI-encodes every byte except unreserved characters (RFC 3986), as signatures require
+ */
+func uriEncode(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func escapePath(path string) string {
+	return uriEncode(path, true)
+}
+
+/**
+ * Query string with keys sorted and keys / values URI-encoded (as signatures require)
+ */
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
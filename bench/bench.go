@@ -0,0 +1,444 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Load generator measuring how notes performs on given hardware
+ *  - a run fills a fresh DB with synthetic notebooks (Notebooks × NotesPerNotebook notes of about NoteSize
+ *    bytes of words), then times workloads against it: adding (the fill itself), point reads, listings,
+ *    full listings, searches and a mix of reads and updates, each by Concurrency goroutines
+ *  - searches of all notebooks (SearchAll of them) run one at a time, first reading notebooks one after the
+ *    other ("search-all"), then fanning out over ReadWorkers workers ("search-all-fanout", see models.SetReadWorkers),
+ *    so as to tell what fanning out gains on given hardware
+ *  - full listings also tell how long their read transactions were held (through the slow-op log, see
+ *    models.SlowOps), which DecodeBuffer trades against memory (see models.SetDecodeBuffer)
+ *  - only public APIs of models are used, so that workloads go through the same code paths as the CLI and API
+ *  - workloads are generated up front from Seed (the same seed giving the same notes and operations), so that
+ *    runs are comparable; timings of course aren't reproducible
+ *  - the DB is removed once done, unless Keep is set (Report.Path then tells where it is)
+ */
+
+// returned by Run for configs it can't run
+var ErrInvalidConfig = errors.New("invalid benchmark config")
+
+// returned by Run when Path names a file that exists (benchmarks never run against existing DBs)
+var ErrDBExists = errors.New("benchmark DB already exists")
+
+/**
+ * What to run (see above)
+ *  - Path is the DB file created for the run (a temporary one if empty); it must not exist
+ *  - notes are added AddBatch at a time (one transaction per batch)
+ *  - Ops is the number of operations of each workload but adding; WriteRatio the share of updates in the mix
+ *  - listings return the ListLimit most recently updated notes of a notebook; full listings (ListAll of them)
+ *    all notes of a notebook
+ *  - DecodeBuffer is passed to models.SetDecodeBuffer (-1 decoding listed notes within read transactions)
+ *  - ReadWorkers is passed to models.SetReadWorkers for searches fanning out (0 for the default)
+ */
+type Config struct {
+	Notebooks        int     `json:"notebooks"`
+	NotesPerNotebook int     `json:"notes_per_notebook"`
+	NoteSize         int     `json:"note_size"`
+	AddBatch         int     `json:"add_batch"`
+	Concurrency      int     `json:"concurrency"`
+	Ops              int     `json:"ops"`
+	WriteRatio       float64 `json:"write_ratio"`
+	ListLimit        int     `json:"list_limit"`
+	ListAll          int     `json:"list_all"`
+	DecodeBuffer     int64   `json:"decode_buffer,omitempty"`
+	SearchAll        int     `json:"search_all"`
+	ReadWorkers      int     `json:"read_workers,omitempty"`
+	Seed             int64   `json:"seed"`
+	Path             string  `json:"path,omitempty"`
+	Keep             bool    `json:"keep,omitempty"`
+}
+
+/**
+ * Config of a small run, taking seconds
+ * return: Config
+ */
+func DefaultConfig() Config {
+	return Config{Notebooks: 4, NotesPerNotebook: 1000, NoteSize: 512, AddBatch: 100, Concurrency: 4, Ops: 1000,
+		WriteRatio: 0.2, ListLimit: 50, ListAll: 10, SearchAll: 20, Seed: 1}
+}
+
+/**
+ * Timings of a workload
+ *  - Ops counts notes for adding, whose latencies are those of batches (see Config.AddBatch)
+ *  - failed operations are counted in Errors, and timed all the same
+ *  - TxHeldP50 / TxHeldMax are set for full listings only: how long their read transactions were held
+ */
+type Result struct {
+	Name      string        `json:"name"`
+	Ops       int           `json:"ops"`
+	Errors    int           `json:"errors"`
+	Duration  time.Duration `json:"duration_ns"`
+	OpsPerSec float64       `json:"ops_per_sec"`
+	P50       time.Duration `json:"p50_ns"`
+	P95       time.Duration `json:"p95_ns"`
+	P99       time.Duration `json:"p99_ns"`
+	Max       time.Duration `json:"max_ns"`
+	TxHeldP50 time.Duration `json:"tx_held_p50_ns,omitempty"`
+	TxHeldMax time.Duration `json:"tx_held_max_ns,omitempty"`
+}
+
+/**
+ * Outcome of a run: its workloads in the order they ran, and size of the DB file at the end
+ *  - Path is set only if the DB was kept
+ */
+type Report struct {
+	Config   Config   `json:"config"`
+	Results  []Result `json:"results"`
+	FileSize int64    `json:"file_size"`
+	Path     string   `json:"path,omitempty"`
+}
+
+// number of full listings whose transactions the slow-op log keeps (in memory) at least
+const maxListAll = 200
+
+// words content and search queries are made of
+var vocabulary = strings.Fields(`alpha budget client deadline draft estimate follow invoice kickoff ledger meeting
+	milestone notes outline plan priority project proposal quarter recap release review roadmap schedule sprint
+	summary task team timeline todo update vendor weekly agenda backlog design feedback hiring launch metrics`)
+
+/**
+ * Runs a benchmark (see above)
+ * Fails with ErrInvalidConfig for configs without notes or goroutines, and with ErrDBExists if Path exists;
+ * failures of single operations are counted rather than failing the run
+ * param: Config cfg
+ * return: (Report, error)
+ */
+func Run(cfg Config) (Report, error) {
+	report := Report{Config: cfg}
+	if err := checkConfig(cfg); err != nil {
+		return report, err
+	}
+	db, cleanup, err := openDB(cfg)
+	if err != nil {
+		return report, err
+	}
+	defer cleanup()
+	db.SetDecodeBuffer(cfg.DecodeBuffer)
+
+	w := newWorkload(cfg)
+	report.Results = append(report.Results, w.add(db))
+	for _, run := range []struct {
+		name string
+		ops  []func() error
+	}{
+		{"read", w.reads(db)},
+		{"list", w.listings(db)},
+	} {
+		report.Results = append(report.Results, timed(run.name, run.ops, cfg.Concurrency))
+	}
+	report.Results = append(report.Results, w.listAll(db))
+	for _, run := range []struct {
+		name string
+		ops  []func() error
+	}{
+		{"search", w.searches(db)},
+		{"mixed", w.mixed(db)},
+	} {
+		report.Results = append(report.Results, timed(run.name, run.ops, cfg.Concurrency))
+	}
+	report.Results = append(report.Results, w.searchAll(db)...)
+
+	info, err := os.Stat(db.Path())
+	if err != nil {
+		return report, err
+	}
+	report.FileSize = info.Size()
+	if cfg.Keep {
+		report.Path = db.Path()
+	}
+	return report, nil
+}
+
+func checkConfig(cfg Config) error {
+	switch {
+	case cfg.Notebooks <= 0 || cfg.NotesPerNotebook <= 0:
+		return fmt.Errorf("%w: no notes to add", ErrInvalidConfig)
+	case cfg.Concurrency <= 0:
+		return fmt.Errorf("%w: concurrency must be positive", ErrInvalidConfig)
+	case cfg.AddBatch <= 0:
+		return fmt.Errorf("%w: batches must hold notes", ErrInvalidConfig)
+	case cfg.Ops < 0 || cfg.NoteSize < 0 || cfg.ListLimit < 0 || cfg.ListAll < 0 || cfg.SearchAll < 0:
+		return fmt.Errorf("%w: negative ops, note size, listings or searches", ErrInvalidConfig)
+	case cfg.ReadWorkers < 0:
+		return fmt.Errorf("%w: read workers can't be negative", ErrInvalidConfig)
+	case cfg.ListAll > maxListAll:
+		return fmt.Errorf("%w: at most %d full listings", ErrInvalidConfig, maxListAll)
+	case cfg.WriteRatio < 0 || cfg.WriteRatio > 1:
+		return fmt.Errorf("%w: write ratio must be between 0 and 1", ErrInvalidConfig)
+	}
+	return nil
+}
+
+/**
+ * Opens the DB of a run, along with the func closing it (and removing it unless it's kept)
+ */
+func openDB(cfg Config) (*models.DB, func(), error) {
+	if cfg.Path == "" {
+		db, cleanup, err := models.OpenTemp()
+		if err != nil || !cfg.Keep {
+			return db, cleanup, err
+		}
+		return db, func() { db.Close() }, nil
+	}
+	if _, err := os.Stat(cfg.Path); err == nil {
+		return nil, nil, fmt.Errorf("%w: '%s'", ErrDBExists, cfg.Path)
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	db, err := models.GetOrCreateDB(cfg.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	return db, func() {
+		once.Do(func() {
+			db.Close()
+			if !cfg.Keep {
+				os.Remove(cfg.Path)
+			}
+		})
+	}, nil
+}
+
+/**
+ * Notes and operations of a run, generated from its seed
+ */
+type workload struct {
+	cfg       Config
+	rng       *rand.Rand
+	notebooks []string
+}
+
+func newWorkload(cfg Config) *workload {
+	w := &workload{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+	for i := 0; i < cfg.Notebooks; i++ {
+		w.notebooks = append(w.notebooks, fmt.Sprintf("bench-%d", i))
+	}
+	return w
+}
+
+/**
+ * Fills the notebooks, batch by batch; notes of a notebook get ids 1 to NotesPerNotebook
+ */
+func (w *workload) add(db *models.DB) Result {
+	var ops []func() error
+	var sizes []int
+	for _, notebook := range w.notebooks {
+		for added := 0; added < w.cfg.NotesPerNotebook; added += w.cfg.AddBatch {
+			batch := make([]string, 0, w.cfg.AddBatch)
+			for i := added; i < added+w.cfg.AddBatch && i < w.cfg.NotesPerNotebook; i++ {
+				batch = append(batch, w.content())
+			}
+			notebook := notebook
+			ops = append(ops, func() error { return db.AddNotes(notebook, batch...) })
+			sizes = append(sizes, len(batch))
+		}
+	}
+	result := timed("add", ops, w.cfg.Concurrency)
+	result.Ops = w.cfg.Notebooks * w.cfg.NotesPerNotebook
+	result.OpsPerSec = float64(result.Ops) / result.Duration.Seconds()
+	return result
+}
+
+func (w *workload) reads(db *models.DB) []func() error {
+	ops := make([]func() error, w.cfg.Ops)
+	for i := range ops {
+		notebook, noteId := w.note()
+		ops[i] = func() error {
+			_, err := db.GetNote(notebook, noteId)
+			return err
+		}
+	}
+	return ops
+}
+
+func (w *workload) listings(db *models.DB) []func() error {
+	ops := make([]func() error, w.cfg.Ops)
+	for i := range ops {
+		notebook := w.notebooks[w.rng.Intn(len(w.notebooks))]
+		ops[i] = func() error {
+			_, _, err := db.Query(notebook).SortBy(models.UpdatedAtDesc).Limit(w.cfg.ListLimit).Execute()
+			return err
+		}
+	}
+	return ops
+}
+
+/**
+ * Lists whole notebooks, with every transaction recorded in the slow-op log, so as to tell how long
+ * listings held theirs
+ */
+func (w *workload) listAll(db *models.DB) Result {
+	ops := make([]func() error, w.cfg.ListAll)
+	for i := range ops {
+		notebook := w.notebooks[w.rng.Intn(len(w.notebooks))]
+		ops[i] = func() error {
+			_, err := db.ListNotes(notebook)
+			return err
+		}
+	}
+	db.SetSlowOpThreshold(time.Nanosecond)
+	result := timed("list-all", ops, w.cfg.Concurrency)
+	db.SetSlowOpThreshold(0)
+
+	var held []time.Duration
+	for _, op := range db.SlowOps(0) {
+		if op.Operation == "DB.ListNotes" && !op.Write {
+			held = append(held, op.Duration)
+		}
+	}
+	if len(held) > 0 {
+		sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+		result.TxHeldP50, result.TxHeldMax = percentile(held, 50), held[len(held)-1]
+	}
+	return result
+}
+
+func (w *workload) searches(db *models.DB) []func() error {
+	ops := make([]func() error, w.cfg.Ops)
+	for i := range ops {
+		notebook, query := w.notebooks[w.rng.Intn(len(w.notebooks))], vocabulary[w.rng.Intn(len(vocabulary))]
+		ops[i] = func() error {
+			_, err := db.SearchNotes(notebook, query)
+			return err
+		}
+	}
+	return ops
+}
+
+/**
+ * Searches all notebooks one at a time, reading notebooks one after the other and then fanning out, with
+ * the same queries
+ */
+func (w *workload) searchAll(db *models.DB) []Result {
+	queries := make([]string, w.cfg.SearchAll)
+	for i := range queries {
+		queries[i] = vocabulary[w.rng.Intn(len(vocabulary))]
+	}
+	var results []Result
+	for _, run := range []struct {
+		name    string
+		workers int
+	}{
+		{"search-all", 1},
+		{"search-all-fanout", w.cfg.ReadWorkers},
+	} {
+		ops := make([]func() error, len(queries))
+		for i, query := range queries {
+			query := query
+			ops[i] = func() error {
+				_, err := db.SearchAllNotebooks(query)
+				return err
+			}
+		}
+		db.SetReadWorkers(run.workers)
+		results = append(results, timed(run.name, ops, 1))
+	}
+	db.SetReadWorkers(0)
+	return results
+}
+
+func (w *workload) mixed(db *models.DB) []func() error {
+	ops := make([]func() error, w.cfg.Ops)
+	for i := range ops {
+		notebook, noteId := w.note()
+		if w.rng.Float64() < w.cfg.WriteRatio {
+			content := w.content()
+			ops[i] = func() error {
+				_, err := db.UpdateNote(notebook, noteId, content)
+				return err
+			}
+			continue
+		}
+		ops[i] = func() error {
+			_, err := db.GetNote(notebook, noteId)
+			return err
+		}
+	}
+	return ops
+}
+
+/**
+ * A random note of the notebooks
+ */
+func (w *workload) note() (string, uint64) {
+	return w.notebooks[w.rng.Intn(len(w.notebooks))], uint64(w.rng.Intn(w.cfg.NotesPerNotebook)) + 1
+}
+
+/**
+ * Random content of about NoteSize bytes: a title line, then lines of words
+ */
+func (w *workload) content() string {
+	var sb strings.Builder
+	sb.WriteString("# " + vocabulary[w.rng.Intn(len(vocabulary))] + "\n")
+	for words := 1; sb.Len() < w.cfg.NoteSize; words++ {
+		sb.WriteString(vocabulary[w.rng.Intn(len(vocabulary))])
+		if words%12 == 0 {
+			sb.WriteByte('\n')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+/**
+ * Runs operations by given number of goroutines (each taking the next operation not run yet), timing them
+ */
+func timed(name string, ops []func() error, concurrency int) Result {
+	latencies := make([]time.Duration, len(ops))
+	var next, errs int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := atomic.AddInt64(&next, 1) - 1; i < int64(len(ops)); i = atomic.AddInt64(&next, 1) - 1 {
+				opStart := time.Now()
+				if ops[i]() != nil {
+					atomic.AddInt64(&errs, 1)
+				}
+				latencies[i] = time.Since(opStart)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := Result{Name: name, Ops: len(ops), Errors: int(errs), Duration: time.Since(start)}
+	if len(ops) == 0 {
+		return result
+	}
+	result.OpsPerSec = float64(len(ops)) / result.Duration.Seconds()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P50, result.P95, result.P99 = percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99)
+	result.Max = latencies[len(latencies)-1]
+	return result
+}
+
+/**
+ * Nearest-rank percentile of sorted latencies
+ */
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
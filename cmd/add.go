@@ -1,8 +1,18 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
 	"github.com/spf13/cobra"
 	"gopkg.in/kyokomi/emoji.v1"
 )
@@ -11,8 +21,17 @@ var addCommand = &cobra.Command{
 	Use:   "add",
 	Short: "Adds notes",
 	Long: "Adds notes to a notebook from the terminal. Use `notes add \"text\"` to jot in the default notebook or" +
-		"`notes add NotebookName \"text-1\" \"text-2\" ..` to add notes to other notebooks",
+		"`notes add NotebookName \"text-1\" \"text-2\" ..` to add notes to other notebooks. " +
+		"`notes add NotebookName -` adds a note read from stdin (like `pbpaste | notes add inbox -`), " +
+		"`notes add NotebookName --file path.md` one read from a file",
 	Run: func(cmd *cobra.Command, args []string) {
+		if addFile != "" || (len(args) > 0 && args[len(args)-1] == "-") {
+			notebookName, content, ok := captureNote(args)
+			if !ok {
+				return
+			}
+			args = []string{notebookName, content}
+		}
 		db := setupDatabase()
 		var err error
 
@@ -20,19 +39,130 @@ var addCommand = &cobra.Command{
 		case 0:
 			emoji.Println(" :warning: You need to add some text")
 		case 1:
-			err = db.AddNotes("Default", args[0])
-			emoji.Println(" :pencil2: Note added to 'Default' Notebook")
+			defaultNotebook := loadConfig().DefaultNotebook
+			if err = addNotes(db, defaultNotebook, args[0]); err == nil {
+				emoji.Println(fmt.Sprintf(" :pencil2: Note added to '%s' Notebook", defaultNotebook))
+			}
 		default:
-			err = db.AddNotes(args[0], args[1:]...)
-			emoji.Println(" :pencil2: Note(s) added")
+			if err = addNotes(db, args[0], args[1:]...); err == nil {
+				emoji.Println(" :pencil2: Note(s) added")
+			}
 		}
-		if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnknownKind), errors.Is(err, models.ErrContentMismatch),
+			errors.Is(err, models.ErrNotebookArchived), errors.Is(err, utils.ErrInvalidTime), errors.Is(err, models.ErrTitleTaken):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		case err != nil:
 			log.Panic()
 		}
 
 	},
 }
 
+var (
+	// when added notes expire: a duration from now or a time (never if empty)
+	addTTL string
+	// kind of added notes ("text" if empty)
+	addKind string
+	// file the note is read from (none if empty)
+	addFile string
+)
+
+/**
+ * Reads the content of a note to add from stdin (`notes add [notebook] -`) or a file (`--file`)
+ * Warns (returning false) if arguments don't add up or the input can't be read
+ * return: (string, string, bool) Notebook and content of the note
+ */
+func captureNote(args []string) (string, string, bool) {
+	notebookArgs := args
+	if len(args) > 0 && args[len(args)-1] == "-" {
+		notebookArgs = args[:len(args)-1]
+	}
+	if len(notebookArgs) > 1 || (addFile != "" && len(notebookArgs) != len(args)) {
+		emoji.Println(" :warning: Give the notebook followed by either `-` (to read stdin) or `--file`, and no text")
+		return "", "", false
+	}
+	cfg := loadConfig()
+	notebookName := cfg.DefaultNotebook
+	if len(notebookArgs) == 1 {
+		notebookName = notebookArgs[0]
+	}
+
+	var r io.Reader = os.Stdin
+	if addFile != "" {
+		file, err := os.Open(addFile)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return "", "", false
+		}
+		defer file.Close()
+		r = file
+	}
+	content, err := readCapture(r, cfg.MaxNoteSize)
+	switch {
+	case err != nil:
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		return "", "", false
+	case content == "":
+		emoji.Println(" :warning: You need to add some text (the input is empty)")
+		return "", "", false
+	}
+	return notebookName, content, true
+}
+
+/**
+ * Reads captured content, failing if it's larger than maxSize bytes (no limit if not positive) or
+ * isn't valid UTF-8 (which notes can't hold byte for byte)
+ * Content is kept byte for byte, except for a single trailing newline ('\n' or '\r\n'), which is dropped
+ */
+func readCapture(r io.Reader, maxSize int64) (string, error) {
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if maxSize > 0 && int64(len(content)) > maxSize {
+		return "", fmt.Errorf("input is larger than %d bytes (raise max_note_size in the config file to add it)", maxSize)
+	}
+	if !utf8.Valid(content) {
+		return "", errors.New("input isn't UTF-8 text")
+	}
+	text := string(content)
+	if strings.HasSuffix(text, "\n") {
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	}
+	return text, nil
+}
+
+/**
+ * Adds notes to given notebook, setting their expiry if '--ttl' flag was supplied
+ * and their kind if '--kind' was
+ */
+func addNotes(db models.Datastore, notebookName string, noteContents ...string) error {
+	if addTTL == "" && addKind == "" {
+		return db.AddNotes(notebookName, noteContents...)
+	}
+	var expiresAt *time.Time
+	if addTTL != "" {
+		expiry, err := utils.ParseWhen(addTTL, time.Now(), time.Local)
+		if err != nil {
+			return err
+		}
+		expiresAt = &expiry
+	}
+	for _, noteContent := range noteContents {
+		if _, err := db.AddNote(notebookName, models.Note{Content: noteContent, ExpiresAt: expiresAt, Kind: addKind}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
+	addCommand.Flags().StringVar(&addTTL, "ttl", "", "expire the notes after given duration or at given time (like 2h, 3d or 'tomorrow 9am')")
+	addCommand.Flags().StringVar(&addKind, "kind", "", "kind of the notes: 'text' (default), 'markdown' or 'json'")
+	addCommand.Flags().StringVar(&addFile, "file", "", "add a note read from this file")
 	root.AddCommand(addCommand)
 }
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Points $HOME at a fresh directory holding given config file (none if empty) for the length of a test,
+ * returning the path of a DB in it
+ */
+func fakeHome(t *testing.T, config string) string {
+	t.Helper()
+	home := t.TempDir()
+	previous, had := os.LookupEnv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv("HOME", previous)
+		} else {
+			os.Unsetenv("HOME")
+		}
+	})
+	if config != "" {
+		dir := filepath.Join(home, ".config", "notes")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(home, "notes.db")
+}
+
+/**
+ * Runs `notes --db path args..` with stdin piped from given input, returning what it prints on stdout
+ */
+func runNotes(t *testing.T, path string, stdin []byte, args ...string) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(stdin)
+		w.Close()
+	}()
+	previous := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = previous
+		r.Close()
+		// (flags keep their values from run to run)
+		addFile, addKind, addTTL = "", "", ""
+		initEncryption = false
+	}()
+	root.SetArgs(append([]string{"--db", path}, args...))
+	return captureStdout(t, func() {
+		if err := root.Execute(); err != nil {
+			t.Fatalf("notes %s: %v", strings.Join(args, " "), err)
+		}
+	})
+}
+
+func TestAddFromStdinAndCat(t *testing.T) {
+	path := fakeHome(t, "")
+	for _, test := range []struct {
+		name    string
+		input   string
+		content string
+	}{
+		{"single trailing newline dropped", "hello\n", "hello"},
+		{"only one newline dropped", "hello\n\n", "hello\n"},
+		{"CRLF dropped", "line one\r\nline two\r\n", "line one\r\nline two"},
+		{"no trailing newline", "as is", "as is"},
+		{"UTF-8 kept exactly", "café ☕ \U0001f4dd\n  ​\n", "café ☕ \U0001f4dd\n  ​"},
+		{"binary-ish", "nul\x00byte\x01\x1b[31mred\x1b[0m\x7f\ttab\n", "nul\x00byte\x01\x1b[31mred\x1b[0m\x7f\ttab"},
+		{"leading and inner whitespace kept", "\n  indented\n\n", "\n  indented\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			notebookName := strings.ReplaceAll(test.name, " ", "-")
+			runNotes(t, path, []byte(test.input), "add", notebookName, "-")
+			out := runNotes(t, path, nil, "cat", notebookName, "1")
+			if want := test.content + "\n"; string(out) != want {
+				t.Errorf("cat printed %q, want %q", out, want)
+			}
+			// `notes cat | notes add -` copies the note exactly
+			runNotes(t, path, out, "add", notebookName, "-")
+			if copied := runNotes(t, path, nil, "cat", notebookName, "2"); !bytes.Equal(copied, out) {
+				t.Errorf("copy printed %q, want %q", copied, out)
+			}
+		})
+	}
+}
+
+func TestAddFromFile(t *testing.T) {
+	path := fakeHome(t, "")
+	file := filepath.Join(t.TempDir(), "note.md")
+	if err := ioutil.WriteFile(file, []byte("# From a file\n\nwith\x00binary\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	runNotes(t, path, nil, "add", "inbox", "--file", file)
+	if out := runNotes(t, path, nil, "cat", "inbox", "1"); string(out) != "# From a file\n\nwith\x00binary\n" {
+		t.Errorf("cat printed %q", out)
+	}
+}
+
+/**
+ * Contents of notes of a notebook of the DB at path (opened and closed again)
+ */
+func contentsIn(t *testing.T, path string, notebookName string) []string {
+	t.Helper()
+	db, err := models.GetOrCreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	notes, err := db.ListNotes(notebookName)
+	if err != nil && !errors.Is(err, models.ErrNotebookNotFound) {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, note := range notes {
+		contents = append(contents, note.Content)
+	}
+	return contents
+}
+
+func TestAddRefusesCaptures(t *testing.T) {
+	path := fakeHome(t, "max_note_size = 64\n")
+	for _, test := range []struct {
+		name    string
+		input   []byte
+		warning string
+	}{
+		{"too large", bytes.Repeat([]byte("x"), 65), "input is larger than 64 bytes"},
+		{"invalid UTF-8", []byte("caf\xe9\n"), "input isn't UTF-8 text"},
+		{"empty", []byte("\n"), "the input is empty"},
+	} {
+		if out := runNotes(t, path, test.input, "add", "inbox", "-"); !strings.Contains(string(out), test.warning) {
+			t.Errorf("%s: printed %q, want a warning that %s", test.name, out, test.warning)
+		}
+	}
+	if contents := contentsIn(t, path, "inbox"); len(contents) != 0 {
+		t.Errorf("refused captures added %q", contents)
+	}
+
+	// up to the limit is fine
+	runNotes(t, path, bytes.Repeat([]byte("y"), 64), "add", "inbox", "-")
+	if contents := contentsIn(t, path, "inbox"); len(contents) != 1 || len(contents[0]) != 64 {
+		t.Errorf("notebook holds %q, want the note of 64 bytes", contents)
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var archiveCommand = &cobra.Command{
+	Use:   "archive <notebook>",
+	Short: "Archive a notebook",
+	Long: "Archives a notebook, like `notes archive 2019`: its notes can still be read, but not changed, and it's " +
+		"left out of `notes ls` and searches across notebooks (see `--archived` of `notes ls`)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setArchived(args[0], true)
+	},
+}
+
+var unarchiveCommand = &cobra.Command{
+	Use:   "unarchive <notebook>",
+	Short: "Unarchive a notebook",
+	Long:  "Makes an archived notebook writable and listed again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setArchived(args[0], false)
+	},
+}
+
+/**
+ * Archives or unarchives given notebook
+ */
+func setArchived(notebookName string, archived bool) {
+	db := setupDatabase()
+
+	set, state := db.UnarchiveNotebook, "unarchived"
+	if archived {
+		set, state = db.ArchiveNotebook, "archived"
+	}
+	switch err := set(notebookName); {
+	case err == nil:
+		emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' %s", notebookName, state))
+	case errors.Is(err, models.ErrNotebookNotFound):
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+	default:
+		log.Panic(err)
+	}
+}
+
+func init() {
+	root.AddCommand(archiveCommand)
+	root.AddCommand(unarchiveCommand)
+}
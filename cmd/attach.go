@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var attachCommand = &cobra.Command{
+	Use:   "attach <notebook> <noteId> <file>",
+	Short: "Attach a file to a note",
+	Long: "Attaches a file to a note under the file's name (or `--name`), like `notes attach work 3 logo.png`. " +
+		"Files attached to several notes are stored only once; files are capped at max_attachment_size of the config (64 MiB by default)",
+	Args: noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		file, err := os.Open(args[2])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		defer file.Close()
+		name := attachName
+		if name == "" {
+			name = filepath.Base(args[2])
+		}
+		db := setupDatabase()
+
+		switch attachment, err := db.AddAttachment(args[0], noteId, name, file); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Attached '%s' (%d bytes) to note with id '%d'", attachment.Name, attachment.Size, noteId))
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNoteReadOnly), errors.Is(err, models.ErrNotebookArchived),
+			errors.Is(err, models.ErrAttachmentTooLarge):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var attachmentsCommand = &cobra.Command{
+	Use:   "attachments <notebook> <noteId>",
+	Short: "List attachments of a note",
+	Args:  noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		attachments, err := db.ListAttachments(args[0], noteId)
+		switch {
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil:
+			log.Panic(err)
+		}
+		if len(attachments) == 0 {
+			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' has no attachments", noteId))
+			return
+		}
+		for _, attachment := range attachments {
+			fmt.Printf(" %s\t%d bytes\n", attachment.Name, attachment.Size)
+		}
+	},
+}
+
+var detachCommand = &cobra.Command{
+	Use:   "detach <notebook> <noteId> <name>",
+	Short: "Remove an attachment from a note",
+	Args:  noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.DeleteAttachment(args[0], noteId, args[2]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Removed '%s' from note with id '%d'", args[2], noteId))
+		case errors.Is(err, models.ErrAttachmentNotFound), errors.Is(err, models.ErrNoteReadOnly),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// name the file is attached under (file's base name if empty)
+	attachName string
+)
+
+func init() {
+	attachCommand.Flags().StringVar(&attachName, "name", "", "name of the attachment (defaults to the file's name)")
+	root.AddCommand(attachCommand)
+	root.AddCommand(attachmentsCommand)
+	root.AddCommand(detachCommand)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var batchCommand = &cobra.Command{
+	Use:   "batch",
+	Short: "Run a batch plan of bulk changes",
+	Long: "Runs the steps of a JSON batch plan in order, like `notes batch --plan cleanup.json --dry-run`; every step " +
+		"is a query over a notebook (`notebook`, `query` with the fields of `notes ls` filters) and an `action`: `tag` " +
+		"(`add_tags`, `remove_tags`), `move` (`target`), `archive` or `trash`. Later steps see what earlier ones did. " +
+		"`--dry-run` tells exactly what would be done, writing nothing; a failing step stops the plan, and what was " +
+		"done up to then is reported note by note",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if batchPlanFile == "" {
+			emoji.Println(" :warning: Give the plan to run with --plan")
+			return
+		}
+		file, err := os.Open(batchPlanFile)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: Can't read plan: %v", err))
+			return
+		}
+		plan, err := models.LoadBatchPlan(file)
+		file.Close()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		var steps []models.BatchStepResult
+		if batchDryRun {
+			var preview models.BatchPreview
+			preview, err = db.PlanBatch(plan)
+			steps = preview.Steps
+		} else {
+			var result models.BatchResult
+			result, err = db.ExecuteBatch(plan)
+			steps = result.Steps
+		}
+		var stepErr *models.BatchStepError
+		if err != nil && !errors.As(err, &stepErr) {
+			log.Panic(err)
+		}
+
+		if batchJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(steps); err != nil {
+				log.Panic(err)
+			}
+		} else {
+			for _, step := range steps {
+				printBatchStep(step, batchDryRun)
+			}
+		}
+		if stepErr != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v; later steps weren't run", stepErr))
+			os.Exit(1)
+		}
+	},
+}
+
+/**
+ * Prints what a step did (or would do), note by note
+ */
+func printBatchStep(step models.BatchStepResult, dryRun bool) {
+	name := step.Name
+	if name == "" {
+		name = string(step.Action)
+	}
+	verb := "changed"
+	if dryRun {
+		verb = "would change"
+	}
+	emoji.Println(fmt.Sprintf(" :pencil2: Step %d (%s): %d matched, %s %d, %d unchanged, %d read-only", step.Step, name,
+		step.Matched, verb, len(step.Changes), len(step.Unchanged), len(step.Locked)))
+	for _, change := range step.Changes {
+		switch {
+		case change.MovedTo != nil:
+			fmt.Printf("   %s -> %s\n", change.Ref, *change.MovedTo)
+		case step.Action == models.BatchTrash:
+			fmt.Printf("   %s trashed\n", change.Ref)
+		default:
+			fmt.Printf("   %s\t%s ->%s\n", change.Ref, formatTags(change.TagsBefore), formatTags(change.TagsAfter))
+		}
+	}
+}
+
+var (
+	// JSON file of the plan to run
+	batchPlanFile string
+	// only tell what the plan would do
+	batchDryRun bool
+	// print what was done as JSON
+	batchJSON bool
+)
+
+func init() {
+	batchCommand.Flags().StringVar(&batchPlanFile, "plan", "", "JSON file of the plan to run")
+	batchCommand.Flags().BoolVar(&batchDryRun, "dry-run", false, "only tell what the plan would do")
+	batchCommand.Flags().BoolVar(&batchJSON, "json", false, "print what was done as JSON")
+	root.AddCommand(batchCommand)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/bench"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var benchCommand = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark notes on this machine",
+	Long: "Fills a throwaway DB with synthetic notebooks, then times adding, reading, listing, searching and a mix " +
+		"of reads and updates, like `notes bench --notebooks 20 --notes 10000 --concurrency 8`. " +
+		"The DB given by `--db` is never touched; the benchmark's own DB is removed afterwards unless `--keep` is given",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := bench.Run(benchConfig)
+		switch {
+		case err == nil:
+		case errors.Is(err, bench.ErrInvalidConfig), errors.Is(err, bench.ErrDBExists):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		default:
+			log.Panic(err)
+		}
+
+		if benchJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				log.Panic(err)
+			}
+			return
+		}
+		fmt.Printf(" %d notebook(s) × %d note(s) of %d bytes, %d goroutine(s), seed %d\n\n", benchConfig.Notebooks,
+			benchConfig.NotesPerNotebook, benchConfig.NoteSize, benchConfig.Concurrency, benchConfig.Seed)
+		fmt.Println(" workload\tops\terrors\tops/s\tp50\tp95\tp99")
+		for _, result := range report.Results {
+			fmt.Printf(" %s\t\t%d\t%d\t%.0f\t%v\t%v\t%v\n", result.Name, result.Ops, result.Errors, result.OpsPerSec,
+				result.P50, result.P95, result.P99)
+		}
+		for _, result := range report.Results {
+			if result.TxHeldMax > 0 {
+				fmt.Printf("\n %s held read transactions for %v (p50), %v (max)\n", result.Name, result.TxHeldP50, result.TxHeldMax)
+			}
+		}
+		fmt.Printf("\n file size: %.1f MiB\n", float64(report.FileSize)/(1<<20))
+		if report.Path != "" {
+			emoji.Println(fmt.Sprintf(" :floppy_disk: DB kept at '%s'", report.Path))
+		}
+	},
+}
+
+var (
+	// what to run (see bench.Config)
+	benchConfig = bench.DefaultConfig()
+	// print the report as JSON
+	benchJSON bool
+)
+
+func init() {
+	flags := benchCommand.Flags()
+	flags.IntVar(&benchConfig.Notebooks, "notebooks", benchConfig.Notebooks, "number of notebooks")
+	flags.IntVar(&benchConfig.NotesPerNotebook, "notes", benchConfig.NotesPerNotebook, "number of notes per notebook")
+	flags.IntVar(&benchConfig.NoteSize, "size", benchConfig.NoteSize, "size of notes, in bytes")
+	flags.IntVar(&benchConfig.AddBatch, "batch", benchConfig.AddBatch, "number of notes added per transaction")
+	flags.IntVar(&benchConfig.Concurrency, "concurrency", benchConfig.Concurrency, "number of goroutines running operations")
+	flags.IntVar(&benchConfig.Ops, "ops", benchConfig.Ops, "number of operations of every workload but adding")
+	flags.Float64Var(&benchConfig.WriteRatio, "write-ratio", benchConfig.WriteRatio, "share of updates in the mixed workload")
+	flags.IntVar(&benchConfig.ListLimit, "list-limit", benchConfig.ListLimit, "number of notes listed at once")
+	flags.IntVar(&benchConfig.ListAll, "list-all", benchConfig.ListAll, "number of listings of whole notebooks")
+	flags.IntVar(&benchConfig.SearchAll, "search-all", benchConfig.SearchAll, "number of searches of all notebooks")
+	flags.IntVar(&benchConfig.ReadWorkers, "read-workers", 0, "number of workers searches of all notebooks fan out over (0 for the default)")
+	flags.Int64Var(&benchConfig.DecodeBuffer, "decode-buffer", 0, "bytes of notes listings copy out of read transactions (-1 for none)")
+	flags.Int64Var(&benchConfig.Seed, "seed", benchConfig.Seed, "seed notes and operations are generated from")
+	flags.StringVar(&benchConfig.Path, "file", "", "file to create the benchmark's DB at (a temporary one by default; must not exist)")
+	flags.BoolVar(&benchConfig.Keep, "keep", false, "keep the benchmark's DB for inspection")
+	flags.BoolVar(&benchJSON, "json", false, "print the report as JSON")
+	root.AddCommand(benchCommand)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var captureCommand = &cobra.Command{
+	Use:   "capture <notebook> [message.eml]",
+	Short: "Add a note made of an email message",
+	Long: "Adds a note made of a raw email message read from a file, or from stdin (like from a mail pipe): " +
+		"the subject becomes its title and the date its creation time; the plain-text body is preferred over HTML. " +
+		"`--tags-header X-Tags` makes tags of a header's comma-separated values, and `--attachments` stores " +
+		"attachments with the note (they're skipped otherwise). Problems with the message are warned about",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := os.Stdin
+		if len(args) == 2 {
+			file, err := os.Open(args[1])
+			if err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: %v", err))
+				return
+			}
+			defer file.Close()
+			input = file
+		}
+		db := setupDatabase()
+
+		opts := models.CaptureOptions{
+			TagsHeader:  captureTagsHeader,
+			Attachments: captureAttachments,
+			OnWarning: func(warning string) {
+				emoji.Fprintln(os.Stderr, fmt.Sprintf(" :warning: %s", warning))
+			},
+		}
+		note, err := db.CaptureMessage(args[0], input, opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' added to notebook '%s'", note.Id, args[0]))
+	},
+}
+
+var (
+	// header whose values become tags of captured notes
+	captureTagsHeader string
+	// whether attachments of captured messages are stored
+	captureAttachments bool
+)
+
+func init() {
+	captureCommand.Flags().StringVar(&captureTagsHeader, "tags-header", "", "make tags of this header's comma-separated values (like X-Tags or Keywords)")
+	captureCommand.Flags().BoolVar(&captureAttachments, "attachments", false, "store attachments of the message with the note")
+	root.AddCommand(captureCommand)
+}
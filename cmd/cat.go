@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var catCommand = &cobra.Command{
+	Use:   "cat <notebook> <noteId>",
+	Short: "Print the content of a note",
+	Long: "Writes the content of a note to stdout as it is, followed by a newline, so that it pipes cleanly, " +
+		"like `notes cat work 3 | wc -w`. `notes cat work 3 | notes add other -` copies the note exactly",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		exists, err := db.NotebookExists(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		var note models.Note
+		if exists {
+			note, err = db.GetNote(args[0], noteId)
+			if errors.Is(err, models.ErrNotebookLocked) {
+				emoji.Fprintln(os.Stderr, fmt.Sprintf(" :warning: Notebook '%s' is encrypted: give its passphrase in $%s", args[0], notebookPassphraseEnvVar))
+				closeDatabase()
+				os.Exit(1)
+			}
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+		if note.Id == 0 {
+			// (on stderr, to keep stdout for content)
+			emoji.Fprintln(os.Stderr, fmt.Sprintf(" :warning: No note with id '%d' in notebook '%s'", noteId, args[0]))
+			closeDatabase()
+			os.Exit(1)
+		}
+		if _, err := os.Stdout.WriteString(note.Content + "\n"); err != nil {
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	root.AddCommand(catCommand)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var checkCommand = &cobra.Command{
+	Use:   "check",
+	Short: "Check the DB for inconsistencies",
+	Long: "Checks that the content of notes stored in chunks and of attachments is complete and intact, " +
+		"and that nothing is left behind by deleted notes. Use `--repair` to fix what can be fixed",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		check := db.CheckIntegrity
+		if checkRepair {
+			check = db.Repair
+		}
+		problems, err := check()
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(problems) == 0 {
+			emoji.Println(" :white_check_mark: No problems found")
+			return
+		}
+		for _, problem := range problems {
+			if problem.Fixed {
+				emoji.Println(fmt.Sprintf(" :pencil2: %s (fixed)", problem))
+			} else {
+				emoji.Println(fmt.Sprintf(" :warning: %s", problem))
+			}
+		}
+	},
+}
+
+var (
+	// fix problems found, where possible
+	checkRepair bool
+)
+
+func init() {
+	checkCommand.Flags().BoolVar(&checkRepair, "repair", false, "fix problems found where possible")
+	root.AddCommand(checkCommand)
+}
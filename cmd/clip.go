@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var clipCommand = &cobra.Command{
+	Use:   "clip <notebook> <url>",
+	Short: "Clip a web page into a note",
+	Long: "Fetches a web page and adds a readable Markdown version of it as a note titled like the page, " +
+		"like `notes clip reading https://example.com/article`; scripts, navigation, headers and footers are left out. " +
+		"Anything but HTML is noted as its URL along with its type and size. Interrupt with Ctrl-C to stop fetching",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		ctx, cancel := context.WithTimeout(context.Background(), clipTimeout)
+		defer cancel()
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		note, err := db.ClipURL(ctx, args[0], args[1], &http.Client{})
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Clipped '%s' as note with id '%d' in notebook '%s'", note.Title(), note.Id, args[0]))
+		case err == context.Canceled:
+			emoji.Println(" :warning: Clipping interrupted")
+		case err == context.DeadlineExceeded:
+			emoji.Println(fmt.Sprintf(" :warning: Page not fetched within %v", clipTimeout))
+		case errors.Is(err, models.ErrInvalidClipURL), errors.Is(err, models.ErrClipTooLarge),
+			errors.Is(err, models.ErrClipFailed), errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// time fetching a page may take
+	clipTimeout time.Duration
+)
+
+func init() {
+	clipCommand.Flags().DurationVar(&clipTimeout, "timeout", 30*time.Second, "time fetching the page may take")
+	root.AddCommand(clipCommand)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var configCommand = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect configuration",
+	Long: "Inspect configuration of notes. Settings are read from `--db` flag, NOTES_DB env var and " +
+		"`~/.config/notes/config.toml` (in that order), falling back to defaults",
+}
+
+var configShowCommand = &cobra.Command{
+	Use:   "show",
+	Short: "Show effective configuration",
+	Long:  "Shows the effective configuration along with the source each setting was picked up from",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+
+		fmt.Printf("config file:           %s\n", cfg.File)
+		fmt.Printf("db:                    %s\t(%s)\n", cfg.DBPath, cfg.Sources["db"])
+		fmt.Printf("default_notebook:      %s\t(%s)\n", cfg.DefaultNotebook, cfg.Sources["default_notebook"])
+		fmt.Printf("editor:                %s\t(%s)\n", cfg.Editor, cfg.Sources["editor"])
+		fmt.Printf("max_note_size:         %d\t(%s)\n", cfg.MaxNoteSize, cfg.Sources["max_note_size"])
+		fmt.Printf("mass_delete_threshold: %d\t(%s)\n", cfg.MassDeleteThreshold, cfg.Sources["mass_delete_threshold"])
+		fmt.Printf("read_workers:          %d\t(%s)\n", cfg.ReadWorkers, cfg.Sources["read_workers"])
+		fmt.Printf("max_attachment_size:   %d\t(%s)\n", cfg.MaxAttachmentSize, cfg.Sources["max_attachment_size"])
+		fmt.Printf("encryption:            enabled=%t key_file=%q\t(%s)\n",
+			cfg.Encryption.Enabled, cfg.Encryption.KeyFile, cfg.Sources["encryption"])
+	},
+}
+
+func init() {
+	configCommand.AddCommand(configShowCommand)
+	root.AddCommand(configCommand)
+}
@@ -1,20 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"github.com/noculture/notes/models"
 	"github.com/noculture/notes/utils"
 	"github.com/spf13/cobra"
 	"gopkg.in/kyokomi/emoji.v1"
+	"io"
 	"log"
+	"os"
 	"strconv"
+	"strings"
 )
 
 var deleteCommand = &cobra.Command{
 	Use:   "del",
 	Short: "Delete notes",
 	Long: "Deletes notes from the terminal. Use `notes del noteId` to delete a note from the Default notebook" +
-		"`notes del NotebookName noteId-1 noteId-2 ..` to delete notes from a specific notebook",
+		"`notes del NotebookName noteId-1 noteId-2 ..` to delete notes from a specific notebook, or " +
+		"`notes del shortId-1 shortId-2 ..` (like `wk-3f`) to delete notes by their short ids. " +
+		"Nothing is deleted if any of the notes is locked read-only, unless `--skip-locked` or `--force` is passed. " +
+		"Deleting more notes at once than `mass_delete_threshold` (50 unless configured) asks to type the notebook name to confirm",
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			emoji.Println(" :warning: You need to specify a note to delete ")
@@ -25,10 +33,14 @@ var deleteCommand = &cobra.Command{
 			var notebookName string
 			var usNoteIds []uint64
 			// determine notebook to delete the notes from
-			switch _, err := strconv.Atoi(args[0]); err {
-			case nil:
-				notebookName = "Default"
+			switch _, err := strconv.Atoi(args[0]); {
+			case err == nil:
+				notebookName = loadConfig().DefaultNotebook
 				usNoteIds, _ = utils.ParseUInt64Slice(args[0:])
+			case looksLikeShortID(args[0]) && !notebookExists(db, args[0]):
+				// short ids (possibly of several notebooks)
+				deleteShortIDsIfExist(db, args...)
+				return
 			default:
 				notebookName = args[0]
 				usNoteIds, _ = utils.ParseUInt64Slice(args[1:])
@@ -39,6 +51,24 @@ var deleteCommand = &cobra.Command{
 	},
 }
 
+/**
+ * Deletes notes with given short ids if they exist, a notebook at a time (in order of first mention)
+ */
+func deleteShortIDsIfExist(db models.Datastore, shortIDs ...string) {
+	var notebookNames []string
+	noteIds := make(map[string][]uint64)
+	for _, shortID := range shortIDs {
+		ref := resolveShortID(db, shortID)
+		if _, ok := noteIds[ref.Notebook]; !ok {
+			notebookNames = append(notebookNames, ref.Notebook)
+		}
+		noteIds[ref.Notebook] = append(noteIds[ref.Notebook], ref.Id)
+	}
+	for _, notebookName := range notebookNames {
+		deleteNotesIfExist(db, notebookName, noteIds[notebookName]...)
+	}
+}
+
 /**
  * Deletes notes with given ids from the given notebook if they exist
  * Displays appropriate messages whether or not note exists
@@ -48,21 +78,108 @@ var deleteCommand = &cobra.Command{
  * param: ...uint64        noteIds
  */
 func deleteNotesIfExist(db models.Datastore, notebookName string, noteIds ...uint64) {
+	var existingIds []uint64
 	for _, noteId := range noteIds {
 		noteExists, _ := db.NoteExists(notebookName, noteId)
 		if noteExists {
-			err := db.DeleteNotes(notebookName, noteId)
-			if err != nil {
-				log.Panic()
-			} else {
-				emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' deleted from notebook '%s'", noteId, notebookName))
-			}
+			existingIds = append(existingIds, noteId)
 		} else {
 			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' does not exist in notebook '%s'", noteId, notebookName))
 		}
 	}
+	if len(existingIds) == 0 {
+		return
+	}
+
+	var opts []models.WriteOption
+	if deleteForce {
+		opts = append(opts, models.Force())
+	}
+	if deleteSkipLocked {
+		opts = append(opts, models.SkipLocked())
+	}
+	skipped, err := db.DeleteNotesWithOptions(notebookName, existingIds, opts...)
+	if errors.Is(err, models.ErrConfirmationRequired) {
+		var confirmed bool
+		if skipped, confirmed, err = confirmMassDelete(db, notebookName, existingIds, opts...); err == nil && !confirmed {
+			emoji.Println(" :warning: Nothing deleted")
+			return
+		}
+	}
+	if errors.Is(err, models.ErrDeletePlanStale) || errors.Is(err, models.ErrDeletePlanNotFound) {
+		emoji.Println(fmt.Sprintf(" :warning: Nothing deleted: %v (run the command again)", err))
+		return
+	}
+	var readOnly *models.ReadOnlyNotesError
+	if errors.As(err, &readOnly) {
+		emoji.Println(fmt.Sprintf(" :warning: Nothing deleted: %v (use `--skip-locked` to delete the others)", err))
+		return
+	}
+	if errors.Is(err, models.ErrNotebookArchived) {
+		emoji.Println(fmt.Sprintf(" :warning: Nothing deleted: %v", err))
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, noteId := range existingIds {
+		if containsId(skipped, noteId) {
+			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' is locked read-only, skipped", noteId))
+		} else {
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' deleted from notebook '%s'", noteId, notebookName))
+		}
+	}
+}
+
+/**
+ * Plans deleting too many notes to be deleted at once, and carries it out once the user confirms
+ * by typing the name of the notebook
+ * return: ([]uint64, bool, error) Ids of the notes left alone, and whether the user confirmed
+ */
+func confirmMassDelete(db models.Datastore, notebookName string, noteIds []uint64, opts ...models.WriteOption) ([]uint64, bool, error) {
+	plan, err := db.PlanDelete(notebookName, noteIds, opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	if plan.Remaining == 0 {
+		emoji.Println(fmt.Sprintf(" :warning: About to delete all %d notes of notebook '%s'", len(plan.NoteIds), notebookName))
+	} else {
+		emoji.Println(fmt.Sprintf(" :warning: About to delete %d notes of notebook '%s', leaving %d",
+			len(plan.NoteIds), notebookName, plan.Remaining))
+	}
+	if len(plan.Locked) > 0 && !containsId(plan.NoteIds, plan.Locked[0]) {
+		fmt.Printf("   (skipping %d locked read-only)\n", len(plan.Locked))
+	}
+	fmt.Print("   Type the notebook name to confirm: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if strings.TrimSpace(answer) != notebookName {
+		return nil, false, nil
+	}
+	skipped, err := db.ExecuteDelete(plan.Token)
+	return skipped, err == nil, err
+}
+
+func containsId(ids []uint64, id uint64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
 }
 
+var (
+	// delete notes even if they are locked read-only
+	deleteForce bool
+	// delete the notes that aren't locked read-only, leaving locked ones alone
+	deleteSkipLocked bool
+)
+
 func init() {
+	deleteCommand.Flags().BoolVar(&deleteForce, "force", false, "delete notes even if they are locked read-only")
+	deleteCommand.Flags().BoolVar(&deleteSkipLocked, "skip-locked", false, "skip notes locked read-only instead of deleting nothing")
 	root.AddCommand(deleteCommand)
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var editCommand = &cobra.Command{
+	Use:   "edit <notebook> <noteId> [content]",
+	Short: "Edit a note",
+	Long: "Replaces content of a note. Use `notes edit NotebookName noteId \"new text\"` or leave out the text " +
+		"to edit the note in the configured editor. Previous content is kept in the note's history",
+	Args: noteArgs(cobra.RangeArgs(2, 3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		note, err := db.GetNote(args[0], noteId)
+		if err != nil {
+			log.Panic(err)
+		}
+		if note.ReadOnly && !editForce {
+			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' is locked read-only (use `--force` to edit it anyway)", noteId))
+			return
+		}
+		var content string
+		if len(args) == 3 {
+			content = args[2]
+		} else if content, err = editInEditor(loadConfig().Editor, note.Content); err != nil {
+			log.Panic(err)
+		} else {
+			content = strings.TrimSuffix(content, "\n")
+		}
+		if content == note.Content {
+			emoji.Println(" :warning: Nothing changed")
+			return
+		}
+
+		// fail rather than clobber changes made (by another process) while editing
+		var conflict *models.RevisionConflictError
+		var opts []models.WriteOption
+		if editForce {
+			opts = append(opts, models.Force())
+		}
+		switch _, err := db.UpdateNoteIfRevision(args[0], noteId, note.Revision, content, opts...); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' updated", noteId))
+		case errors.As(err, &conflict):
+			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' was changed meanwhile, your edit wasn't saved:", noteId))
+			fmt.Println(content)
+		case errors.Is(err, models.ErrNoteReadOnly):
+			emoji.Println(fmt.Sprintf(" :warning: Note with id '%d' got locked read-only meanwhile, your edit wasn't saved:", noteId))
+			fmt.Println(content)
+		case errors.Is(err, models.ErrContentMismatch), errors.Is(err, models.ErrTitleTaken):
+			emoji.Println(fmt.Sprintf(" :warning: %v, your edit wasn't saved:", err))
+			fmt.Println(content)
+		case errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v, your edit wasn't saved:", err))
+			fmt.Println(content)
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// edit the note even if it's locked read-only
+	editForce bool
+)
+
+func init() {
+	editCommand.Flags().BoolVar(&editForce, "force", false, "edit the note even if it's locked read-only")
+	root.AddCommand(editCommand)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+)
+
+func TestEncryptionKeyFileFromConfig(t *testing.T) {
+	if previous, had := os.LookupEnv(dbPassphraseEnvVar); had {
+		os.Unsetenv(dbPassphraseEnvVar)
+		t.Cleanup(func() { os.Setenv(dbPassphraseEnvVar, previous) })
+	}
+	keyFile := filepath.Join(t.TempDir(), "notes.key")
+	if err := ioutil.WriteFile(keyFile, []byte("correct horse\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := fakeHome(t, "[encryption]\nenabled = true\nkey_file = \""+keyFile+"\"\n")
+
+	// the explicit step giving the database its passphrase
+	if out := runNotes(t, path, nil, "settings", "encryption", "content"); !strings.Contains(string(out), "now stored in encrypted form") {
+		t.Fatalf("settings encryption content printed %q", out)
+	}
+	runNotes(t, path, []byte("secret plans\n"), "add", "work", "-")
+	if out := runNotes(t, path, nil, "cat", "work", "1"); string(out) != "secret plans\n" {
+		t.Errorf("cat printed %q", out)
+	}
+
+	// the passphrase of the database is the key file's first line, and no other one is adopted
+	db, err := models.GetOrCreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.GetEncryptionMode() != models.EncryptionContent {
+		t.Errorf("database in %s mode", db.GetEncryptionMode())
+	}
+	if err := db.UnlockEncryption("another"); !errors.Is(err, models.ErrWrongPassphrase) {
+		t.Errorf("unlocking with another passphrase: %v, want ErrWrongPassphrase", err)
+	}
+	if err := db.UnlockEncryption("correct horse"); err != nil {
+		t.Errorf("unlocking with the key file's passphrase: %v", err)
+	}
+}
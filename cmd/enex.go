@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var importENEXCommand = &cobra.Command{
+	Use:   "import-enex <notebook> <file.enex>",
+	Short: "Import an Evernote export",
+	Long: "Adds notes of an Evernote export to a notebook, like `notes import-enex work evernote.enex`. " +
+		"Use `--markdown` to keep formatting as Markdown. Malformed notes are skipped and listed",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := importConflictPolicy()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		file, err := os.Open(args[1])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		defer file.Close()
+		db := setupDatabase()
+
+		opts := models.ENEXOptions{Markdown: enexMarkdown, DedupeByContent: enexDedupe, OnConflict: policy,
+			ResumeFrom: models.ResumeToken(importResume)}
+		report, err := db.ImportENEX(args[0], file, opts)
+		if errors.Is(err, models.ErrInvalidResumeToken) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		for _, skipped := range report.Skipped {
+			emoji.Println(fmt.Sprintf(" :warning: Skipped '%s': %s", skipped.Title, skipped.Reason))
+		}
+		// the mapping is written even if the import failed half-way
+		if enexMapping != "" {
+			if mappingErr := writeMappingFile(enexMapping, report.Mapping); mappingErr != nil {
+				log.Panic(mappingErr)
+			}
+		}
+		if err != nil {
+			printResume(report)
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) imported, %d duplicate(s) skipped", report.Imported, report.Duplicates))
+		printConflicts(report.Conflicts)
+	},
+}
+
+var (
+	// whether ENML is converted to Markdown rather than plain text
+	enexMarkdown bool
+	// whether notes already in the notebook are skipped
+	enexDedupe bool
+	// file the id-mapping is written to (none if empty)
+	enexMapping string
+)
+
+/**
+ * Writes an id-mapping table (see models.WriteIdMapping) to a file
+ */
+func writeMappingFile(path string, mapping []models.IdMapping) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := models.WriteIdMapping(file, mapping); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func init() {
+	importENEXCommand.Flags().BoolVar(&enexMarkdown, "markdown", false, "convert formatting to Markdown")
+	importENEXCommand.Flags().BoolVar(&enexDedupe, "dedupe", false, "skip notes whose content already exists in the notebook")
+	importENEXCommand.Flags().StringVar(&enexMapping, "mapping", "", "write a JSON table mapping source notes to imported note ids to this file")
+	addConflictFlag(importENEXCommand)
+	addResumeFlag(importENEXCommand)
+	root.AddCommand(importENEXCommand)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var expireCommand = &cobra.Command{
+	Use:   "expire <notebook> <noteId> <when|never>",
+	Short: "Set expiry of a note",
+	Long: "Makes a note expire after given duration or at given time, like `notes expire work 3 48h` or " +
+		"`notes expire work 3 'next friday 6pm'`, or never, " +
+		"like `notes expire work 3 never`. Expired notes are hidden from `notes ls` and removed by `notes purge`",
+	Args: noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		var expiresAt *time.Time
+		if args[2] != "never" {
+			t, err := utils.ParseWhen(args[2], time.Now(), time.Local)
+			if err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: %v", err))
+				return
+			}
+			expiresAt = &t
+		}
+		db := setupDatabase()
+
+		switch err := db.SetExpiry(args[0], noteId, expiresAt); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Expiry of note with id '%d' updated", noteId))
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNoteReadOnly), errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var purgeCommand = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove expired notes",
+	Long:  "Physically removes expired notes from all notebooks",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		purged, err := db.PurgeExpired()
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d expired note(s) removed", purged))
+	},
+}
+
+func init() {
+	root.AddCommand(expireCommand)
+	root.AddCommand(purgeCommand)
+}
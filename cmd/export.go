@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var exportCommand = &cobra.Command{
+	Use:   "export <notebook> <noteId>",
+	Short: "Export a single note",
+	Long: "Writes a note as self-contained JSON, like `notes export work 3 -o note.json` " +
+		"(or to stdout without `-o`). Use `--history` to include its past revisions, and `--encrypt` to encrypt it " +
+		"with the passphrase in $" + passphraseEnvVar + " (or `--passphrase-file`)",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		passphrase, err := encryptionPassphrase(exportEncrypt)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		var w io.Writer = os.Stdout
+		if exportOutput != "" {
+			file, err := os.Create(exportOutput)
+			if err != nil {
+				log.Panic(err)
+			}
+			defer file.Close()
+			w = file
+		}
+
+		opts := models.NoteExportOptions{IncludeHistory: exportHistory, Indent: true, Passphrase: passphrase}
+		switch err := db.ExportNote(args[0], noteId, w, opts); {
+		case err == nil:
+			if exportOutput != "" {
+				emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' exported to '%s'", noteId, exportOutput))
+			}
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var importCommand = &cobra.Command{
+	Use:   "import <notebook> <file>",
+	Short: "Import a single note",
+	Long: "Adds a note exported with `notes export` to a notebook, like `notes import work note.json`. " +
+		"Use `--dedupe` to skip the import when the notebook already has a note with the same content, and " +
+		"`--on-conflict` to choose what happens when it has one with the same title. " +
+		"Encrypted exports are decrypted with the passphrase in $" + passphraseEnvVar + " (or `--passphrase-file`)",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := os.Open(args[1])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		defer file.Close()
+		passphrase, err := encryptionPassphrase(false)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		policy, err := importConflictPolicy()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		opts := models.ImportOptions{DedupeByContent: importDedupe, Passphrase: passphrase, OnConflict: policy,
+			OnWarning: func(warning string) {
+				emoji.Println(" :warning: " + warning)
+			}}
+		switch note, err := db.ImportNote(args[0], file, opts); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note imported with id '%d'", note.Id))
+		case errors.Is(err, models.ErrNoteReadOnly):
+			emoji.Println(fmt.Sprintf(" :warning: Not imported: %v", err))
+		case errors.Is(err, models.ErrInvalidNoteExport), errors.Is(err, models.ErrWrongPassphrase):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		case errors.Is(err, models.ErrPassphraseRequired):
+			emoji.Println(" :warning: The export is encrypted: give its passphrase in $" + passphraseEnvVar + " or with --passphrase-file")
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// file the note is exported to (stdout if empty)
+	exportOutput string
+	// whether past revisions are exported too
+	exportHistory bool
+	// whether import is skipped for notes already in the notebook
+	importDedupe bool
+	// what imports do with notes the notebook already has (see models.ConflictPolicy); nothing if empty
+	importOnConflict string
+	// token of a failed import to resume from (see models.ResumeToken)
+	importResume string
+	// whether exports are encrypted
+	exportEncrypt bool
+	// file holding the passphrase of encrypted exports (overrides $NOTES_PASSPHRASE)
+	passphraseFile string
+)
+
+// environment variable holding the passphrase of encrypted exports
+const passphraseEnvVar = "NOTES_PASSPHRASE"
+
+/**
+ * Passphrase of encrypted exports: first line of '--passphrase-file', or $NOTES_PASSPHRASE
+ * Empty if none was given, unless required (for encrypting), which fails
+ */
+func encryptionPassphrase(required bool) (string, error) {
+	passphrase := os.Getenv(passphraseEnvVar)
+	if passphraseFile != "" {
+		contents, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return "", err
+		}
+		passphrase = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
+	}
+	if required && passphrase == "" {
+		return "", fmt.Errorf("Give the passphrase to encrypt with in $%s or with --passphrase-file", passphraseEnvVar)
+	}
+	return passphrase, nil
+}
+
+/**
+ * Conflict policy given with '--on-conflict' (none if it wasn't given)
+ */
+func importConflictPolicy() (models.ConflictPolicy, error) {
+	if importOnConflict == "" {
+		return "", nil
+	}
+	return models.ParseConflictPolicy(importOnConflict)
+}
+
+/**
+ * Prints how conflicts of an import were resolved, if there were any
+ */
+func printConflicts(counts models.ConflictCounts) {
+	if counts == (models.ConflictCounts{}) {
+		return
+	}
+	emoji.Println(fmt.Sprintf(" :twisted_rightwards_arrows: Conflicts: %d skipped, %d overwritten, %d kept both, %d merged",
+		counts.Skipped, counts.Overwritten, counts.KeptBoth, counts.Merged))
+}
+
+/**
+ * Registers '--on-conflict' on an import command
+ */
+func addConflictFlag(command *cobra.Command) {
+	command.Flags().StringVar(&importOnConflict, "on-conflict", "",
+		"what to do with notes the notebook already has (by title): skip, overwrite, keep-both or merge-content")
+}
+
+/**
+ * Registers '--resume' on an import command
+ */
+func addResumeFlag(command *cobra.Command) {
+	command.Flags().StringVar(&importResume, "resume", "", "resume a failed import of the same file from the token it printed")
+}
+
+/**
+ * Tells how to resume an import that failed, if it got anywhere
+ */
+func printResume(report models.ImportReport) {
+	if report.Resume != "" {
+		emoji.Println(fmt.Sprintf(" :repeat: Run the import again with --resume %s to carry on where it stopped", report.Resume))
+	}
+}
+
+func init() {
+	exportCommand.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write the note to")
+	exportCommand.Flags().BoolVar(&exportHistory, "history", false, "include past revisions of the note")
+	exportCommand.Flags().BoolVar(&exportEncrypt, "encrypt", false, "encrypt the export with a passphrase")
+	exportCommand.Flags().StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase to encrypt with")
+	importCommand.Flags().BoolVar(&importDedupe, "dedupe", false, "don't import notes whose content already exists in the notebook")
+	importCommand.Flags().StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase of an encrypted export")
+	addConflictFlag(importCommand)
+	root.AddCommand(exportCommand)
+	root.AddCommand(importCommand)
+}
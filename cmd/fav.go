@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var favCommand = &cobra.Command{
+	Use:   "fav",
+	Short: "Manage favorite notes",
+	Long:  "Favorites are a short list of notes of any notebooks, kept in the order they're arranged in",
+}
+
+var addFavoriteCommand = &cobra.Command{
+	Use:   "add <notebook> <noteId>",
+	Short: "Add a note to favorites",
+	Long:  "Adds a note to the end of favorites, like `notes fav add work 3`",
+	Args:  noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		changeFavorite(expandShortIDs(cmd, args, 0), "added to", func(db models.Datastore, ref models.NoteRef) error {
+			return db.AddFavorite(ref)
+		})
+	},
+}
+
+var removeFavoriteCommand = &cobra.Command{
+	Use:   "rm <notebook> <noteId>",
+	Short: "Remove a note from favorites",
+	Args:  noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		changeFavorite(expandShortIDs(cmd, args, 0), "removed from", func(db models.Datastore, ref models.NoteRef) error {
+			return db.RemoveFavorite(ref)
+		})
+	},
+}
+
+var moveFavoriteCommand = &cobra.Command{
+	Use:   "move <notebook> <noteId> [<notebook> <noteId>]",
+	Short: "Reorder favorites",
+	Long: "Moves a favorite right before another one, like `notes fav move work 3 home 7`, " +
+		"or to the end of favorites without another one",
+	Args: noteArgs(cobra.RangeArgs(2, 4), 0, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0, 2)
+		if len(args) == 3 {
+			emoji.Println(" :warning: Give both the notebook and id of the favorite to move before")
+			return
+		}
+		var before models.NoteRef
+		if len(args) == 4 {
+			beforeId, err := utils.ParseUInt64(args[3])
+			if err != nil {
+				return
+			}
+			before = models.NoteRef{Notebook: args[2], Id: beforeId}
+		}
+		changeFavorite(args[:2], "moved in", func(db models.Datastore, ref models.NoteRef) error {
+			return db.MoveFavorite(ref, before)
+		})
+	},
+}
+
+var listFavoritesCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List favorite notes",
+	Long: "Lists favorite notes in their order. Favorites whose note is gone are left out; " +
+		"`--prune` removes them for good",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		if favoritesPrune {
+			pruned, err := db.PruneFavorites()
+			if err != nil {
+				log.Panic(err)
+			}
+			for _, ref := range pruned {
+				emoji.Println(fmt.Sprintf(" :pencil2: %s (gone) removed from favorites", ref))
+			}
+		}
+		results, err := db.ListFavorites()
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(results) == 0 {
+			emoji.Println(" :warning: No favorites (see `notes fav add`)")
+		}
+		for _, result := range results {
+			emoji.Println(fmt.Sprintf(" %s	%s	%s%s", shortIDOf(db, result.Ref), result.Ref,
+				firstLine(result.Note.Content), formatTags(result.Note.Tags)))
+		}
+	},
+}
+
+/**
+ * Applies a change to the favorite entry of the note given by args (notebook and id), reporting it as done
+ */
+func changeFavorite(args []string, done string, change func(models.Datastore, models.NoteRef) error) {
+	noteId, err := utils.ParseUInt64(args[1])
+	if err != nil {
+		return
+	}
+	db := setupDatabase()
+
+	ref := models.NoteRef{Notebook: args[0], Id: noteId}
+	switch err := change(db, ref); {
+	case err == nil:
+		emoji.Println(fmt.Sprintf(" :pencil2: %s %s favorites", ref, done))
+	case errors.Is(err, models.ErrFavoriteNotFound), errors.Is(err, models.ErrNoteNotFound),
+		errors.Is(err, models.ErrNotebookNotFound):
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+	default:
+		log.Panic(err)
+	}
+}
+
+var (
+	// remove favorites whose note is gone before listing
+	favoritesPrune bool
+)
+
+func init() {
+	listFavoritesCommand.Flags().BoolVar(&favoritesPrune, "prune", false, "remove favorites whose note is gone")
+	favCommand.AddCommand(addFavoriteCommand)
+	favCommand.AddCommand(removeFavoriteCommand)
+	favCommand.AddCommand(moveFavoriteCommand)
+	favCommand.AddCommand(listFavoritesCommand)
+	root.AddCommand(favCommand)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var featuresCommand = &cobra.Command{
+	Use:   "features",
+	Short: "Show which features the database has initialized",
+	Long: "Lists features along with whether the database has initialized them. Features get initialized the " +
+		"first time they're used, so databases created by older versions lack the ones that came later until then",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		capabilities := db.Capabilities()
+		features := []struct {
+			name        string
+			initialized bool
+		}{
+			{"tag index", capabilities.TagIndex},
+			{"title index", capabilities.TitleIndex},
+			{"URL index", capabilities.URLIndex},
+			{"fingerprint index", capabilities.FingerprintIndex},
+			{"history", capabilities.History},
+			{"undo log", capabilities.UndoLog},
+			{"attachments", capabilities.Attachments},
+			{"relations", capabilities.Relations},
+			{"snapshots", capabilities.Snapshots},
+			{"favorites", capabilities.Favorites},
+			{"templates", capabilities.Templates},
+			{"shares", capabilities.Shares},
+			{"API tokens", capabilities.Tokens},
+			{"outbox", capabilities.Outbox},
+			{"sync state", capabilities.SyncState},
+		}
+		for _, feature := range features {
+			state := "not yet"
+			if feature.initialized {
+				state = "yes"
+			}
+			fmt.Printf(" %s:\t%s\n", feature.name, state)
+		}
+		if capabilities.OutboxEnabled {
+			fmt.Println(" recording changes:\tyes")
+		}
+	},
+}
+
+func init() {
+	root.AddCommand(featuresCommand)
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var ruleCommand = &cobra.Command{
+	Use:   "rule",
+	Short: "Manage filing rules",
+	Long: "Filing rules sort notes of an inbox notebook into where they belong, see `notes file`. " +
+		"Rules are tried in the order they were added; the first matching rule wins, unless it has `--continue`",
+}
+
+var addRuleCommand = &cobra.Command{
+	Use:   "add",
+	Short: "Add a filing rule",
+	Long: "Adds a filing rule after the existing ones, like `notes rule add --title-prefix 'Recipe:' --move-to recipes`. " +
+		"All criteria given must hold for a note to match",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		rule := models.FilingRule{Name: ruleName, Match: ruleMatch, Action: ruleAction, Continue: ruleContinue}
+		switch err := db.AddFilingRule(rule); {
+		case err == nil:
+			emoji.Println(" :pencil2: Filing rule added")
+		case errors.Is(err, models.ErrInvalidFilingRule):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listRulesCommand = &cobra.Command{
+	Use:   "ls",
+	Short: "List filing rules",
+	Long:  "Lists filing rules in the order they are tried",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		rules, err := db.ListFilingRules()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, rule := range rules {
+			fmt.Printf(" %d\t%s\t%s -> %s\n", rule.Id, rule.Name, describeMatch(rule.Match), describeAction(rule))
+		}
+	},
+}
+
+var removeRuleCommand = &cobra.Command{
+	Use:   "rm <ruleId>",
+	Short: "Remove a filing rule",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ruleId, err := utils.ParseUInt64(args[0])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.RemoveFilingRule(ruleId); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Filing rule '%d' removed", ruleId))
+		case errors.Is(err, models.ErrFilingRuleNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var fileCommand = &cobra.Command{
+	Use:   "file <notebook>",
+	Short: "File notes of a notebook by filing rules",
+	Long: "Runs filing rules over all notes of a notebook, like `notes file inbox`. " +
+		"`--dry-run` lists which note would be affected by which rule, without changing anything",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		report, err := db.ApplyFilingRules(args[0], fileDryRun)
+		switch {
+		case err == nil:
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookArchived),
+			errors.Is(err, models.ErrInvalidFilingRule):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		default:
+			log.Panic(err)
+		}
+
+		for _, filed := range report.Notes {
+			rules := make([]string, len(filed.Rules))
+			for i, ruleId := range filed.Rules {
+				rules[i] = fmt.Sprint(ruleId)
+			}
+			var actions []string
+			if filed.MovedTo != nil {
+				if fileDryRun {
+					actions = append(actions, fmt.Sprintf("move to '%s'", filed.MovedTo.Notebook))
+				} else {
+					actions = append(actions, fmt.Sprintf("moved to '%s' as %d", filed.MovedTo.Notebook, filed.MovedTo.Id))
+				}
+			}
+			if len(filed.AddedTags) > 0 {
+				actions = append(actions, "tag "+strings.Join(filed.AddedTags, ", "))
+			}
+			if filed.Archived {
+				actions = append(actions, "archive")
+			}
+			if filed.Error != "" {
+				actions = append(actions, "failed: "+filed.Error)
+			}
+			fmt.Printf(" %d\trule %s\t%s\n", filed.NoteId, strings.Join(rules, ", "), strings.Join(actions, "; "))
+		}
+		if fileDryRun {
+			emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) would be filed", len(report.Notes)))
+		} else {
+			emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) filed, %d failed", len(report.Notes)-report.Failed, report.Failed))
+		}
+	},
+}
+
+/**
+ * Criteria of a rule for display
+ */
+func describeMatch(match models.FilingMatch) string {
+	var criteria []string
+	if match.Tag != "" {
+		criteria = append(criteria, "tag "+match.Tag)
+	}
+	if match.TitlePrefix != "" {
+		criteria = append(criteria, fmt.Sprintf("title starting with '%s'", match.TitlePrefix))
+	}
+	if match.ContentRegex != "" {
+		criteria = append(criteria, fmt.Sprintf("content matching /%s/", match.ContentRegex))
+	}
+	if match.Kind != "" {
+		criteria = append(criteria, "kind "+match.Kind)
+	}
+	if match.Language != "" {
+		criteria = append(criteria, "language "+match.Language)
+	}
+	return strings.Join(criteria, " and ")
+}
+
+/**
+ * Actions of a rule for display
+ */
+func describeAction(rule models.FilingRule) string {
+	var actions []string
+	if rule.Action.MoveTo != "" {
+		actions = append(actions, fmt.Sprintf("move to '%s'", rule.Action.MoveTo))
+	}
+	if len(rule.Action.AddTags) > 0 {
+		actions = append(actions, "tag "+strings.Join(rule.Action.AddTags, ", "))
+	}
+	if rule.Action.Archive {
+		actions = append(actions, "archive")
+	}
+	if rule.Continue {
+		actions = append(actions, "continue")
+	}
+	return strings.Join(actions, "; ")
+}
+
+var (
+	// name, criteria and actions of the rule to add
+	ruleName     string
+	ruleMatch    models.FilingMatch
+	ruleAction   models.FilingAction
+	ruleContinue bool
+	// only report what filing would do
+	fileDryRun bool
+)
+
+func init() {
+	flags := addRuleCommand.Flags()
+	flags.StringVar(&ruleName, "name", "", "name of the rule")
+	flags.StringVar(&ruleMatch.Tag, "tag", "", "match notes having this tag")
+	flags.StringVar(&ruleMatch.TitlePrefix, "title-prefix", "", "match notes whose title starts with this")
+	flags.StringVar(&ruleMatch.ContentRegex, "content-regex", "", "match notes whose content matches this regular expression")
+	flags.StringVar(&ruleMatch.Kind, "kind", "", "match notes of this kind (text, markdown or json)")
+	flags.StringVar(&ruleMatch.Language, "language", "", "match notes in this language (ISO 639-1 code)")
+	flags.StringVar(&ruleAction.MoveTo, "move-to", "", "move matching notes into this notebook")
+	flags.StringSliceVar(&ruleAction.AddTags, "add-tag", nil, "add this tag to matching notes (repeatable)")
+	flags.BoolVar(&ruleAction.Archive, "archive", false, "lock matching notes read-only")
+	flags.BoolVar(&ruleContinue, "continue", false, "keep trying later rules when this one matches")
+	fileCommand.Flags().BoolVar(&fileDryRun, "dry-run", false, "only list what would be done")
+
+	ruleCommand.AddCommand(addRuleCommand)
+	ruleCommand.AddCommand(listRulesCommand)
+	ruleCommand.AddCommand(removeRuleCommand)
+	root.AddCommand(ruleCommand)
+	root.AddCommand(fileCommand)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var findBinaryCommand = &cobra.Command{
+	Use:   "find-binary <notebook>",
+	Short: "Find notes holding control characters or binary data",
+	Long: "Lists notes of a notebook whose content isn't plain text, like `notes find-binary scratch`: binary-ish notes " +
+		"(holding NUL bytes, invalid UTF-8 or mostly control characters) and notes holding some control characters " +
+		"(like ANSI color codes of pasted terminal output)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		found, err := db.FindBinaryNotes(args[0])
+		switch {
+		case errors.Is(err, models.ErrEncryptedSearch):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil:
+			log.Panic(err)
+		}
+		for _, stats := range found {
+			fmt.Printf(" %d\t%s\t%d control char(s) in %d byte(s)\n", stats.Id, stats.Class, stats.ControlChars, stats.Bytes)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) found", len(found)))
+	},
+}
+
+func init() {
+	root.AddCommand(findBinaryCommand)
+}
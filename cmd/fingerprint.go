@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var fingerprintCommand = &cobra.Command{
+	Use:   "fingerprint",
+	Short: "Look up notes by fingerprint",
+	Long: "Notes get a fingerprint when they're created, which stays the same across exports, imports and edits " +
+		"(imports with `--dedupe` recognize notes by it)",
+}
+
+var findFingerprintCommand = &cobra.Command{
+	Use:   "find <notebook> <fingerprint>",
+	Short: "Find the note with a fingerprint",
+	Long:  "Prints the note of a notebook having given fingerprint, like `notes fingerprint find work 3f2a..`",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		ref, found, err := db.FindByFingerprint(args[0], args[1])
+		switch {
+		case errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		case errors.Is(err, models.ErrIndexNotBuilt):
+			emoji.Println(" :warning: Notes have no fingerprints yet, give them some with `notes fingerprint backfill`")
+		case err != nil:
+			log.Panic(err)
+		case !found:
+			emoji.Println(fmt.Sprintf(" :warning: No note of notebook '%s' has that fingerprint", args[0]))
+		default:
+			fmt.Printf(" %s\t%s\n", shortIDOf(db, ref), ref)
+		}
+	},
+}
+
+var backfillFingerprintsCommand = &cobra.Command{
+	Use:   "backfill",
+	Short: "Give fingerprints to notes that have none",
+	Long: "Gives fingerprints to notes created before fingerprints existed. These are made of the notes' " +
+		"current content, so copies of a note edited since get different ones",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		backfilled, err := db.BackfillFingerprints()
+		if errors.Is(err, models.ErrEncryptionLocked) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		} else if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d notes given a fingerprint", backfilled))
+	},
+}
+
+func init() {
+	fingerprintCommand.AddCommand(findFingerprintCommand)
+	fingerprintCommand.AddCommand(backfillFingerprintsCommand)
+	root.AddCommand(fingerprintCommand)
+}
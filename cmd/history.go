@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var historyCommand = &cobra.Command{
+	Use:   "history <notebook> <noteId>",
+	Short: "Show revisions of a note",
+	Long:  "Lists past revisions of a note. Use `notes diff` to see what changed between them",
+	Args:  noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		revisions, err := db.GetNoteHistory(args[0], noteId)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, revision := range revisions {
+			fmt.Printf(" %d\t%s\t%s\n", revision.Revision, revision.SavedAt.Format("2006-01-02 15:04:05"),
+				firstLine(revision.Content))
+		}
+		fmt.Printf(" %d\tcurrent\n", len(revisions)+1)
+	},
+}
+
+var diffCommand = &cobra.Command{
+	Use:   "diff <notebook> <noteId> <revA> [revB]",
+	Short: "Show changes between revisions of a note",
+	Long: "Shows a unified diff between two revisions of a note. " +
+		"If `revB` is left out, revision `revA` is compared against the note's current content",
+	Args: noteArgs(cobra.RangeArgs(3, 4), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		ids, err := utils.ParseUInt64Slice(args[1:])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		var hunks []models.DiffHunk
+		if len(ids) == 3 {
+			hunks, err = db.DiffRevisions(args[0], ids[0], ids[1], ids[2])
+		} else {
+			hunks, err = db.DiffAgainstCurrent(args[0], ids[0], ids[1])
+		}
+		var revisionNotFoundErr *models.RevisionNotFoundError
+		switch {
+		case err == nil:
+			fmt.Print(models.FormatUnified(hunks))
+		case errors.As(err, &revisionNotFoundErr), errors.Is(err, models.ErrNoteNotFound),
+			errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	root.AddCommand(historyCommand)
+	root.AddCommand(diffCommand)
+}
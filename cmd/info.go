@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var (
+	// tags to be applied to every new note of the notebook
+	defaultTags []string
+	// text to be prepended to every new note of the notebook
+	defaultContentPrefix string
+)
+
+var infoCommand = &cobra.Command{
+	Use:   "info <notebook>",
+	Short: "Show notebook details",
+	Long:  "Shows number of notes in a notebook along with defaults applied to its new notes",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		info, err := db.GetNotebookInfo(args[0])
+		switch {
+		case err == nil:
+			emoji.Println(" :notebook_with_decorative_cover: " + info.Name)
+			fmt.Printf(" notes:\t%d\n", info.NoteCount)
+			if info.Archived {
+				fmt.Println(" archived:\tyes (read-only)")
+			}
+			if info.Locked {
+				fmt.Println(" encrypted:\tyes (locked: its passphrase wasn't given)")
+			} else if info.Encrypted {
+				fmt.Println(" encrypted:\tyes")
+			}
+			fmt.Printf(" default tags:\t%s\n", formatTags(info.Defaults.Tags))
+			fmt.Printf(" content prefix:\t%q\n", info.Defaults.ContentPrefix)
+			if info.AutoSuffixTitles {
+				fmt.Println(" unique titles:\tyes (taken titles of new notes get suffixed)")
+			} else if info.UniqueTitles {
+				fmt.Println(" unique titles:\tyes")
+			}
+			if info.Policy != nil {
+				fmt.Printf(" policy:\t%s\n", describePolicy(*info.Policy))
+			}
+		case errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: Notebook '%s' doesn't exist", args[0]))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var defaultsCommand = &cobra.Command{
+	Use:   "defaults <notebook>",
+	Short: "Set defaults of a notebook",
+	Long: "Sets tags and content prefix applied to every note added to a notebook from now on, like " +
+		"`notes defaults work --tag work --prefix \"[work] \"`. Existing notes are left untouched; " +
+		"running without flags clears the defaults",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		defaults := models.NotebookDefaults{Tags: defaultTags, ContentPrefix: defaultContentPrefix}
+		if err := db.SetNotebookDefaults(args[0], defaults); errors.Is(err, models.ErrNotebookArchived) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		} else if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Defaults of notebook '%s' updated", args[0]))
+	},
+}
+
+func init() {
+	defaultsCommand.Flags().StringSliceVar(&defaultTags, "tag", nil, "tag applied to every new note (repeatable)")
+	defaultsCommand.Flags().StringVar(&defaultContentPrefix, "prefix", "", "text prepended to every new note")
+	root.AddCommand(infoCommand)
+	root.AddCommand(defaultsCommand)
+}
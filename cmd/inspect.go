@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var inspectCommand = &cobra.Command{
+	Use:   "inspect",
+	Short: "Describe the DB file",
+	Long: "Describes the DB file (like `notes inspect --db broken.db`): its pages, buckets and notebooks, " +
+		"with buckets unknown to this version marked as such. The file is opened read-only and needn't be intact; " +
+		"`--output json` prints the report as JSON",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput = inspectOutput == "json"
+		report, err := models.Inspect(loadConfig().DBPath)
+		switch {
+		case os.IsNotExist(err) && jsonOutput:
+			exitWithJSONError(models.ErrorInfo{Code: models.CodeNotFound, Message: err.Error()})
+		case errors.Is(err, models.ErrDatabaseLocked), os.IsNotExist(err):
+			reportJSONError(err)
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil:
+			reportJSONError(err)
+			log.Panic(err)
+		}
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				log.Panic(err)
+			}
+			return
+		}
+
+		fmt.Printf(" %s: %d bytes, %d byte pages (%d free), transaction %d\n",
+			report.Path, report.FileSize, report.PageSize, report.FreePages, report.TxId)
+		var metaKeys []string
+		for key := range report.Meta {
+			metaKeys = append(metaKeys, key)
+		}
+		sort.Strings(metaKeys)
+		if len(metaKeys) > 0 {
+			fmt.Println()
+		}
+		for _, key := range metaKeys {
+			fmt.Printf(" meta %s: %s\n", key, report.Meta[key])
+		}
+		fmt.Printf("\n %-16s %-10s %10s %12s\n", "bucket", "kind", "keys", "bytes")
+		for _, bucket := range report.Buckets {
+			fmt.Printf(" %-16s %-10s %10d %12d\n", bucket.Name, bucket.Kind, bucket.Keys, bucket.Bytes)
+		}
+		fmt.Printf("\n %-24s %8s %10s %10s %10s  %s\n", "notebook", "notes", "sequence", "min id", "max id", "keys")
+		for _, notebook := range report.Notebooks {
+			format := notebook.KeyFormat
+			if notebook.Buckets > 0 {
+				format += fmt.Sprintf(" (%d nested buckets)", notebook.Buckets)
+			}
+			fmt.Printf(" %-24s %8d %10d %10d %10d  %s\n", strings.ToValidUTF8(notebook.Key, "?"), notebook.Notes,
+				notebook.Sequence, notebook.MinId, notebook.MaxId, format)
+		}
+	},
+}
+
+var (
+	// print the report as JSON ('json') rather than as tables
+	inspectOutput string
+)
+
+func init() {
+	inspectCommand.Flags().StringVar(&inspectOutput, "output", "", "print the report as JSON ('json')")
+	root.AddCommand(inspectCommand)
+}
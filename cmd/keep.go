@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var importKeepCommand = &cobra.Command{
+	Use:   "import-keep <notebook> <dir>",
+	Short: "Import a Google Keep takeout",
+	Long: "Adds notes of a Google Keep takeout directory to a notebook, like `notes import-keep personal Takeout/Keep`. " +
+		"Trashed notes are left out unless `--trashed` is given. Corrupt files are skipped and listed",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := importConflictPolicy()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		opts := models.ImportOptions{DedupeByContent: keepDedupe, IncludeTrashed: keepTrashed, OnConflict: policy}
+		report, err := db.ImportKeepTakeout(args[0], args[1], opts)
+		for _, skipped := range report.Skipped {
+			emoji.Println(fmt.Sprintf(" :warning: Skipped '%s': %s", skipped.Title, skipped.Reason))
+		}
+		// the mapping is written even if the import failed half-way
+		if keepMapping != "" {
+			if mappingErr := writeMappingFile(keepMapping, report.Mapping); mappingErr != nil {
+				log.Panic(mappingErr)
+			}
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) imported, %d duplicate(s) skipped", report.Imported, report.Duplicates))
+		printConflicts(report.Conflicts)
+	},
+}
+
+var (
+	// whether notes already in the notebook are skipped
+	keepDedupe bool
+	// whether trashed notes are imported too
+	keepTrashed bool
+	// file the id-mapping is written to (none if empty)
+	keepMapping string
+)
+
+func init() {
+	importKeepCommand.Flags().BoolVar(&keepDedupe, "dedupe", false, "skip notes whose content already exists in the notebook")
+	importKeepCommand.Flags().BoolVar(&keepTrashed, "trashed", false, "also import trashed notes")
+	importKeepCommand.Flags().StringVar(&keepMapping, "mapping", "", "write a JSON table mapping source notes to imported note ids to this file")
+	addConflictFlag(importKeepCommand)
+	root.AddCommand(importKeepCommand)
+}
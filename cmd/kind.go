@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var kindCommand = &cobra.Command{
+	Use:   "kind <notebook> <noteId> <kind>",
+	Short: "Change the kind of a note",
+	Long: "Changes how a note's content is read: 'text', 'markdown' or 'json', like `notes kind config 4 json`. " +
+		"Content of JSON notes must be valid JSON",
+	Args: noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.SetNoteKind(args[0], noteId, args[2]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' is now of kind '%s'", noteId, args[2]))
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrUnknownKind), errors.Is(err, models.ErrContentMismatch),
+			errors.Is(err, models.ErrNoteReadOnly), errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	root.AddCommand(kindCommand)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var languagesCommand = &cobra.Command{
+	Use:   "languages <notebook>",
+	Short: "Show languages notes are written in",
+	Long: "Shows how many notes of a notebook are written in each language, like `notes languages diary`. " +
+		"Languages of notes are detected when they are saved; `--redetect` detects them afresh for all notes first",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		if languagesRedetect {
+			changed, err := db.RedetectLanguages(args[0])
+			if err != nil {
+				log.Panic(err)
+			}
+			emoji.Println(fmt.Sprintf(" :pencil2: Language of %d notes changed", changed))
+		}
+		distribution, err := db.DetectLanguages(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(distribution) == 0 {
+			emoji.Println(fmt.Sprintf(" :warning: No notes in notebook '%s'", args[0]))
+			return
+		}
+		var languages []string
+		for language := range distribution {
+			languages = append(languages, language)
+		}
+		sort.Slice(languages, func(i, j int) bool {
+			if distribution[languages[i]] != distribution[languages[j]] {
+				return distribution[languages[i]] > distribution[languages[j]]
+			}
+			return languages[i] < languages[j]
+		})
+		for _, language := range languages {
+			fmt.Printf(" %s\t%d\n", language, distribution[language])
+		}
+	},
+}
+
+// whether languages of all notes should be detected afresh
+var languagesRedetect bool
+
+func init() {
+	languagesCommand.Flags().BoolVar(&languagesRedetect, "redetect", false, "detect languages of all notes afresh")
+	root.AddCommand(languagesCommand)
+}
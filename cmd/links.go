@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var linksCommand = &cobra.Command{
+	Use:   "links <notebook>",
+	Short: "Check links of notes for dead ones",
+	Long: "Requests every link found in notes of a notebook and lists the dead ones, like `notes links bookmarks`. " +
+		"Interrupt with Ctrl-C to stop checking",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		dead, err := db.CheckLinks(ctx, args[0], linksConcurrency)
+		if err == context.Canceled {
+			emoji.Println(" :warning: Link check interrupted")
+			return
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(dead) == 0 {
+			emoji.Println(" :pencil2: No dead links")
+			return
+		}
+		for _, link := range dead {
+			reason := link.Error
+			if reason == "" {
+				reason = fmt.Sprintf("status %d", link.Status)
+			}
+			emoji.Println(fmt.Sprintf(" :warning: %d\t%s (%s)", link.Ref.Id, link.URL, reason))
+		}
+	},
+}
+
+var withURLCommand = &cobra.Command{
+	Use:   "with-url <text>",
+	Short: "Find notes linking to a URL",
+	Long:  "Lists notes (of all notebooks) containing a link that contains given text, like `notes with-url github.com`",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		refs, err := db.ListNotesWithURL(args[0])
+		if errors.Is(err, models.ErrIndexNotBuilt) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		} else if err != nil {
+			log.Panic(err)
+		}
+		if len(refs) == 0 {
+			emoji.Println(fmt.Sprintf(" :warning: No notes link to '%s'", args[0]))
+			return
+		}
+		for _, ref := range refs {
+			note, err := db.GetNote(ref.Notebook, ref.Id)
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Printf(" %s\t%s\n", ref, firstLine(note.Content))
+		}
+	},
+}
+
+var (
+	// number of links checked at a time
+	linksConcurrency int
+)
+
+func init() {
+	linksCommand.Flags().IntVar(&linksConcurrency, "concurrency", 4, "number of links checked at a time")
+	root.AddCommand(linksCommand)
+	root.AddCommand(withURLCommand)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var lockCommand = &cobra.Command{
+	Use:   "lock <notebook> <noteId>",
+	Short: "Lock a note read-only",
+	Long: "Locks a note against being edited or deleted, like `notes lock legal 3`. " +
+		"Use `notes unlock` to allow changes again, or `--force` with `notes edit` / `notes del`",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		setReadOnly(args, true)
+	},
+}
+
+var unlockCommand = &cobra.Command{
+	Use:   "unlock <notebook> <noteId>",
+	Short: "Unlock a read-only note",
+	Long:  "Allows a note locked by `notes lock` to be edited and deleted again",
+	Args:  noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		setReadOnly(args, false)
+	},
+}
+
+/**
+ * Locks or unlocks the note given by args (notebook, noteId)
+ */
+func setReadOnly(args []string, readOnly bool) {
+	noteId, err := utils.ParseUInt64(args[1])
+	if err != nil {
+		return
+	}
+	db := setupDatabase()
+
+	set, state := db.UnlockNoteReadOnly, "unlocked"
+	if readOnly {
+		set, state = db.LockNoteReadOnly, "locked read-only"
+	}
+	switch err := set(args[0], noteId); {
+	case err == nil:
+		emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' %s", noteId, state))
+	case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+		errors.Is(err, models.ErrNotebookArchived):
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+	default:
+		log.Panic(err)
+	}
+}
+
+func init() {
+	root.AddCommand(lockCommand)
+	root.AddCommand(unlockCommand)
+}
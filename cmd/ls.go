@@ -13,7 +13,7 @@ var lsCommand = &cobra.Command{
 	Use:   "ls <notebook>",
 	Short: "List stuff",
 	Long: "Show a list of notes or notebooks. `notes ls` will show a list of your notebooks and `notes ls NotebookName` " +
-		"will show a list of notes in the notebook you've specified",
+		"will show a list of notes in the notebook you've specified, by their ids and short ids (like `wk-3f`)",
 	Run: func(cmd *cobra.Command, args []string) {
 		db := setupDatabase()
 
@@ -31,13 +31,35 @@ var lsCommand = &cobra.Command{
 func getSpecificNotebook(db models.Datastore, notebookName string) {
 	notebookExists, _ := db.NotebookExists(notebookName)
 	if notebookExists {
-		notebook, err := db.GetNotebook(notebookName)
+		info, err := db.GetNotebookInfo(notebookName)
 		if err != nil {
 			log.Panic()
 		}
-		emoji.Println(notebook.Name)
-		for _, note := range notebook.Notes {
-			emoji.Println(" " + strconv.FormatUint(note.Id, 10) + "	" + note.Content)
+		var opts []models.ListOption
+		if listExpired {
+			opts = append(opts, models.WithExpired())
+		}
+		if listLanguage != "" {
+			opts = append(opts, models.Language(listLanguage))
+		}
+		if listKind != "" {
+			opts = append(opts, models.OfKind(listKind))
+		}
+		if listArranged {
+			opts = append(opts, models.SortByPosition())
+		}
+		notes, err := db.ListNotes(notebookName, opts...)
+		if err != nil {
+			log.Panic()
+		}
+		if info.Archived {
+			emoji.Println(info.Name + " (archived)")
+		} else {
+			emoji.Println(info.Name)
+		}
+		for _, note := range notes {
+			emoji.Println(" " + strconv.FormatUint(note.Id, 10) + "	" + models.ShortID(info.Abbrev, note.Id) + "	" +
+				models.DisplayContent(note) + formatTags(note.Tags) + formatReadOnly(note))
 		}
 	} else {
 		emoji.Println(fmt.Sprintf(" :warning: Noteebook '%s' doesn't exist", notebookName))
@@ -49,11 +71,43 @@ func printAllNotebooks(db models.Datastore) {
 	if err != nil {
 		log.Panic()
 	}
+	// archived notebooks being the ones listed only along with them
+	active := make(map[string]bool)
 	for _, notebookName := range notebookNames {
-		emoji.Println(" :notebook_with_decorative_cover: " + notebookName)
+		active[notebookName] = true
+	}
+	if listArchived {
+		if notebookNames, err = db.GetAllNotebookNames(models.WithArchivedNotebooks()); err != nil {
+			log.Panic()
+		}
+	}
+	for _, notebookName := range notebookNames {
+		if active[notebookName] {
+			emoji.Println(" :notebook_with_decorative_cover: " + notebookName)
+		} else {
+			emoji.Println(" :file_cabinet: " + notebookName + " (archived)")
+		}
 	}
 }
 
+var (
+	// whether expired (but not yet purged) notes should be listed too
+	listExpired bool
+	// language (ISO 639-1 code) of notes listed; all notes if empty
+	listLanguage string
+	// kind of notes listed ("text", "markdown" or "json"); all notes if empty
+	listKind string
+	// whether notes are listed as arranged by hand (see `notes move`) rather than by id
+	listArranged bool
+	// whether archived notebooks are listed too
+	listArchived bool
+)
+
 func init() {
+	lsCommand.Flags().BoolVar(&listExpired, "expired", false, "include expired notes that haven't been purged yet")
+	lsCommand.Flags().StringVar(&listLanguage, "lang", "", "only list notes written in given language (like 'en' or 'hi')")
+	lsCommand.Flags().StringVar(&listKind, "kind", "", "only list notes of given kind ('text', 'markdown' or 'json')")
+	lsCommand.Flags().BoolVar(&listArranged, "arranged", false, "list notes as arranged by `notes move`")
+	lsCommand.Flags().BoolVar(&listArchived, "archived", false, "list archived notebooks too")
 	root.AddCommand(lsCommand)
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var mirrorCommand = &cobra.Command{
+	Use:   "mirror <dir>",
+	Short: "Mirror notes into a directory of markdown files",
+	Long: "Writes every note as '<notebook>/<id>.md' under given directory, touching only files that changed, " +
+		"like `notes mirror ~/notes-mirror`. Use `--pull` to apply edits made to those files back to the notes",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		opts := models.MirrorOptions{Overwrite: mirrorOverwrite, DeleteMissing: mirrorDeleteMissing}
+		var report models.MirrorReport
+		var err error
+		if mirrorPull {
+			report, err = db.MirrorFromDir(args[0], opts)
+		} else {
+			report, err = db.MirrorToDir(args[0], opts)
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, file := range report.Conflicts {
+			emoji.Println(fmt.Sprintf(" :warning: Conflict on '%s' (edited in the mirror since the last sync)", file))
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d created, %d updated, %d deleted, %d conflicted",
+			report.Created, report.Updated, report.Deleted, report.Conflicted))
+	},
+}
+
+var (
+	// whether edits are applied from the mirror to the notes (rather than the other way round)
+	mirrorPull bool
+	// whether files edited in the mirror are overwritten
+	mirrorOverwrite bool
+	// whether notes whose files were removed from the mirror are deleted
+	mirrorDeleteMissing bool
+)
+
+func init() {
+	mirrorCommand.Flags().BoolVar(&mirrorPull, "pull", false, "apply edits made in the mirror to the notes")
+	mirrorCommand.Flags().BoolVar(&mirrorOverwrite, "overwrite", false, "overwrite files edited in the mirror")
+	mirrorCommand.Flags().BoolVar(&mirrorDeleteMissing, "delete-missing", false, "with --pull, delete notes whose files were removed")
+	root.AddCommand(mirrorCommand)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+// id of the note moved notes are placed before (0 moves them to the end)
+var moveBefore uint64
+
+var moveCommand = &cobra.Command{
+	Use:   "move <notebook> <noteId>",
+	Short: "Arrange a note by hand",
+	Long: "Moves a note before another one, like `notes move board 7 --before 3`, or after the notes already " +
+		"arranged without `--before`. Use `notes ls --arranged` to list notes in that order",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		if moveBefore != 0 {
+			err = db.MoveNoteBefore(args[0], noteId, moveBefore)
+		} else {
+			err = db.MoveNoteToEnd(args[0], noteId)
+		}
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' moved", noteId))
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	moveCommand.Flags().Uint64Var(&moveBefore, "before", 0, "id of the note to place the note before")
+	root.AddCommand(moveCommand)
+}
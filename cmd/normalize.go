@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var normalizeCommand = &cobra.Command{
+	Use:   "normalize <notebook>",
+	Short: "Normalize content of existing notes",
+	Long: "Cleans up content of the notes of a notebook written before normalization was turned on " +
+		"(see `notes settings normalize`), like `notes normalize work`. Previous content is kept in the notes' history; " +
+		"notes locked read-only are left alone",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		changed, err := db.NormalizeExisting(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Content of %d notes normalized", changed))
+	},
+}
+
+func init() {
+	root.AddCommand(normalizeCommand)
+}
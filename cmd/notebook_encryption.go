@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var encryptNotebookCommand = &cobra.Command{
+	Use:   "encrypt-notebook <notebook>",
+	Short: "Encrypt a notebook with a passphrase of its own",
+	Long: "Encrypts content of the notes of a notebook (and their history) with the passphrase in $" + notebookPassphraseEnvVar +
+		", like `NOTES_NOTEBOOK_PASSPHRASE=... notes encrypt-notebook journal`. Titles, tags and timestamps stay in plaintext. " +
+		"Commands then read and write its notes only with the passphrase in $" + notebookPassphraseEnvVar + ", and searches " +
+		"of all notebooks leave it out otherwise. Its exports stay encrypted, importing them taking the same passphrase",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase := os.Getenv(notebookPassphraseEnvVar)
+		if passphrase == "" {
+			emoji.Println(" :warning: Give the passphrase to encrypt the notebook with in $" + notebookPassphraseEnvVar)
+			return
+		}
+		db := setupDatabase()
+
+		err := db.EncryptNotebook(args[0], passphrase)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' is now encrypted: keep its passphrase safe, notes can't be read without it", args[0]))
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookEncrypted),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var notebookPassphraseCommand = &cobra.Command{
+	Use:   "notebook-passphrase <notebook>",
+	Short: "Change the passphrase of an encrypted notebook",
+	Long: "Changes the passphrase of a notebook encrypted with `notes encrypt-notebook` from the one in $" + notebookPassphraseEnvVar +
+		" to the one in $" + newNotebookPassphraseEnvVar + ". Notes aren't encrypted again: only the key they're encrypted with is. " +
+		"Exports made before still take the old passphrase",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		newPassphrase := os.Getenv(newNotebookPassphraseEnvVar)
+		if newPassphrase == "" {
+			emoji.Println(" :warning: Give the new passphrase in $" + newNotebookPassphraseEnvVar)
+			return
+		}
+		db := setupDatabase()
+
+		err := db.ChangeNotebookPassphrase(args[0], os.Getenv(notebookPassphraseEnvVar), newPassphrase)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Changed the passphrase of notebook '%s'", args[0]))
+		case errors.Is(err, models.ErrWrongPassphrase):
+			emoji.Println(fmt.Sprintf(" :warning: $%s isn't the passphrase of notebook '%s'", notebookPassphraseEnvVar, args[0]))
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookNotEncrypted):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+// environment variables holding the passphrase of encrypted notebooks (see `notes encrypt-notebook`), and the
+// one to change it to
+const (
+	notebookPassphraseEnvVar    = "NOTES_NOTEBOOK_PASSPHRASE"
+	newNotebookPassphraseEnvVar = "NOTES_NEW_NOTEBOOK_PASSPHRASE"
+)
+
+/**
+ * Unlocks the encrypted notebooks whose passphrase is in $NOTES_NOTEBOOK_PASSPHRASE (others staying locked)
+ */
+func unlockNotebooks(db models.Datastore) {
+	passphrase := os.Getenv(notebookPassphraseEnvVar)
+	if passphrase == "" {
+		return
+	}
+	names, err := db.GetAllNotebookNames(models.WithArchivedNotebooks())
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, name := range names {
+		err := db.UnlockNotebook(name, passphrase)
+		if err != nil && !errors.Is(err, models.ErrNotebookNotEncrypted) && !errors.Is(err, models.ErrWrongPassphrase) {
+			log.Panic(err)
+		}
+	}
+}
+
+func init() {
+	root.AddCommand(encryptNotebookCommand)
+	root.AddCommand(notebookPassphraseCommand)
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var exportNotebookCommand = &cobra.Command{
+	Use:   "export-notebook <notebook>",
+	Short: "Export notes of a notebook",
+	Long: "Writes notes of a notebook as a stream of JSON documents, like `notes export-notebook blog -o blog.jsonl`. " +
+		"Filters pick the notes exported, like `--tag blog --from 2023-01-01 --to 2024-01-01`. " +
+		"Use `--encrypt` to encrypt the export with the passphrase in $" + passphraseEnvVar + " (or `--passphrase-file`)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts, err := exportFilterOptions()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		passphrase, err := encryptionPassphrase(exportEncrypt)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		var w io.Writer = os.Stdout
+		if exportNotebookOutput != "" {
+			file, err := os.Create(exportNotebookOutput)
+			if err != nil {
+				log.Panic(err)
+			}
+			defer file.Close()
+			w = file
+		}
+
+		if exportEncrypt {
+			err = db.ExportNotebookEncrypted(args[0], w, passphrase, opts...)
+		} else {
+			err = db.ExportNotebook(args[0], w, opts...)
+		}
+		switch {
+		case err == nil:
+			if exportNotebookOutput != "" {
+				emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' exported to '%s'", args[0], exportNotebookOutput))
+			}
+		case errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var importNotebookCommand = &cobra.Command{
+	Use:   "import-notebook <notebook> <file>",
+	Short: "Import notes exported with export-notebook",
+	Long: "Adds notes exported with `notes export-notebook` to a notebook, like `notes import-notebook blog blog.jsonl`. " +
+		"Use `--dedupe` to skip notes whose content the notebook already has. " +
+		"Encrypted exports are decrypted with the passphrase in $" + passphraseEnvVar + " (or `--passphrase-file`), " +
+		"and exports of encrypted notebooks with the notebook's passphrase in $" + notebookPassphraseEnvVar,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := os.Open(args[1])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		defer file.Close()
+		passphrase, err := encryptionPassphrase(false)
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		policy, err := importConflictPolicy()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		opts := models.ImportOptions{DedupeByContent: importDedupe, Passphrase: passphrase, OnConflict: policy,
+			ResumeFrom: models.ResumeToken(importResume), NotebookPassphrase: os.Getenv(notebookPassphraseEnvVar)}
+		report, err := db.ImportNotebook(args[0], file, opts)
+		switch {
+		case errors.Is(err, models.ErrInvalidResumeToken):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case errors.Is(err, models.ErrPassphraseRequired) && opts.Passphrase == "":
+			emoji.Println(" :warning: The export is encrypted: give its passphrase in $" + passphraseEnvVar + " or with --passphrase-file")
+			return
+		case errors.Is(err, models.ErrPassphraseRequired):
+			emoji.Println(" :warning: The export is of an encrypted notebook: give the notebook's passphrase in $" + notebookPassphraseEnvVar)
+			return
+		case errors.Is(err, models.ErrWrongPassphrase), errors.Is(err, models.ErrNotebookLocked),
+			errors.Is(err, models.ErrNotebookNotEncrypted):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil && !errors.Is(err, models.ErrInvalidNoteExport):
+			printResume(report)
+			log.Panic(err)
+		}
+		if report.Filter != nil {
+			emoji.Println(" :warning: The export holds only part of its notebook (it was filtered when exported)")
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Imported %d notes (%d duplicates)", report.Imported, report.Duplicates))
+		if report.Relations > 0 {
+			emoji.Println(fmt.Sprintf(" :pencil2: Recreated %d relations between them", report.Relations))
+		}
+		printConflicts(report.Conflicts)
+		for _, skipped := range report.Skipped {
+			emoji.Println(fmt.Sprintf(" :warning: Skipped '%s': %s", skipped.Title, skipped.Reason))
+		}
+		for _, warning := range report.Warnings {
+			emoji.Println(" :warning: " + warning)
+		}
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			printResume(report)
+		}
+	},
+}
+
+/**
+ * Options of ListNotes matching the filter flags of export-notebook
+ */
+func exportFilterOptions() ([]models.ListOption, error) {
+	var opts []models.ListOption
+	for _, tag := range exportNotebookTags {
+		opts = append(opts, models.WithTag(strings.TrimPrefix(tag, "#")))
+	}
+	if exportNotebookText != "" {
+		opts = append(opts, models.TextContains(exportNotebookText))
+	}
+	if exportNotebookLanguage != "" {
+		opts = append(opts, models.Language(exportNotebookLanguage))
+	}
+	var from, to time.Time
+	var err error
+	if exportNotebookFrom != "" {
+		if from, err = utils.ParseSince(exportNotebookFrom, time.Now(), time.Local); err != nil {
+			return nil, err
+		}
+	}
+	if exportNotebookTo != "" {
+		if to, err = utils.ParseSince(exportNotebookTo, time.Now(), time.Local); err != nil {
+			return nil, err
+		}
+	}
+	if !from.IsZero() || !to.IsZero() {
+		opts = append(opts, models.CreatedBetween(from, to))
+	}
+	if exportNotebookExpired {
+		opts = append(opts, models.WithExpired())
+	}
+	return opts, nil
+}
+
+var (
+	// file the notebook is exported to (stdout if empty)
+	exportNotebookOutput string
+	// filters picking the notes exported
+	exportNotebookTags     []string
+	exportNotebookText     string
+	exportNotebookLanguage string
+	exportNotebookFrom     string
+	exportNotebookTo       string
+	exportNotebookExpired  bool
+)
+
+func init() {
+	flags := exportNotebookCommand.Flags()
+	flags.StringVarP(&exportNotebookOutput, "output", "o", "", "file to write the notes to")
+	flags.StringArrayVar(&exportNotebookTags, "tag", nil, "only notes with this tag (may be repeated)")
+	flags.StringVar(&exportNotebookText, "text", "", "only notes containing this text")
+	flags.StringVar(&exportNotebookLanguage, "lang", "", "only notes written in this language (like 'en')")
+	flags.StringVar(&exportNotebookFrom, "from", "", "only notes created on or after this time (like 2023-01-01, 30d or 'last monday')")
+	flags.StringVar(&exportNotebookTo, "to", "", "only notes created before this time (like 2024-01-01 or 7d)")
+	flags.BoolVar(&exportNotebookExpired, "expired", false, "include expired notes that haven't been purged yet")
+	flags.BoolVar(&exportEncrypt, "encrypt", false, "encrypt the export with a passphrase")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase to encrypt with")
+	importNotebookCommand.Flags().StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase of an encrypted export")
+	importNotebookCommand.Flags().BoolVar(&importDedupe, "dedupe", false, "don't import notes whose content already exists in the notebook")
+	addConflictFlag(importNotebookCommand)
+	addResumeFlag(importNotebookCommand)
+	root.AddCommand(exportNotebookCommand)
+	root.AddCommand(importNotebookCommand)
+}
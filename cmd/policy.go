@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var policyCommand = &cobra.Command{
+	Use:   "policy <notebook>",
+	Short: "Set the archival policy of a notebook",
+	Long: "Sets how old notes of a notebook get archived (tagged '" + models.ArchivedTag + "') and archived ones deleted, " +
+		"like `notes policy clippings --archive-after 90d --delete-after 365d --exclude-tag keep`; age counts from a note's " +
+		"last change. Pinned (favorite) and read-only notes are never touched. Running without flags removes the policy; " +
+		"apply policies with `notes policy apply` (also done by the janitor, see `notes policy schedule`)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		policy := models.NotebookPolicy{ExcludeTags: policyExcludeTags}
+		for flag, age := range map[string]*time.Duration{"archive-after": &policy.ArchiveAfter, "delete-after": &policy.DeleteAfter} {
+			value, _ := cmd.Flags().GetString(flag)
+			if value == "" {
+				continue
+			}
+			var err error
+			if *age, err = utils.ParseDuration(value); err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: Invalid --%s '%s': give days (like 90d), weeks (like 2w) or a duration (like 12h)", flag, value))
+				return
+			}
+		}
+		switch err := db.SetNotebookPolicy(args[0], policy); {
+		case err == nil && policy.ArchiveAfter == 0 && policy.DeleteAfter == 0 && len(policy.ExcludeTags) == 0:
+			emoji.Println(fmt.Sprintf(" :pencil2: Removed policy of notebook '%s'", args[0]))
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' will %s", args[0], describePolicy(policy)))
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookArchived),
+			errors.Is(err, models.ErrInvalidNotebookPolicy):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var applyPoliciesCommand = &cobra.Command{
+	Use:   "apply",
+	Short: "Archive and delete notes as per notebook policies",
+	Long:  "Archives and deletes notes as per the policies of all notebooks; `--dry-run` only tells what would be done",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		report, err := db.ApplyPolicies(policyDryRun)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(report.Notebooks) == 0 {
+			emoji.Println(" :warning: No notebook has a policy")
+			return
+		}
+		verb := "Archived %d and deleted %d note(s)"
+		if policyDryRun {
+			verb = "Would archive %d and delete %d note(s)"
+		}
+		for _, notebook := range report.Notebooks {
+			line := fmt.Sprintf(" :pencil2: %s: "+verb, notebook.Notebook, notebook.Archived, notebook.Deleted)
+			var kept []string
+			for _, count := range []struct {
+				n    int
+				what string
+			}{{notebook.Pinned, "pinned"}, {notebook.Excluded, "excluded by tag"}, {notebook.Locked, "read-only"}} {
+				if count.n > 0 {
+					kept = append(kept, fmt.Sprintf("%d %s", count.n, count.what))
+				}
+			}
+			if len(kept) > 0 {
+				line += fmt.Sprintf(", leaving alone %s", strings.Join(kept, ", "))
+			}
+			emoji.Println(line)
+		}
+	},
+}
+
+var schedulePoliciesCommand = &cobra.Command{
+	Use:   "schedule",
+	Short: "Have the janitor apply notebook policies",
+	Long:  "Makes the janitor apply notebook policies on its every run; `--off` stops it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		if err := db.SchedulePolicies(!policyScheduleOff); err != nil {
+			log.Panic(err)
+		}
+		if policyScheduleOff {
+			emoji.Println(" :pencil2: The janitor no longer applies notebook policies")
+		} else {
+			emoji.Println(" :pencil2: The janitor applies notebook policies")
+		}
+	},
+}
+
+/**
+ * Policy for display, like "archive notes after 90d, delete archived ones after 365d, except tagged #keep"
+ */
+func describePolicy(policy models.NotebookPolicy) string {
+	var parts []string
+	if policy.ArchiveAfter > 0 {
+		parts = append(parts, "archive notes after "+describeRetention(policy.ArchiveAfter))
+	}
+	if policy.DeleteAfter > 0 {
+		parts = append(parts, "delete archived ones after "+describeRetention(policy.DeleteAfter))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "leave notes alone")
+	}
+	if len(policy.ExcludeTags) > 0 {
+		parts = append(parts, "except tagged "+strings.TrimSpace(formatTags(policy.ExcludeTags)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+var (
+	// tags of notes the policy leaves alone
+	policyExcludeTags []string
+	// only tell what applying policies would do
+	policyDryRun bool
+	// stop the janitor applying policies
+	policyScheduleOff bool
+)
+
+func init() {
+	flags := policyCommand.Flags()
+	flags.String("archive-after", "", "age past which notes are archived (like 90d)")
+	flags.String("delete-after", "", "age past which archived notes are deleted (like 365d)")
+	flags.StringSliceVar(&policyExcludeTags, "exclude-tag", nil, "tag of notes left alone (repeatable)")
+	applyPoliciesCommand.Flags().BoolVar(&policyDryRun, "dry-run", false, "only tell what would be done")
+	schedulePoliciesCommand.Flags().BoolVar(&policyScheduleOff, "off", false, "stop the janitor applying policies")
+	policyCommand.AddCommand(applyPoliciesCommand)
+	policyCommand.AddCommand(schedulePoliciesCommand)
+	root.AddCommand(policyCommand)
+}
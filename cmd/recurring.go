@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var recurringCommand = &cobra.Command{
+	Use:   "recurring",
+	Short: "Manage recurring rules, creating notes on a schedule",
+	Long: "Recurring rules create notes in a notebook every day, week or month, like a weekly review note every monday: " +
+		"`notes recurring add review journal --every weekly --on monday --tz Europe/Berlin --title 'Review {{week}}'`. " +
+		"Rules fire with `notes recurring run` (also done by the janitor, see `notes recurring schedule`), once per period",
+}
+
+var addRecurringCommand = &cobra.Command{
+	Use:   "add <name> <notebook>",
+	Short: "Add (or replace) a recurring rule",
+	Long: "Adds a rule creating the note of --title, --content and --tag (or the notes of the template --template) in the " +
+		"notebook every period of --every (daily, weekly --on a weekday, or monthly --on a day of month) in time zone --tz. " +
+		"Titles, contents and --key may use {{rule}}, {{notebook}}, {{date}}, {{year}}, {{month}}, {{day}}, {{weekday}} " +
+		"and {{week}}; a period whose key (" + models.DefaultRecurringDedupeKey + " by default) fired already is left " +
+		"alone. Periods missed between runs are skipped, or fired oldest first with --catch-up backfill",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		rule := models.RecurringRule{
+			Name:      args[0],
+			Notebook:  args[1],
+			Template:  recurringTemplate,
+			Title:     recurringTitle,
+			Content:   recurringContent,
+			Tags:      recurringTags,
+			Schedule:  models.RecurringSchedule{Frequency: models.RecurringFrequency(strings.ToLower(recurringEvery))},
+			TimeZone:  recurringTimeZone,
+			DedupeKey: recurringKey,
+			CatchUp:   models.RecurringCatchUp(strings.ToLower(recurringCatchUp)),
+		}
+		if recurringOn != "" {
+			if day, err := strconv.Atoi(recurringOn); err == nil {
+				rule.Schedule.DayOfMonth = day
+			} else {
+				rule.Schedule.Weekday = recurringOn
+			}
+		}
+		switch err := db.AddRecurringNote(rule); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Rule '%s' creates notes in '%s' %s", rule.Name, rule.Notebook, describeSchedule(rule)))
+		case errors.Is(err, models.ErrInvalidRecurringRule), errors.Is(err, models.ErrTemplateNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listRecurringCommand = &cobra.Command{
+	Use:   "ls",
+	Short: "List recurring rules",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		rules, err := db.ListRecurringNotes()
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(rules) == 0 {
+			emoji.Println(" :warning: No recurring rules")
+			return
+		}
+		for _, rule := range rules {
+			what := fmt.Sprintf("'%s'", rule.Title)
+			if rule.Template != "" {
+				what = fmt.Sprintf("template '%s'", rule.Template)
+			}
+			line := fmt.Sprintf(" :pencil2: %s: %s in '%s' %s", rule.Name, what, rule.Notebook, describeSchedule(rule))
+			if !rule.LastPeriod.IsZero() {
+				line += ", last for " + rule.LastPeriod.Format("2006-01-02")
+			}
+			emoji.Println(line)
+		}
+	},
+}
+
+var removeRecurringCommand = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove a recurring rule",
+	Long:  "Removes a recurring rule; notes it created are kept",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		switch err := db.DeleteRecurringNote(args[0]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Removed recurring rule '%s'", args[0]))
+		case errors.Is(err, models.ErrRecurringRuleNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var runRecurringCommand = &cobra.Command{
+	Use:   "run",
+	Short: "Create the notes recurring rules are due for",
+	Long:  "Fires every recurring rule for the periods due since its last run; `--at` runs as of another time (like 2024-03-01 9:00)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		now := time.Now()
+		if recurringAt != "" {
+			var err error
+			if now, err = utils.ParseSince(recurringAt, now, time.Local); err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: Invalid --at '%s'", recurringAt))
+				return
+			}
+		}
+		report, err := db.RunRecurring(now)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, firing := range report.Fired {
+			emoji.Println(fmt.Sprintf(" :pencil2: %s: created %d note(s) for %s", firing.Rule, len(firing.Notes), firing.Key))
+		}
+		for _, failure := range report.Failed {
+			emoji.Println(fmt.Sprintf(" :warning: %s: %s", failure.Rule, failure.Reason))
+		}
+		if len(report.Fired) == 0 && len(report.Failed) == 0 {
+			emoji.Println(" :pencil2: No rule is due")
+		}
+		if report.Skipped > 0 || report.Duplicates > 0 {
+			emoji.Println(fmt.Sprintf(" :pencil2: Skipped %d missed and %d already fired period(s)", report.Skipped, report.Duplicates))
+		}
+	},
+}
+
+var scheduleRecurringCommand = &cobra.Command{
+	Use:   "schedule",
+	Short: "Have the janitor run recurring rules",
+	Long:  "Makes the janitor run recurring rules on its every run; `--off` stops it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		if err := db.ScheduleRecurring(!recurringScheduleOff); err != nil {
+			log.Panic(err)
+		}
+		if recurringScheduleOff {
+			emoji.Println(" :pencil2: The janitor no longer runs recurring rules")
+		} else {
+			emoji.Println(" :pencil2: The janitor runs recurring rules")
+		}
+	},
+}
+
+/**
+ * Schedule of a rule for display, like "weekly on monday (Europe/Berlin)"
+ */
+func describeSchedule(rule models.RecurringRule) string {
+	schedule := string(rule.Schedule.Frequency)
+	switch rule.Schedule.Frequency {
+	case models.RecurringWeekly:
+		schedule += " on " + strings.ToLower(rule.Schedule.Weekday)
+	case models.RecurringMonthly:
+		schedule += fmt.Sprintf(" on day %d", rule.Schedule.DayOfMonth)
+	}
+	schedule += fmt.Sprintf(" (%s)", rule.TimeZone)
+	if rule.CatchUp == models.RecurringBackfill {
+		schedule += ", backfilling missed periods"
+	}
+	return schedule
+}
+
+var (
+	// how often, and on which weekday or day of month, a rule fires
+	recurringEvery, recurringOn string
+	// IANA time zone of a rule
+	recurringTimeZone string
+	// note created by a rule, or the template whose notes are
+	recurringTitle, recurringContent, recurringTemplate string
+	recurringTags                                       []string
+	// dedupe key of a rule
+	recurringKey string
+	// skip or backfill missed periods
+	recurringCatchUp string
+	// time to run rules as of
+	recurringAt string
+	// stop the janitor running rules
+	recurringScheduleOff bool
+)
+
+func init() {
+	flags := addRecurringCommand.Flags()
+	flags.StringVar(&recurringEvery, "every", "", "daily, weekly or monthly")
+	flags.StringVar(&recurringOn, "on", "", "weekday of weekly rules (like monday), day of month of monthly ones (like 15)")
+	flags.StringVar(&recurringTimeZone, "tz", "", "time zone periods are in (like Europe/Berlin or UTC)")
+	flags.StringVar(&recurringTitle, "title", "", "title of the note created")
+	flags.StringVar(&recurringContent, "content", "", "content of the note created")
+	flags.StringSliceVar(&recurringTags, "tag", nil, "tag of the note created (repeatable)")
+	flags.StringVar(&recurringTemplate, "template", "", "template whose notes are created instead")
+	flags.StringVar(&recurringKey, "key", "", "dedupe key, a period whose key fired already being left alone")
+	flags.StringVar(&recurringCatchUp, "catch-up", string(models.RecurringSkip), "skip or backfill periods missed between runs")
+	runRecurringCommand.Flags().StringVar(&recurringAt, "at", "", "time to run as of")
+	scheduleRecurringCommand.Flags().BoolVar(&recurringScheduleOff, "off", false, "stop the janitor running recurring rules")
+	recurringCommand.AddCommand(addRecurringCommand, listRecurringCommand, removeRecurringCommand, runRecurringCommand,
+		scheduleRecurringCommand)
+	root.AddCommand(recurringCommand)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var relateCommand = &cobra.Command{
+	Use:   "relate <notebook> <noteId> <kind> <notebook> <noteId>",
+	Short: "Relate a note to another",
+	Long: "Relates a note to another (of any notebook) by a relation of given kind, like `notes relate work 3 blocks work 7`. " +
+		"`--remove` removes the relation instead",
+	Args: noteArgs(cobra.ExactArgs(5), 0, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0, 3)
+		fromId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		toId, err := utils.ParseUInt64(args[4])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		from, to, kind := models.NoteRef{Notebook: args[0], Id: fromId}, models.NoteRef{Notebook: args[3], Id: toId}, args[2]
+		change, done := db.AddRelation, "related"
+		if relateRemove {
+			change, done = db.RemoveRelation, "no longer related"
+		}
+		switch err := change(from, to, kind); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: %s %s %s (%s)", from, kind, to, done))
+		case errors.Is(err, models.ErrInvalidRelation), errors.Is(err, models.ErrRelationNotFound),
+			errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var relationsCommand = &cobra.Command{
+	Use:   "relations <notebook> <noteId>",
+	Short: "List relations of a note",
+	Long: "Lists relations going from and to a note, like `notes relations work 3 --kind blocks --direction in`. " +
+		"`--direction` is one of out, in or any (the default)",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		directions := map[string]models.Direction{"out": models.Outgoing, "in": models.Incoming, "any": models.AnyDirection}
+		direction, ok := directions[relationsDirection]
+		if !ok {
+			emoji.Println(fmt.Sprintf(" :warning: unknown direction '%s' (out, in or any)", relationsDirection))
+			return
+		}
+		db := setupDatabase()
+
+		relations, err := db.GetRelations(models.NoteRef{Notebook: args[0], Id: noteId}, relationsKind, direction)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, relation := range relations {
+			fmt.Printf(" %s %s %s\n", relation.From, relation.Kind, relation.To)
+		}
+	},
+}
+
+var (
+	// remove the relation rather than adding it
+	relateRemove bool
+	// only relations of this kind
+	relationsKind string
+	// only relations going this way (out, in or any)
+	relationsDirection string
+)
+
+func init() {
+	relateCommand.Flags().BoolVar(&relateRemove, "remove", false, "remove the relation")
+	relationsCommand.Flags().StringVar(&relationsKind, "kind", "", "only relations of this kind")
+	relationsCommand.Flags().StringVar(&relationsDirection, "direction", "any", "only relations going out, in or any")
+	root.AddCommand(relateCommand)
+	root.AddCommand(relationsCommand)
+}
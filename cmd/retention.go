@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var retentionCommand = &cobra.Command{
+	Use:   "retention",
+	Short: "Show the retention policy",
+	Long: "Shows how long the undo log, history of notes and access times are kept around. " +
+		"Change it with `notes retention set`, apply it with `notes retention apply` (also done by the janitor)",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		policy, err := db.GetRetentionPolicy()
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf(" changelog\t%s\n", describeRetention(policy.Changelog))
+		fmt.Printf(" history\t%s\n", describeRetention(policy.History))
+		fmt.Printf(" access log\t%s\n", describeRetention(policy.AccessLog))
+		if policy.MaxRevisionsPerNote > 0 {
+			fmt.Printf(" revisions\t%d per note\n", policy.MaxRevisionsPerNote)
+		} else {
+			fmt.Println(" revisions\tno limit")
+		}
+	},
+}
+
+var setRetentionCommand = &cobra.Command{
+	Use:   "set",
+	Short: "Change the retention policy",
+	Long: "Changes how long records are kept, like `notes retention set --history 90d --max-revisions 20`; " +
+		"a duration of 0 keeps records forever. Settings not given are left as they are",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		policy, err := db.GetRetentionPolicy()
+		if err != nil {
+			log.Panic(err)
+		}
+		for flag, duration := range map[string]*time.Duration{
+			"changelog": &policy.Changelog, "history": &policy.History, "access-log": &policy.AccessLog,
+		} {
+			if !cmd.Flags().Changed(flag) {
+				continue
+			}
+			value, _ := cmd.Flags().GetString(flag)
+			if *duration, err = utils.ParseDuration(value); err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: Invalid --%s '%s': give days (like 90d), weeks (like 2w) or a duration (like 12h)", flag, value))
+				return
+			}
+		}
+		if cmd.Flags().Changed("max-revisions") {
+			policy.MaxRevisionsPerNote = retentionMaxRevisions
+		}
+		if err := db.SetRetentionPolicy(policy); err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(" :pencil2: Retention policy updated")
+	},
+}
+
+var applyRetentionCommand = &cobra.Command{
+	Use:   "apply",
+	Short: "Prune records as per the retention policy",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		report, err := db.ApplyRetention()
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Removed %d changelog, %d history and %d access log record(s) in %v",
+			report.Changelog, report.History, report.AccessLog, report.Took.Round(time.Millisecond)))
+	},
+}
+
+/**
+ * Retention period for display
+ */
+func describeRetention(period time.Duration) string {
+	switch {
+	case period <= 0:
+		return "forever"
+	case period%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", period/(24*time.Hour))
+	}
+	return period.String()
+}
+
+var (
+	// number of past revisions kept per note
+	retentionMaxRevisions int
+)
+
+func init() {
+	flags := setRetentionCommand.Flags()
+	flags.String("changelog", "", "how long undo log entries are kept (like 30d)")
+	flags.String("history", "", "how long past revisions of notes are kept (like 90d)")
+	flags.String("access-log", "", "how long access times of notes are kept (like 180d)")
+	flags.IntVar(&retentionMaxRevisions, "max-revisions", 0, "past revisions kept per note (0 for no limit)")
+	retentionCommand.AddCommand(setRetentionCommand)
+	retentionCommand.AddCommand(applyRetentionCommand)
+	root.AddCommand(retentionCommand)
+}
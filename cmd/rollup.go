@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var rollupCommand = &cobra.Command{
+	Use:   "rollup <notebook> [day]",
+	Short: "Sum up a day's notes in a rollup note",
+	Long: "Writes a note listing the notes created or updated on a day (today if not given) into a notebook, " +
+		"like `notes rollup journal yesterday --source work --source ideas` (all notebooks if no `--source` is given). " +
+		"Running it again for the same day updates that day's rollup, so it can be run from cron",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		dayText := "today"
+		if len(args) == 2 {
+			dayText = args[1]
+		}
+		day, err := utils.ParseSince(dayText, time.Now(), time.Local)
+		if err != nil {
+			log.Fatal(err)
+		}
+		note, err := db.GenerateDailyRollup(args[0], day, rollupSources)
+		if errors.Is(err, models.ErrNotebookArchived) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		rollup, err := db.GetDailyRollup(args[0], day)
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Rollup of %s (%d notes from %d notebooks) is note %d of notebook '%s'",
+			rollup.Day, rollup.Notes, rollup.Sources, note.Id, args[0]))
+	},
+}
+
+var (
+	// notebooks whose notes are rolled up (all if none)
+	rollupSources []string
+)
+
+func init() {
+	rollupCommand.Flags().StringArrayVar(&rollupSources, "source", nil, "notebook whose notes are rolled up (may be repeated)")
+	root.AddCommand(rollupCommand)
+}
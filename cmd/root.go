@@ -7,10 +7,28 @@ import (
 )
 
 var root = &cobra.Command{
-	Use:           "notes",
-	Short:         "Jot things down quickly from the command line",
+	Use:   "notes",
+	Short: "Jot things down quickly from the command line",
+	Long: "Jot things down quickly from the command line.\n\n" +
+		"Commands taking a note as `<notebook> <noteId>` take its short id (like `wk-3f`, as printed by " +
+		"`notes ls` and `notes search`) too",
 	SilenceErrors: true,
 	SilenceUsage:  true,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		closeDatabase()
+	},
+}
+
+var (
+	// path of database file supplied via '--db' flag
+	dbPath string
+	// leave out (and warn about) corrupt notes instead of failing
+	skipCorrupt bool
+)
+
+func init() {
+	root.PersistentFlags().StringVar(&dbPath, "db", "", "path of the notes database file")
+	root.PersistentFlags().BoolVar(&skipCorrupt, "skip-corrupt", false, "skip notes that can't be read instead of failing")
 }
 
 // Register adds a new command
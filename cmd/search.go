@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var searchCommand = &cobra.Command{
+	Use:   "search [notebook] [text]",
+	Short: "Search notes",
+	Long: "Finds notes having all words of given text, like `notes search --notebook work --tag invoice --since 30d " +
+		"--archived=false --limit 20 payment` (or `notes search work payment`); all notebooks are searched " +
+		"unless one is given. Notes having the words in their first line (title) come first, then notes having them " +
+		"in tags, then elsewhere; `--min-score` leaves out lower ranked ones, and `--unranked` prints notes as " +
+		"they are found instead.\n\n" +
+		"Results are printed by short id (like `wk-3f`) with a snippet, or as per `--format` (a Go template over the result, like " +
+		"'{{.Notebook}}/{{.Note.Id}}: {{.Note.Title}}'), or as a JSON document per line with `--output json`. " +
+		"Exits with status 2 when nothing matched.\n\n" +
+		"Without text (or with `--sort` / `--after`), notes of the notebook are listed by the given order instead, " +
+		"a cursor for `--after` being printed when more notes remain",
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput = searchOutput == "json"
+		// everything given is checked before opening the DB
+		notebookName, text, err := searchArgs(args)
+		if err == nil {
+			err = checkSearchFlags(cmd)
+		}
+		var printer *searchPrinter
+		if err == nil {
+			printer, err = newSearchPrinter(searchFormat, searchOutput)
+		}
+		if err != nil {
+			if jsonOutput {
+				exitWithJSONError(models.ErrorInfo{Code: models.CodeValidation, Message: err.Error()})
+			}
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			os.Exit(1)
+		}
+		db := setupDatabase()
+		openedDatabase.SetEncryptedSearch(searchDecrypt)
+		printer.db = db
+
+		if text == "" || cmd.Flags().Changed("sort") || searchAfter != "" {
+			listByOrder(db, notebookName, text, printer)
+		} else if searchUnranked {
+			searchStreamed(db, notebookName, text, printer)
+		} else {
+			searchRanked(db, notebookName, text, printer)
+		}
+		if searchSkippedBinary > 0 && printer.output == "" {
+			emoji.Println(fmt.Sprintf(" :warning: %d binary-ish note(s) left out", searchSkippedBinary))
+		}
+		if printer.printed == 0 {
+			closeDatabase()
+			os.Exit(2)
+		}
+	},
+}
+
+/**
+ * Notebook and text of a search, from '--notebook' and positional args ('[notebook] text', or 'text' with '--notebook')
+ */
+func searchArgs(args []string) (string, string, error) {
+	switch {
+	case searchNotebook != "" && len(args) > 1:
+		return "", "", errors.New("Give the notebook either with --notebook or as an argument, not both")
+	case searchNotebook != "" && len(args) == 1:
+		return searchNotebook, args[0], nil
+	case len(args) == 2:
+		return args[0], args[1], nil
+	case len(args) == 1:
+		return "", args[0], nil
+	}
+	return searchNotebook, "", nil
+}
+
+/**
+ * Validates flags of search that don't go through a parser of their own
+ */
+func checkSearchFlags(cmd *cobra.Command) error {
+	searchArchivedSet = cmd.Flags().Changed("archived")
+	if _, ok := sortOrders[searchSort]; !ok {
+		return fmt.Errorf("Unknown sort order '%s'", searchSort)
+	}
+	if searchSince != "" {
+		if _, err := utils.ParseSince(searchSince, time.Now(), time.Local); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Lists notes of a notebook (containing given text, if any) by the order of '--sort', a page at a time
+ */
+func listByOrder(db models.Datastore, notebookName string, text string, printer *searchPrinter) {
+	if notebookName == "" {
+		if jsonOutput {
+			exitWithJSONError(models.ErrorInfo{Code: models.CodeValidation, Message: "a notebook is needed to list its notes"})
+		}
+		emoji.Println(" :warning: Give a notebook to list its notes (or text to search for)")
+		return
+	}
+	query := db.Query(notebookName).SortBy(sortOrders[searchSort]).Limit(searchLimit).After(models.Cursor(searchAfter))
+	if text != "" {
+		query.TextContains(text)
+	}
+	for _, tag := range searchTags {
+		query.WithTag(tag)
+	}
+	if searchSince != "" {
+		since, _ := utils.ParseSince(searchSince, time.Now(), time.Local)
+		// (so that cursors of --after stay valid as a relative --since moves on)
+		query.Filter(models.UpdatedSince(searchSince, since))
+	}
+	notes, next, err := query.Execute()
+	switch {
+	case errors.Is(err, models.ErrInvalidQuery), errors.Is(err, models.ErrEncryptedSearch):
+		reportJSONError(err)
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		return
+	case err != nil:
+		reportJSONError(err)
+		log.Panic(err)
+	}
+	for _, note := range notes {
+		if !matchesArchived(note) {
+			continue
+		}
+		printer.print(models.SearchResult{Ref: models.NoteRef{Notebook: notebookName, Id: note.Id}, Note: note}, nil)
+	}
+	if next != "" && printer.output == "" {
+		emoji.Println(fmt.Sprintf(" More notes: --after %s", next))
+	}
+}
+
+/**
+ * Lists notes containing given text, best ranked first
+ * (ranking needs every match, so nothing can be printed before the search is done)
+ */
+func searchRanked(db models.Datastore, notebookName string, text string, printer *searchPrinter) {
+	var results []models.SearchResult
+	var err error
+	if notebookName != "" {
+		results, err = db.SearchNotes(notebookName, text, searchOptions()...)
+	} else {
+		results, err = db.SearchAllNotebooks(text, searchOptions()...)
+	}
+	if errors.Is(err, models.ErrEncryptedSearch) {
+		reportJSONError(err)
+		emoji.Println(fmt.Sprintf(" :warning: %v (with --decrypt)", err))
+		return
+	}
+	if err != nil {
+		reportJSONError(err)
+		log.Panic(err)
+	}
+	filter := newSearchFilter()
+	terms := strings.Fields(text)
+	for _, result := range results {
+		if searchLimit > 0 && printer.printed == searchLimit {
+			break
+		}
+		if filter(result.Note) {
+			printer.print(result, terms)
+		}
+	}
+}
+
+/**
+ * Lists notes containing given text as they are found (in order of ids), stopping once '--limit' is reached
+ */
+func searchStreamed(db models.Datastore, notebookName string, text string, printer *searchPrinter) {
+	filter := newSearchFilter()
+	terms := strings.Fields(text)
+	err := db.SearchStream(notebookName, text, func(result models.SearchResult) (bool, error) {
+		if filter(result.Note) {
+			printer.print(result, terms)
+		}
+		return searchLimit == 0 || printer.printed < searchLimit, nil
+	}, searchOptions()...)
+	if errors.Is(err, models.ErrEncryptedSearch) {
+		reportJSONError(err)
+		emoji.Println(fmt.Sprintf(" :warning: %v (with --decrypt)", err))
+		return
+	}
+	if err != nil {
+		reportJSONError(err)
+		log.Panic(err)
+	}
+}
+
+/**
+ * Options of ranked / streamed search ('--min-score', '--archived-notebooks')
+ */
+func searchOptions() []models.SearchOption {
+	opts := []models.SearchOption{models.MinScore(searchMinScore)}
+	if searchArchivedNotebooks {
+		opts = append(opts, models.IncludeArchivedNotebooks())
+	}
+	if searchSkipBinary {
+		opts = append(opts, models.SkipBinaryNotes(&searchSkippedBinary))
+	}
+	return opts
+}
+
+/**
+ * Predicate of the filters of ranked / streamed search ('--tag', '--since', '--archived')
+ */
+func newSearchFilter() func(models.Note) bool {
+	var since time.Time
+	if searchSince != "" {
+		since, _ = utils.ParseSince(searchSince, time.Now(), time.Local)
+	}
+	return func(note models.Note) bool {
+		updated := note.UpdatedAt
+		if updated.IsZero() {
+			updated = note.CreatedAt
+		}
+		return hasAllTags(note, searchTags) && !updated.Before(since) && matchesArchived(note)
+	}
+}
+
+/**
+ * Whether a note passes '--archived' (archived notes being the ones tagged 'archived', as by `notes keep`)
+ */
+func matchesArchived(note models.Note) bool {
+	if !searchArchivedSet {
+		return true
+	}
+	return hasAllTags(note, []string{"archived"}) == searchArchived
+}
+
+func hasAllTags(note models.Note, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, noteTag := range note.Tags {
+			if noteTag == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * What '--format' templates are executed with (and what '--output json' prints): the result,
+ * along with the notebook's name and a snippet of the note around the words searched for
+ */
+type searchHit struct {
+	models.SearchResult
+	Notebook string `json:"notebook"`
+	ShortID  string `json:"short_id"`
+	Snippet  string `json:"snippet"`
+}
+
+/**
+ * Prints results of search as per '--format' / '--output', counting them
+ */
+type searchPrinter struct {
+	format  *template.Template
+	output  string
+	encoder *json.Encoder
+	printed int
+	// DB short ids of results are looked up in (none while '--format' is tried)
+	db models.Datastore
+}
+
+/**
+ * Checks '--format' / '--output', failing with a helpful error if they can't be used
+ * Templates are tried on an empty result, so that unknown fields are caught before anything is searched
+ */
+func newSearchPrinter(format string, output string) (*searchPrinter, error) {
+	printer := &searchPrinter{output: output}
+	switch output {
+	case "":
+	case "json":
+		printer.encoder = json.NewEncoder(os.Stdout)
+	default:
+		return nil, fmt.Errorf("Unknown --output '%s': only 'json' is supported", output)
+	}
+	if format == "" {
+		return printer, nil
+	}
+	if output != "" {
+		return nil, errors.New("Give either --format or --output, not both")
+	}
+	var err error
+	if printer.format, err = template.New("format").Parse(format); err != nil {
+		return nil, fmt.Errorf("Invalid --format template: %v", err)
+	}
+	if err := printer.format.Execute(ioutil.Discard, searchHit{}); err != nil {
+		return nil, fmt.Errorf("Invalid --format template: %v (fields are .Notebook, .ShortID, .Snippet, .Score, .Ref and .Note, "+
+			"like .Note.Id, .Note.Title, .Note.Content or .Note.Tags)", err)
+	}
+	return printer, nil
+}
+
+/**
+ * Prints a result (terms being the words searched for, for its snippet)
+ */
+func (p *searchPrinter) print(result models.SearchResult, terms []string) {
+	hit := searchHit{SearchResult: result, Notebook: result.Ref.Notebook, Snippet: snippet(result.Note.Content, terms)}
+	if p.db != nil {
+		hit.ShortID = shortIDOf(p.db, result.Ref)
+	}
+	p.printed++
+	switch {
+	case p.encoder != nil:
+		if err := p.encoder.Encode(hit); err != nil {
+			log.Panic(err)
+		}
+	case p.format != nil:
+		if err := p.format.Execute(os.Stdout, hit); err != nil {
+			log.Panic(err)
+		}
+		fmt.Println()
+	default:
+		line := fmt.Sprintf(" %s	%s/%d	%s%s", hit.ShortID, hit.Notebook, hit.Note.Id, firstLine(hit.Note.Content), formatTags(hit.Note.Tags))
+		if result.Score > 0 {
+			line += fmt.Sprintf("	(%g)", result.Score)
+		}
+		emoji.Println(line)
+		if hit.Snippet != "" && strings.IndexByte(hit.Note.Content, '\n') >= 0 {
+			fmt.Println("   	" + hit.Snippet)
+		}
+	}
+}
+
+// characters of context shown on either side of the word a snippet is centered on
+const snippetContext = 30
+
+/**
+ * Part of content around the first of given terms found in it (case-insensitively), on a single line
+ * Empty if there are no terms, or none of them is found
+ */
+func snippet(content string, terms []string) string {
+	text := []rune(content)
+	lowered := make([]rune, len(text))
+	for i, r := range text {
+		lowered[i] = unicode.ToLower(r)
+	}
+	for _, term := range terms {
+		at := strings.Index(string(lowered), strings.ToLower(term))
+		if at < 0 {
+			continue
+		}
+		start := len([]rune(string(lowered)[:at])) - snippetContext
+		end := start + 2*snippetContext + len([]rune(term))
+		prefix, suffix := "..", ".."
+		if start <= 0 {
+			start, prefix = 0, ""
+		}
+		if end >= len(text) {
+			end, suffix = len(text), ""
+		}
+		return prefix + strings.Join(strings.Fields(string(text[start:end])), " ") + suffix
+	}
+	return ""
+}
+
+// sort orders accepted by '--sort'
+var sortOrders = map[string]models.SortOrder{}
+
+var (
+	// notebook searched (all notebooks if empty, unless given as an argument)
+	searchNotebook string
+	// tags notes must have
+	searchTags []string
+	// how recently notes must have been updated (like '30d')
+	searchSince string
+	// whether notes must (or mustn't) be archived; only applies if the flag was given
+	searchArchived    bool
+	searchArchivedSet bool
+	// whether searching all notebooks includes archived notebooks
+	searchArchivedNotebooks bool
+	// order of results
+	searchSort string
+	// maximum number of notes listed
+	searchLimit int
+	// cursor to continue from
+	searchAfter string
+	// least score of ranked results
+	searchMinScore float64
+	// whether results are printed as they are found, rather than ranked
+	searchUnranked bool
+	// template results are printed with
+	searchFormat string
+	// format results are printed in ('json'), instead of for reading
+	searchOutput string
+	// whether encrypted content is searched (decrypting every note searched)
+	searchDecrypt bool
+	// whether binary-ish notes are left out, and how many matching ones were
+	searchSkipBinary    bool
+	searchSkippedBinary int
+)
+
+func init() {
+	for _, order := range []models.SortOrder{models.IdAsc, models.IdDesc, models.CreatedAtAsc,
+		models.CreatedAtDesc, models.UpdatedAtAsc, models.UpdatedAtDesc} {
+		sortOrders[order.String()] = order
+	}
+	searchCommand.Flags().StringVar(&searchNotebook, "notebook", "", "notebook to search (all notebooks if omitted)")
+	searchCommand.Flags().StringSliceVar(&searchTags, "tag", nil, "only notes having this tag (repeatable)")
+	searchCommand.Flags().StringVar(&searchSince, "since", "", "only notes updated since: a duration ago (30d, 12h), a day (yesterday, last monday) or a date")
+	searchCommand.Flags().BoolVar(&searchArchived, "archived", false, "only archived notes (or, with --archived=false, only others)")
+	searchCommand.Flags().BoolVar(&searchArchivedNotebooks, "archived-notebooks", false, "also search archived notebooks (when searching all notebooks)")
+	searchCommand.Flags().StringVar(&searchSort, "sort", "id", "order: id, created_at or updated_at, prefixed with '-' for descending")
+	searchCommand.Flags().IntVar(&searchLimit, "limit", 20, "maximum number of notes listed (0 for all)")
+	searchCommand.Flags().StringVar(&searchAfter, "after", "", "continue after this cursor")
+	searchCommand.Flags().Float64Var(&searchMinScore, "min-score", 0, "leave out ranked notes scoring less")
+	searchCommand.Flags().BoolVar(&searchUnranked, "unranked", false, "print notes as they are found instead of ranked")
+	searchCommand.Flags().StringVar(&searchFormat, "format", "", "Go template to print results with, like '{{.Notebook}}/{{.Note.Id}}: {{.Note.Title}}'")
+	searchCommand.Flags().StringVar(&searchOutput, "output", "", "print results as JSON documents, one per line ('json')")
+	searchCommand.Flags().BoolVar(&searchSkipBinary, "skip-binary", false, "leave out binary-ish notes (like ones holding NUL bytes)")
+	searchCommand.Flags().BoolVar(&searchDecrypt, "decrypt", false, "search content even if it's encrypted (decrypting every note searched)")
+	root.AddCommand(searchCommand)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * What f prints on stdout
+ */
+func captureStdout(t *testing.T, f func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	printed := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		printed <- out
+	}()
+	f()
+	w.Close()
+	return <-printed
+}
+
+/**
+ * DB the golden searches run against: notes with titles, tags, snippets longer than their context,
+ * and a notebook of its own (for short ids)
+ */
+func seedSearchFixture(t *testing.T) *models.DB {
+	t.Helper()
+	db := notestest.NewDB(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"home": {"Budget\nrent, groceries and the rest of the household budget for the year"},
+		"work": {
+			"# Budget review\nwith the team, going through every line of the budget before the board meets",
+			"meeting notes",
+			"the budget is tight",
+			"groceries\nmilk",
+		},
+	}})
+	notestest.MustAddNote(t, db, "work", models.Note{Content: "Offsite\nagenda :tada: and costs",
+		Tags: []string{"budget", "q3"}, CreatedAt: notestest.DefaultStart.Add(time.Hour)})
+	return db
+}
+
+func TestSearchDefaultRendering(t *testing.T) {
+	db := seedSearchFixture(t)
+	for _, test := range []struct {
+		name     string
+		notebook string
+		text     string
+		run      func(db models.Datastore, notebookName string, text string, printer *searchPrinter)
+	}{
+		{"ranked", "", "budget", searchRanked},
+		{"ranked_notebook", "work", "budget", searchRanked},
+		{"unranked", "work", "budget", searchStreamed},
+		{"listed", "work", "", listByOrder},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			printer, err := newSearchPrinter("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			printer.db = db
+			out := captureStdout(t, func() { test.run(db, test.notebook, test.text, printer) })
+			notestest.AssertGolden(t, out, filepath.Join("testdata", "search_"+test.name+".golden"))
+		})
+	}
+}
+
+func TestSearchFormatAndJSONRendering(t *testing.T) {
+	db := seedSearchFixture(t)
+	for _, test := range []struct {
+		name   string
+		format string
+		output string
+	}{
+		{"format", "{{.Notebook}}/{{.Note.Id}} {{.ShortID}}: {{.Snippet}}", ""},
+		{"json", "", "json"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := captureStdout(t, func() {
+				// (the JSON encoder writes to stdout as it was when the printer was made)
+				printer, err := newSearchPrinter(test.format, test.output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				printer.db = db
+				searchRanked(db, "work", "budget", printer)
+			})
+			// (clocks count writes by the DB's device id, random to every DB)
+			out = bytes.ReplaceAll(out, []byte(db.DeviceID()), []byte("device"))
+			notestest.AssertGolden(t, out, filepath.Join("testdata", "search_"+test.name+".golden"))
+		})
+	}
+}
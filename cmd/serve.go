@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/noculture/notes/api"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var serveCommand = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve notes over HTTP",
+	Long: "Serves the REST API, like `notes serve --addr localhost:8080`. " +
+		"Endpoints are listed at '/', and described by the OpenAPI document at '/openapi.json'. " +
+		"A web UI for browsing, searching and writing notes is served at '/ui'. " +
+		"With `--auth`, requests must carry an API token (see `notes token`) as 'Authorization: Bearer <token>'",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+		if serveNoteCache > 0 {
+			openedDatabase.SetNoteCache(serveNoteCache, serveNoteCacheBytes)
+		}
+		openedDatabase.SetSlowOpThreshold(serveSlowOpThreshold)
+		openedDatabase.PersistSlowOps(servePersistSlowOps)
+		if serveIndexMaintenance > 0 {
+			openedDatabase.StartIndexMaintainer(serveIndexMaintenance, serveIndexMaintenanceBudget)
+		}
+
+		var handler http.Handler = api.NewHandler(db)
+		if serveAuth {
+			handler = api.Authenticate(db, handler)
+		}
+		server := &http.Server{Addr: serveAddr, Handler: handler}
+		// shut down gracefully on Ctrl-C / SIGTERM, so that the database is closed (and free for others)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			server.Shutdown(context.Background())
+		}()
+
+		emoji.Println(fmt.Sprintf(" :pencil2: Serving on http://%s", serveAddr))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// address the API is served on
+	serveAddr string
+	// whether requests must carry an API token
+	serveAuth bool
+	// number of notes cached in memory (0 for no cache)
+	serveNoteCache int
+	// duration above which operations are logged as slow (0 for no slow-op log)
+	serveSlowOpThreshold time.Duration
+	// whether slow operations are stored in the database too
+	servePersistSlowOps bool
+	// interval of index maintenance cycles (0 for none), and time each of them may take
+	serveIndexMaintenance       time.Duration
+	serveIndexMaintenanceBudget time.Duration
+)
+
+/**
+ * Bytes of notes cached by `serve --note-cache`
+ */
+const serveNoteCacheBytes = 64 << 20
+
+func init() {
+	serveCommand.Flags().StringVar(&serveAddr, "addr", "localhost:8080", "address to listen on")
+	serveCommand.Flags().BoolVar(&serveAuth, "auth", false, "require an API token (see `notes token`)")
+	serveCommand.Flags().IntVar(&serveNoteCache, "note-cache", 0, "number of notes to cache in memory (up to 64MB)")
+	serveCommand.Flags().DurationVar(&serveSlowOpThreshold, "slow-op-threshold", 0, "log operations slower than this (see '/debug/slow-ops'); 0 for none")
+	serveCommand.Flags().BoolVar(&servePersistSlowOps, "persist-slow-ops", false, "store slow operations in the database too (see `notes slow-ops`)")
+	serveCommand.Flags().DurationVar(&serveIndexMaintenance, "index-maintenance", 0, "check (and repair) index entries of a slice of notes this often (see '/debug/index-maintenance'); 0 for never")
+	serveCommand.Flags().DurationVar(&serveIndexMaintenanceBudget, "index-maintenance-budget", 100*time.Millisecond, "time a round of index maintenance may take")
+	root.AddCommand(serveCommand)
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var settingsCommand = &cobra.Command{
+	Use:   "settings",
+	Short: "Change database settings",
+	Long:  "Change settings persisted in the notes database itself (as opposed to the config file)",
+}
+
+var caseInsensitiveCommand = &cobra.Command{
+	Use:   "case-insensitive <on|off>",
+	Short: "Compare notebook names case-insensitively",
+	Long: "Turns case-insensitive notebook names on or off. Existing notebooks are migrated; " +
+		"if some of them differ only in case (like 'Work' and 'work'), they are reported and nothing is changed",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var enabled bool
+		switch strings.ToLower(args[0]) {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		default:
+			emoji.Println(" :warning: Specify either 'on' or 'off'")
+			return
+		}
+
+		db := setupDatabase()
+		collisions, err := db.SetCaseInsensitiveNotebooks(enabled)
+		switch err {
+		case nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Case-insensitive notebook names turned %s", strings.ToLower(args[0])))
+		case models.ErrNotebookNameCollision:
+			emoji.Println(" :warning: Following notebooks differ only in case, rename them first")
+			for _, collision := range collisions {
+				fmt.Println("  " + strings.Join(collision.Names, ", "))
+			}
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var normalizeSettingCommand = &cobra.Command{
+	Use:   "normalize <on|off>",
+	Short: "Normalize content of notes as it's written",
+	Long: "Turns normalization of content written on or off: invalid UTF-8 is replaced, byte order marks and " +
+		"zero-width spaces are stripped, line endings become '\\n' and characters are NFC-composed. " +
+		"Use `--trim-trailing` to also trim trailing whitespace of lines, `--reject-invalid` to refuse invalid UTF-8 " +
+		"instead of replacing it. Existing notes are left as they are, see `notes normalize`",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var opts models.NormalizeOptions
+		switch strings.ToLower(args[0]) {
+		case "on":
+			opts = models.StandardNormalization
+			opts.TrimTrailingSpace = normalizeTrimTrailing
+			if normalizeRejectInvalid {
+				opts.InvalidUTF8 = models.RejectInvalidUTF8
+			}
+		case "off":
+		default:
+			emoji.Println(" :warning: Specify either 'on' or 'off'")
+			return
+		}
+
+		db := setupDatabase()
+		if err := db.SetContentNormalization(opts); err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Normalization of content turned %s", strings.ToLower(args[0])))
+	},
+}
+
+var outboxSettingCommand = &cobra.Command{
+	Use:   "outbox <on|off>",
+	Short: "Record changes of notes into the outbox",
+	Long: "Turns recording of changes of notes (created, updated, deleted) into the outbox on or off, " +
+		"for other systems to pick them up reliably. Events already recorded are kept when it's turned off",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var enabled bool
+		switch strings.ToLower(args[0]) {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		default:
+			emoji.Println(" :warning: Specify either 'on' or 'off'")
+			return
+		}
+
+		db := setupDatabase()
+		if err := db.EnableOutbox(enabled); err != nil {
+			log.Panic(err)
+		}
+		depth, err := db.OutboxDepth()
+		if err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Outbox turned %s (%d events waiting)", strings.ToLower(args[0]), depth))
+	},
+}
+
+var autoFileSettingCommand = &cobra.Command{
+	Use:   "auto-file <notebook|off>",
+	Short: "File notes of a notebook as they're added",
+	Long: "Makes notes added to a notebook (like an inbox) get filed by filing rules right away, " +
+		"like `notes settings auto-file inbox`, see `notes rule`. `notes settings auto-file off` turns it off",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		notebookName := args[0]
+		if strings.ToLower(notebookName) == "off" {
+			notebookName = ""
+		}
+
+		db := setupDatabase()
+		if err := db.SetAutoFiling(notebookName); err != nil {
+			log.Panic(err)
+		}
+		if notebookName == "" {
+			emoji.Println(" :pencil2: Auto-filing turned off")
+		} else {
+			emoji.Println(fmt.Sprintf(" :pencil2: Notes added to '%s' are now filed right away", notebookName))
+		}
+	},
+}
+
+var titlesSettingCommand = &cobra.Command{
+	Use:   "titles <on|off>",
+	Short: "Infer titles of notes created without one",
+	Long: "Turns title inference on or off: notes created without a title get the first non-empty line of their content " +
+		"as title (heading marks stripped, cut down to `--max-length` characters). With `--reinfer`, inferred titles " +
+		"follow content as it's updated. Existing notes are left as they are, see `notes titles`",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := models.TitleInference{MaxLength: titlesMaxLength, ReinferOnUpdate: titlesReinfer}
+		switch strings.ToLower(args[0]) {
+		case "on":
+			opts.Enabled = true
+		case "off":
+		default:
+			emoji.Println(" :warning: Specify either 'on' or 'off'")
+			return
+		}
+
+		db := setupDatabase()
+		if err := db.SetTitleInference(opts); err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Title inference turned %s", strings.ToLower(args[0])))
+	},
+}
+
+var historySnapshotsSettingCommand = &cobra.Command{
+	Use:   "history-snapshots [interval]",
+	Short: "Keep every n-th revision of history in full",
+	Long: "Past revisions of notes are stored as diffs against the revision after them, except for every n-th one " +
+		"(" + strconv.Itoa(models.DefaultHistorySnapshotInterval) + " unless set) which is kept in full: a smaller n " +
+		"makes old revisions quicker to read, at the cost of space. Revisions already stored are left as they are. " +
+		"Without an interval, shows the current one",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+		if len(args) == 0 {
+			fmt.Printf("history-snapshots: one in %d revisions\n", db.GetHistorySnapshotInterval())
+			return
+		}
+		interval, err := strconv.Atoi(args[0])
+		if err != nil || interval <= 0 {
+			emoji.Println(" :warning: Specify the interval as a positive number of revisions")
+			return
+		}
+		if err := db.SetHistorySnapshotInterval(interval); err != nil {
+			log.Panic(err)
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: One in %d revisions of history is kept in full", interval))
+	},
+}
+
+var encryptionSettingCommand = &cobra.Command{
+	Use:   "encryption [plaintext|content]",
+	Short: "Encrypt content of notes at rest",
+	Long: "Shows how content of notes is stored, or converts notes to be stored in plaintext or with their content " +
+		"(and history) encrypted; titles, tags and timestamps stay in plaintext, so notes can still be listed by them. " +
+		"The passphrase is taken from $" + dbPassphraseEnvVar + " (or `key_file` of the config file's [encryption] table), " +
+		"which every command needs from then on: converting to `content` makes it that of the database, if it has none yet. " +
+		"Searching encrypted content takes `notes search --decrypt`",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var mode models.EncryptionMode
+		if len(args) == 1 {
+			var err error
+			if mode, err = models.ParseEncryptionMode(args[0]); err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: %v", err))
+				return
+			}
+		}
+
+		// (the one place a database gets its passphrase)
+		initEncryption = mode == models.EncryptionContent
+		db := setupDatabase()
+		if mode == "" {
+			fmt.Printf(" Content of notes is stored in %s\n", encryptionDescriptions[db.GetEncryptionMode()])
+			return
+		}
+		converted, err := db.MigrateEncryption(mode)
+		switch {
+		case errors.Is(err, models.ErrEncryptionLocked):
+			emoji.Println(" :warning: Content of notes can't be converted: " + dbPassphraseHint)
+			if converted > 0 {
+				emoji.Println(fmt.Sprintf(" :warning: %d notes were converted; run it again to carry on", converted))
+			}
+		case err != nil:
+			log.Panic(err)
+		default:
+			emoji.Println(fmt.Sprintf(" :pencil2: Content of notes is now stored in %s (%d notes converted)", encryptionDescriptions[mode], converted))
+		}
+	},
+}
+
+// how content of notes is stored, by encryption mode
+var encryptionDescriptions = map[models.EncryptionMode]string{
+	models.EncryptionPlaintext: "plaintext",
+	models.EncryptionContent:   "encrypted form",
+}
+
+var (
+	// longest title inferred
+	titlesMaxLength int
+	// re-infer inferred titles as content is updated
+	titlesReinfer bool
+)
+
+var (
+	// also trim trailing whitespace of lines when normalizing
+	normalizeTrimTrailing bool
+	// refuse invalid UTF-8 rather than replace it when normalizing
+	normalizeRejectInvalid bool
+)
+
+func init() {
+	normalizeSettingCommand.Flags().BoolVar(&normalizeTrimTrailing, "trim-trailing", false, "also trim trailing whitespace of lines")
+	titlesSettingCommand.Flags().IntVar(&titlesMaxLength, "max-length", models.DefaultTitleLength, "longest title inferred, in characters")
+	titlesSettingCommand.Flags().BoolVar(&titlesReinfer, "reinfer", false, "re-infer inferred titles as content is updated")
+	normalizeSettingCommand.Flags().BoolVar(&normalizeRejectInvalid, "reject-invalid", false, "refuse invalid UTF-8 instead of replacing it")
+	settingsCommand.AddCommand(caseInsensitiveCommand)
+	settingsCommand.AddCommand(normalizeSettingCommand)
+	settingsCommand.AddCommand(outboxSettingCommand)
+	settingsCommand.AddCommand(autoFileSettingCommand)
+	settingsCommand.AddCommand(titlesSettingCommand)
+	settingsCommand.AddCommand(historySnapshotsSettingCommand)
+	settingsCommand.AddCommand(encryptionSettingCommand)
+	root.AddCommand(settingsCommand)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/api"
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var shareCommand = &cobra.Command{
+	Use:   "share <notebook> <noteId>",
+	Short: "Share a note through a link",
+	Long: "Shares a single note through a link served by `notes serve` (even with `--auth`), like " +
+		"`notes share work 3 --expires 48h --views 5`. The link is shown once, it can't be retrieved again. " +
+		"List shares with `notes share ls`, revoke them with `notes share revoke`",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		token, err := db.CreateNoteShareLink(args[0], noteId, shareExpires, shareViews)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note %d of '%s' shared until %s:", noteId, args[0],
+				time.Now().Add(shareExpires).Format("2006-01-02 15:04")))
+			fmt.Println(strings.TrimRight(shareBaseURL, "/") + api.SharedNotesPath + token)
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrInvalidNoteShare):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listSharesCommand = &cobra.Command{
+	Use:   "ls",
+	Short: "List shares of notes that can still be viewed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		shares, err := db.ListNoteShares()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, share := range shares {
+			views := fmt.Sprintf("%d views", share.Views)
+			if share.MaxViews > 0 {
+				views = fmt.Sprintf("%d/%d views", share.Views, share.MaxViews)
+			}
+			fmt.Printf(" %d\t%s\t%s\tuntil %s\n", share.Id, models.NoteRef{Notebook: share.Notebook, Id: share.NoteId},
+				views, share.ExpiresAt.Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+var revokeShareCommand = &cobra.Command{
+	Use:   "revoke <shareId>",
+	Short: "Revoke a share of a note",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		shareId, err := utils.ParseUInt64(args[0])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.RevokeNoteShare(shareId); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Share %d revoked", shareId))
+		case errors.Is(err, models.ErrNoteShareNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// how long the link is valid
+	shareExpires time.Duration
+	// how many times the note can be viewed through the link (0 for any number)
+	shareViews int
+	// address `notes serve` is reached at
+	shareBaseURL string
+)
+
+func init() {
+	shareCommand.Flags().DurationVar(&shareExpires, "expires", 24*time.Hour, "how long the link is valid (like 48h or 30m)")
+	shareCommand.Flags().IntVar(&shareViews, "views", 0, "how many times the note can be viewed (0 for any number)")
+	shareCommand.Flags().StringVar(&shareBaseURL, "url", "http://localhost:8080", "address `notes serve` is reached at")
+	shareCommand.AddCommand(listSharesCommand)
+	shareCommand.AddCommand(revokeShareCommand)
+	root.AddCommand(shareCommand)
+}
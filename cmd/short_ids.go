@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+/**
+ * Validates args of a command taking notes as `<notebook> <noteId>` pairs, any of which may be given
+ * as a short id instead (like `wk-3f`, see expandShortIDs)
+ *  - refs are the positions of the pairs' notebooks, counted in args with every pair spelled out
+ *  - args pass if they do in any of their readings (each arg that looks like a short id where a pair
+ *    is expected being read as one or not), since which one holds is only known once the DB is open
+ * param: cobra.PositionalArgs validate Validation of args with every pair spelled out
+ * param: ...int               refs
+ * return: cobra.PositionalArgs
+ */
+func noteArgs(validate cobra.PositionalArgs, refs ...int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		for _, reading := range shortIDReadings(args, refs, nil) {
+			if validate(cmd, reading) == nil {
+				return nil
+			}
+		}
+		return validate(cmd, args)
+	}
+}
+
+/**
+ * Every reading of args (see noteArgs), short ids being spelled out as two args
+ */
+func shortIDReadings(args []string, refs []int, expanded []string) [][]string {
+	if len(args) == 0 {
+		return [][]string{expanded}
+	}
+	readings := shortIDReadings(args[1:], refs, append(expanded[:len(expanded):len(expanded)], args[0]))
+	if isRefPosition(refs, len(expanded)) && looksLikeShortID(args[0]) {
+		readings = append(readings, shortIDReadings(args[1:], refs, append(expanded[:len(expanded):len(expanded)], args[0], args[0]))...)
+	}
+	return readings
+}
+
+/**
+ * Spells out short ids among args of a command validated by noteArgs as the `<notebook> <noteId>`
+ * pairs they stand for, opening the DB to resolve them
+ *  - an arg that looks like a short id where a pair is expected is read as one, unless it's the name of a notebook
+ * Exits with a warning (listing candidates) if a short id can't be resolved
+ * param: *cobra.Command cmd
+ * param: []string       args
+ * param: ...int         refs
+ * return: []string
+ */
+func expandShortIDs(cmd *cobra.Command, args []string, refs ...int) []string {
+	var expanded []string
+	for _, arg := range args {
+		if !isRefPosition(refs, len(expanded)) || !looksLikeShortID(arg) {
+			expanded = append(expanded, arg)
+			continue
+		}
+		db := setupDatabase()
+		if notebookExists(db, arg) {
+			expanded = append(expanded, arg)
+			continue
+		}
+		ref := resolveShortID(db, arg)
+		expanded = append(expanded, ref.Notebook, strconv.FormatUint(ref.Id, 10))
+	}
+	if err := cmd.ValidateArgs(expanded); err != nil {
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		closeDatabase()
+		os.Exit(1)
+	}
+	return expanded
+}
+
+/**
+ * Resolves a short id, exiting with a warning if it can't be
+ */
+func resolveShortID(db models.Datastore, shortID string) models.NoteRef {
+	ref, err := db.ResolveShortID(shortID)
+	switch {
+	case errors.Is(err, models.ErrInvalidShortID), errors.Is(err, models.ErrUnknownAbbreviation),
+		errors.Is(err, models.ErrAmbiguousAbbreviation):
+		emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		closeDatabase()
+		os.Exit(1)
+	case err != nil:
+		log.Panic(err)
+	}
+	return ref
+}
+
+func notebookExists(db models.Datastore, notebookName string) bool {
+	exists, err := db.NotebookExists(notebookName)
+	if err != nil {
+		log.Panic(err)
+	}
+	return exists
+}
+
+func looksLikeShortID(arg string) bool {
+	_, _, err := models.ParseShortID(arg)
+	return err == nil
+}
+
+func isRefPosition(refs []int, position int) bool {
+	for _, ref := range refs {
+		if ref == position {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Short id of a note for listings; the note's plain id if its notebook can't be found
+ */
+func shortIDOf(db models.Datastore, ref models.NoteRef) string {
+	shortID, err := db.NoteShortID(ref)
+	if errors.Is(err, models.ErrNotebookNotFound) {
+		return strconv.FormatUint(ref.Id, 10)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	return shortID
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var slowOpsCommand = &cobra.Command{
+	Use:   "slow-ops",
+	Short: "List slow operations logged by the server",
+	Long: "Lists operations slower than the slow-op threshold stored by `notes serve --slow-op-threshold 200ms --persist-slow-ops` " +
+		"(newest first). `notes slow-ops --top 24h` ranks notebooks by operations recorded in the changelog instead",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		if slowOpsTop > 0 {
+			ranked, err := db.TopNotebooksByOps(slowOpsTop)
+			if err != nil {
+				log.Panic(err)
+			}
+			if len(ranked) == 0 {
+				emoji.Println(fmt.Sprintf(" :warning: No operations recorded in the last %v", slowOpsTop))
+			}
+			for _, count := range ranked {
+				fmt.Printf(" %s\t%d operation(s)\t%d note(s)\n", count.Notebook, count.Ops, count.Notes)
+			}
+			return
+		}
+
+		ops, err := db.PersistedSlowOps(slowOpsLimit)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(ops) == 0 {
+			emoji.Println(" :warning: No slow operations stored")
+		}
+		for _, op := range ops {
+			notebook := op.Notebook
+			if notebook == "" {
+				notebook = "-"
+			}
+			fmt.Printf(" %s\t%v\t%s\t%s\t%d read, %d written\n", op.At.Format("2006-01-02 15:04:05"), op.Duration,
+				op.Operation, notebook, op.NotesRead, op.NotesWritten)
+		}
+	},
+}
+
+var (
+	// maximum number of slow operations listed
+	slowOpsLimit int
+	// window of time notebooks are ranked over (0 to list slow operations)
+	slowOpsTop time.Duration
+)
+
+func init() {
+	slowOpsCommand.Flags().IntVar(&slowOpsLimit, "limit", 50, "maximum number of operations listed (0 for all)")
+	slowOpsCommand.Flags().DurationVar(&slowOpsTop, "top", 0, "rank notebooks by operations within this window instead")
+	root.AddCommand(slowOpsCommand)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var snapshotCommand = &cobra.Command{
+	Use:   "snapshot <notebook> [label]",
+	Short: "Snapshot the notes of a notebook",
+	Long: "Snapshots the notes of a notebook into the DB, like `notes snapshot work before cleanup`, to be restored " +
+		"with `notes snapshot restore`. List snapshots with `notes snapshot ls`, delete them with `notes snapshot rm`",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		id, err := db.SnapshotNotebook(args[0], strings.Join(args[1:], " "))
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Snapshot %d of '%s' taken", id, args[0]))
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrSnapshotTooLarge):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listSnapshotsCommand = &cobra.Command{
+	Use:   "ls <notebook>",
+	Short: "List snapshots of a notebook",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		snapshots, err := db.ListSnapshots(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, snapshot := range snapshots {
+			fmt.Printf(" %d\t%s\t%d notes\t%s\n", snapshot.Id, snapshot.CreatedAt.Format("2006-01-02 15:04"), snapshot.Notes, snapshot.Label)
+		}
+	},
+}
+
+var restoreSnapshotCommand = &cobra.Command{
+	Use:   "restore <notebook> <snapshotId>",
+	Short: "Restore a notebook from a snapshot",
+	Long: "Restores the notes of a notebook as they were in a snapshot, deleting notes added since; " +
+		"with `--merge`, notes added since are kept",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		mode := models.RestoreReplace
+		if snapshotMerge {
+			mode = models.RestoreMerge
+		}
+		switch err := db.RestoreSnapshot(args[0], models.SnapshotID(id), mode); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: '%s' restored from snapshot %d", args[0], id))
+		case errors.Is(err, models.ErrSnapshotNotFound), errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var deleteSnapshotCommand = &cobra.Command{
+	Use:   "rm <notebook> <snapshotId>",
+	Short: "Delete a snapshot of a notebook",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.DeleteSnapshot(args[0], models.SnapshotID(id)); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Snapshot %d of '%s' deleted", id, args[0]))
+		case errors.Is(err, models.ErrSnapshotNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// keep notes added since the snapshot when restoring
+	snapshotMerge bool
+)
+
+func init() {
+	restoreSnapshotCommand.Flags().BoolVar(&snapshotMerge, "merge", false, "keep notes added since the snapshot")
+	snapshotCommand.AddCommand(listSnapshotsCommand)
+	snapshotCommand.AddCommand(restoreSnapshotCommand)
+	snapshotCommand.AddCommand(deleteSnapshotCommand)
+	root.AddCommand(snapshotCommand)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var splitCommand = &cobra.Command{
+	Use:   "split <notebook> <noteId>",
+	Short: "Split a note into several",
+	Long: "Splits a note into new notes of its notebook: at lines made of a delimiter (`--delimiter ---`), at markdown " +
+		"headings (`--heading 2` making every '## ' section a note titled by its heading) or into parts of at most " +
+		"a size (`--max-size 4096`). New notes keep the note's kind and tags; `--mark` tags them 'split-from:<id>' " +
+		"too, and `--trash-original` deletes the note (as `notes undo` can bring back). `--dry-run` lists the notes " +
+		"that would be created",
+	Args: noteArgs(cobra.ExactArgs(2), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		opts := models.SplitOptions{
+			Delimiter:    splitDelimiter,
+			HeadingLevel: splitHeading,
+			MaxSize:      splitMaxSize,
+			MarkOrigin:   splitMark,
+			DryRun:       splitDryRun,
+		}
+		if splitTrashOriginal {
+			opts.Original = models.SplitTrashOriginal
+		}
+		notes, err := db.SplitNote(args[0], noteId, opts)
+		switch {
+		case err == nil:
+		case errors.Is(err, models.ErrNothingToSplit):
+			emoji.Println(fmt.Sprintf(" :warning: Nothing to split: note with id '%d' makes a single note that way", noteId))
+			return
+		case errors.Is(err, models.ErrInvalidSplit), errors.Is(err, models.ErrNoteNotFound),
+			errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNoteReadOnly),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		default:
+			log.Panic(err)
+		}
+
+		if splitDryRun {
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' would be split into %d notes:", noteId, len(notes)))
+			for _, note := range notes {
+				fmt.Printf("   %s (%d bytes)\n", note.Title(), len(note.Content))
+			}
+			return
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' split into %d notes:", noteId, len(notes)))
+		for _, note := range notes {
+			fmt.Printf("   %d\t%s\n", note.Id, note.Title())
+		}
+	},
+}
+
+var (
+	// line separating parts
+	splitDelimiter string
+	// level of markdown headings starting parts
+	splitHeading int
+	// size of parts in bytes
+	splitMaxSize int
+	// tag new notes with the id of the note split
+	splitMark bool
+	// delete the note split
+	splitTrashOriginal bool
+	// only list the notes that would be created
+	splitDryRun bool
+)
+
+func init() {
+	splitCommand.Flags().StringVar(&splitDelimiter, "delimiter", "", "split at lines made of this delimiter, like ---")
+	splitCommand.Flags().IntVar(&splitHeading, "heading", 0, "split at markdown headings of this level (1 to 6)")
+	splitCommand.Flags().IntVar(&splitMaxSize, "max-size", 0, "split into parts of at most this many bytes")
+	splitCommand.Flags().BoolVar(&splitMark, "mark", false, "tag new notes 'split-from:<id>'")
+	splitCommand.Flags().BoolVar(&splitTrashOriginal, "trash-original", false, "delete the note split (undoable)")
+	splitCommand.Flags().BoolVar(&splitDryRun, "dry-run", false, "only list the notes that would be created")
+	root.AddCommand(splitCommand)
+}
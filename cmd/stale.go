@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var staleCommand = &cobra.Command{
+	Use:   "stale <notebook>",
+	Short: "List notes not looked at for a long time",
+	Long: "Lists notes of a notebook that weren't accessed for a while (least recently accessed first), " +
+		"like `notes stale work --older-than 8760h`. Notes never accessed count as accessed when they were created",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		refs, err := db.StaleNotes(args[0], staleOlderThan, staleLimit)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(refs) == 0 {
+			emoji.Println(fmt.Sprintf(" :warning: No notes in notebook '%s' older than %v", args[0], staleOlderThan))
+			return
+		}
+		for _, ref := range refs {
+			note, err := db.GetNote(ref.Notebook, ref.Id)
+			if err != nil {
+				log.Panic(err)
+			}
+			emoji.Println(" " + strconv.FormatUint(note.Id, 10) + "	" + firstLine(note.Content))
+		}
+	},
+}
+
+var (
+	// notes accessed more recently than this are not stale
+	staleOlderThan time.Duration
+	// maximum number of stale notes listed
+	staleLimit int
+)
+
+func init() {
+	staleCommand.Flags().DurationVar(&staleOlderThan, "older-than", 365*24*time.Hour, "minimum time since last access")
+	staleCommand.Flags().IntVar(&staleLimit, "limit", 20, "maximum number of notes listed (0 for all)")
+	root.AddCommand(staleCommand)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var statsCommand = &cobra.Command{
+	Use:   "stats [notebook]",
+	Short: "Show activity over time",
+	Long: "Shows how many notes were created, updated and deleted per week (or `--bucket`) in a notebook, " +
+		"or in all notebooks if none is given (along with counts of notebooks and notes), like `notes stats work --since 90d`",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		to := time.Now()
+		from, err := utils.ParseSince(statsSince, to, time.Local)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bucket, err := utils.ParseDuration(statsBucket)
+		if err == nil && bucket <= 0 {
+			err = fmt.Errorf("invalid --bucket '%s': give a positive duration", statsBucket)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		var histogram []models.ActivityBucket
+		if len(args) == 1 {
+			histogram, err = db.ActivityHistogram(args[0], from, to, bucket)
+		} else {
+			histogram, err = db.ActivityHistogramAll(from, to, bucket)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(args) == 0 {
+			stats, err := db.GetDBStats()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf(" notebooks: %d active, %d archived; notes: %d\n\n", stats.ActiveNotebooks, stats.ArchivedNotebooks, stats.Notes)
+		}
+		if len(histogram) == 0 {
+			emoji.Println(" :warning: No activity to show")
+			return
+		}
+
+		var created []int
+		for _, counts := range histogram {
+			created = append(created, counts.Created)
+		}
+		fmt.Printf(" created %s\n\n", sparkline(created))
+		fmt.Println(" from\t\tcreated\tupdated\tdeleted")
+		for _, counts := range histogram {
+			fmt.Printf(" %s\t%d\t%d\t%d\n", counts.Start.Format("2006-01-02"), counts.Created, counts.Updated, counts.Deleted)
+		}
+	},
+}
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+/**
+ * Renders values as a line of bars scaled to the largest value
+ */
+func sparkline(values []int) string {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var line strings.Builder
+	for _, v := range values {
+		if max == 0 {
+			line.WriteRune(sparkBars[0])
+			continue
+		}
+		line.WriteRune(sparkBars[v*(len(sparkBars)-1)/max])
+	}
+	return line.String()
+}
+
+var (
+	// how far back activity is shown
+	statsSince string
+	// span of time activity is added up over
+	statsBucket string
+)
+
+func init() {
+	statsCommand.Flags().StringVar(&statsSince, "since", "90d", "how far back to show activity (like '90d', '2w' or a date)")
+	statsCommand.Flags().StringVar(&statsBucket, "bucket", "7d", "span of time per row (whole days, like '7d')")
+	root.AddCommand(statsCommand)
+}
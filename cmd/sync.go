@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var syncFromCommand = &cobra.Command{
+	Use:   "sync-from <file>",
+	Short: "Merge notes edited on another device",
+	Long: "Merges notes of another device's DB file (like a copy kept in a shared folder) into the DB, " +
+		"like `notes sync-from ~/Sync/laptop.db`. Notes edited on both devices since they were last synced " +
+		"get both contents between conflict markers, and are tagged 'conflict'. Syncing again from the same device " +
+		"only reads the notes it changed since, if it records its changes (see `settings outbox`)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		report, err := db.SyncFrom(args[0], models.SyncOptions{Notebooks: syncNotebooks})
+		switch {
+		case errors.Is(err, models.ErrDatabaseLocked), errors.Is(err, models.ErrEncryptionLocked), os.IsNotExist(err):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil:
+			log.Panic(err)
+		}
+		for _, ref := range report.Merged {
+			emoji.Println(fmt.Sprintf(" :warning: Conflict on note %d of notebook '%s' (edited on both devices)", ref.Id, ref.Notebook))
+		}
+		for _, skipped := range report.Skipped {
+			emoji.Println(fmt.Sprintf(" :warning: Skipped '%s': %s", skipped.Title, skipped.Reason))
+		}
+		if report.Fallback != "" {
+			emoji.Println(fmt.Sprintf(" :warning: Compared every note: %s", report.Fallback))
+		}
+		if report.Incremental {
+			emoji.Println(fmt.Sprintf(" :pencil2: Read %d notes changed since change %d", report.Read, report.FromSeq))
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d created, %d updated, %d kept, %d unchanged, %d conflicted",
+			report.Created, report.RemoteNewer, report.LocalNewer, report.Unchanged, len(report.Merged)))
+	},
+}
+
+var syncStatusCommand = &cobra.Command{
+	Use:   "sync-status",
+	Short: "List devices synced from",
+	Long: "Lists the devices `sync-from` synced from, most recent first, with the file synced from, when and up to " +
+		"which change of the device's outbox",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		peers, err := db.SyncStatus()
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(peers) == 0 {
+			emoji.Println(" :warning: Never synced from another device")
+			return
+		}
+		for _, peer := range peers {
+			how := "full"
+			if peer.Incremental {
+				how = "incremental"
+			}
+			fmt.Printf("%s\t%s\t%s (%s)\tchange %d\n", peer.Device, peer.Path, peer.SyncedAt.Local().Format("2006-01-02 15:04"), how, peer.Seq)
+		}
+	},
+}
+
+var (
+	// notebooks synced (all of them if empty)
+	syncNotebooks []string
+)
+
+func init() {
+	syncFromCommand.Flags().StringArrayVar(&syncNotebooks, "notebook", nil, "only sync given notebook (repeatable)")
+	root.AddCommand(syncFromCommand)
+	root.AddCommand(syncStatusCommand)
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var tagCommand = &cobra.Command{
+	Use:   "tag <notebook> [text]",
+	Short: "Add or remove tags of matching notes",
+	Long: "Adds and removes tags on every note of a notebook containing given text (and having the tags of `--tag`), " +
+		"like `notes tag work invoice --tag todo --add billing --remove todo`. " +
+		"`--dry-run` lists the notes that would change, without changing anything; notes locked read-only are left alone",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		query := db.Query(args[0])
+		if len(args) > 1 {
+			query.TextContains(args[1])
+		}
+		for _, tag := range tagMatchTags {
+			query.WithTag(strings.TrimPrefix(tag, "#"))
+		}
+		report, err := db.TagMatching(query, trimTags(tagAddTags), trimTags(tagRemoveTags), tagDryRun)
+		switch {
+		case err == nil:
+		case errors.Is(err, models.ErrInvalidTagChange), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		default:
+			log.Panic(err)
+		}
+
+		for _, id := range report.Modified {
+			fmt.Printf(" %d\n", id)
+		}
+		verb := "retagged"
+		if tagDryRun {
+			verb = "would be retagged"
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d note(s) %s (%d left without tags), %d already as asked, %d locked",
+			len(report.Modified), verb, len(report.Untagged), len(report.Unchanged), len(report.Locked)))
+	},
+}
+
+/**
+ * Tags as given on the command line, without leading '#'
+ */
+func trimTags(tags []string) []string {
+	trimmed := make([]string, len(tags))
+	for i, tag := range tags {
+		trimmed[i] = strings.TrimPrefix(tag, "#")
+	}
+	return trimmed
+}
+
+var (
+	// only notes having these tags
+	tagMatchTags []string
+	// tags added to matching notes
+	tagAddTags []string
+	// tags removed from matching notes
+	tagRemoveTags []string
+	// only report notes that would change
+	tagDryRun bool
+)
+
+func init() {
+	flags := tagCommand.Flags()
+	flags.StringArrayVar(&tagMatchTags, "tag", nil, "only notes with this tag (may be repeated)")
+	flags.StringArrayVar(&tagAddTags, "add", nil, "tag to add (may be repeated)")
+	flags.StringArrayVar(&tagRemoveTags, "remove", nil, "tag to remove (may be repeated)")
+	flags.BoolVar(&tagDryRun, "dry-run", false, "only list notes that would change")
+	root.AddCommand(tagCommand)
+}
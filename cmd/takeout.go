@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var takeoutCommand = &cobra.Command{
+	Use:   "takeout <dir>",
+	Short: "Export all notebooks to a directory",
+	Long: "Writes every notebook (archived ones included) to a file of its own in a directory, along with a manifest " +
+		"of the files and their SHA-256, like `notes takeout ~/notes-takeout`. Notebooks are written as by " +
+		"`notes export-notebook`, or as markdown with `--format markdown` (which can't be restored); " +
+		"`--notebook` takes out only some notebooks. Interrupt with Ctrl-C to stop (the takeout then has no manifest)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		report, err := db.TakeoutContext(ctx, args[0], models.TakeoutOptions{Format: takeoutFormat, Notebooks: takeoutNotebooks})
+		switch {
+		case err == context.Canceled:
+			emoji.Println(" :warning: Takeout interrupted")
+			return
+		case errors.Is(err, models.ErrNotebookNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		case err != nil:
+			log.Panic(err)
+		}
+		notebooks, notes := 0, 0
+		for _, file := range report.Manifest.Files {
+			if file.Kind == "notebook" {
+				notebooks++
+				notes += file.Notes
+			}
+		}
+		emoji.Println(fmt.Sprintf(" :pencil2: %d notes of %d notebooks taken out to '%s' (%d files)",
+			notes, notebooks, args[0], len(report.Manifest.Files)))
+	},
+}
+
+var restoreTakeoutCommand = &cobra.Command{
+	Use:   "restore-takeout <dir>",
+	Short: "Import notebooks of a takeout",
+	Long: "Imports the notebooks of a takeout written by `notes takeout` into notebooks of the same names, " +
+		"like `notes restore-takeout ~/notes-takeout`, or only some of them with `--notebook`. Files are checked " +
+		"against the manifest first: nothing is imported if any is missing or corrupted",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := importConflictPolicy()
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		db := setupDatabase()
+
+		opts := models.ImportOptions{DedupeByContent: importDedupe, OnConflict: policy, Notebooks: takeoutNotebooks}
+		report, err := db.RestoreTakeout(args[0], opts)
+		if errors.Is(err, models.ErrInvalidTakeout) {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		for _, restored := range report.Notebooks {
+			emoji.Println(fmt.Sprintf(" :pencil2: '%s': imported %d notes (%d duplicates)",
+				restored.Notebook, restored.Imported, restored.Duplicates))
+			printConflicts(restored.Conflicts)
+			for _, skipped := range restored.Skipped {
+				emoji.Println(fmt.Sprintf(" :warning: Skipped '%s': %s", skipped.Title, skipped.Reason))
+			}
+			for _, warning := range restored.Warnings {
+				emoji.Println(" :warning: " + warning)
+			}
+		}
+		switch {
+		case errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		case err != nil:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// "json" or "markdown"
+	takeoutFormat string
+	// notebooks taken out / restored (all of them if empty)
+	takeoutNotebooks []string
+)
+
+func init() {
+	takeoutCommand.Flags().StringVar(&takeoutFormat, "format", "json", "format of notebook files ('json' or 'markdown')")
+	takeoutCommand.Flags().StringArrayVar(&takeoutNotebooks, "notebook", nil, "only take out this notebook (may be repeated)")
+	restoreTakeoutCommand.Flags().StringArrayVar(&takeoutNotebooks, "notebook", nil, "only restore this notebook (may be repeated)")
+	restoreTakeoutCommand.Flags().BoolVar(&importDedupe, "dedupe", false, "don't import notes whose content already exists in the notebook")
+	addConflictFlag(restoreTakeoutCommand)
+	root.AddCommand(takeoutCommand)
+	root.AddCommand(restoreTakeoutCommand)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var tasksCommand = &cobra.Command{
+	Use:   "tasks <notebook>",
+	Short: "List open tasks",
+	Long: "Lists unchecked checklist items (`- [ ] ..`) of all notes in a notebook along with their note id and line. " +
+		"Use `notes toggle` to check them off",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		taskRefs, err := db.ListOpenTasks(args[0])
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, taskRef := range taskRefs {
+			fmt.Printf(" %d:%d\t[ ] %s\n", taskRef.Ref.Id, taskRef.Task.Line, taskRef.Task.Text)
+		}
+	},
+}
+
+var toggleCommand = &cobra.Command{
+	Use:   "toggle <notebook> <noteId> <line>",
+	Short: "Check or uncheck a task",
+	Long:  "Flips the checkbox of the checklist item on given line of a note",
+	Args:  noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		line, err := strconv.Atoi(args[2])
+		if err != nil {
+			emoji.Println(" :warning: Line must be a number")
+			return
+		}
+		db := setupDatabase()
+
+		note, err := db.ToggleTask(args[0], noteId, line)
+		switch {
+		case err == nil:
+			for _, task := range note.Tasks() {
+				if task.Line != line {
+					continue
+				}
+				state := "open"
+				if task.Done {
+					state = "done"
+				}
+				emoji.Println(fmt.Sprintf(" :pencil2: Task '%s' marked %s", task.Text, state))
+			}
+		case errors.Is(err, models.ErrTaskConflict), errors.Is(err, models.ErrNoteNotFound),
+			errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNoteReadOnly),
+			errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	root.AddCommand(tasksCommand)
+	root.AddCommand(toggleCommand)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var templateCommand = &cobra.Command{
+	Use:   "template",
+	Short: "Manage notebook templates",
+	Long: "Notebook templates hold the notes every new notebook of a kind starts with, see `notes template new`. " +
+		"Titles, contents and tags of their notes can hold variables like '{{project}}'",
+}
+
+var saveTemplateCommand = &cobra.Command{
+	Use:   "save <name> <file>",
+	Short: "Save a notebook template",
+	Long: "Saves a template read from a JSON file, like `notes template save project project.json`, the file holding " +
+		`{"description": "..", "notes": [{"title": "README", "content": "# {{project}}", "tags": ["meta"], "pinned": true}, ..]}. ` +
+		"A template saved under the same name is replaced",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		content, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		var template models.NotebookTemplate
+		if err := json.Unmarshal(content, &template); err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: Invalid template '%s': %v", args[1], err))
+			return
+		}
+		db := setupDatabase()
+
+		switch err := db.SaveNotebookTemplate(args[0], template); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Template '%s' saved", args[0]))
+		case errors.Is(err, models.ErrInvalidTemplate):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listTemplatesCommand = &cobra.Command{
+	Use:   "ls",
+	Short: "List notebook templates",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		templates, err := db.ListNotebookTemplates()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, template := range templates {
+			var titles []string
+			for _, spec := range template.Notes {
+				title := spec.Title
+				if title == "" {
+					title = models.Note{Content: spec.Content}.Title()
+				}
+				titles = append(titles, title)
+			}
+			fmt.Printf(" %s\t%s\t%s\n", template.Name, template.Description, strings.Join(titles, ", "))
+		}
+	},
+}
+
+var removeTemplateCommand = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove a notebook template",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		switch err := db.DeleteNotebookTemplate(args[0]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Template '%s' removed", args[0]))
+		case errors.Is(err, models.ErrTemplateNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var newFromTemplateCommand = &cobra.Command{
+	Use:   "new <notebook> <template>",
+	Short: "Create a notebook from a template",
+	Long: "Creates a notebook holding the notes of a template, like `notes template new apollo project --var project=Apollo`. " +
+		"Every variable the template uses must be given, but for '{{notebook}}' (the notebook's name); " +
+		"nothing is created if the notebook already exists",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vars := make(map[string]string)
+		for _, v := range templateVars {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				emoji.Println(fmt.Sprintf(" :warning: Invalid --var '%s': expected name=value", v))
+				return
+			}
+			vars[parts[0]] = parts[1]
+		}
+		db := setupDatabase()
+
+		notes, err := db.CreateNotebookFromTemplate(args[0], args[1], vars)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' created with %d note(s)", args[0], len(notes)))
+		case errors.Is(err, models.ErrNotebookExists), errors.Is(err, models.ErrTemplateNotFound),
+			errors.Is(err, models.ErrInvalidTemplate), errors.Is(err, models.ErrReservedNotebookName):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var exportTemplateCommand = &cobra.Command{
+	Use:   "export <name>",
+	Short: "Export a notebook template",
+	Long:  "Writes a template as JSON, for `notes template import` in another DB, like `notes template export project -o project.json`",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		var w io.Writer = os.Stdout
+		if templateOutput != "" {
+			file, err := os.Create(templateOutput)
+			if err != nil {
+				log.Panic(err)
+			}
+			defer file.Close()
+			w = file
+		}
+
+		switch err := db.ExportNotebookTemplate(args[0], w); {
+		case err == nil:
+			if templateOutput != "" {
+				emoji.Println(fmt.Sprintf(" :pencil2: Template '%s' exported to '%s'", args[0], templateOutput))
+			}
+		case errors.Is(err, models.ErrTemplateNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var importTemplateCommand = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a notebook template",
+	Long: "Saves a template exported with `notes template export`, under its own name unless `--name` is given " +
+		"(replacing the template saved under that name)",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := os.Open(args[0])
+		if err != nil {
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			return
+		}
+		defer file.Close()
+		db := setupDatabase()
+
+		template, err := db.ImportNotebookTemplate(file, templateName)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Template '%s' imported", template.Name))
+		case errors.Is(err, models.ErrInvalidTemplate), errors.Is(err, models.ErrInvalidNoteExport):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// variables of the template, as 'name=value'
+	templateVars []string
+	// file templates are exported to (stdout if empty)
+	templateOutput string
+	// name templates are imported under (their own if empty)
+	templateName string
+)
+
+func init() {
+	newFromTemplateCommand.Flags().StringArrayVar(&templateVars, "var", nil, "value of a variable, like project=Apollo (repeatable)")
+	exportTemplateCommand.Flags().StringVarP(&templateOutput, "output", "o", "", "file to write the template to (stdout by default)")
+	importTemplateCommand.Flags().StringVar(&templateName, "name", "", "name to save the template under")
+	templateCommand.AddCommand(saveTemplateCommand, listTemplatesCommand, removeTemplateCommand, newFromTemplateCommand,
+		exportTemplateCommand, importTemplateCommand)
+	root.AddCommand(templateCommand)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var titlesCommand = &cobra.Command{
+	Use:   "titles <notebook>",
+	Short: "Infer titles of existing notes",
+	Long: "Gives notes of a notebook that have no title one inferred from their content, like `notes titles work` " +
+		"(see `notes settings titles`). Notes locked read-only are left alone",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		inferred, err := db.InferMissingTitles(args[0])
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Titles of %d notes inferred", inferred))
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookArchived),
+			errors.Is(err, models.ErrTitleTaken):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var renameCommand = &cobra.Command{
+	Use:   "rename <notebook> <noteId> <title>",
+	Short: "Give a note another title",
+	Long: "Gives a note another title, like `notes rename work 3 \"Q3 planning\"`; an empty title removes it. " +
+		"Fails if the notebook requires unique titles and another note has it (see `notes unique-titles`)",
+	Args: noteArgs(cobra.ExactArgs(3), 0),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = expandShortIDs(cmd, args, 0)
+		noteId, err := utils.ParseUInt64(args[1])
+		if err != nil {
+			return
+		}
+		db := setupDatabase()
+
+		switch note, err := db.RenameNote(args[0], noteId, args[2]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Note with id '%d' renamed to '%s'", noteId, note.TitleText))
+		case errors.Is(err, models.ErrNoteNotFound), errors.Is(err, models.ErrNotebookNotFound),
+			errors.Is(err, models.ErrNotebookArchived), errors.Is(err, models.ErrNoteReadOnly), errors.Is(err, models.ErrTitleTaken):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var uniqueTitlesCommand = &cobra.Command{
+	Use:   "unique-titles <notebook>",
+	Short: "Require unique titles in a notebook",
+	Long: "Makes a notebook require titles of its notes to be unique (case-insensitively), like `notes unique-titles work`: " +
+		"adding, editing or renaming a note to a title another note has fails, unless `--auto-suffix` is given, " +
+		"new notes then getting \" (2)\", \" (3)\" .. appended to taken titles. Fails, listing them, if titles already collide; " +
+		"`--off` stops requiring unique titles",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		var collisions *models.TitleCollisionsError
+		switch err := db.SetUniqueTitles(args[0], !uniqueTitlesOff, uniqueTitlesAutoSuffix); {
+		case err == nil && uniqueTitlesOff:
+			emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' no longer requires unique titles", args[0]))
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Notebook '%s' requires unique titles", args[0]))
+		case errors.As(err, &collisions):
+			emoji.Println(fmt.Sprintf(" :warning: Titles of notebook '%s' collide, rename notes first:", collisions.Notebook))
+			var titles []string
+			for title := range collisions.Collisions {
+				titles = append(titles, title)
+			}
+			sort.Strings(titles)
+			for _, title := range titles {
+				fmt.Printf(" %s\tnotes %v\n", title, collisions.Collisions[title])
+			}
+		case errors.Is(err, models.ErrNotebookNotFound), errors.Is(err, models.ErrNotebookArchived):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// stop requiring unique titles
+	uniqueTitlesOff bool
+	// suffix taken titles of new notes rather than rejecting them
+	uniqueTitlesAutoSuffix bool
+)
+
+func init() {
+	uniqueTitlesCommand.Flags().BoolVar(&uniqueTitlesOff, "off", false, "stop requiring unique titles")
+	uniqueTitlesCommand.Flags().BoolVar(&uniqueTitlesAutoSuffix, "auto-suffix", false, "suffix taken titles of new notes rather than rejecting them")
+	root.AddCommand(titlesCommand)
+	root.AddCommand(renameCommand)
+	root.AddCommand(uniqueTitlesCommand)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var tokenCommand = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens",
+	Long:  "API tokens authenticate clients of `notes serve --auth`",
+}
+
+var createTokenCommand = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token",
+	Long: "Creates an API token with given scopes, like `notes token create phone --scope read --scope read-write:inbox`. " +
+		"Scopes are 'read', 'read-write' or 'admin', optionally followed by ':notebook'. " +
+		"The token is shown once, it can't be retrieved again",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var scopes []models.Scope
+		for _, s := range tokenScopes {
+			scope, err := models.ParseScope(s)
+			if err != nil {
+				emoji.Println(fmt.Sprintf(" :warning: %v", err))
+				return
+			}
+			scopes = append(scopes, scope)
+		}
+		db := setupDatabase()
+
+		token, err := db.CreateAPIToken(args[0], scopes)
+		switch {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: API token '%s' created, keep it safe (it won't be shown again):", args[0]))
+			fmt.Println(token)
+		case errors.Is(err, models.ErrAPITokenExists), errors.Is(err, models.ErrInvalidScope):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var listTokensCommand = &cobra.Command{
+	Use:   "ls",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		tokens, err := db.ListAPITokens()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, token := range tokens {
+			scopes := make([]string, len(token.Scopes))
+			for i, scope := range token.Scopes {
+				scopes[i] = scope.String()
+			}
+			fmt.Printf(" %s\t%s\t%s\n", token.Name, token.CreatedAt.Format("2006-01-02 15:04:05"), strings.Join(scopes, ", "))
+		}
+	},
+}
+
+var revokeTokenCommand = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		switch err := db.RevokeAPIToken(args[0]); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: API token '%s' revoked", args[0]))
+		case errors.Is(err, models.ErrAPITokenNotFound):
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+var (
+	// scopes of the token to create
+	tokenScopes []string
+)
+
+func init() {
+	createTokenCommand.Flags().StringArrayVar(&tokenScopes, "scope", nil, "scope of the token, like 'read' or 'read-write:notebook' (repeatable)")
+	tokenCommand.AddCommand(createTokenCommand)
+	tokenCommand.AddCommand(listTokensCommand)
+	tokenCommand.AddCommand(revokeTokenCommand)
+	root.AddCommand(tokenCommand)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+// whether 'undo' should only list recent operations
+var listUndoEntries bool
+
+var undoCommand = &cobra.Command{
+	Use:   "undo [opId]",
+	Short: "Undo a destructive operation",
+	Long: "Restores notes removed by a destructive operation (like `notes del`). `notes undo` undoes the most recent one, " +
+		"`notes undo opId` undoes a specific one and `notes undo --list` shows recent operations. Undo itself can't be undone",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := setupDatabase()
+
+		entries, err := db.LastOperations(models.DefaultUndoLimit)
+		if err != nil {
+			log.Panic(err)
+		}
+		if listUndoEntries {
+			for _, entry := range entries {
+				fmt.Printf(" %d\t%s\t%s\t%d note(s) from '%s'\n", entry.Id, entry.CreatedAt.Format("2006-01-02 15:04:05"),
+					entry.Operation, len(entry.Notes), entry.Notebook)
+			}
+			return
+		}
+
+		// determine operation to undo
+		var opId uint64
+		switch {
+		case len(args) == 1:
+			if opId, err = utils.ParseUInt64(args[0]); err != nil {
+				return
+			}
+		case len(entries) > 0:
+			opId = entries[0].Id
+		default:
+			emoji.Println(" :warning: Nothing to undo")
+			return
+		}
+
+		switch err := db.Undo(opId); {
+		case err == nil:
+			emoji.Println(fmt.Sprintf(" :pencil2: Operation '%d' undone", opId))
+		case errors.Is(err, models.ErrUndoEntryNotFound), errors.Is(err, models.ErrUndoConflict),
+			errors.Is(err, models.ErrNotebookLocked):
+			emoji.Println(fmt.Sprintf(" :warning: Couldn't undo operation '%d': %v", opId, err))
+		default:
+			log.Panic(err)
+		}
+	},
+}
+
+func init() {
+	undoCommand.Flags().BoolVar(&listUndoEntries, "list", false, "list recent operations instead of undoing")
+	root.AddCommand(undoCommand)
+}
@@ -1,32 +1,229 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
-	"os/user"
-	"path"
+	"os"
+	"os/exec"
+	"strings"
 
+	"github.com/noculture/notes/config"
 	"github.com/noculture/notes/models"
+	"gopkg.in/kyokomi/emoji.v1"
 )
 
 /**
- * Creates (or uses existing one) bolt-db database storage file called '.notebooks.db' in user's home directory
+ * Resolves effective configuration using the '--db' flag, NOTES_DB env var and config file
+ * Terminates the program if configuration can't be loaded
+ * return: config.Config
+ */
+func loadConfig() config.Config {
+	cfg, err := config.Load(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+/**
+ * Creates (or uses existing one) bolt-db database storage file at the configured path
+ * (see config.Load for how the path is resolved)
  * Returns models.Datastore object that implements interfaces which can be used for managing our datastore
  * (like add note, show etc.)
  * return: models.Datastore
  */
 func setupDatabase() models.Datastore {
-	var database models.Datastore
-	// determine current user's home directory and build path for a '.notebooks.db' file there
-	usr, err := user.Current()
-	if err != nil {
-		log.Fatal(err)
+	if openedDatabase != nil {
+		// already opened (like for resolving short ids, see expandShortIDs)
+		return openedDatabase
 	}
-	notebook := path.Join(usr.HomeDir, ".notebooks.db")
+	cfg := loadConfig()
 
-	// create a bolt-db file (.notebooks.db) or use the existing one
-	database, err = models.GetOrCreateDB(notebook)
+	// create a bolt-db file or use the existing one
+	database, err := models.GetOrCreateDB(cfg.DBPath)
+	if errors.Is(err, models.ErrDatabaseLocked) {
+		reportJSONError(err)
+		// another process (like `notes serve`) has it open: say who, rather than a stack trace
+		emoji.Println(" :warning: " + strings.ToUpper(err.Error()[:1]) + err.Error()[1:])
+		os.Exit(1)
+	}
 	if err != nil {
+		reportJSONError(err)
 		log.Panic(err)
 	}
+	passphrase, err := dbPassphrase(cfg)
+	if err != nil {
+		reportJSONError(err)
+		emoji.Println(fmt.Sprintf(" :warning: Can't read the passphrase of the notes database: %v", err))
+		os.Exit(1)
+	}
+	if passphrase != "" {
+		unlock := database.UnlockEncryption
+		if initEncryption {
+			unlock = database.InitEncryption
+		}
+		err := unlock(passphrase)
+		switch {
+		case errors.Is(err, models.ErrEncryptionNotInitialized) && cfg.Encryption.Enabled:
+			reportJSONError(err)
+			emoji.Println(" :warning: Encryption is enabled, but the notes database has no passphrase yet: run `notes settings encryption content`")
+			os.Exit(1)
+		case errors.Is(err, models.ErrEncryptionNotInitialized):
+			// (a passphrase only becomes the database's through `notes settings encryption content`)
+		case errors.Is(err, models.ErrWrongPassphrase):
+			reportJSONError(err)
+			emoji.Println(" :warning: The passphrase given isn't that of the notes database")
+			os.Exit(1)
+		case err != nil:
+			reportJSONError(err)
+			log.Panic(err)
+		}
+	} else if database.GetEncryptionMode() == models.EncryptionContent || cfg.Encryption.Enabled {
+		reportJSONError(models.ErrEncryptionLocked)
+		emoji.Println(" :warning: Content of notes is encrypted: " + dbPassphraseHint)
+		os.Exit(1)
+	}
+	unlockNotebooks(database)
+	database.SetMassDeleteThreshold(cfg.MassDeleteThreshold)
+	database.SetReadWorkers(cfg.ReadWorkers)
+	database.SetAttachmentLimit(cfg.MaxAttachmentSize)
+	if skipCorrupt {
+		database.SetReadPolicy(models.SkipCorrupt, func(record models.CorruptRecord) {
+			emoji.Fprintln(os.Stderr, fmt.Sprintf(" :warning: Skipped %v", &record))
+		})
+	}
+	openedDatabase = database
 	return database
 }
+
+// environment variable holding the passphrase content of notes is encrypted with (see `notes settings encryption`)
+const dbPassphraseEnvVar = "NOTES_DB_PASSPHRASE"
+
+// where the passphrase of the database is given, for warnings
+const dbPassphraseHint = "give the passphrase of the database in $" + dbPassphraseEnvVar + " (or `key_file` of the config file's [encryption] table)"
+
+// whether setupDatabase makes the passphrase given that of a database having none (for `notes settings encryption content`)
+var initEncryption bool
+
+/**
+ * Passphrase of the database: $NOTES_DB_PASSPHRASE, or the first line of the configured key file
+ * Empty if neither is given
+ */
+func dbPassphrase(cfg config.Config) (string, error) {
+	if passphrase := os.Getenv(dbPassphraseEnvVar); passphrase != "" || cfg.Encryption.KeyFile == "" {
+		return passphrase, nil
+	}
+	contents, err := ioutil.ReadFile(cfg.Encryption.KeyFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r"), nil
+}
+
+// database opened by setupDatabase, closed once the command is done (see closeDatabase)
+var openedDatabase *models.DB
+
+// whether the command prints JSON ('--output json'), and so reports errors as JSON (see reportJSONError)
+var jsonOutput bool
+
+/**
+ * Reports an error as a JSON document on stderr, like `{"error": {"code": "NOTEBOOK_NOT_FOUND", "message": ...}}`
+ * (see models.DescribeError), and exits with status 1
+ */
+func exitWithJSONError(info models.ErrorInfo) {
+	closeDatabase()
+	if err := json.NewEncoder(os.Stderr).Encode(struct {
+		Error models.ErrorInfo `json:"error"`
+	}{info}); err != nil {
+		log.Panic(err)
+	}
+	os.Exit(1)
+}
+
+/**
+ * Reports an error as by exitWithJSONError if the command prints JSON; does nothing otherwise,
+ * leaving it to the caller (to warn about it, or panic)
+ */
+func reportJSONError(err error) {
+	if jsonOutput {
+		exitWithJSONError(models.DescribeError(err))
+	}
+}
+
+/**
+ * Closes the database opened by setupDatabase (if any), letting other processes know it's free
+ */
+func closeDatabase() {
+	if openedDatabase != nil {
+		if err := openedDatabase.Close(); err != nil {
+			log.Println(err)
+		}
+		openedDatabase = nil
+	}
+}
+
+/**
+ * Lets user edit given content in the configured editor and returns the edited content
+ *  - content is written to a temporary file which is removed afterwards
+ * param: string editor Editor command (may contain arguments, like "code --wait")
+ * param: string content
+ * return: (string, error)
+ */
+func editInEditor(editor string, content string) (string, error) {
+	file, err := ioutil.TempFile("", "notes-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	editorArgs := strings.Fields(editor)
+	editorCmd := exec.Command(editorArgs[0], append(editorArgs[1:], file.Name())...)
+	editorCmd.Stdin, editorCmd.Stdout, editorCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return "", err
+	}
+
+	edited, err := ioutil.ReadFile(file.Name())
+	return string(edited), err
+}
+
+/**
+ * Returns first line of given text (used for one-line previews)
+ */
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i] + " .."
+	}
+	return text
+}
+
+/**
+ * Formats tags for display as ' #tag1 #tag2' (empty string if there are no tags)
+ */
+func formatTags(tags []string) string {
+	var formatted string
+	for _, tag := range tags {
+		formatted += " #" + tag
+	}
+	return formatted
+}
+
+/**
+ * Marker shown after notes locked read-only
+ */
+func formatReadOnly(note models.Note) string {
+	if note.ReadOnly {
+		return " :lock:"
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/noculture/notes/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/kyokomi/emoji.v1"
+)
+
+var verifyBackupCommand = &cobra.Command{
+	Use:   "verify-backup <file>",
+	Short: "Check a backup against the DB",
+	Long: "Compares a backup (like one written by the backup scheduler) with the DB, notebook by notebook, " +
+		"like `notes verify-backup backups/notes-20240101-030000.db`. With the outbox on (see `notes settings outbox`), " +
+		"notes changed since the backup was taken don't fail the check. Exits with 1 if the backup doesn't pass; " +
+		"`--output json` prints the report as JSON",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput = verifyBackupOutput == "json"
+		report, err := models.VerifyBackup(loadConfig().DBPath, args[0])
+		switch {
+		case os.IsNotExist(err) && jsonOutput:
+			exitWithJSONError(models.ErrorInfo{Code: models.CodeNotFound, Message: err.Error()})
+		case errors.Is(err, models.ErrDatabaseLocked), os.IsNotExist(err):
+			reportJSONError(err)
+			emoji.Println(fmt.Sprintf(" :warning: %v", err))
+			os.Exit(1)
+		case err != nil:
+			reportJSONError(err)
+			log.Panic(err)
+		}
+
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				log.Panic(err)
+			}
+		} else {
+			printVerifyReport(report)
+		}
+		if !report.Passed() {
+			os.Exit(1)
+		}
+	},
+}
+
+func printVerifyReport(report models.VerifyReport) {
+	fmt.Printf(" %-24s %8s %8s  %s\n", "notebook", "live", "backup", "")
+	for _, notebook := range report.Notebooks {
+		state := "ok"
+		switch {
+		case notebook.BackupHash == "":
+			state = "missing in backup"
+		case notebook.LiveHash == "":
+			state = "missing in live DB"
+		case notebook.LiveHash != notebook.BackupHash:
+			state = "differs"
+		}
+		fmt.Printf(" %-24s %8d %8d  %s\n", strings.ToValidUTF8(notebook.Notebook, "?"), notebook.LiveNotes, notebook.BackupNotes, state)
+	}
+	if len(report.BucketsMissingInBackup) > 0 || len(report.BucketsMissingInLive) > 0 {
+		fmt.Printf("\n buckets missing in backup: %s; in live DB: %s\n",
+			orNone(report.BucketsMissingInBackup), orNone(report.BucketsMissingInLive))
+	}
+	for _, ref := range report.Unexplained {
+		fmt.Printf(" not changed since the backup, yet differing: %s\n", ref)
+	}
+	fmt.Println()
+
+	switch report.Verdict {
+	case models.VerifyMatch:
+		emoji.Println(" :white_check_mark: PASS: the backup matches the DB")
+	case models.VerifyBehind:
+		emoji.Println(fmt.Sprintf(" :white_check_mark: PASS: the backup is behind the DB by changes %d to %d, and only by them",
+			report.BackupSeq+1, report.LiveSeq))
+	case models.VerifyDiffers:
+		emoji.Println(" :x: FAIL: the backup differs from the DB, and there's no telling whether only by changes made since " +
+			"(the outbox has to be on, and hold the changes)")
+	case models.VerifyDiverged:
+		emoji.Println(" :x: FAIL: the backup diverged from the DB")
+	}
+}
+
+func orNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+var (
+	// print the report as JSON ('json') rather than as a table
+	verifyBackupOutput string
+)
+
+func init() {
+	verifyBackupCommand.Flags().StringVar(&verifyBackupOutput, "output", "", "print the report as JSON ('json')")
+	root.AddCommand(verifyBackupCommand)
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+/**
+ * Name of the environment variable that overrides the database path
+ */
+const DBPathEnvVar = "NOTES_DB"
+
+/**
+ * Places a config value can be picked up from, in order of precedence
+ */
+type Source string
+
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceFile    Source = "config file"
+	SourceDefault Source = "default"
+)
+
+/**
+ * Size (in bytes) of the largest note `notes add` reads from stdin or a file, unless configured otherwise
+ */
+const DefaultMaxNoteSize = 16 << 20
+
+/**
+ * Number of notes `notes del` deletes at once without asking for confirmation, unless configured otherwise
+ */
+const DefaultMassDeleteThreshold = 50
+
+/**
+ * Encryption-related settings read from the config file
+ *  - Enabled makes commands refuse to run without the passphrase of the database (or before it has one,
+ *    see `notes settings encryption`)
+ *  - KeyFile holds that passphrase on its first line, for when $NOTES_DB_PASSPHRASE isn't set
+ */
+type EncryptionConfig struct {
+	Enabled bool   `toml:"enabled"`
+	KeyFile string `toml:"key_file"`
+}
+
+/**
+ * Effective configuration of the 'notes' app
+ *  - DBPath is always an absolute path once Load() returns
+ *  - Sources records where each setting came from (keyed by the toml key name)
+ */
+type Config struct {
+	DBPath          string `toml:"db"`
+	DefaultNotebook string `toml:"default_notebook"`
+	Editor          string `toml:"editor"`
+	MaxNoteSize     int64  `toml:"max_note_size"`
+	// number of notes deleted at once above which deletes have to be confirmed
+	MassDeleteThreshold int `toml:"mass_delete_threshold"`
+	// number of workers searches and takeouts of all notebooks fan out over (0 for as many as CPUs, at most 8)
+	ReadWorkers int `toml:"read_workers"`
+	// size files attached are capped at (0 for 64 MiB, negative for no cap)
+	MaxAttachmentSize int64            `toml:"max_attachment_size"`
+	Encryption        EncryptionConfig `toml:"encryption"`
+
+	File    string            `toml:"-"`
+	Sources map[string]Source `toml:"-"`
+}
+
+/**
+ * Resolves the effective configuration
+ *  - database path is picked from (in order) flagDBPath, NOTES_DB env var,
+ *    config file and finally the default '~/.local/share/notes/notes.db'
+ *  - parent directories of the resolved database path are created if missing
+ * param: string flagDBPath Value of the '--db' flag (empty if not supplied)
+ * return: (Config, error)
+ */
+func Load(flagDBPath string) (Config, error) {
+	var cfg Config
+	cfg.Sources = make(map[string]Source)
+
+	home, err := homeDir()
+	if err != nil {
+		return cfg, err
+	}
+
+	// read config file (if present)
+	cfg.File = filepath.Join(home, ".config", "notes", "config.toml")
+	var fileCfg Config
+	meta, err := toml.DecodeFile(cfg.File, &fileCfg)
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, err
+	}
+
+	// database path
+	switch {
+	case flagDBPath != "":
+		cfg.DBPath, cfg.Sources["db"] = flagDBPath, SourceFlag
+	case os.Getenv(DBPathEnvVar) != "":
+		cfg.DBPath, cfg.Sources["db"] = os.Getenv(DBPathEnvVar), SourceEnv
+	case meta.IsDefined("db"):
+		cfg.DBPath, cfg.Sources["db"] = fileCfg.DBPath, SourceFile
+	default:
+		cfg.DBPath, cfg.Sources["db"] = filepath.Join(home, ".local", "share", "notes", "notes.db"), SourceDefault
+	}
+	if cfg.DBPath, err = ExpandPath(cfg.DBPath); err != nil {
+		return cfg, err
+	}
+
+	// remaining settings can only come from the config file
+	cfg.DefaultNotebook, cfg.Sources["default_notebook"] = "Default", SourceDefault
+	if meta.IsDefined("default_notebook") {
+		cfg.DefaultNotebook, cfg.Sources["default_notebook"] = fileCfg.DefaultNotebook, SourceFile
+	}
+	cfg.Editor, cfg.Sources["editor"] = defaultEditor(), SourceDefault
+	if meta.IsDefined("editor") {
+		cfg.Editor, cfg.Sources["editor"] = fileCfg.Editor, SourceFile
+	}
+	cfg.MaxNoteSize, cfg.Sources["max_note_size"] = DefaultMaxNoteSize, SourceDefault
+	if meta.IsDefined("max_note_size") {
+		cfg.MaxNoteSize, cfg.Sources["max_note_size"] = fileCfg.MaxNoteSize, SourceFile
+	}
+	cfg.MassDeleteThreshold, cfg.Sources["mass_delete_threshold"] = DefaultMassDeleteThreshold, SourceDefault
+	if meta.IsDefined("mass_delete_threshold") {
+		cfg.MassDeleteThreshold, cfg.Sources["mass_delete_threshold"] = fileCfg.MassDeleteThreshold, SourceFile
+	}
+	cfg.Sources["read_workers"] = SourceDefault
+	if meta.IsDefined("read_workers") {
+		cfg.ReadWorkers, cfg.Sources["read_workers"] = fileCfg.ReadWorkers, SourceFile
+	}
+	cfg.Sources["max_attachment_size"] = SourceDefault
+	if meta.IsDefined("max_attachment_size") {
+		cfg.MaxAttachmentSize, cfg.Sources["max_attachment_size"] = fileCfg.MaxAttachmentSize, SourceFile
+	}
+	cfg.Sources["encryption"] = SourceDefault
+	if meta.IsDefined("encryption") {
+		cfg.Encryption, cfg.Sources["encryption"] = fileCfg.Encryption, SourceFile
+		if cfg.Encryption.KeyFile, err = ExpandPath(cfg.Encryption.KeyFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	// create parent directories of database file
+	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0700); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+/**
+ * Expands a leading '~' to current user's home directory and
+ * converts relative paths into absolute ones (relative to working directory)
+ * Empty path is returned as it is
+ */
+func ExpandPath(path string) (string, error) {
+	if path == "" {
+		return path, nil
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+/**
+ * Determines home directory of current user
+ *  - $HOME is preferred (so that it can be overridden, say in tests)
+ *  - falls back to user database lookup
+ */
+func homeDir() (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		return home, nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return usr.HomeDir, nil
+}
+
+func defaultEditor() string {
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		return editor
+	}
+	return "vi"
+}
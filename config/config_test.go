@@ -0,0 +1,148 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ * Points $HOME at a fresh directory (and clears $NOTES_DB) for the length of a test
+ */
+func fakeHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	setEnv(t, "HOME", home)
+	setEnv(t, DBPathEnvVar, "")
+	return home
+}
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	previous, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func writeConfigFile(t *testing.T, home, content string) {
+	t.Helper()
+	dir := filepath.Join(home, ".config", "notes")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	home := fakeHome(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"~", home},
+		{"~/notes.db", filepath.Join(home, "notes.db")},
+		{"~/a/../b/notes.db", filepath.Join(home, "b", "notes.db")},
+		{"notes.db", filepath.Join(wd, "notes.db")},
+		{"./data/notes.db", filepath.Join(wd, "data", "notes.db")},
+		{"/var/notes.db", "/var/notes.db"},
+		// only a leading '~' (alone or before a separator) means home
+		{"~user/notes.db", filepath.Join(wd, "~user", "notes.db")},
+	}
+	for _, test := range tests {
+		got, err := ExpandPath(test.path)
+		if err != nil {
+			t.Errorf("ExpandPath(%q): %v", test.path, err)
+		} else if got != test.want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	home := fakeHome(t)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(home, ".local", "share", "notes", "notes.db")
+	if cfg.DBPath != want || cfg.Sources["db"] != SourceDefault {
+		t.Errorf("db = %q (%s), want %q (default)", cfg.DBPath, cfg.Sources["db"], want)
+	}
+	if info, err := os.Stat(filepath.Dir(want)); err != nil || !info.IsDir() {
+		t.Errorf("parent directory of the database wasn't created: %v", err)
+	}
+	if cfg.DefaultNotebook != "Default" || cfg.MaxNoteSize != DefaultMaxNoteSize ||
+		cfg.MassDeleteThreshold != DefaultMassDeleteThreshold {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.File != filepath.Join(home, ".config", "notes", "config.toml") {
+		t.Errorf("config file = %q", cfg.File)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	home := fakeHome(t)
+	writeConfigFile(t, home, `
+db = "~/from-file/notes.db"
+default_notebook = "inbox"
+editor = "nano"
+max_note_size = 1024
+`)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "from-file", "notes.db"); cfg.DBPath != want || cfg.Sources["db"] != SourceFile {
+		t.Errorf("db = %q (%s), want %q from the config file", cfg.DBPath, cfg.Sources["db"], want)
+	}
+	if cfg.DefaultNotebook != "inbox" || cfg.Sources["default_notebook"] != SourceFile {
+		t.Errorf("default_notebook = %q (%s)", cfg.DefaultNotebook, cfg.Sources["default_notebook"])
+	}
+	if cfg.Editor != "nano" || cfg.MaxNoteSize != 1024 {
+		t.Errorf("editor = %q, max_note_size = %d", cfg.Editor, cfg.MaxNoteSize)
+	}
+
+	// the env var wins over the file
+	setEnv(t, DBPathEnvVar, "~/from-env/notes.db")
+	if cfg, err = Load(""); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "from-env", "notes.db"); cfg.DBPath != want || cfg.Sources["db"] != SourceEnv {
+		t.Errorf("db = %q (%s), want %q from the env var", cfg.DBPath, cfg.Sources["db"], want)
+	}
+
+	// and the flag wins over both
+	if cfg, err = Load("~/from-flag/notes.db"); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "from-flag", "notes.db"); cfg.DBPath != want || cfg.Sources["db"] != SourceFlag {
+		t.Errorf("db = %q (%s), want %q from the flag", cfg.DBPath, cfg.Sources["db"], want)
+	}
+	if _, err := os.Stat(filepath.Join(home, "from-flag")); err != nil {
+		t.Errorf("parent directory of the database wasn't created: %v", err)
+	}
+}
+
+func TestLoadInvalidConfigFile(t *testing.T) {
+	home := fakeHome(t)
+	writeConfigFile(t, home, "db = [")
+
+	if _, err := Load(""); err == nil {
+		t.Error("Load succeeded with a malformed config file")
+	}
+}
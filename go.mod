@@ -1,13 +1,14 @@
 module github.com/noculture/notes
 
-go 1.12
+go 1.16
 
 require (
-	github.com/boltdb/bolt v1.3.1
-	github.com/coreos/go-etcd v2.0.0+incompatible // indirect
-	github.com/cpuguy83/go-md2man v1.0.10 // indirect
+	github.com/BurntSushi/toml v0.3.1
 	github.com/spf13/cobra v0.0.7
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/ugorji/go/codec v0.0.0-20181204163529-d75b2dcb6bc8 // indirect
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.18.0
+	golang.org/x/text v0.14.0
 	gopkg.in/kyokomi/emoji.v1 v1.5.1
+	modernc.org/sqlite v1.29.0
 )
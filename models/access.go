@@ -0,0 +1,253 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Interval at which buffered access times are written to the 'Access' bucket
+ */
+const DefaultAccessFlushInterval = 30 * time.Second
+
+/**
+ * Access times of notes, recorded by GetNote
+ *  - kept in a separate 'Access' bucket (Access -> notebook -> note id), so that reads
+ *    never rewrite the note itself
+ *  - recorded best-effort: accesses are buffered in memory and written by a background
+ *    goroutine every flush interval (and once more on Close); a crash loses the buffer
+ *  - the buffer is swapped out before the write transaction is opened, so recording an
+ *    access never waits on bolt's writer lock and flushing never holds the buffer's lock
+ */
+type accessTracker struct {
+	mu       sync.Mutex
+	disabled bool
+	interval time.Duration
+	started  bool
+	pending  map[NoteRef]time.Time
+}
+
+/**
+ * Turns access tracking on (default) or off
+ * With tracking off GetNote never triggers writes; accesses buffered so far are discarded
+ */
+func (db *DB) SetAccessTracking(enabled bool) {
+	db.access.mu.Lock()
+	defer db.access.mu.Unlock()
+	db.access.disabled = !enabled
+	if !enabled {
+		db.access.pending = nil
+	}
+}
+
+/**
+ * Sets how often buffered access times are flushed (takes effect before the first access)
+ * Non-positive interval falls back to DefaultAccessFlushInterval
+ */
+func (db *DB) SetAccessFlushInterval(interval time.Duration) {
+	db.access.mu.Lock()
+	defer db.access.mu.Unlock()
+	db.access.interval = interval
+}
+
+/**
+ * Lists notes of a notebook not accessed for at least olderThan, least recently accessed first
+ *  - notes never accessed count as accessed when they were created
+ *    (notes predating creation times count as never accessed at all)
+ *  - non-positive limit means no limit
+ * param: string        notebookName
+ * param: time.Duration olderThan
+ * param: int           limit
+ * return: ([]NoteRef, error)
+ */
+func (db *DB) StaleNotes(notebookName string, olderThan time.Duration, limit int) ([]NoteRef, error) {
+	cutoff := time.Now().Add(-olderThan)
+	pending := db.pendingAccess(notebookName)
+
+	type candidate struct {
+		ref        NoteRef
+		accessedAt time.Time
+	}
+	var candidates []candidate
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		accessBucket := notebookAccessBucket(tx, notebookKey)
+		return notebookBucket.ForEach(func(k, v []byte) error {
+			var note Note
+			if err := json.Unmarshal(v, &note); err != nil {
+				return err
+			}
+			accessedAt := note.CreatedAt
+			if t, ok := pending[note.Id]; ok {
+				accessedAt = t
+			} else if accessBucket != nil {
+				if encoded := accessBucket.Get(k); len(encoded) == 8 {
+					accessedAt = time.Unix(0, int64(binary.BigEndian.Uint64(encoded)))
+				}
+			}
+			if accessedAt.Before(cutoff) {
+				candidates = append(candidates, candidate{ref: NoteRef{Notebook: notebookName, Id: note.Id}, accessedAt: accessedAt})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].accessedAt.Equal(candidates[j].accessedAt) {
+			return candidates[i].accessedAt.Before(candidates[j].accessedAt)
+		}
+		return candidates[i].ref.Id < candidates[j].ref.Id
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	var refs []NoteRef
+	for _, c := range candidates {
+		refs = append(refs, c.ref)
+	}
+	return refs, nil
+}
+
+/**
+ * Buffers an access of a note, starting the flusher goroutine on first use
+ */
+func (db *DB) recordAccess(notebookName string, noteId uint64) {
+	db.access.mu.Lock()
+	if db.access.disabled {
+		db.access.mu.Unlock()
+		return
+	}
+	if db.access.pending == nil {
+		db.access.pending = make(map[NoteRef]time.Time)
+	}
+	db.access.pending[NoteRef{Notebook: notebookName, Id: noteId}] = time.Now()
+	start := !db.access.started
+	db.access.started = true
+	interval := db.access.interval
+	db.access.mu.Unlock()
+
+	// started outside of the lock: the flusher itself takes it
+	if start {
+		if interval <= 0 {
+			interval = DefaultAccessFlushInterval
+		}
+		db.startAccessFlusher(interval)
+	}
+}
+
+/**
+ * Returns buffered (not yet flushed) access times of notes of a notebook, keyed by note id
+ */
+func (db *DB) pendingAccess(notebookName string) map[uint64]time.Time {
+	db.access.mu.Lock()
+	defer db.access.mu.Unlock()
+	pending := make(map[uint64]time.Time)
+	for ref, t := range db.access.pending {
+		if string(db.notebookKey(ref.Notebook)) == string(db.notebookKey(notebookName)) {
+			pending[ref.Id] = t
+		}
+	}
+	return pending
+}
+
+/**
+ * Starts the goroutine flushing buffered access times every interval (stopped by Close)
+ */
+func (db *DB) startAccessFlusher(interval time.Duration) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := db.flushAccess(db.Update); err != nil && err != ErrClosed {
+					db.logf("access times could not be flushed: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	db.onClose(func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	})
+}
+
+/**
+ * Writes buffered access times using given update func (Close passes bolt's own Update,
+ * as the DB no longer admits operations by then)
+ * On failure, the accesses are put back in the buffer unless newer ones were recorded meanwhile
+ */
+func (db *DB) flushAccess(update func(func(*bolt.Tx) error) error) error {
+	db.access.mu.Lock()
+	pending := db.access.pending
+	db.access.pending = nil
+	db.access.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := update(func(tx *bolt.Tx) error {
+		accessBucket, err := tx.CreateBucketIfNotExists([]byte("Access"))
+		if err != nil {
+			return err
+		}
+		for ref, t := range pending {
+			notebookAccessBucket, err := accessBucket.CreateBucketIfNotExists(db.notebookKey(ref.Notebook))
+			if err != nil {
+				return err
+			}
+			if err := notebookAccessBucket.Put([]byte(strconv.FormatUint(ref.Id, 10)), itob(uint64(t.UnixNano()))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.access.mu.Lock()
+		if !db.access.disabled {
+			if db.access.pending == nil {
+				db.access.pending = make(map[NoteRef]time.Time)
+			}
+			for ref, t := range pending {
+				if _, ok := db.access.pending[ref]; !ok {
+					db.access.pending[ref] = t
+				}
+			}
+		}
+		db.access.mu.Unlock()
+	}
+	return err
+}
+
+/**
+ * Retrieves (2nd order) access bucket of a notebook; nil if none of its notes was accessed yet
+ */
+func notebookAccessBucket(tx *bolt.Tx, notebookKey []byte) *bolt.Bucket {
+	accessBucket := tx.Bucket([]byte("Access"))
+	if accessBucket == nil {
+		return nil
+	}
+	return accessBucket.Bucket(notebookKey)
+}
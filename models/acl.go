@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Level of access a user has to a notebook; higher levels include lower ones
+ *  - AccessOwner is held by the notebook's owner only (it can't be granted),
+ *    and allows granting access to others
+ */
+type AccessLevel int
+
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessWrite
+	AccessOwner
+)
+
+func (l AccessLevel) String() string {
+	switch l {
+	case AccessNone:
+		return "none"
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessOwner:
+		return "owner"
+	}
+	return fmt.Sprintf("AccessLevel(%d)", int(l))
+}
+
+/**
+ * Returned (wrapped in a ForbiddenError) when a user lacks access to a notebook
+ */
+var ErrForbidden = errors.New("forbidden")
+
+/**
+ * Details of a denied operation; errors.Is(err, ErrForbidden) holds for it
+ */
+type ForbiddenError struct {
+	Notebook string
+	User     string
+	Required AccessLevel
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%v: user '%s' needs %s access to notebook '%s'", ErrForbidden, e.User, e.Required, e.Notebook)
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return ErrForbidden
+}
+
+/**
+ * Grants a user given level of access (AccessRead or AccessWrite) to a notebook; AccessNone revokes access
+ * The notebook's owner always has full access, regardless of grants
+ * param: string      notebookName
+ * param: string      user
+ * param: AccessLevel level
+ * return: error
+ */
+func (db *DB) GrantAccess(notebookName string, user string, level AccessLevel) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return db.grantAccessInTx(tx, notebookName, user, level)
+	})
+}
+
+/**
+ * Returns the owner of a notebook ("" for notebooks created without a user, see ScopedDB)
+ * param: string notebookName
+ * return: (string, error)
+ */
+func (db *DB) NotebookOwner(notebookName string) (string, error) {
+	var owner string
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		owner = getNotebookMeta(tx, notebookKey).Owner
+		return nil
+	})
+	return owner, err
+}
+
+/**
+ * Core logic of GrantAccess, shared with ScopedDB
+ */
+func (db *DB) grantAccessInTx(tx *bolt.Tx, notebookName string, user string, level AccessLevel) error {
+	if level < AccessNone || level > AccessWrite {
+		return fmt.Errorf("%v access can't be granted", level)
+	}
+	notebookKey := db.notebookKey(notebookName)
+	if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+		return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+	}
+	if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+		return err
+	}
+	meta := getNotebookMeta(tx, notebookKey)
+	if level == AccessNone {
+		delete(meta.ACL, user)
+	} else {
+		if meta.ACL == nil {
+			meta.ACL = make(map[string]AccessLevel)
+		}
+		meta.ACL[user] = level
+	}
+	return putNotebookMeta(tx, notebookKey, meta)
+}
+
+/**
+ * Level of access a user has to a notebook (with given bucket key)
+ */
+func accessLevel(tx *bolt.Tx, notebookKey []byte, user string) AccessLevel {
+	meta := getNotebookMeta(tx, notebookKey)
+	if user != "" && meta.Owner == user {
+		return AccessOwner
+	}
+	return meta.ACL[user]
+}
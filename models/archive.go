@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notebooks can be archived: kept around (and readable) without getting in the way
+ *  - archived notebooks are left out of GetAllNotebookNames and of searches across notebooks,
+ *    unless WithArchivedNotebooks() / IncludeArchivedNotebooks() is passed
+ *  - their notes can still be read, listed, searched (within the notebook) and exported,
+ *    but every write against them fails with ErrNotebookArchived until they're unarchived
+ *  - the state is kept in the notebook's metadata, so archiving doesn't touch any note
+ */
+var ErrNotebookArchived = errors.New("notebook is archived")
+
+/**
+ * Option of GetAllNotebookNames
+ */
+type NotebookOption func(*notebookOptions)
+
+type notebookOptions struct {
+	includeArchived bool
+}
+
+/**
+ * Includes archived notebooks, left out by default
+ */
+func WithArchivedNotebooks() NotebookOption {
+	return func(opts *notebookOptions) {
+		opts.includeArchived = true
+	}
+}
+
+/**
+ * Includes notes of archived notebooks in searches across notebooks, left out by default
+ */
+func IncludeArchivedNotebooks() SearchOption {
+	return func(opts *searchOptions) {
+		opts.includeArchived = true
+	}
+}
+
+/**
+ * Archives a notebook (see above); archiving an archived notebook does nothing
+ * param: string notebookName
+ * return: error
+ */
+func (db *DB) ArchiveNotebook(notebookName string) error {
+	return db.setArchived(notebookName, true)
+}
+
+/**
+ * Makes an archived notebook writable (and listed) again; unarchiving a notebook that isn't
+ * archived does nothing
+ * param: string notebookName
+ * return: error
+ */
+func (db *DB) UnarchiveNotebook(notebookName string) error {
+	return db.setArchived(notebookName, false)
+}
+
+func (db *DB) setArchived(notebookName string, archived bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if getNotebookMeta(tx, notebookKey).Archived == archived {
+			return nil
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.Archived = archived
+		return putNotebookMeta(tx, notebookKey, meta)
+	})
+}
+
+/**
+ * Fails with ErrNotebookArchived if given notebook is archived
+ * (to be checked within write transactions, before anything is written)
+ */
+func (db *DB) checkNotArchived(tx *bolt.Tx, notebookName string) error {
+	if notebookArchived(tx, db.notebookKey(notebookName)) {
+		return fmt.Errorf("%w: '%s'", ErrNotebookArchived, notebookName)
+	}
+	return nil
+}
+
+func notebookArchived(tx *bolt.Tx, notebookKey []byte) bool {
+	return getNotebookMeta(tx, notebookKey).Archived
+}
+
+/**
+ * Display names of all notebooks, optionally leaving out archived ones
+ */
+func notebookNamesInTx(tx *bolt.Tx, includeArchived bool) []string {
+	var notebookNames []string
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	cursor := rootBucket.Cursor()
+	for notebookKey, _ := cursor.First(); notebookKey != nil; notebookKey, _ = cursor.Next() {
+		if !includeArchived && notebookArchived(tx, notebookKey) {
+			continue
+		}
+		notebookNames = append(notebookNames, notebookDisplayName(tx, notebookKey))
+	}
+	return notebookNames
+}
@@ -0,0 +1,408 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Files attached to notes are stored content-addressed, so that a file attached to
+ * many notes (like a signature image) is stored only once
+ *  - 'Blobs' bucket: Blobs -> SHA-256 of content (hex) -> chunk index (itob) -> bytes
+ *  - 'BlobMeta' bucket: SHA-256 -> JSON blobMeta, counting the attachments referring to the blob;
+ *    a blob is removed once its last reference is gone
+ *  - 'Attachments' bucket: Attachments -> notebook -> note id -> attachment name -> JSON Attachment
+ */
+
+/**
+ * Returned when a note has no attachment of given name
+ */
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+/**
+ * A file attached to a note
+ *  - Hash is the hex SHA-256 of the file's content, identifying the blob holding it
+ */
+type Attachment struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+/**
+ * Bookkeeping of a blob: number of attachments referring to it, and how its content is chunked
+ */
+type blobMeta struct {
+	Refs   uint64 `json:"refs"`
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+}
+
+/**
+ * Attaches a file to a note, replacing any attachment of the same name
+ * Content is streamed in chunk by chunk (see uploads.go), and isn't stored again if already stored
+ * (attached to any note), only referenced
+ * Fails with ErrAttachmentTooLarge once content is over the attachment size limit (see SetAttachmentLimit)
+ * param: string    notebookName
+ * param: uint64    noteId
+ * param: string    name
+ * param: io.Reader r
+ * return: (Attachment, error)
+ */
+func (db *DB) AddAttachment(notebookName string, noteId uint64, name string, r io.Reader) (Attachment, error) {
+	attachment := Attachment{Name: name}
+	if name == "" {
+		return attachment, errors.New("attachment name must not be empty")
+	}
+	// fail before taking in any content if the note can't be attached to
+	err := db.View(func(tx *bolt.Tx) error {
+		return db.checkAttachable(tx, notebookName, noteId)
+	})
+	if err != nil {
+		return attachment, err
+	}
+	staged, err := db.stageUpload(r)
+	if err != nil {
+		return attachment, err
+	}
+	attachment.Size = staged.size
+	attachment.Hash = staged.hash
+	attachment.AddedAt = time.Now()
+
+	err = db.commitUpload(staged, func(tx *bolt.Tx) error {
+		if err := db.checkAttachable(tx, notebookName, noteId); err != nil {
+			return err
+		}
+		return putAttachmentEntry(tx, db.notebookKey(notebookName), noteId, attachment, func() error {
+			return retainBlob(tx, attachment.Hash, nil)
+		})
+	})
+	return attachment, err
+}
+
+/**
+ * Fails unless given note exists and can be attached to (isn't read-only, nor in an archived notebook)
+ */
+func (db *DB) checkAttachable(tx *bolt.Tx, notebookName string, noteId uint64) error {
+	_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+	if err != nil {
+		return err
+	}
+	if err := checkWritable(notebookName, note, false); err != nil {
+		return err
+	}
+	return db.checkNotArchived(tx, notebookName)
+}
+
+/**
+ * Lists attachments of a note (by name)
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: ([]Attachment, error)
+ */
+func (db *DB) ListAttachments(notebookName string, noteId uint64) ([]Attachment, error) {
+	var attachments []Attachment
+	err := db.View(func(tx *bolt.Tx) error {
+		if _, _, err := db.getNoteInTx(tx, notebookName, noteId); err != nil {
+			return err
+		}
+		var err error
+		attachments, err = listAttachmentsInTx(tx, db.notebookKey(notebookName), noteId)
+		return err
+	})
+	return attachments, err
+}
+
+/**
+ * Opens an attachment of a note for streaming
+ *  - the reader holds a read transaction (like GetNoteReader does) until it is closed
+ *  - the reader seeks (like for range requests of the API), walking chunks of the blob up to the offset
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string name
+ * return: (io.ReadSeekCloser, Attachment, error)
+ */
+func (db *DB) GetAttachment(notebookName string, noteId uint64, name string) (io.ReadSeekCloser, Attachment, error) {
+	var attachment Attachment
+	if err := db.enter(); err != nil {
+		return nil, attachment, err
+	}
+	tx, err := db.DB.Begin(false)
+	if err != nil {
+		db.exit()
+		return nil, attachment, err
+	}
+	reader := &chunkReader{db: db, tx: tx}
+
+	attachment, err = getAttachmentInTx(tx, db.notebookKey(notebookName), noteId, name)
+	if err != nil {
+		reader.Close()
+		return nil, attachment, fmt.Errorf("%w of note %d in notebook '%s'", err, noteId, notebookName)
+	}
+	meta, ok := getBlobMeta(tx, attachment.Hash)
+	reader.chunks = blobBucket(tx, attachment.Hash)
+	reader.count = meta.Chunks
+	if !ok || reader.chunks == nil {
+		reader.Close()
+		return nil, attachment, fmt.Errorf("%w: blob %s of attachment '%s'", ErrMissingChunks, attachment.Hash, name)
+	}
+	return reader, attachment, nil
+}
+
+/**
+ * Removes an attachment of a note (and its blob, unless other attachments refer to it)
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string name
+ * return: error
+ */
+func (db *DB) DeleteAttachment(notebookName string, noteId uint64, name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		if _, note, err := db.getNoteInTx(tx, notebookName, noteId); err == nil {
+			if err := checkWritable(notebookName, note, false); err != nil {
+				return err
+			}
+		}
+		attachment, err := getAttachmentInTx(tx, notebookKey, noteId, name)
+		if err != nil {
+			return fmt.Errorf("%w of note %d in notebook '%s'", err, noteId, notebookName)
+		}
+		if err := noteAttachmentsBucket(tx, notebookKey, noteId).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return releaseBlob(tx, attachment.Hash)
+	})
+}
+
+/**
+ * Stores an attachment entry along with (a reference to) its content, within given transaction
+ */
+func putAttachment(tx *bolt.Tx, notebookKey []byte, noteId uint64, attachment Attachment, content []byte) error {
+	return putAttachmentEntry(tx, notebookKey, noteId, attachment, func() error {
+		return retainBlob(tx, attachment.Hash, content)
+	})
+}
+
+/**
+ * Stores an attachment entry, releasing the blob of the attachment it replaces (if any) once retain
+ * has referenced the blob of the new one
+ */
+func putAttachmentEntry(tx *bolt.Tx, notebookKey []byte, noteId uint64, attachment Attachment, retain func() error) error {
+	attachmentsBucket, err := createNoteAttachmentsBucket(tx, notebookKey, noteId)
+	if err != nil {
+		return err
+	}
+	var previous Attachment
+	encodedPrevious := attachmentsBucket.Get([]byte(attachment.Name))
+	if encodedPrevious != nil {
+		if err := json.Unmarshal(encodedPrevious, &previous); err != nil {
+			return err
+		}
+	}
+
+	// retain the new blob before releasing the replaced one, which may well be the same
+	if err := retain(); err != nil {
+		return err
+	}
+	if encodedPrevious != nil {
+		if err := releaseBlob(tx, previous.Hash); err != nil {
+			return err
+		}
+	}
+	encoded, err := json.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+	return attachmentsBucket.Put([]byte(attachment.Name), encoded)
+}
+
+func listAttachmentsInTx(tx *bolt.Tx, notebookKey []byte, noteId uint64) ([]Attachment, error) {
+	var attachments []Attachment
+	attachmentsBucket := noteAttachmentsBucket(tx, notebookKey, noteId)
+	if attachmentsBucket == nil {
+		return nil, nil
+	}
+	err := attachmentsBucket.ForEach(func(_, v []byte) error {
+		var attachment Attachment
+		if err := json.Unmarshal(v, &attachment); err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+		return nil
+	})
+	return attachments, err
+}
+
+func getAttachmentInTx(tx *bolt.Tx, notebookKey []byte, noteId uint64, name string) (Attachment, error) {
+	var attachment Attachment
+	attachmentsBucket := noteAttachmentsBucket(tx, notebookKey, noteId)
+	if attachmentsBucket == nil {
+		return attachment, fmt.Errorf("%w: '%s'", ErrAttachmentNotFound, name)
+	}
+	encoded := attachmentsBucket.Get([]byte(name))
+	if encoded == nil {
+		return attachment, fmt.Errorf("%w: '%s'", ErrAttachmentNotFound, name)
+	}
+	return attachment, json.Unmarshal(encoded, &attachment)
+}
+
+/**
+ * Removes all attachments of a note (releasing their blobs), like when the note is deleted
+ */
+func deleteAttachments(tx *bolt.Tx, notebookKey []byte, noteId uint64) error {
+	attachments, err := listAttachmentsInTx(tx, notebookKey, noteId)
+	if err != nil || attachments == nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if err := releaseBlob(tx, attachment.Hash); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte("Attachments")).Bucket(notebookKey).DeleteBucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+/**
+ * Retrieves content of a blob (nil if it doesn't exist or is incomplete)
+ */
+func readBlob(tx *bolt.Tx, hash string) []byte {
+	meta, ok := getBlobMeta(tx, hash)
+	chunks := blobBucket(tx, hash)
+	if !ok || chunks == nil {
+		return nil
+	}
+	content := make([]byte, 0, meta.Size)
+	for i := 0; i < meta.Chunks; i++ {
+		chunk := chunks.Get(itob(uint64(i)))
+		if chunk == nil {
+			return nil
+		}
+		content = append(content, chunk...)
+	}
+	return content
+}
+
+/**
+ * Adds a reference to a blob, storing the content if the blob doesn't exist yet
+ * (over any chunks of it without metadata, like those of an upload being committed: see uploads.go)
+ */
+func retainBlob(tx *bolt.Tx, hash string, content []byte) error {
+	meta, ok := getBlobMeta(tx, hash)
+	if !ok {
+		blobsBucket, err := tx.CreateBucketIfNotExists([]byte("Blobs"))
+		if err != nil {
+			return err
+		}
+		chunksBucket, err := blobsBucket.CreateBucketIfNotExists([]byte(hash))
+		if err != nil {
+			return err
+		}
+		chunks := splitChunks(string(content))
+		for i, chunk := range chunks {
+			if err := chunksBucket.Put(itob(uint64(i)), []byte(chunk)); err != nil {
+				return err
+			}
+		}
+		meta = blobMeta{Size: int64(len(content)), Chunks: len(chunks)}
+	}
+	meta.Refs++
+	return putBlobMeta(tx, hash, meta)
+}
+
+/**
+ * Drops a reference to a blob, removing the blob along with its last reference
+ */
+func releaseBlob(tx *bolt.Tx, hash string) error {
+	meta, ok := getBlobMeta(tx, hash)
+	if ok && meta.Refs > 1 {
+		meta.Refs--
+		return putBlobMeta(tx, hash, meta)
+	}
+	return deleteBlob(tx, hash)
+}
+
+func deleteBlob(tx *bolt.Tx, hash string) error {
+	if blobBucket(tx, hash) != nil {
+		if err := tx.Bucket([]byte("Blobs")).DeleteBucket([]byte(hash)); err != nil {
+			return err
+		}
+	}
+	if metaBucket := tx.Bucket([]byte("BlobMeta")); metaBucket != nil {
+		return metaBucket.Delete([]byte(hash))
+	}
+	return nil
+}
+
+func getBlobMeta(tx *bolt.Tx, hash string) (blobMeta, bool) {
+	var meta blobMeta
+	metaBucket := tx.Bucket([]byte("BlobMeta"))
+	if metaBucket == nil {
+		return meta, false
+	}
+	encoded := metaBucket.Get([]byte(hash))
+	if encoded == nil || json.Unmarshal(encoded, &meta) != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+func putBlobMeta(tx *bolt.Tx, hash string, meta blobMeta) error {
+	metaBucket, err := tx.CreateBucketIfNotExists([]byte("BlobMeta"))
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return metaBucket.Put([]byte(hash), encoded)
+}
+
+/**
+ * Retrieves (2nd order) chunks bucket of a blob; nil if it doesn't exist
+ */
+func blobBucket(tx *bolt.Tx, hash string) *bolt.Bucket {
+	blobsBucket := tx.Bucket([]byte("Blobs"))
+	if blobsBucket == nil {
+		return nil
+	}
+	return blobsBucket.Bucket([]byte(hash))
+}
+
+/**
+ * Retrieves (3rd order) attachments bucket of a note; nil if it has no attachments
+ */
+func noteAttachmentsBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) *bolt.Bucket {
+	attachmentsBucket := tx.Bucket([]byte("Attachments"))
+	if attachmentsBucket == nil {
+		return nil
+	}
+	notebookAttachmentsBucket := attachmentsBucket.Bucket(notebookKey)
+	if notebookAttachmentsBucket == nil {
+		return nil
+	}
+	return notebookAttachmentsBucket.Bucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+func createNoteAttachmentsBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) (*bolt.Bucket, error) {
+	attachmentsBucket, err := tx.CreateBucketIfNotExists([]byte("Attachments"))
+	if err != nil {
+		return nil, err
+	}
+	notebookAttachmentsBucket, err := attachmentsBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	return notebookAttachmentsBucket.CreateBucketIfNotExists([]byte(strconv.FormatUint(noteId, 10)))
+}
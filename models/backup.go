@@ -0,0 +1,23 @@
+package models
+
+import (
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Writes a consistent copy of the whole DB file to w (taken within a read
+ * transaction, so writes can go on meanwhile)
+ * param: io.Writer w
+ * return: (int64, error) Number of bytes written
+ */
+func (db *DB) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
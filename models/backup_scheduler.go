@@ -0,0 +1,388 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Layout of timestamps in names of scheduled backups ('notes-20060102-150405.db')
+ */
+const backupFileLayout = "20060102-150405"
+
+/**
+ * Time an AfterBackupCommand is given to finish, unless given otherwise
+ */
+const defaultBackupHookTimeout = 10 * time.Minute
+
+/**
+ * Details of a backup written by the scheduler, passed to hooks run after it (see AfterBackup)
+ */
+type BackupInfo struct {
+	Size      int64     `json:"size"`
+	TxId      int       `json:"tx_id"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+/**
+ * Snapshot of the state of the backup scheduler, as returned by SchedulerStatus
+ *  - Failures: runs that failed to write a backup (or to rotate old ones)
+ *  - HookFailures: failed runs of hooks (every retry counts)
+ *  - Pending: backups whose hooks have yet to succeed, oldest first (these are kept by rotation)
+ *  - LastError: error of the last failed run or hook, if any
+ */
+type BackupSchedulerStatus struct {
+	Running      bool      `json:"running"`
+	Backups      int       `json:"backups"`
+	LastBackup   string    `json:"last_backup,omitempty"`
+	LastBackupAt time.Time `json:"last_backup_at,omitempty"`
+	Failures     int       `json:"failures"`
+	HookFailures int       `json:"hook_failures"`
+	Pending      []string  `json:"pending,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+/**
+ * Option of StartBackupScheduler
+ */
+type BackupOption func(*backupScheduler)
+
+/**
+ * Calls hook after every backup written, with the path of the backup
+ *  - a backup whose hook fails is kept (rotation leaves it alone), and the hook is retried
+ *    on every following run until it succeeds
+ *  - hooks run on the scheduler's goroutine: the next run waits for them
+ * param: func(string, BackupInfo) error hook
+ * return: BackupOption
+ */
+func AfterBackup(hook func(path string, info BackupInfo) error) BackupOption {
+	return func(s *backupScheduler) {
+		s.hooks = append(s.hooks, hook)
+	}
+}
+
+/**
+ * Runs an external command after every backup written (as a hook, see AfterBackup),
+ * passing the path of the backup as its last argument
+ *  - the command is run as given, not through a shell, and is killed after timeout
+ *    (defaultBackupHookTimeout if not positive); a non-zero exit status fails the hook
+ *  - its output (stdout and stderr) is logged through the DB's Logger
+ * param: time.Duration timeout
+ * param: string        name Name or path of the executable
+ * param: ...string     args Arguments preceding the path of the backup
+ * return: BackupOption
+ */
+func AfterBackupCommand(timeout time.Duration, name string, args ...string) BackupOption {
+	if timeout <= 0 {
+		timeout = defaultBackupHookTimeout
+	}
+	args = append([]string(nil), args...)
+	return func(s *backupScheduler) {
+		s.hooks = append(s.hooks, func(path string, _ BackupInfo) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, name, append(args, path)...)
+			// processes the command started may hold on to its output after it's killed
+			cmd.WaitDelay = time.Second
+			output, err := cmd.CombinedOutput()
+			for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+				if line != "" {
+					s.db.logf("backup: %s: %s", filepath.Base(name), line)
+				}
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%s timed out after %v", name, timeout)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			return nil
+		})
+	}
+}
+
+/**
+ * State of a backup scheduler, touched only by its own goroutine (but for status)
+ *  - lastTxId is the id of the last transaction committed as of the previous backup;
+ *    as long as it doesn't change, the DB hasn't either and runs are skipped
+ *  - pending are backups having hooks yet to succeed
+ */
+type backupScheduler struct {
+	db       *DB
+	dir      string
+	keep     int
+	lastTxId int
+	backedUp bool
+	hooks    []func(path string, info BackupInfo) error
+	pending  []pendingBackup
+	statusMu sync.Mutex
+	status   BackupSchedulerStatus
+}
+
+/**
+ * Backup whose hooks (indexes into backupScheduler.hooks) have yet to succeed
+ */
+type pendingBackup struct {
+	path  string
+	info  BackupInfo
+	hooks []int
+}
+
+/**
+ * Starts a background goroutine writing a backup of the DB to 'dir/notes-YYYYMMDD-HHMMSS.db'
+ * every interval, keeping the newest `keep` backups (keep <= 0 keeps all of them)
+ *  - runs happen one after another, never overlapping; a run is skipped if
+ *    nothing was committed since the previous backup
+ *  - hooks given as options (AfterBackup, AfterBackupCommand) run after every backup written
+ *  - outcomes are logged through the DB's Logger (see SetLogger), and summed up by SchedulerStatus
+ * Returned stop func stops the goroutine and waits for a run in progress to finish;
+ * it is safe to call it more than once, and it is called by Close too
+ * param: string          dir
+ * param: time.Duration   interval
+ * param: int             keep
+ * param: ...BackupOption opts
+ * return: (func(), error)
+ */
+func (db *DB) StartBackupScheduler(dir string, interval time.Duration, keep int, opts ...BackupOption) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, errors.New("backup interval must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	scheduler := &backupScheduler{db: db, dir: dir, keep: keep, status: BackupSchedulerStatus{Running: true}}
+	for _, opt := range opts {
+		opt(scheduler)
+	}
+	db.schedulerMu.Lock()
+	db.scheduler = scheduler
+	db.schedulerMu.Unlock()
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				scheduler.tick(now)
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+			scheduler.statusMu.Lock()
+			scheduler.status.Running = false
+			scheduler.statusMu.Unlock()
+		})
+	}
+	db.onClose(stop)
+	return stop, nil
+}
+
+/**
+ * Returns the status of the backup scheduler started last (a zero status if none was started)
+ * return: BackupSchedulerStatus
+ */
+func (db *DB) SchedulerStatus() BackupSchedulerStatus {
+	db.schedulerMu.Lock()
+	scheduler := db.scheduler
+	db.schedulerMu.Unlock()
+	if scheduler == nil {
+		return BackupSchedulerStatus{}
+	}
+	scheduler.statusMu.Lock()
+	defer scheduler.statusMu.Unlock()
+	status := scheduler.status
+	status.Pending = make([]string, len(scheduler.pending))
+	for i, backup := range scheduler.pending {
+		status.Pending[i] = backup.path
+	}
+	return status
+}
+
+/**
+ * Performs a single scheduled run, logging its outcome, then runs hooks of the backups pending
+ * (the one just written included)
+ */
+func (s *backupScheduler) tick(now time.Time) {
+	file, err := s.run(now)
+	switch {
+	case err != nil:
+		s.db.logf("backup: failed: %v", err)
+		s.updateStatus(func(status *BackupSchedulerStatus) {
+			status.Failures++
+			status.LastError = err.Error()
+		})
+	case file == "":
+		// nothing changed since the previous backup
+	default:
+		s.db.logf("backup: wrote %s", file)
+	}
+	s.runHooks()
+}
+
+/**
+ * Writes a backup unless nothing changed since the previous one, then rotates old backups
+ * return: (string, error) Path of the backup written ("" if the run was skipped)
+ */
+func (s *backupScheduler) run(now time.Time) (string, error) {
+	var txId int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		txId = tx.ID()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if s.backedUp && txId == s.lastTxId {
+		return "", nil
+	}
+
+	name := filepath.Join(s.dir, "notes-"+now.UTC().Format(backupFileLayout)+".db")
+	size, err := s.write(name)
+	if err != nil {
+		return "", err
+	}
+	s.lastTxId, s.backedUp = txId, true
+	info := BackupInfo{Size: size, TxId: txId, WrittenAt: now}
+	s.updateStatus(func(status *BackupSchedulerStatus) {
+		status.Backups++
+		status.LastBackup, status.LastBackupAt = name, now
+		if len(s.hooks) > 0 {
+			backup := pendingBackup{path: name, info: info}
+			for i := range s.hooks {
+				backup.hooks = append(backup.hooks, i)
+			}
+			s.pending = append(s.pending, backup)
+		}
+	})
+	return name, s.rotate()
+}
+
+/**
+ * Runs the hooks yet to succeed of pending backups, oldest first; those failing stay pending
+ */
+func (s *backupScheduler) runHooks() {
+	s.statusMu.Lock()
+	pending := append([]pendingBackup(nil), s.pending...)
+	s.statusMu.Unlock()
+
+	var stillPending []pendingBackup
+	for _, backup := range pending {
+		var failed []int
+		for _, i := range backup.hooks {
+			if err := s.hooks[i](backup.path, backup.info); err != nil {
+				s.db.logf("backup: hook failed for %s: %v", backup.path, err)
+				failed = append(failed, i)
+				s.updateStatus(func(status *BackupSchedulerStatus) {
+					status.HookFailures++
+					status.LastError = err.Error()
+				})
+			}
+		}
+		if len(failed) > 0 {
+			backup.hooks = failed
+			stillPending = append(stillPending, backup)
+		}
+	}
+	s.updateStatus(func(*BackupSchedulerStatus) {
+		s.pending = stillPending
+	})
+}
+
+/**
+ * Changes the status (or pending backups) under the lock SchedulerStatus reads them with
+ */
+func (s *backupScheduler) updateStatus(update func(*BackupSchedulerStatus)) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	update(&s.status)
+}
+
+/**
+ * Streams a backup into a temporary file, renamed to name once complete
+ * return: (int64, error) Size of the backup
+ */
+func (s *backupScheduler) write(name string) (int64, error) {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	size, err := s.db.Backup(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return size, os.Rename(tmp.Name(), name)
+}
+
+/**
+ * Removes all but the newest `keep` scheduled backups, sparing those with hooks pending
+ */
+func (s *backupScheduler) rotate() error {
+	if s.keep <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "notes-") || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		if _, err := time.Parse(backupFileLayout, strings.TrimSuffix(strings.TrimPrefix(name, "notes-"), ".db")); err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	sort.Strings(backups)
+	for len(backups) > s.keep {
+		path := filepath.Join(s.dir, backups[0])
+		backups = backups[1:]
+		if s.isPending(path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *backupScheduler) isPending(path string) bool {
+	for _, backup := range s.pending {
+		if backup.path == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+ * Logger keeping messages, for tests to check outcomes were logged
+ */
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range l.messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Creates a scheduler as StartBackupScheduler does, but without its goroutine: tests tick it themselves
+ */
+func newTestScheduler(t *testing.T, db *DB, keep int, opts ...BackupOption) *backupScheduler {
+	t.Helper()
+	scheduler := &backupScheduler{db: db, dir: t.TempDir(), keep: keep, status: BackupSchedulerStatus{Running: true}}
+	for _, opt := range opts {
+		opt(scheduler)
+	}
+	return scheduler
+}
+
+func scheduledBackups(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+var schedulerStart = time.Date(2024, time.May, 6, 7, 8, 9, 0, time.UTC)
+
+func TestBackupSchedulerTick(t *testing.T) {
+	db := newTestDB(t)
+	logger := &recordingLogger{}
+	db.SetLogger(logger)
+	mustAddNote(t, db, "work", Note{Content: "first"})
+	scheduler := newTestScheduler(t, db, 0)
+
+	scheduler.tick(schedulerStart)
+	if got := scheduledBackups(t, scheduler.dir); len(got) != 1 || got[0] != "notes-20240506-070809.db" {
+		t.Fatalf("backups after the first run = %v, want [notes-20240506-070809.db]", got)
+	}
+	if !logger.contains("backup: wrote") {
+		t.Errorf("written backup not logged: %v", logger.messages)
+	}
+
+	// nothing changed: skipped
+	scheduler.tick(schedulerStart.Add(time.Hour))
+	if got := scheduledBackups(t, scheduler.dir); len(got) != 1 {
+		t.Errorf("backups after a run with nothing changed = %v, want 1", got)
+	}
+
+	mustAddNote(t, db, "work", Note{Content: "second"})
+	scheduler.tick(schedulerStart.Add(2 * time.Hour))
+	got := scheduledBackups(t, scheduler.dir)
+	if len(got) != 2 || got[1] != "notes-20240506-090809.db" {
+		t.Fatalf("backups after a change = %v, want a second one", got)
+	}
+
+	restored, err := GetOrCreateDB(filepath.Join(scheduler.dir, got[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if notes, err := restored.ListNotes("work"); err != nil || len(notes) != 2 {
+		t.Errorf("latest backup has %d notes (%v), want 2", len(notes), err)
+	}
+	if status := scheduler.status; status.Backups != 2 || status.Failures != 0 || !status.LastBackupAt.Equal(schedulerStart.Add(2*time.Hour)) {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestBackupSchedulerRotation(t *testing.T) {
+	db := newTestDB(t)
+	db.SetLogger(&recordingLogger{})
+	scheduler := newTestScheduler(t, db, 2)
+	for i := 0; i < 4; i++ {
+		mustAddNote(t, db, "work", Note{Content: fmt.Sprintf("note %d", i)})
+		scheduler.tick(schedulerStart.Add(time.Duration(i) * time.Hour))
+	}
+	want := []string{"notes-20240506-090809.db", "notes-20240506-100809.db"}
+	if got := scheduledBackups(t, scheduler.dir); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("backups kept = %v, want %v", got, want)
+	}
+}
+
+func TestBackupSchedulerFailingHook(t *testing.T) {
+	db := newTestDB(t)
+	logger := &recordingLogger{}
+	db.SetLogger(logger)
+	failing := true
+	var calls []string
+	scheduler := newTestScheduler(t, db, 1, AfterBackup(func(path string, info BackupInfo) error {
+		calls = append(calls, filepath.Base(path))
+		if failing {
+			return errors.New("upload failed")
+		}
+		return nil
+	}))
+
+	mustAddNote(t, db, "work", Note{Content: "first"})
+	scheduler.tick(schedulerStart)
+	mustAddNote(t, db, "work", Note{Content: "second"})
+	scheduler.tick(schedulerStart.Add(time.Hour))
+	// the first backup is beyond keep, but its hook hasn't succeeded yet
+	if got := scheduledBackups(t, scheduler.dir); len(got) != 2 {
+		t.Fatalf("backups = %v, want both kept while their hooks fail", got)
+	}
+	if !logger.contains("hook failed") {
+		t.Errorf("hook failure not logged: %v", logger.messages)
+	}
+	if status := scheduler.status; status.HookFailures != 3 || status.LastError != "upload failed" {
+		t.Errorf("status = %+v, want 3 hook failures (the first backup's retried once)", status)
+	}
+
+	failing = false
+	calls = nil
+	scheduler.tick(schedulerStart.Add(2 * time.Hour)) // nothing changed: only hooks are retried
+	if want := []string{"notes-20240506-070809.db", "notes-20240506-080809.db"}; fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("hooks retried for %v, want %v", calls, want)
+	}
+	if len(scheduler.pending) != 0 {
+		t.Errorf("%d backups still pending", len(scheduler.pending))
+	}
+	mustAddNote(t, db, "work", Note{Content: "third"})
+	scheduler.tick(schedulerStart.Add(3 * time.Hour))
+	if got := scheduledBackups(t, scheduler.dir); len(got) != 1 || got[0] != "notes-20240506-100809.db" {
+		t.Errorf("backups once hooks succeeded = %v, want only the newest", got)
+	}
+}
+
+func TestBackupSchedulerNeverOverlaps(t *testing.T) {
+	db := newTestDB(t)
+	db.SetLogger(&recordingLogger{})
+	var running, overlaps, runs int32
+	stop, err := db.StartBackupScheduler(t.TempDir(), time.Millisecond, 0, AfterBackup(func(string, BackupInfo) error {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; atomic.LoadInt32(&runs) < 5; i++ {
+		mustAddNote(t, db, "work", Note{Content: fmt.Sprintf("note %d", i)})
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop() // harmless
+	if overlaps != 0 {
+		t.Errorf("%d runs overlapped", overlaps)
+	}
+	if db.SchedulerStatus().Running {
+		t.Error("scheduler still running after stop")
+	}
+}
+
+func TestBackupSchedulerStopsOnClose(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.StartBackupScheduler(t.TempDir(), time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !db.SchedulerStatus().Running {
+		t.Fatal("scheduler not running once started")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db.SchedulerStatus().Running {
+		t.Error("scheduler still running after Close")
+	}
+	if _, err := db.StartBackupScheduler(t.TempDir(), 0, 0); err == nil {
+		t.Error("scheduler started with a zero interval")
+	}
+}
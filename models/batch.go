@@ -0,0 +1,374 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Batch plans run several bulk changes in order, like a periodic cleanup: retag notes matching a query,
+ * move others to another notebook, archive what's left (see BatchPlan)
+ *  - every step resolves its query when its turn comes, so it sees what earlier steps did (notes they
+ *    moved into its notebook, tags they added ..); a notebook that doesn't exist has no notes to match
+ *  - ExecuteBatch applies a step in write transactions of batchChunk notes each, notes gone by then
+ *    being skipped; a failing step stops the plan, and the result tells exactly which notes every step
+ *    touched (and how), so that it can be reversed by hand, or with Undo for trashed notes
+ *  - PlanBatch runs the plan the same way within a single write transaction it rolls back, so that its
+ *    preview is exactly what executing it would do, writing nothing (but holding up writers meanwhile)
+ *  - notes locked read-only are left alone, and reported as such
+ *  - archiving tags notes ArchivedTag (see notebook_policies.go); trashing deletes them, stashed for Undo
+ */
+
+/**
+ * Number of notes changed per write transaction by ExecuteBatch
+ */
+const batchChunk = 200
+
+/**
+ * Action of a batch step
+ */
+type BatchAction string
+
+const (
+	// adds AddTags and removes RemoveTags
+	BatchTag BatchAction = "tag"
+	// moves notes into Target (created if it doesn't exist), where they get fresh ids
+	BatchMove BatchAction = "move"
+	// tags notes ArchivedTag
+	BatchArchive BatchAction = "archive"
+	// deletes notes, stashing them for Undo
+	BatchTrash BatchAction = "trash"
+)
+
+// returned for batch plans that can't be run as written (like a move without target)
+var ErrInvalidBatchPlan = errors.New("invalid batch plan")
+
+// returned (internally) to roll the transaction of PlanBatch back
+var errBatchPreview = errors.New("batch preview")
+
+/**
+ * Steps to run in order (see above), loadable from JSON (see LoadBatchPlan)
+ */
+type BatchPlan struct {
+	Steps []BatchStep `json:"steps"`
+}
+
+/**
+ * A step of a batch plan: notes of Notebook matching Query get Action
+ *  - Name is for reports only
+ *  - AddTags / RemoveTags are those of BatchTag, Target that of BatchMove
+ */
+type BatchStep struct {
+	Name       string      `json:"name,omitempty"`
+	Notebook   string      `json:"notebook"`
+	Query      NoteFilter  `json:"query"`
+	Action     BatchAction `json:"action"`
+	AddTags    []string    `json:"add_tags,omitempty"`
+	RemoveTags []string    `json:"remove_tags,omitempty"`
+	Target     string      `json:"target,omitempty"`
+}
+
+/**
+ * Outcome of PlanBatch: what every step would do
+ */
+type BatchPreview struct {
+	Steps []BatchStepResult `json:"steps"`
+}
+
+/**
+ * Outcome of ExecuteBatch: what every step run did, the last one being the step that failed (if any),
+ * with the notes of the transactions it committed before failing
+ */
+type BatchResult struct {
+	Steps []BatchStepResult `json:"steps"`
+}
+
+/**
+ * What a step did (or would do)
+ *  - Matched is the number of notes its query matched when its turn came
+ *  - Changes are the notes it changed, in order of ids; Unchanged those already as the step
+ *    would make them (like tagged already), Locked those locked read-only
+ *  - Error is why the step failed, if it did
+ */
+type BatchStepResult struct {
+	Step      int           `json:"step"`
+	Name      string        `json:"name,omitempty"`
+	Action    BatchAction   `json:"action"`
+	Matched   int           `json:"matched"`
+	Changes   []BatchChange `json:"changes"`
+	Unchanged []NoteRef     `json:"unchanged,omitempty"`
+	Locked    []NoteRef     `json:"locked,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+/**
+ * A note changed by a step: where it was, where it went (moves), and its tags before and after (tag changes
+ * and archiving), so that the change can be reversed
+ */
+type BatchChange struct {
+	Ref        NoteRef  `json:"ref"`
+	MovedTo    *NoteRef `json:"moved_to,omitempty"`
+	TagsBefore []string `json:"tags_before,omitempty"`
+	TagsAfter  []string `json:"tags_after,omitempty"`
+}
+
+/**
+ * Returned by ExecuteBatch (and PlanBatch) when a step fails, stopping the plan
+ */
+type BatchStepError struct {
+	Step     int
+	Notebook string
+	Err      error
+}
+
+func (e *BatchStepError) Error() string {
+	return fmt.Sprintf("step %d (notebook '%s') failed: %v", e.Step, e.Notebook, e.Err)
+}
+
+func (e *BatchStepError) Unwrap() error {
+	return e.Err
+}
+
+/**
+ * Reads a batch plan from JSON, failing with ErrInvalidBatchPlan for malformed plans and fields
+ * plans don't have
+ * param: io.Reader r
+ * return: (BatchPlan, error)
+ */
+func LoadBatchPlan(r io.Reader) (BatchPlan, error) {
+	var plan BatchPlan
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&plan); err != nil {
+		return plan, fmt.Errorf("%w: %v", ErrInvalidBatchPlan, err)
+	}
+	return plan, plan.validate()
+}
+
+/**
+ * Previews a batch plan (see above)
+ * Fails with ErrInvalidBatchPlan for plans that can't be run, and with a *BatchStepError when a step
+ * would fail (the preview covering the steps up to it)
+ * param: BatchPlan plan
+ * return: (BatchPreview, error)
+ */
+func (db *DB) PlanBatch(plan BatchPlan) (BatchPreview, error) {
+	var preview BatchPreview
+	if err := plan.validate(); err != nil {
+		return preview, err
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i, step := range plan.Steps {
+			result := BatchStepResult{Step: i + 1, Name: step.Name, Action: step.Action, Changes: []BatchChange{}}
+			noteIds, err := db.resolveBatchStep(tx, step)
+			if err == nil {
+				result.Matched = len(noteIds)
+				err = db.applyBatchStep(tx, step, noteIds, &result)
+			}
+			if err != nil {
+				result.Error = err.Error()
+				preview.Steps = append(preview.Steps, result)
+				return &BatchStepError{Step: i + 1, Notebook: step.Notebook, Err: err}
+			}
+			preview.Steps = append(preview.Steps, result)
+		}
+		return errBatchPreview
+	})
+	if err == errBatchPreview {
+		err = nil
+	}
+	return preview, err
+}
+
+/**
+ * Runs a batch plan (see above)
+ * Fails with ErrInvalidBatchPlan for plans that can't be run (running none of it), and with a *BatchStepError
+ * when a step fails (the result covering what was done up to then)
+ * param: BatchPlan plan
+ * return: (BatchResult, error)
+ */
+func (db *DB) ExecuteBatch(plan BatchPlan) (BatchResult, error) {
+	var result BatchResult
+	if err := plan.validate(); err != nil {
+		return result, err
+	}
+	for i, step := range plan.Steps {
+		stepResult := BatchStepResult{Step: i + 1, Name: step.Name, Action: step.Action, Changes: []BatchChange{}}
+		err := db.executeBatchStep(step, &stepResult)
+		if err != nil {
+			stepResult.Error = err.Error()
+		}
+		result.Steps = append(result.Steps, stepResult)
+		if err != nil {
+			return result, &BatchStepError{Step: i + 1, Notebook: step.Notebook, Err: err}
+		}
+	}
+	return result, nil
+}
+
+/**
+ * Resolves a step in a read transaction, and applies it in write transactions of batchChunk notes each
+ */
+func (db *DB) executeBatchStep(step BatchStep, result *BatchStepResult) error {
+	var noteIds []uint64
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		noteIds, err = db.resolveBatchStep(tx, step)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	result.Matched = len(noteIds)
+	for start := 0; start < len(noteIds); start += batchChunk {
+		end := start + batchChunk
+		if end > len(noteIds) {
+			end = len(noteIds)
+		}
+		var chunk BatchStepResult
+		err := db.Update(func(tx *bolt.Tx) error {
+			chunk = BatchStepResult{}
+			return db.applyBatchStep(tx, step, noteIds[start:end], &chunk)
+		})
+		if err != nil {
+			return err
+		}
+		result.Changes = append(result.Changes, chunk.Changes...)
+		result.Unchanged = append(result.Unchanged, chunk.Unchanged...)
+		result.Locked = append(result.Locked, chunk.Locked...)
+	}
+	return nil
+}
+
+/**
+ * Ids of the notes a step's query matches, in order; a notebook that doesn't exist (yet) has none
+ */
+func (db *DB) resolveBatchStep(tx *bolt.Tx, step BatchStep) ([]uint64, error) {
+	notebookKey := db.notebookKey(step.Notebook)
+	if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+		return nil, nil
+	}
+	var noteIds []uint64
+	err := db.forEachMatchingNote(tx, notebookKey, step.Query, func(note Note) error {
+		noteIds = append(noteIds, note.Id)
+		return nil
+	})
+	return noteIds, err
+}
+
+/**
+ * Applies a step to given notes within given write transaction, recording what it did into result
+ */
+func (db *DB) applyBatchStep(tx *bolt.Tx, step BatchStep, noteIds []uint64, result *BatchStepResult) error {
+	if err := db.checkNotArchived(tx, step.Notebook); err != nil {
+		return err
+	}
+	if step.Action == BatchMove {
+		if err := db.checkNotArchived(tx, step.Target); err != nil {
+			return err
+		}
+	}
+	notebookKey := db.notebookKey(step.Notebook)
+	var trashed []uint64
+	for _, noteId := range noteIds {
+		_, note, err := db.getNoteInTx(tx, step.Notebook, noteId)
+		if errors.Is(err, ErrNoteNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ref := NoteRef{Notebook: step.Notebook, Id: note.Id}
+		if note.ReadOnly {
+			result.Locked = append(result.Locked, ref)
+			continue
+		}
+
+		switch step.Action {
+		case BatchTag, BatchArchive:
+			addTags, removeTags := step.AddTags, step.RemoveTags
+			if step.Action == BatchArchive {
+				addTags, removeTags = []string{ArchivedTag}, nil
+			}
+			tags := retag(note.Tags, addTags, removeTags)
+			if sameStrings(note.Tags, tags) {
+				result.Unchanged = append(result.Unchanged, ref)
+				continue
+			}
+			result.Changes = append(result.Changes, BatchChange{Ref: ref, TagsBefore: note.Tags, TagsAfter: tags})
+			note.Tags = tags
+			db.bumpRevision(&note)
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return fmt.Errorf("tagging note %d: %w", note.Id, err)
+			}
+		case BatchMove:
+			db.bumpRevision(&note)
+			moved, err := db.moveToNotebookInTx(tx, step.Notebook, note, step.Target)
+			if err != nil {
+				return fmt.Errorf("moving note %d: %w", note.Id, err)
+			}
+			result.Changes = append(result.Changes, BatchChange{Ref: ref, MovedTo: &NoteRef{Notebook: step.Target, Id: moved.Id}})
+		case BatchTrash:
+			trashed = append(trashed, note.Id)
+			result.Changes = append(result.Changes, BatchChange{Ref: ref})
+		}
+	}
+	if len(trashed) == 0 {
+		return nil
+	}
+	// (the plan is what confirms deletes, however many; it can be previewed)
+	_, err := db.deleteNotesInTx(tx, step.Notebook, trashed, writeOptions{confirmed: true})
+	return err
+}
+
+/**
+ * Fails with ErrInvalidBatchPlan if a step can't be run as written
+ */
+func (plan BatchPlan) validate() error {
+	if len(plan.Steps) == 0 {
+		return fmt.Errorf("%w: no steps", ErrInvalidBatchPlan)
+	}
+	for i, step := range plan.Steps {
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: step %d: %s", ErrInvalidBatchPlan, i+1, fmt.Sprintf(format, args...))
+		}
+		if step.Notebook == "" {
+			return invalid("no notebook")
+		}
+		switch step.Action {
+		case BatchTag:
+			if len(step.AddTags) == 0 && len(step.RemoveTags) == 0 {
+				return invalid("no tags to add or remove")
+			}
+			for _, tag := range append(append([]string(nil), step.AddTags...), step.RemoveTags...) {
+				if tag == "" {
+					return invalid("empty tag")
+				}
+				if containsString(step.AddTags, tag) && containsString(step.RemoveTags, tag) {
+					return invalid("'%s' is both added and removed", tag)
+				}
+			}
+		case BatchMove:
+			if step.Target == "" {
+				return invalid("no notebook to move notes to")
+			}
+			if step.Target == step.Notebook {
+				return invalid("notes would be moved into their own notebook")
+			}
+		case BatchArchive, BatchTrash:
+		default:
+			return invalid("unknown action '%s' (one of %s, %s, %s, %s)", step.Action, BatchTag, BatchMove, BatchArchive, BatchTrash)
+		}
+		if step.Action != BatchTag && (len(step.AddTags) > 0 || len(step.RemoveTags) > 0) {
+			return invalid("tags are only added or removed by '%s'", BatchTag)
+		}
+		if step.Action != BatchMove && step.Target != "" {
+			return invalid("only '%s' has a target", BatchMove)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Number of notes committed per transaction by BulkLoad when no batch size is given
+ */
+const DefaultBulkBatchSize = 1000
+
+/**
+ * Outcome of a BulkLoad
+ */
+type BulkReport struct {
+	Inserted int           `json:"inserted"`
+	Batches  []BatchTiming `json:"batches"`
+}
+
+/**
+ * Size and commit duration of one batch of a BulkLoad
+ */
+type BatchTiming struct {
+	Notes    int           `json:"notes"`
+	Duration time.Duration `json:"duration"`
+}
+
+/**
+ * Options of BulkLoad
+ */
+type bulkOptions struct {
+	relaxedDurability bool
+}
+
+/**
+ * Option of BulkLoad (see RelaxedDurability)
+ */
+type BulkOption func(*bulkOptions)
+
+/**
+ * Makes BulkLoad run within WithRelaxedDurability (see its caveats)
+ */
+func RelaxedDurability() BulkOption {
+	return func(opts *bulkOptions) {
+		opts.relaxedDurability = true
+	}
+}
+
+/**
+ * Adds notes consumed from a channel to the given notebook in batches, for throughput
+ *  - every batch of 'batchSize' notes (DefaultBulkBatchSize if not positive) is committed
+ *    in its own transaction, so an interruption leaves only complete batches persisted
+ *  - ids of a batch are allocated by bumping notebook's sequence once, instead of once per note
+ *  - notebook's defaults are applied as in AddNotes
+ * Loading stops when the channel is closed
+ * param: string        notebookName
+ * param: <-chan string notes
+ * param: int           batchSize
+ * param: ...BulkOption opts
+ * return: (BulkReport, error) Report covers the batches committed before an error (if any)
+ */
+func (db *DB) BulkLoad(notebookName string, notes <-chan string, batchSize int, opts ...BulkOption) (BulkReport, error) {
+	defer db.markBusy()()
+	var options bulkOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.relaxedDurability {
+		var report BulkReport
+		err := db.WithRelaxedDurability(func() error {
+			var err error
+			report, err = db.BulkLoad(notebookName, notes, batchSize)
+			return err
+		})
+		return report, err
+	}
+
+	var report BulkReport
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+
+	batch := make([]Note, 0, batchSize)
+	for {
+		noteContent, ok := <-notes
+		if ok {
+			batch = append(batch, Note{Content: noteContent})
+		}
+		if len(batch) == batchSize || (!ok && len(batch) > 0) {
+			start := time.Now()
+			if _, err := db.loadBatch(notebookName, batch); err != nil {
+				return report, err
+			}
+			report.Inserted += len(batch)
+			report.Batches = append(report.Batches, BatchTiming{Notes: len(batch), Duration: time.Since(start)})
+			batch = batch[:0]
+		}
+		if !ok {
+			return report, nil
+		}
+	}
+}
+
+/**
+ * Commits a single batch of BulkLoad (or an import) in its own transaction
+ */
+func (db *DB) loadBatch(notebookName string, notes []Note) ([]Note, error) {
+	batch, err := db.prepareAdd(notebookName, notes)
+	if err != nil {
+		return nil, err
+	}
+	var added []Note
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		added, err = db.commitAdd(tx, batch)
+		return err
+	})
+	return added, err
+}
+
+/**
+ * Commits notes of an import (ImportENEX, ImportKeepTakeout) in batches, keeping its report
+ *  - with existing set (content hash -> id of notes in the notebook), notes whose fingerprint
+ *    (see fingerprints.go) or content already exists are not imported but mapped onto the existing note
+ *  - with resolver set, conflicts with notes of the notebook (or ones imported earlier) are
+ *    resolved by it as they come up (see ConflictPolicy)
+ *  - onAdded (if set) is called for every note once its batch is committed
+ */
+type importBatcher struct {
+	db           *DB
+	notebookName string
+	size         int
+	report       *ImportReport
+	existing     map[string]uint64
+	// fingerprint -> id of notes in the notebook (set along with existing)
+	fingerprints map[string]uint64
+	resolver     *conflictResolver
+	onAdded      func(sourceKey string, note Note) error
+	notes        []Note
+	keys         []string
+	// conflict keys of the queued notes (with resolver set)
+	queuedKeys map[string]bool
+	// number of write transactions committed
+	commits int
+}
+
+func (db *DB) newImportBatcher(notebookName string, batchSize int, dedupe bool, policy ConflictPolicy, report *ImportReport) (*importBatcher, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	b := &importBatcher{db: db, notebookName: notebookName, size: batchSize, report: report, queuedKeys: make(map[string]bool)}
+	var err error
+	if dedupe {
+		if b.existing, err = db.contentHashes(notebookName); err != nil {
+			return nil, err
+		}
+		if b.fingerprints, err = db.fingerprints(notebookName); err != nil {
+			return nil, err
+		}
+	}
+	if b.resolver, err = db.newConflictResolver(notebookName, policy, &report.Conflicts); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+/**
+ * Queues a note read from the import source, committing the batch once it is full
+ */
+func (b *importBatcher) add(note Note, sourceKey string) error {
+	if b.existing != nil {
+		fingerprint := importFingerprint(note)
+		if id, ok := b.fingerprints[fingerprint]; ok && fingerprint != "" {
+			b.duplicate(sourceKey, id)
+			return nil
+		}
+		if fingerprint != "" && fingerprintInBatch(b.notes, fingerprint) {
+			if err := b.flush(); err != nil {
+				return err
+			}
+			b.duplicate(sourceKey, b.fingerprints[fingerprint])
+			return nil
+		}
+		if id, ok := b.existing[contentHash(note.Content)]; ok {
+			b.duplicate(sourceKey, id)
+			return nil
+		}
+		if duplicateInBatch(b.notes, note.Content) {
+			// the note it duplicates gets its id only once the batch is committed
+			if err := b.flush(); err != nil {
+				return err
+			}
+			b.duplicate(sourceKey, b.existing[contentHash(note.Content)])
+			return nil
+		}
+	}
+	if b.resolver != nil {
+		return b.addResolving(note, sourceKey)
+	}
+	return b.queue(note, sourceKey)
+}
+
+/**
+ * Queues a note, resolving its conflict first if it has one (see add)
+ */
+func (b *importBatcher) addResolving(note Note, sourceKey string) error {
+	if b.queuedKeys[conflictKey(note.Content)] {
+		// the note it conflicts with gets its id only once the batch is committed
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	existingId, ok := b.resolver.conflicting(note)
+	if !ok {
+		return b.queue(note, sourceKey)
+	}
+	var resolved Note
+	var status MappingStatus
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		resolved, status, err = b.resolver.resolveInTx(tx, existingId, note)
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrNoteReadOnly):
+		b.skip(note.Title(), sourceKey, err)
+		return nil
+	case err != nil:
+		return err
+	}
+	b.commits++
+	if status != MappingCreated {
+		b.report.Mapping = append(b.report.Mapping, mappedTo(sourceKey, b.notebookName, resolved.Id, status))
+		return nil
+	}
+	// kept both: committed right away, so that later notes see its (suffixed) title taken
+	if err := b.queue(resolved, sourceKey); err != nil {
+		return err
+	}
+	return b.flush()
+}
+
+func (b *importBatcher) queue(note Note, sourceKey string) error {
+	b.notes = append(b.notes, note)
+	b.keys = append(b.keys, sourceKey)
+	if b.resolver != nil {
+		b.queuedKeys[conflictKey(note.Content)] = true
+	}
+	if len(b.notes) == b.size {
+		return b.flush()
+	}
+	return nil
+}
+
+/**
+ * Records a note of the import source that can't be imported
+ */
+func (b *importBatcher) skip(title string, sourceKey string, err error) {
+	b.report.Skipped = append(b.report.Skipped, SkippedNote{Title: title, Reason: err.Error()})
+	b.report.Mapping = append(b.report.Mapping, mappingFailed(sourceKey, err))
+}
+
+func (b *importBatcher) duplicate(sourceKey string, id uint64) {
+	b.report.Duplicates++
+	b.report.Mapping = append(b.report.Mapping, mappedTo(sourceKey, b.notebookName, id, MappingDuplicate))
+}
+
+/**
+ * Commits the queued notes (if any)
+ */
+func (b *importBatcher) flush() error {
+	if len(b.notes) == 0 {
+		return nil
+	}
+	added, err := b.db.loadBatch(b.notebookName, b.notes)
+	for i, sourceKey := range b.keys {
+		if err != nil {
+			b.report.Mapping = append(b.report.Mapping, mappingFailed(sourceKey, err))
+			continue
+		}
+		b.report.Mapping = append(b.report.Mapping, mappedTo(sourceKey, b.notebookName, added[i].Id, MappingCreated))
+		if b.existing != nil {
+			b.existing[contentHash(added[i].Content)] = added[i].Id
+			if _, ok := b.fingerprints[added[i].Fingerprint]; !ok {
+				b.fingerprints[added[i].Fingerprint] = added[i].Id
+			}
+		}
+		if b.resolver != nil {
+			b.resolver.added(added[i])
+		}
+	}
+	keys := b.keys
+	b.notes, b.keys, b.queuedKeys = b.notes[:0], nil, make(map[string]bool)
+	if err != nil {
+		return err
+	}
+	b.commits++
+	b.report.Imported += len(added)
+	if b.onAdded != nil {
+		for i, note := range added {
+			if err := b.onAdded(keys[i], note); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func duplicateInBatch(batch []Note, content string) bool {
+	for _, note := range batch {
+		if note.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprintInBatch(batch []Note, fingerprint string) bool {
+	for _, note := range batch {
+		if importFingerprint(note) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
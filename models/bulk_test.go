@@ -0,0 +1,82 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+)
+
+// number of notes loaded by every iteration of the benchmarks below
+const benchmarkLoadNotes = 100000
+
+func smallNoteContents(n int) []string {
+	contents := make([]string, n)
+	for i := range contents {
+		contents[i] = "note " + strconv.Itoa(i) + ": a small note, like a line jotted down"
+	}
+	return contents
+}
+
+/**
+ * Opens a fresh DB outside the timer, for benchmarks loading a DB per iteration
+ */
+func openBenchmarkDB(b *testing.B) (*DB, func()) {
+	b.StopTimer()
+	defer b.StartTimer()
+	db, cleanup, err := OpenTemp()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db, cleanup
+}
+
+func BenchmarkAddNotes100k(b *testing.B) {
+	contents := smallNoteContents(benchmarkLoadNotes)
+	for i := 0; i < b.N; i++ {
+		db, cleanup := openBenchmarkDB(b)
+		if err := db.AddNotes("bulk", contents...); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		cleanup()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkBulkLoad100k(b *testing.B) {
+	benchmarkBulkLoad(b, benchmarkLoadNotes, 0)
+}
+
+// small batches make bulk loads commit (and sync) often, which relaxed durability saves on
+const benchmarkSyncedNotes, benchmarkSyncedBatch = 20000, 100
+
+func BenchmarkBulkLoadSynced(b *testing.B) {
+	benchmarkBulkLoad(b, benchmarkSyncedNotes, benchmarkSyncedBatch)
+}
+
+func BenchmarkBulkLoadRelaxedDurability(b *testing.B) {
+	benchmarkBulkLoad(b, benchmarkSyncedNotes, benchmarkSyncedBatch, RelaxedDurability())
+}
+
+func benchmarkBulkLoad(b *testing.B, n int, batchSize int, opts ...BulkOption) {
+	contents := smallNoteContents(n)
+	for i := 0; i < b.N; i++ {
+		db, cleanup := openBenchmarkDB(b)
+		notes := make(chan string, 1000)
+		go func() {
+			for _, content := range contents {
+				notes <- content
+			}
+			close(notes)
+		}()
+		report, err := db.BulkLoad("bulk", notes, batchSize, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if report.Inserted != n {
+			b.Fatalf("inserted %d notes, want %d", report.Inserted, n)
+		}
+		b.StopTimer()
+		cleanup()
+		b.StartTimer()
+	}
+}
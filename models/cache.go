@@ -0,0 +1,206 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes read by GetNote can be cached in memory (see SetNoteCache), sparing notes read over and
+ * over (like those a web UI keeps showing) the bolt lookup and decoding
+ *  - the cache is disabled unless enabled with SetNoteCache
+ *  - entries are evicted least recently used first, to keep within a number of entries and a
+ *    number of bytes of content
+ *  - every write of a note invalidates its entry as the write's transaction commits (before the
+ *    write returns), so that reads following a write never get what it replaced; reads racing
+ *    a write don't fill the cache, as they may have read what the write replaced
+ */
+
+/**
+ * Counters of the note cache, as returned by CacheStats
+ */
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+/**
+ * Overhead counted for every cached note on top of its content
+ */
+const noteCacheEntryOverhead = 256
+
+/**
+ * In-memory LRU cache of notes; its zero value is a disabled cache
+ */
+type noteCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	entries    map[noteCacheKey]*list.Element
+	// most recently used first
+	lru   *list.List
+	bytes int64
+	// bumped by every invalidation, so that reads racing writes don't fill the cache
+	generation uint64
+	hits       uint64
+	misses     uint64
+}
+
+type noteCacheKey struct {
+	notebookKey string
+	noteId      uint64
+}
+
+type noteCacheEntry struct {
+	key  noteCacheKey
+	note Note
+	size int64
+}
+
+/**
+ * Enables the note cache, bounded by a number of notes and a number of bytes (of content, plus some
+ * overhead per note; maxBytes <= 0 for no such bound); maxEntries <= 0 disables it (the default),
+ * dropping what it holds
+ * param: int   maxEntries
+ * param: int64 maxBytes
+ */
+func (db *DB) SetNoteCache(maxEntries int, maxBytes int64) {
+	c := &db.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries, c.maxBytes = maxEntries, maxBytes
+	if maxEntries <= 0 {
+		c.entries, c.lru, c.bytes = nil, nil, 0
+		return
+	}
+	if c.entries == nil {
+		c.entries, c.lru = make(map[noteCacheKey]*list.Element), list.New()
+	}
+	c.evict()
+}
+
+/**
+ * Returns counters of the note cache (hits and misses are counted since the DB was opened)
+ * return: CacheStats
+ */
+func (db *DB) CacheStats() CacheStats {
+	c := &db.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.bytes}
+}
+
+/**
+ * Invalidates the cached note (if any) once given write transaction commits
+ */
+func (db *DB) invalidateNote(tx *bolt.Tx, notebookKey []byte, noteId uint64) {
+	key := noteCacheKey{notebookKey: string(notebookKey), noteId: noteId}
+	tx.OnCommit(func() {
+		db.cache.invalidate(&key)
+	})
+}
+
+/**
+ * Invalidates all cached notes once given write transaction commits (for changes of many notes
+ * at once, like notebooks getting new keys)
+ */
+func (db *DB) invalidateNotes(tx *bolt.Tx) {
+	tx.OnCommit(func() {
+		db.cache.invalidate(nil)
+	})
+}
+
+/**
+ * Looks up a note; when it isn't cached, the returned generation is to be passed to put along
+ * with the note once read
+ * return: (Note, bool, uint64)
+ */
+func (c *noteCache) get(notebookKey []byte, noteId uint64) (Note, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return Note{}, false, c.generation
+	}
+	element, ok := c.entries[noteCacheKey{notebookKey: string(notebookKey), noteId: noteId}]
+	if !ok {
+		c.misses++
+		return Note{}, false, c.generation
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return cloneNote(element.Value.(*noteCacheEntry).note), true, c.generation
+}
+
+/**
+ * Caches a note read, unless notes were invalidated since the lookup that returned generation
+ */
+func (c *noteCache) put(notebookKey []byte, note Note, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || generation != c.generation {
+		return
+	}
+	key := noteCacheKey{notebookKey: string(notebookKey), noteId: note.Id}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	entry := &noteCacheEntry{key: key, note: cloneNote(note), size: int64(len(note.Content)) + noteCacheEntryOverhead}
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	c.evict()
+}
+
+/**
+ * Drops given note (all notes if nil)
+ */
+func (c *noteCache) invalidate(key *noteCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if c.entries == nil {
+		return
+	}
+	if key == nil {
+		c.entries, c.lru, c.bytes = make(map[noteCacheKey]*list.Element), list.New(), 0
+		return
+	}
+	if element, ok := c.entries[*key]; ok {
+		c.remove(element)
+	}
+}
+
+/**
+ * Evicts least recently used notes until the cache is within its bounds
+ */
+func (c *noteCache) evict() {
+	for len(c.entries) > c.maxEntries || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *noteCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*noteCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+/**
+ * Copy of a note not sharing tags or expiry with it
+ */
+func cloneNote(note Note) Note {
+	if note.Tags != nil {
+		note.Tags = append([]string(nil), note.Tags...)
+	}
+	if note.ExpiresAt != nil {
+		expiresAt := *note.ExpiresAt
+		note.ExpiresAt = &expiresAt
+	}
+	return note
+}
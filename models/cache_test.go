@@ -0,0 +1,151 @@
+package models_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Version of a note written by TestNoteCacheNeverStale ("v <version>")
+ */
+func cachedVersion(t *testing.T, note models.Note) int {
+	t.Helper()
+	version, err := strconv.Atoi(strings.TrimPrefix(note.Content, "v "))
+	if err != nil {
+		t.Errorf("note %d holds %q", note.Id, note.Content)
+	}
+	return version
+}
+
+func TestNoteCacheNeverStale(t *testing.T) {
+	db := notestest.NewDB(t)
+	db.SetNoteCache(16, 0)
+	note := notestest.MustAdd(t, db, "work", "v 0")
+
+	const versions, readers = 300, 4
+	written := make(chan int)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	// readers keep filling the cache while it's written, and never go back to an older version
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				read, err := db.GetNote("work", note.Id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				version := cachedVersion(t, read)
+				if version < seen {
+					t.Errorf("read version %d after version %d", version, seen)
+					return
+				}
+				seen = version
+			}
+		}()
+	}
+	// a get from another goroutine following an update sees it
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for version := range written {
+			read, err := db.GetNote("work", note.Id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := cachedVersion(t, read); got < version {
+				t.Errorf("read version %d once version %d was written", got, version)
+			}
+		}
+	}()
+	for version := 1; version <= versions; version++ {
+		if _, err := db.UpdateNote("work", note.Id, fmt.Sprintf("v %d", version)); err != nil {
+			t.Fatal(err)
+		}
+		// as does one from this goroutine
+		read, err := db.GetNote("work", note.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cachedVersion(t, read); got != version {
+			t.Fatalf("read version %d right after writing version %d", got, version)
+		}
+		written <- version
+	}
+	close(written)
+	close(done)
+	wg.Wait()
+
+	if stats := db.CacheStats(); stats.Hits == 0 || stats.Misses == 0 {
+		t.Errorf("cache stats %+v, want both hits and misses", stats)
+	}
+}
+
+func TestNoteCacheBounds(t *testing.T) {
+	db := notestest.NewDB(t)
+	ids := notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"a", "b", "c", strings.Repeat("d", 1000)}}})["work"]
+
+	// disabled by default
+	for _, id := range ids {
+		if _, err := db.GetNote("work", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := db.CacheStats(); stats != (models.CacheStats{}) {
+		t.Errorf("disabled cache stats %+v", stats)
+	}
+
+	db.SetNoteCache(2, 0)
+	for _, id := range append(ids, ids[2], ids[3]) {
+		if _, err := db.GetNote("work", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the last two notes read are cached, and were read again from the cache
+	if stats := db.CacheStats(); stats.Entries != 2 || stats.Hits != 2 || stats.Misses != 4 {
+		t.Errorf("cache stats %+v, want 2 entries, 2 hits and 4 misses", stats)
+	}
+
+	// too large for a bound in bytes, the long note isn't cached
+	db.SetNoteCache(10, 600)
+	for _, id := range ids {
+		if _, err := db.GetNote("work", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := db.CacheStats(); stats.Entries != 2 || stats.Bytes > 600 {
+		t.Errorf("cache stats %+v, want 2 entries within 600 bytes", stats)
+	}
+
+	// deleted notes are dropped
+	if err := db.DeleteNotes("work", ids...); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.CacheStats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("cache stats %+v after deleting the notes cached", stats)
+	}
+	// (a missing note reads as an empty one)
+	if read, err := db.GetNote("work", ids[0]); err != nil || read.Id != 0 {
+		t.Errorf("deleted note read as %+v (%v)", read, err)
+	}
+
+	db.SetNoteCache(0, 0)
+	if stats := db.CacheStats(); stats.Entries != 0 {
+		t.Errorf("cache stats %+v once disabled", stats)
+	}
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * DBs written by older versions lack the buckets of features that came later; every feature copes
+ * with that the same way
+ *  - buckets of a feature are created the first time it writes, within the same write transaction
+ *    (like 'History' on the first edit of a note); reads treat a missing bucket as empty
+ *  - indexes (tags, titles and URLs) get built on open for DBs that lack them; fingerprints are only
+ *    built by BackfillFingerprints, as making them of old notes changes the notes themselves
+ *  - lookups by an index that isn't built answer nothing, along with ErrIndexNotBuilt (wrapped), so that
+ *    callers can tell "nothing found" from "nothing to look in"
+ *  - Capabilities tells which features a DB has initialized
+ */
+
+/**
+ * Returned (wrapped, along with empty results) by lookups by an index the DB doesn't have
+ */
+var ErrIndexNotBuilt = errors.New("index not built")
+
+/**
+ * Features a DB has initialized (written to at least once, or built), as reported by Capabilities
+ *  - there's no separate trash: deleted notes are stashed for Undo (see UndoLog)
+ *  - Outbox tells whether changes were ever recorded, OutboxEnabled whether they currently are
+ */
+type Capabilities struct {
+	TagIndex         bool `json:"tag_index"`
+	TitleIndex       bool `json:"title_index"`
+	URLIndex         bool `json:"url_index"`
+	FingerprintIndex bool `json:"fingerprint_index"`
+	History          bool `json:"history"`
+	UndoLog          bool `json:"undo_log"`
+	Attachments      bool `json:"attachments"`
+	Relations        bool `json:"relations"`
+	Snapshots        bool `json:"snapshots"`
+	Favorites        bool `json:"favorites"`
+	Templates        bool `json:"templates"`
+	Recurring        bool `json:"recurring"`
+	Shares           bool `json:"shares"`
+	Tokens           bool `json:"tokens"`
+	Outbox           bool `json:"outbox"`
+	OutboxEnabled    bool `json:"outbox_enabled"`
+	SyncState        bool `json:"sync_state"`
+}
+
+/**
+ * Tells which features the DB has initialized (see above)
+ * return: Capabilities
+ */
+func (db *DB) Capabilities() Capabilities {
+	var capabilities Capabilities
+	db.View(func(tx *bolt.Tx) error {
+		has := func(bucketName string) bool {
+			return tx.Bucket([]byte(bucketName)) != nil
+		}
+		capabilities = Capabilities{
+			TagIndex:         has("TagIndex"),
+			TitleIndex:       has("TitleIndex"),
+			URLIndex:         has("URLs"),
+			FingerprintIndex: has("Fingerprints"),
+			History:          has("History"),
+			UndoLog:          has("UndoLog"),
+			Attachments:      has("Attachments"),
+			Relations:        has("Relations"),
+			Snapshots:        has("Snapshots"),
+			Favorites:        has("Favorites"),
+			Templates:        has("Templates"),
+			Recurring:        has("Recurring"),
+			Shares:           has("NoteShares"),
+			Tokens:           has("APITokens"),
+			Outbox:           has("Outbox"),
+			SyncState:        has("SyncState"),
+		}
+		// (settings that can't be read leave the outbox reported off)
+		settings, _ := getSettings(tx)
+		capabilities.OutboxEnabled = settings.Outbox
+		return nil
+	})
+	return capabilities
+}
+
+/**
+ * Fails with ErrIndexNotBuilt if the DB lacks given index (root bucket)
+ */
+func indexBuilt(tx *bolt.Tx, indexName string) error {
+	if tx.Bucket([]byte(indexName)) == nil {
+		return fmt.Errorf("%w: '%s'", ErrIndexNotBuilt, indexName)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package models_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Writes a DB file as the first versions did: a 'Notebook' bucket of notebooks of notes (JSON records with
+ * neither revision, kind, title, fingerprint nor clock), and no other bucket; returns its path
+ */
+func writeBareDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "old.db")
+	bare, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bare.Close()
+	err = bare.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucket([]byte("Notebook"))
+		if err != nil {
+			return err
+		}
+		for name, records := range map[string][]string{
+			"work": {
+				`{"id":1,"content":"Plan\nsee https://example.com/plan","tags":["q3"],"created_at":"2016-03-01T09:00:00Z"}`,
+				`{"id":2,"content":"standup notes","created_at":"2016-03-02T09:00:00Z"}`,
+			},
+			"home": {`{"id":1,"content":"groceries","created_at":"0001-01-01T00:00:00Z"}`},
+		} {
+			notebook, err := root.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				id, err := notebook.NextSequence()
+				if err != nil {
+					return err
+				}
+				if err := notebook.Put([]byte(fmt.Sprint(id)), []byte(record)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/**
+ * Runs a call of a feature, failing the test if it panics; returns what it returned
+ */
+func callFeature(t *testing.T, name string, call func() error) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s panicked on a bare DB: %v", name, r)
+			err = fmt.Errorf("panicked: %v", r)
+		}
+	}()
+	return call()
+}
+
+/**
+ * Calls reading every feature of a DB, by name
+ */
+func featureReads(db *models.DB) map[string]func() error {
+	work := models.NoteRef{Notebook: "work", Id: 1}
+	ignore := func(_ interface{}, err error) error { return err }
+	return map[string]func() error{
+		"ListNotes":             func() error { return ignore(db.ListNotes("work")) },
+		"GetNote":               func() error { return ignore(db.GetNote("work", 1)) },
+		"ListNoteSummaries":     func() error { return ignore(db.ListNoteSummaries("work", 20)) },
+		"GetAllNotebooks":       func() error { return ignore(db.GetAllNotebooks()) },
+		"GetNotebookInfo":       func() error { return ignore(db.GetNotebookInfo("work")) },
+		"SearchNotes":           func() error { return ignore(db.SearchNotes("work", "plan")) },
+		"SearchAllNotebooks":    func() error { return ignore(db.SearchAllNotebooks("plan")) },
+		"SuggestTags":           func() error { return ignore(db.SuggestTags("work", "q", 5)) },
+		"SuggestTitles":         func() error { return ignore(db.SuggestTitles("work", "P", 5)) },
+		"ListNotesWithURL":      func() error { return ignore(db.ListNotesWithURL("example.com")) },
+		"FindByFingerprint":     func() error { _, _, err := db.FindByFingerprint("work", "abc"); return err },
+		"GetNoteHistory":        func() error { return ignore(db.GetNoteHistory("work", 1)) },
+		"LastOperations":        func() error { return ignore(db.LastOperations(10)) },
+		"ListAttachments":       func() error { return ignore(db.ListAttachments("work", 1)) },
+		"GetRelations":          func() error { return ignore(db.GetRelations(work, "", models.Outgoing)) },
+		"ListSnapshots":         func() error { return ignore(db.ListSnapshots("work")) },
+		"ListFavorites":         func() error { return ignore(db.ListFavorites()) },
+		"ListNotebookTemplates": func() error { return ignore(db.ListNotebookTemplates()) },
+		"ListRecurringNotes":    func() error { return ignore(db.ListRecurringNotes()) },
+		"ListNoteShares":        func() error { return ignore(db.ListNoteShares()) },
+		"ListAPITokens":         func() error { return ignore(db.ListAPITokens()) },
+		"ListFilingRules":       func() error { return ignore(db.ListFilingRules()) },
+		"OutboxDepth":           func() error { return ignore(db.OutboxDepth()) },
+		"ChangesSince":          func() error { return ignore(db.ChangesSince(0)) },
+		"SyncStatus":            func() error { return ignore(db.SyncStatus()) },
+		"ListOpenTasks":         func() error { return ignore(db.ListOpenTasks("work")) },
+		"StaleNotes":            func() error { return ignore(db.StaleNotes("work", time.Hour, 10)) },
+		"GetDBStats":            func() error { return ignore(db.GetDBStats()) },
+		"CheckIntegrity":        func() error { return ignore(db.CheckIntegrity()) },
+		"PersistedSlowOps":      func() error { return ignore(db.PersistedSlowOps(10)) },
+		"GetRetentionPolicy":    func() error { return ignore(db.GetRetentionPolicy()) },
+		"ActivityHistogramAll": func() error {
+			return ignore(db.ActivityHistogramAll(time.Time{}, time.Now(), 24*time.Hour))
+		},
+		"ExportNotebook": func() error { return db.ExportNotebook("work", &bytes.Buffer{}) },
+		"ExportNote":     func() error { return db.ExportNote("work", 1, &bytes.Buffer{}, models.NoteExportOptions{}) },
+		"Capabilities":   func() error { db.Capabilities(); return nil },
+	}
+}
+
+func TestBareDBReadOnly(t *testing.T) {
+	db, err := models.OpenReadOnlyDB(writeBareDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if capabilities := db.Capabilities(); capabilities != (models.Capabilities{}) {
+		t.Errorf("capabilities of a bare DB %+v, want none", capabilities)
+	}
+	// (read-only, the file isn't upgraded: reads may fail, but mustn't panic)
+	for name, call := range featureReads(db) {
+		callFeature(t, name, call)
+	}
+	if notes, err := db.ListNotes("work"); err != nil || len(notes) != 2 || notes[0].Content != "Plan\nsee https://example.com/plan" {
+		t.Errorf("notes of a bare DB %+v (%v)", notes, err)
+	}
+	if tags, err := db.SuggestTags("work", "q", 5); !errors.Is(err, models.ErrIndexNotBuilt) || len(tags) != 0 {
+		t.Errorf("tags suggested without a tag index %q (%v), want none and ErrIndexNotBuilt", tags, err)
+	}
+}
+
+func TestBareDBFeatures(t *testing.T) {
+	db, err := models.GetOrCreateDB(writeBareDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// opening it for writing builds the indexes, but not fingerprints, which would change the notes
+	want := models.Capabilities{TagIndex: true, TitleIndex: true, URLIndex: true}
+	if capabilities := db.Capabilities(); capabilities != want {
+		t.Errorf("capabilities of a bare DB once opened %+v, want %+v", capabilities, want)
+	}
+	// every read answers as if the feature were empty; only lookups by fingerprint tell it's missing
+	for name, call := range featureReads(db) {
+		err := callFeature(t, name, call)
+		if name == "FindByFingerprint" {
+			if !errors.Is(err, models.ErrIndexNotBuilt) {
+				t.Errorf("FindByFingerprint on a bare DB: %v, want ErrIndexNotBuilt", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s on a bare DB: %v", name, err)
+		}
+	}
+	if refs, err := db.ListNotesWithURL("example.com"); err != nil || len(refs) != 1 {
+		t.Errorf("notes with URL %+v (%v)", refs, err)
+	}
+
+	// features initialize on first use
+	work := models.NoteRef{Notebook: "work", Id: 1}
+	for _, feature := range []struct {
+		name  string
+		use   func() error
+		check func(models.Capabilities) bool
+	}{
+		{"history", func() error { _, err := db.UpdateNote("work", 1, "Plan\nrevised"); return err },
+			func(c models.Capabilities) bool { return c.History }},
+		{"undo log", func() error { return db.DeleteNotes("home", 1) },
+			func(c models.Capabilities) bool { return c.UndoLog }},
+		{"attachments", func() error {
+			_, err := db.AddAttachment("work", 1, "plan.txt", strings.NewReader("attached"))
+			return err
+		}, func(c models.Capabilities) bool { return c.Attachments }},
+		{"relations", func() error { return db.AddRelation(work, models.NoteRef{Notebook: "work", Id: 2}, "follows") },
+			func(c models.Capabilities) bool { return c.Relations }},
+		{"snapshots", func() error { _, err := db.SnapshotNotebook("work", "before"); return err },
+			func(c models.Capabilities) bool { return c.Snapshots }},
+		{"favorites", func() error { return db.AddFavorite(work) },
+			func(c models.Capabilities) bool { return c.Favorites }},
+		{"templates", func() error {
+			return db.SaveNotebookTemplate("weekly", models.NotebookTemplate{Notes: []models.NoteSpec{{Content: "goals"}}})
+		}, func(c models.Capabilities) bool { return c.Templates }},
+		{"shares", func() error { _, err := db.CreateNoteShareLink("work", 1, time.Hour, 0); return err },
+			func(c models.Capabilities) bool { return c.Shares }},
+		{"tokens", func() error {
+			_, err := db.CreateAPIToken("client", []models.Scope{{Level: models.ScopeRead}})
+			return err
+		},
+			func(c models.Capabilities) bool { return c.Tokens }},
+		{"outbox", func() error {
+			if err := db.EnableOutbox(true); err != nil {
+				return err
+			}
+			_, err := db.AddNote("work", models.Note{Content: "recorded"})
+			return err
+		}, func(c models.Capabilities) bool { return c.Outbox && c.OutboxEnabled }},
+		{"fingerprints", func() error { _, err := db.BackfillFingerprints(); return err },
+			func(c models.Capabilities) bool { return c.FingerprintIndex }},
+	} {
+		if err := callFeature(t, feature.name, feature.use); err != nil {
+			t.Errorf("using %s on a bare DB: %v", feature.name, err)
+			continue
+		}
+		if capabilities := db.Capabilities(); !feature.check(capabilities) {
+			t.Errorf("%s not initialized once used: %+v", feature.name, capabilities)
+		}
+	}
+	if ref, found, err := db.FindByFingerprint("work", "abc"); err != nil || found {
+		t.Errorf("lookup by fingerprint once backfilled: %+v %v (%v)", ref, found, err)
+	}
+	if problems, err := db.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity of the DB after using every feature: %+v (%v)", problems, err)
+	}
+}
@@ -0,0 +1,515 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Content size above which a note's content is stored in chunks by default
+ */
+const DefaultChunkThreshold = 1 << 20
+
+/**
+ * Size of the chunks large content is split into
+ */
+const chunkSize = 256 << 10
+
+/**
+ * Returned when chunks of a note's content are missing or don't add up
+ */
+var ErrMissingChunks = errors.New("chunks of note content are missing")
+
+/**
+ * Large note content is stored in chunks, apart from the note record
+ *  - chunks live in 'Chunks' bucket: Chunks -> notebook -> note id -> chunk index (itob) -> bytes
+ *  - the note record keeps empty content plus a ChunkDescriptor; reads reassemble the
+ *    content (or stream it, see GetNoteReader), so callers always see plain notes
+ *  - notes stored before chunking existed are single values and stay as they are
+ */
+
+/**
+ * Describes content stored in chunks (see above)
+ *  - Hash is the hex SHA-256 of the whole content
+ */
+type ChunkDescriptor struct {
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+	Hash  string `json:"hash"`
+}
+
+/**
+ * Sets the content size above which notes are stored in chunks
+ * Non-positive threshold falls back to DefaultChunkThreshold
+ */
+func (db *DB) SetChunkThreshold(threshold int) {
+	db.chunkThreshold = threshold
+}
+
+/**
+ * Opens the content of a note for streaming, without assembling it in memory when it is chunked
+ *  - the reader holds a read transaction (and counts as an in-flight operation for Close)
+ *    until it is closed, so close it promptly
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: (io.ReadCloser, int64, error) Reader of the content, and its size
+ */
+func (db *DB) GetNoteReader(notebookName string, noteId uint64) (io.ReadCloser, int64, error) {
+	if err := db.enter(); err != nil {
+		return nil, 0, err
+	}
+	tx, err := db.DB.Begin(false)
+	if err != nil {
+		db.exit()
+		return nil, 0, err
+	}
+	reader := &chunkReader{db: db, tx: tx}
+
+	notebookKey := db.notebookKey(notebookName)
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+	if notebookBucket == nil {
+		reader.Close()
+		return nil, 0, fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+	}
+	encodedNote := notebookBucket.Get([]byte(strconv.FormatUint(noteId, 10)))
+	if encodedNote == nil {
+		reader.Close()
+		return nil, 0, fmt.Errorf("%w: %d in notebook '%s'", ErrNoteNotFound, noteId, notebookName)
+	}
+	var note Note
+	if err := json.Unmarshal(encodedNote, &note); err != nil {
+		reader.Close()
+		return nil, 0, err
+	}
+	chunks := noteChunksBucket(tx, notebookKey, noteId)
+	if note.Chunks == nil || (chunks != nil && isSealed(string(chunks.Get(itob(0))))) {
+		// content that isn't chunked, or is encrypted (and so can only be decrypted whole)
+		if note, err = db.decodeNote(tx, notebookKey, []byte(strconv.FormatUint(noteId, 10)), encodedNote); err != nil {
+			reader.Close()
+			return nil, 0, err
+		}
+		reader.current = strings.NewReader(note.Content)
+		return reader, int64(len(note.Content)), nil
+	}
+
+	reader.chunks = chunks
+	reader.count = note.Chunks.Count
+	if reader.chunks == nil {
+		reader.Close()
+		return nil, 0, fmt.Errorf("%w: note %d in notebook '%s'", ErrMissingChunks, noteId, notebookName)
+	}
+	return reader, note.Chunks.Size, nil
+}
+
+/**
+ * Streams content stored in chunks (of a note or a blob), chunk by chunk, straight out of bolt's memory map
+ */
+type chunkReader struct {
+	db      *DB
+	tx      *bolt.Tx
+	current io.Reader
+	chunks  *bolt.Bucket
+	next    int
+	count   int
+	// offset of the next byte read (see Seek)
+	pos int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.tx == nil {
+		return 0, errors.New("read from closed reader")
+	}
+	for {
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			r.pos += int64(n)
+			if err != io.EOF {
+				return n, err
+			}
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+		}
+		if r.chunks == nil || r.next >= r.count {
+			return 0, io.EOF
+		}
+		chunk := r.chunks.Get(itob(uint64(r.next)))
+		if chunk == nil {
+			return 0, fmt.Errorf("%w: chunk %d", ErrMissingChunks, r.next)
+		}
+		r.current = strings.NewReader(string(chunk))
+		r.next++
+	}
+}
+
+/**
+ * Moves to given offset of chunked content (as io.Seeker does), walking chunks up to the one holding it
+ * Offsets past the end are allowed, reads from them ending right away
+ */
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	if r.tx == nil {
+		return 0, errors.New("seek in closed reader")
+	}
+	if r.chunks == nil {
+		return 0, errors.New("seek in content that isn't chunked")
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		for i := 0; i < r.count; i++ {
+			chunk := r.chunks.Get(itob(uint64(i)))
+			if chunk == nil {
+				return 0, fmt.Errorf("%w: chunk %d", ErrMissingChunks, i)
+			}
+			offset += int64(len(chunk))
+		}
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative offset")
+	}
+
+	r.current, r.next = nil, r.count
+	remaining := offset
+	for i := 0; i < r.count; i++ {
+		chunk := r.chunks.Get(itob(uint64(i)))
+		if chunk == nil {
+			return 0, fmt.Errorf("%w: chunk %d", ErrMissingChunks, i)
+		}
+		if remaining < int64(len(chunk)) {
+			r.current = strings.NewReader(string(chunk[remaining:]))
+			r.next = i + 1
+			break
+		}
+		remaining -= int64(len(chunk))
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	if r.tx == nil {
+		return nil
+	}
+	err := r.tx.Rollback()
+	r.tx = nil
+	r.db.exit()
+	return err
+}
+
+/**
+ * Decodes a note record, reassembling chunked content and decrypting encrypted content (see encryption.go)
+ * Records that can't be read fail with a *CorruptRecord (see corrupt.go), and encrypted ones with
+ * ErrEncryptionLocked until encryption is unlocked (ErrNotebookLocked until their notebook is)
+ * param: *bolt.Tx tx
+ * param: []byte   notebookKey
+ * param: []byte   key         Key of the record within the notebook's bucket
+ * param: []byte   encodedNote
+ * return: (Note, error)
+ */
+func (db *DB) decodeNote(tx *bolt.Tx, notebookKey []byte, key []byte, encodedNote []byte) (Note, error) {
+	traceNotes(tx, notebookKey, 1, 0)
+	note, err := readNoteRecord(tx, db.sealer(), notebookKey, encodedNote)
+	if contentLocked(err) {
+		// (the record is fine: it mustn't be skipped or quarantined as corrupt)
+		return note, err
+	}
+	if err != nil {
+		return note, &CorruptRecord{Notebook: notebookDisplayName(tx, notebookKey), Key: string(key), Size: len(encodedNote), Err: err}
+	}
+	return note, nil
+}
+
+func readNoteRecord(tx *bolt.Tx, sealer contentSealer, notebookKey []byte, encodedNote []byte) (Note, error) {
+	var note Note
+	if err := json.Unmarshal(encodedNote, &note); err != nil {
+		return note, err
+	}
+	if note.Chunks == nil {
+		var err error
+		note.Content, err = sealer.open(note.Content)
+		return note, err
+	}
+
+	chunks := noteChunksBucket(tx, notebookKey, note.Id)
+	if chunks == nil {
+		return note, fmt.Errorf("%w: note %d", ErrMissingChunks, note.Id)
+	}
+	var sb strings.Builder
+	sb.Grow(int(note.Chunks.Size))
+	for i := 0; i < note.Chunks.Count; i++ {
+		chunk := chunks.Get(itob(uint64(i)))
+		if chunk == nil {
+			return note, fmt.Errorf("%w: chunk %d of note %d", ErrMissingChunks, i, note.Id)
+		}
+		sb.Write(chunk)
+	}
+	if int64(sb.Len()) != note.Chunks.Size {
+		return note, fmt.Errorf("%w: note %d has %d bytes instead of %d", ErrMissingChunks, note.Id, sb.Len(), note.Chunks.Size)
+	}
+	note.Chunks = nil
+	var err error
+	note.Content, err = sealer.open(sb.String())
+	return note, err
+}
+
+/**
+ * Content hash of a note as stored, without reassembling chunked content
+ */
+func storedContentHash(note Note) string {
+	if note.Chunks != nil {
+		return note.Chunks.Hash
+	}
+	return contentHash(note.Content)
+}
+
+/**
+ * Stores a note within given transaction, chunking its content if it is large
+ * (and dropping chunks of its previous content)
+ */
+func (db *DB) putNote(tx *bolt.Tx, notebookKey []byte, note Note) error {
+	prepared, err := encodeNote(note, db.encodingFor(tx, notebookKey))
+	if err != nil {
+		return err
+	}
+	if err := db.recordPut(tx, notebookKey, note); err != nil {
+		return err
+	}
+	db.invalidateNote(tx, notebookKey, note.Id)
+	return putEncodedNote(tx, notebookKey, note.Id, prepared)
+}
+
+/**
+ * Content size above which notes are stored in chunks
+ */
+func (db *DB) chunkLimit() int {
+	if db.chunkThreshold <= 0 {
+		return DefaultChunkThreshold
+	}
+	return db.chunkThreshold
+}
+
+/**
+ * How notes are encoded for storage
+ */
+type noteEncoding struct {
+	// content size above which notes are stored in chunks
+	chunkThreshold int
+	detector       LanguageDetector
+	normalization  NormalizeOptions
+	titles         TitleInference
+	sealer         contentSealer
+	// device notes created are counted a write of (see clock.go)
+	device string
+}
+
+func (db *DB) encoding() noteEncoding {
+	return noteEncoding{chunkThreshold: db.chunkLimit(), detector: db.detector(), normalization: db.normalization, titles: db.titles,
+		sealer: db.sealer(), device: db.deviceId}
+}
+
+/**
+ * Marshals a note for storage, detecting its language and splitting content larger than
+ * the chunk threshold into chunks (and extracting URLs of its content for the URL index, see urls.go)
+ * Content is validated against the note's kind (KindText if unset), see kind.go
+ */
+func encodeNote(note Note, encoding noteEncoding) (preparedNote, error) {
+	if note.Kind == "" {
+		note.Kind = KindText
+	}
+	if err := validateKind(note.Kind, note.Content); err != nil {
+		return preparedNote{}, err
+	}
+	if isSealed(note.Content) {
+		return preparedNote{}, fmt.Errorf("%w: content can't start with %q or %q", ErrContentMismatch, sealedContentPrefix, notebookSealedPrefix)
+	}
+	note.Language = encoding.detector.Detect(note.Content)
+	prepared := preparedNote{note: note, sealedWith: encoding.sealer.notebookKeyId}
+	record := note
+	if encoding.sealer.sealing() {
+		// (URLs of encrypted content aren't indexed, as the index would give them away)
+		var err error
+		if record.Content, err = encoding.sealer.seal(note.Content); err != nil {
+			return prepared, err
+		}
+	} else {
+		prepared.urls = extractURLs(note.Content)
+	}
+	if content := record.Content; len(content) > encoding.chunkThreshold {
+		prepared.chunks = splitChunks(content)
+		record.Content = ""
+		record.Chunks = &ChunkDescriptor{Count: len(prepared.chunks), Size: int64(len(content)), Hash: contentHash(content)}
+	}
+	var err error
+	prepared.encoded, err = json.Marshal(record)
+	return prepared, err
+}
+
+/**
+ * Puts an encoded note record along with its chunks (if any), URL index entry and tag / title / fingerprint index entries
+ */
+func putEncodedNote(tx *bolt.Tx, notebookKey []byte, noteId uint64, prepared preparedNote) error {
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+	if notebookBucket == nil {
+		return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookKey)
+	}
+	if encryption := getNotebookMeta(tx, notebookKey).Encryption; prepared.sealedWith != "" || encryption != nil {
+		// (the notebook got encrypted, or its key changed, since the note was prepared)
+		if encryption == nil || encryption.KeyId != prepared.sealedWith {
+			return errStalePrepare
+		}
+	}
+	traceNotes(tx, notebookKey, 0, 1)
+	if err := putChunks(tx, notebookKey, noteId, prepared.chunks); err != nil {
+		return err
+	}
+	if err := putURLs(tx, notebookKey, noteId, prepared.urls); err != nil {
+		return err
+	}
+	note := prepared.note
+	note.Id = noteId
+	if err := putSuggestions(tx, notebookKey, note); err != nil {
+		return err
+	}
+	if err := putFingerprint(tx, notebookKey, noteId, note.Fingerprint); err != nil {
+		return err
+	}
+	return notebookBucket.Put([]byte(strconv.FormatUint(noteId, 10)), prepared.encoded)
+}
+
+/**
+ * Removes everything stored along with a note: chunks of its content, its attachments, relations, shares, favorite
+ * entry and index entries
+ */
+func deleteNoteData(tx *bolt.Tx, notebookKey []byte, noteId uint64) error {
+	traceNotes(tx, notebookKey, 0, 1)
+	if err := deleteChunks(tx, notebookKey, noteId); err != nil {
+		return err
+	}
+	if err := deleteAttachments(tx, notebookKey, noteId); err != nil {
+		return err
+	}
+	if err := deleteRelations(tx, notebookKey, noteId); err != nil {
+		return err
+	}
+	if err := deleteNoteShares(tx, notebookKey, noteId); err != nil {
+		return err
+	}
+	if err := moveFavorite(tx, notebookKey, noteId, nil, 0); err != nil {
+		return err
+	}
+	if err := putSuggestions(tx, notebookKey, Note{Id: noteId}); err != nil {
+		return err
+	}
+	if err := putFingerprint(tx, notebookKey, noteId, ""); err != nil {
+		return err
+	}
+	return putURLs(tx, notebookKey, noteId, nil)
+}
+
+/**
+ * Replaces chunks of a note's content (no chunks just drops the previous ones)
+ */
+func putChunks(tx *bolt.Tx, notebookKey []byte, noteId uint64, chunks []string) error {
+	if err := deleteChunks(tx, notebookKey, noteId); err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	chunksBucket, err := createNoteChunksBucket(tx, notebookKey, noteId)
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := chunksBucket.Put(itob(uint64(i)), []byte(chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitChunks(content string) []string {
+	var chunks []string
+	for len(content) > chunkSize {
+		chunks = append(chunks, content[:chunkSize])
+		content = content[chunkSize:]
+	}
+	return append(chunks, content)
+}
+
+/**
+ * Retrieves (3rd order) chunks bucket of a note; nil if its content isn't chunked
+ */
+func noteChunksBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) *bolt.Bucket {
+	chunksBucket := tx.Bucket([]byte("Chunks"))
+	if chunksBucket == nil {
+		return nil
+	}
+	notebookChunksBucket := chunksBucket.Bucket(notebookKey)
+	if notebookChunksBucket == nil {
+		return nil
+	}
+	return notebookChunksBucket.Bucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+func createNoteChunksBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) (*bolt.Bucket, error) {
+	chunksBucket, err := tx.CreateBucketIfNotExists([]byte("Chunks"))
+	if err != nil {
+		return nil, err
+	}
+	notebookChunksBucket, err := chunksBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	return notebookChunksBucket.CreateBucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+/**
+ * Removes chunks of a note (if any)
+ */
+func deleteChunks(tx *bolt.Tx, notebookKey []byte, noteId uint64) error {
+	if noteChunksBucket(tx, notebookKey, noteId) == nil {
+		return nil
+	}
+	return tx.Bucket([]byte("Chunks")).Bucket(notebookKey).DeleteBucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+/**
+ * Describes what's wrong with chunks of a chunked note ("" if nothing)
+ */
+func checkChunks(tx *bolt.Tx, notebookKey []byte, note Note) string {
+	chunks := noteChunksBucket(tx, notebookKey, note.Id)
+	if chunks == nil {
+		return "all chunks missing"
+	}
+	hash := sha256.New()
+	var size int64
+	for i := 0; i < note.Chunks.Count; i++ {
+		chunk := chunks.Get(itob(uint64(i)))
+		if chunk == nil {
+			return fmt.Sprintf("chunk %d of %d missing", i, note.Chunks.Count)
+		}
+		hash.Write(chunk)
+		size += int64(len(chunk))
+	}
+	if size != note.Chunks.Size {
+		return fmt.Sprintf("chunks add up to %d bytes instead of %d", size, note.Chunks.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != note.Chunks.Hash {
+		return "content doesn't match its hash"
+	}
+	return ""
+}
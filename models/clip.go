@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/**
+ * Web pages can be clipped into notes (see ClipURL)
+ *  - HTML pages are stored as a readable Markdown version: scripts, styles and the like are dropped,
+ *    along with navigation, headers, footers, sidebars and forms; the text of the page's <article>
+ *    (or <main>) is kept if it has one, that of the whole page otherwise
+ *  - the page's title becomes the note's title, its URL (once redirected) the note's SourceURL, and the
+ *    time it was fetched the note's creation time
+ *  - anything but HTML is stored as a note made of the URL and what its headers tell (type and size)
+ *  - pages are read up to MaxClipSize, and up to MaxClipRedirects redirects are followed
+ */
+
+/**
+ * Size of HTML pages past which ClipURL gives up
+ */
+const MaxClipSize = 5 << 20
+
+/**
+ * Number of redirects ClipURL follows
+ */
+const MaxClipRedirects = 5
+
+var (
+	// returned by ClipURL for URLs that aren't http(s) ones
+	ErrInvalidClipURL = errors.New("invalid URL to clip")
+	// returned by ClipURL for pages larger than MaxClipSize
+	ErrClipTooLarge = errors.New("page too large to clip")
+	// returned by ClipURL when a page can't be fetched (request failing, error status, too many redirects)
+	ErrClipFailed = errors.New("fetching page failed")
+)
+
+// elements whose content is never text of a page, dropped before it's parsed (as they
+// may hold what doesn't parse as markup, like scripts)
+var clipDroppedBlocks = func() []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, name := range []string{"script", "style", "noscript", "template", "svg", "iframe"} {
+		patterns = append(patterns, regexp.MustCompile(`(?is)<`+name+`\b.*?</`+name+`\s*>`))
+	}
+	return append(patterns, regexp.MustCompile(`(?s)<!--.*?-->`))
+}()
+
+var clipTitlePattern = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+
+// elements (and ARIA roles) holding what surrounds the text of a page rather than the text itself
+var (
+	clipBoilerplate = map[string]bool{
+		"head": true, "nav": true, "header": true, "footer": true, "aside": true, "form": true,
+		"button": true, "select": true, "menu": true, "dialog": true,
+	}
+	clipBoilerplateRoles = map[string]bool{
+		"navigation": true, "banner": true, "contentinfo": true, "complementary": true, "search": true,
+	}
+)
+
+/**
+ * Fetches a web page and adds a note of it in given notebook (see above)
+ * client is what the page is fetched with (http.DefaultClient if nil); redirects are capped whatever
+ * its own policy, and the request is bound to ctx
+ * Fails with ErrInvalidClipURL for URLs that aren't http(s) ones, with ErrClipTooLarge for HTML pages
+ * larger than MaxClipSize and with ErrClipFailed if the page can't be fetched (or with ctx's error if
+ * it's done)
+ * param: context.Context ctx
+ * param: string          notebookName
+ * param: string          rawURL
+ * param: *http.Client    client
+ * return: (Note, error) The note as stored
+ */
+func (db *DB) ClipURL(ctx context.Context, notebookName string, rawURL string, client *http.Client) (Note, error) {
+	page, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") || page.Host == "" {
+		return Note{}, fmt.Errorf("%w: '%s'", ErrInvalidClipURL, rawURL)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	capped := *client
+	capped.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > MaxClipRedirects {
+			return fmt.Errorf("%w: more than %d redirects", ErrClipFailed, MaxClipRedirects)
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, page.String(), nil)
+	if err != nil {
+		return Note{}, fmt.Errorf("%w: '%s'", ErrInvalidClipURL, rawURL)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	resp, err := capped.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return Note{}, ctx.Err()
+		}
+		if errors.Is(err, ErrClipFailed) {
+			return Note{}, err
+		}
+		return Note{}, fmt.Errorf("%w: %v", ErrClipFailed, err)
+	}
+	defer resp.Body.Close()
+	fetched := time.Now()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Note{}, fmt.Errorf("%w: '%s' answered %s", ErrClipFailed, page, resp.Status)
+	}
+	page = resp.Request.URL
+
+	note := Note{Kind: KindMarkdown, SourceURL: page.String(), CreatedAt: fetched}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		note.TitleText = clipHeadline(page)
+		note.Content = page.String()
+		if details := clipDetails(mediaType, resp.ContentLength); details != "" {
+			note.Content += "\n\n" + details
+		}
+		return db.AddNote(notebookName, note)
+	}
+
+	if resp.ContentLength > MaxClipSize {
+		return Note{}, fmt.Errorf("%w: '%s' is %d bytes (at most %d)", ErrClipTooLarge, page, resp.ContentLength, MaxClipSize)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxClipSize+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return Note{}, ctx.Err()
+		}
+		return Note{}, fmt.Errorf("%w: reading '%s': %v", ErrClipFailed, page, err)
+	}
+	if len(body) > MaxClipSize {
+		return Note{}, fmt.Errorf("%w: '%s' is over %d bytes", ErrClipTooLarge, page, MaxClipSize)
+	}
+
+	note.TitleText, note.Content = readableHTML(string(body), page)
+	if note.TitleText == "" {
+		note.TitleText = clipHeadline(page)
+	}
+	if note.Content == "" {
+		note.Content = page.String()
+	}
+	return db.AddNote(notebookName, note)
+}
+
+/**
+ * Title and readable Markdown text of an HTML page (see above); links are resolved against base
+ * Markup that can't be parsed ends the text where it is
+ */
+func readableHTML(page string, base *url.URL) (string, string) {
+	for _, pattern := range clipDroppedBlocks {
+		page = pattern.ReplaceAllString(page, "")
+	}
+	var title string
+	if m := clipTitlePattern.FindStringSubmatch(page); m != nil {
+		title = strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(page))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	// text of the whole page, and of its <article> (or <main>), while it's being read (depth > 0)
+	whole, main := &enmlWriter{markdown: true}, &enmlWriter{markdown: true}
+	mainDepth, mainDone, skip := 0, false, 0
+	var heading *strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skip > 0 || clipBoilerplate[name] || clipBoilerplateRoles[attr(t, "role")] {
+				skip++
+				continue
+			}
+			if name == "a" {
+				t = resolveHref(t, base)
+			}
+			if name == "h1" && title == "" && heading == nil {
+				heading = &strings.Builder{}
+			}
+			t.Name.Local = name
+			whole.start(t)
+			if mainDepth > 0 {
+				mainDepth++
+				main.start(t)
+			} else if !mainDone && (name == "article" || name == "main" || attr(t, "role") == "main") {
+				mainDepth = 1
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if name == "h1" && heading != nil && title == "" {
+				title = strings.Join(strings.Fields(heading.String()), " ")
+			}
+			whole.end(name)
+			if mainDepth > 0 {
+				if mainDepth--; mainDepth == 0 {
+					mainDone = true
+				} else {
+					main.end(name)
+				}
+			}
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			if heading != nil && title == "" {
+				heading.Write(t)
+			}
+			whole.text(string(t))
+			if mainDepth > 0 {
+				main.text(string(t))
+			}
+		}
+	}
+	if text := main.String(); text != "" {
+		return title, text
+	}
+	return title, whole.String()
+}
+
+/**
+ * Link with its href resolved against base
+ */
+func resolveHref(el xml.StartElement, base *url.URL) xml.StartElement {
+	attrs := make([]xml.Attr, len(el.Attr))
+	copy(attrs, el.Attr)
+	for i, a := range attrs {
+		if a.Name.Local != "href" {
+			continue
+		}
+		if ref, err := url.Parse(strings.TrimSpace(a.Value)); err == nil {
+			attrs[i].Value = base.ResolveReference(ref).String()
+		}
+	}
+	el.Attr = attrs
+	return el
+}
+
+/**
+ * Title of a page that has none: the last segment of its path, or its host
+ */
+func clipHeadline(page *url.URL) string {
+	if name := path.Base(page.Path); name != "." && name != "/" {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			return unescaped
+		}
+		return name
+	}
+	return page.Host
+}
+
+/**
+ * What headers tell of something that isn't a page, like "_application/pdf, 1.2 MiB_"
+ */
+func clipDetails(mediaType string, size int64) string {
+	var details []string
+	if mediaType != "" {
+		details = append(details, mediaType)
+	}
+	switch {
+	case size >= 1<<20:
+		details = append(details, fmt.Sprintf("%.1f MiB", float64(size)/(1<<20)))
+	case size >= 1<<10:
+		details = append(details, fmt.Sprintf("%.1f KiB", float64(size)/(1<<10)))
+	case size >= 0:
+		details = append(details, fmt.Sprintf("%d bytes", size))
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return "_" + strings.Join(details, ", ") + "_"
+}
@@ -0,0 +1,164 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Server of the fixture pages of testdata/clip, plus pages exercising the limits of ClipURL
+ */
+func newClipServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	article, err := ioutil.ReadFile(filepath.Join("testdata", "clip", "article.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blog/shipping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(article)
+	})
+	mux.HandleFunc("/short/", func(w http.ResponseWriter, r *http.Request) {
+		// /short/3 redirects to /short/2, ... /short/0 to the article
+		hops, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/short/"))
+		if hops == 0 {
+			http.Redirect(w, r, "/blog/shipping", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/short/"+strconv.Itoa(hops-1), http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", strconv.Itoa(models.MaxClipSize+1))
+		w.Write([]byte("<p>"))
+	})
+	mux.HandleFunc("/huge-chunked", func(w http.ResponseWriter, r *http.Request) {
+		// no length announced: the page is found too large while it's read
+		w.Header().Set("Content-Type", "text/html")
+		chunk := []byte("<p>" + strings.Repeat("filler ", 1<<10) + "</p>")
+		for written := 0; written <= models.MaxClipSize; written += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4 " + strings.Repeat("x", 2038)))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClipURLArticle(t *testing.T) {
+	server := newClipServer(t)
+	db := notestest.NewDB(t)
+	before := time.Now()
+	note, err := db.ClipURL(context.Background(), "reading", server.URL+"/short/2", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the note is that of the page redirected to, fetched just now
+	if note.SourceURL != server.URL+"/blog/shipping" {
+		t.Errorf("source URL %q", note.SourceURL)
+	}
+	if note.Title() != "Shipping notes & clips | The Notes Blog" || note.Kind != models.KindMarkdown {
+		t.Errorf("title %q, kind %q", note.Title(), note.Kind)
+	}
+	if note.CreatedAt.Before(before.Add(-time.Second)) || note.CreatedAt.After(time.Now()) {
+		t.Errorf("created at %v, want the time the page was fetched", note.CreatedAt)
+	}
+
+	// only the article is kept, with links resolved; the server's address is masked to compare with the golden file
+	for _, boilerplate := range []string{"Archive", "Popular posts", "Subscribe", "©", "tracking", "commented-out", "not the article"} {
+		if strings.Contains(note.Content, boilerplate) {
+			t.Errorf("readable version holds %q:\n%s", boilerplate, note.Content)
+		}
+	}
+	notestest.AssertGolden(t, []byte(strings.ReplaceAll(note.Content, server.URL, "http://example.test")+"\n"),
+		filepath.Join("testdata", "clip", "article.md.golden"))
+
+	stored, err := db.GetNote("reading", note.Id)
+	if err != nil || stored.Content != note.Content || stored.SourceURL != note.SourceURL {
+		t.Errorf("stored note %+v (%v)", stored, err)
+	}
+}
+
+func TestClipURLNonHTML(t *testing.T) {
+	server := newClipServer(t)
+	db := notestest.NewDB(t)
+	note, err := db.ClipURL(context.Background(), "reading", server.URL+"/files/quarterly%20report.pdf", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Title() != "quarterly report.pdf" {
+		t.Errorf("title %q", note.Title())
+	}
+	if want := server.URL + "/files/quarterly%20report.pdf\n\n_application/pdf, 2.0 KiB_"; note.Content != want {
+		t.Errorf("content %q, want %q", note.Content, want)
+	}
+}
+
+func TestClipURLFailures(t *testing.T) {
+	server := newClipServer(t)
+	db := notestest.NewDB(t)
+	for _, test := range []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"not http", "ftp://example.com/file", models.ErrInvalidClipURL},
+		{"no host", "https:///path", models.ErrInvalidClipURL},
+		{"not found", server.URL + "/missing", models.ErrClipFailed},
+		{"too many redirects", server.URL + "/short/" + strconv.Itoa(models.MaxClipRedirects), models.ErrClipFailed},
+		{"redirect loop", server.URL + "/loop", models.ErrClipFailed},
+		{"announced too large", server.URL + "/huge", models.ErrClipTooLarge},
+		{"found too large", server.URL + "/huge-chunked", models.ErrClipTooLarge},
+	} {
+		if _, err := db.ClipURL(context.Background(), "reading", test.url, server.Client()); !errors.Is(err, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, err, test.want)
+		}
+	}
+	// redirects up to the cap are followed
+	if _, err := db.ClipURL(context.Background(), "reading", server.URL+"/short/"+strconv.Itoa(models.MaxClipRedirects-1), server.Client()); err != nil {
+		t.Errorf("%d redirects: %v", models.MaxClipRedirects, err)
+	}
+	if notes, err := db.ListNotes("reading"); err != nil || len(notes) != 1 {
+		t.Errorf("%d notes clipped (%v), want only the one that could be", len(notes), err)
+	}
+}
+
+func TestClipURLCancelled(t *testing.T) {
+	server := newClipServer(t)
+	db := notestest.NewDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.ClipURL(ctx, "reading", server.URL+"/slow", server.Client()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("clipping past the deadline: %v, want context.DeadlineExceeded", err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.ClipURL(cancelled, "reading", server.URL+"/blog/shipping", server.Client()); !errors.Is(err, context.Canceled) {
+		t.Errorf("clipping once cancelled: %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes carry a clock telling which writes they've seen, so that copies of a note edited on several
+ * devices can be merged (see SyncFrom)
+ *  - every DB has a device id: random, persisted in 'Meta' bucket (under 'device_id' key) the first time
+ *    the DB is opened; copies of the DB file (like a restored backup) share it
+ *  - a note's clock counts the writes of the note by device: every write bumping the note's revision
+ *    counts one for the DB's device, and notes created count one
+ *  - copies of a note are compared by their clocks (see CompareClocks): one having seen every write the
+ *    other has is newer, and neither having seen all of the other's means they were edited concurrently
+ *  - notes created before clocks existed have none, which is older than any other clock
+ *  - exports carry clocks, and imports keep them (notes of other sources count as created)
+ */
+
+/**
+ * Writes of a note, by device id
+ */
+type VectorClock map[string]uint64
+
+/**
+ * How two clocks are ordered (see CompareClocks)
+ */
+type ClockOrder string
+
+const (
+	// both clocks have seen the same writes
+	ClockEqual ClockOrder = "equal"
+	// the first clock has seen every write the second has, and more
+	ClockNewer ClockOrder = "newer"
+	// the second clock has seen every write the first has, and more
+	ClockOlder ClockOrder = "older"
+	// each clock has seen writes the other hasn't
+	ClockConcurrent ClockOrder = "concurrent"
+)
+
+/**
+ * Compares two clocks (see ClockOrder); empty clocks are older than any other
+ * param: VectorClock a
+ * param: VectorClock b
+ * return: ClockOrder
+ */
+func CompareClocks(a, b VectorClock) ClockOrder {
+	aAhead, bAhead := false, false
+	for device, count := range a {
+		if count > b[device] {
+			aAhead = true
+		}
+	}
+	for device, count := range b {
+		if count > a[device] {
+			bAhead = true
+		}
+	}
+	switch {
+	case aAhead && bAhead:
+		return ClockConcurrent
+	case aAhead:
+		return ClockNewer
+	case bAhead:
+		return ClockOlder
+	}
+	return ClockEqual
+}
+
+func (c VectorClock) String() string {
+	devices := make([]string, 0, len(c))
+	for device := range c {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	for i, device := range devices {
+		devices[i] = fmt.Sprintf("%s:%d", device, c[device])
+	}
+	return "{" + strings.Join(devices, " ") + "}"
+}
+
+/**
+ * Copy of the clock counting one more write by given device
+ */
+func (c VectorClock) tick(device string) VectorClock {
+	ticked := make(VectorClock, len(c)+1)
+	for d, count := range c {
+		ticked[d] = count
+	}
+	ticked[device]++
+	return ticked
+}
+
+/**
+ * Clock having seen every write either of given clocks has
+ */
+func mergeClocks(a, b VectorClock) VectorClock {
+	merged := make(VectorClock, len(a)+len(b))
+	for device, count := range a {
+		merged[device] = count
+	}
+	for device, count := range b {
+		if count > merged[device] {
+			merged[device] = count
+		}
+	}
+	return merged
+}
+
+/**
+ * Records a write of a note: bumps its revision, and the count of the DB's device in its clock
+ */
+func (db *DB) bumpRevision(note *Note) {
+	note.Revision++
+	note.Clock = note.Clock.tick(db.deviceId)
+}
+
+/**
+ * Returns the id of the DB's device, which clocks of notes count its writes by
+ * return: string
+ */
+func (db *DB) DeviceID() string {
+	return db.deviceId
+}
+
+/**
+ * Gives the DB a device id, if it has none yet
+ */
+func ensureDeviceId(tx *bolt.Tx) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+	if err != nil || bucket.Get([]byte("device_id")) != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	return bucket.Put([]byte("device_id"), []byte(hex.EncodeToString(id)))
+}
+
+func getDeviceId(tx *bolt.Tx) string {
+	if bucket := tx.Bucket([]byte("Meta")); bucket != nil {
+		return string(bucket.Get([]byte("device_id")))
+	}
+	return ""
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * What an import does with a note that the target notebook already has (see conflictResolver)
+ *  - notes are identified by their title (see noteTitle), or by their content if they have no title,
+ *    which is the identity every importer has
+ *  - the zero value doesn't look for conflicts: every note read is imported as a new one
+ *    (apart from ones left out by DedupeByContent, which is checked first)
+ */
+type ConflictPolicy string
+
+const (
+	// leave the existing note alone, mapping the imported one onto it
+	ConflictSkip ConflictPolicy = "skip"
+	// replace content and tags of the existing note with the imported ones (previous content goes to history)
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// import the note as a new one, suffixing its title (' (2)', ' (3)' ..) to tell them apart
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+	// append content of the imported note to the existing one (after mergeSeparator), adding its tags
+	ConflictMergeContent ConflictPolicy = "merge-content"
+)
+
+/**
+ * Put between content of the existing note and the content merged into it by ConflictMergeContent
+ */
+const mergeSeparator = "\n\n---\n\n"
+
+/**
+ * Returned for conflict policies other than the ones above
+ */
+var ErrUnknownConflictPolicy = errors.New("unknown conflict policy")
+
+/**
+ * Parses a conflict policy by name ("skip", "overwrite", "keep-both" or "merge-content")
+ * param: string name
+ * return: (ConflictPolicy, error)
+ */
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictKeepBoth, ConflictMergeContent:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w '%s' (expected skip, overwrite, keep-both or merge-content)", ErrUnknownConflictPolicy, name)
+}
+
+/**
+ * Number of conflicts of an import resolved by each policy
+ */
+type ConflictCounts struct {
+	Skipped     int `json:"skipped"`
+	Overwritten int `json:"overwritten"`
+	KeptBoth    int `json:"kept_both"`
+	Merged      int `json:"merged"`
+}
+
+func (c *ConflictCounts) count(policy ConflictPolicy) {
+	switch policy {
+	case ConflictSkip:
+		c.Skipped++
+	case ConflictOverwrite:
+		c.Overwritten++
+	case ConflictKeepBoth:
+		c.KeptBoth++
+	case ConflictMergeContent:
+		c.Merged++
+	}
+}
+
+/**
+ * Resolves conflicts between imported notes and notes of the target notebook as per a policy;
+ * shared by all importers, so that they all behave alike
+ *  - notes imported are recorded as they are added (see added), so that later notes of the
+ *    same import conflict with them too
+ */
+type conflictResolver struct {
+	db           *DB
+	notebookName string
+	policy       ConflictPolicy
+	// conflict keys (see conflictKey) of notes of the notebook -> their ids
+	existing map[string]uint64
+	// counts of conflicts resolved (those of the import's report)
+	counts *ConflictCounts
+}
+
+/**
+ * Resolver of conflicts with notes of given notebook, counting them into counts;
+ * nil (resolving nothing) for the zero policy
+ */
+func (db *DB) newConflictResolver(notebookName string, policy ConflictPolicy, counts *ConflictCounts) (*conflictResolver, error) {
+	if policy == "" {
+		return nil, nil
+	}
+	if _, err := ParseConflictPolicy(string(policy)); err != nil {
+		return nil, err
+	}
+	r := &conflictResolver{db: db, notebookName: notebookName, policy: policy, existing: make(map[string]uint64), counts: counts}
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return nil
+		}
+		return db.forEachMatchingNote(tx, notebookKey, NoteFilter{IncludeExpired: true}, func(note Note) error {
+			r.added(note)
+			return nil
+		})
+	})
+	return r, err
+}
+
+/**
+ * Identity of a note for conflicts: its title, or its content if it has none
+ */
+func conflictKey(content string) string {
+	if title, ok := noteTitle(content); ok {
+		return "title:" + title
+	}
+	return "content:" + contentHash(content)
+}
+
+/**
+ * Id of the note an imported note conflicts with, if any
+ */
+func (r *conflictResolver) conflicting(note Note) (uint64, bool) {
+	id, ok := r.existing[conflictKey(note.Content)]
+	return id, ok
+}
+
+/**
+ * Records a note added to the notebook (the first note having a key is the one conflicts resolve against)
+ */
+func (r *conflictResolver) added(note Note) {
+	if key := conflictKey(note.Content); r.existing[key] == 0 {
+		r.existing[key] = note.Id
+	}
+}
+
+/**
+ * Resolves the conflict of an imported note with note existingId within given write transaction
+ * return: (Note, MappingStatus, error) With ConflictKeepBoth, the note to import instead (and MappingCreated);
+ *         otherwise the existing note as resolution left it
+ */
+func (r *conflictResolver) resolveInTx(tx *bolt.Tx, existingId uint64, note Note) (Note, MappingStatus, error) {
+	resolved, status, err := r.resolve(tx, existingId, note)
+	if err == nil {
+		r.counts.count(r.policy)
+	}
+	return resolved, status, err
+}
+
+func (r *conflictResolver) resolve(tx *bolt.Tx, existingId uint64, note Note) (Note, MappingStatus, error) {
+	switch r.policy {
+	case ConflictSkip:
+		_, existing, err := r.db.getNoteInTx(tx, r.notebookName, existingId)
+		return existing, MappingSkipped, err
+	case ConflictOverwrite:
+		updated, err := r.db.updateNoteInTx(tx, r.notebookName, existingId, note.Content, false)
+		if err != nil {
+			return updated, MappingFailed, err
+		}
+		updated.Tags = note.Tags
+		return updated, MappingOverwritten, r.db.putNote(tx, r.db.notebookKey(r.notebookName), updated)
+	case ConflictMergeContent:
+		_, existing, err := r.db.getNoteInTx(tx, r.notebookName, existingId)
+		if err != nil {
+			return existing, MappingFailed, err
+		}
+		merged, err := r.db.updateNoteInTx(tx, r.notebookName, existingId, existing.Content+mergeSeparator+note.Content, false)
+		if err != nil {
+			return merged, MappingFailed, err
+		}
+		for _, tag := range note.Tags {
+			if !containsString(merged.Tags, tag) {
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+		return merged, MappingMerged, r.db.putNote(tx, r.db.notebookKey(r.notebookName), merged)
+	default:
+		return r.renamed(note), MappingCreated, nil
+	}
+}
+
+/**
+ * The note with its title suffixed so that it no longer conflicts (ConflictKeepBoth);
+ * notes without a title are kept as they are, getting a fresh id like any other note
+ */
+func (r *conflictResolver) renamed(note Note) Note {
+	title, ok := noteTitle(note.Content)
+	if !ok {
+		return note
+	}
+	firstLine, rest := note.Content, ""
+	if i := strings.IndexByte(note.Content, '\n'); i >= 0 {
+		firstLine, rest = note.Content[:i], note.Content[i:]
+	}
+	for n := 2; ; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		if _, taken := r.existing["title:"+title+suffix]; !taken {
+			note.Content = strings.TrimRight(firstLine, " ") + suffix + rest
+			return note
+		}
+	}
+}
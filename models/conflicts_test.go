@@ -0,0 +1,114 @@
+package models_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestImportConflictPolicies(t *testing.T) {
+	// export of notes, two of which the target notebook has by title, and one of which it has by content
+	source := notestest.NewDB(t)
+	notestest.Seed(t, source, notestest.Spec{Notebooks: map[string][]string{"work": {
+		"Plan\nimported plan",
+		"fresh one-liner",
+		"Ideas\nimported ideas",
+		"same one-liner",
+	}}, Tags: []string{"imported"}})
+	var export bytes.Buffer
+	if err := source.ExportNotebook("work", &export); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		policy   models.ConflictPolicy
+		contents []string
+		counts   models.ConflictCounts
+		statuses []models.MappingStatus
+		imported int
+	}{
+		{models.ConflictSkip,
+			[]string{"Plan\nexisting plan", "Ideas\nexisting ideas", "same one-liner", "fresh one-liner"},
+			models.ConflictCounts{Skipped: 3},
+			[]models.MappingStatus{models.MappingSkipped, models.MappingCreated, models.MappingSkipped, models.MappingSkipped}, 1},
+		{models.ConflictOverwrite,
+			[]string{"Plan\nimported plan", "Ideas\nimported ideas", "same one-liner", "fresh one-liner"},
+			models.ConflictCounts{Overwritten: 3},
+			[]models.MappingStatus{models.MappingOverwritten, models.MappingCreated, models.MappingOverwritten, models.MappingOverwritten}, 1},
+		{models.ConflictKeepBoth,
+			[]string{"Plan\nexisting plan", "Ideas\nexisting ideas", "same one-liner",
+				"Plan (2)\nimported plan", "fresh one-liner", "Ideas (2)\nimported ideas", "same one-liner"},
+			models.ConflictCounts{KeptBoth: 3},
+			[]models.MappingStatus{models.MappingCreated, models.MappingCreated, models.MappingCreated, models.MappingCreated}, 4},
+		{models.ConflictMergeContent,
+			[]string{"Plan\nexisting plan\n\n---\n\nPlan\nimported plan", "Ideas\nexisting ideas\n\n---\n\nIdeas\nimported ideas",
+				"same one-liner\n\n---\n\nsame one-liner", "fresh one-liner"},
+			models.ConflictCounts{Merged: 3},
+			[]models.MappingStatus{models.MappingMerged, models.MappingCreated, models.MappingMerged, models.MappingMerged}, 1},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			db := notestest.NewDB(t)
+			notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {
+				"Plan\nexisting plan", "Ideas\nexisting ideas", "same one-liner",
+			}}, Tags: []string{"existing"}})
+
+			report, err := db.ImportNotebook("work", bytes.NewReader(export.Bytes()), models.ImportOptions{OnConflict: test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Conflicts != test.counts || report.Imported != test.imported {
+				t.Errorf("report has %d imported and conflicts %+v, want %d and %+v",
+					report.Imported, report.Conflicts, test.imported, test.counts)
+			}
+			var statuses []models.MappingStatus
+			for _, mapping := range report.Mapping {
+				statuses = append(statuses, mapping.Status)
+			}
+			if !reflect.DeepEqual(statuses, test.statuses) {
+				t.Errorf("mapping statuses = %v, want %v", statuses, test.statuses)
+			}
+
+			notes, err := db.ListNotes("work")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var contents []string
+			for _, note := range notes {
+				contents = append(contents, note.Content)
+			}
+			if !reflect.DeepEqual(contents, test.contents) {
+				t.Errorf("notebook holds %q, want %q", contents, test.contents)
+			}
+		})
+	}
+}
+
+func TestImportConflictPoliciesTags(t *testing.T) {
+	for policy, want := range map[models.ConflictPolicy][]string{
+		models.ConflictSkip:         {"existing"},
+		models.ConflictOverwrite:    {"imported"},
+		models.ConflictMergeContent: {"existing", "imported"},
+	} {
+		source := notestest.NewDB(t)
+		notestest.Seed(t, source, notestest.Spec{Notebooks: map[string][]string{"work": {"Plan\nimported"}}, Tags: []string{"imported"}})
+		var export bytes.Buffer
+		if err := source.ExportNotebook("work", &export); err != nil {
+			t.Fatal(err)
+		}
+		db := notestest.NewDB(t)
+		notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"Plan\nexisting"}}, Tags: []string{"existing"}})
+		if _, err := db.ImportNotebook("work", &export, models.ImportOptions{OnConflict: policy}); err != nil {
+			t.Fatal(err)
+		}
+		note, err := db.GetNote("work", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(note.Tags, want) {
+			t.Errorf("%s: tags of the existing note = %v, want %v", policy, note.Tags, want)
+		}
+	}
+}
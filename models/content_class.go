@@ -0,0 +1,171 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Content is classified when it's read (see ClassifyContent), so that notes holding control characters
+ * (like pasted terminal output) or binary data can be told apart from text
+ *  - control characters are C0 controls but for tab, line feed and carriage return, and DEL
+ *  - content is binary-ish if it holds a NUL, isn't valid UTF-8, or more than a tenth of its characters
+ *    are control characters; text with fewer (like ANSI color codes) is text with control characters
+ *    (records being JSON, invalid UTF-8 is stored as U+FFFD: only content not stored yet can be invalid)
+ *  - exports meant for reading (markdown takeouts, HTML renderings) escape control characters (see
+ *    EscapeControlChars) rather than writing them as they are; JSON exports and mirrors keep content
+ *    as it is, as they're read back
+ *  - search can leave binary-ish notes out (see SkipBinaryNotes)
+ */
+
+/**
+ * Class of content (see above)
+ */
+type ContentClass string
+
+const (
+	ContentText         ContentClass = "text"
+	ContentControlChars ContentClass = "text_with_control_chars"
+	ContentBinary       ContentClass = "binary"
+)
+
+/**
+ * What a note's content is made of
+ *  - Chars counts characters (bytes of invalid UTF-8 counting one each), and Lines line feeds (plus one
+ *    for content not ending with one)
+ */
+type NoteStats struct {
+	Id           uint64       `json:"id"`
+	Bytes        int          `json:"bytes"`
+	Chars        int          `json:"chars"`
+	Lines        int          `json:"lines"`
+	Class        ContentClass `json:"class"`
+	ControlChars int          `json:"control_chars"`
+	InvalidUTF8  bool         `json:"invalid_utf8,omitempty"`
+}
+
+/**
+ * Classifies content (see above)
+ * param: string content
+ * return: ContentClass
+ */
+func ClassifyContent(content string) ContentClass {
+	return ContentStats(content).Class
+}
+
+/**
+ * Stats of content (Id left zero)
+ * param: string content
+ * return: NoteStats
+ */
+func ContentStats(content string) NoteStats {
+	stats := NoteStats{Bytes: len(content), Class: ContentText}
+	hasNUL := false
+	for i, r := range content {
+		stats.Chars++
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(content[i:], string(utf8.RuneError)):
+			stats.InvalidUTF8 = true
+		case r == '\n':
+			stats.Lines++
+		case isControlChar(r):
+			stats.ControlChars++
+			hasNUL = hasNUL || r == 0
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		stats.Lines++
+	}
+	switch {
+	case hasNUL || stats.InvalidUTF8 || stats.ControlChars*10 > stats.Chars:
+		stats.Class = ContentBinary
+	case stats.ControlChars > 0:
+		stats.Class = ContentControlChars
+	}
+	return stats
+}
+
+/**
+ * Escapes control characters of content, so that it can be written where raw ones don't belong
+ * (see above); the mapping is fixed:
+ *  - control characters U+0000 to U+001F (but for tab, line feed and carriage return) become the
+ *    matching Unicode control pictures U+2400 to U+241F (like NUL becoming '␀' and ESC '␛')
+ *  - DEL becomes '␡' (U+2421)
+ *  - bytes of invalid UTF-8 become U+FFFD, one per byte
+ * param: string content
+ * return: string
+ */
+func EscapeControlChars(content string) string {
+	clean := true
+	for i, r := range content {
+		if isControlChar(r) || (r == utf8.RuneError && !strings.HasPrefix(content[i:], string(utf8.RuneError))) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return content
+	}
+	var sb strings.Builder
+	for i, r := range content {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(content[i:], string(utf8.RuneError)):
+			sb.WriteRune(utf8.RuneError)
+		case r == 0x7f:
+			sb.WriteRune(0x2421)
+		case isControlChar(r):
+			sb.WriteRune(0x2400 + r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func isControlChar(r rune) bool {
+	return (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f
+}
+
+/**
+ * Retrieves stats of a note's content
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: (NoteStats, error)
+ */
+func (db *DB) GetNoteStats(notebookName string, noteId uint64) (NoteStats, error) {
+	note, err := db.GetNote(notebookName, noteId)
+	if err != nil {
+		return NoteStats{}, err
+	}
+	stats := ContentStats(note.Content)
+	stats.Id = note.Id
+	return stats, nil
+}
+
+/**
+ * Finds notes of a notebook whose content isn't plain text: binary-ish, or holding control characters
+ * (see above), so that they can be cleaned up
+ * Fails with ErrEncryptedSearch if content is encrypted and encrypted search isn't enabled
+ * param: string notebookName
+ * return: ([]NoteStats, error) Stats of the notes found, in order of ids
+ */
+func (db *DB) FindBinaryNotes(notebookName string) ([]NoteStats, error) {
+	if err := db.checkSearchable(); err != nil {
+		return nil, err
+	}
+	found := []NoteStats{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return db.forEachMatchingNote(tx, db.notebookKey(notebookName), NoteFilter{IncludeExpired: true}, func(note Note) error {
+			if stats := ContentStats(note.Content); stats.Class != ContentText {
+				stats.Id = note.Id
+				found = append(found, stats)
+			}
+			return nil
+		})
+	})
+	sort.Slice(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	return found, err
+}
@@ -0,0 +1,184 @@
+package models_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// contents holding what pasted terminal output and stray binaries bring along
+var (
+	ansiContent    = "build log\n\x1b[32mok\x1b[0m  notes/models\n\x1b[31mFAIL\x1b[0m notes/api\n"
+	nulContent     = "dump\x00of\x00a\x00struct"
+	invalidContent = "caf\xe9 cr\xe8me"
+	deletedContent = "typo\x7f\x7f fixed in the second draft"
+	noisyContent   = "\x01\x02\x03\x04ab"
+)
+
+func TestContentStats(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		want    models.NoteStats
+	}{
+		{"empty", "", models.NoteStats{Class: models.ContentText}},
+		{"text", "café ☕\nline two\n", models.NoteStats{Bytes: 19, Chars: 16, Lines: 2, Class: models.ContentText}},
+		{"tabs and CRLF", "a\tb\r\nc", models.NoteStats{Bytes: 6, Chars: 6, Lines: 2, Class: models.ContentText}},
+		{"replacement character", "� stays text", models.NoteStats{Bytes: 14, Chars: 12, Lines: 1, Class: models.ContentText}},
+		{"ANSI escapes", ansiContent, models.NoteStats{Bytes: 60, Chars: 60, Lines: 3, Class: models.ContentControlChars, ControlChars: 4}},
+		{"DEL", deletedContent, models.NoteStats{Bytes: 32, Chars: 32, Lines: 1, Class: models.ContentControlChars, ControlChars: 2}},
+		{"NUL", nulContent, models.NoteStats{Bytes: 16, Chars: 16, Lines: 1, Class: models.ContentBinary, ControlChars: 3}},
+		{"invalid UTF-8", invalidContent, models.NoteStats{Bytes: 10, Chars: 10, Lines: 1, Class: models.ContentBinary, InvalidUTF8: true}},
+		{"mostly control characters", noisyContent, models.NoteStats{Bytes: 6, Chars: 6, Lines: 1, Class: models.ContentBinary, ControlChars: 4}},
+	} {
+		if got := models.ContentStats(test.content); got != test.want {
+			t.Errorf("%s: stats %+v, want %+v", test.name, got, test.want)
+		}
+		if got := models.ClassifyContent(test.content); got != test.want.Class {
+			t.Errorf("%s: classified %s, want %s", test.name, got, test.want.Class)
+		}
+	}
+}
+
+func TestEscapeControlChars(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		want    string
+	}{
+		{"plain\ttext\r\n", "plain\ttext\r\n"},
+		{ansiContent, "build log\n␛[32mok␛[0m  notes/models\n␛[31mFAIL␛[0m notes/api\n"},
+		{nulContent, "dump␀of␀a␀struct"},
+		{invalidContent, "caf� cr�me"},
+		{deletedContent, "typo␡␡ fixed in the second draft"},
+		{"\x01\x1f", "␁␟"},
+	} {
+		got := models.EscapeControlChars(test.content)
+		if got != test.want {
+			t.Errorf("EscapeControlChars(%q) = %q, want %q", test.content, got, test.want)
+		}
+		// escaped content is plain text
+		if !utf8.ValidString(got) || models.ClassifyContent(got) != models.ContentText {
+			t.Errorf("EscapeControlChars(%q) = %q isn't plain text", test.content, got)
+		}
+	}
+}
+
+/**
+ * DB with a notebook holding every fixture, plus a plain note; returns ids of the notes by content
+ */
+func seedContentFixtures(t *testing.T) (*models.DB, map[string]uint64) {
+	t.Helper()
+	db := notestest.NewDB(t)
+	ids := make(map[string]uint64)
+	for _, content := range []string{"plain grep target", ansiContent, nulContent, deletedContent, noisyContent} {
+		ids[content] = notestest.MustAdd(t, db, "dumps", content+" grep target").Id
+	}
+	return db, ids
+}
+
+func TestFindBinaryNotesAndSkipThemInSearch(t *testing.T) {
+	db, ids := seedContentFixtures(t)
+	found, err := db.FindBinaryNotes("dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes := make(map[uint64]models.ContentClass)
+	for _, stats := range found {
+		classes[stats.Id] = stats.Class
+	}
+	want := map[uint64]models.ContentClass{
+		ids[ansiContent]: models.ContentControlChars, ids[deletedContent]: models.ContentControlChars,
+		ids[nulContent]: models.ContentBinary, ids[noisyContent]: models.ContentBinary,
+	}
+	if len(classes) != len(want) {
+		t.Errorf("found %+v, want %v", found, want)
+	}
+	for id, class := range want {
+		if classes[id] != class {
+			t.Errorf("note %d found as %q, want %s", id, classes[id], class)
+		}
+	}
+	stats, err := db.GetNoteStats("dumps", ids[nulContent])
+	if err != nil || stats.Class != models.ContentBinary || stats.ControlChars != 3 {
+		t.Errorf("stats of the NUL fixture %+v (%v)", stats, err)
+	}
+
+	// invalid UTF-8 is stored with its bad bytes replaced, as text
+	invalid := notestest.MustAdd(t, db, "latin1", invalidContent)
+	if stats, err := db.GetNoteStats("latin1", invalid.Id); err != nil || stats.Class != models.ContentText || stats.InvalidUTF8 {
+		t.Errorf("stats of a note added with invalid UTF-8 %+v (%v)", stats, err)
+	}
+	if stored, _ := db.GetNote("latin1", invalid.Id); stored.Content != "caf� cr�me" {
+		t.Errorf("note added with invalid UTF-8 stored as %q", stored.Content)
+	}
+
+	all, err := db.SearchNotes("dumps", "grep target")
+	if err != nil || len(all) != 5 {
+		t.Fatalf("search found %d notes (%v), want 5", len(all), err)
+	}
+	skipped := 0
+	text, err := db.SearchNotes("dumps", "grep target", models.SkipBinaryNotes(&skipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// notes with control characters are text still; binary-ish ones are skipped, and counted
+	if len(text) != 3 || skipped != 2 {
+		t.Errorf("search skipping binary notes found %d notes and skipped %d, want 3 and 2", len(text), skipped)
+	}
+	for _, result := range text {
+		if models.ClassifyContent(result.Note.Content) == models.ContentBinary {
+			t.Errorf("binary note %d found", result.Ref.Id)
+		}
+	}
+}
+
+func TestReadableExportsEscapeControlChars(t *testing.T) {
+	db, ids := seedContentFixtures(t)
+	note, err := db.GetNote("dumps", ids[ansiContent])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := models.RenderHTML(note); strings.ContainsAny(html, "\x1b\x00") || !strings.Contains(html, "␛[32mok") {
+		t.Errorf("HTML rendering %q", html)
+	}
+
+	dir := t.TempDir()
+	if _, err := db.Takeout(dir, models.TakeoutOptions{Format: "markdown"}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "notebooks", "*.md"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("markdown takeout wrote %v (%v)", files, err)
+	}
+	markdown, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, escaped := range []string{"␛[31mFAIL␛[0m", "dump␀of␀a␀struct", "typo␡␡ fixed in the second draft", "␁␂␃␄ab"} {
+		if !strings.Contains(string(markdown), escaped) {
+			t.Errorf("markdown takeout lacks %q", escaped)
+		}
+	}
+	if strings.ContainsAny(string(markdown), "\x00\x01\x1b\x7f") {
+		t.Errorf("markdown takeout holds raw control characters:\n%q", markdown)
+	}
+
+	// while JSON exports keep content as it is, to be read back
+	var export strings.Builder
+	if err := db.ExportNotebook("dumps", &export); err != nil {
+		t.Fatal(err)
+	}
+	imported := notestest.NewDB(t)
+	if _, err := imported.ImportNotebook("dumps", strings.NewReader(export.String()), models.ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := imported.FindBinaryNotes("dumps")
+	if err != nil || len(found) != 4 {
+		t.Errorf("%d notes found not plain text after a JSON round trip (%v), want 4", len(found), err)
+	}
+}
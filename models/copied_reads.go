@@ -0,0 +1,213 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Listings and exports of notebooks read note records in two steps, so as not to hold their read
+ * transaction open (pinning pages writers can't reuse meanwhile) while JSON is decoded
+ *  - raw records are copied out of the transaction (bolt's byte slices being valid only within it), then
+ *    decoded, filtered and handed over once it's closed
+ *  - records of notes whose content is in chunks (see chunks.go) are decoded within the transaction,
+ *    as their content has to be read from other buckets anyway
+ *  - copies are capped by the decode buffer (see SetDecodeBuffer): past it, notes are decoded within the
+ *    transaction as they're read, as they used to be, so that enormous notebooks aren't copied whole
+ *  - DecodeInTx() (or a negative decode buffer) decodes within the transaction throughout, for memory-constrained use
+ */
+
+/**
+ * Size of note records copied out of a read transaction before falling back to decoding within it,
+ * unless set otherwise (see SetDecodeBuffer)
+ */
+const DefaultDecodeBuffer = 64 << 20
+
+// what a record holding content chunks has, and no other record (quotes within strings are escaped)
+var chunkedRecordMarker = []byte(`"chunks":{`)
+
+/**
+ * Sets how many bytes of note records listings and exports copy out of their read transaction (see above)
+ * Zero falls back to DefaultDecodeBuffer; a negative size decodes notes within the transaction throughout
+ */
+func (db *DB) SetDecodeBuffer(size int64) {
+	db.decodeBuffer = size
+}
+
+func (db *DB) decodeBufferSize() int64 {
+	if db.decodeBuffer == 0 {
+		return DefaultDecodeBuffer
+	}
+	return db.decodeBuffer
+}
+
+/**
+ * Decodes notes within the read transaction of the listing or export, copying no records out of it
+ * (see above)
+ */
+func DecodeInTx() ListOption {
+	return func(filter *NoteFilter) {
+		filter.DecodeInTx = true
+	}
+}
+
+/**
+ * Hooks of readNotes
+ *  - begin is called first within the transaction (if set), failing the read if it fails
+ *  - copied is called within the transaction for every record copied out of it (if set), returning how many
+ *    bytes it keeps for the note (counted towards the decode buffer)
+ *  - each is called for every matching note, in order of ids, with the transaction while it's open
+ *    (nil once it's closed)
+ */
+type notesRead struct {
+	begin  func(tx *bolt.Tx) error
+	copied func(tx *bolt.Tx, noteId uint64) (int64, error)
+	each   func(tx *bolt.Tx, note Note) error
+}
+
+/**
+ * A record copied out of a read transaction, or decoded within it (note / err set)
+ */
+type copiedRecord struct {
+	key     []byte
+	value   []byte
+	decoded bool
+	note    Note
+	err     error
+}
+
+/**
+ * Reads the notes of a notebook matching filter in a read transaction of its own (see above); a notebook
+ * that doesn't exist has no notes
+ * Corrupt records fail the read, or are skipped, as per the read policy (see SetReadPolicy)
+ */
+func (db *DB) readNotes(notebookKey []byte, filter NoteFilter, r notesRead) error {
+	limit := db.decodeBufferSize()
+	if filter.DecodeInTx {
+		limit = -1
+	}
+	var records []copiedRecord
+	var notebookName string
+	err := db.View(func(tx *bolt.Tx) error {
+		if r.begin != nil {
+			if err := r.begin(tx); err != nil {
+				return err
+			}
+		}
+		if limit < 0 {
+			return db.forEachMatchingNote(tx, notebookKey, filter, func(note Note) error {
+				return r.each(tx, note)
+			})
+		}
+		if filter.Text != "" {
+			if err := db.checkSearchable(); err != nil {
+				return err
+			}
+		}
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		notebookName = notebookDisplayName(tx, notebookKey)
+
+		var size int64
+		cursor := newIdCursor(notebookBucket)
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if size > limit {
+				// past the buffer: hand over what was copied, and decode the rest as it's read
+				inTx := func(note Note) error { return r.each(tx, note) }
+				if err := db.handOver(records, filter, notebookName, inTx); err != nil {
+					return err
+				}
+				records = nil
+				for ; k != nil; k, v = cursor.Next() {
+					if err := db.handOver([]copiedRecord{db.decodeRecord(tx, notebookKey, k, v)}, filter, notebookName, inTx); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			record := copiedRecord{key: append([]byte(nil), k...)}
+			if bytes.Contains(v, chunkedRecordMarker) {
+				record = db.decodeRecord(tx, notebookKey, k, v)
+				size += int64(len(record.note.Content))
+			} else {
+				traceNotes(tx, notebookKey, 1, 0)
+				record.value = append([]byte(nil), v...)
+				size += int64(len(v))
+			}
+			if r.copied != nil {
+				noteId, _ := strconv.ParseUint(string(k), 10, 64)
+				kept, err := r.copied(tx, noteId)
+				if err != nil {
+					return err
+				}
+				size += kept
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.handOver(records, filter, notebookName, func(note Note) error { return r.each(nil, note) })
+}
+
+/**
+ * Decodes a record within its transaction
+ */
+func (db *DB) decodeRecord(tx *bolt.Tx, notebookKey []byte, k, v []byte) copiedRecord {
+	note, err := db.decodeNote(tx, notebookKey, k, v)
+	return copiedRecord{key: append([]byte(nil), k...), decoded: true, note: note, err: err}
+}
+
+/**
+ * Decodes records (unless they were decoded already) and calls fn for those matching filter
+ */
+func (db *DB) handOver(records []copiedRecord, filter NoteFilter, notebookName string, fn func(Note) error) error {
+	now := time.Now()
+	detector := db.detector()
+	for _, record := range records {
+		note, err := record.note, record.err
+		if !record.decoded {
+			note, err = db.decodeCopiedNote(notebookName, record)
+		}
+		if db.skipCorrupt(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !filter.matches(note, now, detector) {
+			continue
+		}
+		if err := fn(note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Same as decodeNote, for records copied out of their transaction (whose content isn't in chunks)
+ */
+func (db *DB) decodeCopiedNote(notebookName string, record copiedRecord) (Note, error) {
+	var note Note
+	err := json.Unmarshal(record.value, &note)
+	if err == nil {
+		note.Content, err = db.sealer().open(note.Content)
+		if contentLocked(err) {
+			// (the record is fine: it mustn't be skipped or quarantined as corrupt)
+			return note, err
+		}
+	}
+	if err != nil {
+		return note, &CorruptRecord{Notebook: notebookName, Key: string(record.key), Size: len(record.value), Err: err}
+	}
+	return note, nil
+}
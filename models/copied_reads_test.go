@@ -0,0 +1,177 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ * Content of version v of note id, padded to a few hundred bytes
+ */
+func versionedContent(id uint64, v int) string {
+	return fmt.Sprintf("note %d, version %d: %s", id, v, strings.Repeat("padding ", 40))
+}
+
+/**
+ * Tells whether content is one of the versions of note id (see versionedContent)
+ */
+func isVersionOf(content string, id uint64) bool {
+	var readId uint64
+	var v int
+	_, err := fmt.Sscanf(content, "note %d, version %d:", &readId, &v)
+	return err == nil && readId == id && content == versionedContent(id, v)
+}
+
+// when exports were made, which differs between exports of the same notes
+var exportedAt = regexp.MustCompile(`"exported_at":"[^"]*"`)
+
+// decode buffers read with: the default, one filled partway through the notebook, and none
+var testDecodeBuffers = []int64{0, 4096, -1}
+
+func TestCopiedReadsOutliveTransaction(t *testing.T) {
+	db := newTestDB(t)
+	const notes = 300
+	for i := uint64(1); i <= notes; i++ {
+		mustAddNote(t, db, "work", Note{Content: versionedContent(i, 0)})
+	}
+
+	// listings and exports are the same whether notes are decoded after their transaction or within it
+	var listings [][]Note
+	var contents [][]string
+	var exports []string
+	for _, size := range testDecodeBuffers {
+		db.SetDecodeBuffer(size)
+		listed, err := db.ListNotes("work")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var export bytes.Buffer
+		if err := db.ExportNotebook("work", &export); err != nil {
+			t.Fatal(err)
+		}
+		copied := make([]string, len(listed))
+		for i, note := range listed {
+			copied[i] = string([]byte(note.Content))
+		}
+		listings, contents, exports = append(listings, listed), append(contents, copied), append(exports, exportedAt.ReplaceAllString(export.String(), ""))
+	}
+	for i := range testDecodeBuffers {
+		if len(listings[i]) != notes || exports[i] != exports[0] {
+			t.Fatalf("decode buffer %d: %d notes listed, export of %d bytes (want %d bytes)",
+				testDecodeBuffers[i], len(listings[i]), len(exports[i]), len(exports[0]))
+		}
+		for j, note := range listings[i] {
+			if note.Content != contents[0][j] {
+				t.Fatalf("decode buffer %d: note %d listed as %q", testDecodeBuffers[i], note.Id, note.Content)
+			}
+		}
+	}
+
+	// rewrite every note while listing concurrently (pages freed by the writes being reused), copying part
+	// of each notebook or decoding all of it in transactions, then grow the file until it's mapped anew
+	db.SetDecodeBuffer(4096)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 2)
+	for _, opts := range [][]ListOption{nil, {DecodeInTx()}} {
+		wg.Add(1)
+		go func(opts []ListOption) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				listed, err := db.ListNotes("work", opts...)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for _, note := range listed {
+					if !isVersionOf(note.Content, note.Id) {
+						errs <- fmt.Errorf("note %d listed as %.40q", note.Id, note.Content)
+						return
+					}
+				}
+			}
+		}(opts)
+	}
+	for v := 1; v <= 5; v++ {
+		for id := uint64(1); id <= notes; id++ {
+			if _, err := db.UpdateNote("work", id, versionedContent(id, v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if err := db.AddNotes("filler", smallNoteContents(20000)...); err != nil {
+		t.Fatal(err)
+	}
+
+	// notes listed earlier are left as they were read
+	for i := range testDecodeBuffers {
+		for j, note := range listings[i] {
+			if note.Content != contents[i][j] || !isVersionOf(note.Content, note.Id) {
+				t.Fatalf("decode buffer %d: note %d listed earlier changed to %.40q", testDecodeBuffers[i], note.Id, note.Content)
+			}
+		}
+	}
+}
+
+// notes of the notebook whose listing is benchmarked, and their size (as in the bench harness)
+const benchmarkListingNotes, benchmarkListingNoteSize = 50000, 512
+
+func BenchmarkListNotesTxHeld50k(b *testing.B) {
+	db, cleanup := openBenchmarkDB(b)
+	b.Cleanup(cleanup)
+	b.StopTimer()
+	contents := make(chan string, 1000)
+	go func() {
+		for i := 0; i < benchmarkListingNotes; i++ {
+			prefix := fmt.Sprintf("note %d: ", i)
+			contents <- prefix + strings.Repeat("x", benchmarkListingNoteSize-len(prefix))
+		}
+		close(contents)
+	}()
+	if _, err := db.BulkLoad("big", contents, 0); err != nil {
+		b.Fatal(err)
+	}
+	b.StartTimer()
+
+	for _, mode := range []struct {
+		name string
+		opts []ListOption
+	}{
+		{"copied", nil},
+		{"in transaction", []ListOption{DecodeInTx()}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			// every listing's transaction goes into the slow-op log, which tells how long it was held
+			db.SetSlowOpThreshold(time.Nanosecond)
+			defer db.SetSlowOpThreshold(0)
+			var held time.Duration
+			for i := 0; i < b.N; i++ {
+				notes, err := db.ListNotes("big", mode.opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(notes) != benchmarkListingNotes {
+					b.Fatalf("%d notes listed, want %d", len(notes), benchmarkListingNotes)
+				}
+				held += db.SlowOps(1)[0].Duration
+			}
+			b.ReportMetric(float64(held.Nanoseconds())/float64(b.N), "tx-held-ns/op")
+		})
+	}
+}
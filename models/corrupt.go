@@ -0,0 +1,172 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Note records that can't be read (damaged by a crash, or by editing the bolt file by hand)
+ *  - reading one fails with a *CorruptRecord (matching ErrCorruptNote), unless SkipCorrupt is
+ *    the read policy, in which case operations going through many notes (listing, querying,
+ *    searching and exporting) leave it out, report it, and carry on
+ *  - QuarantineCorrupt (or Repair) moves unparsable records out of the way, into
+ *    'Quarantine' bucket: Quarantine -> notebook -> note key -> record as it was
+ */
+
+/**
+ * Matched (see errors.Is) by errors reading notes whose records are corrupt
+ */
+var ErrCorruptNote = errors.New("corrupt note")
+
+/**
+ * How operations going through many notes treat corrupt records
+ */
+type ReadPolicy int
+
+const (
+	// fail on the first corrupt record
+	Strict ReadPolicy = iota
+	// leave corrupt records out, reporting them to the callback of SetReadPolicy
+	SkipCorrupt
+)
+
+/**
+ * A note record that can't be read
+ *  - Size is the length of the record as stored
+ *  - Err is what reading it failed with (a JSON syntax error, or ErrMissingChunks)
+ */
+type CorruptRecord struct {
+	Notebook string `json:"notebook"`
+	Key      string `json:"key"`
+	Size     int    `json:"size"`
+	Err      error  `json:"-"`
+}
+
+func (r *CorruptRecord) Error() string {
+	return fmt.Sprintf("%v: '%s' in notebook '%s' (%d bytes): %v", ErrCorruptNote, r.Key, r.Notebook, r.Size, r.Err)
+}
+
+func (r *CorruptRecord) Is(target error) bool {
+	return target == ErrCorruptNote
+}
+
+func (r *CorruptRecord) Unwrap() error {
+	return r.Err
+}
+
+/**
+ * Sets how operations going through many notes treat corrupt records (Strict by default)
+ * With SkipCorrupt, onCorrupt (if not nil) is called with every record left out; it is
+ * called within the reading transaction, so it must not use the DB
+ */
+func (db *DB) SetReadPolicy(policy ReadPolicy, onCorrupt func(CorruptRecord)) {
+	db.readPolicy = policy
+	db.onCorrupt = onCorrupt
+}
+
+/**
+ * Whether a note that failed to be read should be left out (reporting it), as per the read policy
+ */
+func (db *DB) skipCorrupt(err error) bool {
+	var record *CorruptRecord
+	if db.readPolicy != SkipCorrupt || !errors.As(err, &record) {
+		return false
+	}
+	if db.onCorrupt != nil {
+		db.onCorrupt(*record)
+	}
+	return true
+}
+
+/**
+ * Moves note records that can't be parsed into 'Quarantine' bucket, so that they no longer
+ * get in the way of reading notebooks (notes with content chunks missing stay, see CheckIntegrity)
+ * return: ([]CorruptRecord, error) The records moved
+ */
+func (db *DB) QuarantineCorrupt() ([]CorruptRecord, error) {
+	var records []CorruptRecord
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		records, err = quarantineCorruptInTx(tx)
+		return err
+	})
+	return records, err
+}
+
+func quarantineCorruptInTx(tx *bolt.Tx) ([]CorruptRecord, error) {
+	var records []CorruptRecord
+	type quarantined struct {
+		notebookKey, key, record []byte
+	}
+	var moves []quarantined
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	err := rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			if problem := parseNoteRecord(v); problem != nil {
+				records = append(records, CorruptRecord{
+					Notebook: notebookDisplayName(tx, notebookKey), Key: string(k), Size: len(v), Err: problem,
+				})
+				moves = append(moves, quarantined{notebookKey, k, append([]byte(nil), v...)})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// moved after scanning, as bolt doesn't allow modifying buckets being iterated
+	for _, move := range moves {
+		if err := quarantineRecord(tx, move.notebookKey, move.key, move.record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+/**
+ * Moves a note record into 'Quarantine' bucket
+ * Chunks and attachments of the note are left in place (for recovery); CheckIntegrity reports them
+ */
+func quarantineRecord(tx *bolt.Tx, notebookKey, key, record []byte) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("Quarantine"))
+	if err != nil {
+		return err
+	}
+	notebookQuarantineBucket, err := bucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return err
+	}
+	if err := notebookQuarantineBucket.Put(key, record); err != nil {
+		return err
+	}
+	// (quarantined notes aren't suggested from)
+	noteId, _ := strconv.ParseUint(string(key), 10, 64)
+	if err := putSuggestions(tx, notebookKey, Note{Id: noteId}); err != nil {
+		return err
+	}
+	if err := putFingerprint(tx, notebookKey, noteId, ""); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("Notebook")).Bucket(notebookKey).Delete(key)
+}
+
+/**
+ * What's wrong with a note record as stored (nil if it parses)
+ */
+func parseNoteRecord(record []byte) error {
+	var note Note
+	return json.Unmarshal(record, &note)
+}
@@ -1,9 +1,17 @@
 package models
 
 import (
+	"context"
+	"crypto/cipher"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 /**
@@ -17,14 +25,195 @@ type Datastore interface {
 	GetNotebook(notebookName string) (Notebook, error)
 	AddNotebook(notebook Notebook) error
 	GetAllNotebooks() ([]Notebook, error)
-	GetAllNotebookNames() ([]string, error)
+	GetAllNotebookNames(opts ...NotebookOption) ([]string, error)
+	GetNotebookInfo(notebookName string) (NotebookInfo, error)
+	SetNotebookDefaults(notebookName string, defaults NotebookDefaults) error
+	ArchiveNotebook(notebookName string) error
+	UnarchiveNotebook(notebookName string) error
+	SetUniqueTitles(notebookName string, unique bool, autoSuffix bool) error
+	GetDBStats() (DBStats, error)
 	// note-related operations
 	NoteExists(notebookName string, noteId uint64) (bool, error)
 	GetNote(notebookName string, noteId uint64) (Note, error)
+	SearchNotes(notebookName string, query string, opts ...SearchOption) ([]SearchResult, error)
+	SearchEach(notebookName string, query string, fn func(SearchResult) error, opts ...SearchOption) error
+	SearchStream(notebookName string, query string, fn func(SearchResult) (more bool, err error), opts ...SearchOption) error
+	SearchAllNotebooks(query string, opts ...SearchOption) ([]SearchResult, error)
+	SearchAllNotebooksContext(ctx context.Context, query string, opts ...SearchOption) ([]SearchResult, error)
 	AddNotes(notebookName string, noteContents ...string) error
+	AddNote(notebookName string, note Note) (Note, error)
+	ReserveNoteIDs(notebookName string, n int) ([]uint64, error)
+	PutNote(notebookName string, note Note) (Note, error)
+	ListNotes(notebookName string, opts ...ListOption) ([]Note, error)
+	ListNoteSummaries(notebookName string, previewLength int, opts ...ListOption) ([]NoteSummary, error)
+	Query(notebookName string) *Query
 	DeleteNotes(notebookName string, noteIds ...uint64) error
+	DeleteNotesWithOptions(notebookName string, noteIds []uint64, opts ...WriteOption) ([]uint64, error)
+	PlanDelete(notebookName string, noteIds []uint64, opts ...WriteOption) (DeletePlan, error)
+	ExecuteDelete(token string) ([]uint64, error)
+	UpdateNote(notebookName string, noteId uint64, content string, opts ...WriteOption) (Note, error)
+	LockNoteReadOnly(notebookName string, noteId uint64) error
+	UnlockNoteReadOnly(notebookName string, noteId uint64) error
+	SetNoteKind(notebookName string, noteId uint64, kind string) error
+	RenameNote(notebookName string, noteId uint64, title string) (Note, error)
+	MoveNote(notebookName string, noteId uint64, targetName string) (Note, error)
+	SplitNote(notebookName string, noteId uint64, opts SplitOptions) ([]Note, error)
+	MoveNoteBefore(notebookName string, noteId uint64, beforeId uint64) error
+	MoveNoteToEnd(notebookName string, noteId uint64) error
+	UpdateNoteIfRevision(notebookName string, noteId uint64, expectedRev uint64, content string, opts ...WriteOption) (Note, error)
+	StaleNotes(notebookName string, olderThan time.Duration, limit int) ([]NoteRef, error)
+	ListNotesWithURL(urlSubstring string) ([]NoteRef, error)
+	ActivityHistogram(notebookName string, from, to time.Time, bucket time.Duration) ([]ActivityBucket, error)
+	ActivityHistogramAll(from, to time.Time, bucket time.Duration) ([]ActivityBucket, error)
+	DetectLanguages(notebookName string) (map[string]int, error)
+	RedetectLanguages(notebookName string) (int, error)
+	CheckLinks(ctx context.Context, notebookName string, concurrency int) ([]LinkStatus, error)
+	ExportNote(notebookName string, noteId uint64, w io.Writer, opts NoteExportOptions) error
+	ImportNote(notebookName string, r io.Reader, opts ImportOptions) (Note, error)
+	ExportNotebook(notebookName string, w io.Writer, opts ...ListOption) error
+	ExportNotebookEncrypted(notebookName string, w io.Writer, passphrase string, opts ...ListOption) error
+	ImportNotebook(notebookName string, r io.Reader, opts ImportOptions) (ImportReport, error)
+	ImportENEX(notebookName string, r io.Reader, opts ENEXOptions) (ImportReport, error)
+	ImportKeepTakeout(notebookName, dir string, opts ImportOptions) (ImportReport, error)
+	CaptureMessage(notebookName string, r io.Reader, opts CaptureOptions) (Note, error)
+	ClipURL(ctx context.Context, notebookName string, rawURL string, client *http.Client) (Note, error)
+	MirrorToDir(dir string, opts MirrorOptions) (MirrorReport, error)
+	MirrorFromDir(dir string, opts MirrorOptions) (MirrorReport, error)
+	SyncFrom(path string, opts SyncOptions) (SyncReport, error)
+	SyncStatus() ([]PeerState, error)
+	DeviceID() string
+	// filing-rule operations
+	AddFilingRule(rule FilingRule) error
+	ListFilingRules() ([]FilingRule, error)
+	RemoveFilingRule(ruleId uint64) error
+	ApplyFilingRules(notebookName string, dryRun bool) (FilingReport, error)
+	SetAutoFiling(notebookName string) error
+	// tag-related operations
+	TagMatching(q *Query, addTags []string, removeTags []string, dryRun bool) (BulkTagReport, error)
+	PlanBatch(plan BatchPlan) (BatchPreview, error)
+	ExecuteBatch(plan BatchPlan) (BatchResult, error)
+	// suggestion operations
+	SuggestTags(notebookName, prefix string, limit int) ([]string, error)
+	SuggestTitles(notebookName, prefix string, limit int) ([]string, error)
+	// notebook-snapshot operations
+	SnapshotNotebook(notebookName string, label string) (SnapshotID, error)
+	ListSnapshots(notebookName string) ([]NotebookSnapshot, error)
+	RestoreSnapshot(notebookName string, id SnapshotID, mode RestoreMode) error
+	DeleteSnapshot(notebookName string, id SnapshotID) error
+	// multi-notebook operations
+	MultiNotebookTx(names []string, fn func(nbs map[string]*NotebookTx) error, opts ...NotebookTxOption) error
+	Takeout(dir string, opts TakeoutOptions) (TakeoutReport, error)
+	TakeoutContext(ctx context.Context, dir string, opts TakeoutOptions) (TakeoutReport, error)
+	RestoreTakeout(dir string, opts ImportOptions) (RestoreReport, error)
+	// notebook-template operations
+	SaveNotebookTemplate(name string, t NotebookTemplate) error
+	GetNotebookTemplate(name string) (NotebookTemplate, error)
+	ListNotebookTemplates() ([]NotebookTemplate, error)
+	DeleteNotebookTemplate(name string) error
+	CreateNotebookFromTemplate(notebookName, templateName string, vars map[string]string) ([]Note, error)
+	ExportNotebookTemplate(name string, w io.Writer) error
+	ImportNotebookTemplate(r io.Reader, name string) (NotebookTemplate, error)
+	// content-class operations
+	GetNoteStats(notebookName string, noteId uint64) (NoteStats, error)
+	FindBinaryNotes(notebookName string) ([]NoteStats, error)
+	// fingerprint operations
+	FindByFingerprint(notebookName string, fingerprint string) (NoteRef, bool, error)
+	BackfillFingerprints() (int, error)
+	// favorite operations
+	AddFavorite(ref NoteRef) error
+	RemoveFavorite(ref NoteRef) error
+	MoveFavorite(ref NoteRef, before NoteRef) error
+	ListFavorites() ([]SearchResult, error)
+	PruneFavorites() ([]NoteRef, error)
+	// relation operations
+	AddRelation(from NoteRef, to NoteRef, kind string) error
+	RemoveRelation(from NoteRef, to NoteRef, kind string) error
+	GetRelations(ref NoteRef, kind string, direction Direction) ([]Relation, error)
+	// rollup operations
+	GenerateDailyRollup(targetNotebook string, day time.Time, sources []string) (Note, error)
+	GetDailyRollup(targetNotebook string, day time.Time) (DailyRollup, error)
+	// short-id operations
+	ResolveShortID(shortID string) (NoteRef, error)
+	NoteShortID(ref NoteRef) (string, error)
+	// attachment-related operations
+	AddAttachment(notebookName string, noteId uint64, name string, r io.Reader) (Attachment, error)
+	ListAttachments(notebookName string, noteId uint64) ([]Attachment, error)
+	GetAttachment(notebookName string, noteId uint64, name string) (io.ReadSeekCloser, Attachment, error)
+	DeleteAttachment(notebookName string, noteId uint64, name string) error
+	// expiry-related operations
+	SetExpiry(notebookName string, noteId uint64, expiresAt *time.Time) error
+	PurgeExpired() (int, error)
+	// retention-related operations
+	SetRetentionPolicy(policy RetentionPolicy) error
+	GetRetentionPolicy() (RetentionPolicy, error)
+	ApplyRetention() (RetentionReport, error)
+	// notebook policy-related operations
+	SetNotebookPolicy(notebookName string, policy NotebookPolicy) error
+	SchedulePolicies(scheduled bool) error
+	PoliciesScheduled() (bool, error)
+	ApplyPolicies(dryRun bool) (PolicyReport, error)
+	// task-related operations
+	ToggleTask(notebookName string, noteId uint64, line int) (Note, error)
+	ListOpenTasks(notebookName string) ([]TaskRef, error)
+	// history-related operations
+	GetNoteHistory(notebookName string, noteId uint64) ([]NoteRevision, error)
+	RestoreRevision(notebookName string, noteId uint64, revision uint64) (Note, error)
+	DiffRevisions(notebookName string, noteId uint64, revA, revB uint64) ([]DiffHunk, error)
+	DiffAgainstCurrent(notebookName string, noteId uint64, rev uint64) ([]DiffHunk, error)
+	SetHistorySnapshotInterval(interval int) error
+	GetHistorySnapshotInterval() int
+	// undo operations
+	LastOperations(n int) ([]UndoEntry, error)
+	Undo(opId uint64) error
+	// db-settings operations
+	SetCaseInsensitiveNotebooks(enabled bool) ([]NotebookNameCollision, error)
+	SetContentNormalization(opts NormalizeOptions) error
+	NormalizeExisting(notebookName string) (int, error)
+	SetTitleInference(opts TitleInference) error
+	InferMissingTitles(notebookName string) (int, error)
+	EnableOutbox(enabled bool) error
+	GetEncryptionMode() EncryptionMode
+	MigrateEncryption(mode EncryptionMode) (int, error)
+	// notebook-encryption operations
+	EncryptNotebook(notebookName string, passphrase string) error
+	UnlockNotebook(notebookName string, passphrase string) error
+	LockNotebook(notebookName string) error
+	ChangeNotebookPassphrase(notebookName string, oldPassphrase string, newPassphrase string) error
+	// outbox operations
+	OutboxDepth() (int, error)
+	ProcessOutbox(handler func(ChangeEvent) error, batch int) (int, error)
+	ChangesSince(seq uint64) ([]ChangeEvent, error)
+	// API-token operations
+	CreateAPIToken(name string, scopes []Scope) (string, error)
+	RevokeAPIToken(name string) error
+	ListAPITokens() ([]APIToken, error)
+	AuthenticateAPIToken(token string) (APIToken, error)
+	// note-share operations
+	CreateNoteShareLink(notebookName string, noteId uint64, expiry time.Duration, maxViews int) (string, error)
+	ListNoteShares() ([]NoteShare, error)
+	RevokeNoteShare(shareId uint64) error
+	ViewSharedNote(token string) (Note, error)
 	// db-backup operation
 	Dump()
+	// slow-op operations
+	SlowOps(limit int) []SlowOp
+	PersistedSlowOps(limit int) ([]SlowOp, error)
+	TopNotebooksByOps(window time.Duration) ([]NotebookOpCount, error)
+	// index-maintenance operation
+	MaintenanceStats() MaintenanceStats
+	// recurring-note operations
+	AddRecurringNote(rule RecurringRule) error
+	ListRecurringNotes() ([]RecurringRule, error)
+	DeleteRecurringNote(name string) error
+	RunRecurring(now time.Time) (RecurringReport, error)
+	ScheduleRecurring(scheduled bool) error
+	RecurringScheduled() (bool, error)
+	// capability operation
+	Capabilities() Capabilities
+	// db-integrity operation
+	CheckIntegrity() ([]IntegrityProblem, error)
+	Repair() ([]IntegrityProblem, error)
+	QuarantineCorrupt() ([]CorruptRecord, error)
 }
 
 /**
@@ -40,30 +229,218 @@ type Datastore interface {
  */
 type DB struct {
 	*bolt.DB
+	// whether notebook names are looked up case-insensitively (persisted in 'Meta' bucket)
+	caseInsensitiveNames bool
+	// number of undo entries retained (see SetUndoLimit)
+	undoLimit int
+	// outstanding snapshots, released on Close
+	snapshotsMu sync.Mutex
+	snapshots   map[*Snapshot]struct{}
+	// lifecycle: in-flight operations and background goroutines, drained / stopped on Close
+	lifecycleMu  sync.Mutex
+	closed       bool
+	inFlight     sync.WaitGroup
+	stoppers     []func()
+	closeTimeout time.Duration
+	// whether a relaxed durability section is running (see WithRelaxedDurability)
+	relaxedMu sync.Mutex
+	relaxed   bool
+	// buffered note access times (see access.go)
+	access accessTracker
+	// sink of messages of background work (see SetLogger)
+	loggerMu sync.Mutex
+	logger   Logger
+	// content size above which notes are stored in chunks (see chunks.go)
+	chunkThreshold int
+	// revisions between revisions of history kept in full (persisted in 'Meta' bucket, see revisions.go)
+	historySnapshotInterval int
+	// size of notebooks above which they aren't snapshotted (see SetSnapshotLimit)
+	snapshotLimit int64
+	// number of notes deleted at once above which deletes have to be planned (see SetMassDeleteThreshold)
+	massDeleteThreshold int
+	// deletes planned by PlanDelete, by token
+	deletePlansMu sync.Mutex
+	deletePlans   map[string]pendingDelete
+	// transactions slower than a threshold (see SetSlowOpThreshold)
+	slowOps slowOpLog
+	// network access of CheckLinks (see SetLinkChecking)
+	httpClient *http.Client
+	hostDelay  time.Duration
+	// detector of languages of notes saved (see SetLanguageDetector)
+	languageDetector LanguageDetector
+	// treatment of corrupt note records (see SetReadPolicy)
+	readPolicy ReadPolicy
+	onCorrupt  func(CorruptRecord)
+	// normalization of content written (persisted in 'Meta' bucket, see SetContentNormalization)
+	normalization NormalizeOptions
+	// time budget of write transactions (see write_timeout.go), and start of the running one
+	// (only touched within write transactions, which bolt runs one at a time)
+	writeTimeout time.Duration
+	writeStarted time.Time
+	// called within write transactions for every note about to be saved (see SetBeforeSave)
+	beforeSave func(notebookName string, note Note) error
+	// whether changes are recorded into the outbox (persisted in 'Meta' bucket, see outbox.go),
+	// and the lock runs of ProcessOutbox take turns on
+	outbox   bool
+	outboxMu sync.Mutex
+	// notebook whose notes are filed as they're added (persisted in 'Meta' bucket, see filing.go)
+	autoFiling string
+	// inference of titles of notes written (persisted in 'Meta' bucket, see titles.go)
+	titles TitleInference
+	// notes read by GetNote (see cache.go)
+	cache noteCache
+	// size of records listings copy out of their read transaction (see SetDecodeBuffer)
+	decodeBuffer int64
+	// number of workers reads spanning all notebooks fan out over (see SetReadWorkers)
+	readWorkers int
+	// size attachments are capped at (see SetAttachmentLimit), and the lock commits of uploads take
+	// turns on (see uploads.go)
+	attachmentLimit int64
+	uploadsMu       sync.Mutex
+	// backup scheduler started last (see SchedulerStatus)
+	schedulerMu sync.Mutex
+	scheduler   *backupScheduler
+	// encryption of content written (persisted in 'Meta' bucket, see encryption.go), the key content
+	// is encrypted with (nil until UnlockEncryption or InitEncryption) and whether encrypted content can be searched
+	encryptionMode  EncryptionMode
+	contentKey      cipher.AEAD
+	encryptedSearch bool
+	// keys of encrypted notebooks unlocked (see notebook_encryption.go)
+	notebookKeys notebookKeyring
+	// bulk imports and migrations running (see markBusy), and what the index maintainer did
+	// (see index_maintenance.go)
+	busy        int32
+	maintenance indexMaintenance
+	// id of the device notes' clocks count writes of the DB by (persisted in 'Meta' bucket, see clock.go)
+	deviceId string
 }
 
+/**
+ * Size of the initial memory map of the DB file
+ * bolt has to re-map the file when it grows beyond the mapped size, which waits for all
+ * open read transactions to finish; mapping generously up front keeps writes from
+ * blocking behind long-lived read transactions (like those held by a Snapshot)
+ */
+const initialMmapSize = 64 << 20
+
 /**
  * <Constructor for above DB struct>
  * Returns an instance of DB struct by either creating a new BoltDb
  * bucket for given `dbFileName` or using an existing one
+ * If another process holds the DB, waits up to DefaultOpenTimeout for it to become free
+ * and fails with a *DatabaseLockedError (see lockinfo.go) if it doesn't
  * @param dbFileName string The complete (path) qualified filename of for BoltDb file
  * @return (*DB, error) Tuple containing pointer to DB struct and optionally an error
  */
 func GetOrCreateDB(dbFileName string) (*DB, error) {
-	db, err := bolt.Open(dbFileName, 0600, nil)
+	return openDB(dbFileName, DefaultOpenTimeout)
+}
+
+/**
+ * <Constructor for DBs only read from>
+ * Opens an existing BoltDb file read-only: it isn't created if missing (failing like os.Stat does), its schema
+ * isn't upgraded, and other processes can read it meanwhile; writes fail with bolt.ErrDatabaseReadOnly
+ * A file last written by an older version has to be opened for writing once (see GetOrCreateDB) for all
+ * reads to work
+ * If another process holds the file for writing, waits up to DefaultOpenTimeout for it to become free
+ * and fails with a *DatabaseLockedError if it doesn't
+ * param: string dbFileName
+ * return: (*DB, error)
+ */
+func OpenReadOnlyDB(dbFileName string) (*DB, error) {
+	return openReadOnlyDB(dbFileName, DefaultOpenTimeout)
+}
+
+/**
+ * <Constructor for throwaway DBs (tests, ephemeral use)>
+ * Creates the database in a fresh temporary directory
+ * Returned cleanup func closes the DB and removes the directory; it is safe
+ * to call it more than once, and also after db.Close() has been called
+ * return: (*DB, func(), error)
+ */
+func OpenTemp() (*DB, func(), error) {
+	dir, err := os.MkdirTemp("", "notes-")
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := GetOrCreateDB(filepath.Join(dir, "notes.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			db.Close()
+			os.RemoveAll(dir)
+		})
+	}
+	return db, cleanup, nil
+}
+
+/**
+ * <Constructor for truly throwaway DBs>
+ * Creates the database in a temporary file which is unlinked right after opening,
+ * so nothing is left behind on disk once the DB is closed (or the process dies)
+ * return: (*DB, error)
+ */
+func OpenMemory() (*DB, error) {
+	db, cleanup, err := OpenTemp()
 	if err != nil {
 		return nil, err
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
+	// bolt keeps using the open file descriptor (and mmap) after the file is unlinked
+	dir := filepath.Dir(db.Path())
+	if err := os.RemoveAll(dir); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return db, nil
+}
+
+/**
+ * Creates the buckets every DB is expected to have
+ */
+func initSchema(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		fresh := tx.Bucket([]byte("Notebook")) == nil
 		_, err := tx.CreateBucketIfNotExists([]byte("Notebook"))
 		if err != nil {
 			return fmt.Errorf("could not create root bucket: %v", err)
 		}
-		return nil
+		if fresh {
+			// new DBs have no note to fingerprint (see capabilities.go)
+			if _, err := tx.CreateBucketIfNotExists([]byte("Fingerprints")); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte("URLs")) == nil {
+			// DB predates the URL index (see urls.go): build it
+			if err := buildURLIndex(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte("TagIndex")) == nil {
+			// DB predates the tag and title index (see suggest.go): build it
+			if err := buildSuggestionIndex(tx); err != nil {
+				return err
+			}
+		}
+		// histories may predate revisions stored as diffs (see revisions.go)
+		if err := convertStoredHistories(tx, contentSealer{}); err != nil {
+			return err
+		}
+		// DB may predate device ids (see clock.go)
+		if err := ensureDeviceId(tx); err != nil {
+			return err
+		}
+		// uploads left were cut short by the process writing them, as a DB is opened by one process at a
+		// time (see uploads.go)
+		if err := discardUploads(tx); err != nil {
+			return err
+		}
+		// notebooks may predate short ids (see short_ids.go)
+		return assignMissingAbbreviations(tx)
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &DB{db}, nil
 }
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ * Creates a throwaway DB (see OpenTemp), closed and removed once the test is over
+ * (tests outside the package use notestest.NewDB, which models can't import)
+ */
+func newTestDB(t testing.TB) *DB {
+	t.Helper()
+	db, cleanup, err := OpenTemp()
+	if err != nil {
+		t.Fatalf("opening temp DB: %v", err)
+	}
+	t.Cleanup(cleanup)
+	return db
+}
+
+/**
+ * Adds a note to a notebook, failing the test if it can't
+ */
+func mustAddNote(t testing.TB, db *DB, notebookName string, note Note) Note {
+	t.Helper()
+	added, err := db.AddNote(notebookName, note)
+	if err != nil {
+		t.Fatalf("adding note to '%s': %v", notebookName, err)
+	}
+	return added
+}
+
+func TestOpenTemp(t *testing.T) {
+	db, cleanup, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(db.Path())
+	if _, err := os.Stat(db.Path()); err != nil {
+		t.Fatalf("database file missing: %v", err)
+	}
+	note, err := db.AddNote("work", Note{Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetNote("work", note.Id); err != nil || got.Content != "hello" {
+		t.Fatalf("GetNote = %+v, %v", got, err)
+	}
+
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temp directory left behind: %v", err)
+	}
+	// cleaning up twice is harmless
+	cleanup()
+}
+
+func TestOpenTempCleanupAfterClose(t *testing.T) {
+	db, cleanup, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(db.Path())
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temp directory left behind: %v", err)
+	}
+}
+
+func TestOpenMemory(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := os.Stat(db.Path()); !os.IsNotExist(err) {
+		t.Errorf("database file still on disk: %v", err)
+	}
+	// the unlinked file stays usable
+	note, err := db.AddNote("work", Note{Content: "ephemeral"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetNote("work", note.Id); err != nil || got.Content != "ephemeral" {
+		t.Errorf("GetNote = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Mass deletes have to be confirmed: deleting more notes at once than the mass-delete threshold
+ * fails with ErrConfirmationRequired, and takes a plan instead
+ *  - PlanDelete works out what a delete would do without changing anything, handing out a token
+ *  - ExecuteDelete carries out the plan of a token; tokens are used once, expire after deletePlanTTL,
+ *    and are voided by any write to the notebook in between (its notes have to be as they were planned)
+ *  - plans are kept in memory: they don't outlive the DB handle they were made with
+ * This tree has no way of deleting whole notebooks; deleting all notes of one is a mass delete like any other
+ */
+
+/**
+ * Number of notes deleted at once above which deletes have to be planned, unless set otherwise
+ */
+const DefaultMassDeleteThreshold = 50
+
+// time a delete plan can be executed in
+const deletePlanTTL = time.Minute
+
+var (
+	// returned by deletes of more notes than the mass-delete threshold: they have to be planned (see PlanDelete)
+	ErrConfirmationRequired = errors.New("deleting that many notes needs to be confirmed")
+	// returned by ExecuteDelete for tokens that are unknown, already used or expired
+	ErrDeletePlanNotFound = errors.New("delete plan not found (or expired)")
+	// returned by ExecuteDelete when the notebook was written to since the delete was planned
+	ErrDeletePlanStale = errors.New("notebook changed since the delete was planned")
+)
+
+/**
+ * What deleting notes of a notebook would do, as worked out by PlanDelete
+ *  - NoteIds are the notes that would be deleted (Locked ones among them only with Force());
+ *    Missing are ids given with no note
+ *  - Remaining is the number of notes the notebook would be left with (0 when all of them go)
+ */
+type DeletePlan struct {
+	Notebook  string    `json:"notebook"`
+	NoteIds   []uint64  `json:"note_ids"`
+	Missing   []uint64  `json:"missing"`
+	Locked    []uint64  `json:"locked"`
+	Remaining int       `json:"remaining"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+/**
+ * A plan waiting to be executed, along with what it was planned against
+ */
+type pendingDelete struct {
+	plan    DeletePlan
+	options writeOptions
+	// fingerprint of the notebook's notes when planned (see notebookFingerprint)
+	fingerprint string
+}
+
+/**
+ * Sets the number of notes deleted at once above which deletes have to be planned
+ * Non-positive threshold falls back to DefaultMassDeleteThreshold
+ */
+func (db *DB) SetMassDeleteThreshold(threshold int) {
+	db.massDeleteThreshold = threshold
+}
+
+func (db *DB) maxUnconfirmedDeletes() int {
+	if db.massDeleteThreshold <= 0 {
+		return DefaultMassDeleteThreshold
+	}
+	return db.massDeleteThreshold
+}
+
+/**
+ * Works out what deleting notes of a notebook (with given options, as for DeleteNotesWithOptions) would do,
+ * handing out a token to carry it out with ExecuteDelete; nothing is changed
+ * Fails with ErrNotebookNotFound if the notebook doesn't exist, and with ErrNotebookArchived if it's archived
+ * param: string         notebookName
+ * param: []uint64       noteIds
+ * param: ...WriteOption opts
+ * return: (DeletePlan, error)
+ */
+func (db *DB) PlanDelete(notebookName string, noteIds []uint64, opts ...WriteOption) (DeletePlan, error) {
+	pending := pendingDelete{plan: DeletePlan{Notebook: notebookName}, options: newWriteOptions(opts)}
+	plan := &pending.plan
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		for _, noteId := range noteIds {
+			if containsId(plan.NoteIds, noteId) || containsId(plan.Missing, noteId) {
+				continue
+			}
+			noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+			encodedNote := notebookBucket.Get(noteIdBytes)
+			if encodedNote == nil {
+				plan.Missing = append(plan.Missing, noteId)
+				continue
+			}
+			if note, err := db.decodeNote(tx, notebookKey, noteIdBytes, encodedNote); err == nil && note.ReadOnly {
+				plan.Locked = append(plan.Locked, noteId)
+				if !pending.options.force {
+					continue
+				}
+			}
+			plan.NoteIds = append(plan.NoteIds, noteId)
+		}
+		if len(plan.Locked) > 0 && !pending.options.force && !pending.options.skipLocked {
+			return &ReadOnlyNotesError{Notebook: notebookName, Ids: plan.Locked}
+		}
+		plan.Remaining = notebookBucket.Stats().KeyN - len(plan.NoteIds)
+		pending.fingerprint = notebookFingerprint(notebookBucket)
+		return nil
+	})
+	if err != nil {
+		return *plan, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return *plan, err
+	}
+	plan.Token = hex.EncodeToString(token)
+	plan.ExpiresAt = time.Now().Add(deletePlanTTL)
+	db.deletePlansMu.Lock()
+	defer db.deletePlansMu.Unlock()
+	if db.deletePlans == nil {
+		db.deletePlans = make(map[string]pendingDelete)
+	}
+	for token, other := range db.deletePlans {
+		if time.Now().After(other.plan.ExpiresAt) {
+			delete(db.deletePlans, token)
+		}
+	}
+	db.deletePlans[plan.Token] = pending
+	return *plan, nil
+}
+
+/**
+ * Carries out the delete planned by PlanDelete, whatever its size; the token can't be used again
+ * Fails with ErrDeletePlanNotFound if the token is unknown, used or expired, and with ErrDeletePlanStale
+ * (deleting nothing) if the notebook was written to since; either way, the delete has to be planned afresh
+ * param: string token
+ * return: ([]uint64, error) Ids of the notes left alone (read-only ones, with SkipLocked())
+ */
+func (db *DB) ExecuteDelete(token string) ([]uint64, error) {
+	db.deletePlansMu.Lock()
+	pending, ok := db.deletePlans[token]
+	delete(db.deletePlans, token)
+	db.deletePlansMu.Unlock()
+	if !ok || time.Now().After(pending.plan.ExpiresAt) {
+		return nil, ErrDeletePlanNotFound
+	}
+
+	var skipped []uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(pending.plan.Notebook))
+		if notebookBucket == nil || notebookFingerprint(notebookBucket) != pending.fingerprint {
+			return fmt.Errorf("%w: '%s'", ErrDeletePlanStale, pending.plan.Notebook)
+		}
+		options := pending.options
+		options.confirmed = true
+		var err error
+		skipped, err = db.deleteNotesInTx(tx, pending.plan.Notebook, append(pending.plan.NoteIds, pending.plan.Locked...), options)
+		return err
+	})
+	return skipped, err
+}
+
+/**
+ * Fails with ErrConfirmationRequired if more notes than the mass-delete threshold are about to be deleted
+ * without a plan
+ */
+func (db *DB) checkDeleteConfirmed(notebookName string, deleting int, options writeOptions) error {
+	if options.confirmed || deleting <= db.maxUnconfirmedDeletes() {
+		return nil
+	}
+	return fmt.Errorf("%w: %d notes of notebook '%s' (more than %d, see PlanDelete)",
+		ErrConfirmationRequired, deleting, notebookName, db.maxUnconfirmedDeletes())
+}
+
+/**
+ * Digest of the notes of a notebook (records and id sequence), changing with any write to them
+ */
+func notebookFingerprint(notebookBucket *bolt.Bucket) string {
+	hash := sha256.New()
+	hash.Write([]byte(strconv.FormatUint(notebookBucket.Sequence(), 10)))
+	notebookBucket.ForEach(func(k, v []byte) error {
+		hash.Write([]byte(strconv.Itoa(len(k))))
+		hash.Write(k)
+		hash.Write([]byte(strconv.Itoa(len(v))))
+		hash.Write(v)
+		return nil
+	})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func containsId(ids []uint64, id uint64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
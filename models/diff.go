@@ -0,0 +1,199 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+ * Number of unchanged lines shown around changes in a hunk
+ */
+const diffContextLines = 3
+
+/**
+ * Kind of a line in a diff: unchanged (' '), added ('+') or removed ('-')
+ */
+type DiffOp byte
+
+const (
+	DiffContext DiffOp = ' '
+	DiffAdded   DiffOp = '+'
+	DiffRemoved DiffOp = '-'
+)
+
+/**
+ * A single line of a diff
+ *  - OldLine / NewLine are 1-based line numbers in old / new text (0 when line doesn't exist there)
+ */
+type DiffLine struct {
+	Op      DiffOp `json:"op"`
+	Text    string `json:"text"`
+	OldLine int    `json:"old_line"`
+	NewLine int    `json:"new_line"`
+}
+
+/**
+ * A contiguous group of changes along with surrounding context lines
+ */
+type DiffHunk struct {
+	OldStart int        `json:"old_start"`
+	OldLines int        `json:"old_lines"`
+	NewStart int        `json:"new_start"`
+	NewLines int        `json:"new_lines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+/**
+ * Computes line-based diff of two texts as hunks
+ * Identical texts yield an empty slice
+ */
+func DiffText(oldText, newText string) []DiffHunk {
+	return groupHunks(diffLines(splitLines(oldText), splitLines(newText)), diffContextLines)
+}
+
+/**
+ * Renders hunks as unified-diff text (without the '---' / '+++' file headers)
+ */
+func FormatUnified(hunks []DiffHunk) string {
+	var sb strings.Builder
+	for _, hunk := range hunks {
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+		for _, line := range hunk.Lines {
+			sb.WriteByte(byte(line.Op))
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+/**
+ * Myers' O(ND) diff over lines
+ *  - forward pass records the furthest reaching x on every diagonal k for each edit distance d
+ *  - backtracking over those traces yields the edit script
+ */
+func diffLines(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+forward:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break forward
+			}
+		}
+	}
+
+	// backtrack from (n, m) to (0, 0), collecting lines in reverse
+	var reversed []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Op: DiffContext, Text: a[x-1], OldLine: x, NewLine: y})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, DiffLine{Op: DiffAdded, Text: b[y-1], NewLine: y})
+			} else {
+				reversed = append(reversed, DiffLine{Op: DiffRemoved, Text: a[x-1], OldLine: x})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]DiffLine, len(reversed))
+	for i := range reversed {
+		lines[i] = reversed[len(reversed)-1-i]
+	}
+	return lines
+}
+
+/**
+ * Groups diff lines into hunks, keeping 'context' unchanged lines around every change
+ * and merging hunks whose contexts overlap
+ */
+func groupHunks(lines []DiffLine, context int) []DiffHunk {
+	var hunks []DiffHunk
+	// number of old / new lines consumed before each line
+	oldPos, newPos := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if line.Op != DiffAdded {
+			oldPos[i+1]++
+		}
+		if line.Op != DiffRemoved {
+			newPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].Op == DiffContext {
+			i++
+			continue
+		}
+		// extend hunk while next change is within reach of the context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines) && j <= end+2*context; j++ {
+			if lines[j].Op != DiffContext {
+				end = j
+			}
+		}
+		end += context
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+
+		hunk := DiffHunk{Lines: append([]DiffLine(nil), lines[start:end+1]...)}
+		hunk.OldLines = oldPos[end+1] - oldPos[start]
+		hunk.NewLines = newPos[end+1] - newPos[start]
+		hunk.OldStart, hunk.NewStart = oldPos[start], newPos[start]
+		if hunk.OldLines > 0 {
+			hunk.OldStart++
+		}
+		if hunk.NewLines > 0 {
+			hunk.NewStart++
+		}
+		hunks = append(hunks, hunk)
+		i = end + 1
+	}
+	return hunks
+}
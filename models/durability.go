@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+)
+
+/**
+ * Returned by WithRelaxedDurability when another relaxed section is already running
+ * (relaxed sections can neither be nested nor run concurrently)
+ */
+var ErrRelaxedDurabilityActive = errors.New("a relaxed durability section is already running")
+
+/**
+ * Runs bulk work with bolt's fsync on commit turned off (NoSync / NoGrowSync), for throughput
+ *  - the file is synced once when fn returns, and previous settings are restored, even if fn panics
+ *  - CAUTION: a crash (of process or machine) in the middle of the section can lose everything
+ *    committed within the section so far (the un-synced tail); the DB file itself stays consistent
+ *  - the settings are DB-wide, so writes from other goroutines during the section are relaxed too
+ * param: func() error fn
+ * return: error Error returned by fn, or else by the final sync
+ */
+func (db *DB) WithRelaxedDurability(fn func() error) (err error) {
+	db.relaxedMu.Lock()
+	if db.relaxed {
+		db.relaxedMu.Unlock()
+		return ErrRelaxedDurabilityActive
+	}
+	db.relaxed = true
+	db.relaxedMu.Unlock()
+	defer func() {
+		db.relaxedMu.Lock()
+		db.relaxed = false
+		db.relaxedMu.Unlock()
+	}()
+
+	prevNoSync, prevNoGrowSync := db.NoSync, db.NoGrowSync
+	if err := db.setSyncFlags(true, true); err != nil {
+		return err
+	}
+	defer func() {
+		restoreErr := db.setSyncFlags(prevNoSync, prevNoGrowSync)
+		syncErr := db.Sync()
+		if err == nil {
+			if err = restoreErr; err == nil {
+				err = syncErr
+			}
+		}
+	}()
+
+	return fn()
+}
+
+/**
+ * Sets bolt's NoSync / NoGrowSync flags while holding bolt's writer lock (by means of
+ * an empty write transaction), so that no commit observes them half-way
+ */
+func (db *DB) setSyncFlags(noSync, noGrowSync bool) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.exit()
+
+	tx, err := db.DB.Begin(true)
+	if err != nil {
+		return err
+	}
+	db.NoSync, db.NoGrowSync = noSync, noGrowSync
+	return tx.Rollback()
+}
@@ -0,0 +1,499 @@
+package models
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+)
+
+/**
+ * Content of notes can be encrypted at rest (see MigrateEncryption), leaving the rest of their records
+ * (titles, tags, timestamps, kinds ..) in plaintext, so that notes can still be listed and filtered by them
+ *  - content is encrypted with AES-256-GCM under a key derived (with argon2id, as for encrypted exports)
+ *    from the passphrase of the DB, set once by InitEncryption and given to UnlockEncryption from then on
+ *  - encrypted content is stored in place of content (before chunking, see chunks.go), as sealedContentPrefix
+ *    followed by the base64 of nonce and ciphertext; records tell by themselves whether they're encrypted,
+ *    so that records of either kind are read whatever the mode (as during a migration)
+ *  - history revisions, snapshots (see notebook_snapshots.go) and undo entries written in content mode
+ *    are encrypted too; URLs of encrypted content aren't indexed, and duplicates of encrypted notes aren't
+ *    spotted by content when importing
+ *  - titles inferred from content (see titles.go) are stored in plaintext like any title: turn inference
+ *    off to keep first lines of content private
+ *  - records are never encrypted whole, which would break listing notes by their metadata
+ *  - content written in plaintext before encrypting it may linger in free pages of the DB file
+ *    until bolt reuses them
+ *  - searching content means decrypting every note searched, so searches (and filters on text) fail
+ *    with ErrEncryptedSearch unless enabled with SetEncryptedSearch
+ * Settings: 'Meta' bucket, 'settings' key: encryption mode, salt of the key and a check value
+ */
+
+/**
+ * Which fields of note records are encrypted
+ */
+type EncryptionMode string
+
+const (
+	// nothing is encrypted
+	EncryptionPlaintext EncryptionMode = "plaintext"
+	// content (and history) of notes is encrypted
+	EncryptionContent EncryptionMode = "content"
+)
+
+/**
+ * Marks encrypted content as stored (content written in plaintext can't start with it, see encodeNote)
+ */
+const sealedContentPrefix = "\x00aes-gcm:"
+
+/**
+ * Value encrypted into settings, telling whether a passphrase is the DB's
+ */
+const encryptionCheckValue = "notes"
+
+/**
+ * Number of notes migrated per write transaction by MigrateEncryption
+ */
+const encryptionMigrationBatch = 500
+
+var (
+	// returned when reading or writing encrypted content before UnlockEncryption
+	ErrEncryptionLocked = errors.New("content is encrypted: the DB's passphrase is required")
+	// returned by searches of content while it's encrypted, unless enabled with SetEncryptedSearch
+	ErrEncryptedSearch = errors.New("searching encrypted content isn't enabled")
+	// returned by UnlockEncryption while the DB has no passphrase (see InitEncryption)
+	ErrEncryptionNotInitialized = errors.New("content encryption isn't set up: the DB has no passphrase")
+	// returned for encryption modes that aren't one of the Encryption.. constants
+	ErrUnknownEncryptionMode = errors.New("unknown encryption mode")
+)
+
+/**
+ * Encryption settings persisted in 'Meta' bucket
+ *  - Salt is that of the key derived from the passphrase, and Check holds encryptionCheckValue encrypted
+ *    with it; both are set by InitEncryption
+ */
+type encryptionSettings struct {
+	Mode  EncryptionMode `json:"mode,omitempty"`
+	Salt  []byte         `json:"salt,omitempty"`
+	Check string         `json:"check,omitempty"`
+}
+
+/**
+ * Parses an encryption mode ('plaintext' or 'content')
+ * param: string s
+ * return: (EncryptionMode, error)
+ */
+func ParseEncryptionMode(s string) (EncryptionMode, error) {
+	switch mode := EncryptionMode(strings.ToLower(s)); mode {
+	case EncryptionPlaintext, EncryptionContent:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: '%s' (give '%s' or '%s')", ErrUnknownEncryptionMode, s, EncryptionPlaintext, EncryptionContent)
+}
+
+/**
+ * Derives the key content is encrypted with from given passphrase, so that encrypted content can be
+ * read and content written encrypted
+ * Fails with ErrEncryptionNotInitialized if the DB has no passphrase yet (see InitEncryption), and
+ * ErrWrongPassphrase if the passphrase isn't the DB's
+ * param: string passphrase
+ * return: error
+ */
+func (db *DB) UnlockEncryption(passphrase string) error {
+	return db.unlockEncryption(passphrase, false)
+}
+
+/**
+ * Sets the passphrase of the DB (which content is to be encrypted with from then on) and unlocks it
+ * as by UnlockEncryption; setting the passphrase the DB has already just unlocks it
+ * Fails with ErrWrongPassphrase if the DB has another passphrase
+ * param: string passphrase
+ * return: error
+ */
+func (db *DB) InitEncryption(passphrase string) error {
+	return db.unlockEncryption(passphrase, true)
+}
+
+/**
+ * Core logic of UnlockEncryption and InitEncryption; init tells whether a DB without passphrase gets this one
+ */
+func (db *DB) unlockEncryption(passphrase string, init bool) error {
+	if passphrase == "" {
+		return fmt.Errorf("%w: the passphrase is empty", ErrWrongPassphrase)
+	}
+	var key cipher.AEAD
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		if len(settings.Encryption.Salt) > 0 {
+			key, err = unlockContentKey(passphrase, settings.Encryption)
+			return err
+		}
+		if !init {
+			return ErrEncryptionNotInitialized
+		}
+		salt := make([]byte, encryptedSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		if key, err = contentKey(passphrase, salt); err != nil {
+			return err
+		}
+		check, err := contentSealer{mode: EncryptionContent, aead: key}.seal(encryptionCheckValue)
+		if err != nil {
+			return err
+		}
+		settings.Encryption.Salt, settings.Encryption.Check = salt, check
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return err
+	}
+	db.contentKey = key
+	// histories of encrypted content can only be converted into diffs once unlocked (see revisions.go)
+	return db.Update(func(tx *bolt.Tx) error {
+		return convertStoredHistories(tx, db.sealer())
+	})
+}
+
+/**
+ * Sets whether content can be searched while it's encrypted (which decrypts every note searched)
+ */
+func (db *DB) SetEncryptedSearch(enabled bool) {
+	db.encryptedSearch = enabled
+}
+
+/**
+ * Returns the encryption of content written
+ * return: EncryptionMode
+ */
+func (db *DB) GetEncryptionMode() EncryptionMode {
+	if db.encryptionMode == "" {
+		return EncryptionPlaintext
+	}
+	return db.encryptionMode
+}
+
+/**
+ * Sets the encryption of content written, and converts notes (along with their history) stored otherwise
+ *  - notes are converted a batch at a time: if it fails midway, notes already converted stay so, and
+ *    running it again carries on (records of either kind can be read in the meantime)
+ *  - notes keep their revisions and timestamps; snapshots and undo entries are left as they were written
+ * Fails with ErrEncryptionLocked if content is to be encrypted (or decrypted) before UnlockEncryption
+ * param: EncryptionMode mode
+ * return: (int, error) Number of notes converted
+ */
+func (db *DB) MigrateEncryption(mode EncryptionMode) (int, error) {
+	defer db.markBusy()()
+	if _, err := ParseEncryptionMode(string(mode)); err != nil {
+		return 0, err
+	}
+	if mode == EncryptionContent && db.contentKey == nil {
+		return 0, ErrEncryptionLocked
+	}
+	var notebookKeys [][]byte
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.Encryption.Mode = mode
+		if err := putSettings(tx, settings); err != nil {
+			return err
+		}
+		// (archived notebooks included)
+		return tx.Bucket([]byte("Notebook")).ForEach(func(notebookKey, v []byte) error {
+			if v == nil {
+				notebookKeys = append(notebookKeys, append([]byte(nil), notebookKey...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.encryptionMode = mode
+
+	converted := 0
+	for _, notebookKey := range notebookKeys {
+		var after []byte
+		for done := false; !done; {
+			err := db.Update(func(tx *bolt.Tx) error {
+				n, next, err := db.migrateEncryptionBatch(tx, notebookKey, after, mode == EncryptionContent)
+				converted += n
+				after, done = next, next == nil
+				return err
+			})
+			if err != nil {
+				return converted, err
+			}
+		}
+	}
+	return converted, nil
+}
+
+/**
+ * Converts up to encryptionMigrationBatch notes of a notebook following key after (from the first one if nil)
+ * return: (int, []byte, error) Number of notes converted, and the key to carry on after (nil once done)
+ */
+func (db *DB) migrateEncryptionBatch(tx *bolt.Tx, notebookKey []byte, after []byte, encrypt bool) (int, []byte, error) {
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+	if notebookBucket == nil || getNotebookMeta(tx, notebookKey).Encryption != nil {
+		// (notebooks encrypted on their own are left as they are, see notebook_encryption.go)
+		return 0, nil, nil
+	}
+	cursor := notebookBucket.Cursor()
+	k, v := cursor.First()
+	if after != nil {
+		if k, v = cursor.Seek(after); k != nil && bytes.Equal(k, after) {
+			k, v = cursor.Next()
+		}
+	}
+	// (notes are collected first, as bolt doesn't allow writing while cursoring)
+	var notes []Note
+	var last []byte
+	for visited := 0; k != nil && visited < encryptionMigrationBatch; k, v = cursor.Next() {
+		if v == nil {
+			continue
+		}
+		visited++
+		last = append([]byte(nil), k...)
+		if recordSealed(tx, notebookKey, v) == encrypt {
+			continue
+		}
+		note, err := db.decodeNote(tx, notebookKey, k, v)
+		if db.skipCorrupt(err) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		notes = append(notes, note)
+	}
+	if k == nil {
+		last = nil
+	}
+
+	encoding := db.encoding()
+	for _, note := range notes {
+		prepared, err := encodeNote(note, encoding)
+		if err != nil {
+			return 0, nil, err
+		}
+		db.invalidateNote(tx, notebookKey, note.Id)
+		if err := putEncodedNote(tx, notebookKey, note.Id, prepared); err != nil {
+			return 0, nil, err
+		}
+		if err := migrateHistoryEncryption(tx, notebookKey, note.Id, encoding.sealer); err != nil {
+			return 0, nil, err
+		}
+	}
+	return len(notes), last, nil
+}
+
+/**
+ * Encrypts or decrypts (as per the sealer's mode) past revisions of a note
+ */
+func migrateHistoryEncryption(tx *bolt.Tx, notebookKey []byte, noteId uint64, sealer contentSealer) error {
+	historyBucket := noteHistoryBucket(tx, notebookKey, noteId)
+	if historyBucket == nil {
+		return nil
+	}
+	converted := make(map[string][]byte)
+	err := historyBucket.ForEach(func(k, v []byte) error {
+		// (patches are sealed like content, see revisions.go)
+		var revision storedRevision
+		if err := json.Unmarshal(v, &revision); err != nil {
+			return err
+		}
+		if isSealed(revision.Content) == (sealer.mode == EncryptionContent) {
+			return nil
+		}
+		content, err := sealer.open(revision.Content)
+		if err != nil {
+			return err
+		}
+		if revision.Content, err = sealer.seal(content); err != nil {
+			return err
+		}
+		converted[string(k)], err = json.Marshal(revision)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for k, encoded := range converted {
+		if err := historyBucket.Put([]byte(k), encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Whether content of a note record is stored encrypted (looking at its first chunk if it's chunked)
+ */
+func recordSealed(tx *bolt.Tx, notebookKey []byte, encodedNote []byte) bool {
+	var note Note
+	if err := json.Unmarshal(encodedNote, &note); err != nil {
+		return false
+	}
+	if note.Chunks == nil {
+		return isSealed(note.Content)
+	}
+	chunks := noteChunksBucket(tx, notebookKey, note.Id)
+	return chunks != nil && isSealed(string(chunks.Get(itob(0))))
+}
+
+/**
+ * Fails with ErrEncryptedSearch if content is encrypted and searching it isn't enabled
+ */
+func (db *DB) checkSearchable() error {
+	if db.encryptionMode == EncryptionContent && !db.encryptedSearch {
+		return fmt.Errorf("%w: enable it to decrypt every note searched", ErrEncryptedSearch)
+	}
+	return nil
+}
+
+/**
+ * Encrypts content of notes of a notebook as per its encryption, or else the encryption mode (for notes
+ * stored outside of notebooks, like those of snapshots and undo entries)
+ */
+func (db *DB) sealNotes(tx *bolt.Tx, notebookKey []byte, notes []Note) ([]Note, error) {
+	sealer := db.notebookSealer(tx, notebookKey)
+	sealed := make([]Note, len(notes))
+	for i, note := range notes {
+		var err error
+		if note.Content, err = sealer.seal(note.Content); err != nil {
+			return nil, err
+		}
+		sealed[i] = note
+	}
+	return sealed, nil
+}
+
+/**
+ * Decrypts content of notes sealed by sealNotes (leaving content that isn't encrypted as it is)
+ */
+func (db *DB) openNotes(notes []Note) error {
+	sealer := db.sealer()
+	for i := range notes {
+		var err error
+		if notes[i].Content, err = sealer.open(notes[i].Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Encrypts and decrypts content as per an encryption mode and key
+ */
+type contentSealer struct {
+	mode EncryptionMode
+	// nil until encryption is unlocked
+	aead cipher.AEAD
+	// notebook keys unlocked, and the id of the one content is encrypted with (see notebook_encryption.go)
+	keys          *notebookKeyring
+	notebookKeyId string
+}
+
+func (db *DB) sealer() contentSealer {
+	return contentSealer{mode: db.encryptionMode, aead: db.contentKey, keys: &db.notebookKeys}
+}
+
+/**
+ * Whether content gets encrypted
+ */
+func (s contentSealer) sealing() bool {
+	return s.mode == EncryptionContent || s.notebookKeyId != ""
+}
+
+/**
+ * Encrypts content with the notebook key if any, or else in content mode (returns it as it is otherwise)
+ */
+func (s contentSealer) seal(content string) (string, error) {
+	if s.notebookKeyId != "" {
+		key := s.keys.get(s.notebookKeyId)
+		if key == nil {
+			return "", ErrNotebookLocked
+		}
+		return sealWithNotebookKey(s.notebookKeyId, key, content)
+	}
+	if s.mode != EncryptionContent {
+		return content, nil
+	}
+	if s.aead == nil {
+		return "", ErrEncryptionLocked
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(content), nil)
+	return sealedContentPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/**
+ * Decrypts encrypted content, whatever the mode (returns content that isn't encrypted as it is)
+ */
+func (s contentSealer) open(content string) (string, error) {
+	if strings.HasPrefix(content, notebookSealedPrefix) {
+		return openWithNotebookKey(s.keys, content)
+	}
+	if !isSealed(content) {
+		return content, nil
+	}
+	if s.aead == nil {
+		return "", ErrEncryptionLocked
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(content, sealedContentPrefix))
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("encrypted content is malformed")
+	}
+	nonceSize := s.aead.NonceSize()
+	opened, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("encrypted content can't be decrypted: it was tampered with")
+	}
+	return string(opened), nil
+}
+
+func isSealed(content string) bool {
+	return strings.HasPrefix(content, sealedContentPrefix) || strings.HasPrefix(content, notebookSealedPrefix)
+}
+
+/**
+ * Key derived from a passphrase, checked against the settings' check value
+ */
+func unlockContentKey(passphrase string, settings encryptionSettings) (cipher.AEAD, error) {
+	key, err := contentKey(passphrase, settings.Salt)
+	if err != nil {
+		return nil, err
+	}
+	check, err := contentSealer{mode: EncryptionContent, aead: key}.open(settings.Check)
+	if err != nil || check != encryptionCheckValue {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+/**
+ * AES-256-GCM with the key derived from a passphrase (with the parameters of exports written)
+ */
+func contentKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,47 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestEncryptionPassphraseIsOnlySetByInit(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "secret plans")
+
+	// unlocking never adopts a passphrase
+	if err := db.UnlockEncryption("first"); !errors.Is(err, models.ErrEncryptionNotInitialized) {
+		t.Fatalf("unlocking a DB without passphrase: %v, want ErrEncryptionNotInitialized", err)
+	}
+	if _, err := db.MigrateEncryption(models.EncryptionContent); !errors.Is(err, models.ErrEncryptionLocked) {
+		t.Fatalf("encrypting without passphrase: %v, want ErrEncryptionLocked", err)
+	}
+
+	if err := db.InitEncryption("second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateEncryption(models.EncryptionContent); err != nil {
+		t.Fatal(err)
+	}
+	// setting the same passphrase again just unlocks, setting another one fails
+	if err := db.InitEncryption("second"); err != nil {
+		t.Errorf("setting the passphrase again: %v", err)
+	}
+	for _, passphrase := range []string{"first", ""} {
+		if err := db.InitEncryption(passphrase); !errors.Is(err, models.ErrWrongPassphrase) {
+			t.Errorf("setting passphrase %q over another: %v, want ErrWrongPassphrase", passphrase, err)
+		}
+		if err := db.UnlockEncryption(passphrase); !errors.Is(err, models.ErrWrongPassphrase) {
+			t.Errorf("unlocking with passphrase %q: %v, want ErrWrongPassphrase", passphrase, err)
+		}
+	}
+	if err := db.UnlockEncryption("second"); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := db.GetNote("work", note.Id); err != nil || read.Content != "secret plans" {
+		t.Errorf("encrypted note read as %q (%v)", read.Content, err)
+	}
+}
@@ -0,0 +1,463 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+/**
+ * Layout of timestamps in ENEX files (like 20130730T205204Z)
+ */
+const enexTimeLayout = "20060102T150405Z"
+
+/**
+ * Options of ImportENEX
+ */
+type ENEXOptions struct {
+	// convert ENML to Markdown (headings, emphasis, links ..) rather than plain text
+	Markdown bool
+	// number of notes committed per transaction (DefaultBulkBatchSize if not positive)
+	BatchSize int
+	// run the import within WithRelaxedDurability (see its caveats)
+	RelaxedDurability bool
+	// don't import notes whose (converted) content already exists in the notebook
+	DedupeByContent bool
+	// what to do with notes the notebook already has (see ConflictPolicy)
+	OnConflict ConflictPolicy
+	// called with a token to resume from as batches get committed (see resume.go)
+	OnCheckpoint func(ResumeToken)
+	// continue an import that failed midway, from a token it emitted
+	ResumeFrom ResumeToken
+	// if set, every note read is passed through it before it's imported (see transform.go)
+	Transform TransformFunc
+}
+
+/**
+ * A note left out of an import, along with the reason
+ */
+type SkippedNote struct {
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+/**
+ * Outcome of an import
+ *  - Mapping has an entry for every record read, including failed and duplicate ones
+ *    (see IdMapping, and WriteIdMapping to persist it)
+ *  - Conflicts counts notes the notebook already had, by how they were resolved (see ConflictPolicy);
+ *    notes kept both ways count as Imported as well
+ *  - Filter is set by ImportNotebook for exports that cover only part of their notebook
+ *  - Warnings are about the input, like fields its format version doesn't define (each reported once)
+ *  - Resume is the last checkpoint of an import that can be resumed (see resume.go)
+ *  - Relations counts relations between imported notes recreated by ImportNotebook
+ *  - TransformSkipped counts records left out by the import's TransformFunc (see ErrSkipRecord)
+ */
+type ImportReport struct {
+	Imported         int            `json:"imported"`
+	Duplicates       int            `json:"duplicates"`
+	Conflicts        ConflictCounts `json:"conflicts"`
+	Skipped          []SkippedNote  `json:"skipped"`
+	Mapping          []IdMapping    `json:"mapping"`
+	Filter           *NoteFilter    `json:"filter,omitempty"`
+	Warnings         []string       `json:"warnings,omitempty"`
+	Resume           ResumeToken    `json:"resume,omitempty"`
+	Relations        int            `json:"relations,omitempty"`
+	TransformSkipped int            `json:"transform_skipped,omitempty"`
+}
+
+/**
+ * Adds warnings to the report, leaving out ones already reported
+ */
+func (r *ImportReport) warn(warnings ...string) {
+	for _, warning := range warnings {
+		if !containsString(r.Warnings, warning) {
+			r.Warnings = append(r.Warnings, warning)
+		}
+	}
+}
+
+/**
+ * A <note> of an ENEX file; <resource>s are left out, so their (base64) data is discarded while parsing
+ */
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"`
+	Created string   `xml:"created"`
+	Updated string   `xml:"updated"`
+	Tags    []string `xml:"tag"`
+}
+
+/**
+ * Imports notes of an Evernote export (.enex) into given notebook
+ *  - the file is stream-parsed one <note> at a time, and notes are committed in batches,
+ *    so memory use is bounded by the largest note rather than the file size
+ *  - ENML content is converted to plain text (or Markdown), keeping lists and checkboxes
+ *    (as '- [ ]' tasks); the title becomes the first line of the note
+ *  - tags and created / updated times are carried over
+ *  - resources (attachments) are not imported, but replaced by '[attachment]'
+ *  - malformed notes are skipped and reported; only a broken XML stream (or a failing
+ *    commit) aborts the import, in which case the report covers the notes read until then
+ *  - the report maps every note read onto the note it was imported as (see IdMapping)
+ *  - a checkpoint is emitted after every batch, to resume from should the import fail (see resume.go)
+ * param: string      notebookName
+ * param: io.Reader   r
+ * param: ENEXOptions opts
+ * return: (ImportReport, error)
+ */
+func (db *DB) ImportENEX(notebookName string, r io.Reader, opts ENEXOptions) (ImportReport, error) {
+	defer db.markBusy()()
+	if opts.RelaxedDurability {
+		opts.RelaxedDurability = false
+		var report ImportReport
+		err := db.WithRelaxedDurability(func() error {
+			var err error
+			report, err = db.ImportENEX(notebookName, r, opts)
+			return err
+		})
+		return report, err
+	}
+
+	var report ImportReport
+	progress, err := db.newImportProgress(notebookName, opts.ResumeFrom, opts.OnCheckpoint, &report)
+	if err != nil {
+		return report, err
+	}
+	batcher, err := db.newImportBatcher(notebookName, opts.BatchSize, opts.DedupeByContent, opts.OnConflict, &report)
+	if err != nil {
+		return report, err
+	}
+
+	decoder := xml.NewDecoder(r)
+	records, commits := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		if records++; progress.skipped(records) {
+			if err := decoder.Skip(); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		var raw enexNote
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			return report, err
+		}
+		sourceKey := raw.sourceKey()
+		note, err := raw.toNote(opts.Markdown)
+		if err != nil {
+			batcher.skip(raw.Title, sourceKey, err)
+			continue
+		}
+		record := ImportedRecord{Source: RecordSource{Record: records, Offset: decoder.InputOffset()}, SourceKey: sourceKey, Note: note}
+		if note, _, ok, err = transformRecord(opts.Transform, record, &report); err != nil {
+			return report, err
+		} else if !ok {
+			continue
+		}
+		if recovered, err := progress.recover(note, sourceKey, true); err != nil || recovered {
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err := batcher.add(note, sourceKey); err != nil {
+			return report, err
+		}
+		// checkpoint once a commit left no note queued
+		if len(batcher.notes) == 0 && batcher.commits != commits {
+			commits = batcher.commits
+			if err := progress.checkpoint(records, decoder.InputOffset()); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := batcher.flush(); err != nil {
+		return report, err
+	}
+	return report, progress.checkpoint(records, decoder.InputOffset())
+}
+
+/**
+ * Key identifying an ENEX note in id-mappings: 'enex:' followed by
+ * hex SHA-256 of title, created time and raw content (separated by NUL bytes)
+ */
+func (n enexNote) sourceKey() string {
+	return "enex:" + contentHash(n.Title+"\x00"+n.Created+"\x00"+n.Content)
+}
+
+/**
+ * Converts a parsed ENEX note into a Note
+ */
+func (n enexNote) toNote(markdown bool) (Note, error) {
+	var note Note
+	title := strings.TrimSpace(n.Title)
+	body, err := enmlToText(n.Content, markdown)
+	if err != nil {
+		return note, fmt.Errorf("invalid content: %v", err)
+	}
+	switch {
+	case title == "" && body == "":
+		return note, errors.New("note is empty")
+	case title == "":
+		note.Content = body
+	case markdown:
+		note.Content = strings.TrimSpace("# " + title + "\n\n" + body)
+	default:
+		note.Content = strings.TrimSpace(title + "\n\n" + body)
+	}
+
+	for _, tag := range n.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !containsString(note.Tags, tag) {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+	if n.Created != "" {
+		if note.CreatedAt, err = time.Parse(enexTimeLayout, strings.TrimSpace(n.Created)); err != nil {
+			return note, fmt.Errorf("invalid created time: %v", err)
+		}
+	}
+	if n.Updated != "" {
+		if note.UpdatedAt, err = time.Parse(enexTimeLayout, strings.TrimSpace(n.Updated)); err != nil {
+			return note, fmt.Errorf("invalid updated time: %v", err)
+		}
+	}
+	return note, nil
+}
+
+/**
+ * Converts ENML (Evernote's XHTML dialect) to plain text or Markdown
+ */
+func enmlToText(enml string, markdown bool) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(enml))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	w := &enmlWriter{markdown: markdown}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			w.start(t)
+		case xml.EndElement:
+			w.end(t.Name.Local)
+		case xml.CharData:
+			w.text(string(t))
+		}
+	}
+	return w.String(), nil
+}
+
+/**
+ * Accumulates text converted from ENML tokens
+ *  - block elements end the current line; lists are indented by nesting level
+ *  - skip > 0 while inside elements whose text is dropped (like <en-crypt>)
+ */
+type enmlWriter struct {
+	markdown bool
+	sb       strings.Builder
+	line     strings.Builder
+	lists    []enmlList
+	links    []string
+	skip     int
+}
+
+type enmlList struct {
+	ordered bool
+	items   int
+}
+
+func (w *enmlWriter) start(el xml.StartElement) {
+	if w.skip > 0 {
+		w.skip++
+		return
+	}
+	switch name := el.Name.Local; name {
+	case "en-crypt":
+		w.write("[encrypted]")
+		w.skip = 1
+	case "en-media":
+		w.write("[attachment]")
+	case "en-todo":
+		mark := " "
+		if attr(el, "checked") == "true" {
+			mark = "x"
+		}
+		if w.line.Len() == 0 && len(w.lists) == 0 {
+			w.write("- [" + mark + "] ")
+		} else {
+			w.write("[" + mark + "] ")
+		}
+	case "br":
+		w.newline()
+	case "div", "p", "blockquote", "pre", "table", "tr":
+		w.newline()
+	case "hr":
+		w.newline()
+		if w.markdown {
+			w.write("---")
+		}
+		w.newline()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.newline()
+		if w.markdown {
+			w.write(strings.Repeat("#", int(name[1]-'0')) + " ")
+		}
+	case "ul", "ol":
+		w.newline()
+		w.lists = append(w.lists, enmlList{ordered: name == "ol"})
+	case "li":
+		w.newline()
+		if len(w.lists) == 0 {
+			w.write("- ")
+			return
+		}
+		list := &w.lists[len(w.lists)-1]
+		list.items++
+		indent := strings.Repeat("  ", len(w.lists)-1)
+		if list.ordered {
+			w.write(fmt.Sprintf("%s%d. ", indent, list.items))
+		} else {
+			w.write(indent + "- ")
+		}
+	case "td", "th":
+		if w.line.Len() > 0 {
+			w.write(" | ")
+		}
+	case "b", "strong":
+		if w.markdown {
+			w.write("**")
+		}
+	case "i", "em":
+		if w.markdown {
+			w.write("_")
+		}
+	case "code":
+		if w.markdown {
+			w.write("`")
+		}
+	case "a":
+		w.links = append(w.links, attr(el, "href"))
+		if w.markdown {
+			w.write("[")
+		}
+	}
+}
+
+func (w *enmlWriter) end(name string) {
+	if w.skip > 0 {
+		w.skip--
+		return
+	}
+	switch name {
+	case "div", "p", "blockquote", "pre", "table", "tr", "li", "h1", "h2", "h3", "h4", "h5", "h6":
+		w.newline()
+	case "ul", "ol":
+		w.newline()
+		if len(w.lists) > 0 {
+			w.lists = w.lists[:len(w.lists)-1]
+		}
+	case "b", "strong":
+		if w.markdown {
+			w.write("**")
+		}
+	case "i", "em":
+		if w.markdown {
+			w.write("_")
+		}
+	case "code":
+		if w.markdown {
+			w.write("`")
+		}
+	case "a":
+		var href string
+		if len(w.links) > 0 {
+			href = w.links[len(w.links)-1]
+			w.links = w.links[:len(w.links)-1]
+		}
+		if w.markdown {
+			w.write("](" + href + ")")
+		}
+	}
+}
+
+func (w *enmlWriter) text(text string) {
+	if w.skip > 0 {
+		return
+	}
+	// collapse whitespace like a browser would
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" && w.line.Len() > 0 && !strings.HasSuffix(w.line.String(), " ") {
+			w.write(" ")
+		}
+		return
+	}
+	joined := strings.Join(fields, " ")
+	if startsWithSpace(text) && w.line.Len() > 0 && !strings.HasSuffix(w.line.String(), " ") {
+		joined = " " + joined
+	}
+	if endsWithSpace(text) {
+		joined += " "
+	}
+	w.write(joined)
+}
+
+func (w *enmlWriter) write(s string) {
+	w.line.WriteString(s)
+}
+
+/**
+ * Ends the current line (if it has any text)
+ */
+func (w *enmlWriter) newline() {
+	line := strings.TrimRight(w.line.String(), " ")
+	w.line.Reset()
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	w.sb.WriteString(line)
+	w.sb.WriteByte('\n')
+}
+
+func (w *enmlWriter) String() string {
+	w.newline()
+	return strings.TrimRight(w.sb.String(), "\n")
+}
+
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeft(s[:1], " \t\r\n") == ""
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRight(s[len(s)-1:], " \t\r\n") == ""
+}
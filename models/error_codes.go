@@ -0,0 +1,242 @@
+package models
+
+import (
+	"errors"
+)
+
+/**
+ * Errors of the package are classified by stable, machine-readable codes (see ErrorCodeOf), which
+ * front ends report along with the message (like the API's error payloads, or the CLI's with `--output json`)
+ *  - every error the package returns is one of the sentinel errors below (possibly wrapped) or one of
+ *    the typed errors, and maps to exactly one code; anything else is INTERNAL
+ *  - codes are part of the interface: new errors are given one of the existing codes
+ */
+
+/**
+ * Machine-readable class of an error
+ */
+type ErrorCode string
+
+const (
+	// a note (or something else than a notebook, like an attachment or a token) doesn't exist
+	CodeNotFound ErrorCode = "NOT_FOUND"
+	// a notebook doesn't exist
+	CodeNotebookNotFound ErrorCode = "NOTEBOOK_NOT_FOUND"
+	// what's asked for clashes with the current state (like a note modified since it was read, or a mass
+	// delete not planned)
+	CodeConflict ErrorCode = "CONFLICT"
+	// what's asked for is over a limit (like an attachment larger than allowed)
+	CodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+	// notes (or their notebook) can't be changed
+	CodeReadOnly ErrorCode = "READ_ONLY"
+	// what's asked for is invalid (like a malformed query or export)
+	CodeValidation ErrorCode = "VALIDATION"
+	// what's asked for is out of reach: DB held by another process, encrypted content, access not granted
+	CodeLocked ErrorCode = "LOCKED"
+	// stored data can't be read
+	CodeCorrupt ErrorCode = "CORRUPT"
+	// anything else
+	CodeInternal ErrorCode = "INTERNAL"
+)
+
+/**
+ * Codes of sentinel errors (wrapped or not), checked in order
+ */
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrNoteNotFound, CodeNotFound},
+	{ErrAttachmentNotFound, CodeNotFound},
+	{ErrFavoriteNotFound, CodeNotFound},
+	{ErrNoteShareNotFound, CodeNotFound},
+	{ErrNoteShareGone, CodeNotFound},
+	{ErrFilingRuleNotFound, CodeNotFound},
+	{ErrDeletePlanNotFound, CodeNotFound},
+	{ErrSnapshotNotFound, CodeNotFound},
+	{ErrRelationNotFound, CodeNotFound},
+	{ErrRollupNotFound, CodeNotFound},
+	{ErrTemplateNotFound, CodeNotFound},
+	{ErrUndoEntryNotFound, CodeNotFound},
+	{ErrAPITokenNotFound, CodeNotFound},
+	{ErrRecurringRuleNotFound, CodeNotFound},
+	{ErrNotebookNotFound, CodeNotebookNotFound},
+
+	{ErrDeletePlanStale, CodeConflict},
+	{ErrNotebookNameCollision, CodeConflict},
+	{ErrDuplicateNotebook, CodeConflict},
+	{ErrAPITokenExists, CodeConflict},
+	{ErrUndoConflict, CodeConflict},
+	{ErrTaskConflict, CodeConflict},
+	{ErrIdNotReserved, CodeConflict},
+	{ErrRelaxedDurabilityActive, CodeConflict},
+	{ErrSnapshotReleased, CodeConflict},
+	{ErrNotebookExists, CodeConflict},
+	{ErrTitleTaken, CodeConflict},
+	{ErrChangelogTruncated, CodeConflict},
+	{ErrIndexNotBuilt, CodeConflict},
+	{ErrNotebookEncrypted, CodeConflict},
+	{ErrNotebookNotEncrypted, CodeConflict},
+	{ErrConfirmationRequired, CodeConflict},
+
+	{ErrSnapshotTooLarge, CodeQuotaExceeded},
+	{ErrClipTooLarge, CodeQuotaExceeded},
+	{ErrAttachmentTooLarge, CodeQuotaExceeded},
+
+	{ErrNoteReadOnly, CodeReadOnly},
+	{ErrNotebookArchived, CodeReadOnly},
+	{ErrDatabaseReadOnly, CodeReadOnly},
+
+	{ErrInvalidQuery, CodeValidation},
+	{ErrInvalidContent, CodeValidation},
+	{ErrUnknownKind, CodeValidation},
+	{ErrContentMismatch, CodeValidation},
+	{ErrEncryptedSearch, CodeValidation},
+	{ErrUnknownConflictPolicy, CodeValidation},
+	{ErrUnknownEncryptionMode, CodeValidation},
+	{ErrInvalidNoteExport, CodeValidation},
+	{ErrInvalidTakeout, CodeValidation},
+	{ErrInvalidFilingRule, CodeValidation},
+	{ErrInvalidNoteShare, CodeValidation},
+	{ErrInvalidRelation, CodeValidation},
+	{ErrInvalidResumeToken, CodeValidation},
+	{ErrInvalidShortID, CodeValidation},
+	{ErrUnknownAbbreviation, CodeValidation},
+	{ErrAmbiguousAbbreviation, CodeValidation},
+	{ErrInvalidTagChange, CodeValidation},
+	{ErrReservedNotebookName, CodeValidation},
+	{ErrInvalidScope, CodeValidation},
+	{ErrSkipRecord, CodeValidation},
+	{ErrInvalidTemplate, CodeValidation},
+	{ErrNoDatabase, CodeValidation},
+	{ErrInvalidNotebookPolicy, CodeValidation},
+	{ErrInvalidClipURL, CodeValidation},
+	{ErrInvalidBatchPlan, CodeValidation},
+	{ErrInvalidSplit, CodeValidation},
+	{ErrNothingToSplit, CodeValidation},
+	{ErrInvalidRecurringRule, CodeValidation},
+
+	{ErrDatabaseLocked, CodeLocked},
+	{ErrEncryptionLocked, CodeLocked},
+	{ErrEncryptionNotInitialized, CodeLocked},
+	{ErrPassphraseRequired, CodeLocked},
+	{ErrWrongPassphrase, CodeLocked},
+	{ErrNotebookLocked, CodeLocked},
+	{ErrForbidden, CodeLocked},
+	{ErrInvalidAPIToken, CodeLocked},
+
+	{ErrCorruptNote, CodeCorrupt},
+	{ErrMissingChunks, CodeCorrupt},
+
+	{ErrClosed, CodeInternal},
+	{ErrCloseTimeout, CodeInternal},
+	{ErrWriteTimeout, CodeInternal},
+	{ErrClipFailed, CodeInternal},
+	{ErrDatabaseUnavailable, CodeInternal},
+}
+
+/**
+ * Code of an error (see errorCodes); typed errors come first, as some wrap errors of other codes
+ * param: error err
+ * return: ErrorCode
+ */
+func ErrorCodeOf(err error) ErrorCode {
+	var (
+		revisionNotFound *RevisionNotFoundError
+		revisionConflict *RevisionConflictError
+		missingRefs      *MissingRefsError
+		readOnly         *ReadOnlyNotesError
+		formatVersion    *FormatVersionError
+		writeTimeout     *WriteTimeoutError
+		databaseLocked   *DatabaseLockedError
+		forbidden        *ForbiddenError
+		outboxHandler    *OutboxHandlerError
+		recordTransform  *RecordTransformError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &revisionNotFound), errors.As(err, &missingRefs):
+		return CodeNotFound
+	case errors.As(err, &revisionConflict):
+		return CodeConflict
+	case errors.As(err, &readOnly):
+		return CodeReadOnly
+	case errors.As(err, &formatVersion), errors.As(err, &recordTransform):
+		// (a transform failing is the caller's doing, whatever it failed with)
+		return CodeValidation
+	case errors.As(err, &databaseLocked), errors.As(err, &forbidden):
+		return CodeLocked
+	case errors.As(err, &outboxHandler):
+		// (whatever the handler failed with, the note's change was left unprocessed)
+		return CodeInternal
+	case errors.As(err, &writeTimeout):
+		// (nothing's wrong with what was asked: the write may well fit its budget on retry)
+		return CodeInternal
+	}
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return CodeInternal
+}
+
+/**
+ * An error as reported to clients: its code and message, and the notebook / note it's about
+ * when the error tells (typed errors do)
+ */
+type ErrorInfo struct {
+	Code     ErrorCode `json:"code"`
+	Message  string    `json:"message"`
+	Notebook string    `json:"notebook,omitempty"`
+	NoteId   uint64    `json:"note_id,omitempty"`
+}
+
+/**
+ * Describes an error for clients (see ErrorInfo)
+ * param: error err
+ * return: ErrorInfo
+ */
+func DescribeError(err error) ErrorInfo {
+	info := ErrorInfo{Code: ErrorCodeOf(err), Message: err.Error()}
+	var (
+		revisionNotFound *RevisionNotFoundError
+		revisionConflict *RevisionConflictError
+		missingRefs      *MissingRefsError
+		readOnly         *ReadOnlyNotesError
+		forbidden        *ForbiddenError
+		outboxHandler    *OutboxHandlerError
+		recordTransform  *RecordTransformError
+		titleTaken       *TitleTakenError
+		titleCollisions  *TitleCollisionsError
+		batchStep        *BatchStepError
+	)
+	switch {
+	case errors.As(err, &revisionNotFound):
+		info.Notebook, info.NoteId = revisionNotFound.Notebook, revisionNotFound.NoteId
+	case errors.As(err, &revisionConflict):
+		info.Notebook, info.NoteId = revisionConflict.Notebook, revisionConflict.Current.Id
+	case errors.As(err, &missingRefs) && len(missingRefs.Refs) == 1:
+		info.Notebook, info.NoteId = missingRefs.Refs[0].Notebook, missingRefs.Refs[0].Id
+	case errors.As(err, &readOnly):
+		info.Notebook = readOnly.Notebook
+		if len(readOnly.Ids) == 1 {
+			info.NoteId = readOnly.Ids[0]
+		}
+	case errors.As(err, &forbidden):
+		info.Notebook = forbidden.Notebook
+	case errors.As(err, &outboxHandler):
+		info.Notebook, info.NoteId = outboxHandler.Event.Notebook, outboxHandler.Event.NoteId
+	case errors.As(err, &recordTransform) && recordTransform.Ref != nil:
+		info.Notebook, info.NoteId = recordTransform.Ref.Notebook, recordTransform.Ref.Id
+	case errors.As(err, &titleTaken):
+		info.Notebook, info.NoteId = titleTaken.Notebook, titleTaken.TakenBy
+	case errors.As(err, &titleCollisions):
+		info.Notebook = titleCollisions.Notebook
+	case errors.As(err, &batchStep):
+		// (after the errors steps fail with, telling more)
+		info.Notebook = batchStep.Notebook
+	}
+	return info
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/**
+ * Names of exported sentinel errors declared by the package (package-level 'Err..' vars), read from its sources
+ */
+func exportedSentinels(t *testing.T) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range packages["models"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if strings.HasPrefix(name.Name, "Err") && name.IsExported() {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+ * Names of the sentinel errors of errorCodes, by code, read from its declaration
+ */
+func codedSentinels(t *testing.T) map[string]string {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "error_codes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coded := make(map[string]string)
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok || spec.Names[0].Name != "errorCodes" {
+			return true
+		}
+		for _, element := range spec.Values[0].(*ast.CompositeLit).Elts {
+			entry := element.(*ast.CompositeLit).Elts
+			name, code := entry[0].(*ast.Ident).Name, entry[1].(*ast.Ident).Name
+			if _, twice := coded[name]; twice {
+				t.Errorf("%s is given a code twice", name)
+			}
+			coded[name] = code
+		}
+		return false
+	})
+	return coded
+}
+
+func TestEverySentinelHasCode(t *testing.T) {
+	coded := codedSentinels(t)
+	sentinels := exportedSentinels(t)
+	if len(sentinels) < len(coded) {
+		t.Fatalf("%d sentinels found, fewer than the %d given codes", len(sentinels), len(coded))
+	}
+	for _, name := range sentinels {
+		if _, ok := coded[name]; !ok {
+			t.Errorf("%s has no code in errorCodes", name)
+		}
+	}
+
+	// and the table agrees with ErrorCodeOf, wrapped or not (no sentinel is shadowed by an earlier one)
+	for _, entry := range errorCodes {
+		for _, err := range []error{entry.err, wrapped(entry.err)} {
+			if code := ErrorCodeOf(err); code != entry.code {
+				t.Errorf("%v has code %s, want %s", err, code, entry.code)
+			}
+		}
+		if entry.code == "" {
+			t.Errorf("%v has an empty code", entry.err)
+		}
+	}
+}
+
+func wrapped(err error) error {
+	return fmt.Errorf("context: %w", err)
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Returns whether the note has expired as of given time
+ */
+func (n Note) Expired(now time.Time) bool {
+	return n.ExpiresAt != nil && !n.ExpiresAt.After(now)
+}
+
+/**
+ * Sets (or with nil expiresAt, clears) expiry of a note
+ * Expired notes are hidden from ListNotes and physically removed by PurgeExpired
+ * param: string     notebookName
+ * param: uint64     noteId
+ * param: *time.Time expiresAt
+ * return: error
+ */
+func (db *DB) SetExpiry(notebookName string, noteId uint64, expiresAt *time.Time) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		// expiring leads to deletion, which read-only notes are guarded against
+		if err := checkWritable(notebookName, note, false); err != nil {
+			return err
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		note.ExpiresAt = expiresAt
+		db.bumpRevision(&note)
+		return db.putNote(tx, db.notebookKey(notebookName), note)
+	})
+}
+
+/**
+ * Physically removes expired notes from all notebooks
+ * (until then, expired notes can still be retrieved via ListNotes(.., WithExpired()))
+ * Notes locked read-only are kept, even if they have expired, as are notes of archived notebooks
+ * return: (int, error) Number of notes removed
+ */
+func (db *DB) PurgeExpired() (int, error) {
+	purged := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if notebookBucket == nil || notebookArchived(tx, notebookKey) {
+				return nil
+			}
+
+			// collect keys first, as bolt doesn't allow deleting while iterating with ForEach
+			var expiredKeys [][]byte
+			err := notebookBucket.ForEach(func(noteIdBytes, encodedNote []byte) error {
+				var note Note
+				if err := json.Unmarshal(encodedNote, &note); err != nil {
+					return err
+				}
+				if note.Expired(now) && !note.ReadOnly {
+					expiredKeys = append(expiredKeys, append([]byte(nil), noteIdBytes...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, noteIdBytes := range expiredKeys {
+				noteId, _ := strconv.ParseUint(string(noteIdBytes), 10, 64)
+				db.invalidateNote(tx, notebookKey, noteId)
+				if err := notebookBucket.Delete(noteIdBytes); err != nil {
+					return err
+				}
+				if err := deleteNoteData(tx, notebookKey, noteId); err != nil {
+					return err
+				}
+				if err := db.recordChange(tx, ChangeDeleted, notebookKey, noteId, 0); err != nil {
+					return err
+				}
+			}
+			purged += len(expiredKeys)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
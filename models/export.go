@@ -0,0 +1,439 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Version of the format written by ExportNote (see export_format.go for what changed between versions)
+ */
+const NoteExportFormat = 8
+
+/**
+ * Returned by ImportNote when the input isn't a (supported, intact) note export
+ */
+var ErrInvalidNoteExport = errors.New("not a valid note export")
+
+/**
+ * Self-contained representation of a single note, as written by ExportNote
+ *  - Note carries the note's id in the source notebook for reference only; imports get a fresh id
+ *  - ContentHash (hex SHA-256 of content) guards against tampering and drives de-duplication
+ *  - Blobs holds the content of attachments by hash, once however many attachments share it
+ *  - Relations are those going from the note; imports recreate the ones whose other note is
+ *    part of the same import (see ImportNotebook)
+ */
+type NoteExport struct {
+	Format      int               `json:"format"`
+	ExportedAt  time.Time         `json:"exported_at"`
+	Notebook    string            `json:"notebook"`
+	Note        Note              `json:"note"`
+	ContentHash string            `json:"content_hash"`
+	History     []NoteRevision    `json:"history,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	Blobs       map[string][]byte `json:"blobs,omitempty"`
+	Relations   []Relation        `json:"relations,omitempty"`
+}
+
+/**
+ * Options of ExportNote
+ */
+type NoteExportOptions struct {
+	// include past revisions of the note
+	IncludeHistory bool
+	// indent the JSON for readability
+	Indent bool
+	// if set, the export is encrypted with it (see EncryptExport)
+	Passphrase string
+	// if set, the export is passed through it before it's written (see transform.go)
+	Render RenderFunc
+}
+
+/**
+ * Options of ImportNote (and ImportKeepTakeout)
+ */
+type ImportOptions struct {
+	// if a note with the same content already exists in the notebook, return it instead of
+	// creating another one (otherwise importing the same export twice creates two notes)
+	DedupeByContent bool
+	// what to do with notes the notebook already has, checked after DedupeByContent (see ConflictPolicy)
+	OnConflict ConflictPolicy
+	// if set, an id-mapping of the imported note is written to it (see WriteIdMapping);
+	// the source key is the note's 'notebook/id' in the exporting DB
+	Mapping io.Writer
+	// ImportKeepTakeout: also import notes that were in Keep's trash
+	IncludeTrashed bool
+	// ImportNote: called with warnings about the input, like fields its format version doesn't
+	// define (other imports report them in ImportReport.Warnings)
+	OnWarning func(warning string)
+	// passphrase of encrypted exports (see EncryptExport); importing one without it fails with
+	// ErrPassphraseRequired, and with a wrong one with ErrWrongPassphrase
+	Passphrase string
+	// ImportNotebook: passphrase of the notebook an export of an encrypted notebook was made of (see
+	// notebook_encryption.go), unless its key is unlocked; fails like Passphrase otherwise
+	NotebookPassphrase string
+	// ImportNotebook: called with a token to resume from as notes get committed (see resume.go)
+	OnCheckpoint func(ResumeToken)
+	// ImportNotebook: continue an import that failed midway, from a token it emitted
+	ResumeFrom ResumeToken
+	// RestoreTakeout: only restore these notebooks (all of them if empty)
+	Notebooks []string
+	// if set, every record read is passed through it before it's imported (see transform.go)
+	Transform TransformFunc
+}
+
+/**
+ * Writes a note (content, tags, timestamps, attachments and optionally its revision history)
+ * as a single JSON document
+ * param: string            notebookName
+ * param: uint64            noteId
+ * param: io.Writer         w
+ * param: NoteExportOptions opts
+ * return: error
+ */
+func (db *DB) ExportNote(notebookName string, noteId uint64, w io.Writer, opts NoteExportOptions) error {
+	var export NoteExport
+	err := db.View(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		export, err = db.noteExportInTx(tx, db.notebookKey(notebookName), note, opts.IncludeHistory)
+		return err
+	})
+	if err == nil {
+		export, err = renderNoteExport(opts.Render, export)
+	}
+	if err != nil {
+		return err
+	}
+
+	var encrypted io.WriteCloser
+	if opts.Passphrase != "" {
+		if encrypted, err = EncryptExport(w, opts.Passphrase); err != nil {
+			return err
+		}
+		w = encrypted
+	}
+	encoder := json.NewEncoder(w)
+	if opts.Indent {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(export); err != nil || encrypted == nil {
+		return err
+	}
+	return encrypted.Close()
+}
+
+/**
+ * Builds the export of a note (along with its attachments, and optionally its history) within given transaction
+ */
+func (db *DB) noteExportInTx(tx *bolt.Tx, notebookKey []byte, note Note, includeHistory bool) (NoteExport, error) {
+	export, err := noteExportShellInTx(tx, notebookKey, note.Id)
+	if err != nil {
+		return export, err
+	}
+	export.Note, export.ContentHash = note, contentHash(note.Content)
+	if !includeHistory {
+		return export, nil
+	}
+	historyBucket := noteHistoryBucket(tx, notebookKey, note.Id)
+	if historyBucket == nil {
+		return export, nil
+	}
+	export.History, err = readHistory(historyBucket, db.sealer())
+	return export, err
+}
+
+/**
+ * Builds the export of a note but for the note itself (and its history): its attachments and relations,
+ * which are all the export needs the transaction for
+ */
+func noteExportShellInTx(tx *bolt.Tx, notebookKey []byte, noteId uint64) (NoteExport, error) {
+	export := NoteExport{
+		Format:     NoteExportFormat,
+		ExportedAt: time.Now(),
+		Notebook:   notebookDisplayName(tx, notebookKey),
+	}
+	attachments, err := listAttachmentsInTx(tx, notebookKey, noteId)
+	if err != nil {
+		return export, err
+	}
+	for _, attachment := range attachments {
+		if _, ok := export.Blobs[attachment.Hash]; ok {
+			export.Attachments = append(export.Attachments, attachment)
+			continue
+		}
+		content := readBlob(tx, attachment.Hash)
+		if content == nil {
+			return export, fmt.Errorf("%w: blob %s of attachment '%s'", ErrMissingChunks, attachment.Hash, attachment.Name)
+		}
+		if export.Blobs == nil {
+			export.Blobs = make(map[string][]byte)
+		}
+		export.Attachments = append(export.Attachments, attachment)
+		export.Blobs[attachment.Hash] = content
+	}
+	entries, err := relationEntries(tx, notebookKey, noteId)
+	if err != nil {
+		return export, err
+	}
+	for _, entry := range entries {
+		if entry.Outgoing {
+			export.Relations = append(export.Relations, Relation{
+				From: NoteRef{Notebook: export.Notebook, Id: noteId},
+				To:   NoteRef{Notebook: notebookDisplayName(tx, []byte(entry.Notebook)), Id: entry.Id},
+				Kind: entry.Kind,
+			})
+		}
+	}
+	return export, nil
+}
+
+/**
+ * Recreates a note written by ExportNote in given notebook (created if it doesn't exist)
+ *  - the note gets a fresh id; content, tags, timestamps, attachments and history are kept as exported
+ *  - content of attachments already stored in this DB isn't stored again
+ *    (notebook defaults are not applied, so that the note round-trips unchanged)
+ *  - fails with ErrSkipRecord if opts.Transform left the note out
+ * param: string        notebookName
+ * param: io.Reader     r
+ * param: ImportOptions opts
+ * return: (Note, error) The note as stored (or the existing one, when de-duplicated or when
+ *         opts.OnConflict resolved a conflict with it)
+ */
+func (db *DB) ImportNote(notebookName string, r io.Reader, opts ImportOptions) (Note, error) {
+	note, sourceKey, status, err := db.importNote(notebookName, r, opts)
+	if opts.Mapping != nil {
+		mapping := mappedTo(sourceKey, notebookName, note.Id, status)
+		switch {
+		case errors.Is(err, ErrSkipRecord):
+			mapping = IdMapping{SourceKey: sourceKey, Status: MappingSkipped, Error: err.Error()}
+		case err != nil:
+			mapping = mappingFailed(sourceKey, err)
+		}
+		if mappingErr := WriteIdMapping(opts.Mapping, []IdMapping{mapping}); err == nil {
+			err = mappingErr
+		}
+	}
+	return note, err
+}
+
+/**
+ * Core logic of ImportNote, also returning the source key of the note and whether it was a duplicate
+ */
+func (db *DB) importNote(notebookName string, r io.Reader, opts ImportOptions) (Note, string, MappingStatus, error) {
+	r, err := openExport(r, opts.Passphrase)
+	if err != nil {
+		return Note{}, "", MappingFailed, err
+	}
+	var data json.RawMessage
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return Note{}, "", MappingFailed, exportReadError(err)
+	}
+	export, warnings, err := decodeNoteExport(data)
+	if err != nil {
+		return Note{}, "", MappingFailed, err
+	}
+	if opts.OnWarning != nil {
+		for _, warning := range warnings {
+			opts.OnWarning(warning)
+		}
+	}
+	sourceKey := NoteRef{Notebook: export.Notebook, Id: export.Note.Id}.String()
+	export, ok, err := transformNoteExport(opts.Transform, export, RecordSource{Record: 1}, &ImportReport{})
+	if err != nil {
+		return Note{}, sourceKey, MappingFailed, err
+	}
+	if !ok {
+		return Note{}, sourceKey, MappingSkipped, ErrSkipRecord
+	}
+	resolver, err := db.newConflictResolver(notebookName, opts.OnConflict, &ConflictCounts{})
+	if err != nil {
+		return Note{}, "", MappingFailed, err
+	}
+	return db.importNoteExport(notebookName, export, opts, resolver)
+}
+
+/**
+ * Recreates an (already decoded, see decodeNoteExport) note export, see importNote
+ * (resolving its conflict with resolver, if set)
+ */
+func (db *DB) importNoteExport(notebookName string, export NoteExport, opts ImportOptions, resolver *conflictResolver) (Note, string, MappingStatus, error) {
+	sourceKey := NoteRef{Notebook: export.Notebook, Id: export.Note.Id}.String()
+	if err := checkNoteExport(export); err != nil {
+		return Note{}, sourceKey, MappingFailed, err
+	}
+	encoding, err := db.notebookEncoding(notebookName)
+	if err != nil {
+		return Note{}, sourceKey, MappingFailed, err
+	}
+	encodedHistory, err := encodeHistory(export.History, encoding.sealer, db.historySnapshotInterval)
+	if err != nil {
+		return Note{}, sourceKey, MappingFailed, err
+	}
+
+	if export.Note.Clock == nil {
+		// (kept empty rather than counted as created here, the note having been written elsewhere)
+		export.Note.Clock = VectorClock{}
+	}
+	batch := preparedAdd{notebookName: notebookName, notes: []Note{export.Note}, skipDefaults: true}
+	if batch.prepared, err = prepareNotes(batch.notes, NotebookDefaults{}, encoding); err != nil {
+		return Note{}, sourceKey, MappingFailed, err
+	}
+
+	var note Note
+	status := MappingCreated
+	err = db.Update(func(tx *bolt.Tx) error {
+		if opts.DedupeByContent {
+			// (looked up by fingerprint first, which edits made to either copy since don't change)
+			if id, ok := lookupFingerprint(tx, db.notebookKey(notebookName), importFingerprint(export.Note)); ok {
+				_, found, err := db.getNoteInTx(tx, notebookName, id)
+				note, status = found, MappingDuplicate
+				return err
+			}
+			// (compared as it would be stored, content being normalized on write)
+			found, ok, err := db.findNoteByContent(tx, notebookName, batch.prepared[0].note.Content)
+			if err != nil || ok {
+				note, status = found, MappingDuplicate
+				return err
+			}
+		}
+		if resolver != nil {
+			if existingId, ok := resolver.conflicting(export.Note); ok {
+				resolved, resolvedStatus, err := resolver.resolveInTx(tx, existingId, export.Note)
+				if err != nil || resolvedStatus != MappingCreated {
+					note, status = resolved, resolvedStatus
+					return err
+				}
+				// kept both: imported with its title suffixed
+				batch.notes = []Note{resolved}
+				if batch.prepared, err = prepareNotes(batch.notes, NotebookDefaults{}, db.encodingFor(tx, db.notebookKey(notebookName))); err != nil {
+					return err
+				}
+			}
+		}
+
+		added, err := db.commitAdd(tx, batch)
+		if err != nil {
+			return err
+		}
+		note = added[0]
+		for _, attachment := range export.Attachments {
+			if err := putAttachment(tx, db.notebookKey(notebookName), note.Id, attachment, export.Blobs[attachment.Hash]); err != nil {
+				return err
+			}
+		}
+		if len(encodedHistory) == 0 {
+			return nil
+		}
+		if sealer := db.notebookSealer(tx, db.notebookKey(notebookName)); sealer.notebookKeyId != encoding.sealer.notebookKeyId {
+			// (the notebook got encrypted since the history was encoded)
+			if encodedHistory, err = encodeHistory(export.History, sealer, db.historySnapshotInterval); err != nil {
+				return err
+			}
+		}
+		historyBucket, err := createNoteHistoryBucket(tx, db.notebookKey(notebookName), note.Id)
+		if err != nil {
+			return err
+		}
+		for i, encodedRevision := range encodedHistory {
+			if err := historyBucket.Put(itob(uint64(i+1)), encodedRevision); err != nil {
+				return err
+			}
+		}
+		return historyBucket.SetSequence(uint64(len(encodedHistory)))
+	})
+	if err != nil {
+		return note, sourceKey, MappingFailed, err
+	}
+	if resolver != nil && status == MappingCreated {
+		resolver.added(note)
+	}
+	return note, sourceKey, status, nil
+}
+
+/**
+ * Checks that an export is intact: content and attachments match their hashes, and revisions are numbered
+ */
+func checkNoteExport(export NoteExport) error {
+	if export.ContentHash != contentHash(export.Note.Content) {
+		return fmt.Errorf("%w: content doesn't match its hash", ErrInvalidNoteExport)
+	}
+	for i, revision := range export.History {
+		if revision.Revision != uint64(i+1) {
+			return fmt.Errorf("%w: revisions are not numbered 1..%d", ErrInvalidNoteExport, len(export.History))
+		}
+	}
+	for _, attachment := range export.Attachments {
+		content, ok := export.Blobs[attachment.Hash]
+		if !ok || contentHash(string(content)) != attachment.Hash || int64(len(content)) != attachment.Size {
+			return fmt.Errorf("%w: content of attachment '%s' is missing or doesn't match its hash",
+				ErrInvalidNoteExport, attachment.Name)
+		}
+	}
+	return nil
+}
+
+/**
+ * Looks for a note with given content in a notebook (comparing content hashes)
+ */
+func (db *DB) findNoteByContent(tx *bolt.Tx, notebookName string, content string) (Note, bool, error) {
+	var found Note
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(notebookName))
+	if notebookBucket == nil {
+		return found, false, nil
+	}
+	hash := contentHash(content)
+	cursor := notebookBucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var note Note
+		if err := json.Unmarshal(v, &note); err != nil {
+			return found, false, err
+		}
+		if storedContentHash(note) == hash {
+			note, err := db.decodeNote(tx, db.notebookKey(notebookName), k, v)
+			return note, err == nil, err
+		}
+	}
+	return found, false, nil
+}
+
+/**
+ * Maps content hashes of notes of a notebook onto their ids
+ */
+func (db *DB) contentHashes(notebookName string) (map[string]uint64, error) {
+	hashes := make(map[string]uint64)
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(notebookName))
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(_, v []byte) error {
+			var note Note
+			if err := json.Unmarshal(v, &note); err != nil {
+				return err
+			}
+			if _, ok := hashes[storedContentHash(note)]; !ok {
+				hashes[storedContentHash(note)] = note.Id
+			}
+			return nil
+		})
+	})
+	return hashes, err
+}
+
+/**
+ * Hex encoded SHA-256 of note content
+ */
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
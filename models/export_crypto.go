@@ -0,0 +1,272 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/**
+ * Exports can be encrypted with a passphrase (see EncryptExport), so that writing them to disk
+ * doesn't undo encryption at rest
+ *  - the key is derived from the passphrase with argon2id, salted with random bytes per export
+ *  - content is encrypted with AES-256-GCM in chunks of encryptedChunkSize bytes, so that exports
+ *    of any size are streamed (and checked) a chunk at a time
+ *  - the stream starts with a header: magic bytes, format version, argon2id parameters (time,
+ *    memory in KiB, threads) and the salt
+ *  - every chunk is framed by its (ciphertext) length, whose top bit marks the last chunk;
+ *    chunks are numbered by their nonce, and authenticated along with the header and whether
+ *    they're last, so that reordered, truncated or tampered streams are detected
+ * Importers (ImportNote, ImportNotebook) recognize encrypted exports by their header, and decrypt
+ * them with ImportOptions.Passphrase
+ */
+const (
+	encryptedExportMagic   = "NOTESENC"
+	encryptedExportVersion = 1
+	encryptedChunkSize     = 64 << 10
+	// length of the salt, and of the header as a whole
+	encryptedSaltSize   = 16
+	encryptedHeaderSize = len(encryptedExportMagic) + 1 + 4 + 4 + 1 + encryptedSaltSize
+	lastChunkFlag       = 1 << 31
+)
+
+/**
+ * Parameters of argon2id for keys of exports written (as recommended by RFC 9106 for memory-constrained use)
+ */
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 << 10
+	argon2Threads = 4
+	// bounds of parameters accepted from a header, so that a crafted export can't exhaust memory
+	argon2MaxMemory = 1 << 21
+	argon2MaxTime   = 64
+)
+
+var (
+	// returned when importing an encrypted export without a passphrase
+	ErrPassphraseRequired = errors.New("export is encrypted: a passphrase is required")
+	// returned when an encrypted export can't be decrypted with the passphrase given
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+/**
+ * Wraps a writer so that everything written to it is encrypted with given passphrase (see above)
+ * The header is written right away; the returned writer must be closed to write the last chunk
+ * (without it, the export reads as truncated)
+ * param: io.Writer w
+ * param: string    passphrase
+ * return: (io.WriteCloser, error)
+ */
+func EncryptExport(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase can't be empty")
+	}
+	header := make([]byte, encryptedHeaderSize)
+	copy(header, encryptedExportMagic)
+	params := header[len(encryptedExportMagic):]
+	params[0] = encryptedExportVersion
+	binary.BigEndian.PutUint32(params[1:], argon2Time)
+	binary.BigEndian.PutUint32(params[5:], argon2Memory)
+	params[9] = argon2Threads
+	if _, err := io.ReadFull(rand.Reader, params[10:]); err != nil {
+		return nil, err
+	}
+	aead, err := exportCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, header: header}, nil
+}
+
+/**
+ * Wraps a reader of an export written through EncryptExport, decrypting it with given passphrase
+ * The header is read right away; reading fails with ErrWrongPassphrase if the first chunk can't be
+ * decrypted, and with ErrInvalidNoteExport if a later one can't or the stream ends early
+ * param: io.Reader r
+ * param: string    passphrase
+ * return: (io.Reader, error)
+ */
+func DecryptExport(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptedExportMagic)]) != encryptedExportMagic {
+		return nil, fmt.Errorf("%w: not an encrypted export", ErrInvalidNoteExport)
+	}
+	if version := header[len(encryptedExportMagic)]; version != encryptedExportVersion {
+		return nil, &FormatVersionError{Export: "encrypted export", Version: int(version), Supported: encryptedExportVersion}
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	aead, err := exportCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{r: r, aead: aead, header: header}, nil
+}
+
+/**
+ * Reader of an export to import: decrypted with given passphrase if it's encrypted, as it is otherwise
+ */
+func openExport(r io.Reader, passphrase string) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(encryptedExportMagic))
+	if string(magic) != encryptedExportMagic {
+		return buffered, nil
+	}
+	return DecryptExport(buffered, passphrase)
+}
+
+/**
+ * Error of reading an export to import: errors of decryption as they are, others as invalid exports
+ */
+func exportReadError(err error) error {
+	if errors.Is(err, ErrWrongPassphrase) || errors.Is(err, ErrInvalidNoteExport) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidNoteExport, err)
+}
+
+/**
+ * AES-256-GCM with the key derived from a passphrase as per the parameters of a header
+ */
+func exportCipher(passphrase string, header []byte) (cipher.AEAD, error) {
+	params := header[len(encryptedExportMagic):]
+	time, memory, threads := binary.BigEndian.Uint32(params[1:]), binary.BigEndian.Uint32(params[5:]), params[9]
+	if time == 0 || time > argon2MaxTime || memory == 0 || memory > argon2MaxMemory || threads == 0 {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidNoteExport)
+	}
+	key := argon2.IDKey([]byte(passphrase), params[10:], time, memory, threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/**
+ * Nonce and additional data of a chunk: its number, and the header along with whether it's last
+ */
+func chunkNonce(aead cipher.AEAD, number uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], number)
+	return nonce
+}
+
+func chunkAdditionalData(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(append([]byte{}, header...), flag)
+}
+
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buffer  bytes.Buffer
+	written uint64
+	closed  bool
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypted export")
+	}
+	e.buffer.Write(p)
+	// a full chunk is only written once more data follows, as the last chunk has to be marked
+	for e.buffer.Len() > encryptedChunkSize {
+		if err := e.writeChunk(e.buffer.Next(encryptedChunkSize), false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+/**
+ * Writes the last chunk (possibly empty); doesn't close the underlying writer
+ */
+func (e *encryptingWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.writeChunk(e.buffer.Next(e.buffer.Len()), true)
+}
+
+func (e *encryptingWriter) writeChunk(plaintext []byte, last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.written), plaintext, chunkAdditionalData(e.header, last))
+	e.written++
+	frame := uint32(len(sealed))
+	if last {
+		frame |= lastChunkFlag
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], frame)
+	if _, err := e.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptingReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	pending []byte
+	read    uint64
+	done    bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) readChunk() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		return fmt.Errorf("%w: encrypted export is truncated", ErrInvalidNoteExport)
+	}
+	frame := binary.BigEndian.Uint32(length[:])
+	last := frame&lastChunkFlag != 0
+	size := int(frame &^ lastChunkFlag)
+	if size > encryptedChunkSize+d.aead.Overhead() {
+		return fmt.Errorf("%w: encrypted export is corrupt", ErrInvalidNoteExport)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("%w: encrypted export is truncated", ErrInvalidNoteExport)
+	}
+	plaintext, err := d.aead.Open(nil, chunkNonce(d.aead, d.read), sealed, chunkAdditionalData(d.header, last))
+	if err != nil && d.read == 0 {
+		return ErrWrongPassphrase
+	}
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d of encrypted export is corrupt", ErrInvalidNoteExport, d.read)
+	}
+	d.read++
+	d.pending, d.done = plaintext, last
+	return nil
+}
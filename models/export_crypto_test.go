@@ -0,0 +1,150 @@
+package models_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// sizes of the framing of encrypted exports: header, and frame of a full chunk (length, ciphertext, tag)
+const (
+	encryptedHeader = 34
+	encryptedFrame  = 4 + 64<<10 + 16
+)
+
+/**
+ * Encrypted export of a notebook big enough to take several chunks (of 64KiB)
+ */
+func encryptedNotebookExport(t *testing.T, passphrase string) (*models.DB, []byte) {
+	t.Helper()
+	db := notestest.NewDB(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {
+		"Plan\n" + strings.Repeat("step by step ", 8000),
+		"short one",
+		"Log\n" + strings.Repeat("0123456789", 10000),
+	}}, Tags: []string{"secret"}})
+	var export bytes.Buffer
+	if err := db.ExportNotebookEncrypted("work", &export, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	return db, export.Bytes()
+}
+
+func TestEncryptedNotebookExportRoundTrip(t *testing.T) {
+	source, export := encryptedNotebookExport(t, "correct horse")
+	if bytes.Contains(export, []byte("step by step")) || bytes.Contains(export, []byte("secret")) {
+		t.Fatal("encrypted export holds plain content")
+	}
+
+	target := notestest.NewDB(t)
+	report, err := target.ImportNotebook("work", bytes.NewReader(export), models.ImportOptions{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 3 {
+		t.Errorf("%d notes imported, want 3", report.Imported)
+	}
+	want, _ := source.ListNotes("work")
+	got, err := target.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d notes imported, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Content != want[i].Content || strings.Join(got[i].Tags, ",") != "secret" {
+			t.Errorf("note %d imported as %.40q %v", i, got[i].Content, got[i].Tags)
+		}
+	}
+}
+
+func TestEncryptedExportNeedsTheRightPassphrase(t *testing.T) {
+	_, export := encryptedNotebookExport(t, "correct horse")
+	for _, test := range []struct {
+		passphrase string
+		err        error
+	}{
+		{"", models.ErrPassphraseRequired},
+		{"battery staple", models.ErrWrongPassphrase},
+	} {
+		target := notestest.NewDB(t)
+		_, err := target.ImportNotebook("work", bytes.NewReader(export), models.ImportOptions{Passphrase: test.passphrase})
+		if !errors.Is(err, test.err) {
+			t.Errorf("importing with passphrase %q: %v, want %v", test.passphrase, err, test.err)
+		}
+		// (failing on the first chunk, before anything is imported)
+		if notes, _ := target.ListNotes("work"); len(notes) != 0 {
+			t.Errorf("importing with passphrase %q: %d notes imported", test.passphrase, len(notes))
+		}
+	}
+}
+
+func TestTruncatedEncryptedExport(t *testing.T) {
+	_, export := encryptedNotebookExport(t, "correct horse")
+	for name, size := range map[string]int{
+		"within the header":      10,
+		"within the first chunk": 200,
+		"after the first chunk":  encryptedHeader + encryptedFrame,
+		"without the last chunk": encryptedHeader + (len(export)-encryptedHeader)/encryptedFrame*encryptedFrame,
+		"within the last chunk":  len(export) - 20,
+		"missing the last byte":  len(export) - 1,
+	} {
+		target := notestest.NewDB(t)
+		_, err := target.ImportNotebook("work", bytes.NewReader(export[:size]), models.ImportOptions{Passphrase: "correct horse"})
+		if !errors.Is(err, models.ErrInvalidNoteExport) {
+			t.Errorf("%s: %v, want ErrInvalidNoteExport", name, err)
+		} else if size > encryptedHeader && !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("%s: %v, want the export reported as truncated", name, err)
+		}
+	}
+}
+
+func TestTamperedEncryptedExport(t *testing.T) {
+	_, export := encryptedNotebookExport(t, "correct horse")
+	tampered := append([]byte{}, export...)
+	// a byte of the second chunk: the first one decrypts, so the passphrase is right
+	tampered[encryptedHeader+encryptedFrame+10] ^= 1
+	_, err := notestest.NewDB(t).ImportNotebook("work", bytes.NewReader(tampered), models.ImportOptions{Passphrase: "correct horse"})
+	if !errors.Is(err, models.ErrInvalidNoteExport) || errors.Is(err, models.ErrWrongPassphrase) {
+		t.Errorf("importing a tampered export: %v, want ErrInvalidNoteExport", err)
+	}
+}
+
+func TestEncryptExportStreams(t *testing.T) {
+	for _, size := range []int{0, 1, 64 << 10, 64<<10 + 1, 3 * 64 << 10} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+		var encrypted bytes.Buffer
+		w, err := models.EncryptExport(&encrypted, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// written in odd pieces, read back whole
+		for rest := plain; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := models.DecryptExport(&encrypted, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(decrypted, plain) {
+			t.Errorf("%d bytes decrypted as %d (%v)", size, len(decrypted), err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/**
+ * Exports carry the version of their format ('format' field of a NoteExport, and of the manifest
+ * of a notebook export), so that importers can tell what they are reading:
+ *  - versions newer than this version of notes knows are refused with a *FormatVersionError
+ *    (rather than silently dropping what they added)
+ *  - older versions are decoded as they were written, and upgraded to the current one
+ *  - fields the version doesn't define are ignored, and reported as warnings
+ *
+ * Versions of NoteExport:
+ *  1 - initial format
+ *  2 - notes carry whether they are locked read-only ('read_only')
+ *  3 - notes carry their kind ('kind')
+ *  4 - notes carry their position when arranged by hand ('position')
+ *  5 - exports carry relations going from the note ('relations')
+ *  6 - notes carry their fingerprint ('fingerprint')
+ *  7 - notes carry their clock ('clock')
+ *  8 - notes carry the page they were clipped from ('source_url')
+ */
+
+/**
+ * Returned (wrapped in ErrInvalidNoteExport) when an export is in a format version this version
+ * of notes can't read
+ */
+type FormatVersionError struct {
+	// kind of export ("note export" or "notebook export")
+	Export    string
+	Version   int
+	Supported int
+}
+
+func (e *FormatVersionError) Error() string {
+	if e.Version > e.Supported {
+		return fmt.Sprintf("%s format version %d is newer than this version of notes supports (up to %d); upgrade notes to import it",
+			e.Export, e.Version, e.Supported)
+	}
+	return fmt.Sprintf("unknown %s format version %d", e.Export, e.Version)
+}
+
+func (e *FormatVersionError) Unwrap() error {
+	return ErrInvalidNoteExport
+}
+
+/**
+ * Fields of NoteExport (as paths like 'note.read_only') added after the first version, by the version adding them
+ */
+var noteExportFieldVersions = map[string]int{
+	"note.read_only":   2,
+	"note.kind":        3,
+	"note.position":    4,
+	"relations":        5,
+	"note.fingerprint": 6,
+	"note.clock":       7,
+	"note.source_url":  8,
+}
+
+/**
+ * Upgrades of exports decoded in an older format version to the current one, by version
+ */
+var noteExportUpgrades = map[int]func(*NoteExport){
+	// read-only locks didn't exist: notes of version 1 exports are never locked
+	1: func(export *NoteExport) {
+		export.Note.ReadOnly = false
+	},
+	// kinds didn't exist: notes of version 2 exports (and older) are plain text
+	2: func(export *NoteExport) {
+		export.Note.Kind = KindText
+	},
+	// notes couldn't be arranged by hand before version 4
+	3: func(export *NoteExport) {
+		export.Note.Position = 0
+	},
+}
+
+/**
+ * Decodes a note export written by ExportNote (in any format version up to the current one)
+ * return: (NoteExport, []string, error) The export, upgraded to the current version, and warnings
+ *         about fields of the input the version doesn't define
+ */
+func decodeNoteExport(data []byte) (NoteExport, []string, error) {
+	var export NoteExport
+	version, err := exportFormatVersion(data, "note export", NoteExportFormat)
+	if err != nil {
+		return export, nil, err
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return export, nil, fmt.Errorf("%w: %v", ErrInvalidNoteExport, err)
+	}
+	for v := version; v < NoteExportFormat; v++ {
+		if upgrade, ok := noteExportUpgrades[v]; ok {
+			upgrade(&export)
+		}
+	}
+	warnings := unknownFields(data, reflect.TypeOf(export), "", func(path string) bool {
+		return noteExportFieldVersions[path] <= version
+	})
+	return export, formatWarnings(warnings, "note export", version), nil
+}
+
+/**
+ * Decodes the manifest of a notebook export written by ExportNotebook
+ * return: (NotebookExportManifest, []string, error) The manifest and warnings about fields it doesn't define
+ */
+func decodeNotebookExportManifest(data []byte) (NotebookExportManifest, []string, error) {
+	var manifest NotebookExportManifest
+	version, err := exportFormatVersion(data, "notebook export", NotebookExportFormat)
+	if err != nil {
+		return manifest, nil, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("%w: %v", ErrInvalidNoteExport, err)
+	}
+	warnings := unknownFields(data, reflect.TypeOf(manifest), "", func(string) bool { return true })
+	return manifest, formatWarnings(warnings, "notebook export", version), nil
+}
+
+/**
+ * Reads the format version of an export, failing with *FormatVersionError if it isn't one up to supported
+ */
+func exportFormatVersion(data []byte, export string, supported int) (int, error) {
+	var header struct {
+		Format int `json:"format"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidNoteExport, err)
+	}
+	if header.Format < 1 || header.Format > supported {
+		return header.Format, &FormatVersionError{Export: export, Version: header.Format, Supported: supported}
+	}
+	return header.Format, nil
+}
+
+func formatWarnings(fields []string, export string, version int) []string {
+	var warnings []string
+	for _, field := range fields {
+		warnings = append(warnings, fmt.Sprintf("field '%s' isn't part of %s format version %d, ignored", field, export, version))
+	}
+	return warnings
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+/**
+ * Paths of the fields of a JSON document that given type (with fields deemed known by known) doesn't define
+ * Fields of objects are followed into nested structs and slices of structs; types decoding
+ * themselves (like time.Time) and maps are taken as they are, but for structs whose exported
+ * fields are still what's decoded (like Note, which only defaults its kind)
+ */
+func unknownFields(data []byte, t reflect.Type, prefix string, known func(path string) bool) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	decodesItself := reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)
+	if decodesItself && !hasExportedFields(t) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		var elements []json.RawMessage
+		if json.Unmarshal(data, &elements) != nil {
+			return nil
+		}
+		var unknown []string
+		for _, element := range elements {
+			for _, path := range unknownFields(element, t.Elem(), prefix, known) {
+				if !containsString(unknown, path) {
+					unknown = append(unknown, path)
+				}
+			}
+		}
+		return unknown
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return nil
+		}
+		var unknown []string
+		for key, value := range object {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			field, ok := jsonField(t, key)
+			if !ok || !known(path) {
+				unknown = append(unknown, path)
+				continue
+			}
+			unknown = append(unknown, unknownFields(value, field.Type, path, known)...)
+		}
+		sort.Strings(unknown)
+		return unknown
+	}
+	return nil
+}
+
+func hasExportedFields(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Field of a struct a JSON key decodes into (matching names case-insensitively, as encoding/json does)
+ */
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,152 @@
+package models_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Imports a note export fixture of testdata, returning the note and the warnings about it
+ */
+func importFixture(t *testing.T, db *models.DB, name string) (models.Note, []string, error) {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var warnings []string
+	note, err := db.ImportNote("imported", file, models.ImportOptions{OnWarning: func(warning string) {
+		warnings = append(warnings, warning)
+	}})
+	return note, warnings, err
+}
+
+func TestImportNoteExportFormats(t *testing.T) {
+	for _, test := range []struct {
+		fixture  string
+		check    func(t *testing.T, note models.Note)
+		warnings []string
+	}{
+		{"note-export-v1.json", func(t *testing.T, note models.Note) {
+			// read-only locks and kinds didn't exist: the fields are ignored
+			if note.ReadOnly || note.Kind != models.KindText {
+				t.Errorf("read_only = %v, kind = %s; want an unlocked text note", note.ReadOnly, note.Kind)
+			}
+			if note.Content != "Shopping\n- milk\n- bread" || !reflect.DeepEqual(note.Tags, []string{"errands"}) {
+				t.Errorf("note = %+v", note)
+			}
+			if !note.CreatedAt.Equal(time.Date(2021, time.March, 1, 9, 0, 0, 0, time.UTC)) ||
+				!note.UpdatedAt.Equal(time.Date(2021, time.March, 2, 9, 30, 0, 0, time.UTC)) {
+				t.Errorf("created_at = %v, updated_at = %v", note.CreatedAt, note.UpdatedAt)
+			}
+		}, []string{
+			"field 'note.kind' isn't part of note export format version 1, ignored",
+			"field 'note.read_only' isn't part of note export format version 1, ignored",
+		}},
+		{"note-export-v3.json", func(t *testing.T, note models.Note) {
+			if !note.ReadOnly || note.Kind != models.KindMarkdown || note.Position != 0 {
+				t.Errorf("read_only = %v, kind = %s, position = %v; want a read-only markdown note never moved",
+					note.ReadOnly, note.Kind, note.Position)
+			}
+		}, []string{"field 'note.position' isn't part of note export format version 3, ignored"}},
+		{"note-export-v8.json", func(t *testing.T, note models.Note) {
+			if note.Kind != models.KindMarkdown || note.Position != 1.5 || note.TitleText != "Clipped article" ||
+				note.SourceURL != "https://example.com/article" || note.Fingerprint != "3f1c2a" || note.Language != "en" {
+				t.Errorf("note = %+v", note)
+			}
+			if note.ExpiresAt == nil || !note.ExpiresAt.Equal(time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("expires_at = %v", note.ExpiresAt)
+			}
+		}, nil},
+	} {
+		t.Run(test.fixture, func(t *testing.T) {
+			db := notestest.NewDB(t)
+			note, warnings, err := importFixture(t, db, test.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, note)
+			if !reflect.DeepEqual(warnings, test.warnings) {
+				t.Errorf("warnings = %q, want %q", warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestImportNoteExportFormatHistoryAndAttachments(t *testing.T) {
+	db := notestest.NewDB(t)
+	note, _, err := importFixture(t, db, "note-export-v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := db.GetNoteHistory("imported", note.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Content != "Shopping\n- milk" {
+		t.Errorf("history of the version 1 export = %+v", history)
+	}
+
+	note, _, err = importFixture(t, db, "note-export-v8.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := db.ListAttachments("imported", note.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Name != "article.pdf" || attachments[0].Size != int64(len("%PDF-1.4 article")) {
+		t.Errorf("attachments of the version 8 export = %+v", attachments)
+	}
+}
+
+func TestImportNewerNoteExportFormat(t *testing.T) {
+	db := notestest.NewDB(t)
+	_, _, err := importFixture(t, db, "note-export-v9.json")
+	var versionErr *models.FormatVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 9 || versionErr.Supported != models.NoteExportFormat {
+		t.Fatalf("importing a version 9 export: %v, want a *FormatVersionError for version 9", err)
+	}
+	if !errors.Is(err, models.ErrInvalidNoteExport) || !strings.Contains(err.Error(), "version 9") {
+		t.Errorf("error %q should be an ErrInvalidNoteExport naming the version", err)
+	}
+	if notes, _ := db.ListNotes("imported"); len(notes) != 0 {
+		t.Errorf("%d notes imported from a refused export", len(notes))
+	}
+}
+
+func TestImportNotebookExportFormats(t *testing.T) {
+	db := notestest.NewDB(t)
+	file, err := os.Open(filepath.Join("testdata", "notebook-export-v1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	report, err := db.ImportNotebook("mixed", file, models.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 {
+		t.Errorf("%d notes imported, want 2", report.Imported)
+	}
+	// (notes of the export are of versions 2 and 8, and have no field their version doesn't define)
+	if want := []string{"field 'compression' isn't part of notebook export format version 1, ignored"}; !reflect.DeepEqual(report.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", report.Warnings, want)
+	}
+	notes, err := db.ListNotes("mixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Kind != models.KindText || notes[1].Kind != models.KindMarkdown || notes[1].SourceURL != "https://example.com" {
+		t.Errorf("notes = %+v, want a text note of version 2 and a markdown one of version 8", notes)
+	}
+}
@@ -0,0 +1,221 @@
+package models_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Adds a note using every field a note export carries: tags, expiry, kind, title, source, a revision
+ * history and attachments
+ */
+func richNote(t *testing.T, db *models.DB) models.Note {
+	t.Helper()
+	expiresAt := time.Date(2030, time.June, 1, 12, 0, 0, 0, time.UTC)
+	note := notestest.MustAddNote(t, db, "work", models.Note{
+		TitleText: "Launch plan",
+		Content:   "# Launch\n- [ ] draft",
+		Tags:      []string{"launch", "q3"},
+		ExpiresAt: &expiresAt,
+		Kind:      models.KindMarkdown,
+		SourceURL: "https://example.com/launch",
+		CreatedAt: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC),
+	})
+	var err error
+	for _, content := range []string{"# Launch\n- [x] draft", "# Launch\n- [x] draft\n- [ ] review"} {
+		if note, err = db.UpdateNote("work", note.Id, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{"plan.pdf": "%PDF-1.4 plan", "logo.png": "\x89PNG logo"} {
+		if _, err := db.AddAttachment("work", note.Id, name, bytes.NewReader([]byte(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if note, err = db.GetNote("work", note.Id); err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+func TestNoteExportRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts models.NoteExportOptions
+	}{
+		{"plain", models.NoteExportOptions{IncludeHistory: true}},
+		{"indented", models.NoteExportOptions{IncludeHistory: true, Indent: true}},
+		{"encrypted", models.NoteExportOptions{IncludeHistory: true, Passphrase: "correct horse"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			source := notestest.NewDB(t)
+			original := richNote(t, source)
+			var export bytes.Buffer
+			if err := source.ExportNote("work", original.Id, &export, test.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			target := notestest.NewDB(t)
+			notestest.MustAdd(t, target, "inbox", "taking id 1, so that the import gets another id")
+			imported, err := target.ImportNote("inbox", &export, models.ImportOptions{Passphrase: test.opts.Passphrase})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNoteFields(t, original, imported)
+			assertHistoryEqual(t, source, "work", original.Id, target, "inbox", imported.Id)
+			assertAttachmentsEqual(t, source, "work", original.Id, target, "inbox", imported.Id)
+		})
+	}
+}
+
+func TestNoteExportWithoutHistory(t *testing.T) {
+	source := notestest.NewDB(t)
+	original := richNote(t, source)
+	var export bytes.Buffer
+	if err := source.ExportNote("work", original.Id, &export, models.NoteExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := notestest.NewDB(t)
+	imported, err := target.ImportNote("work", &export, models.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoteFields(t, original, imported)
+	if history, err := target.GetNoteHistory("work", imported.Id); err != nil || len(history) != 0 {
+		t.Errorf("history of a note exported without it = %d revisions, %v", len(history), err)
+	}
+	assertAttachmentsEqual(t, source, "work", original.Id, target, "work", imported.Id)
+}
+
+func TestImportNoteTwice(t *testing.T) {
+	source := notestest.NewDB(t)
+	original := notestest.MustAdd(t, source, "work", "imported twice")
+	var export bytes.Buffer
+	if err := source.ExportNote("work", original.Id, &export, models.NoteExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		opts  models.ImportOptions
+		notes int
+	}{
+		{"creating two notes", models.ImportOptions{}, 2},
+		{"deduplicating by content", models.ImportOptions{DedupeByContent: true}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target := notestest.NewDB(t)
+			first, err := target.ImportNote("work", bytes.NewReader(export.Bytes()), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := target.ImportNote("work", bytes.NewReader(export.Bytes()), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notes, err := target.ListNotes("work")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(notes) != test.notes {
+				t.Errorf("notebook has %d notes, want %d", len(notes), test.notes)
+			}
+			if sameNote := first.Id == second.Id; sameNote != (test.notes == 1) {
+				t.Errorf("imports got ids %d and %d", first.Id, second.Id)
+			}
+		})
+	}
+}
+
+/**
+ * Compares every field a note export carries (ids, revisions and clocks being the importing DB's own)
+ */
+func assertNoteFields(t *testing.T, want, got models.Note) {
+	t.Helper()
+	fields := []struct {
+		name      string
+		want, got interface{}
+	}{
+		{"content", want.Content, got.Content},
+		{"tags", want.Tags, got.Tags},
+		{"title", want.TitleText, got.TitleText},
+		{"kind", want.Kind, got.Kind},
+		{"source_url", want.SourceURL, got.SourceURL},
+		{"language", want.Language, got.Language},
+		{"fingerprint", want.Fingerprint, got.Fingerprint},
+		{"created_at", want.CreatedAt.UTC(), got.CreatedAt.UTC()},
+		{"updated_at", want.UpdatedAt.UTC(), got.UpdatedAt.UTC()},
+	}
+	for _, field := range fields {
+		if !reflect.DeepEqual(field.want, field.got) {
+			t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+	if want.ExpiresAt == nil || got.ExpiresAt == nil || !want.ExpiresAt.Equal(*got.ExpiresAt) {
+		t.Errorf("expires_at = %v, want %v", got.ExpiresAt, want.ExpiresAt)
+	}
+}
+
+func assertHistoryEqual(t *testing.T, source *models.DB, sourceNotebook string, sourceId uint64,
+	target *models.DB, targetNotebook string, targetId uint64) {
+	t.Helper()
+	want, err := source.GetNoteHistory(sourceNotebook, sourceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := target.GetNoteHistory(targetNotebook, targetId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 || len(got) != len(want) {
+		t.Fatalf("history has %d revisions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Revision != want[i].Revision || got[i].Content != want[i].Content || !got[i].SavedAt.Equal(want[i].SavedAt) {
+			t.Errorf("revision %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func assertAttachmentsEqual(t *testing.T, source *models.DB, sourceNotebook string, sourceId uint64,
+	target *models.DB, targetNotebook string, targetId uint64) {
+	t.Helper()
+	want, err := source.ListAttachments(sourceNotebook, sourceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := target.ListAttachments(targetNotebook, targetId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 || len(got) != len(want) {
+		t.Fatalf("%d attachments, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].Size != want[i].Size || got[i].Hash != want[i].Hash {
+			t.Errorf("attachment %d = %+v, want %+v", i, got[i], want[i])
+			continue
+		}
+		reader, _, err := target.GetAttachment(targetNotebook, targetId, got[i].Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != want[i].Hash {
+			t.Errorf("content of attachment '%s' doesn't match its hash", got[i].Name)
+		}
+	}
+}
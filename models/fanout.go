@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Reads spanning all notebooks (SearchAllNotebooks, Takeout) fan out over a pool of workers
+ *  - bolt runs any number of read transactions at once: every worker reads in a transaction of its own,
+ *    taking notebooks off a channel until there are none left
+ *  - results are kept by notebook and merged in notebook order once workers are done, so that output
+ *    doesn't depend on which worker read what
+ *  - notebooks read by different workers may be read at different points in time: every notebook is
+ *    consistent in itself, but writes committed meanwhile may show in some notebooks and not others
+ *  - a transaction is never held while waiting on another one to begin, as bolt deadlocks when a writer
+ *    growing the file waits for the first to end
+ *  - the first error (or cancelling the context) stops all workers; callbacks of the reads (like
+ *    Render of takeouts, or the one of SetReadPolicy) may run on several goroutines at once
+ */
+
+/**
+ * Most workers reads fan out over unless set otherwise (see SetReadWorkers)
+ */
+const maxReadWorkers = 8
+
+/**
+ * Sets how many workers reads spanning all notebooks fan out over (see above)
+ * Zero falls back to GOMAXPROCS, at most maxReadWorkers; 1 reads notebooks one after the other
+ */
+func (db *DB) SetReadWorkers(workers int) {
+	db.readWorkers = workers
+}
+
+func (db *DB) readWorkerCount() int {
+	if db.readWorkers > 0 {
+		return db.readWorkers
+	}
+	if workers := runtime.GOMAXPROCS(0); workers < maxReadWorkers {
+		return workers
+	}
+	return maxReadWorkers
+}
+
+/**
+ * Calls fn for every notebook of given ones (i being its index), by up to readWorkerCount() workers each
+ * reading in a read transaction of its own
+ * Fails with the first error fn returns, or with ctx's error once it's cancelled
+ */
+func (db *DB) fanOutNotebooks(ctx context.Context, notebookNames []string, fn func(tx *bolt.Tx, i int, notebookName string) error) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.exit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+
+	workers := db.readWorkerCount()
+	if workers > len(notebookNames) {
+		workers = len(notebookNames)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// (the transaction is begun here, not by the dispatcher, so that it never waits on one)
+			start := time.Now()
+			tx, err := db.DB.Begin(false)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer tx.Rollback()
+			defer db.traceTx(tx, false, start)()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := fn(tx, i, notebookNames[i]); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range notebookNames {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// words notes of fanOutDB are made of, so that every search matches notes of every notebook
+var fanOutWords = []string{"alpha", "bravo", "charlie", "delta", "echo"}
+
+/**
+ * DB of given number of notebooks of given number of notes each, one of them archived
+ */
+func fanOutDB(t testing.TB, notebooks int, notes int) *DB {
+	t.Helper()
+	db, cleanup, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	for n := 0; n < notebooks; n++ {
+		contents := make([]string, notes)
+		for i := range contents {
+			contents[i] = fmt.Sprintf("note %d of notebook %d\n%s %s", i, n, fanOutWords[i%len(fanOutWords)], fanOutWords[(i+n)%len(fanOutWords)])
+		}
+		if err := db.AddNotes("nb"+strconv.Itoa(n), contents...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.ArchiveNotebook("nb0"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+/**
+ * Results of a search of all notebooks in a single transaction, as before searches fanned out
+ */
+func searchAllInOneTx(t *testing.T, db *DB, query string, opts ...SearchOption) []SearchResult {
+	t.Helper()
+	var results []SearchResult
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		results, err = db.searchAllNotebooksInTx(tx, query, newSearchOptions(opts))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestFanOutSearchMatchesSingleTransaction(t *testing.T) {
+	db := fanOutDB(t, 12, 30)
+	for _, workers := range []int{1, 3, 8, 20} {
+		db.SetReadWorkers(workers)
+		for _, query := range []string{"alpha", "delta echo", "notebook 7", "nowhere"} {
+			for _, opts := range [][]SearchOption{nil, {IncludeArchivedNotebooks()}} {
+				results, err := db.SearchAllNotebooks(query, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := searchAllInOneTx(t, db, query, opts...); !reflect.DeepEqual(results, want) {
+					t.Errorf("search of %q by %d workers found %d results, unlike one transaction (%d)", query, workers, len(results), len(want))
+				}
+			}
+		}
+	}
+}
+
+func TestFanOutSearchWhileWriting(t *testing.T) {
+	db := fanOutDB(t, 6, 20)
+	db.SetReadWorkers(4)
+	// writers add notes (growing the file) while searches run, which must neither deadlock nor race
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := db.AddNote("nb"+strconv.Itoa(1+w), Note{Content: "alpha written meanwhile " + strconv.Itoa(i)}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	for s := 0; s < 4; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				results, err := db.SearchAllNotebooks("alpha")
+				if err != nil {
+					errs <- err
+					return
+				}
+				// (notebooks may be read before or after a write, never midway)
+				if len(results) < 5*4 {
+					errs <- fmt.Errorf("%d results while writing", len(results))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	results, err := db.SearchAllNotebooks("meanwhile")
+	if err != nil || len(results) != 100 {
+		t.Errorf("%d notes written meanwhile found (%v), want 100", len(results), err)
+	}
+}
+
+func TestFanOutStopsOnFirstError(t *testing.T) {
+	db := fanOutDB(t, 20, 1)
+	db.SetReadWorkers(4)
+	failure := errors.New("unreadable")
+	var mu sync.Mutex
+	read := 0
+	err := db.fanOutNotebooks(context.Background(), notebookNamesOf(t, db), func(_ *bolt.Tx, i int, _ string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		read++
+		if i == 2 {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("fanning out with a failing notebook: %v, want its error", err)
+	}
+	// (workers may be holding a notebook each when the first fails, but no more are handed out)
+	if read >= 20 {
+		t.Errorf("%d notebooks read after the first error", read)
+	}
+}
+
+func TestFanOutCancelled(t *testing.T) {
+	db := fanOutDB(t, 8, 10)
+	db.SetReadWorkers(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results, err := db.SearchAllNotebooksContext(ctx, "alpha"); !errors.Is(err, context.Canceled) || results != nil {
+		t.Errorf("cancelled search: %d results (%v), want context.Canceled", len(results), err)
+	}
+
+	// a takeout cancelled midway leaves no manifest, even where a previous takeout left one
+	dir := t.TempDir()
+	if _, err := db.Takeout(dir, TakeoutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	var mu sync.Mutex
+	rendered := 0
+	// (Render runs on several workers at once)
+	render := func(record NoteExport) (NoteExport, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if rendered++; rendered == 25 {
+			cancel()
+		}
+		return record, nil
+	}
+	if _, err := db.TakeoutContext(ctx, dir, TakeoutOptions{Render: render}); !errors.Is(err, context.Canceled) {
+		t.Errorf("takeout cancelled midway: %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, takeoutManifestFile)); !os.IsNotExist(err) {
+		t.Errorf("takeout cancelled midway left a manifest (%v)", err)
+	}
+}
+
+func TestFanOutTakeoutSameForAnyWorkers(t *testing.T) {
+	db := fanOutDB(t, 10, 15)
+	var files [][]TakeoutFile
+	for _, workers := range []int{1, 8} {
+		db.SetReadWorkers(workers)
+		report, err := db.Takeout(t.TempDir(), TakeoutOptions{Format: "markdown"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, report.Manifest.Files)
+	}
+	if len(files[0]) == 0 || !reflect.DeepEqual(files[0], files[1]) {
+		t.Errorf("takeout by 8 workers wrote %+v, unlike one worker: %+v", files[1], files[0])
+	}
+}
+
+func notebookNamesOf(t testing.TB, db *DB) []string {
+	t.Helper()
+	var notebookNames []string
+	if err := db.View(func(tx *bolt.Tx) error {
+		notebookNames = notebookNamesInTx(tx, true)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return notebookNames
+}
+
+// notebooks and notes of each searched by the benchmarks below
+const benchmarkFanOutNotebooks, benchmarkFanOutNotes = 16, 1000
+
+func benchmarkSearchAll(b *testing.B, workers int) {
+	db := fanOutDB(b, benchmarkFanOutNotebooks, benchmarkFanOutNotes)
+	db.SetReadWorkers(workers)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := db.SearchAllNotebooks("charlie", IncludeArchivedNotebooks())
+		if err != nil || len(results) == 0 {
+			b.Fatalf("%d results (%v)", len(results), err)
+		}
+	}
+}
+
+func BenchmarkSearchAllNotebooksSequential(b *testing.B) {
+	benchmarkSearchAll(b, 1)
+}
+
+func BenchmarkSearchAllNotebooksFanOut(b *testing.B) {
+	benchmarkSearchAll(b, 0)
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Favorites are a short list of notes of any notebooks, kept in the order they're arranged in
+ *  - 'Favorites' bucket: sequence number (8 bytes) -> key of the note: notebook key, NUL, note id
+ *    keys give the order: a favorite added goes last, and reordering (see MoveFavorite) rewrites
+ *    the whole list, which is meant to stay small
+ *  - deleting a note removes it from favorites, and moving it to another notebook (see filing rules)
+ *    keeps its place; favorites whose note is gone anyway (like one whose record was quarantined as
+ *    corrupt) are left out by ListFavorites, and removed by PruneFavorites
+ */
+
+// returned by RemoveFavorite and MoveFavorite for notes that aren't favorites
+var ErrFavoriteNotFound = errors.New("note is not a favorite")
+
+/**
+ * Adds a note to the end of favorites; adding a favorite again does nothing
+ * Fails with ErrNoteNotFound if the note doesn't exist
+ * param: NoteRef ref
+ * return: error
+ */
+func (db *DB) AddFavorite(ref NoteRef) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, _, err := db.getNoteInTx(tx, ref.Notebook, ref.Id); err != nil {
+			return err
+		}
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		key := favoriteKey(db.notebookKey(ref.Notebook), ref.Id)
+		if indexOfFavorite(favorites, key) >= 0 {
+			return nil
+		}
+		return writeFavorites(tx, append(favorites, key))
+	})
+}
+
+/**
+ * Removes a note from favorites (whether or not the note still exists)
+ * Fails with ErrFavoriteNotFound if it isn't a favorite
+ * param: NoteRef ref
+ * return: error
+ */
+func (db *DB) RemoveFavorite(ref NoteRef) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		i := indexOfFavorite(favorites, favoriteKey(db.notebookKey(ref.Notebook), ref.Id))
+		if i < 0 {
+			return fmt.Errorf("%w: %s", ErrFavoriteNotFound, ref)
+		}
+		return writeFavorites(tx, append(favorites[:i], favorites[i+1:]...))
+	})
+}
+
+/**
+ * Moves a favorite right before another one, or to the end if before is the zero NoteRef
+ * Fails with ErrFavoriteNotFound if either note isn't a favorite
+ * param: NoteRef ref
+ * param: NoteRef before
+ * return: error
+ */
+func (db *DB) MoveFavorite(ref NoteRef, before NoteRef) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		key, beforeKey := favoriteKey(db.notebookKey(ref.Notebook), ref.Id), favoriteKey(db.notebookKey(before.Notebook), before.Id)
+		i := indexOfFavorite(favorites, key)
+		if i < 0 {
+			return fmt.Errorf("%w: %s", ErrFavoriteNotFound, ref)
+		}
+		if bytes.Equal(key, beforeKey) {
+			return nil
+		}
+		favorites = append(favorites[:i], favorites[i+1:]...)
+		if before == (NoteRef{}) {
+			return writeFavorites(tx, append(favorites, key))
+		}
+		j := indexOfFavorite(favorites, beforeKey)
+		if j < 0 {
+			return fmt.Errorf("%w: %s", ErrFavoriteNotFound, before)
+		}
+		arranged := append(append(append([][]byte{}, favorites[:j]...), key), favorites[j:]...)
+		return writeFavorites(tx, arranged)
+	})
+}
+
+/**
+ * Retrieves favorite notes (with refs, as they span notebooks), in their order; favorites whose note
+ * is gone are left out
+ * Corrupt records are treated as per the read policy (see SetReadPolicy)
+ * return: ([]SearchResult, error)
+ */
+func (db *DB) ListFavorites() ([]SearchResult, error) {
+	results := []SearchResult{}
+	err := db.View(func(tx *bolt.Tx) error {
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		for _, key := range favorites {
+			notebookKey, noteId := splitFavoriteKey(key)
+			notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+			if notebookBucket == nil {
+				continue
+			}
+			noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+			encodedNote := notebookBucket.Get(noteIdBytes)
+			if encodedNote == nil {
+				continue
+			}
+			note, err := db.decodeNote(tx, notebookKey, noteIdBytes, encodedNote)
+			if db.skipCorrupt(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			ref := NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: noteId}
+			results = append(results, SearchResult{Ref: ref, Note: note})
+		}
+		return nil
+	})
+	return results, err
+}
+
+/**
+ * Removes favorites whose note is gone
+ * return: ([]NoteRef, error) Refs of the favorites removed
+ */
+func (db *DB) PruneFavorites() ([]NoteRef, error) {
+	var pruned []NoteRef
+	err := db.Update(func(tx *bolt.Tx) error {
+		pruned = nil
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		var kept [][]byte
+		for _, key := range favorites {
+			notebookKey, noteId := splitFavoriteKey(key)
+			notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+			if notebookBucket == nil || notebookBucket.Get([]byte(strconv.FormatUint(noteId, 10))) == nil {
+				pruned = append(pruned, NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: noteId})
+				continue
+			}
+			kept = append(kept, key)
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return writeFavorites(tx, kept)
+	})
+	return pruned, err
+}
+
+func favoriteKey(notebookKey []byte, noteId uint64) []byte {
+	return []byte(string(notebookKey) + "\x00" + strconv.FormatUint(noteId, 10))
+}
+
+func splitFavoriteKey(key []byte) ([]byte, uint64) {
+	separator := bytes.LastIndexByte(key, 0)
+	if separator < 0 {
+		return key, 0
+	}
+	noteId, _ := strconv.ParseUint(string(key[separator+1:]), 10, 64)
+	return key[:separator], noteId
+}
+
+func indexOfFavorite(favorites [][]byte, key []byte) int {
+	for i, favorite := range favorites {
+		if bytes.Equal(favorite, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+ * Reads the keys of favorite notes, in their order
+ */
+func readFavorites(tx *bolt.Tx) ([][]byte, error) {
+	var favorites [][]byte
+	bucket := tx.Bucket([]byte("Favorites"))
+	if bucket == nil {
+		return nil, nil
+	}
+	err := bucket.ForEach(func(_, key []byte) error {
+		favorites = append(favorites, append([]byte(nil), key...))
+		return nil
+	})
+	return favorites, err
+}
+
+/**
+ * Replaces the list of favorites with given keys of notes, in order
+ */
+func writeFavorites(tx *bolt.Tx, favorites [][]byte) error {
+	if tx.Bucket([]byte("Favorites")) != nil {
+		if err := tx.DeleteBucket([]byte("Favorites")); err != nil {
+			return err
+		}
+	}
+	if len(favorites) == 0 {
+		return nil
+	}
+	bucket, err := tx.CreateBucket([]byte("Favorites"))
+	if err != nil {
+		return err
+	}
+	for i, key := range favorites {
+		if err := bucket.Put(itob(uint64(i+1)), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Points the favorite entry of a note (if it's a favorite) at another note, keeping its place
+ * (see moveToNotebookInTx); with targetKey nil, removes it instead (see deleteNoteData)
+ */
+func moveFavorite(tx *bolt.Tx, sourceKey []byte, sourceId uint64, targetKey []byte, targetId uint64) error {
+	bucket := tx.Bucket([]byte("Favorites"))
+	if bucket == nil {
+		return nil
+	}
+	source := favoriteKey(sourceKey, sourceId)
+	cursor := bucket.Cursor()
+	for k, key := cursor.First(); k != nil; k, key = cursor.Next() {
+		if !bytes.Equal(key, source) {
+			continue
+		}
+		if targetKey == nil {
+			return bucket.Delete(k)
+		}
+		return bucket.Put(k, favoriteKey(targetKey, targetId))
+	}
+	return nil
+}
+
+/**
+ * Changes the notebook keys favorites point at, after notebooks got new bucket keys
+ * (see SetCaseInsensitiveNotebooks)
+ * param: map[string]string moved New key by previous key
+ */
+func remapFavorites(tx *bolt.Tx, moved map[string]string) error {
+	favorites, err := readFavorites(tx)
+	if err != nil || len(favorites) == 0 || len(moved) == 0 {
+		return err
+	}
+	for i, key := range favorites {
+		notebookKey, noteId := splitFavoriteKey(key)
+		if to, ok := moved[string(notebookKey)]; ok {
+			favorites[i] = favoriteKey([]byte(to), noteId)
+		}
+	}
+	return writeFavorites(tx, favorites)
+}
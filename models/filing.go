@@ -0,0 +1,531 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Filing rules sort notes dumped into an inbox notebook into where they belong
+ *  - a rule matches notes by tag, title prefix, content regex and / or metadata (kind, language),
+ *    all criteria given having to hold, and acts on them: moves them into another notebook,
+ *    adds tags to them and / or archives them (locks them read-only)
+ *  - rules are tried in the order they were added, the first matching rule winning; a rule
+ *    with Continue set lets the rules after it apply too (their actions are combined)
+ *  - notes locked read-only (including ones archived by a rule) are left alone
+ *  - with auto-filing (see SetAutoFiling), notes added to the inbox notebook by AddNotes are
+ *    filed right away, in the same transaction
+ * 'Rules' bucket: rule id (8 byte big endian) -> JSON FilingRule
+ */
+
+/**
+ * Returned for rules matching nothing, doing nothing, or having an invalid criterion
+ */
+var ErrInvalidFilingRule = errors.New("invalid filing rule")
+
+/**
+ * Returned when there is no filing rule with given id
+ */
+var ErrFilingRuleNotFound = errors.New("filing rule not found")
+
+/**
+ * Criteria of a filing rule; empty ones are ignored, the others must all hold
+ */
+type FilingMatch struct {
+	Tag          string `json:"tag,omitempty"`
+	TitlePrefix  string `json:"title_prefix,omitempty"`
+	ContentRegex string `json:"content_regex,omitempty"`
+	// metadata of the note: its kind (see kind.go) and detected language (see language.go)
+	Kind     string `json:"kind,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+/**
+ * What a filing rule does with notes it matches
+ */
+type FilingAction struct {
+	// notebook to move notes into (they get a fresh id there)
+	MoveTo  string   `json:"move_to,omitempty"`
+	AddTags []string `json:"add_tags,omitempty"`
+	// lock notes read-only, which also keeps them out of later filing
+	Archive bool `json:"archive,omitempty"`
+}
+
+/**
+ * A filing rule; Id is assigned by AddFilingRule
+ */
+type FilingRule struct {
+	Id     uint64       `json:"id"`
+	Name   string       `json:"name,omitempty"`
+	Match  FilingMatch  `json:"match"`
+	Action FilingAction `json:"action"`
+	// keep trying rules after this one when it matches (by default, the first match wins)
+	Continue bool `json:"continue,omitempty"`
+}
+
+/**
+ * What filing did (or would do, in a dry run) with a note
+ *  - MovedTo is the note's new place; its Id is zero in a dry run
+ *  - Error tells why filing the note failed (the note is then left as it was)
+ */
+type FiledNote struct {
+	NoteId    uint64   `json:"note_id"`
+	Rules     []uint64 `json:"rules"`
+	MovedTo   *NoteRef `json:"moved_to,omitempty"`
+	AddedTags []string `json:"added_tags,omitempty"`
+	Archived  bool     `json:"archived,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+/**
+ * Outcome of ApplyFilingRules: notes affected by some rule, in order of their ids
+ */
+type FilingReport struct {
+	Notebook string      `json:"notebook"`
+	DryRun   bool        `json:"dry_run"`
+	Notes    []FiledNote `json:"notes"`
+	Failed   int         `json:"failed"`
+}
+
+/**
+ * A filing rule with its content regex compiled
+ */
+type compiledFilingRule struct {
+	FilingRule
+	contentRegex *regexp.Regexp
+}
+
+/**
+ * Adds a filing rule after the existing ones
+ * Fails with ErrInvalidFilingRule if the rule has no criteria or no action, or an invalid regex or kind
+ * param: FilingRule rule
+ * return: error
+ */
+func (db *DB) AddFilingRule(rule FilingRule) error {
+	if _, err := compileFilingRule(rule); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("Rules"))
+		if err != nil {
+			return err
+		}
+		if rule.Id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(rule.Id), encoded)
+	})
+}
+
+/**
+ * Retrieves filing rules in the order they are tried
+ * return: ([]FilingRule, error)
+ */
+func (db *DB) ListFilingRules() ([]FilingRule, error) {
+	var rules []FilingRule
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		rules, err = getFilingRules(tx)
+		return err
+	})
+	return rules, err
+}
+
+/**
+ * Removes the filing rule with given id
+ * param: uint64 ruleId
+ * return: error
+ */
+func (db *DB) RemoveFilingRule(ruleId uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Rules"))
+		if bucket == nil || bucket.Get(itob(ruleId)) == nil {
+			return fmt.Errorf("%w: %d", ErrFilingRuleNotFound, ruleId)
+		}
+		return bucket.Delete(itob(ruleId))
+	})
+}
+
+/**
+ * Files notes added to given notebook by AddNotes right away ("" turns auto-filing off);
+ * the setting is persisted in the DB
+ * param: string notebookName
+ * return: error
+ */
+func (db *DB) SetAutoFiling(notebookName string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.AutoFiling = notebookName
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return err
+	}
+	db.autoFiling = notebookName
+	return nil
+}
+
+/**
+ * Notebook whose notes are filed as they're added ("" if auto-filing is off)
+ * return: string
+ */
+func (db *DB) AutoFiling() string {
+	return db.autoFiling
+}
+
+/**
+ * Runs filing rules over all notes of a notebook; every note is filed in a transaction of its own
+ * With dryRun, nothing is changed: the report tells what would be done
+ * Fails with ErrNotebookArchived if the notebook is archived (unless dryRun)
+ * param: string notebookName
+ * param: bool   dryRun
+ * return: (FilingReport, error)
+ */
+func (db *DB) ApplyFilingRules(notebookName string, dryRun bool) (FilingReport, error) {
+	report := FilingReport{Notebook: notebookName, DryRun: dryRun}
+	var rules []compiledFilingRule
+	var noteIds []uint64
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if !dryRun {
+			if err := db.checkNotArchived(tx, notebookName); err != nil {
+				return err
+			}
+		}
+		var err error
+		if rules, err = getCompiledFilingRules(tx); err != nil {
+			return err
+		}
+		return db.forEachMatchingNote(tx, notebookKey, NoteFilter{IncludeExpired: true}, func(note Note) error {
+			if dryRun {
+				if filed, ok := db.planFiling(tx, notebookName, note, rules); ok {
+					report.Notes = append(report.Notes, filed)
+				}
+				return nil
+			}
+			noteIds = append(noteIds, note.Id)
+			return nil
+		})
+	})
+	if err != nil || dryRun || len(rules) == 0 {
+		sortFiledNotes(report.Notes)
+		return report, err
+	}
+
+	sort.Slice(noteIds, func(i, j int) bool { return noteIds[i] < noteIds[j] })
+	for _, noteId := range noteIds {
+		var filed FiledNote
+		var ok bool
+		err := db.Update(func(tx *bolt.Tx) error {
+			// the note may have changed (or gone) since it was listed
+			_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+			if errors.Is(err, ErrNoteNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			filed, ok, err = db.fileNoteInTx(tx, notebookName, note, rules)
+			return err
+		})
+		if err != nil {
+			if !errors.Is(err, ErrNotebookArchived) {
+				return report, err
+			}
+			filed.Error = err.Error()
+			report.Failed++
+		}
+		if ok {
+			report.Notes = append(report.Notes, filed)
+		}
+	}
+	return report, nil
+}
+
+/**
+ * Files notes just added by AddNotes within its transaction, if auto-filing is on for their notebook
+ * Notes that can't be filed into an archived notebook are left in place (and logged)
+ */
+func (db *DB) autoFileInTx(tx *bolt.Tx, notebookName string, added []Note) error {
+	if db.autoFiling == "" || string(db.notebookKey(db.autoFiling)) != string(db.notebookKey(notebookName)) {
+		return nil
+	}
+	rules, err := getCompiledFilingRules(tx)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	for _, note := range added {
+		if _, _, err := db.fileNoteInTx(tx, notebookName, note, rules); err != nil {
+			if !errors.Is(err, ErrNotebookArchived) {
+				return err
+			}
+			db.logf("filing: note %d of notebook '%s' left in place: %v", note.Id, notebookName, err)
+		}
+	}
+	return nil
+}
+
+/**
+ * Works out what filing rules do with a note, without changing anything
+ * return: (FiledNote, bool) Whether the note is affected
+ */
+func (db *DB) planFiling(tx *bolt.Tx, notebookName string, note Note, rules []compiledFilingRule) (FiledNote, bool) {
+	filed := FiledNote{NoteId: note.Id}
+	if note.ReadOnly {
+		return filed, false
+	}
+	var moveTo string
+	for _, rule := range rules {
+		if !rule.matches(note) {
+			continue
+		}
+		filed.Rules = append(filed.Rules, rule.Id)
+		if moveTo == "" && rule.Action.MoveTo != "" &&
+			string(db.notebookKey(rule.Action.MoveTo)) != string(db.notebookKey(notebookName)) {
+			moveTo = rule.Action.MoveTo
+		}
+		for _, tag := range rule.Action.AddTags {
+			if !containsString(note.Tags, tag) && !containsString(filed.AddedTags, tag) {
+				filed.AddedTags = append(filed.AddedTags, tag)
+			}
+		}
+		filed.Archived = filed.Archived || rule.Action.Archive
+		if !rule.Continue {
+			break
+		}
+	}
+	if moveTo != "" {
+		filed.MovedTo = &NoteRef{Notebook: moveTo}
+		if tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(moveTo)) != nil {
+			filed.MovedTo.Notebook = notebookDisplayName(tx, db.notebookKey(moveTo))
+		}
+	}
+	// (notes already filed, like ones having the tags added, are not affected)
+	return filed, filed.MovedTo != nil || len(filed.AddedTags) > 0 || filed.Archived
+}
+
+/**
+ * Applies filing rules to a note within given write transaction
+ * Fails with ErrNotebookArchived (before writing anything) if the note is to be moved into an archived notebook
+ * return: (FiledNote, bool, error) Whether the note is affected
+ */
+func (db *DB) fileNoteInTx(tx *bolt.Tx, notebookName string, note Note, rules []compiledFilingRule) (FiledNote, bool, error) {
+	filed, ok := db.planFiling(tx, notebookName, note, rules)
+	if !ok {
+		return filed, false, nil
+	}
+	if filed.MovedTo != nil {
+		if err := db.checkNotArchived(tx, filed.MovedTo.Notebook); err != nil {
+			return filed, true, err
+		}
+	}
+
+	note.Tags = append(note.Tags, filed.AddedTags...)
+	note.ReadOnly = note.ReadOnly || filed.Archived
+	db.bumpRevision(&note)
+	if filed.MovedTo == nil {
+		return filed, true, db.putNote(tx, db.notebookKey(notebookName), note)
+	}
+	moved, err := db.moveToNotebookInTx(tx, notebookName, note, filed.MovedTo.Notebook)
+	filed.MovedTo.Id = moved.Id
+	return filed, true, err
+}
+
+/**
+ * Moves a note into another notebook (created if it doesn't exist), where it gets a fresh id; its attachments,
+ * history, relations and favorite entry go along
+ * Fails with ErrNoteReadOnly if the note is locked read-only, ErrNotebookArchived if either notebook is
+ * archived, and ErrDuplicateNotebook if both are the same
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string targetName
+ * return: (Note, error) The note as stored in the other notebook
+ */
+func (db *DB) MoveNote(notebookName string, noteId uint64, targetName string) (Note, error) {
+	if err := db.checkNotebookTxNames([]string{notebookName, targetName}); err != nil {
+		return Note{}, err
+	}
+	var moved Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		if err := checkWritable(notebookName, note, false); err != nil {
+			return err
+		}
+		for _, name := range []string{notebookName, targetName} {
+			if err := db.checkNotArchived(tx, name); err != nil {
+				return err
+			}
+		}
+		db.bumpRevision(&note)
+		moved, err = db.moveToNotebookInTx(tx, notebookName, note, targetName)
+		return err
+	})
+	return moved, err
+}
+
+/**
+ * Moves a note into another notebook (creating it if needed) within given write transaction;
+ * the note gets a fresh id there, and takes its attachments, history, relations and favorite entry along
+ * return: (Note, error) The note as stored in the other notebook
+ */
+func (db *DB) moveToNotebookInTx(tx *bolt.Tx, notebookName string, note Note, targetName string) (Note, error) {
+	nbs, err := db.notebookTxs(tx, []string{notebookName, targetName}, true)
+	if err != nil {
+		return note, err
+	}
+	source, target := nbs[notebookName], nbs[targetName]
+
+	moved := note
+	moved.Id, moved.Position = 0, 0
+	if moved, err = target.Put(moved); err != nil {
+		return note, err
+	}
+	attachments, err := listAttachmentsInTx(tx, source.key, note.Id)
+	if err != nil {
+		return note, err
+	}
+	for _, attachment := range attachments {
+		if err := putAttachment(tx, target.key, moved.Id, attachment, readBlob(tx, attachment.Hash)); err != nil {
+			return note, err
+		}
+	}
+	if err := copyNoteHistory(tx, source.key, note.Id, target.key, moved.Id); err != nil {
+		return note, err
+	}
+	if sealer := db.notebookSealer(tx, target.key); sealer.notebookKeyId != db.notebookSealer(tx, source.key).notebookKeyId {
+		// (revisions are encrypted as per the notebook they're moved into, see notebook_encryption.go)
+		if err := resealHistory(tx, target.key, moved.Id, sealer); err != nil {
+			return note, err
+		}
+	}
+	if err := moveRelations(tx, source.key, note.Id, target.key, moved.Id); err != nil {
+		return note, err
+	}
+	if err := moveFavorite(tx, source.key, note.Id, target.key, moved.Id); err != nil {
+		return note, err
+	}
+	return moved, source.Delete(note.Id)
+}
+
+/**
+ * Copies revisions of a note's history onto another note
+ */
+func copyNoteHistory(tx *bolt.Tx, sourceKey []byte, sourceId uint64, targetKey []byte, targetId uint64) error {
+	sourceHistory := noteHistoryBucket(tx, sourceKey, sourceId)
+	if sourceHistory == nil {
+		return nil
+	}
+	targetHistory, err := createNoteHistoryBucket(tx, targetKey, targetId)
+	if err != nil {
+		return err
+	}
+	if err := sourceHistory.ForEach(func(k, v []byte) error {
+		return targetHistory.Put(k, v)
+	}); err != nil {
+		return err
+	}
+	return targetHistory.SetSequence(sourceHistory.Sequence())
+}
+
+/**
+ * Whether a note meets all criteria of a rule
+ */
+func (rule compiledFilingRule) matches(note Note) bool {
+	match := rule.Match
+	if match.Tag != "" && !containsString(note.Tags, match.Tag) {
+		return false
+	}
+	if match.TitlePrefix != "" {
+		if title, ok := noteTitle(note.Content); !ok || !strings.HasPrefix(title, match.TitlePrefix) {
+			return false
+		}
+	}
+	if rule.contentRegex != nil && !rule.contentRegex.MatchString(note.Content) {
+		return false
+	}
+	if match.Kind != "" && match.Kind != note.Kind && !(match.Kind == KindText && note.Kind == "") {
+		return false
+	}
+	if match.Language != "" && match.Language != note.Language {
+		return false
+	}
+	return true
+}
+
+func compileFilingRule(rule FilingRule) (compiledFilingRule, error) {
+	compiled := compiledFilingRule{FilingRule: rule}
+	if rule.Match == (FilingMatch{}) {
+		return compiled, fmt.Errorf("%w: no criteria", ErrInvalidFilingRule)
+	}
+	if rule.Action.MoveTo == "" && len(rule.Action.AddTags) == 0 && !rule.Action.Archive {
+		return compiled, fmt.Errorf("%w: no action", ErrInvalidFilingRule)
+	}
+	if rule.Match.Kind != "" {
+		if err := validateKind(rule.Match.Kind, ""); errors.Is(err, ErrUnknownKind) {
+			return compiled, fmt.Errorf("%w: %v", ErrInvalidFilingRule, err)
+		}
+	}
+	if rule.Match.ContentRegex != "" {
+		var err error
+		if compiled.contentRegex, err = regexp.Compile(rule.Match.ContentRegex); err != nil {
+			return compiled, fmt.Errorf("%w: %v", ErrInvalidFilingRule, err)
+		}
+	}
+	return compiled, nil
+}
+
+func getFilingRules(tx *bolt.Tx) ([]FilingRule, error) {
+	var rules []FilingRule
+	bucket := tx.Bucket([]byte("Rules"))
+	if bucket == nil {
+		return nil, nil
+	}
+	err := bucket.ForEach(func(_, v []byte) error {
+		var rule FilingRule
+		if err := json.Unmarshal(v, &rule); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+		return nil
+	})
+	return rules, err
+}
+
+func getCompiledFilingRules(tx *bolt.Tx) ([]compiledFilingRule, error) {
+	rules, err := getFilingRules(tx)
+	if err != nil {
+		return nil, err
+	}
+	var compiled []compiledFilingRule
+	for _, rule := range rules {
+		c, err := compileFilingRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("filing rule %d: %w", rule.Id, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func sortFiledNotes(notes []FiledNote) {
+	sort.Slice(notes, func(i, j int) bool { return notes[i].NoteId < notes[j].NoteId })
+}
@@ -0,0 +1,234 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes get a fingerprint when they're created (see ContentFingerprint), telling the same note apart
+ * across devices and imports where ids can't: ids are local to a DB, and content hashes change with
+ * every edit while fingerprints never do
+ *  - exports carry fingerprints, so that a note imported (or synced) elsewhere keeps its own; notes
+ *    of other sources (like ENEX) get one made of their content and creation time, which importing
+ *    the same source again makes again
+ *  - 'Fingerprints' bucket: notebook key -> 'fingerprints' -> fingerprint and note id (8 bytes) -> empty
+ *                                        -> 'notes' -> note id -> fingerprint
+ *    notes sharing a fingerprint (like ones imported twice without deduplication) all have entries
+ *  - notes created before fingerprints existed have none until BackfillFingerprints makes them
+ */
+
+/**
+ * Fingerprint of a note created with given content at given time: hex encoded SHA-256 of the content
+ * (normalized, see below) and the time of creation truncated to the second
+ *  - content is normalized as by StandardNormalization, trimming trailing space of lines and surrounding
+ *    whitespace, so that it's the same whatever normalization DBs apply on write (see SetContentNormalization)
+ * param: string    content
+ * param: time.Time createdAt
+ * return: string
+ */
+func ContentFingerprint(content string, createdAt time.Time) string {
+	opts := StandardNormalization
+	opts.TrimTrailingSpace = true
+	normalized, _ := NormalizeContent(content, opts)
+	hash := sha256.New()
+	hash.Write([]byte(strings.TrimSpace(normalized)))
+	hash.Write([]byte{0})
+	hash.Write(itob(uint64(createdAt.Truncate(time.Second).Unix())))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/**
+ * Looks up a note of a notebook by fingerprint (the one with the lowest id, should several share it)
+ * Fails with ErrNotebookNotFound if the notebook doesn't exist, and with ErrIndexNotBuilt (finding nothing)
+ * if the DB has no fingerprint index: DBs from before fingerprints get theirs from BackfillFingerprints
+ * param: string notebookName
+ * param: string fingerprint
+ * return: (NoteRef, bool, error)
+ */
+func (db *DB) FindByFingerprint(notebookName string, fingerprint string) (NoteRef, bool, error) {
+	var ref NoteRef
+	var found bool
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := indexBuilt(tx, "Fingerprints"); err != nil {
+			return err
+		}
+		var noteId uint64
+		if noteId, found = lookupFingerprint(tx, notebookKey, fingerprint); found {
+			ref = NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: noteId}
+		}
+		return nil
+	})
+	return ref, found, err
+}
+
+/**
+ * Gives fingerprints to notes that have none (ones created before fingerprints existed), made of
+ * their current content: unlike fingerprints given on creation, these can differ between copies of
+ * a note that were edited since
+ * Corrupt records are treated as per the read policy (see SetReadPolicy)
+ * return: (int, error) Number of notes given a fingerprint
+ */
+func (db *DB) BackfillFingerprints() (int, error) {
+	defer db.markBusy()()
+	var backfilled int
+	err := db.Update(func(tx *bolt.Tx) error {
+		backfilled = 0
+		// (the index is built even if no note needs a fingerprint)
+		if _, err := tx.CreateBucketIfNotExists([]byte("Fingerprints")); err != nil {
+			return err
+		}
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if notebookBucket == nil {
+				return nil
+			}
+			var keys, records [][]byte
+			if err := notebookBucket.ForEach(func(k, v []byte) error {
+				if v != nil {
+					keys, records = append(keys, k), append(records, v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for i, k := range keys {
+				var record Note
+				err := json.Unmarshal(records[i], &record)
+				if db.skipCorrupt(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if record.Fingerprint != "" {
+					continue
+				}
+				note, err := db.decodeNote(tx, notebookKey, k, records[i])
+				if db.skipCorrupt(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				// (only the fingerprint is added to the record as stored: content may be encrypted in it)
+				record.Fingerprint = ContentFingerprint(note.Content, note.CreatedAt)
+				encoded, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				db.invalidateNote(tx, notebookKey, note.Id)
+				if err := notebookBucket.Put(k, encoded); err != nil {
+					return err
+				}
+				if err := putFingerprint(tx, notebookKey, note.Id, record.Fingerprint); err != nil {
+					return err
+				}
+				backfilled++
+			}
+			return nil
+		})
+	})
+	return backfilled, err
+}
+
+/**
+ * Fingerprint of a note about to be created: its own if it has one (like an imported one), or one
+ * made of its content and creation time
+ */
+func noteFingerprint(note Note) string {
+	if note.Fingerprint != "" {
+		return note.Fingerprint
+	}
+	return ContentFingerprint(note.Content, note.CreatedAt)
+}
+
+/**
+ * Fingerprint an imported note is looked up by: its own, or one made of its content and creation time
+ * (empty for notes of unknown creation time, which get created now)
+ */
+func importFingerprint(note Note) string {
+	if note.Fingerprint == "" && note.CreatedAt.IsZero() {
+		return ""
+	}
+	return noteFingerprint(note)
+}
+
+func lookupFingerprint(tx *bolt.Tx, notebookKey []byte, fingerprint string) (uint64, bool) {
+	fingerprints := suggestionBucket(tx, "Fingerprints", notebookKey, "fingerprints")
+	if fingerprints == nil || fingerprint == "" {
+		return 0, false
+	}
+	k, _ := fingerprints.Cursor().Seek([]byte(fingerprint))
+	if k == nil || len(k) != len(fingerprint)+8 || !bytes.HasPrefix(k, []byte(fingerprint)) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(k[len(fingerprint):]), true
+}
+
+/**
+ * Replaces the fingerprint index entry of a note (an empty fingerprint removes it)
+ */
+func putFingerprint(tx *bolt.Tx, notebookKey []byte, noteId uint64, fingerprint string) error {
+	noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+	var previous []byte
+	if notes := suggestionBucket(tx, "Fingerprints", notebookKey, "notes"); notes != nil {
+		previous = append([]byte(nil), notes.Get(noteIdBytes)...)
+	}
+	if string(previous) == fingerprint {
+		return nil
+	}
+
+	buckets, err := createSuggestionBuckets(tx, "Fingerprints", notebookKey, "fingerprints", "notes")
+	if err != nil {
+		return err
+	}
+	fingerprints, notes := buckets[0], buckets[1]
+	if len(previous) > 0 {
+		if err := fingerprints.Delete(append(previous, itob(noteId)...)); err != nil {
+			return err
+		}
+	}
+	if fingerprint == "" {
+		return notes.Delete(noteIdBytes)
+	}
+	if err := fingerprints.Put(append([]byte(fingerprint), itob(noteId)...), []byte{}); err != nil {
+		return err
+	}
+	return notes.Put(noteIdBytes, []byte(fingerprint))
+}
+
+/**
+ * Maps fingerprints of notes of a notebook onto their ids (see contentHashes)
+ */
+func (db *DB) fingerprints(notebookName string) (map[string]uint64, error) {
+	fingerprints := make(map[string]uint64)
+	err := db.View(func(tx *bolt.Tx) error {
+		notes := suggestionBucket(tx, "Fingerprints", db.notebookKey(notebookName), "notes")
+		if notes == nil {
+			return nil
+		}
+		return notes.ForEach(func(k, v []byte) error {
+			noteId, _ := strconv.ParseUint(string(k), 10, 64)
+			if id, ok := fingerprints[string(v)]; !ok || noteId < id {
+				fingerprints[string(v)] = noteId
+			}
+			return nil
+		})
+	})
+	return fingerprints, err
+}
@@ -0,0 +1,307 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * A past revision of a note
+ *  - revisions of a note are numbered 1, 2, .. in the order they were written;
+ *    the note's current content is the revision after the last one in history
+ *  - SavedAt is the time at which this revision got superseded
+ */
+type NoteRevision struct {
+	Revision uint64    `json:"revision"`
+	Content  string    `json:"content"`
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+/**
+ * Returned when a requested revision doesn't exist
+ */
+type RevisionNotFoundError struct {
+	Notebook string
+	NoteId   uint64
+	Revision uint64
+}
+
+func (e *RevisionNotFoundError) Error() string {
+	return fmt.Sprintf("revision %d of note %d in notebook '%s' not found", e.Revision, e.NoteId, e.Notebook)
+}
+
+/**
+ * Returned by UpdateNoteIfRevision when the note was written since the expected revision
+ *  - Current is the note as currently stored, for the caller to merge with
+ */
+type RevisionConflictError struct {
+	Notebook string
+	Current  Note
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("note %d in notebook '%s' has been modified (now at revision %d)", e.Current.Id, e.Notebook, e.Current.Revision)
+}
+
+/**
+ * Updates content of a note, archiving the previous content into 'History' bucket (see revisions.go)
+ * Fails with ErrNoteReadOnly if the note is locked read-only, unless Force() is passed
+ * param: string         notebookName
+ * param: uint64         noteId
+ * param: string         content
+ * param: ...WriteOption opts
+ * return: (Note, error)
+ */
+func (db *DB) UpdateNote(notebookName string, noteId uint64, content string, opts ...WriteOption) (Note, error) {
+	options := newWriteOptions(opts)
+	// marshal outside of the write transaction, retrying if the note changed in between
+	for attempt := 0; attempt < maxPrepareAttempts; attempt++ {
+		update, err := db.prepareUpdate(notebookName, noteId, content, options.force)
+		if err != nil {
+			return update.note, err
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			return db.commitUpdate(tx, update)
+		})
+		if err != errStalePrepare {
+			return update.note, err
+		}
+	}
+
+	// kept losing the race: prepare within the write transaction instead
+	var note Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		note, err = db.updateNoteInTx(tx, notebookName, noteId, content, options.force)
+		return err
+	})
+	return note, err
+}
+
+/**
+ * Updates content of a note only if it is still at the expected revision (optimistic concurrency)
+ * Fails with *RevisionConflictError (carrying the current note) if the note was written in between,
+ * and with ErrNoteReadOnly if the note is locked read-only, unless Force() is passed
+ * param: string         notebookName
+ * param: uint64         noteId
+ * param: uint64         expectedRev Revision of the note the new content is based on
+ * param: string         content
+ * param: ...WriteOption opts
+ * return: (Note, error)
+ */
+func (db *DB) UpdateNoteIfRevision(notebookName string, noteId uint64, expectedRev uint64, content string, opts ...WriteOption) (Note, error) {
+	options := newWriteOptions(opts)
+	var note Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, current, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		if current.Revision != expectedRev {
+			note = current
+			return &RevisionConflictError{Notebook: notebookName, Current: current}
+		}
+		note, err = db.updateNoteInTx(tx, notebookName, noteId, content, options.force)
+		return err
+	})
+	return note, err
+}
+
+/**
+ * Retrieves past revisions of a note (oldest first)
+ * A note that was never updated has no history
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: ([]NoteRevision, error)
+ */
+func (db *DB) GetNoteHistory(notebookName string, noteId uint64) ([]NoteRevision, error) {
+	var revisions []NoteRevision
+	err := db.View(func(tx *bolt.Tx) error {
+		historyBucket := noteHistoryBucket(tx, db.notebookKey(notebookName), noteId)
+		if historyBucket == nil {
+			return nil
+		}
+		var err error
+		revisions, err = readHistory(historyBucket, db.sealer())
+		return err
+	})
+	return revisions, err
+}
+
+/**
+ * Makes content of a past revision the note's current content
+ * (current content is archived as usual, so restoring can itself be reverted)
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: uint64 revision
+ * return: (Note, error)
+ */
+func (db *DB) RestoreRevision(notebookName string, noteId uint64, revision uint64) (Note, error) {
+	var note Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		content, err := db.revisionContent(tx, notebookName, noteId, revision)
+		if err != nil {
+			return err
+		}
+		note, err = db.updateNoteInTx(tx, notebookName, noteId, content, false)
+		return err
+	})
+	return note, err
+}
+
+/**
+ * Computes line-based diff between two revisions of a note
+ * (either of them may be the current revision)
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: uint64 revA
+ * param: uint64 revB
+ * return: ([]DiffHunk, error)
+ */
+func (db *DB) DiffRevisions(notebookName string, noteId uint64, revA, revB uint64) ([]DiffHunk, error) {
+	var hunks []DiffHunk
+	err := db.View(func(tx *bolt.Tx) error {
+		contentA, err := db.revisionContent(tx, notebookName, noteId, revA)
+		if err != nil {
+			return err
+		}
+		contentB, err := db.revisionContent(tx, notebookName, noteId, revB)
+		if err != nil {
+			return err
+		}
+		hunks = DiffText(contentA, contentB)
+		return nil
+	})
+	return hunks, err
+}
+
+/**
+ * Computes line-based diff between a past revision and current content of a note
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: uint64 rev
+ * return: ([]DiffHunk, error)
+ */
+func (db *DB) DiffAgainstCurrent(notebookName string, noteId uint64, rev uint64) ([]DiffHunk, error) {
+	var hunks []DiffHunk
+	err := db.View(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		content, err := db.revisionContent(tx, notebookName, noteId, rev)
+		if err != nil {
+			return err
+		}
+		hunks = DiffText(content, note.Content)
+		return nil
+	})
+	return hunks, err
+}
+
+/**
+ * Updates note content within given transaction, archiving the previous content
+ * Fails with ErrNoteReadOnly if the note is locked read-only, unless force is set,
+ * and with ErrNotebookArchived if the notebook is archived
+ */
+func (db *DB) updateNoteInTx(tx *bolt.Tx, notebookName string, noteId uint64, content string, force bool) (Note, error) {
+	_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+	if err != nil {
+		return note, err
+	}
+	if err := checkWritable(notebookName, note, force); err != nil {
+		return note, err
+	}
+	if err := db.checkNotArchived(tx, notebookName); err != nil {
+		return note, err
+	}
+	if content, err = db.encoding().normalize(content); err != nil {
+		return note, err
+	}
+
+	// archive current content as the next revision in history
+	historyBucket, err := createNoteHistoryBucket(tx, db.notebookKey(notebookName), noteId)
+	if err != nil {
+		return note, err
+	}
+	now := time.Now()
+	revision := NoteRevision{Revision: historyBucket.Sequence() + 1, Content: note.Content, SavedAt: now}
+	write, err := prepareRevision(historyBucket, revision, db.notebookSealer(tx, db.notebookKey(notebookName)), db.historySnapshotInterval)
+	if err != nil {
+		return note, err
+	}
+	if err := write.commit(historyBucket); err != nil {
+		return note, err
+	}
+
+	note.Content = content
+	note.UpdatedAt = now
+	db.bumpRevision(&note)
+	note = db.titles.onUpdate(note)
+	if err := db.runBeforeSave(notebookName, note); err != nil {
+		return note, err
+	}
+	if err := recordActivity(tx, db.notebookKey(notebookName), dayActivity{Updated: 1}); err != nil {
+		return note, err
+	}
+	return note, db.putNote(tx, db.notebookKey(notebookName), note)
+}
+
+/**
+ * Returns content of given revision of a note, be it a past or the current one
+ */
+func (db *DB) revisionContent(tx *bolt.Tx, notebookName string, noteId uint64, revision uint64) (string, error) {
+	_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+	if err != nil {
+		return "", err
+	}
+
+	historyBucket := noteHistoryBucket(tx, db.notebookKey(notebookName), noteId)
+	var currentRevision uint64 = 1
+	if historyBucket != nil {
+		currentRevision = historyBucket.Sequence() + 1
+	}
+	if revision == currentRevision {
+		return note.Content, nil
+	}
+	if historyBucket != nil {
+		if noteRevision, ok, err := readHistoryRevision(historyBucket, revision, db.sealer()); ok || err != nil {
+			return noteRevision.Content, err
+		}
+	}
+	return "", &RevisionNotFoundError{Notebook: notebookName, NoteId: noteId, Revision: revision}
+}
+
+/**
+ * Retrieves (3rd order) history bucket of a note: History -> notebook -> note
+ * Returns nil if the note has no history
+ */
+func noteHistoryBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) *bolt.Bucket {
+	historyBucket := tx.Bucket([]byte("History"))
+	if historyBucket == nil {
+		return nil
+	}
+	notebookHistoryBucket := historyBucket.Bucket(notebookKey)
+	if notebookHistoryBucket == nil {
+		return nil
+	}
+	return notebookHistoryBucket.Bucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+/**
+ * Creates (or retrieves existing) history bucket of a note
+ */
+func createNoteHistoryBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) (*bolt.Bucket, error) {
+	historyBucket, err := tx.CreateBucketIfNotExists([]byte("History"))
+	if err != nil {
+		return nil, err
+	}
+	notebookHistoryBucket, err := historyBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	return notebookHistoryBucket.CreateBucketIfNotExists([]byte(strconv.FormatUint(noteId, 10)))
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"bytes"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes are keyed by their id in decimal, which bolt sorts byte by byte: "10" comes before "2"
+ *  - keys of the same length sort like their ids, so notes are walked in order of ids a length at a time
+ *    (1 to 9, then 10 to 99, ...), seeking to the first key of every length
+ *  - within a length, longer keys met on the way are skipped by seeking past every key they prefix, so a
+ *    walk reads about as many keys as a plain one, and can stop early like one
+ *  - keys that aren't ids (not all digits, or with a leading zero) aren't walked
+ */
+
+// most digits of a uint64 id
+const maxIdDigits = 20
+
+/**
+ * Cursor over records of a notebook bucket in order of ids (see above), used like a bolt.Cursor
+ */
+type idCursor struct {
+	cursor *bolt.Cursor
+	// length of the keys being walked
+	length int
+}
+
+func newIdCursor(notebookBucket *bolt.Bucket) *idCursor {
+	return &idCursor{cursor: notebookBucket.Cursor()}
+}
+
+/**
+ * Moves to the record of the lowest id, returning its key and value (nil if there's none)
+ */
+func (c *idCursor) First() ([]byte, []byte) {
+	c.length = 0
+	return c.nextLength()
+}
+
+/**
+ * Moves to the record of the next id, returning its key and value (nil once past the last one)
+ */
+func (c *idCursor) Next() ([]byte, []byte) {
+	k, v := c.cursor.Next()
+	return c.settle(k, v)
+}
+
+/**
+ * Moves to the first key of the next length
+ */
+func (c *idCursor) nextLength() ([]byte, []byte) {
+	for c.length < maxIdDigits {
+		c.length++
+		first := append([]byte{'1'}, bytes.Repeat([]byte{'0'}, c.length-1)...)
+		if k, v := c.settle(c.cursor.Seek(first)); k != nil {
+			return k, v
+		}
+	}
+	return nil, nil
+}
+
+/**
+ * Moves on from given position to the first key of the current length, or to the first of the next
+ * length once there are no more
+ */
+func (c *idCursor) settle(k, v []byte) ([]byte, []byte) {
+	for k != nil {
+		switch {
+		case len(k) == c.length && isIdKey(k):
+			return k, v
+		case len(k) > c.length && isIdKey(k[:c.length]):
+			// (keys from here to the next prefix of this length are all longer)
+			next, ok := incrementDigits(k[:c.length])
+			if !ok {
+				return c.nextLength()
+			}
+			k, v = c.cursor.Seek(next)
+		default:
+			k, v = c.cursor.Next()
+		}
+	}
+	return c.nextLength()
+}
+
+/**
+ * Whether a key is an id as notes are keyed by: digits, without leading zero
+ */
+func isIdKey(k []byte) bool {
+	if len(k) == 0 || k[0] == '0' {
+		return false
+	}
+	for _, b := range k {
+		if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Decimal digits of the number after the one given, of the same length; false if it has more digits
+ */
+func incrementDigits(digits []byte) ([]byte, bool) {
+	next := append([]byte(nil), digits...)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i] < '9' {
+			next[i]++
+			return next, true
+		}
+		next[i] = '0'
+	}
+	return nil, false
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestIdCursorWalksInOrderOfIds(t *testing.T) {
+	db := newTestDB(t)
+	var ids []uint64
+	for id := uint64(1); id <= 1200; id++ {
+		// (gaps within lengths, and across whole ones)
+		if id%7 != 3 && (id < 20 || id > 99) {
+			ids = append(ids, id)
+		}
+	}
+	ids = append(ids, 99999, 100000, 18446744073709551615)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("ids"))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := bucket.Put([]byte(strconv.FormatUint(id, 10)), []byte("note")); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte("empty")); err != nil {
+			return err
+		}
+		// keys that aren't ids are left out
+		for _, key := range []string{"0", "007", "12a", "abc", "~"} {
+			if err := bucket.Put([]byte(key), []byte("other")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("ids"))
+		var walked []uint64
+		cursor := newIdCursor(bucket)
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if string(v) != "note" {
+				t.Errorf("walked key %q", k)
+				continue
+			}
+			id, _ := strconv.ParseUint(string(k), 10, 64)
+			walked = append(walked, id)
+		}
+		if !reflect.DeepEqual(walked, ids) {
+			t.Errorf("walked %d ids %v..., want %d", len(walked), walked[:12], len(ids))
+		}
+		// as a bolt.Cursor, it starts over on First, and stays past the end
+		if k, _ := cursor.First(); string(k) != "1" {
+			t.Errorf("first key again %q", k)
+		}
+		if k, _ := newIdCursor(tx.Bucket([]byte("empty"))).First(); k != nil {
+			t.Errorf("first key of an empty bucket %q", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListNotesInOrderOfIds(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 25; i++ {
+		mustAddNote(t, db, "work", Note{Content: "note " + strconv.Itoa(i+1)})
+	}
+	// whether notes are decoded after their transaction, partway through or within it
+	for _, size := range testDecodeBuffers {
+		db.SetDecodeBuffer(size)
+		notes, err := db.ListNotes("work")
+		if err != nil || len(notes) != 25 {
+			t.Fatalf("decode buffer %d: %d notes listed (%v)", size, len(notes), err)
+		}
+		summaries, err := db.ListNoteSummaries("work", DefaultPreviewLength)
+		if err != nil || len(summaries) != 25 {
+			t.Fatalf("decode buffer %d: %d summaries listed (%v)", size, len(summaries), err)
+		}
+		for i := range notes {
+			if want := uint64(i + 1); notes[i].Id != want || summaries[i].Id != want {
+				t.Fatalf("decode buffer %d: note %d of the listing is %d (summary %d), want %d", size, i, notes[i].Id, summaries[i].Id, want)
+			}
+		}
+	}
+	db.SetDecodeBuffer(0)
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/**
+ * Version of the id-mapping format written by WriteIdMapping
+ */
+const IdMappingFormat = 1
+
+/**
+ * Outcome of importing a single source record
+ */
+type MappingStatus string
+
+const (
+	// a note was created for the record
+	MappingCreated MappingStatus = "created"
+	// the record duplicates a note that already existed, Ref points at that note
+	MappingDuplicate MappingStatus = "duplicate"
+	// the record couldn't be imported, Error tells why (and Ref is absent)
+	MappingFailed MappingStatus = "failed"
+	// the record conflicted with a note that already existed, and was left out for it (see ConflictSkip);
+	// or it was left out by the import's TransformFunc (see ErrSkipRecord), in which case Ref is absent
+	MappingSkipped MappingStatus = "skipped"
+	// the record conflicted with a note that already existed, which it overwrote (see ConflictOverwrite)
+	MappingOverwritten MappingStatus = "overwritten"
+	// the record conflicted with a note that already existed, which its content was appended to
+	// (see ConflictMergeContent)
+	MappingMerged MappingStatus = "merged"
+)
+
+/**
+ * Maps a record of an import source onto the note it ended up as
+ *  - SourceKey identifies the record within its source; its form depends on the importer:
+ *    'notebook/id' of the exporting DB for ImportNote, and 'enex:<sha256>' (over title,
+ *    created time and content) for ImportENEX
+ */
+type IdMapping struct {
+	SourceKey string        `json:"source_key"`
+	Ref       *NoteRef      `json:"ref,omitempty"`
+	Status    MappingStatus `json:"status"`
+	Error     string        `json:"error,omitempty"`
+}
+
+/**
+ * Document written by WriteIdMapping; this is the stable format other tools may persist:
+ *   {"format": 1, "entries": [{"source_key": "..", "ref": {"notebook": "..", "id": 1}, "status": "created"}, ..]}
+ * Entries appear in the order records were read from the source
+ */
+type idMappingDocument struct {
+	Format  int         `json:"format"`
+	Entries []IdMapping `json:"entries"`
+}
+
+/**
+ * Writes an id-mapping table as JSON (see idMappingDocument for the format)
+ * param: io.Writer   w
+ * param: []IdMapping mapping
+ * return: error
+ */
+func WriteIdMapping(w io.Writer, mapping []IdMapping) error {
+	if mapping == nil {
+		mapping = []IdMapping{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(idMappingDocument{Format: IdMappingFormat, Entries: mapping})
+}
+
+/**
+ * Reads an id-mapping table written by WriteIdMapping
+ * param: io.Reader r
+ * return: ([]IdMapping, error)
+ */
+func ReadIdMapping(r io.Reader) ([]IdMapping, error) {
+	var document idMappingDocument
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return nil, err
+	}
+	if document.Format != IdMappingFormat {
+		return nil, fmt.Errorf("unsupported id-mapping format %d", document.Format)
+	}
+	return document.Entries, nil
+}
+
+func mappedTo(sourceKey string, notebookName string, noteId uint64, status MappingStatus) IdMapping {
+	return IdMapping{SourceKey: sourceKey, Ref: &NoteRef{Notebook: notebookName, Id: noteId}, Status: status}
+}
+
+func mappingFailed(sourceKey string, err error) IdMapping {
+	return IdMapping{SourceKey: sourceKey, Status: MappingFailed, Error: err.Error()}
+}
@@ -0,0 +1,145 @@
+package models_test
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Parses a 'notebook/id' source key (that of ImportNote and ImportNotebook) back into a ref
+ */
+func sourceRef(t *testing.T, sourceKey string) models.NoteRef {
+	t.Helper()
+	i := strings.LastIndex(sourceKey, "/")
+	id, err := strconv.ParseUint(sourceKey[i+1:], 10, 64)
+	if i < 0 || err != nil {
+		t.Fatalf("source key '%s' isn't 'notebook/id'", sourceKey)
+	}
+	return models.NoteRef{Notebook: sourceKey[:i], Id: id}
+}
+
+func TestImportNotebookMappingRoundTrip(t *testing.T) {
+	source := notestest.NewDB(t)
+	notestest.Seed(t, source, notestest.Spec{Notebooks: map[string][]string{
+		"work": {"first", "second", "third", "fourth"},
+	}})
+	var export bytes.Buffer
+	if err := source.ExportNotebook("work", &export); err != nil {
+		t.Fatal(err)
+	}
+	// 'third' no longer matches its hash, so its record fails
+	tampered := strings.Replace(export.String(), `"content":"third"`, `"content":"tampered"`, 1)
+	if tampered == export.String() {
+		t.Fatal("note 'third' not found in the export")
+	}
+
+	target := notestest.NewDB(t)
+	existing := notestest.MustAdd(t, target, "inbox", "second")
+	var mappingOut bytes.Buffer
+	report, err := target.ImportNotebook("inbox", strings.NewReader(tampered),
+		models.ImportOptions{DedupeByContent: true, Mapping: &mappingOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := models.ReadIdMapping(&mappingOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mapping, report.Mapping) {
+		t.Errorf("mapping written = %+v, reported %+v", mapping, report.Mapping)
+	}
+
+	wantStatuses := []models.MappingStatus{models.MappingCreated, models.MappingDuplicate, models.MappingFailed, models.MappingCreated}
+	if len(mapping) != len(wantStatuses) {
+		t.Fatalf("mapping has %d entries, want one per record: %+v", len(mapping), mapping)
+	}
+	for i, entry := range mapping {
+		if entry.Status != wantStatuses[i] {
+			t.Errorf("entry %d (%s) is %s, want %s", i, entry.SourceKey, entry.Status, wantStatuses[i])
+		}
+		if entry.Status == models.MappingFailed {
+			if entry.Ref != nil || entry.Error == "" {
+				t.Errorf("failed entry %+v should have an error and no ref", entry)
+			}
+			continue
+		}
+		from := sourceRef(t, entry.SourceKey)
+		original, err := source.GetNote(from.Notebook, from.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := target.GetNote(entry.Ref.Notebook, entry.Ref.Id)
+		if err != nil {
+			t.Fatalf("fetching %s mapped from %s: %v", entry.Ref, entry.SourceKey, err)
+		}
+		if imported.Content != original.Content {
+			t.Errorf("%s mapped onto %s, which has '%s', want '%s'", entry.SourceKey, entry.Ref, imported.Content, original.Content)
+		}
+	}
+	if ref := mapping[1].Ref; ref == nil || *ref != (models.NoteRef{Notebook: "inbox", Id: existing.Id}) {
+		t.Errorf("duplicate mapped onto %v, want the existing note inbox/%d", ref, existing.Id)
+	}
+}
+
+const mappingENEX = `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+<note><title>Groceries</title><content><![CDATA[<en-note><div>milk</div></en-note>]]></content><created>20240101T090000Z</created></note>
+<note><title>Plumber</title><content><![CDATA[<en-note><div>call on monday</div></en-note>]]></content><created>20240102T090000Z</created></note>
+</en-export>`
+
+func TestImportENEXMappingRoundTrip(t *testing.T) {
+	db := notestest.NewDB(t)
+	report, err := db.ImportENEX("evernote", strings.NewReader(mappingENEX), models.ENEXOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// persisted and read back as another tool would
+	var persisted bytes.Buffer
+	if err := models.WriteIdMapping(&persisted, report.Mapping); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := models.ReadIdMapping(&persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapping) != 2 {
+		t.Fatalf("mapping has %d entries, want 2", len(mapping))
+	}
+	for i, want := range []string{"Groceries\n\nmilk", "Plumber\n\ncall on monday"} {
+		entry := mapping[i]
+		if entry.Status != models.MappingCreated || !strings.HasPrefix(entry.SourceKey, "enex:") || entry.Ref == nil {
+			t.Fatalf("entry %d = %+v", i, entry)
+		}
+		note, err := db.GetNote(entry.Ref.Notebook, entry.Ref.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(note.Content) != want {
+			t.Errorf("%s mapped onto a note with %q, want %q", entry.SourceKey, note.Content, want)
+		}
+	}
+
+	// the same records map onto the same keys, and onto the notes already imported
+	again, err := db.ImportENEX("evernote", strings.NewReader(mappingENEX), models.ENEXOptions{DedupeByContent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range again.Mapping {
+		if entry.SourceKey != mapping[i].SourceKey || entry.Status != models.MappingDuplicate || *entry.Ref != *mapping[i].Ref {
+			t.Errorf("re-imported entry %d = %+v, want a duplicate of %+v", i, entry, mapping[i])
+		}
+	}
+}
+
+func TestReadIdMappingRejectsOtherFormats(t *testing.T) {
+	if _, err := models.ReadIdMapping(strings.NewReader(`{"format": 2, "entries": []}`)); err == nil {
+		t.Error("mapping of an unknown format read")
+	}
+}
@@ -0,0 +1,504 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Indexes kept along with notes (tags, titles, URLs and fingerprints) are verified in the background
+ * by the index maintainer (see StartIndexMaintainer), so that entries gone wrong get repaired without
+ * rebuilding whole indexes
+ *  - each cycle checks a bounded slice of notes against their index entries, and index entries within
+ *    the slice against the notes (catching entries of notes that are gone), resuming where the previous
+ *    cycle stopped: the position is persisted in 'Meta' bucket (under 'index_maintenance' key), so that
+ *    passes over the DB carry on across restarts
+ *  - notes are checked in read transactions of maintenanceBatchSize notes; discrepancies a batch found
+ *    are checked again and repaired in a write transaction of its own
+ *  - a cycle stops once its time budget is spent, and is skipped (or cut short) while bulk imports and
+ *    migrations run (see markBusy)
+ *  - counts of tags (see SuggestTags) aren't checked, but are adjusted as entries get repaired; records
+ *    that can't be read are left to CheckIntegrity
+ */
+
+/**
+ * Number of notes a cycle of the index maintainer checks at most
+ */
+const maintenanceSampleSize = 1000
+
+/**
+ * Number of notes checked per transaction by the index maintainer
+ */
+const maintenanceBatchSize = 100
+
+/**
+ * What the index maintainer did since it was started, as returned by MaintenanceStats
+ *  - SkippedCycles: cycles skipped as bulk work was running
+ *  - Passes: passes completed over all notes
+ *  - Discrepancies: index entries found disagreeing with notes (by index), Repaired: those repaired
+ */
+type MaintenanceStats struct {
+	Running           bool           `json:"running"`
+	Cycles            int            `json:"cycles"`
+	SkippedCycles     int            `json:"skipped_cycles"`
+	Passes            int            `json:"passes"`
+	NotesChecked      int            `json:"notes_checked"`
+	Discrepancies     map[string]int `json:"discrepancies,omitempty"`
+	Repaired          int            `json:"repaired"`
+	LastCycleAt       time.Time      `json:"last_cycle_at,omitempty"`
+	LastCycleDuration time.Duration  `json:"last_cycle_duration,omitempty"`
+	LastError         string         `json:"last_error,omitempty"`
+}
+
+/**
+ * State of the index maintainer, as kept by the DB
+ */
+type indexMaintenance struct {
+	mu    sync.Mutex
+	stats MaintenanceStats
+}
+
+/**
+ * Index entries of a note disagreeing with it (or left behind by a note that's gone)
+ */
+type indexFinding struct {
+	notebookKey []byte
+	noteKey     []byte
+	indexes     []string
+}
+
+/**
+ * Starts a background goroutine verifying (and repairing) indexes every interval, spending at most
+ * budget per cycle (see above)
+ * Returned stop func stops the goroutine and waits for a cycle in progress to finish;
+ * it is safe to call it more than once, and it is called by Close too
+ * param: time.Duration interval
+ * param: time.Duration budget
+ * return: func()
+ */
+func (db *DB) StartIndexMaintainer(interval time.Duration, budget time.Duration) (stop func()) {
+	db.updateMaintenanceStats(func(stats *MaintenanceStats) {
+		stats.Running = true
+	})
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.runIndexMaintenance(budget)
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+			db.updateMaintenanceStats(func(stats *MaintenanceStats) {
+				stats.Running = false
+			})
+		})
+	}
+	db.onClose(stop)
+	return stop
+}
+
+/**
+ * What the index maintainer did since it was started
+ * return: MaintenanceStats
+ */
+func (db *DB) MaintenanceStats() MaintenanceStats {
+	db.maintenance.mu.Lock()
+	defer db.maintenance.mu.Unlock()
+	stats := db.maintenance.stats
+	stats.Discrepancies = make(map[string]int)
+	for index, n := range db.maintenance.stats.Discrepancies {
+		stats.Discrepancies[index] = n
+	}
+	return stats
+}
+
+func (db *DB) updateMaintenanceStats(update func(*MaintenanceStats)) {
+	db.maintenance.mu.Lock()
+	defer db.maintenance.mu.Unlock()
+	if db.maintenance.stats.Discrepancies == nil {
+		db.maintenance.stats.Discrepancies = make(map[string]int)
+	}
+	update(&db.maintenance.stats)
+}
+
+/**
+ * Marks bulk work (like an import or a migration) as running until the returned func is called:
+ * the index maintainer stays out of its way, as it rewrites index entries anyway
+ */
+func (db *DB) markBusy() func() {
+	atomic.AddInt32(&db.busy, 1)
+	return func() {
+		atomic.AddInt32(&db.busy, -1)
+	}
+}
+
+func (db *DB) isBusy() bool {
+	return atomic.LoadInt32(&db.busy) > 0
+}
+
+/**
+ * Performs a single cycle of index maintenance
+ */
+func (db *DB) runIndexMaintenance(budget time.Duration) {
+	if db.isBusy() {
+		db.updateMaintenanceStats(func(stats *MaintenanceStats) {
+			stats.SkippedCycles++
+		})
+		return
+	}
+	start := time.Now()
+	var started []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("Meta")); bucket != nil {
+			started = append([]byte(nil), bucket.Get([]byte("index_maintenance"))...)
+		}
+		return nil
+	})
+	cursor := started
+
+	checked, passes, repaired := 0, 0, 0
+	discrepancies := make(map[string]int)
+	for err == nil && checked < maintenanceSampleSize && time.Since(start) < budget && !db.isBusy() {
+		var findings []indexFinding
+		var n int
+		var next []byte
+		limit := maintenanceSampleSize - checked
+		if limit > maintenanceBatchSize {
+			limit = maintenanceBatchSize
+		}
+		if err = db.View(func(tx *bolt.Tx) error {
+			findings, n, next = checkIndexSlice(tx, cursor, limit)
+			return nil
+		}); err != nil {
+			break
+		}
+		checked += n
+		if len(findings) > 0 && !db.isBusy() {
+			var fixed int
+			if fixed, err = db.repairIndexes(findings, discrepancies); err != nil {
+				break
+			}
+			repaired += fixed
+		}
+		cursor = next
+		if next == nil {
+			// (the next cycle starts over)
+			passes++
+			break
+		}
+	}
+	if err == nil && !bytes.Equal(cursor, started) {
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+			if err != nil {
+				return err
+			}
+			if cursor == nil {
+				return bucket.Delete([]byte("index_maintenance"))
+			}
+			return bucket.Put([]byte("index_maintenance"), cursor)
+		})
+	}
+
+	db.updateMaintenanceStats(func(stats *MaintenanceStats) {
+		stats.Cycles++
+		stats.Passes += passes
+		stats.NotesChecked += checked
+		stats.Repaired += repaired
+		for index, n := range discrepancies {
+			stats.Discrepancies[index] += n
+		}
+		stats.LastCycleAt, stats.LastCycleDuration = start, time.Since(start)
+		if err != nil {
+			stats.LastError = err.Error()
+		}
+	})
+	if err != nil {
+		db.logf("index maintainer: cycle failed: %v", err)
+	}
+}
+
+/**
+ * Checks index entries of up to limit notes following cursor (notebook key, NUL, note key; nil to start
+ * from the first note)
+ * return: ([]indexFinding, int, []byte) Discrepancies, number of notes checked and cursor to carry on
+ *         from (nil once past the last note)
+ */
+func checkIndexSlice(tx *bolt.Tx, cursor []byte, limit int) ([]indexFinding, int, []byte) {
+	var findings []indexFinding
+	checked := 0
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	var afterNotebook, afterNote []byte
+	if separator := bytes.LastIndexByte(cursor, 0); separator >= 0 {
+		afterNotebook, afterNote = cursor[:separator], cursor[separator+1:]
+	}
+
+	notebooks := rootBucket.Cursor()
+	notebookKey, _ := notebooks.First()
+	if afterNotebook != nil {
+		if notebookKey, _ = notebooks.Seek(afterNotebook); !bytes.Equal(notebookKey, afterNotebook) {
+			afterNote = nil
+		}
+	}
+	for ; notebookKey != nil; notebookKey, _ = notebooks.Next() {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			afterNote = nil
+			continue
+		}
+		notes := notebookBucket.Cursor()
+		k, v := notes.First()
+		if len(afterNote) > 0 {
+			if k, v = notes.Seek(afterNote); bytes.Equal(k, afterNote) {
+				k, v = notes.Next()
+			}
+		}
+		var last []byte
+		for ; k != nil && checked < limit; k, v = notes.Next() {
+			if v == nil {
+				continue
+			}
+			checked++
+			last = append([]byte(nil), k...)
+			if indexes := checkNoteIndexes(tx, notebookKey, k, v); len(indexes) > 0 {
+				findings = append(findings, indexFinding{notebookKey: append([]byte(nil), notebookKey...), noteKey: last, indexes: indexes})
+			}
+		}
+		// index entries of the range just checked (to the end of the notebook if it's done with)
+		until := last
+		if k == nil {
+			until = nil
+		}
+		findings = append(findings, orphanIndexEntries(tx, notebookKey, notebookBucket, afterNote, until)...)
+		if k != nil || checked >= limit {
+			if last == nil {
+				last = afterNote
+			}
+			return findings, checked, append(append(append([]byte(nil), notebookKey...), 0), last...)
+		}
+		afterNote = nil
+	}
+	return findings, checked, nil
+}
+
+/**
+ * Names of the indexes whose entries of a note disagree with its record
+ */
+func checkNoteIndexes(tx *bolt.Tx, notebookKey, key, record []byte) []string {
+	note, err := readNoteRecord(tx, contentSealer{}, notebookKey, record)
+	sealed := contentLocked(err)
+	if err != nil && !sealed {
+		return nil
+	}
+	noteId, err := strconv.ParseUint(string(key), 10, 64)
+	if err != nil {
+		return nil
+	}
+	var indexes []string
+
+	var indexedTags []string
+	if notes := suggestionBucket(tx, "TagIndex", notebookKey, "notes"); notes != nil && notes.Get(key) != nil {
+		if json.Unmarshal(notes.Get(key), &indexedTags) != nil {
+			indexedTags = nil
+		}
+	}
+	if !equalStrings(indexedTags, distinctTags(note.Tags)) {
+		indexes = append(indexes, "tags")
+	}
+
+	var titleKey, indexedTitle []byte
+	if notes := suggestionBucket(tx, "TitleIndex", notebookKey, "notes"); notes != nil {
+		titleKey = notes.Get(key)
+	}
+	if recent := suggestionBucket(tx, "TitleIndex", notebookKey, "recent"); recent != nil && titleKey != nil {
+		indexedTitle = recent.Get(titleKey)
+	}
+	if note.TitleText == "" && titleKey != nil ||
+		note.TitleText != "" && (!bytes.Equal(titleKey, titleIndexKey(noteId, lastChangedAt(note))) || string(indexedTitle) != note.TitleText) {
+		indexes = append(indexes, "titles")
+	}
+
+	var indexedURLs, urls []string
+	if bucket := tx.Bucket([]byte("URLs")); bucket != nil && bucket.Bucket(notebookKey) != nil {
+		if encoded := bucket.Bucket(notebookKey).Get(key); encoded != nil && json.Unmarshal(encoded, &indexedURLs) != nil {
+			indexedURLs = nil
+		}
+	}
+	if !sealed {
+		// (URLs of encrypted content aren't indexed)
+		urls = extractURLs(note.Content)
+	}
+	if !equalStrings(indexedURLs, urls) {
+		indexes = append(indexes, "urls")
+	}
+
+	var indexedFingerprint []byte
+	if notes := suggestionBucket(tx, "Fingerprints", notebookKey, "notes"); notes != nil {
+		indexedFingerprint = notes.Get(key)
+	}
+	if string(indexedFingerprint) != note.Fingerprint || note.Fingerprint != "" && !fingerprintIndexed(tx, notebookKey, note.Fingerprint, noteId) {
+		indexes = append(indexes, "fingerprints")
+	}
+	return indexes
+}
+
+/**
+ * Index entries of notes that don't exist, with keys after from (from the first if nil) up to until
+ * (to the last if nil)
+ */
+func orphanIndexEntries(tx *bolt.Tx, notebookKey []byte, notebookBucket *bolt.Bucket, from, until []byte) []indexFinding {
+	orphans := make(map[string][]string)
+	var keys []string
+	buckets := map[string]*bolt.Bucket{
+		"tags":         suggestionBucket(tx, "TagIndex", notebookKey, "notes"),
+		"titles":       suggestionBucket(tx, "TitleIndex", notebookKey, "notes"),
+		"fingerprints": suggestionBucket(tx, "Fingerprints", notebookKey, "notes"),
+	}
+	if bucket := tx.Bucket([]byte("URLs")); bucket != nil {
+		buckets["urls"] = bucket.Bucket(notebookKey)
+	}
+	for _, index := range []string{"tags", "titles", "urls", "fingerprints"} {
+		bucket := buckets[index]
+		if bucket == nil {
+			continue
+		}
+		cursor := bucket.Cursor()
+		k, _ := cursor.First()
+		if len(from) > 0 {
+			if k, _ = cursor.Seek(from); bytes.Equal(k, from) {
+				k, _ = cursor.Next()
+			}
+		}
+		for ; k != nil && (until == nil || bytes.Compare(k, until) <= 0); k, _ = cursor.Next() {
+			if notebookBucket.Get(k) != nil {
+				continue
+			}
+			if _, ok := orphans[string(k)]; !ok {
+				keys = append(keys, string(k))
+			}
+			orphans[string(k)] = append(orphans[string(k)], index)
+		}
+	}
+	var findings []indexFinding
+	for _, k := range keys {
+		findings = append(findings, indexFinding{notebookKey: append([]byte(nil), notebookKey...), noteKey: []byte(k), indexes: orphans[k]})
+	}
+	return findings
+}
+
+/**
+ * Repairs index entries found disagreeing with notes, checking them again first (as notes may have
+ * changed since), in a single write transaction
+ * return: (int, error) Number of notes whose entries were repaired
+ */
+func (db *DB) repairIndexes(findings []indexFinding, discrepancies map[string]int) (int, error) {
+	var repaired []string
+	err := db.Update(func(tx *bolt.Tx) error {
+		repaired = nil
+		for _, finding := range findings {
+			notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(finding.notebookKey)
+			noteId, err := strconv.ParseUint(string(finding.noteKey), 10, 64)
+			if notebookBucket == nil || err != nil {
+				continue
+			}
+			ref := NoteRef{Notebook: notebookDisplayName(tx, finding.notebookKey), Id: noteId}
+			record := notebookBucket.Get(finding.noteKey)
+			if record == nil {
+				if err := putSuggestions(tx, finding.notebookKey, Note{Id: noteId}); err != nil {
+					return err
+				}
+				if err := putFingerprint(tx, finding.notebookKey, noteId, ""); err != nil {
+					return err
+				}
+				if err := putURLs(tx, finding.notebookKey, noteId, nil); err != nil {
+					return err
+				}
+				repaired = append(repaired, ref.String()+" (gone): "+strings.Join(finding.indexes, ", "))
+				continue
+			}
+			indexes := checkNoteIndexes(tx, finding.notebookKey, finding.noteKey, record)
+			if len(indexes) == 0 {
+				continue
+			}
+			note, err := readNoteRecord(tx, contentSealer{}, finding.notebookKey, record)
+			sealed := contentLocked(err)
+			if err != nil && !sealed {
+				continue
+			}
+			note.Id = noteId
+			for _, index := range indexes {
+				switch index {
+				case "tags", "titles":
+					err = putSuggestions(tx, finding.notebookKey, note)
+				case "urls":
+					var urls []string
+					if !sealed {
+						urls = extractURLs(note.Content)
+					}
+					err = putURLs(tx, finding.notebookKey, noteId, urls)
+				case "fingerprints":
+					if err = putFingerprint(tx, finding.notebookKey, noteId, ""); err == nil {
+						err = putFingerprint(tx, finding.notebookKey, noteId, note.Fingerprint)
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
+			repaired = append(repaired, ref.String()+": "+strings.Join(indexes, ", "))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, finding := range findings {
+		for _, index := range finding.indexes {
+			discrepancies[index]++
+		}
+	}
+	for _, entry := range repaired {
+		db.logf("index maintainer: repaired index entries of %s", entry)
+	}
+	return len(repaired), nil
+}
+
+/**
+ * Whether the fingerprint index has an entry of given note under given fingerprint
+ */
+func fingerprintIndexed(tx *bolt.Tx, notebookKey []byte, fingerprint string, noteId uint64) bool {
+	fingerprints := suggestionBucket(tx, "Fingerprints", notebookKey, "fingerprints")
+	return fingerprints != nil && fingerprints.Get(append([]byte(fingerprint), itob(noteId)...)) != nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Inspection of DB files, for when something went wrong with one (see Inspect)
+ *  - the file is opened read-only, and nothing is created, migrated or even expected of it: it's
+ *    read as bolt buckets, and what's found is described along with what this version makes of it
+ *  - DBs carry no schema version: 'Meta' holds settings (whose presence tells which features were used),
+ *    and what's inspected is what buckets there are
+ */
+
+/**
+ * Role of a top-level bucket, as per this version of the package
+ */
+type BucketKind string
+
+const (
+	// the 'Notebook' bucket
+	BucketNotes BucketKind = "notes"
+	// data stored along with notes (history, chunks, attachments ..)
+	BucketAuxiliary BucketKind = "auxiliary"
+	// data derived from notes, which can be rebuilt
+	BucketIndex BucketKind = "index"
+	// settings and metadata
+	BucketSettings BucketKind = "settings"
+	// buckets this version knows nothing of (like ones of newer versions)
+	BucketUnknown BucketKind = "unknown"
+)
+
+/**
+ * Kinds of the top-level buckets this version writes
+ */
+var bucketKinds = map[string]BucketKind{
+	"Notebook":       BucketNotes,
+	"History":        BucketAuxiliary,
+	"Chunks":         BucketAuxiliary,
+	"Attachments":    BucketAuxiliary,
+	"Blobs":          BucketAuxiliary,
+	"BlobMeta":       BucketAuxiliary,
+	"Uploads":        BucketAuxiliary,
+	"UndoLog":        BucketAuxiliary,
+	"Quarantine":     BucketAuxiliary,
+	"Relations":      BucketAuxiliary,
+	"Snapshots":      BucketAuxiliary,
+	"Rollups":        BucketAuxiliary,
+	"Outbox":         BucketAuxiliary,
+	"Reservations":   BucketAuxiliary,
+	"NoteShares":     BucketAuxiliary,
+	"Favorites":      BucketAuxiliary,
+	"SlowLog":        BucketAuxiliary,
+	"URLs":           BucketIndex,
+	"Stats":          BucketIndex,
+	"Access":         BucketIndex,
+	"NoteShareIndex": BucketIndex,
+	"TagIndex":       BucketIndex,
+	"TitleIndex":     BucketIndex,
+	"Fingerprints":   BucketIndex,
+	"Meta":           BucketSettings,
+	"NotebookMeta":   BucketSettings,
+	"Rules":          BucketSettings,
+	"APITokens":      BucketSettings,
+	"Templates":      BucketSettings,
+	"SyncState":      BucketSettings,
+	"Recurring":      BucketSettings,
+}
+
+/**
+ * What Inspect found in a DB file
+ *  - Meta holds the keys of the 'Meta' bucket with their (JSON) values
+ */
+type InspectReport struct {
+	Path      string            `json:"path"`
+	FileSize  int64             `json:"file_size"`
+	PageSize  int               `json:"page_size"`
+	TxId      int               `json:"tx_id"`
+	FreePages int               `json:"free_pages"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Buckets   []BucketReport    `json:"buckets"`
+	Notebooks []NotebookReport  `json:"notebooks"`
+}
+
+/**
+ * A top-level bucket
+ *  - Keys counts the keys of the bucket and of the buckets nested in it, and Bytes adds up their
+ *    keys and values (leaving out bolt's own overhead)
+ */
+type BucketReport struct {
+	Name  string     `json:"name"`
+	Kind  BucketKind `json:"kind"`
+	Keys  int        `json:"keys"`
+	Bytes int        `json:"bytes"`
+}
+
+/**
+ * A notebook (a bucket nested in 'Notebook')
+ *  - KeyFormat is "string" for decimal note ids (as this version writes them), "binary" for 8 byte
+ *    big endian ones, "unknown" for neither (or for an empty notebook) and "mixed" for keys of several formats
+ *  - MinId and MaxId are the smallest and largest note ids read from keys of either format
+ */
+type NotebookReport struct {
+	Key       string `json:"key"`
+	Notes     int    `json:"notes"`
+	Sequence  uint64 `json:"sequence"`
+	MinId     uint64 `json:"min_id"`
+	MaxId     uint64 `json:"max_id"`
+	KeyFormat string `json:"key_format"`
+	// keys holding nested buckets instead of notes
+	Buckets int `json:"buckets,omitempty"`
+}
+
+/**
+ * Inspects a DB file without changing it (see InspectReport)
+ * Fails with a *DatabaseLockedError if another process keeps the file open for writing
+ * param: string path
+ * return: (InspectReport, error)
+ */
+func Inspect(path string) (InspectReport, error) {
+	report := InspectReport{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return report, err
+	}
+	report.FileSize = info.Size()
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: DefaultOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return report, &DatabaseLockedError{Path: path, Holder: readLockInfo(path)}
+	}
+	if err != nil {
+		return report, err
+	}
+	defer db.Close()
+	report.PageSize = db.Info().PageSize
+
+	err = db.View(func(tx *bolt.Tx) error {
+		report.TxId = tx.ID()
+		report.FreePages = db.Stats().FreePageN
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			kind, ok := bucketKinds[string(name)]
+			if !ok {
+				kind = BucketUnknown
+			}
+			bucketReport := BucketReport{Name: string(name), Kind: kind}
+			measureBucket(bucket, &bucketReport)
+			report.Buckets = append(report.Buckets, bucketReport)
+			switch string(name) {
+			case "Meta":
+				report.Meta = inspectMeta(bucket)
+			case "Notebook":
+				report.Notebooks = inspectNotebooks(bucket)
+			}
+			return nil
+		})
+	})
+	return report, err
+}
+
+/**
+ * Adds up keys of a bucket and of the buckets nested in it, along with their sizes
+ */
+func measureBucket(bucket *bolt.Bucket, report *BucketReport) {
+	bucket.ForEach(func(k, v []byte) error {
+		report.Keys++
+		report.Bytes += len(k) + len(v)
+		if v == nil {
+			if nested := bucket.Bucket(k); nested != nil {
+				measureBucket(nested, report)
+			}
+		}
+		return nil
+	})
+}
+
+func inspectMeta(bucket *bolt.Bucket) map[string]string {
+	meta := make(map[string]string)
+	bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			v = []byte("(bucket)")
+		}
+		meta[string(k)] = string(v)
+		return nil
+	})
+	return meta
+}
+
+func inspectNotebooks(rootBucket *bolt.Bucket) []NotebookReport {
+	var notebooks []NotebookReport
+	rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			// (a stray value: not a notebook)
+			return nil
+		}
+		notebook := NotebookReport{Key: string(notebookKey), Sequence: notebookBucket.Sequence()}
+		stringKeys, binaryKeys := 0, 0
+		notebookBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				notebook.Buckets++
+				return nil
+			}
+			notebook.Notes++
+			id, err := strconv.ParseUint(string(k), 10, 64)
+			switch {
+			case err == nil:
+				stringKeys++
+			case len(k) == 8:
+				id = binary.BigEndian.Uint64(k)
+				binaryKeys++
+			default:
+				return nil
+			}
+			if notebook.MinId == 0 || id < notebook.MinId {
+				notebook.MinId = id
+			}
+			if id > notebook.MaxId {
+				notebook.MaxId = id
+			}
+			return nil
+		})
+		otherKeys := notebook.Notes - stringKeys - binaryKeys
+		switch {
+		case stringKeys > 0 && (binaryKeys > 0 || otherKeys > 0), binaryKeys > 0 && otherKeys > 0:
+			notebook.KeyFormat = "mixed"
+		case stringKeys > 0:
+			notebook.KeyFormat = "string"
+		case binaryKeys > 0:
+			notebook.KeyFormat = "binary"
+		default:
+			notebook.KeyFormat = "unknown"
+		}
+		notebooks = append(notebooks, notebook)
+		return nil
+	})
+	return notebooks
+}
@@ -0,0 +1,254 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * A problem found by CheckIntegrity / Repair
+ *  - Ref is the note concerned; Blob the hash of the blob concerned (for problems of blobs)
+ *  - Fixed is set by Repair for problems it fixed
+ */
+type IntegrityProblem struct {
+	Ref     NoteRef `json:"ref"`
+	Blob    string  `json:"blob,omitempty"`
+	Problem string  `json:"problem"`
+	Fixed   bool    `json:"fixed,omitempty"`
+}
+
+func (p IntegrityProblem) String() string {
+	if p.Blob != "" {
+		return "blob " + p.Blob + ": " + p.Problem
+	}
+	return p.Ref.String() + ": " + p.Problem
+}
+
+/**
+ * Verifies that the DB is consistent
+ *  - every note record can be parsed
+ *  - every note stored in chunks has all of its chunks, adding up to its recorded size and hash
+ *  - no chunks or attachments are left without their note
+ *  - every attachment refers to a stored blob, and reference counts of blobs match the attachments
+ * return: ([]IntegrityProblem, error) Problems found (none if the DB is consistent)
+ */
+func (db *DB) CheckIntegrity() ([]IntegrityProblem, error) {
+	var problems []IntegrityProblem
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		problems, err = checkIntegrityInTx(tx, false)
+		return err
+	})
+	return problems, err
+}
+
+/**
+ * Fixes problems found by CheckIntegrity where possible
+ *  - corrupt note records are quarantined (see QuarantineCorrupt)
+ *  - leftover chunks and attachments are removed, as are attachments of missing blobs
+ *  - reference counts of blobs are set to the actual number of references; unreferenced blobs are removed
+ * Missing content can't be recovered: notes with chunks missing are reported, but left alone
+ * return: ([]IntegrityProblem, error) Problems found (those fixed marked as such)
+ */
+func (db *DB) Repair() ([]IntegrityProblem, error) {
+	var problems []IntegrityProblem
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		problems, err = checkIntegrityInTx(tx, true)
+		return err
+	})
+	return problems, err
+}
+
+/**
+ * Core logic of CheckIntegrity and Repair
+ * Fixes are collected while scanning and applied afterwards, as bolt doesn't allow
+ * modifying buckets being iterated with ForEach
+ */
+func checkIntegrityInTx(tx *bolt.Tx, repair bool) ([]IntegrityProblem, error) {
+	var problems []IntegrityProblem
+	var fixes []func() error
+	report := func(problem IntegrityProblem, fix func() error) {
+		if repair && fix != nil {
+			fixes = append(fixes, fix)
+			problem.Fixed = true
+		}
+		problems = append(problems, problem)
+	}
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	noteExists := func(notebookKey []byte, noteIdBytes []byte) bool {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		return notebookBucket != nil && notebookBucket.Get(noteIdBytes) != nil
+	}
+	noteRef := func(notebookKey []byte, noteIdBytes []byte) NoteRef {
+		noteId, _ := strconv.ParseUint(string(noteIdBytes), 10, 64)
+		return NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: noteId}
+	}
+
+	// notes stored in chunks
+	err := rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(noteIdBytes, encodedNote []byte) error {
+			if encodedNote == nil {
+				return nil
+			}
+			var note Note
+			if err := json.Unmarshal(encodedNote, &note); err != nil {
+				bucketKey := append([]byte(nil), notebookKey...)
+				key, record := append([]byte(nil), noteIdBytes...), append([]byte(nil), encodedNote...)
+				report(IntegrityProblem{Ref: noteRef(notebookKey, noteIdBytes), Problem: fmt.Sprintf("note record is corrupt: %v", err)},
+					func() error { return quarantineRecord(tx, bucketKey, key, record) })
+				return nil
+			}
+			if note.Chunks == nil {
+				return nil
+			}
+			if problem := checkChunks(tx, notebookKey, note); problem != "" {
+				report(IntegrityProblem{Ref: noteRef(notebookKey, noteIdBytes), Problem: problem}, nil)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// leftover chunks
+	err = forEachNoteBucket(tx, "Chunks", func(notebookKey, noteIdBytes []byte, parent *bolt.Bucket) error {
+		if !noteExists(notebookKey, noteIdBytes) {
+			key := append([]byte(nil), noteIdBytes...)
+			report(IntegrityProblem{Ref: noteRef(notebookKey, noteIdBytes), Problem: "chunks without a note"},
+				func() error { return parent.DeleteBucket(key) })
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// attachments, counting references to blobs along the way
+	refs := make(map[string]uint64)
+	err = forEachNoteBucket(tx, "Attachments", func(notebookKey, noteIdBytes []byte, parent *bolt.Bucket) error {
+		ref := noteRef(notebookKey, noteIdBytes)
+		if !noteExists(notebookKey, noteIdBytes) {
+			key := append([]byte(nil), noteIdBytes...)
+			report(IntegrityProblem{Ref: ref, Problem: "attachments without a note"},
+				func() error { return parent.DeleteBucket(key) })
+			return nil
+		}
+		attachmentsBucket := parent.Bucket(noteIdBytes)
+		return attachmentsBucket.ForEach(func(name, v []byte) error {
+			var attachment Attachment
+			if err := json.Unmarshal(v, &attachment); err != nil {
+				return err
+			}
+			if blobBucket(tx, attachment.Hash) == nil {
+				key := append([]byte(nil), name...)
+				report(IntegrityProblem{Ref: ref, Problem: fmt.Sprintf("attachment '%s' refers to missing blob %s", name, attachment.Hash)},
+					func() error { return attachmentsBucket.Delete(key) })
+				return nil
+			}
+			refs[attachment.Hash]++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// blobs
+	if blobsBucket := tx.Bucket([]byte("Blobs")); blobsBucket != nil {
+		err = blobsBucket.ForEach(func(hashBytes, _ []byte) error {
+			hash := string(hashBytes)
+			meta, ok := getBlobMeta(tx, hash)
+			switch {
+			case refs[hash] == 0:
+				report(IntegrityProblem{Blob: hash, Problem: "blob is not referenced"},
+					func() error { return deleteBlob(tx, hash) })
+				return nil
+			case !ok:
+				report(IntegrityProblem{Blob: hash, Problem: "blob has no metadata"},
+					func() error { return putBlobMeta(tx, hash, rebuildBlobMeta(tx, hash, refs[hash])) })
+				return nil
+			case meta.Refs != refs[hash]:
+				report(IntegrityProblem{Blob: hash, Problem: fmt.Sprintf("blob has %d references recorded, %d actual", meta.Refs, refs[hash])},
+					func() error {
+						meta.Refs = refs[hash]
+						return putBlobMeta(tx, hash, meta)
+					})
+			}
+			if problem := checkBlob(tx, hash, meta); problem != "" {
+				report(IntegrityProblem{Blob: hash, Problem: problem}, nil)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fix := range fixes {
+		if err := fix(); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+/**
+ * Calls fn for every per-note sub-bucket of a notebook-keyed bucket (like 'Chunks'),
+ * passing the parent bucket the note's bucket lives in
+ */
+func forEachNoteBucket(tx *bolt.Tx, bucketName string, fn func(notebookKey, noteIdBytes []byte, parent *bolt.Bucket) error) error {
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := bucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(noteIdBytes, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			return fn(notebookKey, noteIdBytes, notebookBucket)
+		})
+	})
+}
+
+/**
+ * Describes what's wrong with the content of a blob ("" if nothing)
+ */
+func checkBlob(tx *bolt.Tx, hash string, meta blobMeta) string {
+	content := readBlob(tx, hash)
+	switch {
+	case content == nil && meta.Size > 0:
+		return "blob content is incomplete"
+	case int64(len(content)) != meta.Size:
+		return fmt.Sprintf("blob content has %d bytes instead of %d", len(content), meta.Size)
+	case contentHash(string(content)) != hash:
+		return "blob content doesn't match its hash"
+	}
+	return ""
+}
+
+/**
+ * Recreates metadata of a blob from its chunks
+ */
+func rebuildBlobMeta(tx *bolt.Tx, hash string, refs uint64) blobMeta {
+	meta := blobMeta{Refs: refs}
+	blobBucket(tx, hash).ForEach(func(_, chunk []byte) error {
+		meta.Chunks++
+		meta.Size += int64(len(chunk))
+		return nil
+	})
+	return meta
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+ * Starts a background goroutine that periodically performs housekeeping
+ * (purging expired notes, pruning auxiliary buckets as per the retention policy, see retention.go, and
+ * applying notebook policies and running recurring rules if scheduled, see notebook_policies.go and recurring.go)
+ * Returned stop func stops the goroutine and waits for a run in progress to finish;
+ * it is safe to call it more than once, and it is called by Close too
+ * param: time.Duration interval
+ * return: func()
+ */
+func (db *DB) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.runJanitor()
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+	db.onClose(stop)
+	return stop
+}
+
+/**
+ * Performs a single round of housekeeping
+ */
+func (db *DB) runJanitor() {
+	if purged, err := db.PurgeExpired(); err != nil {
+		db.logf("janitor: purging expired notes failed: %v", err)
+	} else if purged > 0 {
+		db.logf("janitor: purged %d expired note(s)", purged)
+	}
+	if scheduled, err := db.PoliciesScheduled(); err == nil && scheduled {
+		if report, err := db.ApplyPolicies(false); err != nil {
+			db.logf("janitor: applying notebook policies failed: %v", err)
+		} else {
+			for _, notebook := range report.Notebooks {
+				if notebook.Archived+notebook.Deleted > 0 {
+					db.logf("janitor: notebook policy of '%s' archived %d and deleted %d note(s)",
+						notebook.Notebook, notebook.Archived, notebook.Deleted)
+				}
+			}
+		}
+	}
+	if scheduled, err := db.RecurringScheduled(); err == nil && scheduled {
+		if report, err := db.RunRecurring(time.Now()); err != nil {
+			db.logf("janitor: running recurring rules failed: %v", err)
+		} else {
+			for _, firing := range report.Fired {
+				db.logf("janitor: recurring rule '%s' created %d note(s) for %s", firing.Rule, len(firing.Notes), firing.Key)
+			}
+			for _, failure := range report.Failed {
+				db.logf("janitor: recurring rule '%s' couldn't fire: %s", failure.Rule, failure.Reason)
+			}
+		}
+	}
+	if policy, err := db.GetRetentionPolicy(); err != nil || !policy.prunes() {
+		return
+	}
+	if report, err := db.ApplyRetention(); err != nil {
+		db.logf("janitor: applying retention failed: %v", err)
+	} else if removed := report.Changelog + report.History + report.AccessLog; removed > 0 {
+		db.logf("janitor: retention removed %d changelog, %d history and %d access log record(s) in %v",
+			report.Changelog, report.History, report.AccessLog, report.Took)
+	}
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/**
+ * A note of a Google Keep takeout (one JSON file per note)
+ */
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Attachments []struct {
+		FilePath string `json:"filePath"`
+		Mimetype string `json:"mimetype"`
+	} `json:"attachments"`
+	IsTrashed               bool  `json:"isTrashed"`
+	IsPinned                bool  `json:"isPinned"`
+	IsArchived              bool  `json:"isArchived"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+/**
+ * Imports notes of a Google Keep takeout directory (like 'Takeout/Keep') into given notebook
+ *  - every '.json' file of the directory (and its subdirectories) is a note; the title
+ *    becomes the first line of the note, and checklists become '- [ ]' / '- [x]' tasks
+ *  - labels become tags; notes don't have pinned / archived flags, so pinned and archived
+ *    notes are tagged 'pinned' / 'archived' instead
+ *  - created / edited times (in microseconds) are carried over
+ *  - trashed notes are skipped unless IncludeTrashed is set
+ *  - attached files (looked up next to the JSON file) are attached to the imported note;
+ *    missing ones are reported as skipped
+ *  - corrupt files are skipped and reported; only a failing commit aborts the import
+ *  - the report maps every file read ('keep:' followed by its slash separated path within
+ *    the directory) onto the note it was imported as; it is written to opts.Mapping if set
+ * param: string        notebookName
+ * param: string        dir
+ * param: ImportOptions opts
+ * return: (ImportReport, error)
+ */
+func (db *DB) ImportKeepTakeout(notebookName, dir string, opts ImportOptions) (ImportReport, error) {
+	defer db.markBusy()()
+	var report ImportReport
+	batcher, err := db.newImportBatcher(notebookName, 0, opts.DedupeByContent, opts.OnConflict, &report)
+	if err != nil {
+		return report, err
+	}
+
+	// attachments are added once their note is committed (and has an id)
+	type pendingAttachment struct {
+		title, file string
+	}
+	pending := make(map[string][]pendingAttachment)
+	batcher.onAdded = func(sourceKey string, note Note) error {
+		for _, attachment := range pending[sourceKey] {
+			if err := db.attachFile(notebookName, note.Id, attachment.file); err != nil {
+				report.Skipped = append(report.Skipped, SkippedNote{
+					Title:  attachment.title,
+					Reason: fmt.Sprintf("attachment '%s': %v", filepath.Base(attachment.file), err),
+				})
+			}
+		}
+		delete(pending, sourceKey)
+		return nil
+	}
+
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.EqualFold(filepath.Ext(file), ".json") {
+			// unreadable entries are left out like any other non-note file
+			return nil
+		}
+		relative, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		sourceKey := "keep:" + filepath.ToSlash(relative)
+
+		encoded, err := ioutil.ReadFile(file)
+		if err != nil {
+			batcher.skip(relative, sourceKey, err)
+			return nil
+		}
+		var raw keepNote
+		if err := json.Unmarshal(encoded, &raw); err != nil {
+			batcher.skip(relative, sourceKey, fmt.Errorf("invalid note: %v", err))
+			return nil
+		}
+		title := raw.Title
+		if title == "" {
+			title = relative
+		}
+		if raw.IsTrashed && !opts.IncludeTrashed {
+			batcher.skip(title, sourceKey, errors.New("note is trashed"))
+			return nil
+		}
+		note, err := raw.toNote()
+		if err != nil {
+			batcher.skip(title, sourceKey, err)
+			return nil
+		}
+		record := ImportedRecord{Source: RecordSource{File: filepath.ToSlash(relative)}, SourceKey: sourceKey, Note: note}
+		attachmentFiles := make(map[string]string)
+		for _, attachment := range raw.Attachments {
+			if attachment.FilePath == "" {
+				continue
+			}
+			attachmentFile := filepath.Join(filepath.Dir(file), filepath.FromSlash(path.Clean("/"+attachment.FilePath)))
+			record.Attachments = append(record.Attachments, Attachment{Name: filepath.Base(attachmentFile)})
+			attachmentFiles[filepath.Base(attachmentFile)] = attachmentFile
+		}
+		note, attachments, ok, err := transformRecord(opts.Transform, record, &report)
+		if err != nil || !ok {
+			return err
+		}
+		for _, attachment := range attachments {
+			pending[sourceKey] = append(pending[sourceKey], pendingAttachment{title: title, file: attachmentFiles[attachment.Name]})
+		}
+		return batcher.add(note, sourceKey)
+	})
+	if err == nil {
+		err = batcher.flush()
+	}
+	if opts.Mapping != nil {
+		if mappingErr := WriteIdMapping(opts.Mapping, report.Mapping); err == nil {
+			err = mappingErr
+		}
+	}
+	return report, err
+}
+
+/**
+ * Converts a parsed Keep note into a Note
+ */
+func (n keepNote) toNote() (Note, error) {
+	var note Note
+	var lines []string
+	if title := strings.TrimSpace(n.Title); title != "" {
+		lines = append(lines, title, "")
+	}
+	if body := strings.TrimSpace(n.TextContent); body != "" {
+		lines = append(lines, body)
+	}
+	for _, item := range n.ListContent {
+		box := "- [ ] "
+		if item.IsChecked {
+			box = "- [x] "
+		}
+		lines = append(lines, box+strings.TrimSpace(item.Text))
+	}
+	note.Content = strings.TrimSpace(strings.Join(lines, "\n"))
+	if note.Content == "" && len(n.Attachments) == 0 {
+		return note, errors.New("note is empty")
+	}
+
+	for _, label := range n.Labels {
+		if tag := strings.TrimSpace(label.Name); tag != "" && !containsString(note.Tags, tag) {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+	if n.IsPinned && !containsString(note.Tags, "pinned") {
+		note.Tags = append(note.Tags, "pinned")
+	}
+	if n.IsArchived && !containsString(note.Tags, "archived") {
+		note.Tags = append(note.Tags, "archived")
+	}
+
+	if n.CreatedTimestampUsec > 0 {
+		note.CreatedAt = usecToTime(n.CreatedTimestampUsec)
+	}
+	if n.UserEditedTimestampUsec > 0 {
+		note.UpdatedAt = usecToTime(n.UserEditedTimestampUsec)
+		if note.CreatedAt.IsZero() {
+			note.CreatedAt = note.UpdatedAt
+		}
+	}
+	return note, nil
+}
+
+func usecToTime(usec int64) time.Time {
+	return time.Unix(usec/1e6, (usec%1e6)*1e3)
+}
+
+/**
+ * Attaches a file to a note under the file's name
+ */
+func (db *DB) attachFile(notebookName string, noteId uint64, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = db.AddAttachment(notebookName, noteId, filepath.Base(file), f)
+	return err
+}
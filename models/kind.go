@@ -0,0 +1,108 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes are of a kind, telling how their content is to be read
+ *  - content is validated against the kind on every write (JSON notes must hold valid JSON)
+ *  - rendering branches on the kind (see RenderHTML), and JSON notes (being machine-written)
+ *    are left out of search unless asked for (see IncludeJSON)
+ *  - records written before kinds existed read as KindText
+ */
+const (
+	KindText     = "text"
+	KindMarkdown = "markdown"
+	KindJSON     = "json"
+)
+
+var (
+	// returned when a note's kind isn't one of the Kind.. constants
+	ErrUnknownKind = errors.New("unknown note kind")
+	// returned when content doesn't match the note's kind (like invalid JSON for a JSON note)
+	ErrContentMismatch = errors.New("content doesn't match the note's kind")
+)
+
+/**
+ * Decodes a note, defaulting its kind to KindText for records written before kinds existed
+ */
+func (n *Note) UnmarshalJSON(data []byte) error {
+	// a type without methods, so that decoding into it doesn't recurse
+	type noteFields Note
+	fields := noteFields{Kind: KindText}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*n = Note(fields)
+	return nil
+}
+
+/**
+ * Fails with ErrUnknownKind / ErrContentMismatch if content can't be stored as given kind
+ */
+func validateKind(kind string, content string) error {
+	switch kind {
+	case KindText, KindMarkdown:
+		return nil
+	case KindJSON:
+		if !json.Valid([]byte(content)) {
+			return fmt.Errorf("%w: content isn't valid JSON", ErrContentMismatch)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: '%s'", ErrUnknownKind, kind)
+}
+
+/**
+ * Changes the kind of a note, validating its content against the new kind
+ * Fails with ErrNoteReadOnly if the note is locked read-only
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string kind
+ * return: error
+ */
+func (db *DB) SetNoteKind(notebookName string, noteId uint64, kind string) error {
+	if err := validateKind(kind, ""); errors.Is(err, ErrUnknownKind) {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		if err := checkWritable(notebookName, note, false); err != nil {
+			return err
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		if note.Kind == kind {
+			return nil
+		}
+		if err := validateKind(kind, note.Content); err != nil {
+			return fmt.Errorf("%w (note %d in notebook '%s')", err, noteId, notebookName)
+		}
+		note.Kind = kind
+		db.bumpRevision(&note)
+		return db.putNote(tx, db.notebookKey(notebookName), note)
+	})
+}
+
+/**
+ * Content of a note for display: JSON notes are pretty-printed, other notes are shown as they are
+ */
+func DisplayContent(note Note) string {
+	if note.Kind == KindJSON {
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(note.Content), "", "  ") == nil {
+			return indented.String()
+		}
+	}
+	return note.Content
+}
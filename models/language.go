@@ -0,0 +1,360 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Language of notes whose language can't be told (too short, or no language scoring clearly best)
+ */
+const UndeterminedLanguage = "und"
+
+/**
+ * Detects the language of text
+ *  - returns an ISO 639-1 code (like "en" or "hi"), or UndeterminedLanguage
+ *  - implementations must be safe for concurrent use
+ */
+type LanguageDetector interface {
+	Detect(text string) string
+}
+
+/**
+ * Sets the detector languages of notes are detected with when they are saved
+ * (the built-in trigram detector if nil)
+ * Notes saved before keep their language until RedetectLanguages is run
+ */
+func (db *DB) SetLanguageDetector(detector LanguageDetector) {
+	db.languageDetector = detector
+}
+
+func (db *DB) detector() LanguageDetector {
+	if db.languageDetector == nil {
+		return defaultLanguageDetector
+	}
+	return db.languageDetector
+}
+
+/**
+ * Reports how many notes of a notebook are written in each language
+ * Notes saved before languages were detected are detected on the fly (but not stored, see RedetectLanguages)
+ * param: string notebookName
+ * return: (map[string]int, error) Number of notes by language code (UndeterminedLanguage included)
+ */
+func (db *DB) DetectLanguages(notebookName string) (map[string]int, error) {
+	distribution := make(map[string]int)
+	detector := db.detector()
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(k, v []byte) error {
+			note, err := db.decodeNote(tx, notebookKey, k, v)
+			if db.skipCorrupt(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			distribution[languageOf(note, detector)]++
+			return nil
+		})
+	})
+	return distribution, err
+}
+
+/**
+ * Detects languages of all notes of a notebook afresh, storing those that changed
+ * (for notes saved before languages were detected, or after switching detectors)
+ * Revisions and update times of notes are left untouched, as their content doesn't change
+ * param: string notebookName
+ * return: (int, error) Number of notes whose language changed
+ */
+func (db *DB) RedetectLanguages(notebookName string) (int, error) {
+	changed := 0
+	detector := db.detector()
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		// collected first, as bolt doesn't allow modifying a bucket being iterated
+		var stale []Note
+		err := notebookBucket.ForEach(func(k, v []byte) error {
+			note, err := db.decodeNote(tx, notebookKey, k, v)
+			if db.skipCorrupt(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if language := detector.Detect(note.Content); language != note.Language {
+				stale = append(stale, note)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, note := range stale {
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return err
+			}
+		}
+		changed = len(stale)
+		return nil
+	})
+	return changed, err
+}
+
+/**
+ * Language of a note: the stored one, or the detected one for notes saved before languages were detected
+ */
+func languageOf(note Note, detector LanguageDetector) string {
+	if note.Language != "" {
+		return note.Language
+	}
+	return detector.Detect(note.Content)
+}
+
+/**
+ * Minimum number of letters a text needs for its language to be detected
+ */
+const minLanguageLetters = 20
+
+/**
+ * Minimum lead (in log likelihood per trigram) of the best matching trigram profile over the runner-up
+ */
+const minLanguageMargin = 0.05
+
+/**
+ * Languages told apart by the script they're written in alone
+ */
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Devanagari, "hi"},
+	{unicode.Bengali, "bn"},
+	{unicode.Gurmukhi, "pa"},
+	{unicode.Gujarati, "gu"},
+	{unicode.Tamil, "ta"},
+	{unicode.Telugu, "te"},
+	{unicode.Kannada, "kn"},
+	{unicode.Malayalam, "ml"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Thai, "th"},
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+}
+
+/**
+ * Built-in detector
+ *  - text mostly written in a script of scriptLanguages is taken to be in that script's language
+ *    (Han text containing any kana is Japanese)
+ *  - text mostly written in Latin script is compared with trigram profiles of languages
+ *    using it (by likelihood of the text's trigrams under each profile)
+ *  - text with fewer than minLanguageLetters letters, or whose best matching profile isn't
+ *    clearly ahead of the runner-up, is UndeterminedLanguage
+ */
+type trigramDetector struct {
+	profiles map[string]trigramProfile
+	// number of distinct trigrams of all profiles
+	vocabulary float64
+}
+
+/**
+ * Trigram counts of a language's sample text
+ */
+type trigramProfile struct {
+	counts map[string]float64
+	total  float64
+}
+
+var defaultLanguageDetector LanguageDetector = newTrigramDetector(languageSamples)
+
+/**
+ * <Constructor for trigramDetector>
+ * param: map[string]string samples Sample text of every Latin script language to detect, by language code
+ */
+func newTrigramDetector(samples map[string]string) *trigramDetector {
+	d := &trigramDetector{profiles: make(map[string]trigramProfile)}
+	vocabulary := make(map[string]bool)
+	for language, sample := range samples {
+		profile := trigramProfile{counts: trigrams(sample)}
+		for trigram, count := range profile.counts {
+			profile.total += count
+			vocabulary[trigram] = true
+		}
+		d.profiles[language] = profile
+	}
+	d.vocabulary = float64(len(vocabulary))
+	return d
+}
+
+func (d *trigramDetector) Detect(text string) string {
+	// URLs say nothing about the language of the text around them
+	text = urlPattern.ReplaceAllString(text, " ")
+	letters, latin := 0, 0
+	byScript := make(map[string]int)
+	kana := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				byScript[s.language]++
+				kana = kana || s.language == "ja"
+				break
+			}
+		}
+	}
+	if letters < minLanguageLetters {
+		return UndeterminedLanguage
+	}
+
+	for language, count := range byScript {
+		if 2*count > letters {
+			return language
+		}
+	}
+	if kana && 2*(byScript["ja"]+byScript["zh"]) > letters {
+		return "ja"
+	}
+	if 2*latin <= letters {
+		return UndeterminedLanguage
+	}
+
+	counts := trigrams(text)
+	type score struct {
+		language string
+		value    float64
+	}
+	var scores []score
+	for language, profile := range d.profiles {
+		// log likelihood of the text's trigrams under the profile (add-one smoothed), per trigram
+		likelihood, n := 0.0, 0.0
+		for trigram, count := range counts {
+			likelihood += count * math.Log((profile.counts[trigram]+1)/(profile.total+d.vocabulary))
+			n += count
+		}
+		scores = append(scores, score{language, likelihood / n})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].value > scores[j].value })
+	if len(scores) == 0 {
+		return UndeterminedLanguage
+	}
+	if len(scores) > 1 && scores[0].value-scores[1].value < minLanguageMargin {
+		return UndeterminedLanguage
+	}
+	return scores[0].language
+}
+
+/**
+ * Counts of letter trigrams of the words of text (words padded with a space on either side)
+ */
+func trigrams(text string) map[string]float64 {
+	counts := make(map[string]float64)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, word := range words {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+	return counts
+}
+
+/**
+ * Sample text trigram profiles of Latin script languages are built from: the language's
+ * most common words followed by everyday sentences, so that profiles are dominated by common words
+ */
+var languageSamples = map[string]string{
+	"en": `the of and to a in is it you that he was for on are with as his they be at one have this from or had
+		by not but what all were we when your can said there use an each which she do how their if will up
+		other about out many then them these so some her would make like him into time has look two more go
+		see no way could people my than first been call who its now find long down day did get come made may
+		part.
+		The quick brown fox jumps over the lazy dog. I think that we should meet at the office
+		tomorrow morning and talk about what has to be done this week. There is a lot of work left, but
+		it will be fine if everyone does their part. Please remember to buy milk, bread and some
+		vegetables on the way home. What are you doing this evening? The weather was nice and we went for
+		a walk in the park with the children. Have you read the book I gave you? It is one of the best
+		stories I have ever read, and I would like to know what you think about it.`,
+	"es": `de la que el en y a los se del las un por con no una su para es al lo como más pero sus le ya o este
+		sí porque esta entre cuando muy sin sobre también me hasta hay donde quien desde todo nos durante
+		todos uno les ni contra otros ese eso ante ellos e esto mí antes algunos qué unos yo otro otras otra
+		él tanto esa estos mucho quienes nada muchos cual poco ella estar estas algunas algo nosotros.
+		El rápido zorro marrón salta sobre el perro perezoso. Creo que deberíamos reunirnos en la
+		oficina mañana por la mañana y hablar de lo que hay que hacer esta semana. Queda mucho trabajo,
+		pero todo saldrá bien si cada uno hace su parte. Por favor recuerda comprar leche, pan y algunas
+		verduras de camino a casa. ¿Qué vas a hacer esta noche? El tiempo era agradable y fuimos a dar un
+		paseo por el parque con los niños. ¿Has leído el libro que te di? Es una de las mejores historias
+		que he leído y me gustaría saber qué piensas de ella.`,
+	"fr": `de la le et les des en un du une que est pour qui dans par plus pas au sur ne se ce il sont avec ou
+		mais comme on tout nous sa aux son ses cette bien elle fait ont été avoir sans leur même peut entre
+		ces deux aussi donc très tous je vous ils elles lui moi toi.
+		Le rapide renard brun saute par-dessus le chien paresseux. Je pense que nous devrions nous
+		retrouver au bureau demain matin et parler de ce qu'il faut faire cette semaine. Il reste beaucoup
+		de travail, mais tout ira bien si chacun fait sa part. N'oublie pas d'acheter du lait, du pain et
+		des légumes en rentrant à la maison. Qu'est-ce que tu fais ce soir? Il faisait beau et nous
+		sommes allés nous promener dans le parc avec les enfants. As-tu lu le livre que je t'ai donné?
+		C'est une des meilleures histoires que j'ai jamais lues et j'aimerais savoir ce que tu en penses.`,
+	"de": `der die und in den von zu das mit sich des auf für ist im dem nicht ein eine als auch es an werden
+		aus er hat dass sie nach wird bei einer um am sind noch wie einem über einen so zum war haben nur
+		oder aber vor zur bis mehr durch man sein wurde sei ich du wir ihr.
+		Der schnelle braune Fuchs springt über den faulen Hund. Ich denke, dass wir uns morgen früh im
+		Büro treffen und darüber sprechen sollten, was diese Woche zu tun ist. Es gibt noch viel Arbeit,
+		aber es wird gut gehen, wenn jeder seinen Teil macht. Bitte denk daran, auf dem Heimweg Milch,
+		Brot und etwas Gemüse zu kaufen. Was machst du heute Abend? Das Wetter war schön und wir sind mit
+		den Kindern im Park spazieren gegangen. Hast du das Buch gelesen, das ich dir gegeben habe? Es ist
+		eine der besten Geschichten, die ich je gelesen habe, und ich möchte wissen, was du davon hältst.`,
+	"it": `di e il la che è per un in non una sono del le si da a con i gli dei della al ma come anche questo
+		più lo ha se mi ci nel alla ne io tu noi voi loro cosa tutto molto sempre quando dove perché ancora.
+		La veloce volpe marrone salta sopra il cane pigro. Penso che dovremmo incontrarci in ufficio
+		domani mattina e parlare di quello che c'è da fare questa settimana. Resta molto lavoro, ma andrà
+		tutto bene se ognuno fa la sua parte. Per favore ricordati di comprare latte, pane e un po' di
+		verdura tornando a casa. Che cosa fai stasera? Il tempo era bello e siamo andati a fare una
+		passeggiata nel parco con i bambini. Hai letto il libro che ti ho dato? È una delle storie più
+		belle che abbia mai letto e vorrei sapere che cosa ne pensi.`,
+	"pt": `de a o que e do da em um para é com não uma os no se na por mais as dos como mas foi ao ele das tem
+		à seu sua ou ser quando muito há nos já está eu também só pelo pela até isso ela entre era depois
+		sem mesmo aos ter seus quem nas me esse eles estão você tinha foram essa num nem suas meu às minha
+		têm numa pelos elas havia seja qual será nós.
+		A rápida raposa marrom pula sobre o cão preguiçoso. Acho que devíamos nos encontrar no
+		escritório amanhã de manhã e falar sobre o que precisa ser feito esta semana. Ainda há muito
+		trabalho, mas vai dar tudo certo se cada um fizer a sua parte. Por favor lembre de comprar leite,
+		pão e alguns legumes no caminho para casa. O que você vai fazer hoje à noite? O tempo estava bom e
+		fomos passear no parque com as crianças. Você leu o livro que eu te dei? É uma das melhores
+		histórias que eu já li e gostaria de saber o que você acha dela.`,
+	"nl": `de en van het een in is dat op te zijn met voor niet aan er die ook als om bij door maar dan nog wel
+		uit wat naar kan tot zo zich al hij ze was worden wordt heeft hebben geen ik je we jullie mijn dit
+		deze veel.
+		De snelle bruine vos springt over de luie hond. Ik denk dat we morgenochtend op kantoor moeten
+		afspreken en praten over wat er deze week gedaan moet worden. Er is nog veel werk, maar het komt
+		goed als iedereen zijn deel doet. Vergeet alsjeblieft niet om op weg naar huis melk, brood en wat
+		groenten te kopen. Wat ga je vanavond doen? Het weer was mooi en we zijn met de kinderen in het
+		park gaan wandelen. Heb je het boek gelezen dat ik je gaf? Het is een van de beste verhalen die ik
+		ooit heb gelezen en ik wil graag weten wat je ervan vindt.`,
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Time Close waits for in-flight operations by default
+ */
+const DefaultCloseTimeout = 5 * time.Second
+
+var (
+	// returned by operations on a DB that has been closed (or is being closed)
+	ErrClosed = errors.New("database is closed")
+	// returned by Close when in-flight operations didn't finish in time
+	ErrCloseTimeout = errors.New("timed out waiting for in-flight operations")
+)
+
+/**
+ * Sets how long Close waits for in-flight operations
+ * Non-positive timeout falls back to DefaultCloseTimeout
+ */
+func (db *DB) SetCloseTimeout(timeout time.Duration) {
+	db.closeTimeout = timeout
+}
+
+/**
+ * Returns whether Close has been called on the DB
+ */
+func (db *DB) Closed() bool {
+	db.lifecycleMu.Lock()
+	defer db.lifecycleMu.Unlock()
+	return db.closed
+}
+
+/**
+ * Closes the DB gracefully
+ *  1. stops accepting new operations (they fail with ErrClosed)
+ *  2. stops background goroutines (like the janitor)
+ *  3. waits (up to the close timeout) for in-flight operations; if they don't finish
+ *     in time, ErrCloseTimeout is returned and bolt is left open (Close can be retried)
+ *  4. flushes buffered access times, force-releases outstanding snapshots, forgets keys of notebooks
+ *     unlocked, and closes bolt
+ *     (removing the record of this process holding the DB, see lockinfo.go)
+ */
+func (db *DB) Close() error {
+	db.lifecycleMu.Lock()
+	db.closed = true
+	stoppers := db.stoppers
+	db.stoppers = nil
+	db.lifecycleMu.Unlock()
+
+	for _, stop := range stoppers {
+		stop()
+	}
+
+	timeout := db.closeTimeout
+	if timeout <= 0 {
+		timeout = DefaultCloseTimeout
+	}
+	drained := make(chan struct{})
+	go func() {
+		db.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		return ErrCloseTimeout
+	}
+
+	if err := db.flushAccess(db.DB.Update); err != nil {
+		db.logf("access times could not be flushed on close: %v", err)
+	}
+	db.releaseSnapshots()
+	db.notebookKeys.clear()
+	path := db.Path()
+	if err := db.DB.Close(); err != nil {
+		return err
+	}
+	removeLockInfo(path)
+	return nil
+}
+
+/**
+ * Same as bolt's View, but tracked as an in-flight operation (and timed for the slow-op log, see slow_ops.go)
+ */
+func (db *DB) View(fn func(*bolt.Tx) error) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.exit()
+	return db.traced(db.DB.View, false, fn)
+}
+
+/**
+ * Same as bolt's Update, but tracked as an in-flight operation
+ * (and timed for checkpoints of write operations, see write_timeout.go, and for the slow-op log)
+ */
+func (db *DB) Update(fn func(*bolt.Tx) error) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.exit()
+	return db.traced(db.DB.Update, true, func(tx *bolt.Tx) error {
+		defer db.timeWrite()()
+		return fn(tx)
+	})
+}
+
+/**
+ * Registers an operation as in-flight, failing if DB has been closed
+ * Every successful enter() must be paired with an exit()
+ */
+func (db *DB) enter() error {
+	db.lifecycleMu.Lock()
+	defer db.lifecycleMu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.inFlight.Add(1)
+	return nil
+}
+
+/**
+ * Marks an in-flight operation as finished
+ */
+func (db *DB) exit() {
+	db.inFlight.Done()
+}
+
+/**
+ * Registers a func stopping a background goroutine, to be called on Close
+ * If DB is already closed, the goroutine is stopped right away
+ */
+func (db *DB) onClose(stop func()) {
+	db.lifecycleMu.Lock()
+	if !db.closed {
+		db.stoppers = append(db.stoppers, stop)
+		db.lifecycleMu.Unlock()
+		return
+	}
+	db.lifecycleMu.Unlock()
+	stop()
+}
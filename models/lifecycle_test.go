@@ -0,0 +1,125 @@
+package models_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// (run these with `go test -race`: they're about operations racing Close)
+
+func TestCloseDuringConcurrentOperations(t *testing.T) {
+	db := notestest.NewDB(t)
+	db.StartJanitor(time.Millisecond)
+	note := notestest.MustAdd(t, db, "work", "first")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	started := make(chan struct{}, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				var err error
+				switch (w + i) % 3 {
+				case 0:
+					_, err = db.AddNote("work", models.Note{Content: "concurrent"})
+				case 1:
+					_, err = db.GetNote("work", note.Id)
+				default:
+					_, err = db.ListNotes("work")
+				}
+				if i == 0 {
+					started <- struct{}{}
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	for w := 0; w < 8; w++ {
+		<-started
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, models.ErrClosed) {
+			t.Errorf("operation racing Close failed with %v, want ErrClosed", err)
+		}
+	}
+	if !db.Closed() {
+		t.Error("Closed() = false after Close")
+	}
+}
+
+func TestOperationsAfterCloseFail(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "content")
+	if db.Closed() {
+		t.Fatal("Closed() = true before Close")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.AddNote("work", models.Note{Content: "late"}); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("AddNote: %v, want ErrClosed", err)
+	}
+	if _, err := db.GetNote("work", note.Id); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("GetNote: %v, want ErrClosed", err)
+	}
+	if _, err := db.ListNotes("work"); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("ListNotes: %v, want ErrClosed", err)
+	}
+	if err := db.View(func(*bolt.Tx) error { return nil }); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("View: %v, want ErrClosed", err)
+	}
+	// closing again is harmless
+	if err := db.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	db := notestest.NewDB(t)
+	db.SetCloseTimeout(20 * time.Millisecond)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	viewed := make(chan error)
+	go func() {
+		viewed <- db.View(func(*bolt.Tx) error {
+			close(entered)
+			<-release
+			return nil
+		})
+	}()
+	<-entered
+
+	if err := db.Close(); !errors.Is(err, models.ErrCloseTimeout) {
+		t.Fatalf("Close with an operation in flight: %v, want ErrCloseTimeout", err)
+	}
+	// no new operation is let in meanwhile
+	if _, err := db.ListNotes("work"); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("ListNotes while closing: %v, want ErrClosed", err)
+	}
+
+	close(release)
+	if err := <-viewed; err != nil {
+		t.Errorf("in-flight View: %v", err)
+	}
+	// Close can be retried once the operation is done
+	if err := db.Close(); err != nil {
+		t.Errorf("retried Close: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Predicates selecting notes, shared by ListNotes, Query and the exporters (ExportNotebook, ExportSQLite)
+ *  - all predicates must hold for a note to match; zero fields don't constrain anything
+ *  - expired notes are left out unless IncludeExpired
+ *  - time ranges are [from, to), zero bounds being open; a note's update time is its creation
+ *    time until it's first updated
+ */
+type NoteFilter struct {
+	Tags           []string  `json:"tags,omitempty"`
+	Text           string    `json:"text,omitempty"`
+	Language       string    `json:"language,omitempty"`
+	Kind           string    `json:"kind,omitempty"`
+	CreatedFrom    time.Time `json:"created_from"`
+	CreatedTo      time.Time `json:"created_to"`
+	UpdatedFrom    time.Time `json:"updated_from"`
+	UpdatedTo      time.Time `json:"updated_to"`
+	IncludeExpired bool      `json:"include_expired,omitempty"`
+	// not a predicate: ListNotes sorts notes by position rather than by id (see SortByPosition)
+	SortByPosition bool `json:"sort_by_position,omitempty"`
+	// not a predicate: exporters pass every note export through it (see RenderWith)
+	Render RenderFunc `json:"-"`
+	// not a predicate: notes are decoded within the read transaction (see DecodeInTx)
+	DecodeInTx bool `json:"-"`
+	// relative lower bounds (like "3d") as given, which cursors are told apart by rather than by the times
+	// they resolve to, as these move on from page to page (see CreatedSince)
+	CreatedSince string `json:"created_since,omitempty"`
+	UpdatedSince string `json:"updated_since,omitempty"`
+}
+
+/**
+ * Option of ListNotes and the exporters, narrowing down the notes they cover (see With.. functions)
+ */
+type ListOption func(*NoteFilter)
+
+/**
+ * Includes notes that have expired but haven't been purged yet
+ */
+func WithExpired() ListOption {
+	return func(filter *NoteFilter) {
+		filter.IncludeExpired = true
+	}
+}
+
+/**
+ * Only notes having given tag (may be passed repeatedly: notes must have all the tags)
+ */
+func WithTag(tag string) ListOption {
+	return func(filter *NoteFilter) {
+		filter.Tags = append(filter.Tags, tag)
+	}
+}
+
+/**
+ * Only notes whose content contains given text (case-insensitively)
+ */
+func TextContains(text string) ListOption {
+	return func(filter *NoteFilter) {
+		filter.Text = text
+	}
+}
+
+/**
+ * Only notes written in given language (see Query.Language)
+ */
+func Language(language string) ListOption {
+	return func(filter *NoteFilter) {
+		filter.Language = language
+	}
+}
+
+/**
+ * Only notes of given kind (see Query.Kind)
+ */
+func OfKind(kind string) ListOption {
+	return func(filter *NoteFilter) {
+		filter.Kind = kind
+	}
+}
+
+/**
+ * Only notes created within [from, to); a zero bound is open
+ */
+func CreatedBetween(from, to time.Time) ListOption {
+	return func(filter *NoteFilter) {
+		filter.CreatedFrom, filter.CreatedTo, filter.CreatedSince = from, to, ""
+	}
+}
+
+/**
+ * Only notes last updated within [from, to); a zero bound is open
+ */
+func UpdatedBetween(from, to time.Time) ListOption {
+	return func(filter *NoteFilter) {
+		filter.UpdatedFrom, filter.UpdatedTo, filter.UpdatedSince = from, to, ""
+	}
+}
+
+/**
+ * Only notes created from given time on, it being what since (like "3d") resolved to; cursors of
+ * the query stay valid as since resolves to later times (see filterFingerprint)
+ */
+func CreatedSince(since string, from time.Time) ListOption {
+	return func(filter *NoteFilter) {
+		filter.CreatedFrom, filter.CreatedTo, filter.CreatedSince = from, time.Time{}, since
+	}
+}
+
+/**
+ * Only notes last updated from given time on, it being what since (like "3d") resolved to (see CreatedSince)
+ */
+func UpdatedSince(since string, from time.Time) ListOption {
+	return func(filter *NoteFilter) {
+		filter.UpdatedFrom, filter.UpdatedTo, filter.UpdatedSince = from, time.Time{}, since
+	}
+}
+
+func newNoteFilter(opts []ListOption) NoteFilter {
+	var filter NoteFilter
+	for _, opt := range opts {
+		opt(&filter)
+	}
+	return filter
+}
+
+/**
+ * Whether the filter leaves out notes other than expired ones
+ * (notes due to be purged aren't missed by anyone)
+ */
+func (f NoteFilter) Partial() bool {
+	return len(f.Tags) > 0 || f.Text != "" || f.Language != "" || f.Kind != "" ||
+		!f.CreatedFrom.IsZero() || !f.CreatedTo.IsZero() || !f.UpdatedFrom.IsZero() || !f.UpdatedTo.IsZero()
+}
+
+/**
+ * Whether a note satisfies all predicates of the filter
+ */
+func (f NoteFilter) matches(note Note, now time.Time, detector LanguageDetector) bool {
+	if !f.IncludeExpired && note.Expired(now) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !containsString(note.Tags, tag) {
+			return false
+		}
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(note.Content), strings.ToLower(f.Text)) {
+		return false
+	}
+	if f.Language != "" && languageOf(note, detector) != f.Language {
+		return false
+	}
+	if f.Kind != "" && note.Kind != f.Kind {
+		return false
+	}
+	return within(note.CreatedAt, f.CreatedFrom, f.CreatedTo) && within(updatedAt(note), f.UpdatedFrom, f.UpdatedTo)
+}
+
+/**
+ * Calls fn for every note of a notebook matching filter, in order of ids
+ * Notes are decoded (and filtered) one at a time while cursoring, so memory use doesn't grow
+ * with the notebook; a notebook that doesn't exist has no notes
+ * Corrupt records fail the iteration, or are skipped, as per the read policy (see SetReadPolicy)
+ */
+func (db *DB) forEachMatchingNote(tx *bolt.Tx, notebookKey []byte, filter NoteFilter, fn func(Note) error) error {
+	return db.forEachNote(tx, notebookKey, filter, func(k, v []byte) (Note, error) {
+		return db.decodeNote(tx, notebookKey, k, v)
+	}, fn)
+}
+
+/**
+ * Core logic of forEachMatchingNote, decoding note records with given function
+ */
+func (db *DB) forEachNote(tx *bolt.Tx, notebookKey []byte, filter NoteFilter, decode func(k, v []byte) (Note, error), fn func(Note) error) error {
+	if filter.Text != "" {
+		if err := db.checkSearchable(); err != nil {
+			return err
+		}
+	}
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+	if notebookBucket == nil {
+		return nil
+	}
+	now := time.Now()
+	detector := db.detector()
+	// (keys are walked in order of ids, not in bolt's order of bytes: see id_order.go)
+	cursor := newIdCursor(notebookBucket)
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		note, err := decode(k, v)
+		if db.skipCorrupt(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !filter.matches(note, now, detector) {
+			continue
+		}
+		if err := fn(note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Retrieves notes of a notebook
+ *  - expired notes are left out unless WithExpired() is passed; other options narrow down
+ *    the notes further (see NoteFilter)
+ *  - notes are in order of ids (2 before 10, see id_order.go), or arranged by hand with SortByPosition()
+ *  - a notebook that doesn't exist has no notes
+ *  - records are decoded once the read transaction is closed (see copied_reads.go)
+ * param: string        notebookName
+ * param: ...ListOption opts
+ * return: ([]Note, error)
+ */
+func (db *DB) ListNotes(notebookName string, opts ...ListOption) ([]Note, error) {
+	return db.listNotes(notebookName, nil, opts...)
+}
+
+/**
+ * Core logic of ListNotes, calling begin first within the read transaction (if set, see notesRead)
+ */
+func (db *DB) listNotes(notebookName string, begin func(tx *bolt.Tx) error, opts ...ListOption) ([]Note, error) {
+	var notes []Note
+	filter := newNoteFilter(opts)
+	err := db.readNotes(db.notebookKey(notebookName), filter, notesRead{begin: begin, each: func(_ *bolt.Tx, note Note) error {
+		notes = append(notes, note)
+		return nil
+	}})
+	if filter.SortByPosition {
+		sortByPosition(notes)
+	}
+	return notes, err
+}
+
+/**
+ * Same as ListNotes, within given transaction (as Snapshot reads)
+ */
+func (db *DB) listNotesInTx(tx *bolt.Tx, notebookName string, opts ...ListOption) ([]Note, error) {
+	var notes []Note
+	filter := newNoteFilter(opts)
+	err := db.forEachMatchingNote(tx, db.notebookKey(notebookName), filter, func(note Note) error {
+		notes = append(notes, note)
+		return nil
+	})
+	if filter.SortByPosition {
+		sortByPosition(notes)
+	}
+	return notes, err
+}
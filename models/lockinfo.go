@@ -0,0 +1,208 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Only one process can have a DB file open at a time (bolt holds an exclusive lock on it)
+ *  - the process having a DB open records who it is in a sidecar file next to it
+ *    ('<db file>.lockinfo', removed on Close), so that others failing to open it can tell who's holding it
+ *  - a sidecar left behind by a process that died is recognized (on the same host) and ignored
+ *  - DBs opened read-only (see OpenReadOnlyDB) share the file with other readers, and record nothing
+ */
+
+/**
+ * Time GetOrCreateDB waits for a DB held by another process to become free
+ */
+const DefaultOpenTimeout = 2 * time.Second
+
+/**
+ * Returned (wrapped in a *DatabaseLockedError) when a DB can't be opened as another process holds it
+ */
+var ErrDatabaseLocked = errors.New("database in use by another process")
+
+/**
+ * Who holds a DB open
+ */
+type LockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Program  string    `json:"program"`
+	Since    time.Time `json:"since"`
+}
+
+/**
+ * Returned when a DB can't be opened as another process holds it
+ *  - Holder is nil if the holder is unknown (it didn't record itself, or its record is stale)
+ */
+type DatabaseLockedError struct {
+	Path   string
+	Holder *LockInfo
+}
+
+func (e *DatabaseLockedError) Error() string {
+	if e.Holder == nil {
+		return ErrDatabaseLocked.Error()
+	}
+	holder := fmt.Sprintf("PID %d", e.Holder.PID)
+	if hostname, _ := os.Hostname(); e.Holder.Hostname != hostname {
+		holder += " on " + e.Holder.Hostname
+	}
+	since := e.Holder.Since.Local()
+	layout := "15:04"
+	if y, m, d := since.Date(); y != time.Now().Year() || m != time.Now().Month() || d != time.Now().Day() {
+		layout = "Jan 2 15:04"
+	}
+	return fmt.Sprintf("database in use by %s (%s) since %s", holder, e.Holder.Program, since.Format(layout))
+}
+
+func (e *DatabaseLockedError) Unwrap() error {
+	return ErrDatabaseLocked
+}
+
+/**
+ * <Constructor for DB that doesn't wait>
+ * Same as GetOrCreateDB, but fails right away (after a single attempt) with a *DatabaseLockedError
+ * if another process holds the DB, instead of waiting for it to become free
+ * param: string path
+ * return: (*DB, error)
+ */
+func TryOpen(path string) (*DB, error) {
+	// bolt takes a zero timeout as 'wait forever'; any positive one gives up after the first attempt
+	return openDB(path, time.Nanosecond)
+}
+
+/**
+ * Opens a DB, waiting up to timeout for another process holding it to let go
+ */
+func openDB(path string, timeout time.Duration) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{InitialMmapSize: initialMmapSize, Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, &DatabaseLockedError{Path: path, Holder: readLockInfo(path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	database := &DB{DB: db}
+	if err := database.loadSettings(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// recording the holder is a courtesy to other processes: failing to doesn't fail the open
+	writeLockInfo(path)
+	return database, nil
+}
+
+/**
+ * Opens an existing DB file read-only (see OpenReadOnlyDB), waiting up to timeout for a writer holding it
+ */
+func openReadOnlyDB(path string, timeout time.Duration) (*DB, error) {
+	// (bolt would create a missing file, were it opened for writing)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, &DatabaseLockedError{Path: path, Holder: readLockInfo(path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("Notebook")) == nil {
+			return fmt.Errorf("'%s' isn't a notes database (it has no 'Notebook' bucket)", path)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	database := &DB{DB: db}
+	if err := database.loadSettings(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// access times can't be written to the file (see access.go)
+	database.SetAccessTracking(false)
+	return database, nil
+}
+
+func lockInfoPath(path string) string {
+	return path + ".lockinfo"
+}
+
+/**
+ * Records this process as holder of a DB
+ */
+func writeLockInfo(path string) error {
+	hostname, _ := os.Hostname()
+	info := LockInfo{PID: os.Getpid(), Hostname: hostname, Program: filepath.Base(os.Args[0]), Since: time.Now()}
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// written aside and renamed, so that readers never see a partial record
+	tmp := lockInfoPath(path) + ".tmp"
+	if err := ioutil.WriteFile(tmp, encoded, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, lockInfoPath(path))
+}
+
+/**
+ * Removes the record of this process holding a DB (unless another process has recorded itself since)
+ */
+func removeLockInfo(path string) {
+	if info := readLockInfo(path); info != nil && info.PID == os.Getpid() {
+		os.Remove(lockInfoPath(path))
+	}
+}
+
+/**
+ * Reads the record of who holds a DB (nil if there's none, or it is stale)
+ */
+func readLockInfo(path string) *LockInfo {
+	encoded, err := ioutil.ReadFile(lockInfoPath(path))
+	if err != nil {
+		return nil
+	}
+	var info LockInfo
+	if err := json.Unmarshal(encoded, &info); err != nil {
+		return nil
+	}
+	// processes of other hosts can't be checked: their records are taken at face value
+	if hostname, _ := os.Hostname(); info.Hostname == hostname && !processAlive(info.PID) {
+		return nil
+	}
+	return &info
+}
+
+/**
+ * Whether a process with given PID is running (on this host)
+ */
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// signal 0 checks for existence without delivering anything
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"log"
+)
+
+/**
+ * Sink for messages of background work (janitor, backups ..), satisfied by *log.Logger
+ */
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+/**
+ * Sets where messages of background work go; nil restores the default
+ * (the standard logger of package log)
+ */
+func (db *DB) SetLogger(logger Logger) {
+	db.loggerMu.Lock()
+	defer db.loggerMu.Unlock()
+	db.logger = logger
+}
+
+/**
+ * Logs a message through the configured Logger
+ */
+func (db *DB) logf(format string, v ...interface{}) {
+	db.loggerMu.Lock()
+	logger := db.logger
+	db.loggerMu.Unlock()
+	if logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	logger.Printf(format, v...)
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Options of CaptureMessage
+ */
+type CaptureOptions struct {
+	// header whose (comma-separated) values become tags, like "X-Tags" or "Keywords"; no tags if empty
+	TagsHeader string
+	// store attachments of the message with the note (see AddAttachment); they're skipped otherwise
+	Attachments bool
+	// called with problems of the message that were worked around, and with attachments skipped
+	OnWarning func(warning string)
+}
+
+/**
+ * Parts of a message making up a note
+ */
+type messageParts struct {
+	plain, html *string
+	attachments []messageAttachment
+}
+
+type messageAttachment struct {
+	name    string
+	content []byte
+}
+
+// tags (and comments) of HTML, dropped when it can't be converted properly, and those of them
+// ending lines
+var (
+	htmlTagPattern   = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<(br|/p|/div|/h[1-6]|li|/tr)\b[^>]*>`)
+	blankRunPattern  = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+/**
+ * Adds a note made of an RFC 2822 message (like one forwarded to a mail pipe) in given notebook
+ *  - the subject becomes the note's title, and its Date header its creation time
+ *  - the body is the text/plain part of a multipart message if it has one, its text/html part
+ *    converted to text otherwise
+ *  - values of opts.TagsHeader (if set) become tags
+ *  - attachments are stored with the note if opts.Attachments is set, and reported to opts.OnWarning
+ *    as skipped otherwise
+ *  - malformed messages make a note of whatever could be read (the raw message, if its headers can't be),
+ *    problems being reported to opts.OnWarning; only failing to read r or to store the note fails
+ * param: string         notebookName
+ * param: io.Reader      r
+ * param: CaptureOptions opts
+ * return: (Note, error) The note as stored
+ */
+func (db *DB) CaptureMessage(notebookName string, r io.Reader, opts CaptureOptions) (Note, error) {
+	warn := func(format string, args ...interface{}) {
+		if opts.OnWarning != nil {
+			opts.OnWarning(fmt.Sprintf(format, args...))
+		}
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Note{}, err
+	}
+
+	var note Note
+	var parts messageParts
+	message, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		warn("headers can't be read (%v): the whole message is kept as content", err)
+		note.Content = strings.TrimSpace(string(raw))
+	} else {
+		var decoder mime.WordDecoder
+		subject := message.Header.Get("Subject")
+		if decoded, err := decoder.DecodeHeader(subject); err == nil {
+			subject = decoded
+		} else {
+			warn("subject can't be decoded (%v): kept as it is", err)
+		}
+		note.TitleText = strings.TrimSpace(subject)
+
+		if message.Header.Get("Date") != "" {
+			if date, err := message.Header.Date(); err == nil {
+				note.CreatedAt = date
+			} else {
+				warn("date can't be read (%v): the note is dated now", err)
+			}
+		}
+		if opts.TagsHeader != "" {
+			for _, value := range message.Header[textproto.CanonicalMIMEHeaderKey(opts.TagsHeader)] {
+				if decoded, err := decoder.DecodeHeader(value); err == nil {
+					value = decoded
+				}
+				for _, tag := range strings.Split(value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" && !containsString(note.Tags, tag) {
+						note.Tags = append(note.Tags, tag)
+					}
+				}
+			}
+		}
+
+		body, err := ioutil.ReadAll(message.Body)
+		if err != nil {
+			warn("body can't be read whole (%v): kept as far as it could be", err)
+		}
+		body = decodeTransfer(message.Header.Get("Content-Transfer-Encoding"), body, warn)
+		readMessagePart(textproto.MIMEHeader(message.Header), body, &parts, warn)
+		switch {
+		case parts.plain != nil:
+			note.Content = strings.TrimSpace(*parts.plain)
+		case parts.html != nil:
+			text, err := enmlToText(*parts.html, false)
+			if err != nil {
+				warn("HTML body can't be converted (%v): its tags are stripped", err)
+				text = htmlBreakPattern.ReplaceAllString(*parts.html, "\n$0")
+				text = html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+				text = blankRunPattern.ReplaceAllString(text, "\n\n")
+			}
+			note.Content = strings.TrimSpace(text)
+		}
+	}
+
+	if note, err = db.AddNote(notebookName, note); err != nil {
+		return note, err
+	}
+	for _, attachment := range parts.attachments {
+		if !opts.Attachments {
+			warn("attachment '%s' (%d bytes) skipped", attachment.name, len(attachment.content))
+			continue
+		}
+		if _, err := db.AddAttachment(notebookName, note.Id, attachment.name, bytes.NewReader(attachment.content)); err != nil {
+			return note, err
+		}
+	}
+	return note, nil
+}
+
+/**
+ * Sorts a part of a message (its body decoded as per its Content-Transfer-Encoding) into text bodies
+ * and attachments, walking into multipart parts
+ */
+func readMessagePart(header textproto.MIMEHeader, body []byte, parts *messageParts, warn func(string, ...interface{})) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		if header.Get("Content-Type") != "" {
+			warn("content type can't be read (%v): read as text", err)
+		}
+		mediaType, params = "text/plain", nil
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			// (quoted-printable parts are decoded by the reader itself)
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				warn("multipart body is malformed (%v): parts after it are left out", err)
+				break
+			}
+			content, err := ioutil.ReadAll(part)
+			if err != nil {
+				warn("part can't be read whole (%v): kept as far as it could be", err)
+			}
+			readMessagePart(part.Header, decodeTransfer(part.Header.Get("Content-Transfer-Encoding"), content, warn), parts, warn)
+		}
+		return
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if disposition == "attachment" || name != "" || !isText {
+		if name == "" {
+			name = "attachment"
+			if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+				name += extensions[0]
+			}
+		}
+		parts.attachments = append(parts.attachments, messageAttachment{name: name, content: body})
+		return
+	}
+	text := decodeCharset(params["charset"], body, warn)
+	if mediaType == "text/plain" && parts.plain == nil {
+		parts.plain = &text
+	}
+	if mediaType == "text/html" && parts.html == nil {
+		parts.html = &text
+	}
+}
+
+/**
+ * Decodes content as per its Content-Transfer-Encoding (quoted-printable parts of multipart bodies
+ * come decoded already, their header being dropped)
+ */
+func decodeTransfer(encoding string, content []byte, warn func(string, ...interface{})) []byte {
+	var decoded []byte
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		unwrapped := strings.NewReplacer("\r", "", "\n", "", " ", "", "\t", "").Replace(string(content))
+		decoded, err = ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(unwrapped)))
+	case "quoted-printable":
+		decoded, err = ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(content)))
+	default:
+		return content
+	}
+	if err != nil {
+		warn("%s content can't be decoded (%v): kept as far as it could be", encoding, err)
+	}
+	return decoded
+}
+
+/**
+ * Decodes text in given charset: UTF-8 (and ASCII) as it is, ISO-8859-1 by code point; other
+ * charsets are read as UTF-8
+ */
+func decodeCharset(charset string, content []byte, warn func(string, ...interface{})) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(content)
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		warn("charset '%s' isn't supported: read as UTF-8", charset)
+		return string(content)
+	}
+}
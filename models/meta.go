@@ -0,0 +1,176 @@
+package models
+
+import (
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * DB-level settings persisted in 'Meta' bucket (under 'settings' key)
+ */
+type dbSettings struct {
+	CaseInsensitiveNames bool               `json:"case_insensitive_names"`
+	Normalization        NormalizeOptions   `json:"normalization"`
+	Outbox               bool               `json:"outbox,omitempty"`
+	AutoFiling           string             `json:"auto_filing,omitempty"`
+	Titles               TitleInference     `json:"titles"`
+	Retention            RetentionPolicy    `json:"retention"`
+	Encryption           encryptionSettings `json:"encryption"`
+	// revisions between revisions of history kept in full (see revisions.go), and whether histories
+	// written before revisions were stored as diffs are all converted
+	HistorySnapshotInterval int  `json:"history_snapshot_interval,omitempty"`
+	HistoryDiffs            bool `json:"history_diffs,omitempty"`
+	// the janitor applies notebook policies (see notebook_policies.go)
+	ScheduledPolicies bool `json:"scheduled_policies,omitempty"`
+	// the janitor runs recurring rules (see recurring.go)
+	ScheduledRecurring bool `json:"scheduled_recurring,omitempty"`
+	// times the outbox was turned on: changes made in between two of them weren't recorded (see sync_state.go)
+	OutboxEpoch uint64 `json:"outbox_epoch,omitempty"`
+}
+
+/**
+ * Per-notebook metadata persisted in 'NotebookMeta' bucket
+ * keyed by the notebook's bucket key
+ */
+type notebookMeta struct {
+	DisplayName string                 `json:"display_name"`
+	Defaults    NotebookDefaults       `json:"defaults"`
+	Owner       string                 `json:"owner,omitempty"`
+	ACL         map[string]AccessLevel `json:"acl,omitempty"`
+	// archived notebooks are read-only and left out of listings (see archive.go)
+	Archived bool `json:"archived,omitempty"`
+	// abbreviation short ids of the notebook's notes start with (see short_ids.go)
+	Abbrev string `json:"abbrev,omitempty"`
+	// titles of the notebook's notes are unique, taken ones getting suffixed on creation if AutoSuffixTitles (see unique_titles.go)
+	UniqueTitles     bool `json:"unique_titles,omitempty"`
+	AutoSuffixTitles bool `json:"auto_suffix_titles,omitempty"`
+	// archival and deletion of old notes (see notebook_policies.go)
+	Policy *NotebookPolicy `json:"policy,omitempty"`
+	// key content of the notebook's notes is encrypted with, wrapped (see notebook_encryption.go)
+	Encryption *NotebookEncryption `json:"encryption,omitempty"`
+}
+
+/**
+ * Reads DB-level settings; a DB without 'Meta' bucket
+ * (created by older versions) simply has default settings
+ */
+func getSettings(tx *bolt.Tx) (dbSettings, error) {
+	var settings dbSettings
+	bucket := tx.Bucket([]byte("Meta"))
+	if bucket == nil {
+		return settings, nil
+	}
+	if encoded := bucket.Get([]byte("settings")); encoded != nil {
+		if err := json.Unmarshal(encoded, &settings); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+/**
+ * Persists DB-level settings, creating 'Meta' bucket if needed
+ */
+func putSettings(tx *bolt.Tx, settings dbSettings) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte("settings"), encoded)
+}
+
+/**
+ * Loads DB-level settings into the DB struct
+ */
+func (db *DB) loadSettings() error {
+	return db.View(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		db.caseInsensitiveNames = settings.CaseInsensitiveNames
+		db.normalization = settings.Normalization
+		db.outbox = settings.Outbox
+		db.autoFiling = settings.AutoFiling
+		db.titles = settings.Titles
+		db.deviceId = getDeviceId(tx)
+		db.encryptionMode = settings.Encryption.Mode
+		db.historySnapshotInterval = settings.HistorySnapshotInterval
+		return nil
+	})
+}
+
+/**
+ * Retrieves metadata of notebook with given bucket key
+ * Missing metadata (or missing 'NotebookMeta' bucket) yields zero-valued notebookMeta
+ */
+func getNotebookMeta(tx *bolt.Tx, notebookKey []byte) notebookMeta {
+	var meta notebookMeta
+	bucket := tx.Bucket([]byte("NotebookMeta"))
+	if bucket == nil {
+		return meta
+	}
+	if encoded := bucket.Get(notebookKey); encoded != nil {
+		json.Unmarshal(encoded, &meta)
+	}
+	return meta
+}
+
+/**
+ * Persists metadata of notebook with given bucket key
+ */
+func putNotebookMeta(tx *bolt.Tx, notebookKey []byte, meta notebookMeta) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("NotebookMeta"))
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(notebookKey, encoded)
+}
+
+/**
+ * Removes metadata of notebook with given bucket key
+ */
+func deleteNotebookMeta(tx *bolt.Tx, notebookKey []byte) error {
+	bucket := tx.Bucket([]byte("NotebookMeta"))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(notebookKey)
+}
+
+/**
+ * Records display name (and abbreviation) of a notebook unless it already has them
+ */
+func ensureNotebookMeta(tx *bolt.Tx, notebookKey []byte, notebookName string) error {
+	meta := getNotebookMeta(tx, notebookKey)
+	if meta.DisplayName != "" && meta.Abbrev != "" {
+		return nil
+	}
+	if meta.DisplayName == "" {
+		meta.DisplayName = notebookName
+	}
+	if meta.Abbrev == "" {
+		meta.Abbrev = assignAbbreviation(tx, notebookKey, meta.DisplayName)
+	}
+	return putNotebookMeta(tx, notebookKey, meta)
+}
+
+/**
+ * Returns display name of notebook with given bucket key
+ * falling back to the key itself for notebooks created before metadata existed
+ */
+func notebookDisplayName(tx *bolt.Tx, notebookKey []byte) string {
+	if meta := getNotebookMeta(tx, notebookKey); meta.DisplayName != "" {
+		return meta.DisplayName
+	}
+	return string(notebookKey)
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Name of the index file MirrorToDir maintains within the mirror directory
+ */
+const mirrorIndexFile = ".notes-index.json"
+
+/**
+ * Options of MirrorToDir / MirrorFromDir
+ */
+type MirrorOptions struct {
+	// MirrorToDir: overwrite (or delete) files edited since the last sync, instead of reporting a conflict
+	Overwrite bool
+	// MirrorFromDir: delete notes whose files were removed from the mirror
+	DeleteMissing bool
+}
+
+/**
+ * Outcome of a mirror sync (in either direction)
+ *  - Conflicts lists (slash separated) paths of files, relative to the mirror directory
+ */
+type MirrorReport struct {
+	Created    int      `json:"created"`
+	Updated    int      `json:"updated"`
+	Deleted    int      `json:"deleted"`
+	Conflicted int      `json:"conflicted"`
+	Conflicts  []string `json:"conflicts,omitempty"`
+}
+
+/**
+ * Index of a mirror: file (slash separated path relative to the mirror directory) -> entry
+ * Hash is the content hash of the file as of the last sync, which tells apart files
+ * edited in the mirror from notes changed in the DB
+ */
+type mirrorIndex struct {
+	Files map[string]mirrorEntry `json:"files"`
+}
+
+type mirrorEntry struct {
+	Ref  NoteRef `json:"ref"`
+	Hash string  `json:"hash"`
+}
+
+/**
+ * Mirrors all notes into a directory (meant to be kept under version control)
+ *  - every note is a markdown file '<notebook>/<id>.md' holding the note's content
+ *  - only files whose content actually changed are written, so that diffs stay minimal;
+ *    files of notes that no longer exist are deleted
+ *  - files edited in the mirror since the last sync are left alone and reported as conflicts
+ *    (sync them back with MirrorFromDir first, or pass Overwrite)
+ * param: string        dir
+ * param: MirrorOptions opts
+ * return: (MirrorReport, error)
+ */
+func (db *DB) MirrorToDir(dir string, opts MirrorOptions) (MirrorReport, error) {
+	var report MirrorReport
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return report, err
+	}
+	index, err := readMirrorIndex(dir)
+	if err != nil {
+		return report, err
+	}
+
+	seen := make(map[string]bool)
+	err = db.View(func(tx *bolt.Tx) error {
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if notebookBucket == nil {
+				return nil
+			}
+			notebookName := notebookDisplayName(tx, notebookKey)
+			return notebookBucket.ForEach(func(k, encodedNote []byte) error {
+				note, err := db.decodeNote(tx, notebookKey, k, encodedNote)
+				if err != nil {
+					return err
+				}
+				file := mirrorFileName(notebookName, note.Id)
+				seen[file] = true
+				rendered := renderMirrorFile(note)
+				hash := contentHash(rendered)
+
+				current, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+				exists := err == nil
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				entry, indexed := index.Files[file]
+				switch {
+				case exists && contentHash(string(current)) == hash:
+					// up to date
+				case exists && indexed && contentHash(string(current)) != entry.Hash && !opts.Overwrite:
+					report.conflict(file)
+					return nil
+				default:
+					if err := writeFileAtomic(filepath.Join(dir, filepath.FromSlash(file)), []byte(rendered)); err != nil {
+						return err
+					}
+					if exists {
+						report.Updated++
+					} else {
+						report.Created++
+					}
+				}
+				index.Files[file] = mirrorEntry{Ref: NoteRef{Notebook: notebookName, Id: note.Id}, Hash: hash}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// remove files of notes that no longer exist
+	for file, entry := range index.Files {
+		if seen[file] {
+			continue
+		}
+		fullPath := filepath.Join(dir, filepath.FromSlash(file))
+		current, err := ioutil.ReadFile(fullPath)
+		switch {
+		case os.IsNotExist(err):
+			delete(index.Files, file)
+		case err != nil:
+			return report, err
+		case contentHash(string(current)) != entry.Hash && !opts.Overwrite:
+			report.conflict(file)
+		default:
+			if err := os.Remove(fullPath); err != nil {
+				return report, err
+			}
+			// drop the notebook directory once it's empty (ignoring failure otherwise)
+			os.Remove(filepath.Dir(fullPath))
+			delete(index.Files, file)
+			report.Deleted++
+		}
+	}
+	sort.Strings(report.Conflicts)
+	return report, writeMirrorIndex(dir, index)
+}
+
+/**
+ * Applies edits made to files of a mirror (see MirrorToDir) back to the DB
+ *  - files changed since the last sync update their notes (previous content goes to history)
+ *  - if the note changed in the DB as well (or no longer exists, or is locked read-only), the file
+ *    is reported as a conflict
+ *  - files removed from the mirror delete their notes only if DeleteMissing is set
+ *  - files not in the index (like newly created ones) are ignored
+ * param: string        dir
+ * param: MirrorOptions opts
+ * return: (MirrorReport, error)
+ */
+func (db *DB) MirrorFromDir(dir string, opts MirrorOptions) (MirrorReport, error) {
+	var report MirrorReport
+	index, err := readMirrorIndex(dir)
+	if err != nil {
+		return report, err
+	}
+
+	files := make([]string, 0, len(index.Files))
+	for file := range index.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		entry := index.Files[file]
+		current, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if os.IsNotExist(err) {
+			if !opts.DeleteMissing {
+				continue
+			}
+			if err := db.DeleteNotes(entry.Ref.Notebook, entry.Ref.Id); errors.Is(err, ErrNoteReadOnly) {
+				report.conflict(file)
+				continue
+			} else if err != nil {
+				return report, err
+			}
+			delete(index.Files, file)
+			report.Deleted++
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		hash := contentHash(string(current))
+		if hash == entry.Hash {
+			continue
+		}
+
+		conflict := false
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, note, err := db.getNoteInTx(tx, entry.Ref.Notebook, entry.Ref.Id)
+			if err != nil || note.ReadOnly || db.checkNotArchived(tx, entry.Ref.Notebook) != nil ||
+				contentHash(renderMirrorFile(note)) != entry.Hash {
+				// note is gone, locked (or its notebook archived), or changed in the DB as well
+				conflict = true
+				return nil
+			}
+			_, err = db.updateNoteInTx(tx, entry.Ref.Notebook, entry.Ref.Id, parseMirrorFile(string(current)), false)
+			return err
+		})
+		if err != nil {
+			return report, err
+		}
+		if conflict {
+			report.conflict(file)
+			continue
+		}
+		index.Files[file] = mirrorEntry{Ref: entry.Ref, Hash: hash}
+		report.Updated++
+	}
+	return report, writeMirrorIndex(dir, index)
+}
+
+func (r *MirrorReport) conflict(file string) {
+	r.Conflicted++
+	r.Conflicts = append(r.Conflicts, file)
+}
+
+/**
+ * Stable (slash separated) path of a note's file within the mirror
+ */
+func mirrorFileName(notebookName string, noteId uint64) string {
+	return path.Join(url.PathEscape(notebookName), strconv.FormatUint(noteId, 10)+".md")
+}
+
+/**
+ * Contents of a note's mirror file: the note's content, ending with a newline
+ */
+func renderMirrorFile(note Note) string {
+	if strings.HasSuffix(note.Content, "\n") {
+		return note.Content
+	}
+	return note.Content + "\n"
+}
+
+/**
+ * Note content from a mirror file (reverse of renderMirrorFile)
+ */
+func parseMirrorFile(contents string) string {
+	return strings.TrimSuffix(contents, "\n")
+}
+
+func readMirrorIndex(dir string) (mirrorIndex, error) {
+	index := mirrorIndex{Files: make(map[string]mirrorEntry)}
+	encoded, err := ioutil.ReadFile(filepath.Join(dir, mirrorIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(encoded, &index); err != nil {
+		return index, err
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]mirrorEntry)
+	}
+	return index, nil
+}
+
+func writeMirrorIndex(dir string, index mirrorIndex) error {
+	encoded, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, mirrorIndexFile), append(encoded, '\n'))
+}
+
+/**
+ * Writes a file via a temporary file in the same directory and a rename,
+ * so that readers never see a half-written file
+ */
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
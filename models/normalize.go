@@ -0,0 +1,250 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/text/unicode/norm"
+)
+
+/**
+ * Content of notes can be normalized as it's written (see SetContentNormalization), cleaning up
+ * what pasting from other apps leaves behind; off by default, content being stored byte-exact
+ */
+
+/**
+ * Returned when writing content that isn't valid UTF-8, with RejectInvalidUTF8 normalization
+ */
+var ErrInvalidContent = errors.New("content isn't valid UTF-8")
+
+/**
+ * What normalization does with byte sequences that aren't valid UTF-8
+ */
+type InvalidUTF8Policy int
+
+const (
+	// leave them as they are
+	KeepInvalidUTF8 InvalidUTF8Policy = iota
+	// replace each of them with U+FFFD
+	ReplaceInvalidUTF8
+	// refuse the content (writes fail with ErrInvalidContent)
+	RejectInvalidUTF8
+)
+
+/**
+ * Steps of content normalization (the zero value normalizes nothing)
+ *  - StripInvisible removes byte order marks and zero-width spaces / word joiners; zero-width
+ *    (non-)joiners are kept, as they matter to some scripts and emoji sequences
+ *  - LineEndings turns '\r\n' and lone '\r' into '\n'
+ *  - NFC composes characters canonically (so that 'é' is always the same bytes)
+ *  - TrimTrailingSpace removes spaces and tabs ending lines
+ */
+type NormalizeOptions struct {
+	InvalidUTF8       InvalidUTF8Policy `json:"invalid_utf8,omitempty"`
+	StripInvisible    bool              `json:"strip_invisible,omitempty"`
+	LineEndings       bool              `json:"line_endings,omitempty"`
+	NFC               bool              `json:"nfc,omitempty"`
+	TrimTrailingSpace bool              `json:"trim_trailing_space,omitempty"`
+}
+
+/**
+ * Normalization cleaning up content without touching its visible text
+ */
+var StandardNormalization = NormalizeOptions{
+	InvalidUTF8:    ReplaceInvalidUTF8,
+	StripInvisible: true,
+	LineEndings:    true,
+	NFC:            true,
+}
+
+/**
+ * What NormalizeContent changed
+ *  - Rejected is set if content was refused for being invalid UTF-8 (it's then left unchanged)
+ */
+type NormalizeReport struct {
+	InvalidSequences     int  `json:"invalid_sequences,omitempty"`
+	InvisibleRemoved     int  `json:"invisible_removed,omitempty"`
+	LineEndingsConverted int  `json:"line_endings_converted,omitempty"`
+	Recomposed           bool `json:"recomposed,omitempty"`
+	TrimmedLines         int  `json:"trimmed_lines,omitempty"`
+	Rejected             bool `json:"rejected,omitempty"`
+}
+
+/**
+ * Whether normalization changed the content
+ */
+func (r NormalizeReport) Changed() bool {
+	return !r.Rejected && (r.InvalidSequences > 0 || r.InvisibleRemoved > 0 || r.LineEndingsConverted > 0 ||
+		r.Recomposed || r.TrimmedLines > 0)
+}
+
+/**
+ * Normalizes content as per given options, reporting what changed
+ * Steps run in order: invalid UTF-8, invisible characters, line endings, NFC, trailing space
+ * param: string           s
+ * param: NormalizeOptions opts
+ * return: (string, NormalizeReport)
+ */
+func NormalizeContent(s string, opts NormalizeOptions) (string, NormalizeReport) {
+	var report NormalizeReport
+	original := s
+
+	if opts.InvalidUTF8 != KeepInvalidUTF8 && !utf8.ValidString(s) {
+		var b strings.Builder
+		for i := 0; i < len(s); {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				report.InvalidSequences++
+				// a run of invalid bytes is a single sequence
+				for i += size; i < len(s); i += size {
+					if r, size = utf8.DecodeRuneInString(s[i:]); r != utf8.RuneError || size != 1 {
+						break
+					}
+				}
+				b.WriteRune(utf8.RuneError)
+				continue
+			}
+			b.WriteString(s[i : i+size])
+			i += size
+		}
+		if opts.InvalidUTF8 == RejectInvalidUTF8 {
+			report.Rejected = true
+			return original, report
+		}
+		s = b.String()
+	}
+
+	if opts.StripInvisible {
+		s = strings.Map(func(r rune) rune {
+			if isInvisible(r) {
+				report.InvisibleRemoved++
+				return -1
+			}
+			return r
+		}, s)
+	}
+
+	if opts.LineEndings && strings.IndexByte(s, '\r') >= 0 {
+		report.LineEndingsConverted = strings.Count(s, "\r")
+		s = strings.Replace(s, "\r\n", "\n", -1)
+		s = strings.Replace(s, "\r", "\n", -1)
+	}
+
+	if opts.NFC && !norm.NFC.IsNormalString(s) {
+		s = norm.NFC.String(s)
+		report.Recomposed = true
+	}
+
+	if opts.TrimTrailingSpace {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if trimmed := strings.TrimRight(line, " \t"); trimmed != line {
+				lines[i] = trimmed
+				report.TrimmedLines++
+			}
+		}
+		s = strings.Join(lines, "\n")
+	}
+	return s, report
+}
+
+/**
+ * Characters StripInvisible removes
+ */
+func isInvisible(r rune) bool {
+	switch r {
+	case '\uFEFF', // byte order mark (zero-width no-break space)
+		'\u200B', // zero-width space
+		'\u2060': // word joiner
+		return true
+	}
+	return false
+}
+
+/**
+ * Sets normalization applied to content of notes as they are added or updated
+ * (the zero NormalizeOptions turns it off); the setting is persisted in the DB
+ * Notes written before keep their content until NormalizeExisting is run
+ * param: NormalizeOptions opts
+ * return: error
+ */
+func (db *DB) SetContentNormalization(opts NormalizeOptions) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.Normalization = opts
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return err
+	}
+	db.normalization = opts
+	return nil
+}
+
+/**
+ * Normalizes content of the notes of a notebook written before normalization was turned on
+ *  - notes are normalized as per SetContentNormalization, or StandardNormalization if it's off
+ *  - changed notes are updated as usual (previous content goes to history)
+ *  - notes locked read-only, and ones whose content is refused (see RejectInvalidUTF8), are left alone
+ * param: string notebookName
+ * return: (int, error) Number of notes changed
+ */
+func (db *DB) NormalizeExisting(notebookName string) (int, error) {
+	defer db.markBusy()()
+	opts := db.normalization
+	if opts == (NormalizeOptions{}) {
+		opts = StandardNormalization
+	}
+	changed := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		// collected first, as bolt doesn't allow modifying a bucket being iterated
+		var stale []Note
+		err := db.forEachMatchingNote(tx, db.notebookKey(notebookName), NoteFilter{IncludeExpired: true}, func(note Note) error {
+			if note.ReadOnly {
+				return nil
+			}
+			if content, report := NormalizeContent(note.Content, opts); report.Changed() {
+				note.Content = content
+				stale = append(stale, note)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, note := range stale {
+			if err := db.writeCheckpoint(i, len(stale)); err != nil {
+				return err
+			}
+			if _, err := db.updateNoteInTx(tx, notebookName, note.Id, note.Content, false); err != nil {
+				return err
+			}
+		}
+		changed = len(stale)
+		return nil
+	})
+	return changed, err
+}
+
+/**
+ * Normalizes content being written as per the normalization set (see SetContentNormalization)
+ */
+func (e noteEncoding) normalize(content string) (string, error) {
+	if e.normalization == (NormalizeOptions{}) {
+		return content, nil
+	}
+	normalized, report := NormalizeContent(content, e.normalization)
+	if report.Rejected {
+		return content, fmt.Errorf("%w: %d invalid sequence(s)", ErrInvalidContent, report.InvalidSequences)
+	}
+	return normalized, nil
+}
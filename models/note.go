@@ -2,19 +2,56 @@ package models
 
 import (
 	"bytes"
-	"encoding/json"
-	"github.com/boltdb/bolt"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
 	"strconv"
+	"time"
+)
+
+var (
+	// returned when a note with given id doesn't exist in the notebook
+	ErrNoteNotFound = errors.New("note not found")
+	// returned when a notebook by given name doesn't exist
+	ErrNotebookNotFound = errors.New("notebook not found")
 )
 
 /**
  * DTO for a Note within a Notebook
  */
 type Note struct {
-	// TODO: explore allowing 'naming' notes within a notebook
-	//Title   string `json:"title"`
-	Id      uint64 `json:"id"`
-	Content string `json:"content"`
+	Id        uint64     `json:"id"`
+	Revision  uint64     `json:"revision"` // bumped on every write of the note (see UpdateNoteIfRevision)
+	Content   string     `json:"content"`
+	Tags      []string   `json:"tags,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// zero for notes created before creation times were recorded
+	CreatedAt time.Time `json:"created_at"`
+	// time content was last changed; zero for notes never updated
+	UpdatedAt time.Time `json:"updated_at"`
+	// set in stored records of notes whose content is stored in chunks (see chunks.go)
+	Chunks *ChunkDescriptor `json:"chunks,omitempty"`
+	// ISO 639-1 code of the content's language, detected on save (see language.go);
+	// empty for notes saved before languages were detected
+	Language string `json:"language,omitempty"`
+	// locked against changes and deletion (see readonly.go)
+	ReadOnly bool `json:"read_only,omitempty"`
+	// one of the Kind.. constants, telling how content is to be read (see kind.go)
+	Kind string `json:"kind,omitempty"`
+	// place of the note when arranged by hand; zero for notes never moved (see position.go)
+	Position float64 `json:"position,omitempty"`
+	// title of the note, if it has one of its own (see Title() for the one displayed), and whether
+	// it was inferred from content rather than given explicitly (see titles.go)
+	TitleText     string `json:"title,omitempty"`
+	TitleInferred bool   `json:"title_inferred,omitempty"`
+	// identity of the note across DBs, set when it's created and never changed (see fingerprints.go);
+	// empty for notes created before fingerprints existed, until backfilled
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// writes of the note by device, telling copies of it on several devices apart (see clock.go);
+	// empty for notes not written since clocks existed
+	Clock VectorClock `json:"clock,omitempty"`
+	// page the note was clipped from (see clip.go)
+	SourceURL string `json:"source_url,omitempty"`
 }
 
 /**
@@ -25,7 +62,7 @@ func (db *DB) NoteExists(notebookName string, reqNoteId uint64) (bool, error) {
 	noteExists := false
 	err := db.View(func(tx *bolt.Tx) error {
 		reqNoteIdBytes := []byte(strconv.FormatUint(reqNoteId, 10))
-		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket([]byte(notebookName))
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(notebookName))
 
 		foundNoteIdBytes, _ := notebookBucket.Cursor().Seek(reqNoteIdBytes)
 		if foundNoteIdBytes != nil && bytes.Equal(reqNoteIdBytes, foundNoteIdBytes) {
@@ -38,65 +75,92 @@ func (db *DB) NoteExists(notebookName string, reqNoteId uint64) (bool, error) {
 }
 
 /**
- * Retrives note with a given id
+ * Retrives note with a given id (from the note cache, if enabled, see cache.go)
  * param: uint64 noteId
  * return: (Note, error)
  */
 func (db *DB) GetNote(notebookName string, reqNoteId uint64) (Note, error) {
+	if db.Closed() {
+		return Note{}, ErrClosed
+	}
+	notebookKey := db.notebookKey(notebookName)
+	note, cached, generation := db.cache.get(notebookKey, reqNoteId)
+	if !cached {
+		err := db.View(func(tx *bolt.Tx) error {
+			var err error
+			note, err = db.getNoteView(tx, notebookName, reqNoteId)
+			return err
+		})
+		if err != nil {
+			return note, err
+		}
+		if note.Id != 0 {
+			db.cache.put(notebookKey, note, generation)
+		}
+	}
+	if note.Id != 0 {
+		db.recordAccess(notebookName, note.Id)
+	}
+	return note, nil
+}
+
+/**
+ * Core logic of GetNote, shared with Snapshot
+ */
+func (db *DB) getNoteView(tx *bolt.Tx, notebookName string, reqNoteId uint64) (Note, error) {
 	var note Note
-	err := db.View(func(tx *bolt.Tx) error {
-		reqNoteIdBytes := []byte(strconv.FormatUint(reqNoteId, 10))
-		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket([]byte(notebookName))
+	reqNoteIdBytes := []byte(strconv.FormatUint(reqNoteId, 10))
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(notebookName))
 
-		foundNoteIdBytes, foundNoteContentBytes := notebookBucket.Cursor().Seek(reqNoteIdBytes)
-		if foundNoteIdBytes != nil && bytes.Equal(reqNoteIdBytes, foundNoteIdBytes) {
-			return json.Unmarshal(foundNoteContentBytes, &note)
-		}
+	foundNoteIdBytes, foundNoteContentBytes := notebookBucket.Cursor().Seek(reqNoteIdBytes)
+	if foundNoteIdBytes != nil && bytes.Equal(reqNoteIdBytes, foundNoteIdBytes) {
+		return db.decodeNote(tx, db.notebookKey(notebookName), foundNoteIdBytes, foundNoteContentBytes)
+	}
 
-		return nil
-	})
-	return note, err
+	return note, nil
 }
 
 /**
  * Adds notes in the given notebook
  * notes' auto-increment 'Id' are generated and stored in the db by this method itself
+ * notebook's defaults (see SetNotebookDefaults) are applied to every note
+ * notes are filed by filing rules right away if auto-filing is on for the notebook (see SetAutoFiling)
  * param: string notebookName
  * param: ...Note notes
  * return: error
  */
 func (db *DB) AddNotes(notebookName string, noteContents ...string) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.exit()
+
+	// create Note objects, and marshal them before the write transaction is opened
+	var notes []Note
+	for _, noteContent := range noteContents {
+		notes = append(notes, Note{Content: noteContent})
+	}
+	batch, err := db.prepareAdd(notebookName, notes)
+	if err != nil {
+		return err
+	}
+
 	// create a bolt-db transaction with deferred-rollback
+	start := time.Now()
 	tx, err := db.Begin(true)
 	if err != nil {
 		return err
 	}
+	defer db.traceTx(tx, true, start)()
 	defer tx.Rollback()
+	defer db.timeWrite()()
 
-	// create or retrieve (2nd order) bucket with given notebookName
-	notebookBucket, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists([]byte(notebookName))
+	added, err := db.commitAdd(tx, batch)
 	if err != nil {
 		return err
 	}
-
-	// for each noteContent to be added
-	for _, noteContent := range noteContents {
-		// create Note object
-		var note Note = Note{Content: noteContent}
-
-		// gereate noteId
-		noteId, err := notebookBucket.NextSequence()
-		if err != nil {
-			return err
-		}
-		note.Id = noteId
-
-		// put JSON-marshalled noteContent into bolt-db bucket (of given Notebook) with noteId as key
-		if encodedNote, err := json.Marshal(note); err != nil {
-			return err
-		} else if err := notebookBucket.Put([]byte(strconv.FormatUint(noteId, 10)), encodedNote); err != nil {
-			return err
-		}
+	if err := db.autoFileInTx(tx, notebookName, added); err != nil {
+		return err
 	}
 
 	// Commit the transaction.
@@ -107,37 +171,181 @@ func (db *DB) AddNotes(notebookName string, noteContents ...string) error {
 	return err
 }
 
+/**
+ * Adds a single note (with fields other than content, like tags or expiry) in the given notebook
+ * note's 'Id' is generated by this method itself (any supplied 'Id' is ignored)
+ * param: string notebookName
+ * param: Note   note
+ * return: (Note, error) The note as stored
+ */
+func (db *DB) AddNote(notebookName string, note Note) (Note, error) {
+	batch, err := db.prepareAdd(notebookName, []Note{note})
+	if err != nil {
+		return note, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		added, err := db.commitAdd(tx, batch)
+		if err != nil {
+			return err
+		}
+		note = added[0]
+		return nil
+	})
+	return note, err
+}
+
 /**
  * Deletes notes with given ids from the given notebook
+ * deleted notes are stashed in 'UndoLog' bucket, so that deletion can be undone (see Undo)
+ * Fails (deleting nothing) with *ReadOnlyNotesError if any of the notes is locked read-only
  * param: string notebookName
  * param: ...uint64 noteIds
  * return: error
  */
 func (db *DB) DeleteNotes(notebookName string, noteIds ...uint64) error {
+	_, err := db.DeleteNotesWithOptions(notebookName, noteIds)
+	return err
+}
+
+/**
+ * Same as DeleteNotes, with options regarding notes locked read-only:
+ * Force() deletes them as well, SkipLocked() deletes the others and leaves them alone
+ * param: string         notebookName
+ * param: []uint64       noteIds
+ * param: ...WriteOption opts
+ * return: ([]uint64, error) Ids of the notes left alone (with SkipLocked())
+ */
+func (db *DB) DeleteNotesWithOptions(notebookName string, noteIds []uint64, opts ...WriteOption) ([]uint64, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.exit()
+
 	// TODO: try to remove code-duplication: txn creation & notebook notebookBucket retrieval logic can be extracted out
 	// create a bolt-db transaction with deferred-rollback
+	start := time.Now()
 	tx, err := db.Begin(true)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer db.traceTx(tx, true, start)()
 	defer tx.Rollback()
+	defer db.timeWrite()()
 
+	skipped, err := db.deleteNotesInTx(tx, notebookName, noteIds, newWriteOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction.
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return skipped, err
+}
+
+/**
+ * Core logic of DeleteNotes, shared with ScopedDB
+ * return: ([]uint64, error) Ids of read-only notes left alone
+ */
+func (db *DB) deleteNotesInTx(tx *bolt.Tx, notebookName string, noteIds []uint64, options writeOptions) ([]uint64, error) {
+	if err := db.checkNotArchived(tx, notebookName); err != nil {
+		return nil, err
+	}
 	// retrieve (2nd order) bucket with given notebookName
-	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket([]byte(notebookName))
+	notebookKey := db.notebookKey(notebookName)
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+
+	// check for read-only notes up front, so that nothing gets deleted if deletion fails
+	var locked []uint64
+	if notebookBucket != nil && !options.force {
+		for _, noteId := range noteIds {
+			noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+			if encodedNote := notebookBucket.Get(noteIdBytes); encodedNote != nil {
+				if note, err := db.decodeNote(tx, notebookKey, noteIdBytes, encodedNote); err == nil && note.ReadOnly {
+					locked = append(locked, noteId)
+				}
+			}
+		}
+	}
+	if len(locked) > 0 {
+		if !options.skipLocked {
+			return nil, &ReadOnlyNotesError{Notebook: notebookName, Ids: locked}
+		}
+		noteIds = withoutIds(noteIds, locked)
+	}
+
+	if notebookBucket != nil {
+		var existing []uint64
+		for _, noteId := range noteIds {
+			if !containsId(existing, noteId) && notebookBucket.Get([]byte(strconv.FormatUint(noteId, 10))) != nil {
+				existing = append(existing, noteId)
+			}
+		}
+		if err := db.checkDeleteConfirmed(notebookName, len(existing), options); err != nil {
+			return nil, err
+		}
+	}
 
 	// for each noteId supplied
-	for _, noteId := range noteIds {
-		// delete the note with given noteId from notebook's bucket
-		err = notebookBucket.Delete([]byte(strconv.FormatUint(noteId, 10)))
-		if err != nil {
-			return err
+	var deletedNotes []Note
+	deleted := 0
+	for i, noteId := range noteIds {
+		if err := db.writeCheckpoint(i, len(noteIds)); err != nil {
+			return nil, err
+		}
+		noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+		// remember the note (if it exists) so that deletion can be undone
+		if encodedNote := notebookBucket.Get(noteIdBytes); encodedNote != nil {
+			deleted++
+			if err := db.recordChange(tx, ChangeDeleted, notebookKey, noteId, 0); err != nil {
+				return nil, err
+			}
+			// (corrupt notes, like ones with chunks missing, can't be restored, but can still be deleted)
+			note, err := db.decodeNote(tx, notebookKey, noteIdBytes, encodedNote)
+			if err != nil && !errors.Is(err, ErrCorruptNote) {
+				return nil, err
+			}
+			if err == nil {
+				deletedNotes = append(deletedNotes, note)
+			}
+		}
+		// delete the note with given noteId from notebook's bucket, along with everything stored with it
+		db.invalidateNote(tx, notebookKey, noteId)
+		if err := notebookBucket.Delete(noteIdBytes); err != nil {
+			return nil, err
+		}
+		if err := deleteNoteData(tx, notebookKey, noteId); err != nil {
+			return nil, err
 		}
 	}
 
-	// Commit the transaction.
-	if err := tx.Commit(); err != nil {
-		return err
+	if err := recordActivity(tx, notebookKey, dayActivity{Deleted: deleted}); err != nil {
+		return nil, err
 	}
+	// stash deleted notes in the same transaction
+	return locked, db.stashForUndo(tx, "delete", notebookName, deletedNotes)
+}
 
-	return err
+/**
+ * Retrieves notebook bucket and a note from it within given transaction
+ * Fails with ErrNotebookNotFound / ErrNoteNotFound if either of them doesn't exist
+ * param: *bolt.Tx tx
+ * param: string   notebookName
+ * param: uint64   noteId
+ * return: (*bolt.Bucket, Note, error)
+ */
+func (db *DB) getNoteInTx(tx *bolt.Tx, notebookName string, noteId uint64) (*bolt.Bucket, Note, error) {
+	var note Note
+	notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(db.notebookKey(notebookName))
+	if notebookBucket == nil {
+		return nil, note, fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+	}
+	encodedNote := notebookBucket.Get([]byte(strconv.FormatUint(noteId, 10)))
+	if encodedNote == nil {
+		return notebookBucket, note, fmt.Errorf("%w: %d in notebook '%s'", ErrNoteNotFound, noteId, notebookName)
+	}
+	note, err := db.decodeNote(tx, db.notebookKey(notebookName), []byte(strconv.FormatUint(noteId, 10)), encodedNote)
+	return notebookBucket, note, err
 }
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * A single note can be shared through a link (see CreateNoteShareLink), letting anyone holding it
+ * read that note (and nothing else) until the link expires or runs out of views
+ *  - a share token reads 'share_<id>_<secret>'; like API tokens, only a SHA-256 hash of the secret
+ *    is stored
+ *  - every view is counted in the same transaction that checks the share, so that concurrent
+ *    views can't consume the last one twice
+ *  - expired and exhausted shares are kept (so that their links keep telling they're gone) until
+ *    revoked; deleting the note (or moving it to another notebook) revokes its shares
+ * 'NoteShares' bucket: share id (8 byte big endian) -> JSON storedShare
+ * 'NoteShareIndex' bucket: notebook key -> note id -> share id (8 byte big endian) -> nothing
+ */
+
+/**
+ * Prefix of share tokens
+ */
+const shareTokenPrefix = "share_"
+
+var (
+	// returned for share tokens that are malformed, unknown or revoked, and for shares that don't exist
+	ErrNoteShareNotFound = errors.New("note share not found")
+	// returned by ViewSharedNote for shares that expired or ran out of views
+	ErrNoteShareGone = errors.New("note share is no longer available")
+	// returned by CreateNoteShareLink for shares that can't expire
+	ErrInvalidNoteShare = errors.New("invalid note share")
+)
+
+/**
+ * A note shared through a link (without the link's secret)
+ *  - MaxViews is the number of times the note can be viewed through the link (0 for any number)
+ */
+type NoteShare struct {
+	Id        uint64    `json:"id"`
+	Notebook  string    `json:"notebook"`
+	NoteId    uint64    `json:"note_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxViews  int       `json:"max_views,omitempty"`
+	Views     int       `json:"views"`
+}
+
+/**
+ * Whether the note can still be viewed through the share at given time
+ * param: time.Time now
+ * return: bool
+ */
+func (s NoteShare) Active(now time.Time) bool {
+	return now.Before(s.ExpiresAt) && (s.MaxViews == 0 || s.Views < s.MaxViews)
+}
+
+/**
+ * A note share as stored: with the hash of its secret
+ */
+type storedShare struct {
+	NoteShare
+	SecretHash string `json:"secret_hash"`
+}
+
+/**
+ * Shares a note through a link, valid for given duration and number of views (maxViews <= 0 for any
+ * number); the note is served at '/shared/<token>' by the REST API
+ * Fails with ErrNotebookNotFound / ErrNoteNotFound if either doesn't exist, and with ErrInvalidNoteShare
+ * if expiry isn't positive
+ * param: string        notebookName
+ * param: uint64        noteId
+ * param: time.Duration expiry
+ * param: int           maxViews
+ * return: (string, error) The share token; it can't be retrieved again
+ */
+func (db *DB) CreateNoteShareLink(notebookName string, noteId uint64, expiry time.Duration, maxViews int) (string, error) {
+	if expiry <= 0 {
+		return "", fmt.Errorf("%w: expiry must be positive (got %s)", ErrInvalidNoteShare, expiry)
+	}
+	if maxViews < 0 {
+		maxViews = 0
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encodedSecret := hex.EncodeToString(secret)
+
+	var token string
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, _, err := db.getNoteInTx(tx, notebookName, noteId); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		bucket, err := tx.CreateBucketIfNotExists([]byte("NoteShares"))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		stored := storedShare{
+			NoteShare: NoteShare{
+				Notebook:  notebookDisplayName(tx, notebookKey),
+				NoteId:    noteId,
+				CreatedAt: now,
+				ExpiresAt: now.Add(expiry),
+				MaxViews:  maxViews,
+			},
+			SecretHash: hashSecret(encodedSecret),
+		}
+		if stored.Id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		if err := putShare(bucket, stored); err != nil {
+			return err
+		}
+		index, err := createNoteShareIndexBucket(tx, notebookKey, noteId)
+		if err != nil {
+			return err
+		}
+		token = fmt.Sprintf("%s%d_%s", shareTokenPrefix, stored.Id, encodedSecret)
+		return index.Put(itob(stored.Id), []byte{})
+	})
+	return token, err
+}
+
+/**
+ * Lists shares of notes that can still be viewed, oldest first
+ * return: ([]NoteShare, error)
+ */
+func (db *DB) ListNoteShares() ([]NoteShare, error) {
+	var shares []NoteShare
+	now := time.Now()
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("NoteShares"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var stored storedShare
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if stored.Active(now) {
+				shares = append(shares, stored.NoteShare)
+			}
+			return nil
+		})
+	})
+	return shares, err
+}
+
+/**
+ * Revokes a note share, so that its link serves nothing anymore
+ * Fails with ErrNoteShareNotFound if there's no such share
+ * param: uint64 shareId
+ * return: error
+ */
+func (db *DB) RevokeNoteShare(shareId uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("NoteShares"))
+		if bucket == nil || bucket.Get(itob(shareId)) == nil {
+			return fmt.Errorf("%w: %d", ErrNoteShareNotFound, shareId)
+		}
+		var stored storedShare
+		if err := json.Unmarshal(bucket.Get(itob(shareId)), &stored); err != nil {
+			return err
+		}
+		if index := noteShareIndexBucket(tx, db.notebookKey(stored.Notebook), stored.NoteId); index != nil {
+			if err := index.Delete(itob(shareId)); err != nil {
+				return err
+			}
+		}
+		return bucket.Delete(itob(shareId))
+	})
+}
+
+/**
+ * Retrieves the note shared by a share token, counting a view of it
+ * Fails with ErrNoteShareNotFound if the token is malformed, unknown or revoked, and with
+ * ErrNoteShareGone if the share expired or ran out of views
+ * param: string token
+ * return: (Note, error)
+ */
+func (db *DB) ViewSharedNote(token string) (Note, error) {
+	var note Note
+	id, secret, ok := splitToken(shareTokenPrefix, token)
+	if !ok {
+		return note, ErrNoteShareNotFound
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("NoteShares"))
+		if bucket == nil {
+			return ErrNoteShareNotFound
+		}
+		encoded := bucket.Get(itob(id))
+		if encoded == nil {
+			return ErrNoteShareNotFound
+		}
+		var stored storedShare
+		if err := json.Unmarshal(encoded, &stored); err != nil {
+			return err
+		}
+		if !secretMatches(secret, stored.SecretHash) {
+			return ErrNoteShareNotFound
+		}
+		if now := time.Now(); !stored.Active(now) {
+			reason := "expired"
+			if now.Before(stored.ExpiresAt) {
+				reason = "ran out of views"
+			}
+			return fmt.Errorf("%w: share %d %s", ErrNoteShareGone, stored.Id, reason)
+		}
+		var err error
+		if _, note, err = db.getNoteInTx(tx, stored.Notebook, stored.NoteId); err != nil {
+			return err
+		}
+		stored.Views++
+		return putShare(bucket, stored)
+	})
+	return note, err
+}
+
+/**
+ * Revokes all shares of a note (see deleteNoteData)
+ */
+func deleteNoteShares(tx *bolt.Tx, notebookKey []byte, noteId uint64) error {
+	index := noteShareIndexBucket(tx, notebookKey, noteId)
+	if index == nil {
+		return nil
+	}
+	bucket := tx.Bucket([]byte("NoteShares"))
+	err := index.ForEach(func(shareId, _ []byte) error {
+		return bucket.Delete(shareId)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("NoteShareIndex")).Bucket(notebookKey).DeleteBucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+func putShare(bucket *bolt.Bucket, stored storedShare) error {
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(stored.Id), encoded)
+}
+
+/**
+ * Retrieves (3rd order) bucket of ids of a note's shares; nil if it has none
+ */
+func noteShareIndexBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) *bolt.Bucket {
+	rootBucket := tx.Bucket([]byte("NoteShareIndex"))
+	if rootBucket == nil {
+		return nil
+	}
+	notebookShares := rootBucket.Bucket(notebookKey)
+	if notebookShares == nil {
+		return nil
+	}
+	return notebookShares.Bucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+func createNoteShareIndexBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) (*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte("NoteShareIndex"))
+	if err != nil {
+		return nil, err
+	}
+	notebookShares, err := rootBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	return notebookShares.CreateBucketIfNotExists([]byte(strconv.FormatUint(noteId, 10)))
+}
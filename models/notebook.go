@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 	"log"
 	"strings"
 )
@@ -23,8 +23,8 @@ type Notebook struct {
 func (db *DB) NotebookExists(notebookName string) (bool, error) {
 	notebookExists := false
 	err := db.View(func(tx *bolt.Tx) error {
-		// conver notebookName to bytes
-		reqNotebookNameBytes := []byte(notebookName)
+		// conver notebookName to (bucket key) bytes
+		reqNotebookNameBytes := db.notebookKey(notebookName)
 		// retrieve BoldDb (base) bucket object
 		bucket := tx.Bucket([]byte("Notebook"))
 		// check if notebook by given name exists
@@ -47,15 +47,16 @@ func (db *DB) GetNotebook(notebookName string) (Notebook, error) {
 	var notebook Notebook
 	notebook.Name = notebookName
 	err := db.View(func(tx *bolt.Tx) error {
-		// conver notebookName to bytes
-		reqNotebookNameBytes := []byte(notebookName)
+		// conver notebookName to (bucket key) bytes
+		reqNotebookNameBytes := db.notebookKey(notebookName)
 		// retrieve BoldDb (base) bucket object
 		bucket := tx.Bucket([]byte("Notebook"))
 		// check if notebook by given name exists
 		foundNotebookNameBytes, _ := bucket.Cursor().Seek(reqNotebookNameBytes)
 		if foundNotebookNameBytes != nil && bytes.Equal(reqNotebookNameBytes, foundNotebookNameBytes) {
-			// if it exists, retrieve it's notes
-			notebook.Notes = getNotesInNotebook(bucket, reqNotebookNameBytes)
+			// if it exists, retrieve it's display name and notes
+			notebook.Name = notebookDisplayName(tx, reqNotebookNameBytes)
+			notebook.Notes = db.getNotesInNotebook(bucket, reqNotebookNameBytes)
 		}
 
 		return nil
@@ -75,11 +76,12 @@ func (db *DB) AddNotebook(notebook Notebook) error {
 		return err
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		err = tx.Bucket([]byte("Notebook")).Put([]byte(notebook.Name), encoded)
+		notebookKey := db.notebookKey(notebook.Name)
+		err = tx.Bucket([]byte("Notebook")).Put(notebookKey, encoded)
 		if err != nil {
 			return fmt.Errorf("could not set config: %v", err)
 		}
-		return nil
+		return ensureNotebookMeta(tx, notebookKey, notebook.Name)
 	})
 	return err
 }
@@ -93,7 +95,7 @@ func (db *DB) GetAllNotebooks() ([]Notebook, error) {
 	var notebooks []Notebook
 	err := db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("Notebook"))
-		notebooks = getNotebooksInRootBucket(bucket.Cursor(), bucket, false)
+		notebooks = db.getNotebooksInRootBucket(bucket.Cursor(), bucket, false)
 
 		return nil
 	})
@@ -102,18 +104,18 @@ func (db *DB) GetAllNotebooks() ([]Notebook, error) {
 
 /**
  * Retrieves all notebook names
- *  - delegates actual work to 'getNotebooksInRootBucket' function
+ *  - archived notebooks are left out unless WithArchivedNotebooks() is passed
+ * param: ...NotebookOption opts
+ * return: ([]string, error)
  */
-func (db *DB) GetAllNotebookNames() ([]string, error) {
+func (db *DB) GetAllNotebookNames(opts ...NotebookOption) ([]string, error) {
+	var options notebookOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	var notebookNames []string
 	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("Notebook"))
-		notebooks := getNotebooksInRootBucket(bucket.Cursor(), bucket, true)
-		// retrive names from notebook objects
-		for _, notebook := range notebooks {
-			notebookNames = append(notebookNames, notebook.Name)
-		}
-
+		notebookNames = notebookNamesInTx(tx, options.includeArchived)
 		return nil
 	})
 	return notebookNames, err
@@ -128,13 +130,13 @@ func (db *DB) GetAllNotebookNames() ([]string, error) {
  * param: bool onlyNames Whether to retrieve only name of notebooks or notes too
  * return: []Notebook
  */
-func getNotebooksInRootBucket(cursor *bolt.Cursor, bucket *bolt.Bucket, onlyNames bool) []Notebook {
+func (db *DB) getNotebooksInRootBucket(cursor *bolt.Cursor, bucket *bolt.Bucket, onlyNames bool) []Notebook {
 	var notebooks []Notebook
 	for notebookNameBytes, _ := cursor.First(); notebookNameBytes != nil; notebookNameBytes, _ = cursor.Next() {
 		var notebook Notebook
-		notebook.Name = string(notebookNameBytes)
+		notebook.Name = notebookDisplayName(bucket.Tx(), notebookNameBytes)
 		if !onlyNames {
-			notebook.Notes = getNotesInNotebook(bucket, notebookNameBytes)
+			notebook.Notes = db.getNotesInNotebook(bucket, notebookNameBytes)
 		}
 		notebooks = append(notebooks, notebook)
 	}
@@ -147,12 +149,11 @@ func getNotebooksInRootBucket(cursor *bolt.Cursor, bucket *bolt.Bucket, onlyName
  * param: []byte       notebookNameBytes
  * return: []Note
  */
-func getNotesInNotebook(bucket *bolt.Bucket, notebookNameBytes []byte) []Note {
+func (db *DB) getNotesInNotebook(bucket *bolt.Bucket, notebookNameBytes []byte) []Note {
 	var notes []Note
 	nestedBucketCursor := bucket.Bucket([]byte(notebookNameBytes)).Cursor()
 	for noteIdBytes, noteContentBytes := nestedBucketCursor.First(); noteIdBytes != nil; noteIdBytes, noteContentBytes = nestedBucketCursor.Next() {
-		var note Note
-		json.Unmarshal(noteContentBytes, &note)
+		note, _ := db.decodeNote(bucket.Tx(), notebookNameBytes, noteIdBytes, noteContentBytes)
 		notes = append(notes, note)
 	}
 	return notes
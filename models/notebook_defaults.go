@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Settings of a notebook applied to notes as they are added to it
+ *  - Tags are added to every new note (in addition to its own tags)
+ *  - ContentPrefix (if any) is prepended to content of every new note
+ * Changing defaults doesn't modify notes that already exist
+ */
+type NotebookDefaults struct {
+	Tags          []string `json:"tags,omitempty"`
+	ContentPrefix string   `json:"content_prefix,omitempty"`
+}
+
+/**
+ * Summary of a notebook (without its notes)
+ */
+type NotebookInfo struct {
+	Name      string           `json:"name"`
+	NoteCount int              `json:"note_count"`
+	Defaults  NotebookDefaults `json:"defaults"`
+	Archived  bool             `json:"archived"`
+	Abbrev    string           `json:"abbrev"`
+	// see unique_titles.go
+	UniqueTitles     bool `json:"unique_titles"`
+	AutoSuffixTitles bool `json:"auto_suffix_titles"`
+	// see notebook_policies.go
+	Policy *NotebookPolicy `json:"policy,omitempty"`
+	// the notebook is encrypted with a passphrase of its own, and its key isn't unlocked (see notebook_encryption.go)
+	Encrypted bool `json:"encrypted"`
+	Locked    bool `json:"locked"`
+}
+
+/**
+ * Sets defaults of a notebook (creating the notebook if it doesn't exist)
+ * Fails with ErrNotebookArchived if the notebook is archived
+ * param: string           notebookName
+ * param: NotebookDefaults defaults
+ * return: error
+ */
+func (db *DB) SetNotebookDefaults(notebookName string, defaults NotebookDefaults) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		if _, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists(notebookKey); err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.Defaults = defaults
+		return putNotebookMeta(tx, notebookKey, meta)
+	})
+}
+
+/**
+ * Retrieves summary of a notebook including its effective defaults
+ * param: string notebookName
+ * return: (NotebookInfo, error)
+ */
+func (db *DB) GetNotebookInfo(notebookName string) (NotebookInfo, error) {
+	var info NotebookInfo
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+
+		meta := getNotebookMeta(tx, notebookKey)
+		info.Name = notebookDisplayName(tx, notebookKey)
+		info.NoteCount = notebookBucket.Stats().KeyN
+		info.Defaults = meta.Defaults
+		info.Archived = meta.Archived
+		info.Abbrev = meta.Abbrev
+		info.UniqueTitles, info.AutoSuffixTitles = meta.UniqueTitles, meta.AutoSuffixTitles
+		info.Policy = meta.Policy
+		info.Encrypted, info.Locked = meta.Encryption != nil, db.notebookLocked(tx, notebookKey)
+		return nil
+	})
+	return info, err
+}
+
+/**
+ * Applies defaults to a note that is about to be added
+ */
+func (d NotebookDefaults) apply(note Note) Note {
+	note.Content = d.ContentPrefix + note.Content
+	note.Tags = append([]string(nil), note.Tags...)
+	for _, tag := range d.Tags {
+		if !containsString(note.Tags, tag) {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+	return note
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,576 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+)
+
+/**
+ * A notebook can be encrypted on its own, with a passphrase of its own (see EncryptNotebook), whatever
+ * the DB-wide encryption (see encryption.go)
+ *  - content of its notes is encrypted with AES-256-GCM under a random notebook key, stored in the
+ *    notebook's metadata wrapped (encrypted) under a key derived from the passphrase with argon2id,
+ *    along with the salt and parameters of the derivation
+ *  - changing the passphrase (see ChangeNotebookPassphrase) only wraps the notebook key anew: notes
+ *    aren't encrypted again
+ *  - encrypted content is stored as notebookSealedPrefix followed by the id of the key and the base64
+ *    of nonce and ciphertext, so that it's decrypted with the right key wherever it's read (like in
+ *    snapshots and undo entries)
+ *  - notebook keys are held in memory once given the passphrase (see UnlockNotebook), until LockNotebook
+ *    or Close; reading or writing content of a locked notebook fails with ErrNotebookLocked, and searches
+ *    across notebooks leave locked notebooks out
+ *  - past revisions of the notebook's notes are encrypted along with them; snapshots and undo entries
+ *    taken from then on are encrypted too, those taken before are left as they were
+ *  - as with DB-wide encryption, titles, tags and timestamps stay in plaintext, URLs aren't indexed,
+ *    attachments aren't encrypted, and plaintext may linger in free pages of the DB file
+ *  - exports of an encrypted notebook (see ExportNotebook) keep content encrypted, their manifest
+ *    carrying the wrapped key: importing them takes the notebook's passphrase, unless its key is unlocked
+ */
+
+/**
+ * Marks content encrypted with a notebook key as stored (followed by the key's id and ':')
+ */
+const notebookSealedPrefix = "\x00nb-aes-gcm:"
+
+var (
+	// returned when reading or writing content of an encrypted notebook before UnlockNotebook
+	ErrNotebookLocked = errors.New("notebook is encrypted: its passphrase is required")
+	// returned by EncryptNotebook for notebooks already encrypted
+	ErrNotebookEncrypted = errors.New("notebook is already encrypted")
+	// returned for notebooks that aren't encrypted by calls expecting one that is
+	ErrNotebookNotEncrypted = errors.New("notebook isn't encrypted")
+)
+
+/**
+ * Key of an encrypted notebook as stored in its metadata (and in exports of it)
+ *  - WrappedKey holds nonce and ciphertext of the notebook key, encrypted under the key derived
+ *    from the passphrase with argon2id (with Salt, Time, Memory in KiB and Threads)
+ *  - KeyId names the notebook key, which stays the same as the passphrase changes
+ */
+type NotebookEncryption struct {
+	KeyId      string `json:"key_id"`
+	Salt       []byte `json:"salt"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+/**
+ * Notebook keys unlocked, by id; its zero value is an empty keyring
+ */
+type notebookKeyring struct {
+	mu   sync.RWMutex
+	keys map[string]cipher.AEAD
+}
+
+func (k *notebookKeyring) get(keyId string) cipher.AEAD {
+	if k == nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[keyId]
+}
+
+func (k *notebookKeyring) put(keyId string, key cipher.AEAD) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string]cipher.AEAD)
+	}
+	k.keys[keyId] = key
+}
+
+func (k *notebookKeyring) drop(keyId string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, keyId)
+}
+
+func (k *notebookKeyring) clear() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = nil
+}
+
+/**
+ * Encrypts a notebook with given passphrase (see above): its notes (along with their history) are
+ * encrypted in a single transaction, and its key is left unlocked
+ * Fails with ErrNotebookEncrypted if the notebook already is, and with ErrEncryptionLocked if content
+ * is encrypted DB-wide and encryption isn't unlocked
+ * param: string notebookName
+ * param: string passphrase
+ * return: error
+ */
+func (db *DB) EncryptNotebook(notebookName string, passphrase string) error {
+	defer db.markBusy()()
+	if passphrase == "" {
+		return fmt.Errorf("%w: the passphrase is empty", ErrWrongPassphrase)
+	}
+	// (derived outside of the write transaction, argon2id being slow on purpose)
+	key, encryption, err := newNotebookKey(passphrase)
+	if err != nil {
+		return err
+	}
+	db.notebookKeys.put(encryption.KeyId, key)
+	err = db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		if meta.Encryption != nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookEncrypted, notebookName)
+		}
+		// (notes are read before the notebook is marked, while they're stored in plaintext)
+		var notes []Note
+		if err := notebookBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			note, err := db.decodeNote(tx, notebookKey, k, v)
+			if db.skipCorrupt(err) {
+				return nil
+			}
+			notes = append(notes, note)
+			return err
+		}); err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+		meta = getNotebookMeta(tx, notebookKey)
+		meta.Encryption = &encryption
+		if err := putNotebookMeta(tx, notebookKey, meta); err != nil {
+			return err
+		}
+
+		encoding := db.encodingFor(tx, notebookKey)
+		for _, note := range notes {
+			// (notes keep their revisions and timestamps)
+			prepared, err := encodeNote(note, encoding)
+			if err != nil {
+				return err
+			}
+			if err := putEncodedNote(tx, notebookKey, note.Id, prepared); err != nil {
+				return err
+			}
+			if err := resealHistory(tx, notebookKey, note.Id, encoding.sealer); err != nil {
+				return err
+			}
+		}
+		db.invalidateNotes(tx)
+		return nil
+	})
+	if err != nil {
+		db.notebookKeys.drop(encryption.KeyId)
+	}
+	return err
+}
+
+/**
+ * Unlocks an encrypted notebook for as long as the DB is open (or until LockNotebook), so that
+ * content of its notes can be read and written
+ * Fails with ErrNotebookNotEncrypted if the notebook isn't encrypted, and with ErrWrongPassphrase if the
+ * passphrase isn't the notebook's
+ * param: string notebookName
+ * param: string passphrase
+ * return: error
+ */
+func (db *DB) UnlockNotebook(notebookName string, passphrase string) error {
+	encryption, err := db.notebookEncryption(notebookName)
+	if err != nil {
+		return err
+	}
+	key, err := unwrapNotebookKey(encryption, passphrase)
+	if err != nil {
+		return err
+	}
+	db.notebookKeys.put(encryption.KeyId, key)
+	// histories of encrypted content can only be converted into diffs once unlocked (see revisions.go)
+	return db.Update(func(tx *bolt.Tx) error {
+		return convertStoredHistories(tx, db.sealer())
+	})
+}
+
+/**
+ * Forgets the key of an encrypted notebook (and every note read from the note cache), so that content
+ * of its notes can't be read again until UnlockNotebook
+ * Fails with ErrNotebookNotEncrypted if the notebook isn't encrypted
+ * param: string notebookName
+ * return: error
+ */
+func (db *DB) LockNotebook(notebookName string) error {
+	encryption, err := db.notebookEncryption(notebookName)
+	if err != nil {
+		return err
+	}
+	db.notebookKeys.drop(encryption.KeyId)
+	db.cache.invalidate(nil)
+	return nil
+}
+
+/**
+ * Changes the passphrase of an encrypted notebook, wrapping its key anew (notes aren't encrypted again)
+ * Fails with ErrNotebookNotEncrypted if the notebook isn't encrypted, and with ErrWrongPassphrase if
+ * oldPassphrase isn't the notebook's
+ * param: string notebookName
+ * param: string oldPassphrase
+ * param: string newPassphrase
+ * return: error
+ */
+func (db *DB) ChangeNotebookPassphrase(notebookName string, oldPassphrase string, newPassphrase string) error {
+	if newPassphrase == "" {
+		return fmt.Errorf("%w: the new passphrase is empty", ErrWrongPassphrase)
+	}
+	encryption, err := db.notebookEncryption(notebookName)
+	if err != nil {
+		return err
+	}
+	key, err := unwrapNotebookKey(encryption, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := wrapNotebookKey(encryption.KeyId, key.secret, newPassphrase)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		meta := getNotebookMeta(tx, notebookKey)
+		if meta.Encryption == nil || meta.Encryption.KeyId != encryption.KeyId {
+			// (the notebook got another key in between)
+			return fmt.Errorf("%w: '%s' changed meanwhile", ErrNotebookNotEncrypted, notebookName)
+		}
+		meta.Encryption = &rewrapped
+		return putNotebookMeta(tx, notebookKey, meta)
+	})
+}
+
+/**
+ * Encryption of a notebook; fails with ErrNotebookNotFound or ErrNotebookNotEncrypted
+ */
+func (db *DB) notebookEncryption(notebookName string) (NotebookEncryption, error) {
+	var encryption NotebookEncryption
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		if meta.Encryption == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotEncrypted, notebookName)
+		}
+		encryption = *meta.Encryption
+		return nil
+	})
+	return encryption, err
+}
+
+/**
+ * Whether content of a notebook is encrypted under a key that isn't unlocked
+ */
+func (db *DB) notebookLocked(tx *bolt.Tx, notebookKey []byte) bool {
+	encryption := getNotebookMeta(tx, notebookKey).Encryption
+	return encryption != nil && db.notebookKeys.get(encryption.KeyId) == nil
+}
+
+/**
+ * Makes the notebook of an export of an encrypted notebook (see ImportNotebook) ready for its notes
+ *  - the key of the export is unlocked (with passphrase, unless it already is)
+ *  - a notebook that doesn't exist yet is created encrypted with it, so that the passphrase stays the same
+ * Fails with ErrNotebookNotEncrypted for notebooks existing in plaintext (which would leave notes of the
+ * export in plaintext), and with ErrNotebookLocked for ones encrypted with a key that isn't unlocked
+ */
+func (db *DB) adoptNotebookEncryption(notebookName string, encryption NotebookEncryption, passphrase string) error {
+	if db.notebookKeys.get(encryption.KeyId) == nil {
+		if passphrase == "" {
+			return fmt.Errorf("%w: notes of the export are encrypted with the passphrase of their notebook", ErrPassphraseRequired)
+		}
+		key, err := unwrapNotebookKey(encryption, passphrase)
+		if err != nil {
+			return err
+		}
+		db.notebookKeys.put(encryption.KeyId, key)
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		if rootBucket.Bucket(notebookKey) != nil {
+			existing := getNotebookMeta(tx, notebookKey).Encryption
+			switch {
+			case existing == nil:
+				return fmt.Errorf("%w: '%s', while notes of the export are encrypted", ErrNotebookNotEncrypted, notebookName)
+			case db.notebookKeys.get(existing.KeyId) == nil:
+				return fmt.Errorf("%w: '%s'", ErrNotebookLocked, notebookName)
+			}
+			return nil
+		}
+		if _, err := rootBucket.CreateBucket(notebookKey); err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.Encryption = &encryption
+		return putNotebookMeta(tx, notebookKey, meta)
+	})
+}
+
+/**
+ * Re-encrypts past revisions of a note with given sealer (see migrateHistoryEncryption)
+ */
+func resealHistory(tx *bolt.Tx, notebookKey []byte, noteId uint64, sealer contentSealer) error {
+	historyBucket := noteHistoryBucket(tx, notebookKey, noteId)
+	if historyBucket == nil {
+		return nil
+	}
+	resealed := make(map[string][]byte)
+	err := historyBucket.ForEach(func(k, v []byte) error {
+		var revision storedRevision
+		if err := json.Unmarshal(v, &revision); err != nil {
+			return err
+		}
+		content, err := sealer.open(revision.Content)
+		if err != nil {
+			return err
+		}
+		if revision.Content, err = sealer.seal(content); err != nil {
+			return err
+		}
+		resealed[string(k)], err = json.Marshal(revision)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for k, encoded := range resealed {
+		if err := historyBucket.Put([]byte(k), encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Same as the sealer, sealing content as per the encryption of given notebook
+ */
+func (s contentSealer) forNotebook(tx *bolt.Tx, notebookKey []byte) contentSealer {
+	s.notebookKeyId = ""
+	if encryption := getNotebookMeta(tx, notebookKey).Encryption; encryption != nil {
+		s.notebookKeyId = encryption.KeyId
+	}
+	return s
+}
+
+/**
+ * Sealer of content of given notebook
+ */
+func (db *DB) notebookSealer(tx *bolt.Tx, notebookKey []byte) contentSealer {
+	return db.sealer().forNotebook(tx, notebookKey)
+}
+
+/**
+ * How notes of given notebook are encoded for storage
+ */
+func (db *DB) encodingFor(tx *bolt.Tx, notebookKey []byte) noteEncoding {
+	encoding := db.encoding()
+	encoding.sealer = encoding.sealer.forNotebook(tx, notebookKey)
+	return encoding
+}
+
+/**
+ * Same as encodingFor, within a read transaction of its own
+ */
+func (db *DB) notebookEncoding(notebookName string) (noteEncoding, error) {
+	var encoding noteEncoding
+	err := db.View(func(tx *bolt.Tx) error {
+		encoding = db.encodingFor(tx, db.notebookKey(notebookName))
+		return nil
+	})
+	return encoding, err
+}
+
+/**
+ * Whether an error is about content that can't be decrypted without a key, rather than a broken record
+ */
+func contentLocked(err error) bool {
+	return errors.Is(err, ErrEncryptionLocked) || errors.Is(err, ErrNotebookLocked)
+}
+
+/**
+ * Encrypts content with a notebook key (see above)
+ */
+func sealWithNotebookKey(keyId string, key cipher.AEAD, content string) (string, error) {
+	nonce := make([]byte, key.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.Seal(nonce, nonce, []byte(content), []byte(keyId))
+	return notebookSealedPrefix + keyId + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/**
+ * Decrypts content encrypted with a notebook key, looking the key up in keys
+ */
+func openWithNotebookKey(keys *notebookKeyring, content string) (string, error) {
+	rest := strings.TrimPrefix(content, notebookSealedPrefix)
+	separator := strings.IndexByte(rest, ':')
+	if separator < 0 {
+		return "", errors.New("encrypted content is malformed")
+	}
+	keyId := rest[:separator]
+	key := keys.get(keyId)
+	if key == nil {
+		return "", ErrNotebookLocked
+	}
+	sealed, err := base64.StdEncoding.DecodeString(rest[separator+1:])
+	if err != nil || len(sealed) < key.NonceSize() {
+		return "", errors.New("encrypted content is malformed")
+	}
+	nonceSize := key.NonceSize()
+	opened, err := key.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyId))
+	if err != nil {
+		return "", errors.New("encrypted content can't be decrypted: it was tampered with")
+	}
+	return string(opened), nil
+}
+
+/**
+ * Random notebook key, wrapped under the key derived from passphrase
+ */
+func newNotebookKey(passphrase string) (*notebookKeyCipher, NotebookEncryption, error) {
+	secret := make([]byte, 32)
+	id := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, NotebookEncryption{}, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return nil, NotebookEncryption{}, err
+	}
+	encryption, err := wrapNotebookKey(hex.EncodeToString(id), secret, passphrase)
+	if err != nil {
+		return nil, encryption, err
+	}
+	key, err := notebookCipher(secret)
+	return key, encryption, err
+}
+
+/**
+ * Wraps a notebook key under the key derived from passphrase, with a fresh salt
+ */
+func wrapNotebookKey(keyId string, secret []byte, passphrase string) (NotebookEncryption, error) {
+	encryption := NotebookEncryption{KeyId: keyId, Salt: make([]byte, encryptedSaltSize), Time: argon2Time, Memory: argon2Memory, Threads: argon2Threads}
+	if _, err := rand.Read(encryption.Salt); err != nil {
+		return encryption, err
+	}
+	wrapping, err := notebookCipher(argon2.IDKey([]byte(passphrase), encryption.Salt, encryption.Time, encryption.Memory, encryption.Threads, 32))
+	if err != nil {
+		return encryption, err
+	}
+	nonce := make([]byte, wrapping.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return encryption, err
+	}
+	encryption.WrappedKey = wrapping.Seal(nonce, nonce, secret, []byte(keyId))
+	return encryption, nil
+}
+
+/**
+ * Notebook key unwrapped with passphrase; fails with ErrWrongPassphrase if it isn't the notebook's
+ */
+func unwrapNotebookKey(encryption NotebookEncryption, passphrase string) (*notebookKeyCipher, error) {
+	if encryption.Time == 0 || encryption.Time > argon2MaxTime || encryption.Memory == 0 || encryption.Memory > argon2MaxMemory || encryption.Threads == 0 {
+		// (bounded like parameters of exports, as they may come from an export)
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidNoteExport)
+	}
+	wrapping, err := notebookCipher(argon2.IDKey([]byte(passphrase), encryption.Salt, encryption.Time, encryption.Memory, encryption.Threads, 32))
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := wrapping.NonceSize()
+	if len(encryption.WrappedKey) < nonceSize {
+		return nil, ErrWrongPassphrase
+	}
+	secret, err := wrapping.Open(nil, encryption.WrappedKey[:nonceSize], encryption.WrappedKey[nonceSize:], []byte(encryption.KeyId))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return notebookCipher(secret)
+}
+
+/**
+ * AES-256-GCM with given key, keeping the key so that it can be wrapped again (see ChangeNotebookPassphrase)
+ */
+type notebookKeyCipher struct {
+	cipher.AEAD
+	secret []byte
+}
+
+func notebookCipher(secret []byte) (*notebookKeyCipher, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &notebookKeyCipher{AEAD: aead, secret: secret}, nil
+}
+
+/**
+ * Encrypts content of a note export with the notebook key of given sealer, if it has one (see ExportNotebook)
+ *  - ContentHash is that of the encrypted content, for the export to be checked before it's decrypted
+ */
+func sealNoteExport(sealer contentSealer, export NoteExport) (NoteExport, error) {
+	if sealer.notebookKeyId == "" {
+		return export, nil
+	}
+	var err error
+	if export.Note.Content, err = sealer.seal(export.Note.Content); err != nil {
+		return export, err
+	}
+	export.ContentHash = contentHash(export.Note.Content)
+	return export, nil
+}
+
+/**
+ * Decrypts content of a note export sealed by sealNoteExport, with the notebook keys unlocked
+ * Fails with ErrInvalidNoteExport if content doesn't match its hash or can't be decrypted
+ */
+func (db *DB) openNoteExport(export NoteExport) (NoteExport, error) {
+	if export.ContentHash != contentHash(export.Note.Content) {
+		return export, fmt.Errorf("%w: content doesn't match its hash", ErrInvalidNoteExport)
+	}
+	if !strings.HasPrefix(export.Note.Content, notebookSealedPrefix) {
+		return export, fmt.Errorf("%w: content isn't encrypted", ErrInvalidNoteExport)
+	}
+	content, err := openWithNotebookKey(&db.notebookKeys, export.Note.Content)
+	if errors.Is(err, ErrNotebookLocked) {
+		return export, err
+	}
+	if err != nil {
+		return export, fmt.Errorf("%w: %v", ErrInvalidNoteExport, err)
+	}
+	export.Note.Content, export.ContentHash = content, contentHash(content)
+	return export, nil
+}
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Version of the format written by ExportNotebook (of its manifest: notes carry their own, see NoteExportFormat)
+ */
+const NotebookExportFormat = 1
+
+/**
+ * First record of an export written by ExportNotebook
+ *  - Filter is the filter notes were selected with, if it left out some (see NoteFilter.Partial)
+ *  - Encryption is the wrapped key of an encrypted notebook, content of notes of the export being
+ *    encrypted with it (see notebook_encryption.go)
+ */
+type NotebookExportManifest struct {
+	Format     int                 `json:"format"`
+	ExportedAt time.Time           `json:"exported_at"`
+	Notebook   string              `json:"notebook"`
+	Filter     *NoteFilter         `json:"filter,omitempty"`
+	Encryption *NotebookEncryption `json:"encryption,omitempty"`
+}
+
+/**
+ * Writes the notes of a notebook (along with their attachments) matching given options
+ * as a stream of JSON documents: a NotebookExportManifest followed by a NoteExport per note
+ *  - options are those of ListNotes (like WithTag or CreatedBetween); expired notes are left out
+ *    unless WithExpired() is passed
+ *  - records are copied out of the read transaction and written once it's closed, up to the decode
+ *    buffer past which notes are filtered and written one at a time while cursoring (see copied_reads.go)
+ *  - content of notes of an encrypted notebook stays encrypted (once rendered), the manifest carrying
+ *    the notebook's wrapped key; the notebook must be unlocked (see notebook_encryption.go)
+ * param: string        notebookName
+ * param: io.Writer     w
+ * param: ...ListOption opts
+ * return: error
+ */
+func (db *DB) ExportNotebook(notebookName string, w io.Writer, opts ...ListOption) error {
+	filter := newNoteFilter(opts)
+	notebookKey := db.notebookKey(notebookName)
+	encoder := json.NewEncoder(w)
+	// attachments and relations of notes having any, read along with their records (see copied_reads.go)
+	shells := make(map[uint64]NoteExport)
+	var shell NoteExport
+	var sealer contentSealer
+	return db.readNotes(notebookKey, filter, notesRead{
+		begin: func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+				return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+			}
+			shell = NoteExport{Format: NoteExportFormat, Notebook: notebookDisplayName(tx, notebookKey)}
+			sealer = db.notebookSealer(tx, notebookKey)
+			return encoder.Encode(notebookExportManifest(shell.Notebook, filter, getNotebookMeta(tx, notebookKey).Encryption))
+		},
+		copied: func(tx *bolt.Tx, noteId uint64) (int64, error) {
+			export, err := noteExportShellInTx(tx, notebookKey, noteId)
+			if err != nil || len(export.Attachments) == 0 && len(export.Relations) == 0 {
+				return 0, err
+			}
+			shells[noteId] = export
+			var size int64
+			for _, blob := range export.Blobs {
+				size += int64(len(blob))
+			}
+			return size, nil
+		},
+		each: func(tx *bolt.Tx, note Note) error {
+			export, err := shell, error(nil)
+			switch {
+			case tx != nil:
+				export, err = db.noteExportInTx(tx, notebookKey, note, false)
+			case shells[note.Id].Notebook != "":
+				export = shells[note.Id]
+				delete(shells, note.Id)
+			}
+			if err != nil {
+				return err
+			}
+			export.ExportedAt, export.Note, export.ContentHash = time.Now(), note, contentHash(note.Content)
+			if export, err = renderNoteExport(filter.Render, export); err != nil {
+				return err
+			}
+			if export, err = sealNoteExport(sealer, export); err != nil {
+				return err
+			}
+			return encoder.Encode(export)
+		},
+	})
+}
+
+/**
+ * Writes an export of a notebook as ExportNotebook does, within given transaction
+ * return: (int, error) Number of notes written
+ */
+func (db *DB) exportNotebookInTx(tx *bolt.Tx, notebookKey []byte, filter NoteFilter, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(notebookExportManifest(notebookDisplayName(tx, notebookKey), filter, getNotebookMeta(tx, notebookKey).Encryption)); err != nil {
+		return 0, err
+	}
+	sealer := db.notebookSealer(tx, notebookKey)
+	notes := 0
+	err := db.forEachMatchingNote(tx, notebookKey, filter, func(note Note) error {
+		export, err := db.noteExportInTx(tx, notebookKey, note, false)
+		if err == nil {
+			export, err = renderNoteExport(filter.Render, export)
+		}
+		if err == nil {
+			export, err = sealNoteExport(sealer, export)
+		}
+		if err != nil {
+			return err
+		}
+		notes++
+		return encoder.Encode(export)
+	})
+	return notes, err
+}
+
+func notebookExportManifest(notebookName string, filter NoteFilter, encryption *NotebookEncryption) NotebookExportManifest {
+	manifest := NotebookExportManifest{
+		Format:     NotebookExportFormat,
+		ExportedAt: time.Now(),
+		Notebook:   notebookName,
+		Encryption: encryption,
+	}
+	if filter.Partial() {
+		manifest.Filter = &filter
+	}
+	return manifest
+}
+
+/**
+ * Same as ExportNotebook, but encrypting the export with given passphrase (see EncryptExport)
+ * param: string        notebookName
+ * param: io.Writer     w
+ * param: string        passphrase
+ * param: ...ListOption opts
+ * return: error
+ */
+func (db *DB) ExportNotebookEncrypted(notebookName string, w io.Writer, passphrase string, opts ...ListOption) error {
+	encrypted, err := EncryptExport(w, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := db.ExportNotebook(notebookName, encrypted, opts...); err != nil {
+		return err
+	}
+	return encrypted.Close()
+}
+
+/**
+ * Imports notes written by ExportNotebook (or ExportNotebookEncrypted) into given notebook (created if it doesn't exist)
+ *  - every note is imported as by ImportNote (getting a fresh id), one at a time
+ *  - notes failing validation are skipped and reported; a malformed stream aborts the import
+ *  - if the export covers only part of its notebook, the report carries the filter it was made with
+ *  - encrypted exports are decrypted with opts.Passphrase; a wrong one fails the import before
+ *    anything is imported, while a truncated or tampered export fails it where that's detected
+ *  - exports of an encrypted notebook take opts.NotebookPassphrase (unless its key is unlocked), and
+ *    are imported into a notebook encrypted in turn: a new one gets the same key, see notebook_encryption.go
+ *  - a checkpoint is emitted every importCheckpointInterval notes, to resume from should the
+ *    import fail (see resume.go)
+ *  - relations between notes of the export are recreated once all of them are imported; those
+ *    involving notes imported before the checkpoint an import resumed from are lost
+ * param: string        notebookName
+ * param: io.Reader     r
+ * param: ImportOptions opts
+ * return: (ImportReport, error)
+ */
+func (db *DB) ImportNotebook(notebookName string, r io.Reader, opts ImportOptions) (ImportReport, error) {
+	report, err := db.importNotebook(notebookName, r, opts)
+	if opts.Mapping != nil {
+		if mappingErr := WriteIdMapping(opts.Mapping, report.Mapping); err == nil {
+			err = mappingErr
+		}
+	}
+	return report, err
+}
+
+func (db *DB) importNotebook(notebookName string, r io.Reader, opts ImportOptions) (ImportReport, error) {
+	defer db.markBusy()()
+	var report ImportReport
+	r, err := openExport(r, opts.Passphrase)
+	if err != nil {
+		return report, err
+	}
+	decoder := json.NewDecoder(r)
+	var data json.RawMessage
+	if err := decoder.Decode(&data); err != nil {
+		return report, exportReadError(err)
+	}
+	manifest, warnings, err := decodeNotebookExportManifest(data)
+	if err != nil {
+		return report, err
+	}
+	report.warn(warnings...)
+	report.Filter = manifest.Filter
+	if manifest.Encryption != nil {
+		if err := db.adoptNotebookEncryption(notebookName, *manifest.Encryption, opts.NotebookPassphrase); err != nil {
+			return report, err
+		}
+	}
+	progress, err := db.newImportProgress(notebookName, opts.ResumeFrom, opts.OnCheckpoint, &report)
+	if err != nil {
+		return report, err
+	}
+	resolver, err := db.newConflictResolver(notebookName, opts.OnConflict, &report.Conflicts)
+	if err != nil {
+		return report, err
+	}
+
+	records := 0
+	var relations []Relation
+	for {
+		// every note read so far has been committed
+		if records > 0 && records%importCheckpointInterval == 0 {
+			if err := progress.checkpoint(records, decoder.InputOffset()); err != nil {
+				return report, err
+			}
+		}
+		var data json.RawMessage
+		if err := decoder.Decode(&data); err == io.EOF {
+			if report.Relations, err = db.importRelations(notebookName, relations, report.Mapping); err != nil {
+				return report, err
+			}
+			return report, progress.checkpoint(records, decoder.InputOffset())
+		} else if err != nil {
+			return report, exportReadError(err)
+		}
+		if records++; progress.skipped(records) {
+			continue
+		}
+		export, warnings, err := decodeNoteExport(data)
+		if err != nil {
+			return report, err
+		}
+		report.warn(warnings...)
+		relations = append(relations, export.Relations...)
+		sourceKey := NoteRef{Notebook: export.Notebook, Id: export.Note.Id}.String()
+		source := RecordSource{Record: records, Offset: decoder.InputOffset()}
+		var ok bool
+		if manifest.Encryption != nil {
+			// (decrypted first, for transforms and checks to see content)
+			export, err = db.openNoteExport(export)
+		}
+		if err == nil {
+			export, ok, err = transformNoteExport(opts.Transform, export, source, &report)
+		}
+		switch {
+		case errors.Is(err, ErrInvalidNoteExport):
+			report.Skipped = append(report.Skipped, SkippedNote{Title: sourceKey, Reason: err.Error()})
+			report.Mapping = append(report.Mapping, mappingFailed(sourceKey, err))
+			continue
+		case err != nil:
+			return report, err
+		case !ok:
+			continue
+		}
+		if recovered, err := progress.recover(export.Note, sourceKey, false); err != nil || recovered {
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		note, sourceKey, status, err := db.importNoteExport(notebookName, export, opts, resolver)
+		switch {
+		case err == nil:
+			switch status {
+			case MappingCreated:
+				report.Imported++
+			case MappingDuplicate:
+				report.Duplicates++
+			}
+			report.Mapping = append(report.Mapping, mappedTo(sourceKey, notebookName, note.Id, status))
+		case errors.Is(err, ErrInvalidNoteExport), errors.Is(err, ErrNoteReadOnly):
+			report.Skipped = append(report.Skipped, SkippedNote{Title: sourceKey, Reason: err.Error()})
+			report.Mapping = append(report.Mapping, mappingFailed(sourceKey, err))
+		default:
+			return report, err
+		}
+	}
+}
+
+/**
+ * Recreates relations between imported notes, those whose notes both have been mapped onto a note
+ * return: (int, error) Number of relations recreated
+ */
+func (db *DB) importRelations(notebookName string, relations []Relation, mapping []IdMapping) (int, error) {
+	if len(relations) == 0 {
+		return 0, nil
+	}
+	imported := make(map[string]NoteRef)
+	for _, entry := range mapping {
+		if entry.Ref != nil {
+			imported[entry.SourceKey] = *entry.Ref
+		}
+	}
+	recreated := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		recreated = 0
+		notebookKey := db.notebookKey(notebookName)
+		for _, relation := range relations {
+			from, fromOk := imported[relation.From.String()]
+			to, toOk := imported[relation.To.String()]
+			if !fromOk || !toOk || validateRelation(notebookKey, from.Id, notebookKey, to.Id, relation.Kind) != nil {
+				continue
+			}
+			if _, _, err := db.getNoteInTx(tx, notebookName, from.Id); errors.Is(err, ErrNoteNotFound) {
+				continue
+			}
+			if _, _, err := db.getNoteInTx(tx, notebookName, to.Id); errors.Is(err, ErrNoteNotFound) {
+				continue
+			}
+			if err := putRelation(tx, notebookKey, from.Id, notebookKey, to.Id, relation.Kind); err != nil {
+				return err
+			}
+			recreated++
+		}
+		return nil
+	})
+	return recreated, err
+}
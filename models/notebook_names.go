@@ -0,0 +1,211 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+/**
+ * Returned by SetCaseInsensitiveNotebooks when existing notebooks
+ * can't be told apart once names are compared case-insensitively
+ */
+var ErrNotebookNameCollision = errors.New("notebook names collide when compared case-insensitively")
+
+/**
+ * Top-level buckets holding per-notebook sub-buckets keyed by notebook's bucket key;
+ * these are migrated along with the notebooks themselves
+ */
+var notebookKeyedBuckets = []string{"History", "Access", "Chunks", "Attachments", "URLs", "Stats", "Quarantine", "Reservations", "Relations", "Snapshots", "NoteShareIndex", "Rollups", "TagIndex", "TitleIndex", "Fingerprints"}
+
+/**
+ * A group of notebooks whose names map onto the same bucket key
+ */
+type NotebookNameCollision struct {
+	Key   string   `json:"key"`
+	Names []string `json:"names"`
+}
+
+/**
+ * Normalized form of a notebook name used as bucket key in case-insensitive mode
+ * (Unicode case-folding followed by NFC normalization)
+ */
+func NormalizeNotebookName(notebookName string) string {
+	return norm.NFC.String(cases.Fold().String(notebookName))
+}
+
+/**
+ * Returns the bucket key for given notebook name
+ *  - normalized name when case-insensitive notebook names are enabled
+ *  - name as it is otherwise
+ */
+func (db *DB) notebookKey(notebookName string) []byte {
+	if db.caseInsensitiveNames {
+		return []byte(NormalizeNotebookName(notebookName))
+	}
+	return []byte(notebookName)
+}
+
+/**
+ * Turns case-insensitive notebook names on or off
+ *  - migrates existing notebook buckets to their new keys (display names are preserved)
+ *  - if some notebooks would collide (say 'Work' and 'work'), nothing is changed
+ *    and the collisions are returned along with ErrNotebookNameCollision
+ * param: bool enabled
+ * return: ([]NotebookNameCollision, error)
+ */
+func (db *DB) SetCaseInsensitiveNotebooks(enabled bool) ([]NotebookNameCollision, error) {
+	defer db.markBusy()()
+	var collisions []NotebookNameCollision
+	err := db.Update(func(tx *bolt.Tx) error {
+		rootBucket := tx.Bucket([]byte("Notebook"))
+
+		// determine target key of every notebook
+		type move struct {
+			from, to    []byte
+			displayName string
+		}
+		var moves []move
+		namesByTarget := make(map[string][]string)
+		cursor := rootBucket.Cursor()
+		for notebookKey, _ := cursor.First(); notebookKey != nil; notebookKey, _ = cursor.Next() {
+			displayName := notebookDisplayName(tx, notebookKey)
+			target := displayName
+			if enabled {
+				target = NormalizeNotebookName(displayName)
+			}
+			namesByTarget[target] = append(namesByTarget[target], displayName)
+			if target != string(notebookKey) {
+				from := append([]byte(nil), notebookKey...)
+				moves = append(moves, move{from: from, to: []byte(target), displayName: displayName})
+			}
+		}
+
+		// refuse to merge notebooks silently
+		for target, names := range namesByTarget {
+			if len(names) > 1 {
+				collisions = append(collisions, NotebookNameCollision{Key: target, Names: names})
+			}
+		}
+		if len(collisions) > 0 {
+			sort.Slice(collisions, func(i, j int) bool { return collisions[i].Key < collisions[j].Key })
+			return ErrNotebookNameCollision
+		}
+
+		// move buckets via temporary keys, so that a target key
+		// still occupied by another (yet to be moved) notebook isn't clobbered
+		buckets := []*bolt.Bucket{rootBucket}
+		for _, bucketName := range notebookKeyedBuckets {
+			if bucket := tx.Bucket([]byte(bucketName)); bucket != nil {
+				buckets = append(buckets, bucket)
+			}
+		}
+		for _, bucket := range buckets {
+			var tmpKeys [][]byte
+			for i, m := range moves {
+				tmpKeys = append(tmpKeys, []byte(fmt.Sprintf("\x00migrating-%d", i)))
+				if bucket.Get(m.from) != nil || bucket.Bucket(m.from) != nil {
+					if err := moveRootKey(bucket, m.from, tmpKeys[i]); err != nil {
+						return err
+					}
+				}
+			}
+			for i, m := range moves {
+				if bucket.Get(tmpKeys[i]) != nil || bucket.Bucket(tmpKeys[i]) != nil {
+					if err := moveRootKey(bucket, tmpKeys[i], m.to); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// cached notes are cached under their notebooks' previous keys
+		db.invalidateNotes(tx)
+
+		// point relations and favorites at the notebooks' new keys
+		movedKeys := make(map[string]string)
+		for _, m := range moves {
+			movedKeys[string(m.from)] = string(m.to)
+		}
+		if err := remapRelations(tx, movedKeys); err != nil {
+			return err
+		}
+		if err := remapFavorites(tx, movedKeys); err != nil {
+			return err
+		}
+
+		// move metadata (recording display names of notebooks that had none yet)
+		var metas []notebookMeta
+		for _, m := range moves {
+			meta := getNotebookMeta(tx, m.from)
+			meta.DisplayName = m.displayName
+			metas = append(metas, meta)
+			if err := deleteNotebookMeta(tx, m.from); err != nil {
+				return err
+			}
+		}
+		for i, m := range moves {
+			if err := putNotebookMeta(tx, m.to, metas[i]); err != nil {
+				return err
+			}
+		}
+
+		// persist the setting
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.CaseInsensitiveNames = enabled
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return collisions, err
+	}
+
+	db.caseInsensitiveNames = enabled
+	return nil, nil
+}
+
+/**
+ * Moves a key (nested bucket or plain value) of given bucket to a new key
+ */
+func moveRootKey(bucket *bolt.Bucket, from, to []byte) error {
+	if nestedBucket := bucket.Bucket(from); nestedBucket != nil {
+		newBucket, err := bucket.CreateBucket(to)
+		if err != nil {
+			return err
+		}
+		if err := copyBucket(nestedBucket, newBucket); err != nil {
+			return err
+		}
+		return bucket.DeleteBucket(from)
+	}
+	value := append([]byte(nil), bucket.Get(from)...)
+	if err := bucket.Put(to, value); err != nil {
+		return err
+	}
+	return bucket.Delete(from)
+}
+
+/**
+ * Recursively copies contents (and sequence) of src bucket into dst bucket
+ */
+func copyBucket(src, dst *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nestedBucket, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nestedBucket)
+		}
+		return dst.Put(k, v)
+	})
+}
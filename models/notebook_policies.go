@@ -0,0 +1,359 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notebooks can have a policy archiving old notes, and deleting old archived ones (see SetNotebookPolicy),
+ * applied by ApplyPolicies (and by the janitor, if scheduled, see SchedulePolicies)
+ *  - notes don't have an archived flag: archiving a note tags it ArchivedTag (the way Keep imports do),
+ *    which doesn't count as a change of the note; notes tagged so by hand count as archived as well
+ *  - the age of a note is the time since its content last changed (since it was created if never updated);
+ *    notes older than ArchiveAfter are archived, and archived notes older than DeleteAfter are deleted
+ *    (a note archived by a run is deleted by a later one at the earliest)
+ *  - pinned notes (favorites, see favorites.go), notes having any of ExcludeTags and notes locked read-only
+ *    are never touched, nor are notes of unknown age (created before creation times were recorded)
+ *  - archived notebooks are left alone, as they can't be written
+ *  - the policy is kept in the notebook's metadata
+ */
+
+// tag notes archived by notebook policies get
+const ArchivedTag = "archived"
+
+/**
+ * Number of notes archived or deleted per write transaction by ApplyPolicies
+ */
+const policyBatch = 200
+
+// returned by SetNotebookPolicy for policies that don't make sense (like negative ages)
+var ErrInvalidNotebookPolicy = errors.New("invalid notebook policy")
+
+/**
+ * Policy of a notebook (see above); zero ages don't archive or delete anything
+ */
+type NotebookPolicy struct {
+	ArchiveAfter time.Duration `json:"archive_after,omitempty"`
+	DeleteAfter  time.Duration `json:"delete_after,omitempty"`
+	ExcludeTags  []string      `json:"exclude_tags,omitempty"`
+}
+
+/**
+ * Outcome of ApplyPolicies, by notebook (in order of names), and time taken
+ */
+type PolicyReport struct {
+	DryRun    bool                   `json:"dry_run,omitempty"`
+	Notebooks []NotebookPolicyReport `json:"notebooks"`
+	Took      time.Duration          `json:"took"`
+}
+
+/**
+ * Outcome of ApplyPolicies for a notebook: numbers of notes archived and deleted (or that would be, on a dry run),
+ * and of notes due that were left alone as they're pinned, excluded by tag or locked read-only
+ */
+type NotebookPolicyReport struct {
+	Notebook string `json:"notebook"`
+	Archived int    `json:"archived"`
+	Deleted  int    `json:"deleted"`
+	Pinned   int    `json:"pinned"`
+	Excluded int    `json:"excluded"`
+	Locked   int    `json:"locked"`
+}
+
+/**
+ * What a policy does to a note
+ */
+type policyAction int
+
+const (
+	policyKeep policyAction = iota
+	policyArchive
+	policyDelete
+)
+
+/**
+ * Notes of a notebook a policy archives and deletes, as planned by ApplyPolicies
+ */
+type policyPlan struct {
+	notebookName string
+	policy       NotebookPolicy
+	report       NotebookPolicyReport
+	archive      []uint64
+	delete       []uint64
+}
+
+/**
+ * Sets the policy of a notebook (see above); a zero policy removes it
+ * Fails with ErrInvalidNotebookPolicy for negative ages or empty excluded tags, with ErrNotebookNotFound if
+ * the notebook doesn't exist and with ErrNotebookArchived if it's archived
+ * param: string         notebookName
+ * param: NotebookPolicy policy
+ * return: error
+ */
+func (db *DB) SetNotebookPolicy(notebookName string, policy NotebookPolicy) error {
+	if policy.ArchiveAfter < 0 || policy.DeleteAfter < 0 {
+		return fmt.Errorf("%w: ages can't be negative", ErrInvalidNotebookPolicy)
+	}
+	for _, tag := range policy.ExcludeTags {
+		if tag == "" || tag == ArchivedTag {
+			return fmt.Errorf("%w: can't exclude tag '%s'", ErrInvalidNotebookPolicy, tag)
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.Policy = nil
+		if policy.ArchiveAfter > 0 || policy.DeleteAfter > 0 || len(policy.ExcludeTags) > 0 {
+			meta.Policy = &policy
+		}
+		return putNotebookMeta(tx, notebookKey, meta)
+	})
+}
+
+/**
+ * Makes the janitor apply notebook policies on its every run (or stop doing so); the setting is persisted in the DB
+ * param: bool scheduled
+ * return: error
+ */
+func (db *DB) SchedulePolicies(scheduled bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.ScheduledPolicies = scheduled
+		return putSettings(tx, settings)
+	})
+}
+
+/**
+ * Retrieves whether the janitor applies notebook policies (see SchedulePolicies)
+ * return: (bool, error)
+ */
+func (db *DB) PoliciesScheduled() (bool, error) {
+	var scheduled bool
+	err := db.View(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		scheduled = settings.ScheduledPolicies
+		return err
+	})
+	return scheduled, err
+}
+
+/**
+ * Archives and deletes notes as per the policies of notebooks (see above)
+ *  - notes due are collected in a read transaction (all a dry run does), then archived and deleted in write
+ *    transactions of policyBatch notes each, so that writers aren't held up for long; notes are checked
+ *    afresh in them, those no longer due (or gone) by then being skipped
+ *  - a dry run reports exactly what a run at the same time would do
+ * param: bool dryRun Whether to only report what would be done
+ * return: (PolicyReport, error) Report covers the batches committed before an error (if any)
+ */
+func (db *DB) ApplyPolicies(dryRun bool) (PolicyReport, error) {
+	start := time.Now()
+	report := PolicyReport{DryRun: dryRun}
+	var plans []*policyPlan
+	err := db.View(func(tx *bolt.Tx) error {
+		pinned, err := pinnedNotes(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("Notebook")).ForEach(func(notebookKey, _ []byte) error {
+			meta := getNotebookMeta(tx, notebookKey)
+			if meta.Policy == nil || meta.Archived {
+				return nil
+			}
+			plan := &policyPlan{notebookName: notebookDisplayName(tx, notebookKey), policy: *meta.Policy}
+			plan.report.Notebook = plan.notebookName
+			err := tx.Bucket([]byte("Notebook")).Bucket(notebookKey).ForEach(func(k, v []byte) error {
+				// records are read as they are: content isn't needed (nor decrypted)
+				var note Note
+				if v == nil || json.Unmarshal(v, &note) != nil {
+					return nil
+				}
+				switch plan.policy.action(note, start, pinned[string(favoriteKey(notebookKey, note.Id))], &plan.report) {
+				case policyArchive:
+					plan.archive = append(plan.archive, note.Id)
+				case policyDelete:
+					plan.delete = append(plan.delete, note.Id)
+				}
+				return nil
+			})
+			plan.report.Archived, plan.report.Deleted = len(plan.archive), len(plan.delete)
+			plans = append(plans, plan)
+			return err
+		})
+	})
+	sort.Slice(plans, func(i, j int) bool { return plans[i].notebookName < plans[j].notebookName })
+	if err != nil || dryRun {
+		for _, plan := range plans {
+			report.Notebooks = append(report.Notebooks, plan.report)
+		}
+		report.Took = time.Since(start)
+		return report, err
+	}
+
+	for _, plan := range plans {
+		plan.report.Archived, plan.report.Deleted = 0, 0
+		err = db.applyPolicyPlan(plan, start)
+		report.Notebooks = append(report.Notebooks, plan.report)
+		if errors.Is(err, ErrNotebookArchived) {
+			// (archived since it was planned)
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	report.Took = time.Since(start)
+	return report, err
+}
+
+/**
+ * Archives and deletes the notes of a plan in write transactions of policyBatch notes each, counting those
+ * archived and deleted into the plan's report
+ */
+func (db *DB) applyPolicyPlan(plan *policyPlan, now time.Time) error {
+	type step struct {
+		noteId uint64
+		action policyAction
+	}
+	var steps []step
+	for _, noteId := range plan.archive {
+		steps = append(steps, step{noteId, policyArchive})
+	}
+	for _, noteId := range plan.delete {
+		steps = append(steps, step{noteId, policyDelete})
+	}
+
+	notebookKey := db.notebookKey(plan.notebookName)
+	for start := 0; start < len(steps); start += policyBatch {
+		end := start + policyBatch
+		if end > len(steps) {
+			end = len(steps)
+		}
+		archived, deleted := 0, 0
+		err := db.Update(func(tx *bolt.Tx) error {
+			archived, deleted = 0, 0
+			if err := db.checkNotArchived(tx, plan.notebookName); err != nil {
+				return err
+			}
+			pinned, err := pinnedNotes(tx)
+			if err != nil {
+				return err
+			}
+			var deleting []uint64
+			for _, s := range steps[start:end] {
+				_, note, err := db.getNoteInTx(tx, plan.notebookName, s.noteId)
+				if errors.Is(err, ErrNoteNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				// (left alone now, the note was counted when planned; it isn't anymore)
+				var ignored NotebookPolicyReport
+				if plan.policy.action(note, now, pinned[string(favoriteKey(notebookKey, note.Id))], &ignored) != s.action {
+					continue
+				}
+				if s.action == policyDelete {
+					deleting = append(deleting, note.Id)
+					continue
+				}
+				note.Tags = append(note.Tags, ArchivedTag)
+				db.bumpRevision(&note)
+				if err := db.putNote(tx, notebookKey, note); err != nil {
+					return fmt.Errorf("archiving note %d: %w", note.Id, err)
+				}
+				archived++
+			}
+			if len(deleting) == 0 {
+				return nil
+			}
+			// (the policy is what confirms deletes, however many)
+			if _, err := db.deleteNotesInTx(tx, plan.notebookName, deleting, writeOptions{confirmed: true}); err != nil {
+				return err
+			}
+			deleted = len(deleting)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		plan.report.Archived += archived
+		plan.report.Deleted += deleted
+	}
+	return nil
+}
+
+/**
+ * What the policy does to a note at given time; notes due but left alone are counted into report
+ */
+func (p NotebookPolicy) action(note Note, now time.Time, pinned bool, report *NotebookPolicyReport) policyAction {
+	changed := note.UpdatedAt
+	if changed.IsZero() {
+		changed = note.CreatedAt
+	}
+	if changed.IsZero() {
+		return policyKeep
+	}
+	age := now.Sub(changed)
+	action := policyKeep
+	switch archived := containsString(note.Tags, ArchivedTag); {
+	case archived && p.DeleteAfter > 0 && age > p.DeleteAfter:
+		action = policyDelete
+	case !archived && p.ArchiveAfter > 0 && age > p.ArchiveAfter:
+		action = policyArchive
+	}
+	if action == policyKeep {
+		return policyKeep
+	}
+
+	switch {
+	case pinned:
+		report.Pinned++
+	case p.excludes(note):
+		report.Excluded++
+	case note.ReadOnly:
+		report.Locked++
+	default:
+		return action
+	}
+	return policyKeep
+}
+
+func (p NotebookPolicy) excludes(note Note) bool {
+	for _, tag := range p.ExcludeTags {
+		if containsString(note.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Keys of favorite notes (see favoriteKey), as a set
+ */
+func pinnedNotes(tx *bolt.Tx) (map[string]bool, error) {
+	favorites, err := readFavorites(tx)
+	pinned := make(map[string]bool, len(favorites))
+	for _, key := range favorites {
+		pinned[string(key)] = true
+	}
+	return pinned, err
+}
@@ -0,0 +1,319 @@
+package models
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notebooks can be snapshotted into the DB itself, to restore them from later (say, after a bulk
+ * operation gone wrong); unlike backups, snapshots are per notebook and need no external storage
+ *  - a snapshot holds the notes of the notebook as they were (chunked content included, but
+ *    not attachments, history or relations)
+ *  - 'Snapshots' bucket: notebook key -> snapshot id (8 byte big endian) -> 'meta' (a NotebookSnapshot)
+ *    and 'notes' (gzipped JSON array of the notes)
+ */
+
+/**
+ * Largest notebook (in bytes of stored notes) snapshotted, unless set otherwise with SetSnapshotLimit
+ */
+const DefaultSnapshotLimit = 64 << 20
+
+var (
+	// returned by SnapshotNotebook for notebooks above the snapshot limit (see SetSnapshotLimit)
+	ErrSnapshotTooLarge = errors.New("notebook is too large to snapshot")
+	// returned for snapshots that don't exist
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+/**
+ * Identifies a snapshot of a notebook (snapshots of each notebook are numbered from 1)
+ */
+type SnapshotID uint64
+
+/**
+ * A snapshot of a notebook, as listed by ListSnapshots
+ *  - Size is that of the notes as stored in the notebook (before compression)
+ */
+type NotebookSnapshot struct {
+	Id        SnapshotID `json:"id"`
+	Label     string     `json:"label,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Notes     int        `json:"notes"`
+	Size      int64      `json:"size"`
+}
+
+/**
+ * How RestoreSnapshot treats notes added since the snapshot was taken
+ */
+type RestoreMode int
+
+const (
+	// the notebook ends up as it was: notes added since are deleted
+	RestoreReplace RestoreMode = iota
+	// notes of the snapshot are restored, and notes added since are kept
+	RestoreMerge
+)
+
+/**
+ * Sets the size above which SnapshotNotebook refuses to snapshot a notebook
+ * Non-positive limit falls back to DefaultSnapshotLimit
+ */
+func (db *DB) SetSnapshotLimit(limit int64) {
+	db.snapshotLimit = limit
+}
+
+func (db *DB) maxSnapshotSize() int64 {
+	if db.snapshotLimit <= 0 {
+		return DefaultSnapshotLimit
+	}
+	return db.snapshotLimit
+}
+
+/**
+ * Snapshots the notes of a notebook, to be restored with RestoreSnapshot
+ * Fails with ErrNotebookNotFound if the notebook doesn't exist, and with ErrSnapshotTooLarge if its
+ * notes take more than the snapshot limit (see SetSnapshotLimit)
+ * param: string notebookName
+ * param: string label Describes the snapshot (like what it was taken before)
+ * return: (SnapshotID, error)
+ */
+func (db *DB) SnapshotNotebook(notebookName string, label string) (SnapshotID, error) {
+	var id SnapshotID
+	err := db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		snapshot := NotebookSnapshot{Label: label, CreatedAt: time.Now()}
+		limit := db.maxSnapshotSize()
+		var notes []Note
+		err := notebookBucket.ForEach(func(k, v []byte) error {
+			note, err := db.decodeNote(tx, notebookKey, k, v)
+			if err != nil {
+				return err
+			}
+			snapshot.Notes++
+			snapshot.Size += int64(len(v))
+			if noteChunksBucket(tx, notebookKey, note.Id) != nil {
+				snapshot.Size += int64(len(note.Content))
+			}
+			if snapshot.Size > limit {
+				return fmt.Errorf("%w: '%s' takes more than %d bytes", ErrSnapshotTooLarge, notebookName, limit)
+			}
+			notes = append(notes, note)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if notes, err = db.sealNotes(tx, notebookKey, notes); err != nil {
+			return err
+		}
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if err := json.NewEncoder(writer).Encode(notes); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		snapshots, err := createNotebookSnapshotsBucket(tx, notebookKey)
+		if err != nil {
+			return err
+		}
+		sequence, err := snapshots.NextSequence()
+		if err != nil {
+			return err
+		}
+		id, snapshot.Id = SnapshotID(sequence), SnapshotID(sequence)
+		snapshotBucket, err := snapshots.CreateBucket(itob(sequence))
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := snapshotBucket.Put([]byte("meta"), encoded); err != nil {
+			return err
+		}
+		return snapshotBucket.Put([]byte("notes"), compressed.Bytes())
+	})
+	return id, err
+}
+
+/**
+ * Lists snapshots of a notebook, oldest first
+ * param: string notebookName
+ * return: ([]NotebookSnapshot, error)
+ */
+func (db *DB) ListSnapshots(notebookName string) ([]NotebookSnapshot, error) {
+	var snapshots []NotebookSnapshot
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookSnapshots := notebookSnapshotsBucket(tx, db.notebookKey(notebookName))
+		if notebookSnapshots == nil {
+			return nil
+		}
+		return notebookSnapshots.ForEach(func(k, _ []byte) error {
+			var snapshot NotebookSnapshot
+			if err := json.Unmarshal(notebookSnapshots.Bucket(k).Get([]byte("meta")), &snapshot); err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+			return nil
+		})
+	})
+	return snapshots, err
+}
+
+/**
+ * Restores the notes of a notebook as they were when given snapshot was taken
+ *  - notes of the snapshot get back their content, tags and other fields (read-only ones included),
+ *    and notes deleted since come back under their ids
+ *  - notes added since are deleted in RestoreReplace mode, and kept in RestoreMerge mode
+ *  - the snapshot is kept, so that it can be restored again
+ * Fails with ErrSnapshotNotFound if there's no such snapshot, and ErrNotebookArchived if the
+ * notebook is archived
+ * param: string      notebookName
+ * param: SnapshotID  id
+ * param: RestoreMode mode
+ * return: error
+ */
+func (db *DB) RestoreSnapshot(notebookName string, id SnapshotID, mode RestoreMode) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		snapshotBucket, err := getSnapshotBucket(tx, notebookKey, notebookName, id)
+		if err != nil {
+			return err
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(snapshotBucket.Get([]byte("notes"))))
+		if err != nil {
+			return err
+		}
+		encoded, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		var notes []Note
+		if err := json.Unmarshal(encoded, &notes); err != nil {
+			return err
+		}
+		if err := db.openNotes(notes); err != nil {
+			return err
+		}
+		notebookBucket, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists(notebookKey)
+		if err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+
+		snapshotIds := make(map[uint64]bool)
+		for _, note := range notes {
+			snapshotIds[note.Id] = true
+		}
+		var activity dayActivity
+		if mode == RestoreReplace {
+			var addedKeys [][]byte
+			err := notebookBucket.ForEach(func(k, _ []byte) error {
+				if noteId, _ := strconv.ParseUint(string(k), 10, 64); !snapshotIds[noteId] {
+					addedKeys = append(addedKeys, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range addedKeys {
+				noteId, _ := strconv.ParseUint(string(k), 10, 64)
+				db.invalidateNote(tx, notebookKey, noteId)
+				if err := notebookBucket.Delete(k); err != nil {
+					return err
+				}
+				if err := deleteNoteData(tx, notebookKey, noteId); err != nil {
+					return err
+				}
+				if err := db.recordChange(tx, ChangeDeleted, notebookKey, noteId, 0); err != nil {
+					return err
+				}
+			}
+			activity.Deleted = len(addedKeys)
+		}
+		for i, note := range notes {
+			if err := db.writeCheckpoint(i, len(notes)); err != nil {
+				return err
+			}
+			if notebookBucket.Get([]byte(strconv.FormatUint(note.Id, 10))) == nil {
+				activity.Created++
+			} else {
+				activity.Updated++
+			}
+			// (notes are stored afresh, chunking content as they'd be now)
+			note.Chunks = nil
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return err
+			}
+		}
+		return recordActivity(tx, notebookKey, activity)
+	})
+}
+
+/**
+ * Deletes a snapshot of a notebook
+ * Fails with ErrSnapshotNotFound if there's no such snapshot
+ * param: string     notebookName
+ * param: SnapshotID id
+ * return: error
+ */
+func (db *DB) DeleteSnapshot(notebookName string, id SnapshotID) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if _, err := getSnapshotBucket(tx, notebookKey, notebookName, id); err != nil {
+			return err
+		}
+		return notebookSnapshotsBucket(tx, notebookKey).DeleteBucket(itob(uint64(id)))
+	})
+}
+
+func getSnapshotBucket(tx *bolt.Tx, notebookKey []byte, notebookName string, id SnapshotID) (*bolt.Bucket, error) {
+	if notebookSnapshots := notebookSnapshotsBucket(tx, notebookKey); notebookSnapshots != nil {
+		if snapshotBucket := notebookSnapshots.Bucket(itob(uint64(id))); snapshotBucket != nil {
+			return snapshotBucket, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d of notebook '%s'", ErrSnapshotNotFound, id, notebookName)
+}
+
+/**
+ * Retrieves (2nd order) snapshots bucket of a notebook; nil if it has no snapshots
+ */
+func notebookSnapshotsBucket(tx *bolt.Tx, notebookKey []byte) *bolt.Bucket {
+	rootBucket := tx.Bucket([]byte("Snapshots"))
+	if rootBucket == nil {
+		return nil
+	}
+	return rootBucket.Bucket(notebookKey)
+}
+
+func createNotebookSnapshotsBucket(tx *bolt.Tx, notebookKey []byte) (*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte("Snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	return rootBucket.CreateBucketIfNotExists(notebookKey)
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Transactions spanning several notebooks: MultiNotebookTx hands a closure a NotebookTx handle per
+ * notebook, all of them backed by a single write transaction, so that whatever the closure does
+ * across notebooks is committed (or rolled back, if it fails) as a whole
+ *  - handles read and write notes the way other operations do: content encoded (and sealed) as
+ *    configured, changes recorded in the outbox and in activity stats, and everything stored with
+ *    a note (chunks, attachments, relations ..) deleted along with it
+ *  - handles are only valid within the closure they're given to
+ */
+
+var (
+	// returned by MultiNotebookTx when a notebook is asked for more than once
+	ErrDuplicateNotebook = errors.New("notebook asked for more than once")
+	// returned by MultiNotebookTx for names of the DB's own buckets (and empty names)
+	ErrReservedNotebookName = errors.New("reserved notebook name")
+)
+
+/**
+ * Handle on a notebook within a MultiNotebookTx
+ */
+type NotebookTx struct {
+	db   *DB
+	tx   *bolt.Tx
+	name string
+	key  []byte
+}
+
+/**
+ * Option of MultiNotebookTx
+ */
+type NotebookTxOption func(*notebookTxOptions)
+
+type notebookTxOptions struct {
+	create bool
+}
+
+/**
+ * Creates notebooks that don't exist yet (rather than failing with ErrNotebookNotFound)
+ */
+func CreateNotebooks() NotebookTxOption {
+	return func(o *notebookTxOptions) {
+		o.create = true
+	}
+}
+
+/**
+ * Runs fn over handles on given notebooks (keyed by the names given) in a single write transaction,
+ * committed if fn succeeds and rolled back otherwise
+ *  - names are checked before the transaction is opened: asking for a notebook twice (under names that
+ *    are the same one, like 'Work' and 'work' with case-insensitive names) fails with ErrDuplicateNotebook,
+ *    and asking for one named like the DB's own buckets (like 'Meta') with ErrReservedNotebookName
+ *  - notebooks that don't exist fail with ErrNotebookNotFound, unless CreateNotebooks() is given
+ * param: []string                             names
+ * param: func(map[string]*NotebookTx) error  fn
+ * param: ...NotebookTxOption                 opts
+ * return: error
+ */
+func (db *DB) MultiNotebookTx(names []string, fn func(nbs map[string]*NotebookTx) error, opts ...NotebookTxOption) error {
+	var options notebookTxOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := db.checkNotebookTxNames(names); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		nbs, err := db.notebookTxs(tx, names, options.create)
+		if err != nil {
+			return err
+		}
+		return fn(nbs)
+	})
+}
+
+/**
+ * Fails with ErrDuplicateNotebook / ErrReservedNotebookName for names MultiNotebookTx can't be given
+ */
+func (db *DB) checkNotebookTxNames(names []string) error {
+	seen := make(map[string]string)
+	for _, name := range names {
+		if _, internal := bucketKinds[name]; internal || name == "" || strings.HasPrefix(name, "\x00") {
+			return fmt.Errorf("%w: '%s'", ErrReservedNotebookName, name)
+		}
+		notebookKey := string(db.notebookKey(name))
+		if previous, ok := seen[notebookKey]; ok {
+			return fmt.Errorf("%w: '%s' and '%s'", ErrDuplicateNotebook, previous, name)
+		}
+		seen[notebookKey] = name
+	}
+	return nil
+}
+
+/**
+ * Resolves notebooks into handles within given write transaction (creating missing ones if asked to)
+ */
+func (db *DB) notebookTxs(tx *bolt.Tx, names []string, create bool) (map[string]*NotebookTx, error) {
+	nbs := make(map[string]*NotebookTx)
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	for _, name := range names {
+		notebookKey := db.notebookKey(name)
+		if rootBucket.Bucket(notebookKey) == nil {
+			if !create {
+				return nil, fmt.Errorf("%w: '%s'", ErrNotebookNotFound, name)
+			}
+			if _, err := rootBucket.CreateBucket(notebookKey); err != nil {
+				return nil, err
+			}
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, name); err != nil {
+			return nil, err
+		}
+		nbs[name] = &NotebookTx{db: db, tx: tx, name: name, key: notebookKey}
+	}
+	return nbs, nil
+}
+
+/**
+ * Name of the notebook, as it was asked for
+ * return: string
+ */
+func (n *NotebookTx) Name() string {
+	return n.name
+}
+
+/**
+ * Retrieves a note
+ * Fails with ErrNoteNotFound if there's no note with given id
+ * param: uint64 noteId
+ * return: (Note, error)
+ */
+func (n *NotebookTx) Get(noteId uint64) (Note, error) {
+	_, note, err := n.db.getNoteInTx(n.tx, n.name, noteId)
+	return note, err
+}
+
+/**
+ * Takes the next id of the notebook, for a note to be put
+ * return: (uint64, error)
+ */
+func (n *NotebookTx) NextID() (uint64, error) {
+	return n.bucket().NextSequence()
+}
+
+/**
+ * Stores a note as it is, under its id (the next one of the notebook if it has none), replacing the
+ * note having that id if any; no history is kept of the replaced note, but its revision is bumped
+ * (so that writes expecting the replaced revision, like UpdateNoteIfRevision, fail)
+ * Fails with ErrNotebookArchived if the notebook is archived, and with ErrNoteReadOnly if the note
+ * replaced is locked read-only
+ * param: Note note
+ * return: (Note, error) The note as stored
+ */
+func (n *NotebookTx) Put(note Note) (Note, error) {
+	if err := n.db.checkNotArchived(n.tx, n.name); err != nil {
+		return note, err
+	}
+	activity := dayActivity{Created: 1}
+	if note.Id == 0 {
+		var err error
+		if note.Id, err = n.NextID(); err != nil {
+			return note, err
+		}
+		note.Fingerprint = noteFingerprint(note)
+	} else if existing, err := n.Get(note.Id); err == nil {
+		if err := checkWritable(n.name, existing, false); err != nil {
+			return note, err
+		}
+		activity = dayActivity{Updated: 1}
+		// (revisions carry on from the note replaced, whatever the one given says)
+		note.Revision = existing.Revision
+	} else if !errors.Is(err, ErrNoteNotFound) {
+		return note, err
+	}
+	n.db.bumpRevision(&note)
+	if err := n.db.putNote(n.tx, n.key, note); err != nil {
+		return note, err
+	}
+	return note, recordActivity(n.tx, n.key, activity)
+}
+
+/**
+ * Deletes a note, along with everything stored with it
+ * Fails with ErrNotebookArchived if the notebook is archived, with ErrNoteNotFound if there's no note
+ * with given id, and with ErrNoteReadOnly if it's locked read-only
+ * param: uint64 noteId
+ * return: error
+ */
+func (n *NotebookTx) Delete(noteId uint64) error {
+	if err := n.db.checkNotArchived(n.tx, n.name); err != nil {
+		return err
+	}
+	note, err := n.Get(noteId)
+	if err != nil {
+		return err
+	}
+	if err := checkWritable(n.name, note, false); err != nil {
+		return err
+	}
+	n.db.invalidateNote(n.tx, n.key, noteId)
+	if err := n.bucket().Delete([]byte(strconv.FormatUint(noteId, 10))); err != nil {
+		return err
+	}
+	if err := deleteNoteData(n.tx, n.key, noteId); err != nil {
+		return err
+	}
+	if err := n.db.recordChange(n.tx, ChangeDeleted, n.key, noteId, 0); err != nil {
+		return err
+	}
+	return recordActivity(n.tx, n.key, dayActivity{Deleted: 1})
+}
+
+func (n *NotebookTx) bucket() *bolt.Bucket {
+	return n.tx.Bucket([]byte("Notebook")).Bucket(n.key)
+}
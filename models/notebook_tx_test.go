@@ -0,0 +1,49 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestNotebookTxPutBumpsRevision(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "first")
+	if note.Revision != 1 {
+		t.Fatalf("added note at revision %d", note.Revision)
+	}
+
+	var replaced, added models.Note
+	err := db.MultiNotebookTx([]string{"work"}, func(nbs map[string]*models.NotebookTx) error {
+		stale := note
+		stale.Content, stale.Revision = "replaced", 0
+		var err error
+		if replaced, err = nbs["work"].Put(stale); err != nil {
+			return err
+		}
+		added, err = nbs["work"].Put(models.Note{Content: "added in the transaction"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the revision carries on from the note replaced, whatever the note given said
+	if replaced.Revision != 2 || added.Revision != 1 {
+		t.Errorf("revisions %d of the note replaced and %d of the note added, want 2 and 1", replaced.Revision, added.Revision)
+	}
+	if stored, err := db.GetNote("work", note.Id); err != nil || stored.Revision != 2 || stored.Content != "replaced" {
+		t.Errorf("stored note %+v (%v)", stored, err)
+	}
+
+	// a write expecting the revision read before the transaction's is stale
+	_, err = db.UpdateNoteIfRevision("work", note.Id, note.Revision, "lost update")
+	var conflict *models.RevisionConflictError
+	if !errors.As(err, &conflict) || conflict.Current.Revision != 2 || conflict.Current.Content != "replaced" {
+		t.Fatalf("update expecting a stale revision: %v, want a conflict with revision 2", err)
+	}
+	if _, err := db.UpdateNoteIfRevision("work", note.Id, replaced.Revision, "up to date"); err != nil {
+		t.Errorf("update expecting the current revision: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * The outbox makes changes of notes available to other systems (like sync) reliably
+ *  - once enabled (see EnableOutbox, persisted in the DB), every write of a note appends a
+ *    ChangeEvent to the 'Outbox' bucket within the write's own transaction: events are
+ *    recorded if and only if the change is committed, even if the process dies right after
+ *  - ProcessOutbox hands events to a handler in order of commit, deleting each only after
+ *    the handler succeeded: delivery is at-least-once, so handlers must be idempotent
+ *  - an event the handler fails on (or panics on) stays at the head of the outbox, and is
+ *    handed out again by the next ProcessOutbox; StartOutboxPump keeps doing so in the
+ *    background, backing off while the handler fails
+ * 'Outbox' bucket: sequence number (8 byte big endian) -> JSON ChangeEvent
+ */
+
+/**
+ * Kinds of changes of ChangeEvent
+ */
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+/**
+ * Longest a pump waits after its handler failed (doubling from its interval)
+ */
+const maxOutboxBackoff = 5 * time.Minute
+
+/**
+ * Number of events StartOutboxPump hands out per round
+ */
+const outboxPumpBatch = 100
+
+/**
+ * A committed change of a note, as recorded in the outbox
+ *  - Seq orders events by commit (and identifies them, for de-duplicating re-deliveries)
+ *  - Revision is the note's revision after the change (zero for deletions)
+ */
+type ChangeEvent struct {
+	Seq       uint64    `json:"seq"`
+	Kind      string    `json:"kind"`
+	Notebook  string    `json:"notebook"`
+	NoteId    uint64    `json:"note_id"`
+	Revision  uint64    `json:"revision,omitempty"`
+	Committed time.Time `json:"committed_at"`
+}
+
+/**
+ * Failure of an outbox handler on an event (the event stays in the outbox)
+ */
+type OutboxHandlerError struct {
+	Event ChangeEvent
+	Err   error
+}
+
+func (e *OutboxHandlerError) Error() string {
+	return fmt.Sprintf("outbox handler failed on event %d (note %d of notebook '%s' %s): %v",
+		e.Event.Seq, e.Event.NoteId, e.Event.Notebook, e.Event.Kind, e.Err)
+}
+
+func (e *OutboxHandlerError) Unwrap() error {
+	return e.Err
+}
+
+/**
+ * Turns recording of changes into the outbox on or off; the setting is persisted in the DB
+ * Events already recorded are kept (and can be processed) when it's turned off
+ * param: bool enabled
+ * return: error
+ */
+func (db *DB) EnableOutbox(enabled bool) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		if enabled && !settings.Outbox {
+			settings.OutboxEpoch++
+		}
+		settings.Outbox = enabled
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return err
+	}
+	db.outbox = enabled
+	return nil
+}
+
+/**
+ * Number of events waiting in the outbox
+ * return: (int, error)
+ */
+func (db *DB) OutboxDepth() (int, error) {
+	depth := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("Outbox")); bucket != nil {
+			depth = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	return depth, err
+}
+
+/**
+ * Hands up to batch events of the outbox to handler, oldest first, deleting every event the
+ * handler succeeds on; stops at the first failure (a returned error, or a panic), leaving that
+ * event at the head of the outbox
+ * Runs of ProcessOutbox (and pumps) take turns, so that events are handed out in order
+ * param: func(ChangeEvent) error handler
+ * param: int                     batch
+ * return: (int, error) Number of events handled; *OutboxHandlerError if the handler failed
+ */
+func (db *DB) ProcessOutbox(handler func(ChangeEvent) error, batch int) (int, error) {
+	db.outboxMu.Lock()
+	defer db.outboxMu.Unlock()
+
+	var events []ChangeEvent
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Outbox"))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && len(events) < batch; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	handled := 0
+	var handlerErr error
+	for _, event := range events {
+		if err := callOutboxHandler(handler, event); err != nil {
+			handlerErr = &OutboxHandlerError{Event: event, Err: err}
+			break
+		}
+		handled++
+	}
+	if handled > 0 {
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("Outbox"))
+			for _, event := range events[:handled] {
+				if err := bucket.Delete(itob(event.Seq)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// the events are handed out again, which at-least-once delivery allows for
+			return 0, err
+		}
+	}
+	return handled, handlerErr
+}
+
+/**
+ * Calls an outbox handler, turning a panic into an error
+ */
+func callOutboxHandler(handler func(ChangeEvent) error, event ChangeEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(event)
+}
+
+/**
+ * Starts a background goroutine that keeps handing events of the outbox to handler
+ * (see ProcessOutbox), checking for new ones every interval
+ *  - while the handler fails, the failing event is retried after a delay doubling from
+ *    interval up to maxOutboxBackoff; failures are reported through the Logger
+ * Returned stop func stops the goroutine and waits for a round in progress to finish;
+ * it is safe to call it more than once, and it is called by Close too
+ * param: func(ChangeEvent) error handler
+ * param: time.Duration           interval
+ * return: func()
+ */
+func (db *DB) StartOutboxPump(handler func(ChangeEvent) error, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		var delay time.Duration
+		for {
+			handled, err := db.ProcessOutbox(handler, outboxPumpBatch)
+			switch {
+			case err != nil:
+				if delay *= 2; delay < interval {
+					delay = interval
+				} else if delay > maxOutboxBackoff {
+					delay = maxOutboxBackoff
+				}
+				db.logf("outbox: %v (retrying in %v)", err, delay)
+			case handled == outboxPumpBatch:
+				// more events may be waiting
+				delay = 0
+			default:
+				delay = interval
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+	db.onClose(stop)
+	return stop
+}
+
+/**
+ * Appends a change of a note to the outbox (if enabled) within given write transaction
+ */
+func (db *DB) recordChange(tx *bolt.Tx, kind string, notebookKey []byte, noteId uint64, revision uint64) error {
+	if !db.outbox {
+		return nil
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("Outbox"))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(ChangeEvent{
+		Seq:       seq,
+		Kind:      kind,
+		Notebook:  notebookDisplayName(tx, notebookKey),
+		NoteId:    noteId,
+		Revision:  revision,
+		Committed: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(seq), encoded)
+}
+
+/**
+ * Records a note about to be stored by putNote as created or updated, depending on whether it exists
+ */
+func (db *DB) recordPut(tx *bolt.Tx, notebookKey []byte, note Note) error {
+	if !db.outbox {
+		return nil
+	}
+	kind := ChangeUpdated
+	if notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey); notebookBucket == nil ||
+		notebookBucket.Get([]byte(strconv.FormatUint(note.Id, 10))) == nil {
+		kind = ChangeCreated
+	}
+	return db.recordChange(tx, kind, notebookKey, note.Id, note.Revision)
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes can be arranged by hand within their notebook (like cards on a board), by their Position
+ *  - notes never moved have no position (zero); arranged notes come first (by position),
+ *    followed by the others in order of ids (see SortByPosition)
+ *  - positions are spaced positionGap apart, and a note moved between two others gets the
+ *    midpoint of their positions, so that a move rewrites only the note moved; notes are
+ *    renumbered only once the gap between neighbours is exhausted (or when moving before
+ *    a note that has no position yet)
+ *  - moves run in a single write transaction, so concurrent moves can't hand out the same
+ *    position; notes that still share one (like ones imported from several exports) are
+ *    ordered by id
+ *  - moving is arrangement rather than an edit: revisions aren't bumped, and notes locked
+ *    read-only can be moved
+ */
+const positionGap = 1024
+
+/**
+ * Sorts notes listed by their position (see above) instead of by id
+ */
+func SortByPosition() ListOption {
+	return func(filter *NoteFilter) {
+		filter.SortByPosition = true
+	}
+}
+
+/**
+ * Moves a note right before another one of the notebook
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: uint64 beforeId
+ * return: error
+ */
+func (db *DB) MoveNoteBefore(notebookName string, noteId uint64, beforeId uint64) error {
+	return db.moveNote(notebookName, noteId, beforeId)
+}
+
+/**
+ * Moves a note after all notes of the notebook arranged by hand (notes never moved follow it)
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: error
+ */
+func (db *DB) MoveNoteToEnd(notebookName string, noteId uint64) error {
+	return db.moveNote(notebookName, noteId, 0)
+}
+
+/**
+ * Moves a note before the one with id beforeId, or to the end if beforeId is 0
+ */
+func (db *DB) moveNote(notebookName string, noteId uint64, beforeId uint64) error {
+	if noteId == beforeId {
+		return nil
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, _, err := db.getNoteInTx(tx, notebookName, noteId); err != nil {
+			return err
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		if beforeId != 0 {
+			if _, _, err := db.getNoteInTx(tx, notebookName, beforeId); err != nil {
+				return err
+			}
+		}
+		notebookKey := db.notebookKey(notebookName)
+		var moving Note
+		var order []Note
+		err := db.forEachMatchingNote(tx, notebookKey, NoteFilter{IncludeExpired: true}, func(note Note) error {
+			if note.Id == noteId {
+				moving = note
+			} else {
+				order = append(order, note)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sortByPosition(order)
+
+		changed := placeNote(order, moving, beforeId)
+		for _, note := range changed {
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Assigns positions placing a note before beforeId (0 for the end) in order (the other notes,
+ * sorted by position), returning the notes whose position changed
+ */
+func placeNote(order []Note, moving Note, beforeId uint64) []Note {
+	lastPositioned := -1
+	for i, note := range order {
+		if note.Position > 0 {
+			lastPositioned = i
+		}
+	}
+
+	if beforeId == 0 {
+		moving.Position = positionGap
+		if lastPositioned >= 0 {
+			moving.Position = order[lastPositioned].Position + positionGap
+		}
+		return []Note{moving}
+	}
+
+	index := 0
+	for index < len(order) && order[index].Id != beforeId {
+		index++
+	}
+	if before := order[index]; before.Position > 0 {
+		// notes with positions come first, so the one preceding before has a position too
+		var lower float64
+		if index > 0 {
+			lower = order[index-1].Position
+		}
+		if middle := lower + (before.Position-lower)/2; middle > lower && middle < before.Position {
+			moving.Position = middle
+			return []Note{moving}
+		}
+	}
+
+	// renumber notes up to before, or to the last one having a position if it's further along
+	// (indexes in arranged being one more than in order from index on)
+	arranged := append(append(append([]Note{}, order[:index]...), moving), order[index:]...)
+	end := index + 1
+	if lastPositioned+1 > end {
+		end = lastPositioned + 1
+	}
+	var changed []Note
+	for i := 0; i <= end; i++ {
+		if position := float64((i + 1) * positionGap); arranged[i].Position != position {
+			arranged[i].Position = position
+			changed = append(changed, arranged[i])
+		}
+	}
+	return changed
+}
+
+/**
+ * Sorts notes by position, notes without one last; ties (and notes without position) by id
+ */
+func sortByPosition(notes []Note) {
+	sort.SliceStable(notes, func(i, j int) bool {
+		a, b := notes[i], notes[j]
+		if (a.Position > 0) != (b.Position > 0) {
+			return a.Position > 0
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.Id < b.Id
+	})
+}
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Write paths marshal notes *before* opening the write transaction, so that bolt's single
+ * writer lock is held only for the Put / Delete calls themselves
+ *  - ids are only known inside the transaction; notes are therefore marshalled with a
+ *    placeholder id of 0 which is spliced with the real id at Put time (Id is the first
+ *    field of Note, so its JSON always starts with `{"id":0,`)
+ *  - anything read to prepare the notes (like notebook defaults) is re-checked inside the
+ *    transaction; if it changed in between, the notes are prepared again (rare slow path)
+ */
+
+var placeholderIdPrefix = []byte(`{"id":0,`)
+
+/**
+ * Number of times a prepared write is retried when the data it was prepared from changed
+ */
+const maxPrepareAttempts = 3
+
+/**
+ * Returned (within a write transaction, to roll it back) when data a write was prepared from has changed
+ */
+var errStalePrepare = errors.New("data changed since the write was prepared")
+
+/**
+ * A note marshalled ahead of its write transaction
+ */
+type preparedNote struct {
+	note    Note
+	encoded []byte
+	// content split into chunks if it's large (see chunks.go), the record then having no content
+	chunks []string
+	// URLs of the content (see urls.go)
+	urls []string
+	// id of the notebook key content is encrypted with (see notebook_encryption.go)
+	sealedWith string
+}
+
+/**
+ * Notes to be added to a notebook, prepared ahead of the write transaction
+ */
+type preparedAdd struct {
+	notebookName string
+	defaults     NotebookDefaults
+	notes        []Note
+	prepared     []preparedNote
+	// notes are stored as they are, without notebook defaults (like imported notes)
+	skipDefaults bool
+	// user recorded as owner if the notebook gets created (see ScopedDB)
+	owner string
+	// ids reserved for the notes (see ReserveNoteIDs); new ones are allocated if nil
+	ids []uint64
+}
+
+/**
+ * Applies notebook defaults to notes and marshals them, outside of any write transaction
+ * param: string notebookName
+ * param: []Note notes
+ * return: (preparedAdd, error)
+ */
+func (db *DB) prepareAdd(notebookName string, notes []Note) (preparedAdd, error) {
+	batch := preparedAdd{notebookName: notebookName, notes: notes}
+	var encoding noteEncoding
+	err := db.View(func(tx *bolt.Tx) error {
+		batch.defaults = getNotebookMeta(tx, db.notebookKey(notebookName)).Defaults
+		encoding = db.encodingFor(tx, db.notebookKey(notebookName))
+		return nil
+	})
+	if err != nil {
+		return batch, err
+	}
+	batch.prepared, err = prepareNotes(notes, batch.defaults, encoding)
+	return batch, err
+}
+
+/**
+ * Stores prepared notes within given write transaction
+ *  - creates the notebook if it doesn't exist
+ *  - reserves ids for all notes at once by bumping notebook's sequence (unless batch has ids reserved
+ *    by ReserveNoteIDs, which are taken out of the reservations)
+ *  - fails with ErrNotebookArchived if the notebook is archived, and with a *TitleTakenError if a title is
+ *    taken in a notebook requiring unique titles (unless taken titles get suffixed, see unique_titles.go)
+ * return: ([]Note, error) The notes as stored
+ */
+func (db *DB) commitAdd(tx *bolt.Tx, batch preparedAdd) ([]Note, error) {
+	if err := db.checkNotArchived(tx, batch.notebookName); err != nil {
+		return nil, err
+	}
+	// create or retrieve (2nd order) bucket with given notebookName
+	notebookKey := db.notebookKey(batch.notebookName)
+	created := tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil
+	notebookBucket, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureNotebookMeta(tx, notebookKey, batch.notebookName); err != nil {
+		return nil, err
+	}
+	if created && batch.owner != "" {
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.Owner = batch.owner
+		if err := putNotebookMeta(tx, notebookKey, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	// re-prepare if defaults (or the notebook's encryption) changed since notes were prepared
+	prepared := batch.prepared
+	meta := getNotebookMeta(tx, notebookKey)
+	encoding := db.encodingFor(tx, notebookKey)
+	defaults := batch.defaults
+	if !batch.skipDefaults {
+		defaults = meta.Defaults
+	}
+	if !reflect.DeepEqual(defaults, batch.defaults) || !sealedWith(prepared, encoding.sealer.notebookKeyId) {
+		if prepared, err = prepareNotes(batch.notes, defaults, encoding); err != nil {
+			return nil, err
+		}
+	}
+
+	// reserve ids for the whole batch at once, unless they were reserved beforehand
+	ids := batch.ids
+	if ids == nil {
+		firstId := notebookBucket.Sequence() + 1
+		if err := notebookBucket.SetSequence(notebookBucket.Sequence() + uint64(len(prepared))); err != nil {
+			return nil, err
+		}
+		for i := range prepared {
+			ids = append(ids, firstId+uint64(i))
+		}
+	} else if err := takeReservedIds(tx, notebookKey, batch.notebookName, ids); err != nil {
+		return nil, err
+	}
+
+	var added []Note
+	for i, p := range prepared {
+		if err := db.writeCheckpoint(i, len(prepared)); err != nil {
+			return nil, err
+		}
+		if meta.AutoSuffixTitles {
+			if p, err = db.suffixTakenTitle(tx, notebookKey, p); err != nil {
+				return nil, err
+			}
+		}
+		note, encodedNote := p.withId(ids[i])
+		if err := db.runBeforeSave(batch.notebookName, note); err != nil {
+			return nil, err
+		}
+		db.invalidateNote(tx, notebookKey, note.Id)
+		traceNotes(tx, notebookKey, 0, 1)
+		if err := notebookBucket.Put([]byte(strconv.FormatUint(note.Id, 10)), encodedNote); err != nil {
+			return nil, err
+		}
+		if err := db.recordChange(tx, ChangeCreated, notebookKey, note.Id, note.Revision); err != nil {
+			return nil, err
+		}
+		if len(p.chunks) > 0 {
+			if err := putChunks(tx, notebookKey, note.Id, p.chunks); err != nil {
+				return nil, err
+			}
+		}
+		if len(p.urls) > 0 {
+			if err := putURLs(tx, notebookKey, note.Id, p.urls); err != nil {
+				return nil, err
+			}
+		}
+		if err := putSuggestions(tx, notebookKey, note); err != nil {
+			return nil, err
+		}
+		if err := putFingerprint(tx, notebookKey, note.Id, note.Fingerprint); err != nil {
+			return nil, err
+		}
+		added = append(added, note)
+	}
+	return added, recordActivity(tx, notebookKey, dayActivity{Created: len(added)})
+}
+
+/**
+ * Applies defaults to notes (inferring titles, see titles.go), normalizes their content and marshals them with a placeholder id (see encodeNote)
+ */
+func prepareNotes(notes []Note, defaults NotebookDefaults, encoding noteEncoding) ([]preparedNote, error) {
+	var prepared []preparedNote
+	now := time.Now()
+	for _, note := range notes {
+		var err error
+		if note.Content, err = encoding.normalize(note.Content); err != nil {
+			return nil, err
+		}
+		note = encoding.titles.onCreate(defaults.apply(note))
+		note.Id = 0
+		note.Revision = 1
+		if note.CreatedAt.IsZero() {
+			note.CreatedAt = now
+		}
+		note.Fingerprint = noteFingerprint(note)
+		if note.Clock == nil {
+			// (imported notes keep their clock, an empty one for notes without, see importNoteExport)
+			note.Clock = note.Clock.tick(encoding.device)
+		}
+		p, err := encodeNote(note, encoding)
+		if err != nil {
+			return nil, err
+		}
+		prepared = append(prepared, p)
+	}
+	return prepared, nil
+}
+
+/**
+ * Whether prepared notes are all encrypted with given notebook key (none of them is, for an empty id)
+ */
+func sealedWith(prepared []preparedNote, keyId string) bool {
+	for _, p := range prepared {
+		if p.sealedWith != keyId {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Returns the note with given id along with its JSON, splicing the id into pre-marshalled JSON
+ */
+func (p preparedNote) withId(id uint64) (Note, []byte) {
+	note := p.note
+	note.Id = id
+	if !bytes.HasPrefix(p.encoded, placeholderIdPrefix) {
+		// can't happen as long as Id is the first field of Note; marshal afresh to stay correct regardless
+		var record Note
+		json.Unmarshal(p.encoded, &record)
+		record.Id = id
+		encoded, _ := json.Marshal(record)
+		return note, encoded
+	}
+	encoded := make([]byte, 0, len(p.encoded)+20)
+	encoded = append(encoded, `{"id":`...)
+	encoded = strconv.AppendUint(encoded, id, 10)
+	encoded = append(encoded, ',')
+	encoded = append(encoded, p.encoded[len(placeholderIdPrefix):]...)
+	return note, encoded
+}
+
+/**
+ * Update of a note's content, prepared ahead of the write transaction
+ *  - current / historySeq record what the update was prepared from
+ */
+type preparedUpdate struct {
+	notebookName string
+	note         Note
+	current      []byte
+	historySeq   uint64
+	prepared     preparedNote
+	revision     revisionWrite
+}
+
+/**
+ * Reads the note and marshals its updated version (and the revision archiving its
+ * current content), outside of any write transaction
+ */
+func (db *DB) prepareUpdate(notebookName string, noteId uint64, content string, force bool) (preparedUpdate, error) {
+	update := preparedUpdate{notebookName: notebookName}
+	encoding := db.encoding()
+	content, err := encoding.normalize(content)
+	if err != nil {
+		return update, err
+	}
+	now := time.Now()
+	err = db.View(func(tx *bolt.Tx) error {
+		notebookBucket, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		encoding.sealer = db.notebookSealer(tx, db.notebookKey(notebookName))
+		// (the note getting locked in between changes its record, making the update stale)
+		if err := checkWritable(notebookName, note, force); err != nil {
+			return err
+		}
+		update.note = note
+		update.current = append([]byte(nil), notebookBucket.Get([]byte(strconv.FormatUint(noteId, 10)))...)
+		historyBucket := noteHistoryBucket(tx, db.notebookKey(notebookName), noteId)
+		if historyBucket != nil {
+			update.historySeq = historyBucket.Sequence()
+		}
+		revision := NoteRevision{Revision: update.historySeq + 1, Content: note.Content, SavedAt: now}
+		update.revision, err = prepareRevision(historyBucket, revision, encoding.sealer, db.historySnapshotInterval)
+		return err
+	})
+	if err != nil {
+		return update, err
+	}
+
+	update.note.Content = content
+	update.note.UpdatedAt = now
+	db.bumpRevision(&update.note)
+	update.note = encoding.titles.onUpdate(update.note)
+	update.prepared, err = encodeNote(update.note, encoding)
+	return update, err
+}
+
+/**
+ * Stores a prepared update within given write transaction
+ * Fails with errStalePrepare if the note (or its history) changed since it was prepared
+ */
+func (db *DB) commitUpdate(tx *bolt.Tx, update preparedUpdate) error {
+	if err := db.checkNotArchived(tx, update.notebookName); err != nil {
+		return err
+	}
+	notebookBucket, _, err := db.getNoteInTx(tx, update.notebookName, update.note.Id)
+	if err != nil {
+		return err
+	}
+	noteIdBytes := []byte(strconv.FormatUint(update.note.Id, 10))
+	if !bytes.Equal(notebookBucket.Get(noteIdBytes), update.current) {
+		return errStalePrepare
+	}
+	historyBucket, err := createNoteHistoryBucket(tx, db.notebookKey(update.notebookName), update.note.Id)
+	if err != nil {
+		return err
+	}
+	if historyBucket.Sequence() != update.historySeq {
+		return errStalePrepare
+	}
+	if err := db.runBeforeSave(update.notebookName, update.note); err != nil {
+		return err
+	}
+	if err := update.revision.commit(historyBucket); err != nil {
+		return err
+	}
+	if err := recordActivity(tx, db.notebookKey(update.notebookName), dayActivity{Updated: 1}); err != nil {
+		return err
+	}
+	if err := db.recordChange(tx, ChangeUpdated, db.notebookKey(update.notebookName), update.note.Id, update.note.Revision); err != nil {
+		return err
+	}
+	db.invalidateNote(tx, db.notebookKey(update.notebookName), update.note.Id)
+	return putEncodedNote(tx, db.notebookKey(update.notebookName), update.note.Id, update.prepared)
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writers (each with a notebook of its own) and notes per write of the benchmarks below
+const (
+	benchmarkWriters    = 8
+	benchmarkBatchNotes = 20
+)
+
+/**
+ * Runs b.N writes of benchmarkBatchNotes notes by benchmarkWriters goroutines, each to a notebook of its own,
+ * reporting percentiles of how long the write transactions were held before committing (from their function
+ * starting, the writer lock being taken, to it returning; commits themselves take alike either way)
+ * inTx marshals notes within the transaction, as writes did before being prepared ahead of it
+ */
+func benchmarkConcurrentWriteHold(b *testing.B, inTx bool) {
+	db := newTestDB(b)
+	content := strings.Repeat("lorem ipsum dolor sit amet, ", 40)
+	var next int64
+	holds := make([][]time.Duration, benchmarkWriters)
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for w := 0; w < benchmarkWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			notebookName := "notebook-" + strconv.Itoa(w)
+			for atomic.AddInt64(&next, 1) <= int64(b.N) {
+				notes := make([]Note, benchmarkBatchNotes)
+				for i := range notes {
+					notes[i] = Note{Content: content + strconv.Itoa(i)}
+				}
+				var batch preparedAdd
+				if !inTx {
+					var err error
+					if batch, err = db.prepareAdd(notebookName, notes); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+				var hold time.Duration
+				err := db.Update(func(tx *bolt.Tx) error {
+					defer func(start time.Time) { hold = time.Since(start) }(time.Now())
+					if inTx {
+						notebookKey := db.notebookKey(notebookName)
+						batch = preparedAdd{notebookName: notebookName, notes: notes,
+							defaults: getNotebookMeta(tx, notebookKey).Defaults}
+						var err error
+						if batch.prepared, err = prepareNotes(notes, batch.defaults, db.encodingFor(tx, notebookKey)); err != nil {
+							return err
+						}
+					}
+					_, err := db.commitAdd(tx, batch)
+					return err
+				})
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				holds[w] = append(holds[w], hold)
+			}
+		}(w)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	var all []time.Duration
+	for _, writerHolds := range holds {
+		all = append(all, writerHolds...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	if len(all) > 0 {
+		b.ReportMetric(float64(all[len(all)/2].Microseconds()), "p50-hold-µs")
+		b.ReportMetric(float64(all[len(all)*99/100].Microseconds()), "p99-hold-µs")
+	}
+}
+
+func BenchmarkConcurrentWritesPrepared(b *testing.B) {
+	benchmarkConcurrentWriteHold(b, false)
+}
+
+func BenchmarkConcurrentWritesMarshalledInTx(b *testing.B) {
+	benchmarkConcurrentWriteHold(b, true)
+}
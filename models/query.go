@@ -0,0 +1,363 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Order of notes returned by a Query
+ */
+type SortOrder int
+
+const (
+	IdAsc SortOrder = iota
+	IdDesc
+	CreatedAtAsc
+	CreatedAtDesc
+	UpdatedAtAsc
+	UpdatedAtDesc
+)
+
+func (o SortOrder) String() string {
+	switch o {
+	case IdAsc:
+		return "id"
+	case IdDesc:
+		return "-id"
+	case CreatedAtAsc:
+		return "created_at"
+	case CreatedAtDesc:
+		return "-created_at"
+	case UpdatedAtAsc:
+		return "updated_at"
+	case UpdatedAtDesc:
+		return "-updated_at"
+	}
+	return fmt.Sprintf("SortOrder(%d)", int(o))
+}
+
+/**
+ * Opaque position within the results of a Query, to continue from (see Query.After)
+ * The empty Cursor is returned when there are no more results
+ */
+type Cursor string
+
+/**
+ * Returned by Execute for queries that can't be run as built (like an After cursor of a query
+ * having another sort order or other predicates)
+ */
+var ErrInvalidQuery = errors.New("invalid query")
+
+/**
+ * Composable query over the notes of a notebook; build it with the chained methods
+ * and run it with Execute, like
+ *   db.Query("work").WithTag("invoice").CreatedBetween(a, b).SortBy(UpdatedAtDesc).Limit(20).Execute()
+ *  - all predicates must hold for a note to match; expired notes are left out unless WithExpired
+ *  - a note's update time is its creation time until it's first updated
+ *  - the access path is picked by Execute; notes have no secondary indexes yet, so every
+ *    query is a scan of the notebook with predicates applied while cursoring
+ *  - predicates are those of NoteFilter, so the same can be passed as ListOptions (see Filter)
+ */
+type Query struct {
+	db           *DB
+	notebookName string
+	filter       NoteFilter
+	sortOrder    SortOrder
+	limit        int
+	after        Cursor
+	// why the query can't run, for queries of a workspace notebook no available file holds (see workspace.go)
+	err error
+}
+
+/**
+ * Position encoded in a Cursor: sort order and predicates (see filterFingerprint) of the query
+ * it belongs to, and sort key of the last note returned
+ */
+type cursorPosition struct {
+	Sort   SortOrder `json:"s"`
+	Filter string    `json:"f,omitempty"`
+	Time   time.Time `json:"t,omitempty"`
+	Id     uint64    `json:"i"`
+}
+
+/**
+ * Starts a query over notes of given notebook
+ * param: string notebookName
+ * return: *Query
+ */
+func (db *DB) Query(notebookName string) *Query {
+	return &Query{db: db, notebookName: notebookName}
+}
+
+/**
+ * Only notes having given tag (may be called repeatedly: notes must have all the tags)
+ */
+func (q *Query) WithTag(tag string) *Query {
+	WithTag(tag)(&q.filter)
+	return q
+}
+
+/**
+ * Only notes whose content contains given text (case-insensitively)
+ */
+func (q *Query) TextContains(text string) *Query {
+	TextContains(text)(&q.filter)
+	return q
+}
+
+/**
+ * Only notes written in given language (an ISO 639-1 code, or UndeterminedLanguage)
+ * Notes saved before languages were detected are detected on the fly
+ */
+func (q *Query) Language(language string) *Query {
+	Language(language)(&q.filter)
+	return q
+}
+
+/**
+ * Only notes of given kind (KindText, KindMarkdown or KindJSON)
+ */
+func (q *Query) Kind(kind string) *Query {
+	OfKind(kind)(&q.filter)
+	return q
+}
+
+/**
+ * Only notes created within [from, to); a zero bound is open
+ */
+func (q *Query) CreatedBetween(from, to time.Time) *Query {
+	CreatedBetween(from, to)(&q.filter)
+	return q
+}
+
+/**
+ * Only notes last updated within [from, to); a zero bound is open
+ */
+func (q *Query) UpdatedBetween(from, to time.Time) *Query {
+	UpdatedBetween(from, to)(&q.filter)
+	return q
+}
+
+/**
+ * Includes notes that have expired but haven't been purged yet
+ */
+func (q *Query) WithExpired() *Query {
+	WithExpired()(&q.filter)
+	return q
+}
+
+/**
+ * Applies options of ListNotes (like WithTag) as predicates of the query
+ */
+func (q *Query) Filter(opts ...ListOption) *Query {
+	for _, opt := range opts {
+		opt(&q.filter)
+	}
+	return q
+}
+
+/**
+ * Sets the order of results (IdAsc by default)
+ */
+func (q *Query) SortBy(order SortOrder) *Query {
+	q.sortOrder = order
+	return q
+}
+
+/**
+ * Returns at most n notes (no limit if n <= 0)
+ */
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+/**
+ * Continues after the position of a cursor returned by a previous Execute of the same query
+ */
+func (q *Query) After(cursor Cursor) *Query {
+	q.after = cursor
+	return q
+}
+
+/**
+ * Runs the query
+ * return: ([]Note, Cursor, error) Cursor to pass to After for the next page ("" if there are no more notes)
+ */
+func (q *Query) Execute() ([]Note, Cursor, error) {
+	return q.execute(q.scan)
+}
+
+/**
+ * Same as Execute, but answering summaries of the notes (see ListNoteSummaries)
+ * param: int previewLength Characters of content previewed (DefaultPreviewLength if not positive)
+ * return: ([]NoteSummary, Cursor, error)
+ */
+func (q *Query) ExecuteSummaries(previewLength int) ([]NoteSummary, Cursor, error) {
+	if previewLength <= 0 {
+		previewLength = DefaultPreviewLength
+	}
+	notes, next, err := q.execute(func(tx *bolt.Tx) ([]Note, error) {
+		var matches []Note
+		err := q.db.forEachMatchingHead(tx, q.db.notebookKey(q.notebookName), q.filter, previewLength, func(note Note) error {
+			matches = append(matches, note)
+			return nil
+		})
+		return matches, err
+	})
+	return summarize(notes, previewLength), next, err
+}
+
+/**
+ * Core logic of Execute, reading the matching notes with given scan
+ */
+func (q *Query) execute(scan func(tx *bolt.Tx) ([]Note, error)) ([]Note, Cursor, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+	if q.sortOrder < IdAsc || q.sortOrder > UpdatedAtDesc {
+		return nil, "", fmt.Errorf("%w: unknown sort order %v", ErrInvalidQuery, q.sortOrder)
+	}
+	var after *cursorPosition
+	if q.after != "" {
+		position, err := decodeCursor(q.after)
+		if err != nil {
+			return nil, "", err
+		}
+		if position.Sort != q.sortOrder {
+			return nil, "", fmt.Errorf("%w: cursor belongs to a query sorted by %v, this one sorts by %v",
+				ErrInvalidQuery, position.Sort, q.sortOrder)
+		}
+		if position.Filter != filterFingerprint(q.filter) {
+			return nil, "", fmt.Errorf("%w: cursor belongs to a query with other predicates", ErrInvalidQuery)
+		}
+		after = &position
+	}
+
+	var matches []Note
+	err := q.db.View(func(tx *bolt.Tx) error {
+		var err error
+		matches, err = scan(tx)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return q.less(q.position(matches[i]), q.position(matches[j]))
+	})
+	if after != nil {
+		start := sort.Search(len(matches), func(i int) bool {
+			return q.less(*after, q.position(matches[i]))
+		})
+		matches = matches[start:]
+	}
+
+	var next Cursor
+	if q.limit > 0 && len(matches) > q.limit {
+		matches = matches[:q.limit]
+		last := q.position(matches[len(matches)-1])
+		last.Filter = filterFingerprint(q.filter)
+		next = encodeCursor(last)
+	}
+	return matches, next, nil
+}
+
+/**
+ * Full scan access path: reads every note of the notebook, keeping the matching ones
+ */
+func (q *Query) scan(tx *bolt.Tx) ([]Note, error) {
+	var matches []Note
+	err := q.db.forEachMatchingNote(tx, q.db.notebookKey(q.notebookName), q.filter, func(note Note) error {
+		matches = append(matches, note)
+		return nil
+	})
+	return matches, err
+}
+
+/**
+ * Sort key of a note under the query's sort order
+ */
+func (q *Query) position(note Note) cursorPosition {
+	position := cursorPosition{Sort: q.sortOrder, Id: note.Id}
+	switch q.sortOrder {
+	case CreatedAtAsc, CreatedAtDesc:
+		position.Time = note.CreatedAt
+	case UpdatedAtAsc, UpdatedAtDesc:
+		position.Time = updatedAt(note)
+	}
+	return position
+}
+
+/**
+ * Whether position a comes before position b under the query's sort order (ties broken by id)
+ */
+func (q *Query) less(a, b cursorPosition) bool {
+	descending := q.sortOrder == IdDesc || q.sortOrder == CreatedAtDesc || q.sortOrder == UpdatedAtDesc
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time) != descending
+	}
+	if a.Id != b.Id {
+		return (a.Id < b.Id) != descending
+	}
+	return false
+}
+
+/**
+ * Time a note was last updated (its creation time if it never was)
+ */
+func updatedAt(note Note) time.Time {
+	if note.UpdatedAt.IsZero() {
+		return note.CreatedAt
+	}
+	return note.UpdatedAt
+}
+
+/**
+ * Whether t lies within [from, to), zero bounds being open
+ */
+func within(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+/**
+ * Short digest of the predicates of a query, telling cursors of queries with other predicates apart
+ * (relative bounds counting as given, not as the times they resolved to)
+ */
+func filterFingerprint(filter NoteFilter) string {
+	if filter.CreatedSince != "" {
+		filter.CreatedFrom = time.Time{}
+	}
+	if filter.UpdatedSince != "" {
+		filter.UpdatedFrom = time.Time{}
+	}
+	encoded, _ := json.Marshal(filter)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func encodeCursor(position cursorPosition) Cursor {
+	encoded, _ := json.Marshal(position)
+	return Cursor(base64.RawURLEncoding.EncodeToString(encoded))
+}
+
+func decodeCursor(cursor Cursor) (cursorPosition, error) {
+	var position cursorPosition
+	encoded, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err == nil {
+		err = json.Unmarshal(encoded, &position)
+	}
+	if err != nil {
+		return position, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return position, nil
+}
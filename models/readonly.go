@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes can be locked read-only (see LockNoteReadOnly), guarding them against accidental changes
+ *  - updating (including restoring revisions and toggling tasks), deleting, changing attachments
+ *    or expiry of a locked note fails with ErrNoteReadOnly, unless Force() is passed where offered
+ *  - deleting several notes at once fails as a whole if any of them is locked, unless
+ *    SkipLocked() is passed, in which case locked notes are left alone and reported
+ *  - the lock is part of the note: it's exported (and imported) along with it
+ */
+
+/**
+ * Returned when trying to change a note that is locked read-only
+ */
+var ErrNoteReadOnly = errors.New("note is read-only")
+
+/**
+ * Returned when notes to be changed together include read-only ones (matches ErrNoteReadOnly)
+ */
+type ReadOnlyNotesError struct {
+	Notebook string
+	Ids      []uint64
+}
+
+func (e *ReadOnlyNotesError) Error() string {
+	ids := make([]string, len(e.Ids))
+	for i, id := range e.Ids {
+		ids[i] = strconv.FormatUint(id, 10)
+	}
+	return fmt.Sprintf("%v: %s in notebook '%s'", ErrNoteReadOnly, strings.Join(ids, ", "), e.Notebook)
+}
+
+func (e *ReadOnlyNotesError) Unwrap() error {
+	return ErrNoteReadOnly
+}
+
+/**
+ * Option of writes to notes (UpdateNote, UpdateNoteIfRevision, DeleteNotesWithOptions)
+ */
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	force      bool
+	skipLocked bool
+	// deletes were planned, and can be of any size (see delete_plan.go)
+	confirmed bool
+}
+
+/**
+ * Changes notes even if they are locked read-only
+ */
+func Force() WriteOption {
+	return func(opts *writeOptions) {
+		opts.force = true
+	}
+}
+
+/**
+ * Leaves notes that are locked read-only alone (reporting them), instead of failing
+ */
+func SkipLocked() WriteOption {
+	return func(opts *writeOptions) {
+		opts.skipLocked = true
+	}
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	var options writeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+/**
+ * Locks a note read-only, so that it can't be changed or deleted (without Force())
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: error
+ */
+func (db *DB) LockNoteReadOnly(notebookName string, noteId uint64) error {
+	return db.setReadOnly(notebookName, noteId, true)
+}
+
+/**
+ * Unlocks a note locked by LockNoteReadOnly
+ * param: string notebookName
+ * param: uint64 noteId
+ * return: error
+ */
+func (db *DB) UnlockNoteReadOnly(notebookName string, noteId uint64) error {
+	return db.setReadOnly(notebookName, noteId, false)
+}
+
+func (db *DB) setReadOnly(notebookName string, noteId uint64, readOnly bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil || note.ReadOnly == readOnly {
+			return err
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		note.ReadOnly = readOnly
+		db.bumpRevision(&note)
+		return db.putNote(tx, db.notebookKey(notebookName), note)
+	})
+}
+
+/**
+ * Fails with ErrNoteReadOnly if a note is locked read-only (and the change isn't forced)
+ */
+func checkWritable(notebookName string, note Note, force bool) error {
+	if note.ReadOnly && !force {
+		return fmt.Errorf("%w: %d in notebook '%s'", ErrNoteReadOnly, note.Id, notebookName)
+	}
+	return nil
+}
+
+/**
+ * Ids not among given ones to leave out (keeping their order)
+ */
+func withoutIds(ids []uint64, leftOut []uint64) []uint64 {
+	var kept []uint64
+	for _, id := range ids {
+		skip := false
+		for _, other := range leftOut {
+			if id == other {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,559 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Recurring rules create notes on a schedule (like a weekly review every Monday), see RunRecurring
+ *  - a rule creates either a note of its own (title, content and tags) or the notes of a notebook template
+ *    (see templates.go) in its notebook, created if need be
+ *  - schedules are daily, weekly on a weekday, or monthly on a day of the month (the last day of months
+ *    shorter than that); a rule is due once per period, from midnight of the day it falls on, in the
+ *    rule's time zone (which it must name: there's no default)
+ *  - a rule fires once per period: the dedupe key of the period (see RecurringRule.DedupeKey) is recorded
+ *    along with the notes created, and periods whose key was recorded are never fired again
+ *  - the first period of a rule is the one it was added in; periods missed since the last run (like days
+ *    the DB wasn't opened) are skipped or fired in turn, as per the rule's catch-up policy
+ *  - RunRecurring is meant to be called regularly (like by cron), and is called by the janitor if
+ *    scheduled (see ScheduleRecurring)
+ *  - titles, contents and tags (and dedupe keys) can hold variables like '{{date}}' (see recurringVariables)
+ * 'Recurring' bucket: rule name -> bucket of the rule
+ *   - "rule" -> JSON RecurringRule
+ *   - "fired" bucket: dedupe key -> JSON RecurringFiring
+ */
+
+/**
+ * How often a rule is due
+ */
+type RecurringFrequency string
+
+const (
+	RecurringDaily   RecurringFrequency = "daily"
+	RecurringWeekly  RecurringFrequency = "weekly"
+	RecurringMonthly RecurringFrequency = "monthly"
+)
+
+/**
+ * What becomes of periods missed since the last run
+ */
+type RecurringCatchUp string
+
+const (
+	// only the current period is fired, missed ones are skipped (the default)
+	RecurringSkip RecurringCatchUp = "skip"
+	// every missed period is fired, oldest first (up to maxRecurringBackfill of them per run)
+	RecurringBackfill RecurringCatchUp = "backfill"
+)
+
+/**
+ * Dedupe key of rules that don't set theirs: one firing per rule and period
+ */
+const DefaultRecurringDedupeKey = "{{rule}}/{{date}}"
+
+/**
+ * Most periods a rule backfills in a run; older ones are skipped
+ */
+const maxRecurringBackfill = 366
+
+var (
+	// returned by AddRecurringNote for invalid rules
+	ErrInvalidRecurringRule = errors.New("invalid recurring rule")
+	// returned when there's no recurring rule by given name
+	ErrRecurringRuleNotFound = errors.New("recurring rule not found")
+)
+
+/**
+ * When a rule is due
+ *  - Weekday (like "monday") is that of weekly rules, and DayOfMonth (1 to 31) that of monthly ones
+ */
+type RecurringSchedule struct {
+	Frequency  RecurringFrequency `json:"frequency"`
+	Weekday    string             `json:"weekday,omitempty"`
+	DayOfMonth int                `json:"day_of_month,omitempty"`
+}
+
+/**
+ * A recurring rule (see above)
+ *  - Template names a notebook template whose notes are created; Title, Content and Tags make the note
+ *    created otherwise
+ *  - TimeZone is an IANA name (like "Europe/Berlin" or "UTC")
+ *  - DedupeKey is DefaultRecurringDedupeKey if empty; a key not changing every period (like
+ *    "{{rule}}/{{year}}-{{month}}" for a weekly rule) fires once per value it takes
+ *  - CatchUp is RecurringSkip if empty
+ *  - CreatedAt is set by AddRecurringNote to the time the rule is added, unless given (like for a rule
+ *    meant to start later, or backfill from earlier); LastPeriod is set by RunRecurring (the start of
+ *    the last period fired or skipped)
+ */
+type RecurringRule struct {
+	Name       string            `json:"name"`
+	Notebook   string            `json:"notebook"`
+	Template   string            `json:"template,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	Content    string            `json:"content,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Schedule   RecurringSchedule `json:"schedule"`
+	TimeZone   string            `json:"time_zone"`
+	DedupeKey  string            `json:"dedupe_key,omitempty"`
+	CatchUp    RecurringCatchUp  `json:"catch_up,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	LastPeriod time.Time         `json:"last_period,omitempty"`
+}
+
+/**
+ * A period a rule fired for: its start, dedupe key and the notes created
+ */
+type RecurringFiring struct {
+	Rule    string    `json:"rule"`
+	Key     string    `json:"key"`
+	Period  time.Time `json:"period"`
+	Notes   []NoteRef `json:"notes"`
+	FiredAt time.Time `json:"fired_at"`
+}
+
+/**
+ * A rule that couldn't fire (like for its notebook being archived); it's tried again on the next run
+ */
+type RecurringFailure struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+/**
+ * Outcome of RunRecurring
+ *  - Skipped counts periods missed and skipped, Duplicates periods whose dedupe key had fired already
+ */
+type RecurringReport struct {
+	Fired      []RecurringFiring  `json:"fired"`
+	Skipped    int                `json:"skipped"`
+	Duplicates int                `json:"duplicates"`
+	Failed     []RecurringFailure `json:"failed,omitempty"`
+}
+
+/**
+ * Adds a recurring rule, replacing the rule of the same name (if any) but keeping the periods it fired for
+ * Fails with ErrInvalidRecurringRule for invalid rules, and with ErrTemplateNotFound for templates that
+ * don't exist
+ * param: RecurringRule rule
+ * return: error
+ */
+func (db *DB) AddRecurringNote(rule RecurringRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if rule.Template != "" {
+			if _, err := getNotebookTemplate(tx, rule.Template); err != nil {
+				return err
+			}
+		}
+		root, err := tx.CreateBucketIfNotExists([]byte("Recurring"))
+		if err != nil {
+			return err
+		}
+		bucket, err := root.CreateBucketIfNotExists([]byte(rule.Name))
+		if err != nil {
+			return err
+		}
+		if rule.CreatedAt.IsZero() {
+			rule.CreatedAt = time.Now()
+		}
+		rule.LastPeriod = time.Time{}
+		if existing, err := getRecurringRule(bucket); err == nil {
+			rule.CreatedAt, rule.LastPeriod = existing.CreatedAt, existing.LastPeriod
+		}
+		return putRecurringRule(bucket, rule)
+	})
+}
+
+/**
+ * Retrieves all recurring rules, by name
+ * return: ([]RecurringRule, error)
+ */
+func (db *DB) ListRecurringNotes() ([]RecurringRule, error) {
+	rules := []RecurringRule{}
+	err := db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte("Recurring"))
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(k, _ []byte) error {
+			rule, err := getRecurringRule(root.Bucket(k))
+			rules = append(rules, rule)
+			return err
+		})
+	})
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules, err
+}
+
+/**
+ * Deletes a recurring rule, along with its record of periods fired (notes it created are left as they are)
+ * Fails with ErrRecurringRuleNotFound if there's no rule by given name
+ * param: string name
+ * return: error
+ */
+func (db *DB) DeleteRecurringNote(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte("Recurring"))
+		if root == nil || root.Bucket([]byte(name)) == nil {
+			return fmt.Errorf("%w: '%s'", ErrRecurringRuleNotFound, name)
+		}
+		return root.DeleteBucket([]byte(name))
+	})
+}
+
+/**
+ * Fires recurring rules due at given time (see above); running again for the same time does nothing
+ *  - every rule is fired in a write transaction of its own: a rule that fails is reported, its periods
+ *    being fired on a later run, without holding up the others
+ * param: time.Time now
+ * return: (RecurringReport, error)
+ */
+func (db *DB) RunRecurring(now time.Time) (RecurringReport, error) {
+	report := RecurringReport{Fired: []RecurringFiring{}}
+	var names []string
+	err := db.View(func(tx *bolt.Tx) error {
+		if root := tx.Bucket([]byte("Recurring")); root != nil {
+			return root.ForEach(func(k, _ []byte) error {
+				names = append(names, string(k))
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, name := range names {
+		var fired RecurringReport
+		err := db.Update(func(tx *bolt.Tx) error {
+			fired = RecurringReport{}
+			return db.runRecurringRule(tx, name, now, &fired)
+		})
+		if err != nil && !recurringFailure(err) {
+			return report, err
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, RecurringFailure{Rule: name, Reason: err.Error()})
+			continue
+		}
+		report.Fired = append(report.Fired, fired.Fired...)
+		report.Skipped += fired.Skipped
+		report.Duplicates += fired.Duplicates
+	}
+	return report, nil
+}
+
+/**
+ * Makes the janitor run recurring rules on its every run (or stop doing so); the setting is persisted in the DB
+ * param: bool scheduled
+ * return: error
+ */
+func (db *DB) ScheduleRecurring(scheduled bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.ScheduledRecurring = scheduled
+		return putSettings(tx, settings)
+	})
+}
+
+/**
+ * Retrieves whether the janitor runs recurring rules (see ScheduleRecurring)
+ * return: (bool, error)
+ */
+func (db *DB) RecurringScheduled() (bool, error) {
+	var scheduled bool
+	err := db.View(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		scheduled = settings.ScheduledRecurring
+		return err
+	})
+	return scheduled, err
+}
+
+/**
+ * Fires the periods of a rule due at given time, within given write transaction
+ */
+func (db *DB) runRecurringRule(tx *bolt.Tx, name string, now time.Time, report *RecurringReport) error {
+	bucket := tx.Bucket([]byte("Recurring")).Bucket([]byte(name))
+	if bucket == nil {
+		// (deleted since it was listed)
+		return nil
+	}
+	rule, err := getRecurringRule(bucket)
+	if err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(rule.TimeZone)
+	if err != nil {
+		return fmt.Errorf("%w: time zone '%s' of '%s': %v", ErrInvalidRecurringRule, rule.TimeZone, rule.Name, err)
+	}
+	current := rule.Schedule.periodOf(now.In(location))
+	next := rule.Schedule.periodOf(rule.CreatedAt.In(location))
+	if !rule.LastPeriod.IsZero() {
+		next = rule.Schedule.after(rule.LastPeriod.In(location))
+	}
+	var periods []time.Time
+	for period := next; !period.After(current); period = rule.Schedule.after(period) {
+		periods = append(periods, period)
+	}
+	if len(periods) == 0 {
+		return nil
+	}
+	keep := maxRecurringBackfill
+	if rule.CatchUp != RecurringBackfill {
+		keep = 1
+	}
+	if len(periods) > keep {
+		report.Skipped += len(periods) - keep
+		periods = periods[len(periods)-keep:]
+	}
+
+	fired, err := bucket.CreateBucketIfNotExists([]byte("fired"))
+	if err != nil {
+		return err
+	}
+	for _, period := range periods {
+		vars := recurringVariables(rule, period)
+		key, err := expandRecurring(rule.dedupeKey(), vars)
+		if err != nil {
+			return err
+		}
+		if fired.Get([]byte(key)) != nil {
+			report.Duplicates++
+			continue
+		}
+		notes, err := recurringNotes(tx, rule, vars)
+		if err != nil {
+			return err
+		}
+		batch := preparedAdd{notebookName: rule.Notebook, notes: notes, defaults: getNotebookMeta(tx, db.notebookKey(rule.Notebook)).Defaults}
+		if batch.prepared, err = prepareNotes(notes, batch.defaults, db.encodingFor(tx, db.notebookKey(rule.Notebook))); err != nil {
+			return err
+		}
+		added, err := db.commitAdd(tx, batch)
+		if err != nil {
+			return err
+		}
+		firing := RecurringFiring{Rule: rule.Name, Key: key, Period: period, FiredAt: time.Now()}
+		for _, note := range added {
+			firing.Notes = append(firing.Notes, NoteRef{Notebook: rule.Notebook, Id: note.Id})
+		}
+		encoded, err := json.Marshal(firing)
+		if err != nil {
+			return err
+		}
+		if err := fired.Put([]byte(key), encoded); err != nil {
+			return err
+		}
+		report.Fired = append(report.Fired, firing)
+	}
+	rule.LastPeriod = current
+	return putRecurringRule(bucket, rule)
+}
+
+/**
+ * Notes a rule creates for a period
+ */
+func recurringNotes(tx *bolt.Tx, rule RecurringRule, vars map[string]string) ([]Note, error) {
+	t := rule.noteTemplate()
+	if rule.Template != "" {
+		var err error
+		if t, err = getNotebookTemplate(tx, rule.Template); err != nil {
+			return nil, err
+		}
+	}
+	return expandNotebookTemplate(t, rule.Notebook, vars)
+}
+
+/**
+ * The note of a rule not using a template, as a template of one note
+ */
+func (rule RecurringRule) noteTemplate() NotebookTemplate {
+	return NotebookTemplate{Name: rule.Name, Notes: []NoteSpec{{Title: rule.Title, Content: rule.Content, Tags: rule.Tags}}}
+}
+
+/**
+ * Whether an error firing a rule is the rule's own (reported, other rules being fired), rather than the DB's
+ */
+func recurringFailure(err error) bool {
+	for _, expected := range []error{ErrInvalidRecurringRule, ErrTemplateNotFound, ErrInvalidTemplate, ErrNotebookArchived,
+		ErrNotebookLocked, ErrEncryptionLocked, ErrReservedNotebookName, ErrContentMismatch} {
+		if errors.Is(err, expected) {
+			return true
+		}
+	}
+	var titleTaken *TitleTakenError
+	return errors.As(err, &titleTaken)
+}
+
+func (rule RecurringRule) validate() error {
+	switch {
+	case rule.Name == "":
+		return fmt.Errorf("%w: no name", ErrInvalidRecurringRule)
+	case rule.Notebook == "":
+		return fmt.Errorf("%w: '%s' has no notebook", ErrInvalidRecurringRule, rule.Name)
+	case rule.Template != "" && (rule.Title != "" || rule.Content != "" || len(rule.Tags) > 0):
+		return fmt.Errorf("%w: '%s' has both a template and a note of its own", ErrInvalidRecurringRule, rule.Name)
+	case rule.Template == "" && rule.Title == "" && rule.Content == "":
+		return fmt.Errorf("%w: '%s' has neither a template nor a note of its own", ErrInvalidRecurringRule, rule.Name)
+	case rule.TimeZone == "" || rule.TimeZone == "Local":
+		return fmt.Errorf("%w: '%s' must name its time zone (like 'Europe/Berlin' or 'UTC')", ErrInvalidRecurringRule, rule.Name)
+	}
+	if _, err := time.LoadLocation(rule.TimeZone); err != nil {
+		return fmt.Errorf("%w: time zone '%s' of '%s': %v", ErrInvalidRecurringRule, rule.TimeZone, rule.Name, err)
+	}
+	switch rule.Schedule.Frequency {
+	case RecurringDaily:
+	case RecurringWeekly:
+		if _, ok := parseWeekday(rule.Schedule.Weekday); !ok {
+			return fmt.Errorf("%w: '%s' is weekly on unknown weekday '%s'", ErrInvalidRecurringRule, rule.Name, rule.Schedule.Weekday)
+		}
+	case RecurringMonthly:
+		if rule.Schedule.DayOfMonth < 1 || rule.Schedule.DayOfMonth > 31 {
+			return fmt.Errorf("%w: '%s' is monthly on day %d (1 to 31)", ErrInvalidRecurringRule, rule.Name, rule.Schedule.DayOfMonth)
+		}
+	default:
+		return fmt.Errorf("%w: '%s' has unknown frequency '%s' (daily, weekly or monthly)", ErrInvalidRecurringRule, rule.Name, rule.Schedule.Frequency)
+	}
+	switch rule.CatchUp {
+	case "", RecurringSkip, RecurringBackfill:
+	default:
+		return fmt.Errorf("%w: '%s' has unknown catch-up policy '%s' (skip or backfill)", ErrInvalidRecurringRule, rule.Name, rule.CatchUp)
+	}
+	// (variables are checked by expanding them for any period)
+	vars := recurringVariables(rule, time.Now())
+	if _, err := expandRecurring(rule.dedupeKey(), vars); err != nil {
+		return err
+	}
+	if rule.Template == "" {
+		if _, err := expandNotebookTemplate(rule.noteTemplate(), rule.Notebook, vars); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecurringRule, err)
+		}
+	}
+	return nil
+}
+
+func (rule RecurringRule) dedupeKey() string {
+	if rule.DedupeKey == "" {
+		return DefaultRecurringDedupeKey
+	}
+	return rule.DedupeKey
+}
+
+/**
+ * Start of the period given time is in: midnight of the latest day the schedule falls on, at or before it
+ */
+func (s RecurringSchedule) periodOf(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch s.Frequency {
+	case RecurringWeekly:
+		weekday, _ := parseWeekday(s.Weekday)
+		return day.AddDate(0, 0, -((int(day.Weekday()) - int(weekday) + 7) % 7))
+	case RecurringMonthly:
+		if start := monthlyDay(t.Year(), t.Month(), s.DayOfMonth, t.Location()); !start.After(day) {
+			return start
+		}
+		return monthlyDay(t.Year(), t.Month()-1, s.DayOfMonth, t.Location())
+	}
+	return day
+}
+
+/**
+ * Start of the period after the one starting at given time
+ */
+func (s RecurringSchedule) after(period time.Time) time.Time {
+	switch s.Frequency {
+	case RecurringWeekly:
+		return period.AddDate(0, 0, 7)
+	case RecurringMonthly:
+		return monthlyDay(period.Year(), period.Month()+1, s.DayOfMonth, period.Location())
+	}
+	return period.AddDate(0, 0, 1)
+}
+
+/**
+ * Midnight of given day of a month, or of its last day if it's shorter
+ */
+func monthlyDay(year int, month time.Month, day int, location *time.Location) time.Time {
+	// (day 0 of the next month is the last day of this one)
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, location).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, location)
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if full := strings.ToLower(day.String()); strings.ToLower(name) == full || strings.ToLower(name) == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+/**
+ * Variables of a rule's notes and dedupe key for the period starting at given time
+ *  - rule and notebook: names of the rule and of its notebook (along with the variables of its template)
+ *  - date ('2006-01-02'), year, month ('01'), day ('02') and weekday ('Monday') of the period's start
+ *  - week: ISO week of the period's start (like '2024-W03')
+ */
+func recurringVariables(rule RecurringRule, period time.Time) map[string]string {
+	year, week := period.ISOWeek()
+	return map[string]string{
+		"rule":     rule.Name,
+		"notebook": rule.Notebook,
+		"date":     period.Format("2006-01-02"),
+		"year":     period.Format("2006"),
+		"month":    period.Format("01"),
+		"day":      period.Format("02"),
+		"weekday":  period.Weekday().String(),
+		"week":     fmt.Sprintf("%d-W%02d", year, week),
+	}
+}
+
+/**
+ * Text with its variables replaced by their values; fails with ErrInvalidRecurringRule for unknown ones
+ */
+func expandRecurring(text string, vars map[string]string) (string, error) {
+	var unknown error
+	expanded := templateVariable.ReplaceAllStringFunc(text, func(variable string) string {
+		name := templateVariable.FindStringSubmatch(variable)[1]
+		value, ok := vars[name]
+		if !ok && unknown == nil {
+			unknown = fmt.Errorf("%w: unknown variable '%s' in '%s'", ErrInvalidRecurringRule, name, text)
+		}
+		return value
+	})
+	return expanded, unknown
+}
+
+func getRecurringRule(bucket *bolt.Bucket) (RecurringRule, error) {
+	var rule RecurringRule
+	encoded := bucket.Get([]byte("rule"))
+	if encoded == nil {
+		return rule, ErrRecurringRuleNotFound
+	}
+	err := json.Unmarshal(encoded, &rule)
+	return rule, err
+}
+
+func putRecurringRule(bucket *bolt.Bucket, rule RecurringRule) error {
+	encoded, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte("rule"), encoded)
+}
@@ -0,0 +1,179 @@
+package models_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata" // time zones of rules, whatever the system has
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Contents of the notes of a notebook, in order
+ */
+func notebookContents(t *testing.T, db *models.DB, notebookName string) []string {
+	t.Helper()
+	notes, err := db.ListNotes(notebookName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []string{}
+	for _, note := range notes {
+		contents = append(contents, note.Content)
+	}
+	return contents
+}
+
+func mustAddRule(t *testing.T, db *models.DB, rule models.RecurringRule) {
+	t.Helper()
+	if err := db.AddRecurringNote(rule); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecurringWeekWithMissedDay(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rules added on Monday 1 January 2024, run every morning of the week but Wednesday's, twice on Friday
+	monday := time.Date(2024, time.January, 1, 6, 0, 0, 0, berlin)
+	db := notestest.NewDB(t)
+	for _, rule := range []models.RecurringRule{
+		{Name: "journal", Notebook: "skipped", Content: "Journal {{weekday}} {{date}}", Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}},
+		{Name: "journal-all", Notebook: "backfilled", Content: "Journal {{weekday}} {{date}}", Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}, CatchUp: models.RecurringBackfill},
+		{Name: "review", Notebook: "reviews", Title: "Weekly review {{week}}", Content: "- wins\n- misses", Tags: []string{"review"},
+			Schedule: models.RecurringSchedule{Frequency: models.RecurringWeekly, Weekday: "monday"}},
+	} {
+		rule.TimeZone, rule.CreatedAt = "Europe/Berlin", monday
+		mustAddRule(t, db, rule)
+	}
+
+	fired := make(map[string][]string)
+	skipped := 0
+	for day := 0; day < 7; day++ {
+		if day == 2 {
+			// Wednesday: the machine was off
+			continue
+		}
+		runs := 1
+		if day == 4 {
+			runs = 2
+		}
+		for run := 0; run < runs; run++ {
+			now := monday.AddDate(0, 0, day).Add(time.Hour + time.Duration(run)*time.Hour)
+			report, err := db.RunRecurring(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if run > 0 && len(report.Fired) != 0 {
+				t.Errorf("running again on %s fired %+v", now.Weekday(), report.Fired)
+			}
+			for _, firing := range report.Fired {
+				fired[firing.Rule] = append(fired[firing.Rule], firing.Key)
+				if !firing.Period.Equal(time.Date(firing.Period.Year(), firing.Period.Month(), firing.Period.Day(), 0, 0, 0, 0, berlin)) {
+					t.Errorf("period %v of %s doesn't start at midnight in Berlin", firing.Period, firing.Key)
+				}
+			}
+			skipped += report.Skipped
+		}
+	}
+
+	// skipping, Wednesday's journal is never written; backfilling, it's written on Thursday before Thursday's
+	if want := []string{"Journal Monday 2024-01-01", "Journal Tuesday 2024-01-02", "Journal Thursday 2024-01-04",
+		"Journal Friday 2024-01-05", "Journal Saturday 2024-01-06", "Journal Sunday 2024-01-07"}; !reflect.DeepEqual(notebookContents(t, db, "skipped"), want) {
+		t.Errorf("journal skipping missed days %q, want %q", notebookContents(t, db, "skipped"), want)
+	}
+	if want := []string{"Journal Monday 2024-01-01", "Journal Tuesday 2024-01-02", "Journal Wednesday 2024-01-03", "Journal Thursday 2024-01-04",
+		"Journal Friday 2024-01-05", "Journal Saturday 2024-01-06", "Journal Sunday 2024-01-07"}; !reflect.DeepEqual(notebookContents(t, db, "backfilled"), want) {
+		t.Errorf("journal backfilling missed days %q, want %q", notebookContents(t, db, "backfilled"), want)
+	}
+	if skipped != 1 {
+		t.Errorf("%d periods skipped, want Wednesday's", skipped)
+	}
+	if want := []string{"journal-all/2024-01-01", "journal-all/2024-01-02", "journal-all/2024-01-03"}; !reflect.DeepEqual(fired["journal-all"][:3], want) {
+		t.Errorf("backfilled journal fired %q", fired["journal-all"])
+	}
+
+	// the weekly review was written once, on Monday, and is due again next Monday
+	reviews, err := db.ListNotes("reviews")
+	if err != nil || len(reviews) != 1 || reviews[0].Title() != "Weekly review 2024-W01" || !reflect.DeepEqual(reviews[0].Tags, []string{"review"}) {
+		t.Errorf("reviews %+v (%v)", reviews, err)
+	}
+	report, err := db.RunRecurring(monday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Fired) != 3 || !reflect.DeepEqual(notebookContents(t, db, "reviews"), []string{"- wins\n- misses", "- wins\n- misses"}) {
+		t.Errorf("next Monday fired %+v", report.Fired)
+	}
+}
+
+func TestRecurringDedupeAndTimeZones(t *testing.T) {
+	db := notestest.NewDB(t)
+	start := time.Date(2024, time.March, 30, 12, 0, 0, 0, time.UTC)
+	// a daily rule whose key only changes every month fires once a month
+	mustAddRule(t, db, models.RecurringRule{Name: "monthly-ish", Notebook: "log", Content: "{{date}}", TimeZone: "UTC", CreatedAt: start,
+		Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}, DedupeKey: "{{rule}}/{{year}}-{{month}}"})
+	duplicates := 0
+	for day := 0; day < 4; day++ {
+		report, err := db.RunRecurring(start.AddDate(0, 0, day))
+		if err != nil {
+			t.Fatal(err)
+		}
+		duplicates += report.Duplicates
+	}
+	if contents := notebookContents(t, db, "log"); !reflect.DeepEqual(contents, []string{"2024-03-30", "2024-04-01"}) || duplicates != 2 {
+		t.Errorf("rule deduped by month wrote %q, with %d duplicates", contents, duplicates)
+	}
+
+	// re-adding a rule keeps the periods it fired for
+	mustAddRule(t, db, models.RecurringRule{Name: "monthly-ish", Notebook: "log", Content: "again {{date}}", TimeZone: "UTC",
+		Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}, DedupeKey: "{{rule}}/{{year}}-{{month}}"})
+	if report, err := db.RunRecurring(start.AddDate(0, 0, 3)); err != nil || len(report.Fired) != 0 {
+		t.Errorf("re-added rule fired %+v (%v)", report.Fired, err)
+	}
+
+	// days start at midnight of the rule's time zone: at 11:30 UTC on 1 April, it's already 2 April in Auckland
+	instant := time.Date(2024, time.April, 1, 11, 30, 0, 0, time.UTC)
+	dates := map[string]string{"UTC": "2024-04-01", "Pacific/Auckland": "2024-04-02", "America/Los_Angeles": "2024-04-01"}
+	for zone := range dates {
+		mustAddRule(t, db, models.RecurringRule{Name: "daily " + zone, Notebook: zone, Content: "{{date}}", TimeZone: zone, CreatedAt: instant,
+			Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}})
+	}
+	if _, err := db.RunRecurring(instant); err != nil {
+		t.Fatal(err)
+	}
+	for zone, want := range dates {
+		if contents := notebookContents(t, db, zone); !reflect.DeepEqual(contents, []string{want}) {
+			t.Errorf("rule in %s wrote %q, want %s", zone, contents, want)
+		}
+	}
+
+	// time zones must be named
+	for _, zone := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		err := db.AddRecurringNote(models.RecurringRule{Name: "vague", Notebook: "log", Content: "x", TimeZone: zone,
+			Schedule: models.RecurringSchedule{Frequency: models.RecurringDaily}})
+		if !errors.Is(err, models.ErrInvalidRecurringRule) {
+			t.Errorf("rule in time zone %q: %v, want ErrInvalidRecurringRule", zone, err)
+		}
+	}
+}
+
+func TestRecurringMonthlyOnShortMonths(t *testing.T) {
+	db := notestest.NewDB(t)
+	start := time.Date(2024, time.January, 31, 10, 0, 0, 0, time.UTC)
+	mustAddRule(t, db, models.RecurringRule{Name: "rent", Notebook: "bills", Content: "Rent {{date}}", TimeZone: "UTC", CreatedAt: start,
+		Schedule: models.RecurringSchedule{Frequency: models.RecurringMonthly, DayOfMonth: 31}, CatchUp: models.RecurringBackfill})
+	// run once, in May: every month end since January is backfilled, February's on the 29th
+	if _, err := db.RunRecurring(time.Date(2024, time.May, 2, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Rent 2024-01-31", "Rent 2024-02-29", "Rent 2024-03-31", "Rent 2024-04-30"}
+	if contents := notebookContents(t, db, "bills"); !reflect.DeepEqual(contents, want) {
+		t.Errorf("monthly rule wrote %q, want %q", contents, want)
+	}
+}
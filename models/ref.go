@@ -0,0 +1,107 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Identifies a note across notebooks
+ */
+type NoteRef struct {
+	Notebook string `json:"notebook"`
+	Id       uint64 `json:"id"`
+}
+
+func (r NoteRef) String() string {
+	return fmt.Sprintf("%s/%d", r.Notebook, r.Id)
+}
+
+/**
+ * A note found by a multi-notebook operation (search, due notes, recent notes ..)
+ *  - Score is the relevance of the result (higher is better, see search.go); 0 when not applicable
+ */
+type SearchResult struct {
+	Ref   NoteRef `json:"ref"`
+	Note  Note    `json:"note"`
+	Score float64 `json:"score"`
+}
+
+/**
+ * Returned by ResolveRefs when some of the refs don't point to an existing note
+ */
+type MissingRefsError struct {
+	Refs []NoteRef
+}
+
+func (e *MissingRefsError) Error() string {
+	var refs []string
+	for _, ref := range e.Refs {
+		refs = append(refs, ref.String())
+	}
+	return "notes not found: " + strings.Join(refs, ", ")
+}
+
+/**
+ * Fetches notes pointed to by given refs (possibly spanning several notebooks) in a single read transaction
+ *  - refs are grouped by notebook, so that every notebook bucket is looked up just once
+ *  - found notes are returned in the order of refs; if some refs are missing, they are
+ *    left out and reported (each one of them) via *MissingRefsError
+ * param: ...NoteRef refs
+ * return: ([]Note, error)
+ */
+func (db *DB) ResolveRefs(refs ...NoteRef) ([]Note, error) {
+	found := make(map[NoteRef]Note)
+	err := db.View(func(tx *bolt.Tx) error {
+		// group refs by notebook (bucket key)
+		var notebookKeys []string
+		refsByNotebook := make(map[string][]NoteRef)
+		for _, ref := range refs {
+			notebookKey := string(db.notebookKey(ref.Notebook))
+			if _, ok := refsByNotebook[notebookKey]; !ok {
+				notebookKeys = append(notebookKeys, notebookKey)
+			}
+			refsByNotebook[notebookKey] = append(refsByNotebook[notebookKey], ref)
+		}
+
+		for _, notebookKey := range notebookKeys {
+			notebookBucket := tx.Bucket([]byte("Notebook")).Bucket([]byte(notebookKey))
+			if notebookBucket == nil {
+				continue
+			}
+			for _, ref := range refsByNotebook[notebookKey] {
+				noteIdBytes := []byte(strconv.FormatUint(ref.Id, 10))
+				encodedNote := notebookBucket.Get(noteIdBytes)
+				if encodedNote == nil {
+					continue
+				}
+				note, err := db.decodeNote(tx, []byte(notebookKey), noteIdBytes, encodedNote)
+				if err != nil {
+					return err
+				}
+				found[ref] = note
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []Note
+	var missing []NoteRef
+	for _, ref := range refs {
+		if note, ok := found[ref]; ok {
+			notes = append(notes, note)
+		} else {
+			missing = append(missing, ref)
+		}
+	}
+	if len(missing) > 0 {
+		return notes, &MissingRefsError{Refs: missing}
+	}
+	return notes, nil
+}
@@ -0,0 +1,389 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes can be related to one another by typed relations ('blocks', 'duplicates', 'follows-up' ..)
+ *  - a relation goes from one note to another, possibly of another notebook
+ *  - 'Relations' bucket: notebook key -> note id -> entry key -> entry (a relationEntry)
+ *    every relation has two entries written together: an outgoing one on the note it goes from,
+ *    and an incoming one on the note it goes to
+ *  - deleting a note removes its relations (both entries of each); moving a note to another
+ *    notebook carries them along
+ */
+
+/**
+ * Longest kind of relation allowed, in characters
+ */
+const maxRelationKindLength = 64
+
+var (
+	// returned for relations that can't be stored (like ones of an empty kind, or from a note to itself)
+	ErrInvalidRelation = errors.New("invalid relation")
+	// returned by RemoveRelation for relations that don't exist
+	ErrRelationNotFound = errors.New("relation not found")
+)
+
+/**
+ * Relation of a kind from a note to another
+ */
+type Relation struct {
+	From NoteRef `json:"from"`
+	To   NoteRef `json:"to"`
+	Kind string  `json:"kind"`
+}
+
+/**
+ * Direction of relations looked up by GetRelations, as seen from the note they're looked up for
+ */
+type Direction int
+
+const (
+	// relations going from the note
+	Outgoing Direction = iota
+	// relations going to the note
+	Incoming
+	// relations going either way
+	AnyDirection
+)
+
+/**
+ * Entry of a relation stored on one of its notes, pointing at the other one
+ *  - Notebook is the bucket key of the other note's notebook
+ */
+type relationEntry struct {
+	Kind     string `json:"kind"`
+	Outgoing bool   `json:"outgoing"`
+	Notebook string `json:"notebook"`
+	Id       uint64 `json:"id"`
+}
+
+/**
+ * Key of an entry within the bucket of its note: direction ('>' outgoing, '<' incoming),
+ * kind, and the other note (kinds can't contain the separating NUL)
+ */
+func (e relationEntry) key() []byte {
+	direction := "<"
+	if e.Outgoing {
+		direction = ">"
+	}
+	return []byte(direction + e.Kind + "\x00" + e.Notebook + "\x00" + strconv.FormatUint(e.Id, 10))
+}
+
+/**
+ * Relates a note to another; adding a relation that exists already does nothing
+ * Fails with ErrNoteNotFound if either note doesn't exist, ErrNotebookArchived if either notebook
+ * is archived, and ErrInvalidRelation for a kind that's empty, too long (maxRelationKindLength)
+ * or has control characters, or for a note related to itself
+ * param: NoteRef from
+ * param: NoteRef to
+ * param: string  kind
+ * return: error
+ */
+func (db *DB) AddRelation(from NoteRef, to NoteRef, kind string) error {
+	if err := validateRelation(db.notebookKey(from.Notebook), from.Id, db.notebookKey(to.Notebook), to.Id, kind); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, ref := range []NoteRef{from, to} {
+			if err := db.checkNotArchived(tx, ref.Notebook); err != nil {
+				return err
+			}
+			if _, _, err := db.getNoteInTx(tx, ref.Notebook, ref.Id); err != nil {
+				return err
+			}
+		}
+		return putRelation(tx, db.notebookKey(from.Notebook), from.Id, db.notebookKey(to.Notebook), to.Id, kind)
+	})
+}
+
+/**
+ * Removes a relation of a kind from a note to another
+ * Fails with ErrRelationNotFound if there's no such relation
+ * param: NoteRef from
+ * param: NoteRef to
+ * param: string  kind
+ * return: error
+ */
+func (db *DB) RemoveRelation(from NoteRef, to NoteRef, kind string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, from.Notebook); err != nil {
+			return err
+		}
+		if err := db.checkNotArchived(tx, to.Notebook); err != nil {
+			return err
+		}
+		fromKey, toKey := db.notebookKey(from.Notebook), db.notebookKey(to.Notebook)
+		outgoing := relationEntry{Kind: kind, Outgoing: true, Notebook: string(toKey), Id: to.Id}
+		relations := noteRelationsBucket(tx, fromKey, from.Id)
+		if relations == nil || relations.Get(outgoing.key()) == nil {
+			return fmt.Errorf("%w: %s %s %s", ErrRelationNotFound, from, kind, to)
+		}
+		return deleteRelation(tx, fromKey, from.Id, outgoing)
+	})
+}
+
+/**
+ * Retrieves relations of a note, of given kind ("" for all kinds) going in given direction
+ * Relations are ordered by direction (outgoing first), kind and the other note; a note without
+ * relations (or that doesn't exist) has none
+ * param: NoteRef   ref
+ * param: string    kind
+ * param: Direction direction
+ * return: ([]Relation, error)
+ */
+func (db *DB) GetRelations(ref NoteRef, kind string, direction Direction) ([]Relation, error) {
+	var relations []Relation
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(ref.Notebook)
+		self := NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: ref.Id}
+		entries, err := relationEntries(tx, notebookKey, ref.Id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if (kind != "" && entry.Kind != kind) || (direction == Outgoing && !entry.Outgoing) || (direction == Incoming && entry.Outgoing) {
+				continue
+			}
+			other := NoteRef{Notebook: notebookDisplayName(tx, []byte(entry.Notebook)), Id: entry.Id}
+			if entry.Outgoing {
+				relations = append(relations, Relation{From: self, To: other, Kind: entry.Kind})
+			} else {
+				relations = append(relations, Relation{From: other, To: self, Kind: entry.Kind})
+			}
+		}
+		return nil
+	})
+	return relations, err
+}
+
+func validateRelation(fromKey []byte, fromId uint64, toKey []byte, toId uint64, kind string) error {
+	switch {
+	case kind == "":
+		return fmt.Errorf("%w: empty kind", ErrInvalidRelation)
+	case utf8.RuneCountInString(kind) > maxRelationKindLength:
+		return fmt.Errorf("%w: kind is longer than %d characters", ErrInvalidRelation, maxRelationKindLength)
+	case !utf8.ValidString(kind):
+		return fmt.Errorf("%w: kind isn't UTF-8 text", ErrInvalidRelation)
+	case string(fromKey) == string(toKey) && fromId == toId:
+		return fmt.Errorf("%w: a note can't be related to itself", ErrInvalidRelation)
+	}
+	for _, r := range kind {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: kind has control characters", ErrInvalidRelation)
+		}
+	}
+	return nil
+}
+
+/**
+ * Writes both entries of a relation (whose notes are known to exist)
+ */
+func putRelation(tx *bolt.Tx, fromKey []byte, fromId uint64, toKey []byte, toId uint64, kind string) error {
+	entries := []struct {
+		notebookKey []byte
+		noteId      uint64
+		entry       relationEntry
+	}{
+		{fromKey, fromId, relationEntry{Kind: kind, Outgoing: true, Notebook: string(toKey), Id: toId}},
+		{toKey, toId, relationEntry{Kind: kind, Outgoing: false, Notebook: string(fromKey), Id: fromId}},
+	}
+	for _, e := range entries {
+		relations, err := createNoteRelationsBucket(tx, e.notebookKey, e.noteId)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(e.entry)
+		if err != nil {
+			return err
+		}
+		if err := relations.Put(e.entry.key(), encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Removes both entries of a relation, given the one stored on a note
+ */
+func deleteRelation(tx *bolt.Tx, notebookKey []byte, noteId uint64, entry relationEntry) error {
+	mirror := relationEntry{Kind: entry.Kind, Outgoing: !entry.Outgoing, Notebook: string(notebookKey), Id: noteId}
+	for _, e := range []struct {
+		notebookKey []byte
+		noteId      uint64
+		entry       relationEntry
+	}{{notebookKey, noteId, entry}, {[]byte(entry.Notebook), entry.Id, mirror}} {
+		relations := noteRelationsBucket(tx, e.notebookKey, e.noteId)
+		if relations == nil {
+			continue
+		}
+		if err := relations.Delete(e.entry.key()); err != nil {
+			return err
+		}
+		if k, _ := relations.Cursor().First(); k == nil {
+			if err := tx.Bucket([]byte("Relations")).Bucket(e.notebookKey).DeleteBucket([]byte(strconv.FormatUint(e.noteId, 10))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * Removes all relations of a note
+ */
+func deleteRelations(tx *bolt.Tx, notebookKey []byte, noteId uint64) error {
+	entries, err := relationEntries(tx, notebookKey, noteId)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := deleteRelation(tx, notebookKey, noteId, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Moves all relations of a note onto another one (see moveToNotebookInTx)
+ */
+func moveRelations(tx *bolt.Tx, sourceKey []byte, sourceId uint64, targetKey []byte, targetId uint64) error {
+	entries, err := relationEntries(tx, sourceKey, sourceId)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := deleteRelation(tx, sourceKey, sourceId, entry); err != nil {
+			return err
+		}
+		if entry.Outgoing {
+			err = putRelation(tx, targetKey, targetId, []byte(entry.Notebook), entry.Id, entry.Kind)
+		} else {
+			err = putRelation(tx, []byte(entry.Notebook), entry.Id, targetKey, targetId, entry.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Changes the notebook keys relation entries point at, after notebooks got new bucket keys
+ * (see SetCaseInsensitiveNotebooks)
+ * param: map[string]string moved New key by previous key
+ */
+func remapRelations(tx *bolt.Tx, moved map[string]string) error {
+	rootBucket := tx.Bucket([]byte("Relations"))
+	if rootBucket == nil || len(moved) == 0 {
+		return nil
+	}
+	var notebookKeys [][]byte
+	err := rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookKeys = append(notebookKeys, append([]byte(nil), notebookKey...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, notebookKey := range notebookKeys {
+		notebookRelations := rootBucket.Bucket(notebookKey)
+		var noteIds [][]byte
+		err := notebookRelations.ForEach(func(noteIdBytes, _ []byte) error {
+			noteIds = append(noteIds, append([]byte(nil), noteIdBytes...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, noteIdBytes := range noteIds {
+			noteId, _ := strconv.ParseUint(string(noteIdBytes), 10, 64)
+			entries, err := relationEntries(tx, notebookKey, noteId)
+			if err != nil {
+				return err
+			}
+			relations := notebookRelations.Bucket(noteIdBytes)
+			for _, entry := range entries {
+				target, ok := moved[entry.Notebook]
+				if !ok {
+					continue
+				}
+				if err := relations.Delete(entry.key()); err != nil {
+					return err
+				}
+				entry.Notebook = target
+				encoded, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				if err := relations.Put(entry.key(), encoded); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * Entries of relations of a note, in order of their keys
+ */
+func relationEntries(tx *bolt.Tx, notebookKey []byte, noteId uint64) ([]relationEntry, error) {
+	relations := noteRelationsBucket(tx, notebookKey, noteId)
+	if relations == nil {
+		return nil, nil
+	}
+	var entries []relationEntry
+	err := relations.ForEach(func(_, v []byte) error {
+		var entry relationEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	// outgoing ('>') before incoming ('<')
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Outgoing && !entries[j].Outgoing
+	})
+	return entries, err
+}
+
+/**
+ * Retrieves (3rd order) relations bucket of a note; nil if it has no relations
+ */
+func noteRelationsBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) *bolt.Bucket {
+	rootBucket := tx.Bucket([]byte("Relations"))
+	if rootBucket == nil {
+		return nil
+	}
+	notebookRelations := rootBucket.Bucket(notebookKey)
+	if notebookRelations == nil {
+		return nil
+	}
+	return notebookRelations.Bucket([]byte(strconv.FormatUint(noteId, 10)))
+}
+
+func createNoteRelationsBucket(tx *bolt.Tx, notebookKey []byte, noteId uint64) (*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte("Relations"))
+	if err != nil {
+		return nil, err
+	}
+	notebookRelations, err := rootBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	return notebookRelations.CreateBucketIfNotExists([]byte(strconv.FormatUint(noteId, 10)))
+}
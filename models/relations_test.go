@@ -0,0 +1,157 @@
+package models_test
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Relations of a note going either way, as sorted "from kind to" lines
+ */
+func relationsOf(t *testing.T, db *models.DB, ref models.NoteRef) []string {
+	t.Helper()
+	relations, err := db.GetRelations(ref, "", models.AnyDirection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, len(relations))
+	for i, relation := range relations {
+		lines[i] = relation.From.String() + " " + relation.Kind + " " + relation.To.String()
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestRelations(t *testing.T) {
+	db := notestest.NewDB(t)
+	ids := notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"a", "b"}, "home": {"c"}}})
+	a, b := models.NoteRef{Notebook: "work", Id: ids["work"][0]}, models.NoteRef{Notebook: "work", Id: ids["work"][1]}
+	c := models.NoteRef{Notebook: "home", Id: ids["home"][0]}
+	for _, relation := range []models.Relation{{From: a, To: b, Kind: "blocks"}, {From: c, To: a, Kind: "duplicates"}} {
+		if err := db.AddRelation(relation.From, relation.To, relation.Kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// adding a relation again does nothing
+	if err := db.AddRelation(a, b, "blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relationsOf(t, db, a), []string{"home/1 duplicates work/1", "work/1 blocks work/2"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("relations of a: %q, want %q", got, want)
+	}
+	outgoing, err := db.GetRelations(a, "", models.Outgoing)
+	if err != nil || len(outgoing) != 1 || outgoing[0].To != b {
+		t.Errorf("outgoing relations of a: %+v (%v)", outgoing, err)
+	}
+	incoming, err := db.GetRelations(b, "blocks", models.Incoming)
+	if err != nil || len(incoming) != 1 || incoming[0].From != a {
+		t.Errorf("incoming relations of b: %+v (%v)", incoming, err)
+	}
+
+	for name, kind := range map[string]string{
+		"empty kind":         "",
+		"kind too long":      strings.Repeat("k", 65),
+		"control characters": "blocks\x00",
+	} {
+		if err := db.AddRelation(a, b, kind); !errors.Is(err, models.ErrInvalidRelation) {
+			t.Errorf("%s: %v, want ErrInvalidRelation", name, err)
+		}
+	}
+	if err := db.AddRelation(a, a, "blocks"); !errors.Is(err, models.ErrInvalidRelation) {
+		t.Errorf("relating a note to itself: %v, want ErrInvalidRelation", err)
+	}
+	if err := db.AddRelation(a, models.NoteRef{Notebook: "work", Id: 99}, "blocks"); !errors.Is(err, models.ErrNoteNotFound) {
+		t.Errorf("relating a note to a missing one: %v, want ErrNoteNotFound", err)
+	}
+
+	if err := db.RemoveRelation(b, a, "blocks"); !errors.Is(err, models.ErrRelationNotFound) {
+		t.Errorf("removing a relation the wrong way round: %v, want ErrRelationNotFound", err)
+	}
+	if err := db.RemoveRelation(a, b, "blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if got := relationsOf(t, db, b); len(got) != 0 {
+		t.Errorf("relations of b after removing its only one: %q", got)
+	}
+}
+
+func TestDeletingNoteRemovesItsRelations(t *testing.T) {
+	db := notestest.NewDB(t)
+	ids := notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": {"a", "b", "c"}, "home": {"d"}}})
+	a, b, c := models.NoteRef{Notebook: "work", Id: ids["work"][0]}, models.NoteRef{Notebook: "work", Id: ids["work"][1]}, models.NoteRef{Notebook: "work", Id: ids["work"][2]}
+	d := models.NoteRef{Notebook: "home", Id: ids["home"][0]}
+	for _, relation := range []models.Relation{
+		{From: a, To: b, Kind: "blocks"}, {From: c, To: a, Kind: "duplicates"}, {From: a, To: d, Kind: "follows-up"}, {From: b, To: c, Kind: "blocks"},
+	} {
+		if err := db.AddRelation(relation.From, relation.To, relation.Kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DeleteNotes("work", a.Id); err != nil {
+		t.Fatal(err)
+	}
+	// both entries of every relation of the deleted note are gone, others are left alone
+	if got, want := relationsOf(t, db, b), []string{"work/2 blocks work/3"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("relations of b: %q, want %q", got, want)
+	}
+	if got, want := relationsOf(t, db, c), []string{"work/2 blocks work/3"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("relations of c: %q, want %q", got, want)
+	}
+	if got := relationsOf(t, db, d); len(got) != 0 {
+		t.Errorf("relations of d in another notebook: %q, want none", got)
+	}
+	if got := relationsOf(t, db, a); len(got) != 0 {
+		t.Errorf("relations of the deleted note: %q, want none", got)
+	}
+	if problems, err := db.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity problems after deleting a related note: %+v (%v)", problems, err)
+	}
+}
+
+func TestRelationsExportRoundTrip(t *testing.T) {
+	source := notestest.NewDB(t)
+	ids := notestest.Seed(t, source, notestest.Spec{Notebooks: map[string][]string{"work": {"a", "b", "c"}, "home": {"d"}}})
+	a, b, c := models.NoteRef{Notebook: "work", Id: ids["work"][0]}, models.NoteRef{Notebook: "work", Id: ids["work"][1]}, models.NoteRef{Notebook: "work", Id: ids["work"][2]}
+	d := models.NoteRef{Notebook: "home", Id: ids["home"][0]}
+	for _, relation := range []models.Relation{
+		{From: a, To: b, Kind: "blocks"}, {From: c, To: a, Kind: "duplicates"}, {From: a, To: d, Kind: "follows-up"},
+	} {
+		if err := source.AddRelation(relation.From, relation.To, relation.Kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var export bytes.Buffer
+	if err := source.ExportNotebook("work", &export); err != nil {
+		t.Fatal(err)
+	}
+
+	db := notestest.NewDB(t)
+	notestest.MustAdd(t, db, "copy", "shifting ids")
+	report, err := db.ImportNotebook("copy", &export, models.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the relation to a note of another notebook isn't part of the export
+	if report.Relations != 2 {
+		t.Errorf("%d relations recreated, want 2", report.Relations)
+	}
+	imported := make(map[string]models.NoteRef)
+	for _, mapping := range report.Mapping {
+		if mapping.Ref != nil {
+			imported[mapping.SourceKey] = *mapping.Ref
+		}
+	}
+	copyOf := func(ref models.NoteRef) string { return imported[ref.String()].String() }
+	want := []string{copyOf(a) + " blocks " + copyOf(b), copyOf(c) + " duplicates " + copyOf(a)}
+	sort.Strings(want)
+	if got := relationsOf(t, db, imported[a.String()]); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("relations of the copy of a: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Renders a note as an HTML fragment, as per its kind
+ *  - markdown notes are rendered from markdown (see renderMarkdown for what's supported)
+ *  - JSON notes are pretty-printed within <pre>
+ *  - text notes become paragraphs (blank lines separating them), line breaks being kept
+ * All content is escaped, so that notes can't inject markup or scripts, and so are control characters
+ * (see EscapeControlChars)
+ * param: Note note
+ * return: string
+ */
+func RenderHTML(note Note) string {
+	note.Content = EscapeControlChars(note.Content)
+	switch note.Kind {
+	case KindMarkdown:
+		return renderMarkdown(note.Content)
+	case KindJSON:
+		return "<pre><code>" + html.EscapeString(DisplayContent(note)) + "</code></pre>\n"
+	}
+	var sb strings.Builder
+	for _, paragraph := range strings.Split(strings.TrimSpace(note.Content), "\n\n") {
+		if paragraph = strings.Trim(paragraph, "\n"); paragraph != "" {
+			sb.WriteString("<p>" + strings.Replace(html.EscapeString(paragraph), "\n", "<br>\n", -1) + "</p>\n")
+		}
+	}
+	return sb.String()
+}
+
+var (
+	markdownHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownRule       = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	markdownBullet     = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	markdownNumbered   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	markdownTask       = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+	markdownLink       = regexp.MustCompile(`\[([^\]]+)\]\(((?:[^()\s]|\([^()\s]*\))+)\)`)
+	markdownStrong     = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	markdownEmphasis   = regexp.MustCompile(`(^|[^\w*])[*_]([^*_\s][^*_]*?)[*_]($|[^\w*])`)
+	markdownSafeURL    = regexp.MustCompile(`^(?i)(https?:|mailto:|[/#.]|[\w-]+(/|$))`)
+	markdownCodeFences = "```"
+)
+
+/**
+ * Renders the common subset of markdown: ATX headings, paragraphs, fenced code blocks,
+ * block quotes, bulleted / numbered lists (with task items), horizontal rules, and inline
+ * code, strong / emphasized text and links (other than javascript: and such)
+ */
+func renderMarkdown(content string) string {
+	var sb strings.Builder
+	var paragraph, quote []string
+	list := ""
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			sb.WriteString("<p>" + renderInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	flushQuote := func() {
+		if len(quote) > 0 {
+			sb.WriteString("<blockquote>\n" + renderMarkdown(strings.Join(quote, "\n")) + "</blockquote>\n")
+			quote = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			sb.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(kind string) {
+		if list != kind {
+			closeList()
+			sb.WriteString("<" + kind + ">\n")
+			list = kind
+		}
+	}
+
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, ">") {
+			flushParagraph()
+			closeList()
+			quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(line, ">"), " "))
+			continue
+		}
+		flushQuote()
+
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), markdownCodeFences):
+			flushParagraph()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), markdownCodeFences); i++ {
+				code = append(code, lines[i])
+			}
+			sb.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case strings.TrimSpace(line) == "":
+			flushParagraph()
+			closeList()
+		case markdownHeading.MatchString(line):
+			flushParagraph()
+			closeList()
+			match := markdownHeading.FindStringSubmatch(line)
+			level := string('0' + rune(len(match[1])))
+			sb.WriteString("<h" + level + ">" + renderInline(match[2]) + "</h" + level + ">\n")
+		case markdownRule.MatchString(line):
+			flushParagraph()
+			closeList()
+			sb.WriteString("<hr>\n")
+		case markdownBullet.MatchString(line):
+			flushParagraph()
+			openList("ul")
+			sb.WriteString("<li>" + renderListItem(markdownBullet.FindStringSubmatch(line)[1]) + "</li>\n")
+		case markdownNumbered.MatchString(line):
+			flushParagraph()
+			openList("ol")
+			sb.WriteString("<li>" + renderListItem(markdownNumbered.FindStringSubmatch(line)[1]) + "</li>\n")
+		default:
+			closeList()
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	flushParagraph()
+	flushQuote()
+	closeList()
+	return sb.String()
+}
+
+/**
+ * Renders the text of a list item, task items ('[ ] ..' / '[x] ..') getting a checkbox
+ */
+func renderListItem(text string) string {
+	if match := markdownTask.FindStringSubmatch(text); match != nil {
+		checked := ""
+		if match[1] != " " {
+			checked = " checked"
+		}
+		return `<input type="checkbox" disabled` + checked + `> ` + renderInline(match[2])
+	}
+	return renderInline(text)
+}
+
+/**
+ * Renders inline markdown of a block's text: code spans are left alone, the rest is escaped
+ * and gets links and strong / emphasized text
+ */
+func renderInline(text string) string {
+	var sb strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		// odd parts are within backticks, unless the last backtick isn't closed
+		if i%2 == 1 && i < len(parts)-1 {
+			sb.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			sb.WriteString("`")
+		}
+		escaped := html.EscapeString(part)
+		escaped = markdownLink.ReplaceAllStringFunc(escaped, func(link string) string {
+			match := markdownLink.FindStringSubmatch(link)
+			if !markdownSafeURL.MatchString(html.UnescapeString(match[2])) {
+				return match[1]
+			}
+			return `<a href="` + match[2] + `">` + match[1] + `</a>`
+		})
+		escaped = markdownStrong.ReplaceAllString(escaped, "<strong>$2</strong>")
+		escaped = markdownEmphasis.ReplaceAllString(escaped, "$1<em>$2</em>$3")
+		sb.WriteString(escaped)
+	}
+	return sb.String()
+}
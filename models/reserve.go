@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Ids of notes can be reserved ahead of writing the notes (see ReserveNoteIDs), so that other
+ * systems can refer to notes before they exist
+ *  - reserving advances the notebook's sequence, so reserved ids are never handed out to other notes
+ *  - reserved ids are written with PutNote, each at most once
+ *  - ids reserved but never written are gaps, like ids of deleted notes
+ * 'Reservations' bucket: notebook key -> first id of a range (8 byte big endian) -> last id of the range
+ */
+
+/**
+ * Returned by PutNote for ids that aren't reserved (or were written already)
+ */
+var ErrIdNotReserved = errors.New("note id is not reserved")
+
+/**
+ * Largest number of ids reserved at once
+ */
+const maxReservation = 1 << 20
+
+/**
+ * Reserves ids for n notes of a notebook (created if it doesn't exist), to be written with PutNote
+ * Fails with ErrNotebookArchived if the notebook is archived
+ * param: string notebookName
+ * param: int    n
+ * return: ([]uint64, error) The ids reserved, consecutive and ascending
+ */
+func (db *DB) ReserveNoteIDs(notebookName string, n int) ([]uint64, error) {
+	if n <= 0 || n > maxReservation {
+		return nil, fmt.Errorf("can reserve 1 to %d ids at once, not %d", maxReservation, n)
+	}
+	var ids []uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists(notebookKey)
+		if err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, notebookName); err != nil {
+			return err
+		}
+		first := notebookBucket.Sequence() + 1
+		last := notebookBucket.Sequence() + uint64(n)
+		if err := notebookBucket.SetSequence(last); err != nil {
+			return err
+		}
+		reservations, err := createReservationsBucket(tx, notebookKey)
+		if err != nil {
+			return err
+		}
+		if err := reservations.Put(itob(first), itob(last)); err != nil {
+			return err
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+/**
+ * Writes a note under an id reserved by ReserveNoteIDs; notebook defaults apply as in AddNote
+ * Fails with ErrIdNotReserved if note.Id isn't reserved, or was written already
+ * param: string notebookName
+ * param: Note   note
+ * return: (Note, error) The note as stored
+ */
+func (db *DB) PutNote(notebookName string, note Note) (Note, error) {
+	batch, err := db.prepareAdd(notebookName, []Note{note})
+	if err != nil {
+		return note, err
+	}
+	batch.ids = []uint64{note.Id}
+	err = db.Update(func(tx *bolt.Tx) error {
+		added, err := db.commitAdd(tx, batch)
+		if err != nil {
+			return err
+		}
+		note = added[0]
+		return nil
+	})
+	return note, err
+}
+
+func createReservationsBucket(tx *bolt.Tx, notebookKey []byte) (*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte("Reservations"))
+	if err != nil {
+		return nil, err
+	}
+	return rootBucket.CreateBucketIfNotExists(notebookKey)
+}
+
+/**
+ * Takes ids out of the reserved ranges of a notebook, splitting the ranges they fall in
+ * Fails with ErrIdNotReserved if any of them isn't reserved
+ */
+func takeReservedIds(tx *bolt.Tx, notebookKey []byte, notebookName string, ids []uint64) error {
+	var reservations *bolt.Bucket
+	if rootBucket := tx.Bucket([]byte("Reservations")); rootBucket != nil {
+		reservations = rootBucket.Bucket(notebookKey)
+	}
+	for _, id := range ids {
+		if reservations == nil {
+			return fmt.Errorf("%w: %d in notebook '%s'", ErrIdNotReserved, id, notebookName)
+		}
+		// the range holding id is the last one starting at or before it
+		cursor := reservations.Cursor()
+		k, v := cursor.Seek(itob(id))
+		switch {
+		case k == nil:
+			k, v = cursor.Last()
+		case binary.BigEndian.Uint64(k) != id:
+			k, v = cursor.Prev()
+		}
+		if k == nil || binary.BigEndian.Uint64(k) > id || binary.BigEndian.Uint64(v) < id {
+			return fmt.Errorf("%w: %d in notebook '%s'", ErrIdNotReserved, id, notebookName)
+		}
+		first, last := binary.BigEndian.Uint64(k), binary.BigEndian.Uint64(v)
+		if err := reservations.Delete(k); err != nil {
+			return err
+		}
+		if first < id {
+			if err := reservations.Put(itob(first), itob(id-1)); err != nil {
+				return err
+			}
+		}
+		if id < last {
+			if err := reservations.Put(itob(id+1), itob(last)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package models_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestConcurrentReservationsNeverOverlap(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.MustAdd(t, db, "work", "before the reservations")
+
+	const workers, rounds = 8, 25
+	var wg sync.WaitGroup
+	reserved := make([][][]uint64, workers)
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// reservations of different sizes, interleaved with plain adds
+				ids, err := db.ReserveNoteIDs("work", 1+(w+i)%5)
+				if err != nil {
+					errs <- err
+					return
+				}
+				reserved[w] = append(reserved[w], ids)
+				if i%5 == 0 {
+					if _, err := db.AddNote("work", models.Note{Content: "added meanwhile"}); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	added, err := db.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := make(map[uint64]string)
+	for _, note := range added {
+		owner[note.Id] = "an added note"
+	}
+	for w, reservations := range reserved {
+		for _, ids := range reservations {
+			for i, id := range ids {
+				if i > 0 && id != ids[i-1]+1 {
+					t.Errorf("worker %d reserved %v, not consecutive", w, ids)
+				}
+				if other, taken := owner[id]; taken {
+					t.Errorf("id %d reserved by worker %d was given to %s too", id, w, other)
+				}
+				owner[id] = "another reservation"
+			}
+		}
+	}
+
+	// every reserved id can be written once, and ids reserved but never written are just gaps
+	written := 0
+	for w, reservations := range reserved {
+		for i, ids := range reservations {
+			if i%2 == 1 {
+				continue
+			}
+			for _, id := range ids {
+				if _, err := db.PutNote("work", models.Note{Id: id, Content: "reserved"}); err != nil {
+					t.Fatalf("writing reserved id %d of worker %d: %v", id, w, err)
+				}
+				written++
+				if _, err := db.PutNote("work", models.Note{Id: id, Content: "again"}); !errors.Is(err, models.ErrIdNotReserved) {
+					t.Errorf("writing id %d twice: %v, want ErrIdNotReserved", id, err)
+				}
+			}
+		}
+	}
+	if problems, err := db.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity problems with gaps left by reservations: %+v (%v)", problems, err)
+	}
+	notes, err := db.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[uint64]bool)
+	for _, note := range notes {
+		if listed[note.Id] {
+			t.Errorf("note %d listed twice", note.Id)
+		}
+		listed[note.Id] = true
+	}
+	if len(notes) != len(added)+written {
+		t.Errorf("%d notes listed, want the %d added and the %d written under reserved ids", len(notes), len(added), written)
+	}
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Imports of streams (ImportENEX, ImportNotebook) can be resumed after failing midway
+ *  - as notes get committed, the import emits checkpoints to the OnCheckpoint option; the last
+ *    one is also returned in ImportReport.Resume, failed imports included
+ *  - passing a checkpoint's token as the ResumeFrom option, along with the same input, skips the
+ *    records it covers and carries on after them
+ *  - a checkpoint covers committed notes only; notes committed after it (before the import failed)
+ *    are recognized by their content among the notes having ids above its LastId, and mapped onto
+ *    rather than imported again
+ */
+
+/**
+ * Position of an import within its input, up to which everything read has been committed
+ *  - Records: number of records (notes) read, whether imported, duplicate or skipped
+ *  - Offset: byte offset just past the last of them (of the decrypted input, for encrypted exports)
+ *  - LastId: sequence of the notebook at the time, so every note created since has a higher id
+ */
+type ImportCheckpoint struct {
+	Records int    `json:"records"`
+	Offset  int64  `json:"offset"`
+	LastId  uint64 `json:"last_id"`
+}
+
+/**
+ * Opaque encoding of an ImportCheckpoint, to persist and pass as ResumeFrom
+ */
+type ResumeToken string
+
+/**
+ * Returned by imports given a ResumeFrom token that can't be decoded
+ */
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
+/**
+ * Number of records between checkpoints of ImportNotebook (which commits every note on its own)
+ */
+const importCheckpointInterval = 100
+
+/**
+ * Encodes the checkpoint as a token
+ */
+func (c ImportCheckpoint) Token() ResumeToken {
+	encoded, _ := json.Marshal(c)
+	return ResumeToken(base64.RawURLEncoding.EncodeToString(encoded))
+}
+
+/**
+ * Decodes the checkpoint of a token
+ * return: (ImportCheckpoint, error)
+ */
+func (t ResumeToken) Checkpoint() (ImportCheckpoint, error) {
+	var checkpoint ImportCheckpoint
+	encoded, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err == nil {
+		err = json.Unmarshal(encoded, &checkpoint)
+	}
+	if err != nil || checkpoint.Records < 0 || checkpoint.Offset < 0 {
+		return checkpoint, ErrInvalidResumeToken
+	}
+	return checkpoint, nil
+}
+
+/**
+ * Tracks the checkpoints of an import, and the notes it committed after the one it resumes from
+ */
+type importProgress struct {
+	db           *DB
+	notebookName string
+	report       *ImportReport
+	onCheckpoint func(ResumeToken)
+	resumeFrom   ImportCheckpoint
+	// notes committed after resumeFrom: hash of content as stored -> ids
+	recovered map[string][]uint64
+	defaults  NotebookDefaults
+	// records read as of the last checkpoint
+	checkpointed int
+}
+
+/**
+ * Starts tracking an import, resuming from given token (if any)
+ */
+func (db *DB) newImportProgress(notebookName string, resumeFrom ResumeToken, onCheckpoint func(ResumeToken), report *ImportReport) (*importProgress, error) {
+	p := &importProgress{db: db, notebookName: notebookName, report: report, onCheckpoint: onCheckpoint}
+	if resumeFrom == "" {
+		return p, nil
+	}
+	var err error
+	if p.resumeFrom, err = resumeFrom.Checkpoint(); err != nil {
+		return nil, err
+	}
+	p.checkpointed = p.resumeFrom.Records
+	report.Resume = resumeFrom
+
+	p.recovered = make(map[string][]uint64)
+	err = db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		p.defaults = getNotebookMeta(tx, notebookKey).Defaults
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(_, v []byte) error {
+			var note Note
+			if err := json.Unmarshal(v, &note); err != nil {
+				return err
+			}
+			if note.Id > p.resumeFrom.LastId {
+				hash := storedContentHash(note)
+				p.recovered[hash] = append(p.recovered[hash], note.Id)
+			}
+			return nil
+		})
+	})
+	return p, err
+}
+
+/**
+ * Whether the record read as the given one (counting from 1) is covered by the checkpoint resumed from
+ */
+func (p *importProgress) skipped(record int) bool {
+	return record <= p.resumeFrom.Records
+}
+
+/**
+ * Looks up a note committed after the checkpoint resumed from with the content given note
+ * would be stored with, mapping the record onto it (it then counts as imported)
+ * param: Note   note
+ * param: string sourceKey
+ * param: bool   withDefaults Whether notebook defaults are applied to imported notes
+ * return: (bool, error) Whether such a note was found
+ */
+func (p *importProgress) recover(note Note, sourceKey string, withDefaults bool) (bool, error) {
+	if len(p.recovered) == 0 {
+		return false, nil
+	}
+	defaults := NotebookDefaults{}
+	if withDefaults {
+		defaults = p.defaults
+	}
+	prepared, err := prepareNotes([]Note{note}, defaults, p.db.encoding())
+	if err != nil {
+		return false, err
+	}
+	hash := contentHash(prepared[0].note.Content)
+	ids := p.recovered[hash]
+	if len(ids) == 0 {
+		return false, nil
+	}
+	if p.recovered[hash] = ids[1:]; len(ids) == 1 {
+		delete(p.recovered, hash)
+	}
+	p.report.Imported++
+	p.report.Mapping = append(p.report.Mapping, mappedTo(sourceKey, p.notebookName, ids[0], MappingCreated))
+	return true, nil
+}
+
+/**
+ * Emits a checkpoint once everything up to given record has been committed
+ * param: int   records Number of records read
+ * param: int64 offset
+ * return: error
+ */
+func (p *importProgress) checkpoint(records int, offset int64) error {
+	if records <= p.checkpointed {
+		return nil
+	}
+	checkpoint := ImportCheckpoint{Records: records, Offset: offset}
+	err := p.db.View(func(tx *bolt.Tx) error {
+		if notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(p.db.notebookKey(p.notebookName)); notebookBucket != nil {
+			checkpoint.LastId = notebookBucket.Sequence()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.checkpointed = records
+	p.report.Resume = checkpoint.Token()
+	if p.onCheckpoint != nil {
+		p.onCheckpoint(p.report.Resume)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package models_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// injected failure killing imports midway
+var errKilled = errors.New("killed")
+
+/**
+ * Reader failing with errKilled once n bytes are read
+ */
+type killingReader struct {
+	r io.Reader
+	n int
+}
+
+func (k *killingReader) Read(p []byte) (int, error) {
+	if k.n <= 0 {
+		return 0, errKilled
+	}
+	if len(p) > k.n {
+		p = p[:k.n]
+	}
+	n, err := k.r.Read(p)
+	k.n -= n
+	return n, err
+}
+
+/**
+ * Notes of a notebook as compared between imports: everything but what differs from DB to DB
+ */
+func comparableNotes(t *testing.T, db *models.DB, notebookName string) []string {
+	t.Helper()
+	notes, err := db.ListNotes(notebookName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compared := make([]string, len(notes))
+	for i, note := range notes {
+		compared[i] = fmt.Sprintf("%d %q %v %s", note.Id, note.Content, note.Tags, note.CreatedAt.UTC().Format("2006-01-02T15:04"))
+	}
+	return compared
+}
+
+/**
+ * Export of a notebook of 250 notes, a few of them alike (which resuming must tell apart by position)
+ */
+func resumableExport(t *testing.T) []byte {
+	t.Helper()
+	source := notestest.NewDB(t)
+	contents := make([]string, 250)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("Note %d\nbody", i+1)
+		if i%40 == 39 {
+			contents[i] = "same content"
+		}
+	}
+	notestest.Seed(t, source, notestest.Spec{Notebooks: map[string][]string{"work": contents}, Tags: []string{"imported"}})
+	var export bytes.Buffer
+	if err := source.ExportNotebook("work", &export); err != nil {
+		t.Fatal(err)
+	}
+	return export.Bytes()
+}
+
+func TestResumedNotebookImportEqualsOneShot(t *testing.T) {
+	export := resumableExport(t)
+	oneShot := notestest.NewDB(t)
+	if _, err := oneShot.ImportNotebook("work", bytes.NewReader(export), models.ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := comparableNotes(t, oneShot, "work")
+
+	for _, test := range []struct {
+		name string
+		// kills the import of db, returning the input to give it
+		kill func(t *testing.T, db *models.DB) io.Reader
+	}{
+		{"failing write", func(t *testing.T, db *models.DB) io.Reader {
+			saved := 0
+			db.SetBeforeSave(func(string, models.Note) error {
+				if saved++; saved == 230 {
+					return errKilled
+				}
+				return nil
+			})
+			return bytes.NewReader(export)
+		}},
+		{"failing input", func(t *testing.T, db *models.DB) io.Reader {
+			return &killingReader{r: bytes.NewReader(export), n: len(export) * 9 / 10}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db := notestest.NewDB(t)
+			var checkpoints []models.ResumeToken
+			report, err := db.ImportNotebook("work", test.kill(t, db), models.ImportOptions{OnCheckpoint: func(token models.ResumeToken) {
+				checkpoints = append(checkpoints, token)
+			}})
+			if err == nil {
+				t.Fatal("killed import succeeded")
+			}
+			if len(checkpoints) != 2 || report.Resume != checkpoints[len(checkpoints)-1] {
+				t.Fatalf("killed import emitted %d checkpoints (last resumable %q), want 2", len(checkpoints), report.Resume)
+			}
+			checkpoint, err := report.Resume.Checkpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the checkpoint covers committed notes only, and notes were committed past it
+			committed, _ := db.ListNotes("work")
+			if checkpoint.Records != 200 || checkpoint.LastId != 200 || len(committed) <= 200 {
+				t.Fatalf("checkpoint %+v with %d notes committed", checkpoint, len(committed))
+			}
+
+			db.SetBeforeSave(nil)
+			resumed, err := db.ImportNotebook("work", bytes.NewReader(export), models.ImportOptions{ResumeFrom: report.Resume})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed.Imported != 50 {
+				t.Errorf("resumed import imported %d notes, want the 50 after the checkpoint", resumed.Imported)
+			}
+			if got := comparableNotes(t, db, "work"); !reflect.DeepEqual(got, want) {
+				t.Errorf("resumed import differs from a one-shot import:\n%s", firstDifferentNote(want, got))
+			}
+		})
+	}
+}
+
+func TestResumedENEXImportEqualsOneShot(t *testing.T) {
+	var enex strings.Builder
+	enex.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<en-export>\n")
+	for i := 1; i <= 120; i++ {
+		fmt.Fprintf(&enex, "<note><title>Note %d</title><content><![CDATA[<en-note><div>body %d</div></en-note>]]></content>"+
+			"<created>20240101T090000Z</created><tag>imported</tag></note>\n", i, i%7)
+	}
+	enex.WriteString("</en-export>\n")
+	export := []byte(enex.String())
+
+	oneShot := notestest.NewDB(t)
+	if _, err := oneShot.ImportENEX("work", bytes.NewReader(export), models.ENEXOptions{BatchSize: 25}); err != nil {
+		t.Fatal(err)
+	}
+	want := comparableNotes(t, oneShot, "work")
+
+	db := notestest.NewDB(t)
+	report, err := db.ImportENEX("work", &killingReader{r: bytes.NewReader(export), n: len(export) * 2 / 3}, models.ENEXOptions{BatchSize: 25})
+	if err == nil {
+		t.Fatal("killed import succeeded")
+	}
+	checkpoint, err := report.Resume.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Records == 0 || checkpoint.Records%25 != 0 || checkpoint.Records >= 120 {
+		t.Fatalf("checkpoint %+v, want one after a committed batch of 25", checkpoint)
+	}
+	if _, err := db.ImportENEX("work", bytes.NewReader(export), models.ENEXOptions{BatchSize: 25, ResumeFrom: report.Resume}); err != nil {
+		t.Fatal(err)
+	}
+	if got := comparableNotes(t, db, "work"); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed import differs from a one-shot import:\n%s", firstDifferentNote(want, got))
+	}
+}
+
+func firstDifferentNote(want, got []string) string {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Sprintf("note %d of %d (%d wanted):\n  want: %s\n  got:  %s", i, len(got), len(want), w, g)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * How long auxiliary records are kept around (zero keeps them forever); the policy is persisted
+ * in the DB and applied by ApplyRetention (and by the janitor, see StartJanitor)
+ *  - Changelog: entries of the undo log (the record of destructive changes, see undo.go); entries
+ *    newer than the oldest event still waiting in the outbox are kept regardless
+ *  - History: past revisions of notes (see history.go), by the time they were superseded; the
+ *    latest revision of a note that still exists is kept regardless, and revisions older than
+ *    one that expires expire with it
+ *  - MaxRevisionsPerNote: number of past revisions kept per note, newest first (zero for no limit)
+ *  - AccessLog: access times of notes (see access.go), by the time of access
+ * The outbox itself is never pruned: its events are only dropped once they're handled
+ */
+type RetentionPolicy struct {
+	Changelog           time.Duration `json:"changelog,omitempty"`
+	History             time.Duration `json:"history,omitempty"`
+	AccessLog           time.Duration `json:"access_log,omitempty"`
+	MaxRevisionsPerNote int           `json:"max_revisions_per_note,omitempty"`
+}
+
+/**
+ * Outcome of ApplyRetention: number of records removed from each bucket, and time taken
+ */
+type RetentionReport struct {
+	Changelog int           `json:"changelog"`
+	History   int           `json:"history"`
+	AccessLog int           `json:"access_log"`
+	Took      time.Duration `json:"took"`
+}
+
+/**
+ * Number of records removed per write transaction by ApplyRetention
+ */
+const retentionBatch = 1000
+
+/**
+ * A record to be removed: its key, within the bucket at given path from the root
+ */
+type expiredRecord struct {
+	path [][]byte
+	key  []byte
+}
+
+/**
+ * Sets the retention policy; the policy is persisted in the DB
+ * param: RetentionPolicy policy
+ * return: error
+ */
+func (db *DB) SetRetentionPolicy(policy RetentionPolicy) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.Retention = policy
+		return putSettings(tx, settings)
+	})
+}
+
+/**
+ * Retrieves the retention policy
+ * return: (RetentionPolicy, error)
+ */
+func (db *DB) GetRetentionPolicy() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	err := db.View(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		policy = settings.Retention
+		return err
+	})
+	return policy, err
+}
+
+/**
+ * Prunes auxiliary buckets as per the retention policy
+ * Records due are collected in a read transaction, and removed in write transactions of
+ * retentionBatch records each, so that writers aren't held up for long
+ * return: (RetentionReport, error) Counts removed so far if it fails midway
+ */
+func (db *DB) ApplyRetention() (RetentionReport, error) {
+	start := time.Now()
+	var report RetentionReport
+	policy, err := db.GetRetentionPolicy()
+	if err != nil {
+		return report, err
+	}
+
+	var changelog, history, accessLog []expiredRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		if changelog, err = expiredChangelog(tx, policy, start); err != nil {
+			return err
+		}
+		if history, err = expiredHistory(tx, policy, start); err != nil {
+			return err
+		}
+		accessLog = expiredAccessLog(tx, policy, start)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, prune := range []struct {
+		records []expiredRecord
+		count   *int
+	}{{changelog, &report.Changelog}, {history, &report.History}, {accessLog, &report.AccessLog}} {
+		if *prune.count, err = db.removeRecords(prune.records); err != nil {
+			break
+		}
+	}
+	report.Took = time.Since(start)
+	return report, err
+}
+
+/**
+ * Undo entries older than the policy allows (and than the oldest event waiting in the outbox)
+ */
+func expiredChangelog(tx *bolt.Tx, policy RetentionPolicy, now time.Time) ([]expiredRecord, error) {
+	bucket := tx.Bucket([]byte("UndoLog"))
+	if policy.Changelog <= 0 || bucket == nil {
+		return nil, nil
+	}
+	cutoff := now.Add(-policy.Changelog)
+	if outbox := tx.Bucket([]byte("Outbox")); outbox != nil {
+		if _, v := outbox.Cursor().First(); v != nil {
+			var oldest ChangeEvent
+			if err := json.Unmarshal(v, &oldest); err != nil {
+				return nil, err
+			}
+			if oldest.Committed.Before(cutoff) {
+				cutoff = oldest.Committed
+			}
+		}
+	}
+
+	var expired []expiredRecord
+	err := bucket.ForEach(func(k, v []byte) error {
+		var entry UndoEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		if entry.CreatedAt.Before(cutoff) {
+			expired = append(expired, expiredRecord{path: [][]byte{[]byte("UndoLog")}, key: append([]byte(nil), k...)})
+		}
+		return nil
+	})
+	return expired, err
+}
+
+/**
+ * Revisions older than the policy allows, or beyond the number of revisions kept per note
+ */
+func expiredHistory(tx *bolt.Tx, policy RetentionPolicy, now time.Time) ([]expiredRecord, error) {
+	historyBucket := tx.Bucket([]byte("History"))
+	if (policy.History <= 0 && policy.MaxRevisionsPerNote <= 0) || historyBucket == nil {
+		return nil, nil
+	}
+	cutoff := now.Add(-policy.History)
+
+	var expired []expiredRecord
+	err := historyBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookHistoryBucket := historyBucket.Bucket(notebookKey)
+		if notebookHistoryBucket == nil {
+			return nil
+		}
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		return notebookHistoryBucket.ForEach(func(noteIdBytes, _ []byte) error {
+			noteHistory := notebookHistoryBucket.Bucket(noteIdBytes)
+			if noteHistory == nil {
+				return nil
+			}
+			live := notebookBucket != nil && notebookBucket.Get(noteIdBytes) != nil
+			path := [][]byte{[]byte("History"), append([]byte(nil), notebookKey...), append([]byte(nil), noteIdBytes...)}
+
+			// newest first, so that the revisions kept come first; once one expires, older ones go
+			// along with it, as they may be stored as patches against it (see revisions.go)
+			cursor := noteHistory.Cursor()
+			kept, expiring := 0, false
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				var revision NoteRevision
+				if err := json.Unmarshal(v, &revision); err != nil {
+					return err
+				}
+				tooOld := policy.History > 0 && revision.SavedAt.Before(cutoff)
+				tooMany := policy.MaxRevisionsPerNote > 0 && kept >= policy.MaxRevisionsPerNote
+				if !expiring && ((live && kept == 0) || (!tooOld && !tooMany)) {
+					kept++
+					continue
+				}
+				expiring = true
+				expired = append(expired, expiredRecord{path: path, key: append([]byte(nil), k...)})
+			}
+			return nil
+		})
+	})
+	return expired, err
+}
+
+/**
+ * Access times older than the policy allows
+ */
+func expiredAccessLog(tx *bolt.Tx, policy RetentionPolicy, now time.Time) []expiredRecord {
+	accessBucket := tx.Bucket([]byte("Access"))
+	if policy.AccessLog <= 0 || accessBucket == nil {
+		return nil
+	}
+	cutoff := now.Add(-policy.AccessLog).UnixNano()
+
+	var expired []expiredRecord
+	accessBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookAccessBucket := accessBucket.Bucket(notebookKey)
+		if notebookAccessBucket == nil {
+			return nil
+		}
+		path := [][]byte{[]byte("Access"), append([]byte(nil), notebookKey...)}
+		return notebookAccessBucket.ForEach(func(k, v []byte) error {
+			if len(v) == 8 && int64(binary.BigEndian.Uint64(v)) < cutoff {
+				expired = append(expired, expiredRecord{path: path, key: append([]byte(nil), k...)})
+			}
+			return nil
+		})
+	})
+	return expired
+}
+
+/**
+ * Removes records in write transactions of retentionBatch records each
+ * Records gone in the meantime are skipped
+ * return: (int, error) Number of records removed
+ */
+func (db *DB) removeRecords(records []expiredRecord) (int, error) {
+	removed := 0
+	for start := 0; start < len(records); start += retentionBatch {
+		end := start + retentionBatch
+		if end > len(records) {
+			end = len(records)
+		}
+		batchRemoved := 0
+		err := db.Update(func(tx *bolt.Tx) error {
+			batchRemoved = 0
+			for _, record := range records[start:end] {
+				bucket := tx.Bucket(record.path[0])
+				for _, name := range record.path[1:] {
+					if bucket == nil {
+						break
+					}
+					bucket = bucket.Bucket(name)
+				}
+				if bucket == nil || bucket.Get(record.key) == nil {
+					continue
+				}
+				if err := bucket.Delete(record.key); err != nil {
+					return err
+				}
+				batchRemoved++
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += batchRemoved
+	}
+	return removed, nil
+}
+
+/**
+ * Whether a retention policy prunes anything
+ */
+func (p RetentionPolicy) prunes() bool {
+	return p.Changelog > 0 || p.History > 0 || p.AccessLog > 0 || p.MaxRevisionsPerNote > 0
+}
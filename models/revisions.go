@@ -0,0 +1,410 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Past revisions of a note are stored compactly: the newest revision of its history in full, and
+ * older ones as reverse diffs, patches turning content of the revision stored after them into theirs
+ *  - reconstructing a revision applies patches from the first revision after it stored in full, so
+ *    every revision numbered a multiple of the snapshot interval (see SetHistorySnapshotInterval)
+ *    is kept in full as well, bounding the number of patches applied
+ *  - a revision is kept in full whenever its patch wouldn't be smaller than its content
+ *  - patches are encrypted like content is (see encryption.go)
+ *  - histories written before revisions were stored as diffs are converted when the DB is opened
+ *    (histories of encrypted content once encryption is unlocked)
+ * Patches are line-based, one instruction a line: '=N' keeps the next N lines of the newer content,
+ * '-N' drops them, and '+text' adds a line
+ */
+
+/**
+ * Number of revisions between revisions kept in full, unless set otherwise
+ */
+const DefaultHistorySnapshotInterval = 20
+
+// returned when a stored patch doesn't apply to the revision after it
+var errBrokenPatch = errors.New("patch of revision doesn't apply to the revision after it")
+
+/**
+ * A revision as stored in 'History' bucket
+ */
+type storedRevision struct {
+	NoteRevision
+	// Content is a patch against the revision stored after this one (rather than its content)
+	Delta bool `json:"delta,omitempty"`
+}
+
+/**
+ * Writes archiving content as the newest revision of a note's history, prepared (within
+ * any transaction) by prepareRevision
+ */
+type revisionWrite struct {
+	encoded []byte
+	// previous newest revision as it was read, and rewritten as a patch against the new one
+	// (nil if it's left as it is)
+	previousKey     []byte
+	previous        []byte
+	encodedPrevious []byte
+}
+
+/**
+ * Sets the number of revisions between revisions kept in full (only revisions written from then
+ * on are affected); non-positive interval falls back to DefaultHistorySnapshotInterval
+ * param: int interval
+ * return: error
+ */
+func (db *DB) SetHistorySnapshotInterval(interval int) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.HistorySnapshotInterval = interval
+		return putSettings(tx, settings)
+	})
+	if err == nil {
+		db.historySnapshotInterval = interval
+	}
+	return err
+}
+
+/**
+ * Retrieves the number of revisions between revisions kept in full
+ * return: int
+ */
+func (db *DB) GetHistorySnapshotInterval() int {
+	return snapshotInterval(db.historySnapshotInterval)
+}
+
+func snapshotInterval(interval int) int {
+	if interval <= 0 {
+		return DefaultHistorySnapshotInterval
+	}
+	return interval
+}
+
+/**
+ * Prepares archiving (plaintext) content as the next revision of a note's history (which may be nil,
+ * for notes without history), turning the newest revision into a patch against it if it can be
+ */
+func prepareRevision(historyBucket *bolt.Bucket, revision NoteRevision, sealer contentSealer, interval int) (revisionWrite, error) {
+	var write revisionWrite
+	newest := revision.Content
+	var err error
+	if revision.Content, err = sealer.seal(newest); err != nil {
+		return write, err
+	}
+	if write.encoded, err = json.Marshal(storedRevision{NoteRevision: revision}); err != nil {
+		return write, err
+	}
+	if historyBucket == nil {
+		return write, nil
+	}
+	k, v := historyBucket.Cursor().Last()
+	if k == nil {
+		return write, nil
+	}
+	var previous storedRevision
+	if err := json.Unmarshal(v, &previous); err != nil {
+		return write, err
+	}
+	if previous.Delta || previous.Revision%uint64(snapshotInterval(interval)) == 0 {
+		return write, nil
+	}
+	if previous.Content, err = sealer.open(previous.Content); err != nil {
+		return write, err
+	}
+	patch := makePatch(newest, previous.Content)
+	if len(patch) >= len(previous.Content) {
+		return write, nil
+	}
+	if previous.Content, err = sealer.seal(patch); err != nil {
+		return write, err
+	}
+	previous.Delta = true
+	if write.encodedPrevious, err = json.Marshal(previous); err != nil {
+		return write, err
+	}
+	write.previousKey, write.previous = append([]byte(nil), k...), append([]byte(nil), v...)
+	return write, nil
+}
+
+/**
+ * Stores a prepared revision as the next one of a note's history
+ * Fails with errStalePrepare if the newest revision changed since it was prepared
+ */
+func (w revisionWrite) commit(historyBucket *bolt.Bucket) error {
+	if w.previousKey != nil {
+		if !bytes.Equal(historyBucket.Get(w.previousKey), w.previous) {
+			return errStalePrepare
+		}
+		if err := historyBucket.Put(w.previousKey, w.encodedPrevious); err != nil {
+			return err
+		}
+	}
+	revision, err := historyBucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	return historyBucket.Put(itob(revision), w.encoded)
+}
+
+/**
+ * Reconstructs all revisions of a note's history (oldest first)
+ */
+func readHistory(historyBucket *bolt.Bucket, sealer contentSealer) ([]NoteRevision, error) {
+	var reversed []NoteRevision
+	var newer *string
+	cursor := historyBucket.Cursor()
+	for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+		revision, err := readRevision(v, newer, sealer)
+		if err != nil {
+			return nil, err
+		}
+		reversed = append(reversed, revision)
+		newer = &reversed[len(reversed)-1].Content
+	}
+	revisions := make([]NoteRevision, len(reversed))
+	for i := range reversed {
+		revisions[i] = reversed[len(reversed)-1-i]
+	}
+	return revisions, nil
+}
+
+/**
+ * Reconstructs given revision of a note's history, applying patches from the first revision
+ * after it stored in full; false if there's no such revision
+ */
+func readHistoryRevision(historyBucket *bolt.Bucket, revision uint64, sealer contentSealer) (NoteRevision, bool, error) {
+	var records [][]byte
+	cursor := historyBucket.Cursor()
+	k, v := cursor.Seek(itob(revision))
+	if k == nil || !bytes.Equal(k, itob(revision)) {
+		return NoteRevision{}, false, nil
+	}
+	for ; k != nil; k, v = cursor.Next() {
+		records = append(records, v)
+		var stored storedRevision
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return NoteRevision{}, true, err
+		}
+		if !stored.Delta {
+			break
+		}
+	}
+	var read NoteRevision
+	var newer *string
+	for i := len(records) - 1; i >= 0; i-- {
+		var err error
+		if read, err = readRevision(records[i], newer, sealer); err != nil {
+			return read, true, err
+		}
+		newer = &read.Content
+	}
+	return read, true, nil
+}
+
+/**
+ * Decodes a stored revision, applying its patch (if it's one) to the content of the revision after it
+ */
+func readRevision(encoded []byte, newer *string, sealer contentSealer) (NoteRevision, error) {
+	var stored storedRevision
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return stored.NoteRevision, err
+	}
+	content, err := sealer.open(stored.Content)
+	if err != nil {
+		return stored.NoteRevision, err
+	}
+	if stored.Delta {
+		if newer == nil {
+			return stored.NoteRevision, fmt.Errorf("%w: revision %d has none after it", errBrokenPatch, stored.Revision)
+		}
+		if content, err = applyPatch(*newer, content); err != nil {
+			return stored.NoteRevision, fmt.Errorf("%w (revision %d)", err, stored.Revision)
+		}
+	}
+	stored.NoteRevision.Content = content
+	return stored.NoteRevision, nil
+}
+
+/**
+ * Encodes revisions (with plaintext content, oldest first) as a history to be stored
+ */
+func encodeHistory(revisions []NoteRevision, sealer contentSealer, interval int) ([][]byte, error) {
+	encoded := make([][]byte, len(revisions))
+	for i, revision := range revisions {
+		stored := storedRevision{NoteRevision: revision}
+		if i < len(revisions)-1 && revision.Revision%uint64(snapshotInterval(interval)) != 0 {
+			if patch := makePatch(revisions[i+1].Content, revision.Content); len(patch) < len(revision.Content) {
+				stored.Content, stored.Delta = patch, true
+			}
+		}
+		var err error
+		if stored.Content, err = sealer.seal(stored.Content); err != nil {
+			return nil, err
+		}
+		if encoded[i], err = json.Marshal(stored); err != nil {
+			return nil, err
+		}
+	}
+	return encoded, nil
+}
+
+/**
+ * Line-based patch turning newer text into older text
+ */
+func makePatch(newer, older string) string {
+	var instructions []string
+	var run DiffOp
+	count := 0
+	flush := func() {
+		if count > 0 {
+			if run == DiffContext {
+				instructions = append(instructions, "="+strconv.Itoa(count))
+			} else {
+				instructions = append(instructions, "-"+strconv.Itoa(count))
+			}
+		}
+		count = 0
+	}
+	for _, line := range diffLines(splitLines(newer), splitLines(older)) {
+		if line.Op == DiffAdded {
+			flush()
+			instructions = append(instructions, "+"+line.Text)
+			continue
+		}
+		if line.Op != run {
+			flush()
+			run = line.Op
+		}
+		count++
+	}
+	flush()
+	return strings.Join(instructions, "\n")
+}
+
+/**
+ * Applies a patch made by makePatch to the newer text it was made against
+ */
+func applyPatch(newer, patch string) (string, error) {
+	lines := splitLines(newer)
+	var applied []string
+	position := 0
+	for _, instruction := range splitLines(patch) {
+		if strings.HasPrefix(instruction, "+") {
+			applied = append(applied, instruction[1:])
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimLeft(instruction, "=-"))
+		if err != nil || count <= 0 || position+count > len(lines) {
+			return "", errBrokenPatch
+		}
+		if instruction[0] == '=' {
+			applied = append(applied, lines[position:position+count]...)
+		}
+		position += count
+	}
+	if position != len(lines) {
+		return "", errBrokenPatch
+	}
+	return strings.Join(applied, "\n"), nil
+}
+
+/**
+ * Converts histories stored in full into diffs, unless they all are already
+ */
+func convertStoredHistories(tx *bolt.Tx, sealer contentSealer) error {
+	settings, err := getSettings(tx)
+	if err != nil || settings.HistoryDiffs {
+		return err
+	}
+	complete, err := convertHistories(tx, sealer, settings.HistorySnapshotInterval)
+	if err != nil || !complete {
+		return err
+	}
+	settings.HistoryDiffs = true
+	return putSettings(tx, settings)
+}
+
+/**
+ * Converts histories stored in full (as written before revisions were stored as diffs) into diffs
+ *  - histories of encrypted content are left as they are without a key, and converted once unlocked
+ *    (encryption, or their notebook, see notebook_encryption.go)
+ * return: (bool, error) Whether all histories are converted
+ */
+func convertHistories(tx *bolt.Tx, sealer contentSealer, interval int) (bool, error) {
+	historyBucket := tx.Bucket([]byte("History"))
+	if historyBucket == nil {
+		return true, nil
+	}
+	complete := true
+	err := historyBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookHistoryBucket := historyBucket.Bucket(notebookKey)
+		if notebookHistoryBucket == nil {
+			return nil
+		}
+		return notebookHistoryBucket.ForEach(func(noteIdBytes, _ []byte) error {
+			noteHistory := notebookHistoryBucket.Bucket(noteIdBytes)
+			if noteHistory == nil {
+				return nil
+			}
+			converted, err := convertHistory(noteHistory, sealer.forNotebook(tx, notebookKey), interval)
+			if contentLocked(err) {
+				complete = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for k, encoded := range converted {
+				if err := noteHistory.Put([]byte(k), encoded); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return complete, err
+}
+
+/**
+ * Re-encodes the revisions of a note's history that are stored in full but can be patches
+ * (keyed by their keys); the newest revision, and revisions in patch form, are left as they are
+ */
+func convertHistory(historyBucket *bolt.Bucket, sealer contentSealer, interval int) (map[string][]byte, error) {
+	converted := make(map[string][]byte)
+	var newer *string
+	cursor := historyBucket.Cursor()
+	for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+		var stored storedRevision
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return nil, err
+		}
+		revision, err := readRevision(v, newer, sealer)
+		if err != nil {
+			return nil, err
+		}
+		if !stored.Delta && newer != nil && revision.Revision%uint64(snapshotInterval(interval)) != 0 {
+			if patch := makePatch(*newer, revision.Content); len(patch) < len(revision.Content) {
+				if stored.Content, err = sealer.seal(patch); err != nil {
+					return nil, err
+				}
+				stored.Delta = true
+				if converted[string(k)], err = json.Marshal(stored); err != nil {
+					return nil, err
+				}
+			}
+		}
+		newer = &revision.Content
+	}
+	return converted, nil
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Edits a note of 200 lines 100 times, a few lines at a time (adding, changing and dropping lines);
+ * returns the note and its content at every revision (revision i at index i-1, the current one last)
+ */
+func editedNote(t *testing.T, db *DB) (Note, []string) {
+	t.Helper()
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d of a long note, edited over and over", i)
+	}
+	contents := []string{strings.Join(lines, "\n")}
+	note := mustAddNote(t, db, "work", Note{Content: contents[0]})
+	for edit := 1; edit <= 100; edit++ {
+		lines[(edit*37)%len(lines)] = fmt.Sprintf("line changed by edit %d", edit)
+		switch edit % 3 {
+		case 0:
+			lines = append(lines, fmt.Sprintf("line added by edit %d", edit))
+		case 1:
+			at := (edit * 11) % len(lines)
+			lines = append(lines[:at], lines[at+1:]...)
+		}
+		content := strings.Join(lines, "\n")
+		if _, err := db.UpdateNote("work", note.Id, content); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	return note, contents
+}
+
+/**
+ * Checks every revision of a note, read with its history and one by one, against contents
+ */
+func checkRevisions(t *testing.T, db *DB, noteId uint64, contents []string) {
+	t.Helper()
+	history, err := db.GetNoteHistory("work", noteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(contents)-1 {
+		t.Fatalf("%d revisions in history, want %d", len(history), len(contents)-1)
+	}
+	for _, revision := range history {
+		if revision.Content != contents[revision.Revision-1] {
+			t.Fatalf("revision %d of history reconstructed wrong", revision.Revision)
+		}
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		for i, want := range contents {
+			content, err := db.revisionContent(tx, "work", noteId, uint64(i+1))
+			if err != nil {
+				return err
+			}
+			if content != want {
+				return fmt.Errorf("revision %d reconstructed wrong", i+1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/**
+ * Bytes a note's history takes in 'History' bucket, and how many of its revisions are patches
+ */
+func storedHistorySize(t *testing.T, db *DB, noteId uint64) (int, int) {
+	t.Helper()
+	size, patches := 0, 0
+	err := db.View(func(tx *bolt.Tx) error {
+		return noteHistoryBucket(tx, db.notebookKey("work"), noteId).ForEach(func(k, v []byte) error {
+			var stored storedRevision
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			size += len(v)
+			if stored.Delta {
+				patches++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size, patches
+}
+
+func TestHistoryOfHundredEdits(t *testing.T) {
+	for _, interval := range []int{DefaultHistorySnapshotInterval, 7, 1} {
+		t.Run(fmt.Sprintf("snapshots every %d", interval), func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.SetHistorySnapshotInterval(interval); err != nil {
+				t.Fatal(err)
+			}
+			note, contents := editedNote(t, db)
+			checkRevisions(t, db, note.Id, contents)
+
+			full := 0
+			for _, content := range contents[:len(contents)-1] {
+				full += len(content)
+			}
+			size, patches := storedHistorySize(t, db, note.Id)
+			t.Logf("history of 100 revisions: %d bytes stored, %d bytes of full copies (%.1f%%), %d patches",
+				size, full, 100*float64(size)/float64(full), patches)
+			// every revision but the newest and those numbered a multiple of the interval is a patch
+			if wantPatches := 99 - 99/interval; patches != wantPatches {
+				t.Errorf("%d revisions stored as patches, want %d", patches, wantPatches)
+			}
+			if interval == DefaultHistorySnapshotInterval && size > full/4 {
+				t.Errorf("history takes %d bytes, more than a quarter of the %d bytes of full copies", size, full)
+			}
+
+			// restoring an old revision gives back its content, and archives the current one
+			restored, err := db.RestoreRevision("work", note.Id, 42)
+			if err != nil || restored.Content != contents[41] {
+				t.Fatalf("revision 42 restored as %d bytes (%v)", len(restored.Content), err)
+			}
+			checkRevisions(t, db, note.Id, append(contents, contents[41]))
+		})
+	}
+}
+
+func TestConvertFullCopyHistory(t *testing.T) {
+	db := newTestDB(t)
+	note, contents := editedNote(t, db)
+	diffSize, _ := storedHistorySize(t, db, note.Id)
+
+	// rewrite the history the way it was stored before revisions were patches
+	err := db.Update(func(tx *bolt.Tx) error {
+		historyBucket := noteHistoryBucket(tx, db.notebookKey("work"), note.Id)
+		revisions, err := readHistory(historyBucket, db.sealer())
+		if err != nil {
+			return err
+		}
+		for _, revision := range revisions {
+			encoded, err := json.Marshal(storedRevision{NoteRevision: revision})
+			if err != nil {
+				return err
+			}
+			if err := historyBucket.Put(itob(revision.Revision), encoded); err != nil {
+				return err
+			}
+		}
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.HistoryDiffs = false
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, patches := storedHistorySize(t, db, note.Id); patches != 0 || size <= diffSize {
+		t.Fatalf("full copies take %d bytes with %d patches", size, patches)
+	}
+	checkRevisions(t, db, note.Id, contents)
+
+	if err := db.Update(func(tx *bolt.Tx) error { return convertStoredHistories(tx, db.sealer()) }); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := storedHistorySize(t, db, note.Id); size != diffSize {
+		t.Errorf("converted history takes %d bytes, want the %d bytes of one written as patches", size, diffSize)
+	}
+	checkRevisions(t, db, note.Id, contents)
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Daily rollups sum up, in a note of their own, the notes created or updated on a day
+ *  - a rollup lists the notes by notebook, one bullet per note with its title (or the beginning of
+ *    its content) and a '[[notebook/id]]' link to it
+ *  - there's one rollup per day and target notebook: generating it again updates its note (which
+ *    keeps history of earlier rollups, like any update)
+ *  - rollup notes are tagged 'rollup', and are left out of rollups themselves
+ * 'Rollups' bucket: notebook key -> day ('2006-01-02') -> JSON DailyRollup
+ */
+
+/**
+ * Tag of rollup notes
+ */
+const RollupTag = "rollup"
+
+/**
+ * Longest title of a note listed in a rollup, in characters
+ */
+const rollupTitleLength = 80
+
+/**
+ * Returned by GetDailyRollup for days with no rollup
+ */
+var ErrRollupNotFound = errors.New("rollup not found")
+
+/**
+ * Metadata of a daily rollup
+ *  - Sources is the number of notebooks looked at, and Notes the number of notes listed
+ */
+type DailyRollup struct {
+	Day         string    `json:"day"`
+	NoteId      uint64    `json:"note_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Sources     int       `json:"sources"`
+	Notes       int       `json:"notes"`
+}
+
+/**
+ * Generates the rollup of a day into the target notebook, updating the day's rollup if there's one
+ *  - the day is that of given time, in its location
+ *  - notes created or updated that day in the source notebooks (all notebooks but archived ones if
+ *    none are given) are listed, in order of sources and then of ids; expired notes are left out
+ * Fails with ErrNotebookArchived if the target notebook is archived
+ * param: string    targetNotebook
+ * param: time.Time day
+ * param: []string  sources
+ * return: (Note, error) The rollup note
+ */
+func (db *DB) GenerateDailyRollup(targetNotebook string, day time.Time, sources []string) (Note, error) {
+	var rollupNote Note
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	to := from.AddDate(0, 0, 1)
+	dayKey := from.Format("2006-01-02")
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := db.checkNotArchived(tx, targetNotebook); err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			sources = notebookNamesInTx(tx, false)
+		}
+		rollup := DailyRollup{Day: dayKey, GeneratedAt: time.Now(), Sources: len(sources)}
+		var content strings.Builder
+		for _, source := range sources {
+			var section strings.Builder
+			err := db.forEachMatchingNote(tx, db.notebookKey(source), newNoteFilter(nil), func(note Note) error {
+				if !changedBetween(note, from, to) || hasTag(note, RollupTag) {
+					return nil
+				}
+				rollup.Notes++
+				fmt.Fprintf(&section, "- %s [[%s]]\n", rollupTitle(note), NoteRef{Notebook: source, Id: note.Id})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if section.Len() > 0 {
+				fmt.Fprintf(&content, "\n## %s\n\n%s", source, section.String())
+			}
+		}
+		if rollup.Notes == 0 {
+			content.WriteString("\nNo notes were created or updated.\n")
+		}
+		text := strings.TrimPrefix(content.String(), "\n")
+
+		notebookKey := db.notebookKey(targetNotebook)
+		rollups, err := createNotebookRollupsBucket(tx, notebookKey)
+		if err != nil {
+			return err
+		}
+		var previous DailyRollup
+		if encoded := rollups.Get([]byte(dayKey)); encoded != nil {
+			if err := json.Unmarshal(encoded, &previous); err != nil {
+				return err
+			}
+		}
+		_, existing, err := db.getNoteInTx(tx, targetNotebook, previous.NoteId)
+		switch {
+		case err == nil:
+			rollupNote = existing
+			if existing.Content != text {
+				if rollupNote, err = db.updateNoteInTx(tx, targetNotebook, previous.NoteId, text, true); err != nil {
+					return err
+				}
+			}
+		case errors.Is(err, ErrNotebookNotFound), errors.Is(err, ErrNoteNotFound):
+			// no rollup yet (or its note was deleted)
+			batch := preparedAdd{notebookName: targetNotebook, defaults: getNotebookMeta(tx, notebookKey).Defaults, notes: []Note{{
+				TitleText: "Rollup of " + dayKey, Content: text, Tags: []string{RollupTag}, Kind: KindMarkdown,
+			}}}
+			if batch.prepared, err = prepareNotes(batch.notes, batch.defaults, db.encodingFor(tx, db.notebookKey(targetNotebook))); err != nil {
+				return err
+			}
+			added, err := db.commitAdd(tx, batch)
+			if err != nil {
+				return err
+			}
+			rollupNote = added[0]
+		default:
+			return err
+		}
+
+		rollup.NoteId = rollupNote.Id
+		encoded, err := json.Marshal(rollup)
+		if err != nil {
+			return err
+		}
+		return rollups.Put([]byte(dayKey), encoded)
+	})
+	return rollupNote, err
+}
+
+/**
+ * Retrieves metadata of the rollup of a day in the target notebook
+ * Fails with ErrRollupNotFound if there's none
+ * param: string    targetNotebook
+ * param: time.Time day
+ * return: (DailyRollup, error)
+ */
+func (db *DB) GetDailyRollup(targetNotebook string, day time.Time) (DailyRollup, error) {
+	var rollup DailyRollup
+	dayKey := day.Format("2006-01-02")
+	err := db.View(func(tx *bolt.Tx) error {
+		rollups := notebookRollupsBucket(tx, db.notebookKey(targetNotebook))
+		if rollups == nil || rollups.Get([]byte(dayKey)) == nil {
+			return fmt.Errorf("%w: %s in notebook '%s'", ErrRollupNotFound, dayKey, targetNotebook)
+		}
+		return json.Unmarshal(rollups.Get([]byte(dayKey)), &rollup)
+	})
+	return rollup, err
+}
+
+/**
+ * Whether a note was created or updated within [from, to)
+ */
+func changedBetween(note Note, from, to time.Time) bool {
+	for _, t := range []time.Time{note.CreatedAt, note.UpdatedAt} {
+		if !t.IsZero() && !t.Before(from) && t.Before(to) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(note Note, tag string) bool {
+	for _, noteTag := range note.Tags {
+		if noteTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Title a note is listed under in rollups: its own, or one inferred from its content
+ */
+func rollupTitle(note Note) string {
+	title := note.TitleText
+	if title == "" {
+		title = InferTitle(DisplayContent(note), rollupTitleLength)
+	}
+	if title == "" {
+		return "(empty note)"
+	}
+	return title
+}
+
+/**
+ * Retrieves (2nd order) rollups bucket of a notebook; nil if it has no rollups
+ */
+func notebookRollupsBucket(tx *bolt.Tx, notebookKey []byte) *bolt.Bucket {
+	rootBucket := tx.Bucket([]byte("Rollups"))
+	if rootBucket == nil {
+		return nil
+	}
+	return rootBucket.Bucket(notebookKey)
+}
+
+func createNotebookRollupsBucket(tx *bolt.Tx, notebookKey []byte) (*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte("Rollups"))
+	if err != nil {
+		return nil, err
+	}
+	return rootBucket.CreateBucketIfNotExists(notebookKey)
+}
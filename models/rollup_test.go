@@ -0,0 +1,77 @@
+package models_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestDailyRollup(t *testing.T) {
+	db := notestest.NewDB(t)
+	day := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+	at := func(days int, hour, minute int) time.Time {
+		return day.AddDate(0, 0, days).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	for _, fixture := range []struct {
+		notebook string
+		note     models.Note
+	}{
+		{"work", models.Note{Content: "Plan the offsite\nvenue, dates", CreatedAt: at(0, 9, 0)}},
+		{"work", models.Note{Content: "From the day before", CreatedAt: at(-1, 23, 59)}},
+		{"work", models.Note{Content: "Revised on the day\nsecond draft", CreatedAt: at(-3, 12, 0), UpdatedAt: at(0, 10, 30)}},
+		{"work", models.Note{TitleText: "Standup", Content: "- shipped the rollups", CreatedAt: at(0, 9, 30)}},
+		{"work", models.Note{Content: strings.Repeat("a long first line ", 10), CreatedAt: at(0, 17, 0)}},
+		{"home", models.Note{Content: "Groceries\nmilk, eggs", CreatedAt: at(0, 23, 59)}},
+		{"home", models.Note{Content: "The day after", CreatedAt: at(1, 0, 0)}},
+		{"ideas", models.Note{Content: "Not a source", CreatedAt: at(0, 12, 0)}},
+	} {
+		notestest.MustAddNote(t, db, fixture.notebook, fixture.note)
+	}
+
+	rollup, err := db.GenerateDailyRollup("journal", day.Add(15*time.Hour), []string{"work", "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notestest.AssertGolden(t, []byte(rollup.Content), "testdata/rollup-2024-05-01.golden")
+	if rollup.TitleText != "Rollup of 2024-05-01" || len(rollup.Tags) != 1 || rollup.Tags[0] != models.RollupTag {
+		t.Errorf("rollup note titled %q, tagged %v", rollup.TitleText, rollup.Tags)
+	}
+	meta, err := db.GetDailyRollup("journal", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.NoteId != rollup.Id || meta.Sources != 2 || meta.Notes != 5 || meta.GeneratedAt.IsZero() {
+		t.Errorf("rollup metadata %+v, want note %d of 5 notes from 2 sources", meta, rollup.Id)
+	}
+
+	// generating it again updates the same note rather than adding one
+	notestest.MustAddNote(t, db, "home", models.Note{Content: "Added later that day", CreatedAt: at(0, 20, 0)})
+	again, err := db.GenerateDailyRollup("journal", day, []string{"work", "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != rollup.Id || !strings.Contains(again.Content, "- Added later that day [[home/3]]") {
+		t.Errorf("rollup generated again as note %d:\n%s", again.Id, again.Content)
+	}
+	notes, err := db.ListNotes("journal")
+	if err != nil || len(notes) != 1 {
+		t.Errorf("%d notes in the target notebook (%v), want the one rollup", len(notes), err)
+	}
+
+	// rollups are left out of rollups of all notebooks
+	all, err := db.GenerateDailyRollup("journal", day, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Id != rollup.Id || strings.Contains(all.Content, "## journal") || !strings.Contains(all.Content, "## ideas") {
+		t.Errorf("rollup of all notebooks:\n%s", all.Content)
+	}
+
+	if _, err := db.GetDailyRollup("journal", day.AddDate(0, 0, 1)); !errors.Is(err, models.ErrRollupNotFound) {
+		t.Errorf("rollup of a day that has none: %v, want ErrRollupNotFound", err)
+	}
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Handle on the DB acting on behalf of a user, enforcing notebooks' access control
+ *  - notebooks created through the handle are owned by the user
+ *  - reads need AccessRead, writes need AccessWrite, and granting access needs ownership
+ *    (denied operations fail with a ForbiddenError); notebooks the user can't read
+ *    are invisible when listing or searching across notebooks
+ *  - notebooks created through the DB itself have no owner, so they're accessible
+ *    to users only once access has been granted with DB.GrantAccess
+ * Checks happen in the same transaction as the operation itself
+ */
+type ScopedDB struct {
+	db   *DB
+	user string
+}
+
+/**
+ * Returns a handle acting on behalf of given user
+ * param: string user
+ * return: *ScopedDB
+ */
+func (db *DB) As(user string) *ScopedDB {
+	return &ScopedDB{db: db, user: user}
+}
+
+/**
+ * Returns the user the handle acts on behalf of
+ */
+func (s *ScopedDB) User() string {
+	return s.user
+}
+
+/**
+ * Returns the level of access the user has to a notebook
+ */
+func (s *ScopedDB) AccessLevel(notebookName string) (AccessLevel, error) {
+	var level AccessLevel
+	err := s.db.View(func(tx *bolt.Tx) error {
+		level = accessLevel(tx, s.db.notebookKey(notebookName), s.user)
+		return nil
+	})
+	return level, err
+}
+
+/**
+ * Retrieves names of notebooks the user can read
+ */
+func (s *ScopedDB) GetAllNotebookNames() ([]string, error) {
+	var notebookNames []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		notebookNames = s.readableNotebooks(tx)
+		return nil
+	})
+	return notebookNames, err
+}
+
+func (s *ScopedDB) GetNote(notebookName string, noteId uint64) (Note, error) {
+	var note Note
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessRead); err != nil {
+			return err
+		}
+		var err error
+		note, err = s.db.getNoteView(tx, notebookName, noteId)
+		return err
+	})
+	if err == nil && note.Id != 0 {
+		s.db.recordAccess(notebookName, note.Id)
+	}
+	return note, err
+}
+
+func (s *ScopedDB) ListNotes(notebookName string, opts ...ListOption) ([]Note, error) {
+	return s.db.listNotes(notebookName, func(tx *bolt.Tx) error {
+		return s.check(tx, notebookName, AccessRead)
+	}, opts...)
+}
+
+func (s *ScopedDB) SearchNotes(notebookName string, query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessRead); err != nil {
+			return err
+		}
+		var err error
+		results, err = s.db.searchNotesInTx(tx, notebookName, query, newSearchOptions(opts))
+		return err
+	})
+	return results, err
+}
+
+/**
+ * Searches notebooks the user can read (see DB.SearchAllNotebooks)
+ */
+func (s *ScopedDB) SearchAllNotebooks(query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, notebookName := range s.readableNotebooks(tx) {
+			notebookResults, err := s.db.searchNotesInTx(tx, notebookName, query, newSearchOptions(opts))
+			if err != nil {
+				return err
+			}
+			results = append(results, notebookResults...)
+		}
+		sortResults(results)
+		return nil
+	})
+	return results, err
+}
+
+func (s *ScopedDB) AddNotes(notebookName string, noteContents ...string) error {
+	var notes []Note
+	for _, noteContent := range noteContents {
+		notes = append(notes, Note{Content: noteContent})
+	}
+	_, err := s.addNotes(notebookName, notes)
+	return err
+}
+
+func (s *ScopedDB) AddNote(notebookName string, note Note) (Note, error) {
+	added, err := s.addNotes(notebookName, []Note{note})
+	if err != nil {
+		return note, err
+	}
+	return added[0], nil
+}
+
+func (s *ScopedDB) UpdateNote(notebookName string, noteId uint64, content string) (Note, error) {
+	var note Note
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessWrite); err != nil {
+			return err
+		}
+		var err error
+		note, err = s.db.updateNoteInTx(tx, notebookName, noteId, content, false)
+		return err
+	})
+	return note, err
+}
+
+func (s *ScopedDB) DeleteNotes(notebookName string, noteIds ...uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessWrite); err != nil {
+			return err
+		}
+		_, err := s.db.deleteNotesInTx(tx, notebookName, noteIds, writeOptions{})
+		return err
+	})
+}
+
+/**
+ * Grants another user access to a notebook owned by the user (see DB.GrantAccess)
+ */
+func (s *ScopedDB) GrantAccess(notebookName string, user string, level AccessLevel) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessOwner); err != nil {
+			return err
+		}
+		return s.db.grantAccessInTx(tx, notebookName, user, level)
+	})
+}
+
+/**
+ * Adds notes, creating the notebook (owned by the user) if it doesn't exist
+ */
+func (s *ScopedDB) addNotes(notebookName string, notes []Note) ([]Note, error) {
+	batch, err := s.db.prepareAdd(notebookName, notes)
+	if err != nil {
+		return nil, err
+	}
+	batch.owner = s.user
+	var added []Note
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.check(tx, notebookName, AccessWrite); err != nil {
+			return err
+		}
+		var err error
+		added, err = s.db.commitAdd(tx, batch)
+		return err
+	})
+	return added, err
+}
+
+/**
+ * Fails with a ForbiddenError unless the user has the required access to an existing notebook
+ * (a notebook that doesn't exist yet passes, as there's nothing to protect)
+ */
+func (s *ScopedDB) check(tx *bolt.Tx, notebookName string, required AccessLevel) error {
+	notebookKey := s.db.notebookKey(notebookName)
+	if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+		return nil
+	}
+	if accessLevel(tx, notebookKey, s.user) < required {
+		return &ForbiddenError{Notebook: notebookName, User: s.user, Required: required}
+	}
+	return nil
+}
+
+/**
+ * Display names of notebooks the user can read
+ */
+func (s *ScopedDB) readableNotebooks(tx *bolt.Tx) []string {
+	var notebookNames []string
+	cursor := tx.Bucket([]byte("Notebook")).Cursor()
+	for notebookKey, _ := cursor.First(); notebookKey != nil; notebookKey, _ = cursor.Next() {
+		if accessLevel(tx, notebookKey, s.user) >= AccessRead {
+			notebookNames = append(notebookNames, notebookDisplayName(tx, notebookKey))
+		}
+	}
+	return notebookNames
+}
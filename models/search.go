@@ -0,0 +1,379 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Search ranks notes by where query terms are found
+ *  - notes have no separate title: a note's title is its first line, if it's followed by more
+ *    lines or is a markdown heading (leading '#'s aside); one-liners are all content
+ *  - every term scores the weight of the best place it's found in: the whole title, part of the
+ *    title, a tag, or elsewhere in the content (see the weights below)
+ *  - a query is split into terms on whitespace; notes must contain every term, and score the sum
+ *    of their terms' scores (so matching more terms in better places ranks higher)
+ *  - results are sorted by score, more recently updated notes first among equal scores
+ *  - JSON notes (being machine-written) are left out unless IncludeJSON() is passed, and binary-ish
+ *    notes when SkipBinaryNotes() is
+ *  - searches across notebooks leave out archived notebooks unless IncludeArchivedNotebooks() is passed, and
+ *    read notebooks in parallel (see fanout.go)
+ */
+const (
+	exactTitleWeight = 8
+	titleWeight      = 4
+	tagWeight        = 2
+	contentWeight    = 1
+)
+
+/**
+ * Option of SearchNotes and SearchAllNotebooks
+ */
+type SearchOption func(*searchOptions)
+
+type searchOptions struct {
+	minScore        float64
+	includeJSON     bool
+	includeArchived bool
+	skipBinary      bool
+	skippedBinary   *int
+	// stops the search once cancelled (set by SearchAllNotebooksContext)
+	ctx context.Context
+}
+
+/**
+ * Only results scoring at least given score (like 4, for notes having every term in their title)
+ */
+func MinScore(score float64) SearchOption {
+	return func(opts *searchOptions) {
+		opts.minScore = score
+	}
+}
+
+/**
+ * Includes notes of KindJSON, left out of search by default
+ */
+func IncludeJSON() SearchOption {
+	return func(opts *searchOptions) {
+		opts.includeJSON = true
+	}
+}
+
+/**
+ * Leaves out binary-ish notes (see content_class.go), counting the ones that would have matched
+ * into skipped (if not nil)
+ */
+func SkipBinaryNotes(skipped *int) SearchOption {
+	return func(opts *searchOptions) {
+		opts.skipBinary, opts.skippedBinary = true, skipped
+	}
+}
+
+func newSearchOptions(opts []SearchOption) searchOptions {
+	var options searchOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+/**
+ * Finds notes of a notebook whose content or tags contain all terms of the query (case-insensitively),
+ * ranked by score (see above)
+ *  - expired notes are left out
+ * param: string          notebookName
+ * param: string          query
+ * param: ...SearchOption opts
+ * return: ([]SearchResult, error)
+ */
+func (db *DB) SearchNotes(notebookName string, query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		results, err = db.searchNotesInTx(tx, notebookName, query, newSearchOptions(opts))
+		return err
+	})
+	return results, err
+}
+
+/**
+ * Finds notes across all notebooks whose content or tags contain all terms of the query
+ * (see SearchNotes)
+ * param: string          query
+ * param: ...SearchOption opts
+ * return: ([]SearchResult, error)
+ */
+func (db *DB) SearchAllNotebooks(query string, opts ...SearchOption) ([]SearchResult, error) {
+	return db.SearchAllNotebooksContext(context.Background(), query, opts...)
+}
+
+/**
+ * Same as SearchAllNotebooks, notebooks being searched by several workers at once (see fanout.go)
+ * until ctx is cancelled, failing with ctx's error then
+ * param: context.Context ctx
+ * param: string          query
+ * param: ...SearchOption opts
+ * return: ([]SearchResult, error)
+ */
+func (db *DB) SearchAllNotebooksContext(ctx context.Context, query string, opts ...SearchOption) ([]SearchResult, error) {
+	options := newSearchOptions(opts)
+	options.ctx = ctx
+	var notebookNames []string
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookNames = notebookNamesInTx(tx, options.includeArchived)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// (results and counts are kept by notebook, so that workers share nothing)
+	found := make([][]SearchResult, len(notebookNames))
+	skipped := make([]int, len(notebookNames))
+	err = db.fanOutNotebooks(ctx, notebookNames, func(tx *bolt.Tx, i int, notebookName string) error {
+		if db.notebookLocked(tx, db.notebookKey(notebookName)) {
+			// (notebooks encrypted on their own are left out until unlocked, see notebook_encryption.go)
+			return nil
+		}
+		notebookOptions := options
+		if options.skippedBinary != nil {
+			notebookOptions.skippedBinary = &skipped[i]
+		}
+		return db.searchEachInTx(tx, notebookName, query, notebookOptions, func(result SearchResult) error {
+			found[i] = append(found[i], result)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for i := range notebookNames {
+		results = append(results, found[i]...)
+		if options.skippedBinary != nil {
+			*options.skippedBinary += skipped[i]
+		}
+	}
+	sortResults(results)
+	return results, nil
+}
+
+/**
+ * Visits notes whose content or tags contain all terms of the query as they are found, without
+ * ranking them: notebook by notebook (all notebooks if notebookName is empty), in order of ids
+ *  - results carry their score, and options apply as in SearchNotes
+ *  - fn is called within a read transaction, so it mustn't write to the DB; an error returned
+ *    by it stops the search, and is returned
+ * Unlike SearchNotes, results aren't collected first, so callers can use them as the search goes on
+ * param: string                   notebookName
+ * param: string                   query
+ * param: func(SearchResult) error fn
+ * param: ...SearchOption          opts
+ * return: error
+ */
+func (db *DB) SearchEach(notebookName string, query string, fn func(SearchResult) error, opts ...SearchOption) error {
+	options := newSearchOptions(opts)
+	return db.View(func(tx *bolt.Tx) error {
+		notebookNames := []string{notebookName}
+		if notebookName == "" {
+			notebookNames = notebookNamesInTx(tx, options.includeArchived)
+		}
+		for _, name := range notebookNames {
+			if notebookName == "" && db.notebookLocked(tx, db.notebookKey(name)) {
+				// (notebooks encrypted on their own are left out until unlocked, see notebook_encryption.go)
+				continue
+			}
+			if err := db.searchEachInTx(tx, name, query, options, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Visits notes matching the query as SearchEach does, until fn returns false: the search stops
+ * right there, without reading further notes, and its read transaction is released
+ *  - meant for searches wanting the first few hits (like a page of 10) of notebooks too large to
+ *    search through; an error returned by fn stops the search as well, and is returned
+ * param: string                          notebookName
+ * param: string                          query
+ * param: func(SearchResult) (bool, error) fn
+ * param: ...SearchOption                 opts
+ * return: error
+ */
+func (db *DB) SearchStream(notebookName string, query string, fn func(SearchResult) (more bool, err error), opts ...SearchOption) error {
+	err := db.SearchEach(notebookName, query, func(result SearchResult) error {
+		more, err := fn(result)
+		if err == nil && !more {
+			return errSearchStopped
+		}
+		return err
+	}, opts...)
+	if err == errSearchStopped {
+		return nil
+	}
+	return err
+}
+
+// returned to SearchEach by SearchStream, once its callback wants no more results
+var errSearchStopped = errors.New("search stopped")
+
+/**
+ * Core logic of SearchNotes, shared with Snapshot
+ */
+func (db *DB) searchNotesInTx(tx *bolt.Tx, notebookName string, query string, options searchOptions) ([]SearchResult, error) {
+	var results []SearchResult
+	err := db.searchEachInTx(tx, notebookName, query, options, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortResults(results)
+	return results, nil
+}
+
+/**
+ * Calls fn for every note of a notebook matching the query, in order of ids
+ */
+func (db *DB) searchEachInTx(tx *bolt.Tx, notebookName string, query string, options searchOptions, fn func(SearchResult) error) error {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+	if err := db.checkSearchable(); err != nil {
+		return err
+	}
+	notebookKey := db.notebookKey(notebookName)
+	displayName := notebookDisplayName(tx, notebookKey)
+	return db.forEachMatchingNote(tx, notebookKey, NoteFilter{}, func(note Note) error {
+		if options.ctx != nil && options.ctx.Err() != nil {
+			return options.ctx.Err()
+		}
+		if note.Kind == KindJSON && !options.includeJSON {
+			return nil
+		}
+		score := searchScore(note, terms)
+		if score == 0 || score < options.minScore {
+			return nil
+		}
+		if options.skipBinary && ClassifyContent(note.Content) == ContentBinary {
+			if options.skippedBinary != nil {
+				*options.skippedBinary++
+			}
+			return nil
+		}
+		return fn(SearchResult{Ref: NoteRef{Notebook: displayName, Id: note.Id}, Note: note, Score: score})
+	})
+}
+
+/**
+ * Searches all notebooks in a single transaction, for Snapshot (whose reads must all see the same state)
+ */
+func (db *DB) searchAllNotebooksInTx(tx *bolt.Tx, query string, options searchOptions) ([]SearchResult, error) {
+	var results []SearchResult
+	for _, notebookName := range notebookNamesInTx(tx, options.includeArchived) {
+		if db.notebookLocked(tx, db.notebookKey(notebookName)) {
+			// (notebooks encrypted on their own are left out until unlocked, see notebook_encryption.go)
+			continue
+		}
+		notebookResults, err := db.searchNotesInTx(tx, notebookName, query, options)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, notebookResults...)
+	}
+	sortResults(results)
+	return results, nil
+}
+
+/**
+ * Score of a note for given (lower-cased) terms; zero if some term isn't found
+ */
+func searchScore(note Note, terms []string) float64 {
+	content := strings.ToLower(note.Content)
+	title, hasTitle := noteTitle(content)
+	var score float64
+	for _, term := range terms {
+		var termScore float64
+		switch {
+		case hasTitle && title == term:
+			termScore = exactTitleWeight
+		case hasTitle && strings.Contains(title, term):
+			termScore = titleWeight
+		case tagsContain(note.Tags, term):
+			termScore = tagWeight
+		case strings.Contains(content, term):
+			termScore = contentWeight
+		default:
+			return 0
+		}
+		score += termScore
+	}
+	return score
+}
+
+func tagsContain(tags []string, term string) bool {
+	for _, tag := range tags {
+		if strings.Contains(strings.ToLower(tag), term) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Title of a note for display: its own title if it has one, otherwise its first line,
+ * markdown heading marks aside (unlike in ranking, one-liners are their own title)
+ */
+func (n Note) Title() string {
+	if n.TitleText != "" {
+		return n.TitleText
+	}
+	firstLine := n.Content
+	if i := strings.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+	return strings.TrimSpace(strings.TrimLeft(firstLine, "#"))
+}
+
+/**
+ * Title of a note's content (see above), if it has one
+ */
+func noteTitle(content string) (string, bool) {
+	firstLine := content
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		firstLine = content[:i]
+	}
+	heading := strings.HasPrefix(firstLine, "#")
+	if firstLine == content && !heading {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimLeft(firstLine, "#")), true
+}
+
+/**
+ * Sorts results by score, then by update time (most recent first), then by ref for stability
+ */
+func sortResults(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if updatedA, updatedB := updatedAt(a.Note), updatedAt(b.Note); !updatedA.Equal(updatedB) {
+			return updatedA.After(updatedB)
+		}
+		if a.Ref.Notebook != b.Ref.Notebook {
+			return a.Ref.Notebook < b.Ref.Notebook
+		}
+		return a.Ref.Id < b.Ref.Id
+	})
+}
@@ -0,0 +1,192 @@
+package models_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Notes of the ranking corpus, created a minute apart in this order (ids 1..)
+ */
+var rankingCorpus = []models.Note{
+	{Content: "Budget\nnumbers for q3"},                  // 1: 'budget' is the whole title
+	{Content: "# Budget review\nwith the team"},          // 2: in the title
+	{Content: "meeting notes", Tags: []string{"budget"}}, // 3: in a tag
+	{Content: "the budget is tight"},                     // 4: in content only (one-liners have no title)
+	{Content: "Budget plans\nfor next year"},             // 5: in the title, like 2 but newer
+	{Content: "groceries\nmilk"},                         // 6: not at all
+	{Content: "Review\nthe budget, later"},               // 7: 'review' is the whole title, 'budget' in content
+	{Content: `{"budget": 1}`, Kind: models.KindJSON},    // 8: JSON, left out by default
+}
+
+func seedRankingCorpus(t *testing.T) *models.DB {
+	t.Helper()
+	db := notestest.NewDB(t)
+	for i, note := range rankingCorpus {
+		note.CreatedAt = notestest.DefaultStart.Add(time.Duration(i) * time.Minute)
+		notestest.MustAddNote(t, db, "corpus", note)
+	}
+	return db
+}
+
+func TestSearchRanking(t *testing.T) {
+	db := seedRankingCorpus(t)
+	for _, test := range []struct {
+		name   string
+		query  string
+		opts   []models.SearchOption
+		ids    []uint64
+		scores []float64
+	}{
+		// exact title > title > tag > content; the newer of equal scores first
+		{"single term", "budget", nil, []uint64{1, 5, 2, 3, 7, 4}, []float64{8, 4, 4, 2, 1, 1}},
+		{"case-insensitively", "BUDGET", nil, []uint64{1, 5, 2, 3, 7, 4}, []float64{8, 4, 4, 2, 1, 1}},
+		// scores of terms add up, and notes must have every term
+		{"several terms", "budget review", nil, []uint64{7, 2}, []float64{9, 8}},
+		{"min score", "budget", []models.SearchOption{models.MinScore(4)}, []uint64{1, 5, 2}, []float64{8, 4, 4}},
+		{"with JSON notes", "budget", []models.SearchOption{models.IncludeJSON()}, []uint64{1, 5, 2, 3, 8, 7, 4}, []float64{8, 4, 4, 2, 1, 1, 1}},
+		{"no match", "budget groceries", nil, nil, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := db.SearchNotes("corpus", test.query, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint64
+			var scores []float64
+			for _, result := range results {
+				ids = append(ids, result.Ref.Id)
+				scores = append(scores, result.Score)
+			}
+			if !reflect.DeepEqual(ids, test.ids) || !reflect.DeepEqual(scores, test.scores) {
+				t.Errorf("results = %v scoring %v, want %v scoring %v", ids, scores, test.ids, test.scores)
+			}
+		})
+	}
+}
+
+func TestSearchRankingFollowsUpdates(t *testing.T) {
+	db := seedRankingCorpus(t)
+	// updating the older of two equal scores makes it the more recent one
+	if _, err := db.UpdateNote("corpus", 2, "# Budget review\nwith the whole team"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := db.SearchNotes("corpus", "budget", models.MinScore(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for _, result := range results {
+		ids = append(ids, result.Ref.Id)
+	}
+	if want := []uint64{1, 2, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("results = %v, want %v", ids, want)
+	}
+}
+
+func TestSearchAllNotebooksRanking(t *testing.T) {
+	db := seedRankingCorpus(t)
+	notestest.MustAddNote(t, db, "other", models.Note{Content: "budget", Tags: []string{"budget"}})
+	results, err := db.SearchAllNotebooks("budget", models.MinScore(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []models.NoteRef
+	for _, result := range results {
+		refs = append(refs, result.Ref)
+	}
+	// (a one-liner has no title: the other notebook's note scores as its tag does, and is the newest of those)
+	want := []models.NoteRef{{Notebook: "corpus", Id: 1}, {Notebook: "corpus", Id: 5}, {Notebook: "corpus", Id: 2},
+		{Notebook: "other", Id: 1}, {Notebook: "corpus", Id: 3}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("results = %v, want %v", refs, want)
+	}
+}
+
+func TestSearchStreamStopsAtLimit(t *testing.T) {
+	db := notestest.NewDB(t)
+	const notes = 10000
+	contents := make(chan string, 100)
+	go func() {
+		for i := 1; i <= notes; i++ {
+			// every 10th note is a hit
+			if i%10 == 0 {
+				contents <- fmt.Sprintf("Note %d\nwith a needle", i)
+			} else {
+				contents <- fmt.Sprintf("Note %d\nwith hay", i)
+			}
+		}
+		close(contents)
+	}()
+	if _, err := db.BulkLoad("big", contents, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// notes read by the search's transaction are counted by slow-op tracing
+	db.SetSlowOpThreshold(time.Nanosecond)
+	defer db.SetSlowOpThreshold(0)
+	var ids []uint64
+	err := db.SearchStream("big", "needle", func(result models.SearchResult) (bool, error) {
+		ids = append(ids, result.Ref.Id)
+		return len(ids) < 10, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// notes are searched in order of ids (not of their keys, ids as text: 1, 10, 100, 1000, 10000, 1001 ..)
+	if want := []uint64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("streamed hits %v, want %v", ids, want)
+	}
+	if len(ids) != 10 {
+		t.Fatalf("streamed %d hits, want 10", len(ids))
+	}
+	ops := db.SlowOps(1)
+	if len(ops) != 1 {
+		t.Fatal("search wasn't traced")
+	}
+	// the search stopped at the 10th hit rather than reading all notes
+	if want := int64(100); ops[0].NotesRead != want {
+		t.Errorf("search read %d notes, want the %d up to the 10th hit", ops[0].NotesRead, want)
+	}
+
+	// as does one failing in its callback
+	failed := errors.New("failed")
+	calls := 0
+	err = db.SearchStream("big", "needle", func(models.SearchResult) (bool, error) {
+		calls++
+		return true, failed
+	})
+	if err != failed || calls != 1 {
+		t.Errorf("search failing in its first callback: %v after %d calls", err, calls)
+	}
+	if ops := db.SlowOps(1); len(ops) != 1 || ops[0].NotesRead != 10 {
+		t.Errorf("failed search read %+v, want the 10 notes up to the first hit", ops)
+	}
+}
+
+func TestLimitedSearchOfAllNotebooks(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"a": {"needle 1", "needle 2", "needle 3", "needle 4"},
+		"b": {"needle 5", "hay"},
+		"c": {"hay", "needle 6"},
+	}})
+	var found []string
+	err := db.SearchStream("", "needle", func(result models.SearchResult) (bool, error) {
+		found = append(found, result.Ref.String())
+		return len(found) < 5, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// notebooks are searched in order, so a limited search stops in the middle of one
+	if want := []string{"a/1", "a/2", "a/3", "a/4", "b/1"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+}
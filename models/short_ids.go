@@ -0,0 +1,252 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Short ids are a display-layer name of notes, easier to type than 'notebook id' pairs: the note id
+ * in base36, prefixed by an abbreviation of its notebook (like 'wk-3f' for note 135 of 'work')
+ *  - abbreviations are recorded in notebook metadata ('NotebookMeta' bucket), assigned as notebooks
+ *    are created (and on open, to notebooks created before short ids existed)
+ *  - they are derived from notebook names, collisions being resolved deterministically by trying
+ *    other letters of the name, and then numbered suffixes ('wk', 'wr', .. 'wk2')
+ *  - an abbreviation stays with its notebook for good (moving along with its metadata when its key
+ *    changes), and is only taken while the notebook's bucket exists
+ */
+
+var (
+	// returned by ParseShortID for text that isn't a short id
+	ErrInvalidShortID = errors.New("invalid short id")
+	// returned by ResolveShortID for abbreviations of no notebook
+	ErrUnknownAbbreviation = errors.New("unknown notebook abbreviation")
+	// returned by ResolveShortID for abbreviations that are a prefix of several notebooks' ones
+	ErrAmbiguousAbbreviation = errors.New("ambiguous notebook abbreviation")
+)
+
+// abbreviation, '-' and base36 note id
+var shortIDPattern = regexp.MustCompile(`^([a-z][a-z0-9]*)-([0-9a-z]+)$`)
+
+/**
+ * Formats short id of a note
+ * param: string notebookAbbrev
+ * param: uint64 noteId
+ * return: string
+ */
+func ShortID(notebookAbbrev string, noteId uint64) string {
+	return notebookAbbrev + "-" + strconv.FormatUint(noteId, 36)
+}
+
+/**
+ * Splits a short id (case-insensitively) into notebook abbreviation and note id
+ * Fails with ErrInvalidShortID if it isn't one
+ * param: string s
+ * return: (string, uint64, error)
+ */
+func ParseShortID(s string) (notebookAbbrev string, id uint64, err error) {
+	match := shortIDPattern.FindStringSubmatch(strings.ToLower(s))
+	if match == nil {
+		return "", 0, fmt.Errorf("%w: '%s' (like 'wk-3f')", ErrInvalidShortID, s)
+	}
+	if id, err = strconv.ParseUint(match[2], 36, 64); err != nil || id == 0 {
+		return "", 0, fmt.Errorf("%w: '%s' (note id out of range)", ErrInvalidShortID, s)
+	}
+	return match[1], id, nil
+}
+
+/**
+ * Resolves a short id into the note it stands for
+ *  - an abbreviation matches its notebook exactly, or else the one notebook whose abbreviation it is a prefix of
+ *  - the note itself isn't looked up: it's up to the caller to find it missing
+ * Fails with ErrUnknownAbbreviation / ErrAmbiguousAbbreviation (listing candidates) if the abbreviation
+ * doesn't point to a single notebook
+ * param: string shortID
+ * return: (NoteRef, error)
+ */
+func (db *DB) ResolveShortID(shortID string) (NoteRef, error) {
+	abbrev, id, err := ParseShortID(shortID)
+	if err != nil {
+		return NoteRef{}, err
+	}
+	var ref NoteRef
+	err = db.View(func(tx *bolt.Tx) error {
+		abbrevs := notebookAbbreviations(tx)
+		var matching []string
+		for candidate := range abbrevs {
+			if candidate == abbrev {
+				ref = NoteRef{Notebook: abbrevs[candidate], Id: id}
+				return nil
+			}
+			if strings.HasPrefix(candidate, abbrev) {
+				matching = append(matching, candidate)
+			}
+		}
+		switch len(matching) {
+		case 1:
+			ref = NoteRef{Notebook: abbrevs[matching[0]], Id: id}
+			return nil
+		case 0:
+			// notebooks whose abbreviations start alike are likeliest to have been meant (any of them otherwise)
+			for candidate := range abbrevs {
+				if candidate[0] == abbrev[0] {
+					matching = append(matching, candidate)
+				}
+			}
+			if len(matching) == 0 {
+				for candidate := range abbrevs {
+					matching = append(matching, candidate)
+				}
+			}
+			if len(matching) == 0 {
+				return fmt.Errorf("%w: '%s' (there are no notebooks)", ErrUnknownAbbreviation, abbrev)
+			}
+			return fmt.Errorf("%w: '%s' (did you mean %s?)", ErrUnknownAbbreviation, abbrev, describeAbbreviations(abbrevs, matching))
+		default:
+			return fmt.Errorf("%w: '%s' could be %s", ErrAmbiguousAbbreviation, abbrev, describeAbbreviations(abbrevs, matching))
+		}
+	})
+	return ref, err
+}
+
+/**
+ * Formats short id of a note (whether the note exists or not)
+ * Fails with ErrNotebookNotFound if its notebook doesn't exist
+ * param: NoteRef ref
+ * return: (string, error)
+ */
+func (db *DB) NoteShortID(ref NoteRef) (string, error) {
+	var shortID string
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(ref.Notebook)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, ref.Notebook)
+		}
+		shortID = ShortID(getNotebookMeta(tx, notebookKey).Abbrev, ref.Id)
+		return nil
+	})
+	return shortID, err
+}
+
+/**
+ * Abbreviations of existing notebooks, mapped to their display names
+ */
+func notebookAbbreviations(tx *bolt.Tx) map[string]string {
+	abbrevs := make(map[string]string)
+	cursor := tx.Bucket([]byte("Notebook")).Cursor()
+	for notebookKey, _ := cursor.First(); notebookKey != nil; notebookKey, _ = cursor.Next() {
+		if meta := getNotebookMeta(tx, notebookKey); meta.Abbrev != "" {
+			abbrevs[meta.Abbrev] = notebookDisplayName(tx, notebookKey)
+		}
+	}
+	return abbrevs
+}
+
+/**
+ * Lists abbreviations along with their notebooks, like "wk (work), wr (writing)"
+ */
+func describeAbbreviations(abbrevs map[string]string, candidates []string) string {
+	sort.Strings(candidates)
+	var described []string
+	for _, candidate := range candidates {
+		described = append(described, fmt.Sprintf("%s (%s)", candidate, abbrevs[candidate]))
+	}
+	return strings.Join(described, ", ")
+}
+
+/**
+ * Picks abbreviation of a notebook: the first of its candidates not taken by another notebook
+ */
+func assignAbbreviation(tx *bolt.Tx, notebookKey []byte, notebookName string) string {
+	taken := make(map[string]bool)
+	cursor := tx.Bucket([]byte("Notebook")).Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		if string(key) != string(notebookKey) {
+			taken[getNotebookMeta(tx, key).Abbrev] = true
+		}
+	}
+	for _, candidate := range abbreviationCandidates(notebookName) {
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+	base := abbreviationCandidates(notebookName)[0]
+	for n := 2; ; n++ {
+		if candidate := base + strconv.Itoa(n); !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+/**
+ * Two-letter abbreviations of a name, preferred first
+ *  - its first letter along with its last consonant ('work' -> 'wk'), then with each of its
+ *    other consonants, and then with each of its other letters or digits
+ *  - single-letter names are abbreviated as they are, and names with no letters 'nb'
+ */
+func abbreviationCandidates(name string) []string {
+	var chars []rune
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9' && len(chars) > 0) {
+			chars = append(chars, r)
+		}
+	}
+	if len(chars) == 0 {
+		chars = []rune("nb")
+	}
+	first, rest := chars[0], chars[1:]
+	if len(rest) == 0 {
+		return []string{string(first)}
+	}
+	isConsonant := func(r rune) bool { return r >= 'a' && r <= 'z' && !strings.ContainsRune("aeiou", r) }
+
+	var candidates []string
+	seen := make(map[string]bool)
+	add := func(r rune) {
+		if candidate := string([]rune{first, r}); !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	for i := len(rest) - 1; i >= 0; i-- {
+		if isConsonant(rest[i]) {
+			add(rest[i])
+			break
+		}
+	}
+	for _, r := range rest {
+		if isConsonant(r) {
+			add(r)
+		}
+	}
+	for _, r := range rest {
+		add(r)
+	}
+	return candidates
+}
+
+/**
+ * Assigns abbreviations to notebooks created before short ids existed (in order of their keys, so
+ * that every copy of a DB gets the same ones)
+ */
+func assignMissingAbbreviations(tx *bolt.Tx) error {
+	var missing [][]byte
+	cursor := tx.Bucket([]byte("Notebook")).Cursor()
+	for notebookKey, _ := cursor.First(); notebookKey != nil; notebookKey, _ = cursor.Next() {
+		if getNotebookMeta(tx, notebookKey).Abbrev == "" {
+			missing = append(missing, append([]byte(nil), notebookKey...))
+		}
+	}
+	for _, notebookKey := range missing {
+		if err := ensureNotebookMeta(tx, notebookKey, notebookDisplayName(tx, notebookKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Slow-op log: transactions taking longer than a threshold (see SetSlowOpThreshold) are recorded, along
+ * with the operation (public method) they ran for and the notebook and notes they touched
+ *  - operations are timed a transaction at a time: one made of several transactions (like UpdateNote,
+ *    reading before it writes) gets an entry for each of them that is slow; transactions held open by
+ *    callers (readers of content and attachments, snapshots) aren't timed
+ *  - entries go into a ring of the last slowOpCapacity ones kept in memory (see SlowOps), written without
+ *    locks; with PersistSlowOps, they're also stored in 'SlowLog' bucket (the last slowLogCapacity of them,
+ *    see PersistedSlowOps), so that they outlive the process
+ *  - with no threshold (the default), nothing is timed, and transactions aren't tracked at all
+ * 'SlowLog' bucket: start time (unix nanoseconds, 8 bytes) and sequence number (8 bytes) -> JSON of a SlowOp
+ */
+
+/**
+ * Number of slow operations kept in memory
+ */
+const slowOpCapacity = 256
+
+/**
+ * Number of slow operations kept in 'SlowLog' bucket
+ */
+const slowLogCapacity = 1000
+
+/**
+ * A transaction that took longer than the slow-op threshold
+ *  - Notebook is empty if it touched no notes, or notes of several notebooks
+ *  - NotesRead / NotesWritten count notes decoded and notes stored or deleted (a note read and then
+ *    written counts as both)
+ */
+type SlowOp struct {
+	Operation    string        `json:"operation"`
+	Notebook     string        `json:"notebook,omitempty"`
+	Write        bool          `json:"write"`
+	Duration     time.Duration `json:"duration"`
+	NotesRead    int64         `json:"notes_read"`
+	NotesWritten int64         `json:"notes_written"`
+	At           time.Time     `json:"at"`
+}
+
+/**
+ * Number of operations on a notebook's notes recorded in the changelog (see TopNotebooksByOps)
+ */
+type NotebookOpCount struct {
+	Notebook string `json:"notebook"`
+	Ops      int    `json:"ops"`
+	Notes    int    `json:"notes"`
+}
+
+/**
+ * Slow-op recording state of a DB
+ */
+type slowOpLog struct {
+	// threshold in nanoseconds (0 when disabled), and whether entries are persisted (0 / 1)
+	threshold int64
+	persist   int32
+	// number of entries ever recorded; the next one goes into slots[next % slowOpCapacity]
+	next  uint64
+	slots [slowOpCapacity]atomic.Value
+}
+
+/**
+ * What a running transaction touched, tracked while slow ops are recorded
+ */
+type txTrace struct {
+	notesRead    int64
+	notesWritten int64
+	// key of the first notebook touched, and whether others were touched too (0 / 1)
+	notebookKey atomic.Value
+	several     int32
+}
+
+var (
+	// traces of running transactions (of any DB), and the number of them (so that nothing is
+	// looked up while no DB records slow ops)
+	txTraces      sync.Map
+	runningTraces int32
+)
+
+// public methods of this package's types, as named in stack frames
+var publicMethodPattern = regexp.MustCompile(`^` + regexp.QuoteMeta(reflect.TypeOf(DB{}).PkgPath()) + `\.\(\*(\w+)\)\.([A-Z]\w*)$`)
+
+/**
+ * Sets the duration above which transactions are recorded in the slow-op log; zero (or less) disables it
+ */
+func (db *DB) SetSlowOpThreshold(threshold time.Duration) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	atomic.StoreInt64(&db.slowOps.threshold, int64(threshold))
+}
+
+/**
+ * Sets whether slow operations are stored in 'SlowLog' bucket, besides being kept in memory
+ */
+func (db *DB) PersistSlowOps(enabled bool) {
+	var persist int32
+	if enabled {
+		persist = 1
+	}
+	atomic.StoreInt32(&db.slowOps.persist, persist)
+}
+
+/**
+ * Retrieves the slow operations recorded in memory, newest first
+ * param: int limit Maximum number of operations retrieved (all of them if not positive)
+ * return: []SlowOp
+ */
+func (db *DB) SlowOps(limit int) []SlowOp {
+	ops := []SlowOp{}
+	next := atomic.LoadUint64(&db.slowOps.next)
+	for i := next; i > 0 && next-i < slowOpCapacity && (limit <= 0 || len(ops) < limit); i-- {
+		if op, ok := db.slowOps.slots[(i-1)%slowOpCapacity].Load().(SlowOp); ok {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+/**
+ * Retrieves the slow operations stored in 'SlowLog' bucket (see PersistSlowOps), newest first
+ * param: int limit Maximum number of operations retrieved (all of them if not positive)
+ * return: ([]SlowOp, error)
+ */
+func (db *DB) PersistedSlowOps(limit int) ([]SlowOp, error) {
+	ops := []SlowOp{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("SlowLog"))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && (limit <= 0 || len(ops) < limit); k, v = cursor.Prev() {
+			var op SlowOp
+			if err := json.Unmarshal(v, &op); err != nil {
+				return err
+			}
+			ops = append(ops, op)
+		}
+		return nil
+	})
+	return ops, err
+}
+
+/**
+ * Ranks notebooks by the number of operations on their notes recorded in the changelog (the undo log,
+ * see undo.go) within a window of time, busiest first (ties by number of notes, then by name)
+ *  - the changelog records destructive operations (deletes, moves, restores ..), and only as many of
+ *    them as it retains (see SetUndoLimit and the retention policy)
+ * param: time.Duration window
+ * return: ([]NotebookOpCount, error)
+ */
+func (db *DB) TopNotebooksByOps(window time.Duration) ([]NotebookOpCount, error) {
+	counts := make(map[string]*NotebookOpCount)
+	since := time.Now().Add(-window)
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("UndoLog"))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var entry UndoEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.CreatedAt.Before(since) {
+				// (entries are in order of time)
+				break
+			}
+			count, ok := counts[entry.Notebook]
+			if !ok {
+				count = &NotebookOpCount{Notebook: entry.Notebook}
+				counts[entry.Notebook] = count
+			}
+			count.Ops++
+			count.Notes += len(entry.Notes)
+		}
+		return nil
+	})
+	ranked := []NotebookOpCount{}
+	for _, count := range counts {
+		ranked = append(ranked, *count)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Ops != ranked[j].Ops {
+			return ranked[i].Ops > ranked[j].Ops
+		}
+		if ranked[i].Notes != ranked[j].Notes {
+			return ranked[i].Notes > ranked[j].Notes
+		}
+		return ranked[i].Notebook < ranked[j].Notebook
+	})
+	return ranked, err
+}
+
+/**
+ * Runs a transaction (with bolt's View or Update), recording it in the slow-op log if it's slow
+ */
+func (db *DB) traced(run func(func(*bolt.Tx) error) error, write bool, fn func(*bolt.Tx) error) error {
+	if atomic.LoadInt64(&db.slowOps.threshold) <= 0 {
+		return run(fn)
+	}
+	start := time.Now()
+	finish := func() {}
+	err := run(func(tx *bolt.Tx) error {
+		finish = db.traceTx(tx, write, start)
+		return fn(tx)
+	})
+	finish()
+	return err
+}
+
+/**
+ * Tracks what a transaction begun at given time touches, for the slow-op log
+ * The func returned has to be called once the transaction is over: it records it if it was slow
+ */
+func (db *DB) traceTx(tx *bolt.Tx, write bool, start time.Time) func() {
+	threshold := time.Duration(atomic.LoadInt64(&db.slowOps.threshold))
+	if threshold <= 0 {
+		return func() {}
+	}
+	trace := &txTrace{}
+	atomic.AddInt32(&runningTraces, 1)
+	txTraces.Store(tx, trace)
+	return func() {
+		txTraces.Delete(tx)
+		atomic.AddInt32(&runningTraces, -1)
+		if took := time.Since(start); took >= threshold {
+			db.recordSlowOp(SlowOp{
+				Operation:    callingOperation(),
+				Write:        write,
+				Duration:     took,
+				NotesRead:    atomic.LoadInt64(&trace.notesRead),
+				NotesWritten: atomic.LoadInt64(&trace.notesWritten),
+				At:           start,
+			}, trace)
+		}
+	}
+}
+
+/**
+ * Counts notes of a notebook read (or written) by a transaction, if it's traced
+ */
+func traceNotes(tx *bolt.Tx, notebookKey []byte, read, written int64) {
+	if atomic.LoadInt32(&runningTraces) == 0 {
+		return
+	}
+	value, ok := txTraces.Load(tx)
+	if !ok {
+		return
+	}
+	trace := value.(*txTrace)
+	atomic.AddInt64(&trace.notesRead, read)
+	atomic.AddInt64(&trace.notesWritten, written)
+	if first, ok := trace.notebookKey.Load().([]byte); !ok {
+		trace.notebookKey.Store(append([]byte(nil), notebookKey...))
+	} else if !bytes.Equal(first, notebookKey) {
+		atomic.StoreInt32(&trace.several, 1)
+	}
+}
+
+/**
+ * Adds an operation to the ring (and to 'SlowLog' bucket, if persisted), naming the notebook it touched
+ */
+func (db *DB) recordSlowOp(op SlowOp, trace *txTrace) {
+	notebookKey, _ := trace.notebookKey.Load().([]byte)
+	if notebookKey != nil && atomic.LoadInt32(&trace.several) == 0 {
+		op.Notebook = string(notebookKey)
+		db.DB.View(func(tx *bolt.Tx) error {
+			op.Notebook = notebookDisplayName(tx, notebookKey)
+			return nil
+		})
+	}
+	slot := atomic.AddUint64(&db.slowOps.next, 1) - 1
+	db.slowOps.slots[slot%slowOpCapacity].Store(op)
+
+	if atomic.LoadInt32(&db.slowOps.persist) == 0 || db.enter() != nil {
+		return
+	}
+	// (stored apart from the caller, which may be running in another transaction)
+	go func() {
+		defer db.exit()
+		if err := persistSlowOp(db.DB, op); err != nil {
+			db.logf("slow operation could not be persisted: %v", err)
+		}
+	}()
+}
+
+func persistSlowOp(boltDB *bolt.DB, op SlowOp) error {
+	return boltDB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("SlowLog"))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		// (keyed by start time: operations are stored in whatever order they finish)
+		key := append(itob(uint64(op.At.UnixNano())), itob(seq)...)
+		if err := bucket.Put(key, encoded); err != nil {
+			return err
+		}
+		if seq > slowLogCapacity {
+			oldest, _ := bucket.Cursor().First()
+			return bucket.Delete(oldest)
+		}
+		return nil
+	})
+}
+
+/**
+ * Name of the outermost public method (like 'DB.ListNotes') on the calling goroutine's stack;
+ * 'unknown' if there's none
+ */
+func callingOperation() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	operation := "unknown"
+	for {
+		frame, more := frames.Next()
+		if match := publicMethodPattern.FindStringSubmatch(frame.Function); match != nil {
+			operation = strings.Join(match[1:], ".")
+		}
+		if !more {
+			return operation
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Returned by operations on a Snapshot after it has been released
+ */
+var ErrSnapshotReleased = errors.New("snapshot has been released")
+
+/**
+ * Point-in-time, read-only view of the DB
+ *  - backed by a long-lived bolt read transaction: writes committed after the
+ *    snapshot was taken are invisible to it
+ *  - CAVEAT: while a snapshot is held, bolt can't reclaim pages freed by later
+ *    writes, so the DB file keeps growing; and once it outgrows the initial memory map,
+ *    writes block until snapshots are released. Release snapshots as soon as possible
+ *  - db.Close() force-releases outstanding snapshots
+ */
+type Snapshot struct {
+	db *DB
+	// guards tx against being used during (or after) Release
+	mu sync.RWMutex
+	tx *bolt.Tx
+}
+
+/**
+ * Takes a point-in-time snapshot of the DB (see Snapshot)
+ * return: (*Snapshot, error)
+ */
+func (db *DB) Snapshot() (*Snapshot, error) {
+	// snapshot isn't tracked as an in-flight operation (Close force-releases it instead)
+	if db.Closed() {
+		return nil, ErrClosed
+	}
+	tx, err := db.DB.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{db: db, tx: tx}
+
+	db.snapshotsMu.Lock()
+	defer db.snapshotsMu.Unlock()
+	if db.snapshots == nil {
+		db.snapshots = make(map[*Snapshot]struct{})
+	}
+	db.snapshots[snapshot] = struct{}{}
+	return snapshot, nil
+}
+
+/**
+ * Releases the underlying read transaction; safe to call more than once
+ */
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return
+	}
+	s.tx.Rollback()
+	s.tx = nil
+
+	s.db.snapshotsMu.Lock()
+	delete(s.db.snapshots, s)
+	s.db.snapshotsMu.Unlock()
+}
+
+/**
+ * Runs fn with snapshot's transaction, failing if snapshot has been released
+ */
+func (s *Snapshot) view(fn func(tx *bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.tx == nil {
+		return ErrSnapshotReleased
+	}
+	return fn(s.tx)
+}
+
+/**
+ * Same as DB.GetNote, as of the time snapshot was taken
+ */
+func (s *Snapshot) GetNote(notebookName string, noteId uint64) (Note, error) {
+	var note Note
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		note, err = s.db.getNoteView(tx, notebookName, noteId)
+		return err
+	})
+	return note, err
+}
+
+/**
+ * Same as DB.ListNotes, as of the time snapshot was taken
+ */
+func (s *Snapshot) ListNotes(notebookName string, opts ...ListOption) ([]Note, error) {
+	var notes []Note
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		notes, err = s.db.listNotesInTx(tx, notebookName, opts...)
+		return err
+	})
+	return notes, err
+}
+
+/**
+ * Same as DB.SearchNotes, as of the time snapshot was taken
+ */
+func (s *Snapshot) SearchNotes(notebookName string, query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		results, err = s.db.searchNotesInTx(tx, notebookName, query, newSearchOptions(opts))
+		return err
+	})
+	return results, err
+}
+
+/**
+ * Same as DB.SearchAllNotebooks, as of the time snapshot was taken
+ */
+func (s *Snapshot) SearchAllNotebooks(query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		results, err = s.db.searchAllNotebooksInTx(tx, query, newSearchOptions(opts))
+		return err
+	})
+	return results, err
+}
+
+/**
+ * Same as DB.GetAllNotebooks (i.e. exports every notebook along with its notes),
+ * as of the time snapshot was taken
+ */
+func (s *Snapshot) GetAllNotebooks() ([]Notebook, error) {
+	var notebooks []Notebook
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Notebook"))
+		notebooks = s.db.getNotebooksInRootBucket(bucket.Cursor(), bucket, false)
+		return nil
+	})
+	return notebooks, err
+}
+
+/**
+ * Releases all outstanding snapshots
+ * (bolt would otherwise wait for their read transactions forever on Close)
+ */
+func (db *DB) releaseSnapshots() {
+	db.snapshotsMu.Lock()
+	var snapshots []*Snapshot
+	for snapshot := range db.snapshots {
+		snapshots = append(snapshots, snapshot)
+	}
+	db.snapshotsMu.Unlock()
+
+	for _, snapshot := range snapshots {
+		snapshot.Release()
+	}
+}
@@ -0,0 +1,96 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	db := notestest.NewDB(t)
+	ids := notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"work": {"quarterly report draft", "meeting notes"},
+	}})
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Release()
+
+	// writes committed after the snapshot was taken
+	if _, err := db.UpdateNote("work", ids["work"][0], "quarterly report final"); err != nil {
+		t.Fatal(err)
+	}
+	notestest.MustAdd(t, db, "work", "report follow-ups")
+	notestest.MustAdd(t, db, "home", "groceries")
+	if err := db.DeleteNotes("work", ids["work"][1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// are invisible to the snapshot
+	note, err := snapshot.GetNote("work", ids["work"][0])
+	if err != nil || note.Content != "quarterly report draft" {
+		t.Errorf("snapshot GetNote = %q, %v; want the content before the update", note.Content, err)
+	}
+	if notes, err := snapshot.ListNotes("work"); err != nil || len(notes) != 2 {
+		t.Errorf("snapshot ListNotes = %d notes, %v; want 2", len(notes), err)
+	}
+	if results, err := snapshot.SearchNotes("work", "report"); err != nil || len(results) != 1 {
+		t.Errorf("snapshot SearchNotes = %d results, %v; want 1", len(results), err)
+	}
+	if notebooks, err := snapshot.GetAllNotebooks(); err != nil || len(notebooks) != 1 {
+		t.Errorf("snapshot GetAllNotebooks = %d notebooks, %v; want 1", len(notebooks), err)
+	}
+
+	// but visible to fresh reads
+	if note, err := db.GetNote("work", ids["work"][0]); err != nil || note.Content != "quarterly report final" {
+		t.Errorf("GetNote = %q, %v; want the updated content", note.Content, err)
+	}
+	if notes, err := db.ListNotes("work"); err != nil || len(notes) != 2 {
+		t.Errorf("ListNotes = %d notes, %v; want 2", len(notes), err)
+	}
+	if results, err := db.SearchNotes("work", "report"); err != nil || len(results) != 2 {
+		t.Errorf("SearchNotes = %d results, %v; want 2", len(results), err)
+	}
+	if names, err := db.GetAllNotebookNames(); err != nil || len(names) != 2 {
+		t.Errorf("GetAllNotebookNames = %v, %v; want 2 notebooks", names, err)
+	}
+}
+
+func TestSnapshotRelease(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "content")
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Release()
+	snapshot.Release()
+	if _, err := snapshot.GetNote("work", note.Id); !errors.Is(err, models.ErrSnapshotReleased) {
+		t.Errorf("GetNote after Release: %v, want ErrSnapshotReleased", err)
+	}
+}
+
+func TestCloseReleasesSnapshots(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "content")
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Close would hang on the snapshot's read transaction if it weren't released
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.GetNote("work", note.Id); !errors.Is(err, models.ErrSnapshotReleased) {
+		t.Errorf("GetNote after Close: %v, want ErrSnapshotReleased", err)
+	}
+	if _, err := db.Snapshot(); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("Snapshot after Close: %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,311 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * A note can be split into several (see SplitNote), by one of three strategies
+ *  - by delimiter: lines made of the delimiter alone (like "---") separate parts, and are dropped
+ *  - by heading: every markdown heading of the level (or a higher one, like "# " for level 2) starts a part,
+ *    titled by the heading; what comes before the first heading is a part of its own, and headings within
+ *    code fences don't count
+ *  - by size: parts are cut at line boundaries so that none exceeds the size (lines longer than that
+ *    being cut themselves)
+ * Parts made only of blank lines are skipped, and parts are trimmed of leading and trailing blank lines
+ *  - new notes are added to the note's notebook in order, with its kind and tags (and SplitFromTagPrefix+id
+ *    if asked for), along with the original being trashed if asked for, all in one transaction
+ *  - there's no separate trash: trashed originals are deleted, stashed for Undo like any deleted note
+ */
+
+/**
+ * Prefix of the tag marking notes split from another, followed by its id (like "split-from:12")
+ */
+const SplitFromTagPrefix = "split-from:"
+
+/**
+ * What becomes of a split note
+ */
+type SplitOriginal string
+
+const (
+	// the note is left as it is (the default)
+	SplitKeepOriginal SplitOriginal = "keep"
+	// the note is deleted, stashed for Undo
+	SplitTrashOriginal SplitOriginal = "trash"
+)
+
+var (
+	// returned by SplitNote for options without exactly one strategy (or with an unknown SplitOriginal)
+	ErrInvalidSplit = errors.New("invalid split")
+	// returned by SplitNote for notes that don't split into several parts, nothing being written
+	ErrNothingToSplit = errors.New("nothing to split")
+)
+
+/**
+ * Options of SplitNote: exactly one of Delimiter, HeadingLevel (1 to 6) and MaxSize (bytes) sets the strategy
+ *  - MarkOrigin tags new notes SplitFromTagPrefix + id of the note split
+ *  - Original tells what becomes of the note split (SplitKeepOriginal if empty)
+ *  - DryRun only tells what the new notes would be
+ */
+type SplitOptions struct {
+	Delimiter    string
+	HeadingLevel int
+	MaxSize      int
+	MarkOrigin   bool
+	Original     SplitOriginal
+	DryRun       bool
+}
+
+/**
+ * Splits a note into new notes of its notebook (see above)
+ * Fails with ErrInvalidSplit for invalid options, with ErrNothingToSplit if the note would be split into
+ * fewer than two parts, and with ErrNoteReadOnly for notes locked read-only to be trashed
+ * param: string       notebookName
+ * param: uint64       noteId
+ * param: SplitOptions opts
+ * return: ([]Note, error) The new notes as stored, in order; as they would be (without ids) with DryRun
+ */
+func (db *DB) SplitNote(notebookName string, noteId uint64, opts SplitOptions) ([]Note, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	// split and marshal outside of the write transaction, retrying if the note changed in between
+	for attempt := 0; attempt < maxPrepareAttempts; attempt++ {
+		var original Note
+		err := db.View(func(tx *bolt.Tx) error {
+			var err error
+			_, original, err = db.getNoteInTx(tx, notebookName, noteId)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts, err := splitParts(notebookName, original, opts)
+		if err != nil || opts.DryRun {
+			return parts, err
+		}
+		batch, err := db.prepareAdd(notebookName, parts)
+		if err != nil {
+			return nil, err
+		}
+		var added []Note
+		err = db.Update(func(tx *bolt.Tx) error {
+			var err error
+			added, err = db.splitNoteInTx(tx, notebookName, noteId, original.Revision, &batch, opts)
+			return err
+		})
+		if err != errStalePrepare {
+			return added, err
+		}
+	}
+
+	// kept losing the race: split within the write transaction instead
+	var added []Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		added, err = db.splitNoteInTx(tx, notebookName, noteId, 0, nil, opts)
+		return err
+	})
+	return added, err
+}
+
+/**
+ * Adds the parts of a note and trashes it if asked for, within given write transaction
+ * With a batch prepared from revision of the note, fails with errStalePrepare if the note changed since;
+ * without one, the note is split here
+ */
+func (db *DB) splitNoteInTx(tx *bolt.Tx, notebookName string, noteId uint64, revision uint64, batch *preparedAdd, opts SplitOptions) ([]Note, error) {
+	_, current, err := db.getNoteInTx(tx, notebookName, noteId)
+	if err != nil {
+		return nil, err
+	}
+	if batch != nil && current.Revision != revision {
+		return nil, errStalePrepare
+	}
+	if opts.Original == SplitTrashOriginal {
+		if err := checkWritable(notebookName, current, false); err != nil {
+			return nil, err
+		}
+	}
+	if batch == nil {
+		parts, err := splitParts(notebookName, current, opts)
+		if err != nil {
+			return nil, err
+		}
+		batch = &preparedAdd{notebookName: notebookName, notes: parts, defaults: getNotebookMeta(tx, db.notebookKey(notebookName)).Defaults}
+		if batch.prepared, err = prepareNotes(parts, batch.defaults, db.encodingFor(tx, db.notebookKey(notebookName))); err != nil {
+			return nil, err
+		}
+	}
+
+	added, err := db.commitAdd(tx, *batch)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Original == SplitTrashOriginal {
+		if _, err := db.deleteNotesInTx(tx, notebookName, []uint64{noteId}, writeOptions{confirmed: true}); err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+func (opts SplitOptions) validate() error {
+	strategies := 0
+	if opts.Delimiter != "" {
+		strategies++
+	}
+	if opts.HeadingLevel != 0 {
+		strategies++
+		if opts.HeadingLevel < 1 || opts.HeadingLevel > 6 {
+			return fmt.Errorf("%w: heading level %d (1 to 6)", ErrInvalidSplit, opts.HeadingLevel)
+		}
+	}
+	if opts.MaxSize != 0 {
+		strategies++
+		if opts.MaxSize < 0 {
+			return fmt.Errorf("%w: size %d", ErrInvalidSplit, opts.MaxSize)
+		}
+	}
+	if strategies != 1 {
+		return fmt.Errorf("%w: needs exactly one of delimiter, heading level and size", ErrInvalidSplit)
+	}
+	switch opts.Original {
+	case "", SplitKeepOriginal, SplitTrashOriginal:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown original '%s' (keep or trash)", ErrInvalidSplit, opts.Original)
+}
+
+/**
+ * New notes a note splits into (see above)
+ * Fails with ErrNothingToSplit if there are fewer than two of them
+ */
+func splitParts(notebookName string, original Note, opts SplitOptions) ([]Note, error) {
+	var titles, contents []string
+	switch {
+	case opts.Delimiter != "":
+		contents = splitByDelimiter(original.Content, opts.Delimiter)
+		titles = make([]string, len(contents))
+	case opts.HeadingLevel != 0:
+		titles, contents = splitByHeading(original.Content, opts.HeadingLevel)
+	default:
+		contents = splitBySize(original.Content, opts.MaxSize)
+		titles = make([]string, len(contents))
+	}
+
+	tags := append([]string(nil), original.Tags...)
+	if marker := SplitFromTagPrefix + strconv.FormatUint(original.Id, 10); opts.MarkOrigin && !containsString(tags, marker) {
+		tags = append(tags, marker)
+	}
+	var parts []Note
+	for i, content := range contents {
+		content = trimBlankLines(content)
+		body := content
+		if titles[i] != "" {
+			// (a heading with nothing under it makes a blank part too)
+			body = ""
+			if nl := strings.IndexByte(content, '\n'); nl >= 0 {
+				body = content[nl+1:]
+			}
+		}
+		if trimBlankLines(body) != "" {
+			parts = append(parts, Note{Content: content, TitleText: titles[i], Tags: tags, Kind: original.Kind})
+		}
+	}
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%w: note %d in notebook '%s' makes %d part(s)", ErrNothingToSplit, original.Id, notebookName, len(parts))
+	}
+	return parts, nil
+}
+
+func splitByDelimiter(content string, delimiter string) []string {
+	delimiter = strings.TrimSpace(delimiter)
+	var parts []string
+	var part strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == delimiter {
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		part.WriteString(line + "\n")
+	}
+	return append(parts, part.String())
+}
+
+/**
+ * Sections of markdown content starting at headings of given level or higher, along with their titles
+ * (empty for what precedes the first heading)
+ */
+func splitByHeading(content string, level int) ([]string, []string) {
+	titles, parts := []string{""}, []string{""}
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), markdownCodeFences) {
+			inFence = !inFence
+		}
+		if !inFence {
+			if m := markdownHeading.FindStringSubmatch(line); m != nil && len(m[1]) <= level {
+				titles, parts = append(titles, m[2]), append(parts, "")
+			}
+		}
+		parts[len(parts)-1] += line + "\n"
+	}
+	return titles, parts
+}
+
+/**
+ * Content cut at line boundaries into parts of at most maxSize bytes (lines longer than that being cut
+ * at character boundaries)
+ */
+func splitBySize(content string, maxSize int) []string {
+	var parts []string
+	var part strings.Builder
+	for _, line := range strings.SplitAfter(content, "\n") {
+		for len(line) > maxSize {
+			if part.Len() > 0 {
+				parts = append(parts, part.String())
+				part.Reset()
+			}
+			cut := maxSize
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				// a single character larger than maxSize
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+		}
+		if part.Len()+len(line) > maxSize {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(line)
+	}
+	return append(parts, part.String())
+}
+
+/**
+ * Text without its leading and trailing blank lines (nor its final line break); empty if it's all blank
+ */
+func trimBlankLines(text string) string {
+	lines := strings.Split(text, "\n")
+	start, end := 0, len(lines)
+	for start < end && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return strings.Join(lines[start:end], "\n")
+}
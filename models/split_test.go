@@ -0,0 +1,197 @@
+package models_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+// a guide with headings of several levels, one of them in a code fence and one with nothing under it
+const nestedHeadings = `Read this first.
+
+# Guide
+## Install
+steps
+### Linux
+apt install notes
+## Use
+` + "```" + `
+## not a heading, but a comment
+` + "```" + `
+run notes
+## Empty
+
+# Appendix
+more`
+
+/**
+ * Titles and contents of notes
+ */
+func titlesAndContents(notes []models.Note) ([]string, []string) {
+	var titles, contents []string
+	for _, note := range notes {
+		titles, contents = append(titles, note.Title()), append(contents, note.Content)
+	}
+	return titles, contents
+}
+
+func TestSplitNoteByNestedHeadings(t *testing.T) {
+	db := notestest.NewDB(t)
+	guide := notestest.MustAddNote(t, db, "docs", models.Note{Content: nestedHeadings, Kind: models.KindMarkdown, Tags: []string{"manual"}})
+
+	// sections of level 2 keep the level 3 ones they hold; "# Guide" and "## Empty" have nothing of their own
+	parts, err := db.SplitNote("docs", guide.Id, models.SplitOptions{HeadingLevel: 2, MarkOrigin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles, contents := titlesAndContents(parts)
+	if want := []string{"Read this first.", "Install", "Use", "Appendix"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("parts titled %q, want %q", titles, want)
+	}
+	wantContents := []string{
+		"Read this first.",
+		"## Install\nsteps\n### Linux\napt install notes",
+		"## Use\n```\n## not a heading, but a comment\n```\nrun notes",
+		"# Appendix\nmore",
+	}
+	if !reflect.DeepEqual(contents, wantContents) {
+		t.Errorf("parts %q, want %q", contents, wantContents)
+	}
+	marker := models.SplitFromTagPrefix + strconv.FormatUint(guide.Id, 10)
+	for _, part := range parts {
+		if part.Id == 0 || part.Kind != models.KindMarkdown || !reflect.DeepEqual(part.Tags, []string{"manual", marker}) {
+			t.Errorf("part %+v", part)
+		}
+	}
+	if kept, _ := db.GetNote("docs", guide.Id); kept.Content != nestedHeadings {
+		t.Errorf("original changed to %q", kept.Content)
+	}
+
+	// at level 3, "### Linux" gets a part of its own
+	parts, err = db.SplitNote("docs", guide.Id, models.SplitOptions{HeadingLevel: 3, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles, _ := titlesAndContents(parts); !reflect.DeepEqual(titles, []string{"Read this first.", "Install", "Linux", "Use", "Appendix"}) {
+		t.Errorf("parts at level 3 titled %q", titles)
+	}
+}
+
+func TestSplitNoteWithoutDelimiterIsANoOp(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "one\nsingle\npart")
+	for _, opts := range []models.SplitOptions{
+		{Delimiter: "---"},
+		{Delimiter: "---", DryRun: true},
+		{Delimiter: "---", Original: models.SplitTrashOriginal},
+		{HeadingLevel: 2},
+		{MaxSize: 1 << 10},
+	} {
+		parts, err := db.SplitNote("work", note.Id, opts)
+		if !errors.Is(err, models.ErrNothingToSplit) || parts != nil {
+			t.Errorf("splitting with %+v: %d parts (%v), want ErrNothingToSplit", opts, len(parts), err)
+		}
+		if !strings.Contains(err.Error(), "makes 1 part") {
+			t.Errorf("error %q doesn't tell the note makes a single part", err)
+		}
+	}
+	// a note only delimiters and blank lines apart from one part doesn't split either
+	blanks := notestest.MustAdd(t, db, "work", "---\n\n---\nlonely\n---\n   \n")
+	if _, err := db.SplitNote("work", blanks.Id, models.SplitOptions{Delimiter: "---"}); !errors.Is(err, models.ErrNothingToSplit) {
+		t.Errorf("splitting blank parts: %v, want ErrNothingToSplit", err)
+	}
+	notes, err := db.ListNotes("work")
+	if err != nil || len(notes) != 2 || notes[0].Content != "one\nsingle\npart" {
+		t.Errorf("notes after splitting nothing: %+v (%v)", notes, err)
+	}
+}
+
+func TestSplitNoteByDelimiterTrashingOriginal(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAddNote(t, db, "work", models.Note{Content: "first\n---\n\n---\nsecond\n  ---  \nthird\n", Tags: []string{"log"}})
+
+	preview, err := db.SplitNote("work", note.Id, models.SplitOptions{Delimiter: "---", Original: models.SplitTrashOriginal, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, contents := titlesAndContents(preview); !reflect.DeepEqual(contents, []string{"first", "second", "third"}) || preview[0].Id != 0 {
+		t.Errorf("dry run %+v", preview)
+	}
+	if notes, _ := db.ListNotes("work"); len(notes) != 1 {
+		t.Fatalf("dry run wrote: %d notes", len(notes))
+	}
+
+	parts, err := db.SplitNote("work", note.Id, models.SplitOptions{Delimiter: "---", Original: models.SplitTrashOriginal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, err := db.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, contents := titlesAndContents(notes)
+	if !reflect.DeepEqual(contents, []string{"first", "second", "third"}) || len(parts) != 3 || notes[0].Id != parts[0].Id {
+		t.Errorf("notes after the split: %q", contents)
+	}
+	if notes[0].Tags[0] != "log" || len(notes[0].Tags) != 1 {
+		t.Errorf("tags of a part %q, want the original's only", notes[0].Tags)
+	}
+
+	// read-only notes can't be trashed: splitting one so fails, writing nothing
+	locked := notestest.MustAdd(t, db, "work", "a\n---\nb")
+	if err := db.LockNoteReadOnly("work", locked.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SplitNote("work", locked.Id, models.SplitOptions{Delimiter: "---", Original: models.SplitTrashOriginal}); !errors.Is(err, models.ErrNoteReadOnly) {
+		t.Errorf("trashing a read-only note: %v, want ErrNoteReadOnly", err)
+	}
+	if notes, _ := db.ListNotes("work"); len(notes) != 4 {
+		t.Errorf("%d notes after a failed split, want it to write nothing", len(notes))
+	}
+}
+
+func TestSplitNoteBySize(t *testing.T) {
+	db := notestest.NewDB(t)
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, "line "+strconv.Itoa(i))
+	}
+	long := strings.Repeat("é", 30)
+	note := notestest.MustAdd(t, db, "work", strings.Join(lines, "\n")+"\n"+long)
+	parts, err := db.SplitNote("work", note.Id, models.SplitOptions{MaxSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// parts lose their final line break only
+	var joined strings.Builder
+	for _, part := range parts {
+		if len(part.Content) > 50 {
+			t.Errorf("part of %d bytes: %q", len(part.Content), part.Content)
+		}
+		joined.WriteString(part.Content)
+	}
+	if strings.Replace(joined.String(), "\n", "", -1) != strings.Join(lines, "")+long {
+		t.Errorf("parts don't make the note back: %q", joined.String())
+	}
+}
+
+func TestSplitNoteInvalidOptions(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "a\n---\nb")
+	for _, opts := range []models.SplitOptions{
+		{},
+		{Delimiter: "---", HeadingLevel: 2},
+		{HeadingLevel: 7},
+		{MaxSize: -1},
+		{Delimiter: "---", Original: "shred"},
+	} {
+		if _, err := db.SplitNote("work", note.Id, opts); !errors.Is(err, models.ErrInvalidSplit) {
+			t.Errorf("splitting with %+v: %v, want ErrInvalidSplit", opts, err)
+		}
+	}
+}
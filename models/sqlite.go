@@ -0,0 +1,202 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Name of the database/sql driver ExportSQLite uses
+ * The driver is not linked in by this package (so that cgo isn't forced on everyone);
+ * programs exporting to SQLite import one, like the pure-Go
+ *   import _ "modernc.org/sqlite"      // registers "sqlite"
+ * or
+ *   import _ "github.com/mattn/go-sqlite3" // registers "sqlite3", needs cgo
+ * and set SQLiteDriverName accordingly
+ */
+var SQLiteDriverName = "sqlite"
+
+/**
+ * Number of rows inserted per SQL transaction by ExportSQLite
+ */
+const sqliteExportBatchSize = 1000
+
+var sqliteSchema = []string{
+	`CREATE TABLE notebooks (
+		id   INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE notes (
+		notebook_id INTEGER NOT NULL REFERENCES notebooks(id),
+		id          INTEGER NOT NULL,
+		content     TEXT NOT NULL,
+		created_at  TEXT,
+		updated_at  TEXT,
+		expires_at  TEXT,
+		PRIMARY KEY (notebook_id, id)
+	)`,
+	`CREATE TABLE tags (
+		id   INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE note_tags (
+		notebook_id INTEGER NOT NULL,
+		note_id     INTEGER NOT NULL,
+		tag_id      INTEGER NOT NULL REFERENCES tags(id),
+		PRIMARY KEY (notebook_id, note_id, tag_id),
+		FOREIGN KEY (notebook_id, note_id) REFERENCES notes(notebook_id, id)
+	)`,
+}
+
+/**
+ * Exports all notebooks and notes into a new SQLite file, for ad-hoc querying
+ *  - tables: notebooks(id, name), notes(notebook_id, id, content, created_at, updated_at, expires_at),
+ *    tags(id, name) and note_tags(notebook_id, note_id, tag_id)
+ *  - note ids are preserved (notes are identified by notebook_id and id together);
+ *    timestamps are ISO-8601 (RFC 3339) text in UTC, NULL when unknown
+ *  - rows are inserted in batches, each within its own SQL transaction
+ *  - needs a SQLite driver to be registered (see SQLiteDriverName); fails if path already exists
+ *  - options are those of ListNotes (like WithTag), applied to the notes of every notebook;
+ *    expired notes are left out unless WithExpired() is passed
+ * param: string        path
+ * param: ...ListOption opts
+ * return: error
+ */
+func (db *DB) ExportSQLite(path string, opts ...ListOption) error {
+	filter := newNoteFilter(opts)
+	if !sqlDriverRegistered(SQLiteDriverName) {
+		return fmt.Errorf("sql driver '%s' is not registered (import a SQLite driver, see SQLiteDriverName)", SQLiteDriverName)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("'%s' already exists", path)
+	}
+	sqlDB, err := sql.Open(SQLiteDriverName, path)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	for _, statement := range sqliteSchema {
+		if _, err := sqlDB.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	w := &sqlBatchWriter{db: sqlDB, batchSize: sqliteExportBatchSize}
+	tagIds := make(map[string]int64)
+	err = db.View(func(tx *bolt.Tx) error {
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		var notebookId int64
+		return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if notebookBucket == nil {
+				return nil
+			}
+			notebookId++
+			if err := w.exec(`INSERT INTO notebooks (id, name) VALUES (?, ?)`, notebookId, notebookDisplayName(tx, notebookKey)); err != nil {
+				return err
+			}
+			return db.forEachMatchingNote(tx, notebookKey, filter, func(note Note) error {
+				err := w.exec(`INSERT INTO notes (notebook_id, id, content, created_at, updated_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+					notebookId, int64(note.Id), note.Content,
+					sqlTime(note.CreatedAt), sqlTime(note.UpdatedAt), sqlTimePtr(note.ExpiresAt))
+				if err != nil {
+					return err
+				}
+				for _, tag := range note.Tags {
+					tagId, ok := tagIds[tag]
+					if !ok {
+						tagId = int64(len(tagIds) + 1)
+						tagIds[tag] = tagId
+						if err := w.exec(`INSERT INTO tags (id, name) VALUES (?, ?)`, tagId, tag); err != nil {
+							return err
+						}
+					}
+					if err := w.exec(`INSERT OR IGNORE INTO note_tags (notebook_id, note_id, tag_id) VALUES (?, ?, ?)`,
+						notebookId, int64(note.Id), tagId); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	return w.commit()
+}
+
+/**
+ * Executes statements in SQL transactions of (at most) batchSize statements each
+ */
+type sqlBatchWriter struct {
+	db        *sql.DB
+	batchSize int
+	tx        *sql.Tx
+	pending   int
+}
+
+func (w *sqlBatchWriter) exec(query string, args ...interface{}) error {
+	if w.tx == nil {
+		tx, err := w.db.Begin()
+		if err != nil {
+			return err
+		}
+		w.tx = tx
+	}
+	if _, err := w.tx.Exec(query, args...); err != nil {
+		return err
+	}
+	w.pending++
+	if w.pending == w.batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *sqlBatchWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx, w.pending = nil, 0
+	return err
+}
+
+func (w *sqlBatchWriter) rollback() {
+	if w.tx != nil {
+		w.tx.Rollback()
+		w.tx, w.pending = nil, 0
+	}
+}
+
+func sqlDriverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * ISO-8601 text of a timestamp (nil, i.e. NULL, for zero time)
+ */
+func sqlTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func sqlTimePtr(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return sqlTime(*t)
+}
@@ -0,0 +1,111 @@
+package models_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+	_ "modernc.org/sqlite" // registers "sqlite" (pure Go), see models.SQLiteDriverName
+)
+
+func TestExportSQLite(t *testing.T) {
+	db := notestest.NewDB(t)
+	ids := notestest.Seed(t, db, notestest.Spec{
+		Notebooks: map[string][]string{
+			"home": {"groceries", "plumber's number"},
+			"work": {"standup notes", "quarterly report", "1:1 agenda"},
+		},
+		Tags: []string{"seeded"},
+	})
+	tagged := notestest.MustAddNote(t, db, "work", models.Note{Content: "launch checklist", Tags: []string{"launch", "seeded"}})
+	expiresAt := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := db.SetExpiry("work", ids["work"][0], &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notes.sqlite")
+	if err := db.ExportSQLite(path); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := sql.Open(models.SQLiteDriverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	// notes by notebook
+	rows, err := sqlDB.Query(`SELECT nb.name, COUNT(*) FROM notes n JOIN notebooks nb ON nb.id = n.notebook_id
+		GROUP BY nb.name ORDER BY nb.name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			t.Fatal(err)
+		}
+		counts[name] = count
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if counts["home"] != 2 || counts["work"] != 4 || len(counts) != 2 {
+		t.Errorf("notes by notebook = %v, want home: 2, work: 4", counts)
+	}
+
+	// notes of a tag, with their ids preserved
+	var id int64
+	var content string
+	err = sqlDB.QueryRow(`SELECT n.id, n.content FROM notes n
+		JOIN note_tags nt ON nt.notebook_id = n.notebook_id AND nt.note_id = n.id
+		JOIN tags t ON t.id = nt.tag_id WHERE t.name = 'launch'`).Scan(&id, &content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(id) != tagged.Id || content != "launch checklist" {
+		t.Errorf("note tagged 'launch' = %d %q, want %d %q", id, content, tagged.Id, "launch checklist")
+	}
+	var seeded int
+	if err := sqlDB.QueryRow(`SELECT COUNT(*) FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+		WHERE t.name = 'seeded'`).Scan(&seeded); err != nil {
+		t.Fatal(err)
+	}
+	if seeded != 6 {
+		t.Errorf("%d notes tagged 'seeded', want 6", seeded)
+	}
+
+	// timestamps as ISO-8601 text, NULL when unknown
+	var createdAt string
+	var updatedAt, expires sql.NullString
+	err = sqlDB.QueryRow(`SELECT created_at, updated_at, expires_at FROM notes n JOIN notebooks nb ON nb.id = n.notebook_id
+		WHERE nb.name = 'work' AND n.id = ?`, int64(ids["work"][0])).Scan(&createdAt, &updatedAt, &expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != notestest.DefaultStart.Format(time.RFC3339Nano) {
+		t.Errorf("created_at = %q, want %q", createdAt, notestest.DefaultStart.Format(time.RFC3339Nano))
+	}
+	if updatedAt.Valid {
+		t.Errorf("updated_at = %q, want NULL for a note never updated", updatedAt.String)
+	}
+	if !expires.Valid || expires.String != "2030-01-02T03:04:05Z" {
+		t.Errorf("expires_at = %v, want 2030-01-02T03:04:05Z", expires)
+	}
+}
+
+func TestExportSQLiteRefusesExistingFile(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.MustAdd(t, db, "work", "content")
+	path := filepath.Join(t.TempDir(), "notes.sqlite")
+	if err := db.ExportSQLite(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExportSQLite(path); err == nil {
+		t.Error("exporting over an existing file succeeded")
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Activity is counted per notebook and day as notes are written
+ *  - 'Stats' bucket: Stats -> notebook -> day ('2006-01-02', UTC) -> JSON dayActivity
+ *  - every mutation adds to its day's counters with a single Put within the mutation's transaction,
+ *    so histograms are read off the counters without touching notes
+ *  - activity from before counters existed isn't known
+ */
+
+/**
+ * Layout of day keys of 'Stats' bucket (sorting chronologically)
+ */
+const statsDayLayout = "2006-01-02"
+
+/**
+ * Number of notes created, updated and deleted within a span of time
+ * (a bucket of ActivityHistogram, starting at Start)
+ */
+type ActivityBucket struct {
+	Start   time.Time `json:"start"`
+	Created int       `json:"created"`
+	Updated int       `json:"updated"`
+	Deleted int       `json:"deleted"`
+}
+
+/**
+ * Counters of a single day
+ */
+type dayActivity struct {
+	Created int `json:"c,omitempty"`
+	Updated int `json:"u,omitempty"`
+	Deleted int `json:"d,omitempty"`
+}
+
+/**
+ * Counts of what the DB holds
+ *  - notebooks are counted as active or archived (see archive.go)
+ *  - Notes counts notes of all notebooks, archived ones included
+ */
+type DBStats struct {
+	ActiveNotebooks   int `json:"active_notebooks"`
+	ArchivedNotebooks int `json:"archived_notebooks"`
+	Notes             int `json:"notes"`
+}
+
+/**
+ * Counts notebooks (active and archived) and notes of the DB
+ * return: (DBStats, error)
+ */
+func (db *DB) GetDBStats() (DBStats, error) {
+	var stats DBStats
+	err := db.View(func(tx *bolt.Tx) error {
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		return rootBucket.ForEach(func(notebookKey, v []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if v != nil || notebookBucket == nil {
+				return nil
+			}
+			if notebookArchived(tx, notebookKey) {
+				stats.ArchivedNotebooks++
+			} else {
+				stats.ActiveNotebooks++
+			}
+			stats.Notes += notebookBucket.Stats().KeyN
+			return nil
+		})
+	})
+	return stats, err
+}
+
+/**
+ * Counts notes of a notebook created, updated and deleted within [from, to), per time bucket
+ *  - buckets are consecutive spans of given length, the first one starting on from's day (UTC);
+ *    as activity is counted per day, the length must be a whole number of days
+ *  - buckets without activity are included (with zero counts)
+ * param: string        notebookName
+ * param: time.Time     from
+ * param: time.Time     to
+ * param: time.Duration bucket
+ * return: ([]ActivityBucket, error)
+ */
+func (db *DB) ActivityHistogram(notebookName string, from, to time.Time, bucket time.Duration) ([]ActivityBucket, error) {
+	return db.activityHistogram([][]byte{db.notebookKey(notebookName)}, from, to, bucket)
+}
+
+/**
+ * Same as ActivityHistogram, adding up activity of all notebooks
+ */
+func (db *DB) ActivityHistogramAll(from, to time.Time, bucket time.Duration) ([]ActivityBucket, error) {
+	return db.activityHistogram(nil, from, to, bucket)
+}
+
+/**
+ * Core logic of ActivityHistogram(All): activity of notebooks with given keys (all notebooks if nil)
+ */
+func (db *DB) activityHistogram(notebookKeys [][]byte, from, to time.Time, bucket time.Duration) ([]ActivityBucket, error) {
+	if bucket < 24*time.Hour || bucket%(24*time.Hour) != 0 {
+		return nil, fmt.Errorf("%w: bucket length %v isn't a whole number of days", ErrInvalidQuery, bucket)
+	}
+	start := startOfDay(from)
+	if !to.After(start) {
+		return nil, nil
+	}
+	var histogram []ActivityBucket
+	for t := start; t.Before(to); t = t.Add(bucket) {
+		histogram = append(histogram, ActivityBucket{Start: t})
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		statsBucket := tx.Bucket([]byte("Stats"))
+		if statsBucket == nil {
+			return nil
+		}
+		if notebookKeys == nil {
+			statsBucket.ForEach(func(notebookKey, v []byte) error {
+				if v == nil {
+					notebookKeys = append(notebookKeys, notebookKey)
+				}
+				return nil
+			})
+		}
+		for _, notebookKey := range notebookKeys {
+			notebookStatsBucket := statsBucket.Bucket(notebookKey)
+			if notebookStatsBucket == nil {
+				continue
+			}
+			cursor := notebookStatsBucket.Cursor()
+			for k, v := cursor.Seek([]byte(start.Format(statsDayLayout))); k != nil; k, v = cursor.Next() {
+				day, err := time.Parse(statsDayLayout, string(k))
+				if err != nil {
+					return err
+				}
+				if !day.Before(to) {
+					break
+				}
+				var activity dayActivity
+				if err := json.Unmarshal(v, &activity); err != nil {
+					return err
+				}
+				counts := &histogram[int(day.Sub(start)/bucket)]
+				counts.Created += activity.Created
+				counts.Updated += activity.Updated
+				counts.Deleted += activity.Deleted
+			}
+		}
+		return nil
+	})
+	return histogram, err
+}
+
+/**
+ * Adds to today's activity counters of a notebook within given write transaction
+ */
+func recordActivity(tx *bolt.Tx, notebookKey []byte, activity dayActivity) error {
+	if activity == (dayActivity{}) {
+		return nil
+	}
+	statsBucket, err := tx.CreateBucketIfNotExists([]byte("Stats"))
+	if err != nil {
+		return err
+	}
+	notebookStatsBucket, err := statsBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return err
+	}
+	day := []byte(time.Now().UTC().Format(statsDayLayout))
+	var counts dayActivity
+	if encoded := notebookStatsBucket.Get(day); encoded != nil {
+		if err := json.Unmarshal(encoded, &counts); err != nil {
+			return err
+		}
+	}
+	counts.Created += activity.Created
+	counts.Updated += activity.Updated
+	counts.Deleted += activity.Deleted
+	encoded, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	return notebookStatsBucket.Put(day, encoded)
+}
+
+/**
+ * Midnight (UTC) of t's day (in UTC)
+ */
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Tags and titles of notes are indexed whenever a note is saved, so that they can be suggested
+ * (like for a quick-capture box) without reading notes
+ *  - 'TagIndex' bucket: notebook key -> 'counts' -> tag -> number of notes having it (8 bytes)
+ *                                    -> 'notes' -> note id -> JSON list of the note's (distinct) tags
+ *  - 'TitleIndex' bucket: notebook key -> 'recent' -> time of last change (unix nanoseconds, 8 bytes)
+ *                                          and note id (8 bytes) -> title
+ *                                      -> 'notes' -> note id -> its key in 'recent'
+ *                                      -> 'unique', 'unique_notes' (for notebooks requiring unique titles, see unique_titles.go)
+ *  - notes without tags (or title) have no entry; DBs created before the index existed get it built on open
+ */
+
+/**
+ * Suggests tags of a notebook starting with given prefix (case-insensitively), most used first
+ * (ties by tag); an empty prefix suggests the most used tags
+ * Fails with ErrNotebookNotFound if the notebook doesn't exist, and with ErrIndexNotBuilt (suggesting nothing)
+ * if the DB has no index
+ * param: string notebookName
+ * param: string prefix
+ * param: int    limit Maximum number of tags suggested (all if not positive)
+ * return: ([]string, error)
+ */
+func (db *DB) SuggestTags(notebookName, prefix string, limit int) ([]string, error) {
+	type tagCount struct {
+		tag   string
+		count uint64
+	}
+	var matching []tagCount
+	prefix = strings.ToLower(prefix)
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := indexBuilt(tx, "TagIndex"); err != nil {
+			return err
+		}
+		counts := suggestionBucket(tx, "TagIndex", notebookKey, "counts")
+		if counts == nil {
+			return nil
+		}
+		return counts.ForEach(func(tag, count []byte) error {
+			if strings.HasPrefix(strings.ToLower(string(tag)), prefix) {
+				matching = append(matching, tagCount{tag: string(tag), count: binary.BigEndian.Uint64(count)})
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, ErrIndexNotBuilt) {
+		return []string{}, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].count != matching[j].count {
+			return matching[i].count > matching[j].count
+		}
+		return matching[i].tag < matching[j].tag
+	})
+	tags := []string{}
+	for _, match := range matching {
+		if limit > 0 && len(tags) == limit {
+			break
+		}
+		tags = append(tags, match.tag)
+	}
+	return tags, nil
+}
+
+/**
+ * Suggests titles of notes of a notebook starting with given prefix (case-insensitively), titles of
+ * the most recently changed notes first (each title once); an empty prefix suggests the most recent titles
+ * Fails with ErrNotebookNotFound if the notebook doesn't exist, and with ErrIndexNotBuilt (suggesting nothing)
+ * if the DB has no index
+ * param: string notebookName
+ * param: string prefix
+ * param: int    limit Maximum number of titles suggested (all if not positive)
+ * return: ([]string, error)
+ */
+func (db *DB) SuggestTitles(notebookName, prefix string, limit int) ([]string, error) {
+	titles := []string{}
+	prefix = strings.ToLower(prefix)
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := indexBuilt(tx, "TitleIndex"); err != nil {
+			return err
+		}
+		recent := suggestionBucket(tx, "TitleIndex", notebookKey, "recent")
+		if recent == nil {
+			return nil
+		}
+		seen := make(map[string]bool)
+		cursor := recent.Cursor()
+		for k, title := cursor.Last(); k != nil && (limit <= 0 || len(titles) < limit); k, title = cursor.Prev() {
+			if !seen[string(title)] && strings.HasPrefix(strings.ToLower(string(title)), prefix) {
+				seen[string(title)] = true
+				titles = append(titles, string(title))
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrIndexNotBuilt) {
+		return titles, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+/**
+ * Retrieves a (3rd order) bucket of a suggestion index; nil if there's none
+ */
+func suggestionBucket(tx *bolt.Tx, indexName string, notebookKey []byte, name string) *bolt.Bucket {
+	rootBucket := tx.Bucket([]byte(indexName))
+	if rootBucket == nil || rootBucket.Bucket(notebookKey) == nil {
+		return nil
+	}
+	return rootBucket.Bucket(notebookKey).Bucket([]byte(name))
+}
+
+func createSuggestionBuckets(tx *bolt.Tx, indexName string, notebookKey []byte, names ...string) ([]*bolt.Bucket, error) {
+	rootBucket, err := tx.CreateBucketIfNotExists([]byte(indexName))
+	if err != nil {
+		return nil, err
+	}
+	notebookBucket, err := rootBucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []*bolt.Bucket
+	for _, name := range names {
+		bucket, err := notebookBucket.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+/**
+ * Replaces the tag and title index entries of a note (a note without tags and title, like Note{Id: id},
+ * removes them); fails with a *TitleTakenError if its title is taken in a notebook requiring unique titles
+ */
+func putSuggestions(tx *bolt.Tx, notebookKey []byte, note Note) error {
+	if err := putUniqueTitle(tx, notebookKey, note.Id, note.TitleText); err != nil {
+		return err
+	}
+	if err := putTagSuggestions(tx, notebookKey, note.Id, note.Tags); err != nil {
+		return err
+	}
+	return putTitleSuggestion(tx, notebookKey, note.Id, note.TitleText, lastChangedAt(note))
+}
+
+/**
+ * Time a note was last changed (unix nanoseconds), as the title index orders notes by
+ */
+func lastChangedAt(note Note) int64 {
+	var changedAt int64
+	for _, t := range []time.Time{note.CreatedAt, note.UpdatedAt} {
+		if !t.IsZero() && t.UnixNano() > changedAt {
+			changedAt = t.UnixNano()
+		}
+	}
+	return changedAt
+}
+
+/**
+ * Distinct (non-empty) tags, in order, as the tag index holds them
+ */
+func distinctTags(tags []string) []string {
+	var distinct []string
+	for _, tag := range tags {
+		if tag != "" && !containsString(distinct, tag) {
+			distinct = append(distinct, tag)
+		}
+	}
+	return distinct
+}
+
+/**
+ * Key of a note's entry in 'recent' of the title index
+ */
+func titleIndexKey(noteId uint64, changedAt int64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(changedAt))
+	binary.BigEndian.PutUint64(key[8:], noteId)
+	return key
+}
+
+func putTagSuggestions(tx *bolt.Tx, notebookKey []byte, noteId uint64, tags []string) error {
+	noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+	var previous []string
+	if notes := suggestionBucket(tx, "TagIndex", notebookKey, "notes"); notes != nil {
+		if encoded := notes.Get(noteIdBytes); encoded != nil {
+			if err := json.Unmarshal(encoded, &previous); err != nil {
+				return err
+			}
+		}
+	}
+	distinct := distinctTags(tags)
+	if len(previous) == 0 && len(distinct) == 0 {
+		return nil
+	}
+
+	buckets, err := createSuggestionBuckets(tx, "TagIndex", notebookKey, "counts", "notes")
+	if err != nil {
+		return err
+	}
+	counts, notes := buckets[0], buckets[1]
+	adjust := func(tag string, delta int) error {
+		var count uint64
+		if encoded := counts.Get([]byte(tag)); encoded != nil {
+			count = binary.BigEndian.Uint64(encoded)
+		}
+		if delta < 0 && count <= 1 {
+			return counts.Delete([]byte(tag))
+		}
+		return counts.Put([]byte(tag), itob(uint64(int64(count)+int64(delta))))
+	}
+	for _, tag := range previous {
+		if !containsString(distinct, tag) {
+			if err := adjust(tag, -1); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tag := range distinct {
+		if !containsString(previous, tag) {
+			if err := adjust(tag, 1); err != nil {
+				return err
+			}
+		}
+	}
+	if len(distinct) == 0 {
+		return notes.Delete(noteIdBytes)
+	}
+	encoded, err := json.Marshal(distinct)
+	if err != nil {
+		return err
+	}
+	return notes.Put(noteIdBytes, encoded)
+}
+
+func putTitleSuggestion(tx *bolt.Tx, notebookKey []byte, noteId uint64, title string, changedAt int64) error {
+	noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+	var previousKey []byte
+	if notes := suggestionBucket(tx, "TitleIndex", notebookKey, "notes"); notes != nil && notes.Get(noteIdBytes) != nil {
+		previousKey = append([]byte(nil), notes.Get(noteIdBytes)...)
+	}
+	if previousKey == nil && title == "" {
+		return nil
+	}
+
+	buckets, err := createSuggestionBuckets(tx, "TitleIndex", notebookKey, "recent", "notes")
+	if err != nil {
+		return err
+	}
+	recent, notes := buckets[0], buckets[1]
+	if previousKey != nil {
+		if err := recent.Delete(previousKey); err != nil {
+			return err
+		}
+	}
+	if title == "" {
+		return notes.Delete(noteIdBytes)
+	}
+	key := titleIndexKey(noteId, changedAt)
+	if err := recent.Put(key, []byte(title)); err != nil {
+		return err
+	}
+	return notes.Put(noteIdBytes, key)
+}
+
+/**
+ * Indexes tags and titles of all notes (for DBs created before the index existed)
+ * Records are read as they are: tags, titles and times are never encrypted, so content needn't be
+ */
+func buildSuggestionIndex(tx *bolt.Tx) error {
+	for _, indexName := range []string{"TagIndex", "TitleIndex"} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(indexName)); err != nil {
+			return err
+		}
+	}
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(k, encodedNote []byte) error {
+			var note Note
+			if err := json.Unmarshal(encodedNote, &note); err != nil {
+				// corrupt notes are reported by CheckIntegrity, not fatal here
+				return nil
+			}
+			note.Id, _ = strconv.ParseUint(string(k), 10, 64)
+			return putSuggestions(tx, notebookKey, note)
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuggestTags(t *testing.T) {
+	db := newTestDB(t)
+	for _, tags := range [][]string{{"Project", "urgent"}, {"project", "prose"}, {"project"}, {"urgent", "urgent"}, {"plan"}} {
+		mustAddNote(t, db, "work", Note{Content: "note", Tags: tags})
+	}
+	for _, test := range []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		// most used first, ties by tag; a note counts once per tag
+		{"", 0, []string{"project", "urgent", "Project", "plan", "prose"}},
+		{"", 2, []string{"project", "urgent"}},
+		{"PR", 0, []string{"project", "Project", "prose"}},
+		{"pro", 1, []string{"project"}},
+		{"x", 0, []string{}},
+	} {
+		got, err := db.SuggestTags("work", test.prefix, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") || got == nil {
+			t.Errorf("SuggestTags(%q, %d) = %q, want %q", test.prefix, test.limit, got, test.want)
+		}
+	}
+
+	// counts follow deletions
+	notes, err := db.ListNotes("work", WithTag("urgent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, note := range notes {
+		if err := db.DeleteNotes("work", note.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := db.SuggestTags("work", "u", 0); len(got) != 0 {
+		t.Errorf("tags of deleted notes suggested: %q", got)
+	}
+	if _, err := db.SuggestTags("missing", "", 0); err == nil {
+		t.Error("tags of a missing notebook suggested")
+	}
+}
+
+func TestSuggestTitles(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	var ids []uint64
+	for i, title := range []string{"Weekly review", "Plan the offsite", "weekly sync", "Weekly review", "Budget"} {
+		note := mustAddNote(t, db, "work", Note{TitleText: title, Content: "body", CreatedAt: start.Add(time.Duration(i) * time.Hour)})
+		ids = append(ids, note.Id)
+	}
+	check := func(prefix string, limit int, want ...string) {
+		t.Helper()
+		got, err := db.SuggestTitles("work", prefix, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("SuggestTitles(%q, %d) = %q, want %q", prefix, limit, got, want)
+		}
+	}
+	// most recent first, each title once
+	check("", 0, "Budget", "Weekly review", "weekly sync", "Plan the offsite")
+	check("", 2, "Budget", "Weekly review")
+	check("WEEK", 0, "Weekly review", "weekly sync")
+	check("nothing", 0)
+
+	// updating a note makes its title the most recent
+	if _, err := db.UpdateNote("work", ids[1], "body again"); err != nil {
+		t.Fatal(err)
+	}
+	check("", 1, "Plan the offsite")
+}
+
+// notes of the notebook suggestions are benchmarked over, and tags they're given from
+const benchmarkSuggestNotes, benchmarkSuggestTags = 10000, 500
+
+/**
+ * DB of benchmarkSuggestNotes notes with titles and three tags each (some tags much more used than
+ * others), loaded outside the timer
+ */
+func openSuggestBenchmarkDB(b *testing.B) *DB {
+	db, cleanup := openBenchmarkDB(b)
+	b.Cleanup(cleanup)
+	b.StopTimer()
+	defer b.StartTimer()
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err := db.MultiNotebookTx([]string{"big"}, func(nbs map[string]*NotebookTx) error {
+		for i := 0; i < benchmarkSuggestNotes; i++ {
+			tags := []string{
+				fmt.Sprintf("tag%03d", i%benchmarkSuggestTags),
+				fmt.Sprintf("tag%03d", (i*i)%50),
+				fmt.Sprintf("topic%02d", i%7),
+			}
+			note := Note{TitleText: fmt.Sprintf("Meeting %d about topic %d", i, i%7), Content: "body", Tags: tags,
+				CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+			if _, err := nbs["big"].Put(note); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, CreateNotebooks())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+func BenchmarkSuggestTags10k(b *testing.B) {
+	db := openSuggestBenchmarkDB(b)
+	for _, prefix := range []string{"", "tag", "tag04", "topic", "none"} {
+		b.Run(fmt.Sprintf("prefix=%q", prefix), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.SuggestTags("big", prefix, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSuggestTitles10k(b *testing.B) {
+	db := openSuggestBenchmarkDB(b)
+	// (a prefix matching only the oldest notes walks the whole index)
+	for _, prefix := range []string{"", "meeting", "meeting 99", "meeting 1 ", "none"} {
+		b.Run(fmt.Sprintf("prefix=%q", prefix), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.SuggestTitles("big", prefix, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
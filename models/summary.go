@@ -0,0 +1,219 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Lightweight view of a note for listings (see ListNoteSummaries)
+ *  - Title is the one displayed (see Note.Title), cut at summaryTitleLength characters
+ *  - Preview holds the first characters of content (never splitting one)
+ *  - UpdatedAt is the creation time of notes never updated
+ */
+type NoteSummary struct {
+	Id        uint64    `json:"id"`
+	Title     string    `json:"title"`
+	Preview   string    `json:"preview"`
+	Tags      []string  `json:"tags,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+/**
+ * Number of characters of content previewed by summaries, unless asked otherwise
+ */
+const DefaultPreviewLength = 100
+
+/**
+ * Number of characters titles of summaries are cut at (a note without a title line is titled
+ * by its whole content)
+ */
+const summaryTitleLength = 200
+
+/**
+ * Retrieves summaries of the notes of a notebook, taking options of ListNotes
+ * Only the beginning of every note's content is decoded (as far as the title and preview need),
+ * so listing large notes costs a fraction of ListNotes; notes are still decoded in full for
+ * filters looking into content (TextContains and Language)
+ * param: string        notebookName
+ * param: int           previewLength Characters of content previewed (DefaultPreviewLength if not positive)
+ * param: ...ListOption opts
+ * return: ([]NoteSummary, error)
+ */
+func (db *DB) ListNoteSummaries(notebookName string, previewLength int, opts ...ListOption) ([]NoteSummary, error) {
+	if previewLength <= 0 {
+		previewLength = DefaultPreviewLength
+	}
+	filter := newNoteFilter(opts)
+	var notes []Note
+	err := db.View(func(tx *bolt.Tx) error {
+		return db.forEachMatchingHead(tx, db.notebookKey(notebookName), filter, previewLength, func(note Note) error {
+			notes = append(notes, note)
+			return nil
+		})
+	})
+	if filter.SortByPosition {
+		sortByPosition(notes)
+	}
+	return summarize(notes, previewLength), err
+}
+
+/**
+ * Same as forEachMatchingNote, but notes carry only as much of their content as a summary with
+ * a preview of previewLength characters needs (unless the filter looks into content)
+ */
+func (db *DB) forEachMatchingHead(tx *bolt.Tx, notebookKey []byte, filter NoteFilter, previewLength int, fn func(Note) error) error {
+	if filter.Text != "" || filter.Language != "" {
+		return db.forEachMatchingNote(tx, notebookKey, filter, fn)
+	}
+	return db.forEachNote(tx, notebookKey, filter, func(k, v []byte) (Note, error) {
+		note, err := readNoteHead(tx, notebookKey, v, previewLength)
+		if err != nil {
+			return note, &CorruptRecord{Notebook: notebookDisplayName(tx, notebookKey), Key: string(k), Size: len(v), Err: err}
+		}
+		if isSealed(note.Content) {
+			// encrypted content can only be decrypted whole
+			return db.decodeNote(tx, notebookKey, k, v)
+		}
+		return note, nil
+	}, fn)
+}
+
+/**
+ * Content of a note record, decoded only up to 'limit' characters, or further up to where the
+ * first line ends (as long as the first line is within summaryTitleLength characters)
+ */
+type contentHead struct {
+	limit int
+	text  string
+}
+
+/**
+ * Note record whose content is decoded as a contentHead (shadowing Note.Content)
+ * Note is embedded as a type without methods, so that its UnmarshalJSON doesn't decode the record whole
+ */
+type noteHead struct {
+	noteRecord
+	Content contentHead `json:"content"`
+}
+
+type noteRecord Note
+
+func (c *contentHead) UnmarshalJSON(data []byte) error {
+	if len(data) < 2 || data[0] != '"' {
+		return json.Unmarshal(data, &c.text)
+	}
+	// find where to cut the string literal, escape sequences and characters left whole
+	end := len(data) - 1
+	i, runes, newline := 1, 0, false
+	for i < end && !(runes >= c.limit && (newline || runes >= summaryTitleLength)) {
+		switch {
+		case data[i] != '\\':
+			_, size := utf8.DecodeRune(data[i:end])
+			i += size
+		case i+1 < end && data[i+1] == 'u':
+			i += 6
+			// a surrogate pair stands for a single character
+			if i+6 <= end && data[i] == '\\' && data[i+1] == 'u' && isHighSurrogate(data[i-4:i]) {
+				i += 6
+			}
+		default:
+			newline = newline || (i+1 < end && data[i+1] == 'n')
+			i += 2
+		}
+		runes++
+	}
+	if i >= end {
+		return json.Unmarshal(data, &c.text)
+	}
+	literal := make([]byte, 0, i+1)
+	literal = append(append(literal, data[:i]...), '"')
+	return json.Unmarshal(literal, &c.text)
+}
+
+/**
+ * Whether 4 hex digits of a \u escape are those of a high surrogate (D800-DBFF)
+ */
+func isHighSurrogate(hex []byte) bool {
+	return len(hex) == 4 && (hex[0] == 'd' || hex[0] == 'D') && strings.IndexByte("89abAB", hex[1]) >= 0
+}
+
+/**
+ * Reads a note record, with content only as far as a preview of previewLength characters
+ * (and the title) need; chunked content is read a chunk at a time until it has that much
+ */
+func readNoteHead(tx *bolt.Tx, notebookKey []byte, encodedNote []byte, previewLength int) (Note, error) {
+	head := noteHead{noteRecord: noteRecord{Kind: KindText}, Content: contentHead{limit: previewLength}}
+	if err := json.Unmarshal(encodedNote, &head); err != nil {
+		return Note(head.noteRecord), err
+	}
+	note := Note(head.noteRecord)
+	note.Content = head.Content.text
+	if note.Chunks == nil {
+		return note, nil
+	}
+
+	chunks := noteChunksBucket(tx, notebookKey, note.Id)
+	if chunks == nil {
+		return note, fmt.Errorf("%w: note %d", ErrMissingChunks, note.Id)
+	}
+	var content []byte
+	for i := 0; i < note.Chunks.Count; i++ {
+		if runes := utf8.RuneCount(content); runes >= previewLength && (bytes.IndexByte(content, '\n') >= 0 || runes >= summaryTitleLength) {
+			break
+		}
+		chunk := chunks.Get(itob(uint64(i)))
+		if chunk == nil {
+			return note, fmt.Errorf("%w: chunk %d of note %d", ErrMissingChunks, i, note.Id)
+		}
+		content = append(content, chunk...)
+	}
+	// chunks may end within a character
+	for n := 1; n <= utf8.UTFMax && n <= len(content); n++ {
+		if utf8.RuneStart(content[len(content)-n]) {
+			if !utf8.FullRune(content[len(content)-n:]) {
+				content = content[:len(content)-n]
+			}
+			break
+		}
+	}
+	note.Content = string(content)
+	note.Chunks = nil
+	return note, nil
+}
+
+/**
+ * Summaries of notes (whose content may have been decoded only in part, see readNoteHead)
+ */
+func summarize(notes []Note, previewLength int) []NoteSummary {
+	summaries := make([]NoteSummary, len(notes))
+	for i, note := range notes {
+		summaries[i] = NoteSummary{
+			Id:        note.Id,
+			Title:     preview(note.Title(), summaryTitleLength),
+			Preview:   preview(note.Content, previewLength),
+			Tags:      note.Tags,
+			UpdatedAt: updatedAt(note),
+		}
+	}
+	return summaries
+}
+
+/**
+ * First n characters of content
+ */
+func preview(content string, n int) string {
+	for i := range content {
+		if n == 0 {
+			return content[:i]
+		}
+		n--
+	}
+	return content
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNoteSummaries(t *testing.T) {
+	db := newTestDB(t)
+	long := "Title line\n" + strings.Repeat("é☕\U0001f4dd", 100)
+	mustAddNote(t, db, "work", Note{Content: long, Tags: []string{"a"}})
+	mustAddNote(t, db, "work", Note{Content: "short"})
+	mustAddNote(t, db, "work", Note{Content: "ascii " + strings.Repeat("x", 500)})
+
+	full, err := db.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, previewLength := range []int{1, 2, 7, 11, 12, 13, 100} {
+		summaries, err := db.ListNoteSummaries("work", previewLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != len(full) {
+			t.Fatalf("%d summaries of %d notes", len(summaries), len(full))
+		}
+		for i, summary := range summaries {
+			note := full[i]
+			updated := note.UpdatedAt
+			if updated.IsZero() {
+				updated = note.CreatedAt
+			}
+			if summary.Id != note.Id || strings.Join(summary.Tags, ",") != strings.Join(note.Tags, ",") || !summary.UpdatedAt.Equal(updated) {
+				t.Errorf("summary %+v of note %d", summary, note.Id)
+			}
+			// previews are whole characters, and a prefix of content
+			if !utf8.ValidString(summary.Preview) || utf8.RuneCountInString(summary.Preview) > previewLength ||
+				!strings.HasPrefix(note.Content, summary.Preview) {
+				t.Errorf("preview of %d characters of note %d = %q", previewLength, note.Id, summary.Preview)
+			}
+		}
+	}
+}
+
+// notes listed by the benchmarks below, and the size of their content
+const benchmarkSummaryNotes, benchmarkSummaryContent = 10000, 10 << 10
+
+/**
+ * DB of benchmarkSummaryNotes notes of benchmarkSummaryContent bytes each, loaded outside the timer
+ */
+func openSummaryBenchmarkDB(b *testing.B) *DB {
+	db, cleanup := openBenchmarkDB(b)
+	b.Cleanup(cleanup)
+	b.StopTimer()
+	defer b.StartTimer()
+	body := strings.Repeat("lorem ipsum dolor sit amet, ", benchmarkSummaryContent/28+1)[:benchmarkSummaryContent]
+	contents := make(chan string, 100)
+	go func() {
+		for i := 0; i < benchmarkSummaryNotes; i++ {
+			contents <- "Note " + strconv.Itoa(i) + "\n" + body
+		}
+		close(contents)
+	}()
+	if _, err := db.BulkLoad("big", contents, 1000); err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+func BenchmarkListNotesFull10k(b *testing.B) {
+	db := openSummaryBenchmarkDB(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		notes, err := db.ListNotes("big")
+		if err != nil || len(notes) != benchmarkSummaryNotes {
+			b.Fatalf("%d notes listed (%v)", len(notes), err)
+		}
+	}
+}
+
+func BenchmarkListNoteSummaries10k(b *testing.B) {
+	db := openSummaryBenchmarkDB(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		summaries, err := db.ListNoteSummaries("big", DefaultPreviewLength)
+		if err != nil || len(summaries) != benchmarkSummaryNotes {
+			b.Fatalf("%d summaries listed (%v)", len(summaries), err)
+		}
+	}
+}
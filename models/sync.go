@@ -0,0 +1,284 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Merging notes written on another device, from a copy of its DB file (like one kept in a shared folder)
+ *  - notes are told apart across DBs by fingerprint (see fingerprints.go), within notebooks of the same
+ *    name; notes of the other DB without one (not backfilled, see BackfillFingerprints) are left out
+ *  - copies of a note are compared by their clocks (see clock.go): the local copy is kept if it's newer,
+ *    and replaced by the other (previous content going to history) if that's newer; copies edited
+ *    concurrently are merged by SyncOptions.Resolver, or else into a conflict note holding both contents
+ *    between markers (see conflictMarkers), tagged 'conflict'
+ *  - merged notes get a clock newer than both copies, so that syncing them back doesn't conflict again
+ *  - notes the DB lacks are created, keeping their clock; deletes and attachments aren't synced
+ *  - syncing is one way: devices sync both ways by each syncing from the other's file
+ *  - syncing again from a device only reads the notes it changed since, if its changelog tells (see sync_state.go)
+ */
+
+/**
+ * Options of SyncFrom
+ */
+type SyncOptions struct {
+	// only sync these notebooks (all of the other DB's if empty)
+	Notebooks []string
+	// merges copies of a note edited concurrently (given copies of both), returning the note to keep;
+	// content, tags, title and kind are taken from it
+	Resolver func(local, remote Note) (Note, error)
+}
+
+/**
+ * What SyncFrom did, counting notes of the other DB by how they compared to the local ones
+ *  - Merged are the (local) notes edited concurrently on both devices, merged by the resolver or
+ *    into a conflict note
+ */
+type SyncReport struct {
+	Created     int           `json:"created"`
+	RemoteNewer int           `json:"remote_newer"`
+	LocalNewer  int           `json:"local_newer"`
+	Unchanged   int           `json:"unchanged"`
+	Merged      []NoteRef     `json:"merged,omitempty"`
+	Skipped     []SkippedNote `json:"skipped,omitempty"`
+	// notes of the other DB read, and whether only those it changed since the last sync were (from changes
+	// after FromSeq); Fallback tells why everything was read instead, if the DB synced from it before
+	Read        int    `json:"read"`
+	Incremental bool   `json:"incremental"`
+	FromSeq     uint64 `json:"from_seq,omitempty"`
+	Fallback    string `json:"fallback,omitempty"`
+}
+
+/**
+ * Markers a conflict note puts around the contents of both copies of a note
+ */
+var conflictMarkers = [3]string{"<<<<<<< this device", "=======", ">>>>>>> other device"}
+
+/**
+ * Merges notes of another DB file into this one (see above); the file is opened read-only
+ * Fails with a *DatabaseLockedError if another process has the file open, and with ErrEncryptionLocked
+ * if its content is encrypted
+ * param: string      path
+ * param: SyncOptions opts
+ * return: (SyncReport, error) Report covers the notebooks synced before an error (if any)
+ */
+func (db *DB) SyncFrom(path string, opts SyncOptions) (SyncReport, error) {
+	defer db.markBusy()()
+	var report SyncReport
+	other, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: DefaultOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return report, &DatabaseLockedError{Path: path, Holder: readLockInfo(path)}
+	}
+	if err != nil {
+		return report, err
+	}
+	defer other.Close()
+
+	err = other.View(func(otherTx *bolt.Tx) error {
+		plan, err := db.planSync(otherTx, path)
+		if err != nil {
+			return err
+		}
+		report.Incremental, report.FromSeq, report.Fallback = plan.peer.Incremental, plan.fromSeq, plan.fallback
+		rootBucket := otherTx.Bucket([]byte("Notebook"))
+		if rootBucket == nil {
+			return nil
+		}
+		var notebookKeys [][]byte
+		if err := rootBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				notebookKeys = append(notebookKeys, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, notebookKey := range notebookKeys {
+			notebookName := notebookDisplayName(otherTx, notebookKey)
+			if len(opts.Notebooks) > 0 && !db.containsNotebook(opts.Notebooks, notebookName) {
+				continue
+			}
+			var notes []Note
+			readRecord := func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				// (notebooks encrypted on their own, as in a copy of this DB, are read with the keys unlocked here)
+				note, err := readNoteRecord(otherTx, contentSealer{keys: &db.notebookKeys}, notebookKey, v)
+				if err != nil {
+					return err
+				}
+				notes = append(notes, note)
+				return nil
+			}
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if plan.changed == nil {
+				if err := notebookBucket.ForEach(readRecord); err != nil {
+					return err
+				}
+			}
+			for _, noteId := range plan.changed[notebookName] {
+				noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+				// (notes deleted since are gone)
+				if err := readRecord(noteIdBytes, notebookBucket.Get(noteIdBytes)); err != nil {
+					return err
+				}
+			}
+			if len(notes) == 0 {
+				continue
+			}
+			report.Read += len(notes)
+			if err := db.Update(func(tx *bolt.Tx) error {
+				return db.syncNotebook(tx, notebookName, notes, opts, &report)
+			}); err != nil {
+				return err
+			}
+		}
+		if plan.peer.Device == "" || len(opts.Notebooks) > 0 {
+			return nil
+		}
+		plan.peer.SyncedAt = time.Now()
+		return db.Update(func(tx *bolt.Tx) error {
+			return putPeerState(tx, plan.peer)
+		})
+	})
+	return report, err
+}
+
+/**
+ * Merges notes of a notebook of the other DB into the notebook of the same name (created if need be)
+ */
+func (db *DB) syncNotebook(tx *bolt.Tx, notebookName string, notes []Note, opts SyncOptions, report *SyncReport) error {
+	notebookKey := db.notebookKey(notebookName)
+	if notebookArchived(tx, notebookKey) {
+		for _, note := range notes {
+			report.Skipped = append(report.Skipped, SkippedNote{Title: note.Title(), Reason: ErrNotebookArchived.Error()})
+		}
+		return nil
+	}
+	for _, remote := range notes {
+		if remote.Fingerprint == "" {
+			report.Skipped = append(report.Skipped, SkippedNote{Title: remote.Title(), Reason: "note has no fingerprint"})
+			continue
+		}
+		localId, found := lookupFingerprint(tx, notebookKey, remote.Fingerprint)
+		if !found {
+			if err := db.syncCreate(tx, notebookName, remote); err != nil {
+				return err
+			}
+			report.Created++
+			continue
+		}
+		_, local, err := db.getNoteInTx(tx, notebookName, localId)
+		if err != nil {
+			return err
+		}
+
+		order := CompareClocks(local.Clock, remote.Clock)
+		if order == ClockEqual && local.Content != remote.Content {
+			// (only copies written before clocks existed can differ without their clocks telling)
+			order = ClockConcurrent
+		}
+		switch order {
+		case ClockEqual:
+			report.Unchanged++
+		case ClockNewer:
+			report.LocalNewer++
+		case ClockOlder:
+			if err := db.syncReplace(tx, notebookName, local, remote, remote, mergeClocks(local.Clock, remote.Clock)); err != nil {
+				return err
+			}
+			report.RemoteNewer++
+		case ClockConcurrent:
+			merged := conflictNote(local, remote)
+			if opts.Resolver != nil {
+				if merged, err = opts.Resolver(copyNote(local), copyNote(remote)); err != nil {
+					return fmt.Errorf("resolving note %d of notebook '%s': %w", local.Id, notebookName, err)
+				}
+			}
+			// (a write of this device, on top of both copies)
+			clock := mergeClocks(local.Clock, remote.Clock).tick(db.deviceId)
+			if err := db.syncReplace(tx, notebookName, local, merged, remote, clock); err != nil {
+				return err
+			}
+			report.Merged = append(report.Merged, NoteRef{Notebook: notebookName, Id: local.Id})
+		}
+	}
+	return nil
+}
+
+/**
+ * Creates a note of the other DB, keeping its clock (an empty one, for notes without)
+ */
+func (db *DB) syncCreate(tx *bolt.Tx, notebookName string, remote Note) error {
+	if remote.Clock == nil {
+		remote.Clock = VectorClock{}
+	}
+	batch := preparedAdd{notebookName: notebookName, notes: []Note{remote}, skipDefaults: true}
+	var err error
+	if batch.prepared, err = prepareNotes(batch.notes, NotebookDefaults{}, db.encodingFor(tx, db.notebookKey(notebookName))); err != nil {
+		return err
+	}
+	_, err = db.commitAdd(tx, batch)
+	return err
+}
+
+/**
+ * Replaces a local note by given one (content, tags, title and kind; everything of it if it's the other
+ * DB's copy), giving it clock
+ */
+func (db *DB) syncReplace(tx *bolt.Tx, notebookName string, local Note, note Note, remote Note, clock VectorClock) error {
+	updated := local
+	if note.Content != local.Content {
+		var err error
+		if updated, err = db.updateNoteInTx(tx, notebookName, local.Id, note.Content, true); err != nil {
+			return err
+		}
+	} else {
+		updated.Revision++
+	}
+	updated.Tags = note.Tags
+	updated.TitleText, updated.TitleInferred = note.TitleText, note.TitleInferred
+	updated.Kind = note.Kind
+	if note.Content == remote.Content && CompareClocks(clock, remote.Clock) == ClockEqual {
+		updated.ReadOnly, updated.ExpiresAt = remote.ReadOnly, remote.ExpiresAt
+		if !remote.UpdatedAt.IsZero() {
+			updated.UpdatedAt = remote.UpdatedAt
+		}
+	}
+	updated.Clock = clock
+	return db.putNote(tx, db.notebookKey(notebookName), updated)
+}
+
+/**
+ * Note holding the contents of both copies of a note edited concurrently, between conflict markers,
+ * with the tags of both (and 'conflict')
+ */
+func conflictNote(local, remote Note) Note {
+	merged := copyNote(local)
+	merged.Content = strings.Join([]string{conflictMarkers[0], local.Content, conflictMarkers[1], remote.Content,
+		conflictMarkers[2]}, "\n")
+	for _, tag := range append(append([]string(nil), remote.Tags...), "conflict") {
+		if !containsString(merged.Tags, tag) {
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+	return merged
+}
+
+/**
+ * Whether given names include a notebook (as its name is looked up, see SetCaseInsensitiveNotebooks)
+ */
+func (db *DB) containsNotebook(names []string, notebookName string) bool {
+	for _, name := range names {
+		if string(db.notebookKey(name)) == string(db.notebookKey(notebookName)) {
+			return true
+		}
+	}
+	return false
+}
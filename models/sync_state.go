@@ -0,0 +1,222 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Syncing again from a DB synced from before only reads the notes it changed since (see SyncFrom)
+ *  - after syncing from a DB (all of its notebooks), the DB records in 'SyncState' bucket, by the other DB's
+ *    device id, the sequence of the last change of the other DB's outbox (see outbox.go) it had seen
+ *  - the next sync from that device reads the changes recorded since (see ChangesSince), and only the notes
+ *    they're about, rather than every note
+ *  - everything is compared instead (and the reason reported) when the other DB's changelog can't tell
+ *    all that changed: its outbox is off, was turned off and on since, or no longer holds every change
+ *    since the last sync (processed by ProcessOutbox, or the file was restored from an older copy)
+ *  - syncs of some notebooks only read changes like any other, but don't record where they got to
+ * 'SyncState' bucket: device id -> JSON PeerState
+ */
+
+/**
+ * Returned by ChangesSince when the outbox no longer holds every change since given sequence
+ */
+var ErrChangelogTruncated = errors.New("changelog truncated")
+
+/**
+ * What the DB knows of a device it synced from
+ *  - Seq is the sequence of the last change of the device's outbox seen (see ChangeEvent), and Epoch the
+ *    times its outbox had been turned on then
+ *  - Incremental tells whether the last sync only read changes since the one before
+ */
+type PeerState struct {
+	Device      string    `json:"device"`
+	Path        string    `json:"path"`
+	Seq         uint64    `json:"seq"`
+	Epoch       uint64    `json:"epoch"`
+	SyncedAt    time.Time `json:"synced_at"`
+	Incremental bool      `json:"incremental"`
+}
+
+/**
+ * Changes recorded in the outbox after given sequence, in order
+ * Fails with ErrChangelogTruncated if some of them are no longer there (like events processed by ProcessOutbox)
+ * param: uint64 seq
+ * return: ([]ChangeEvent, error)
+ */
+func (db *DB) ChangesSince(seq uint64) ([]ChangeEvent, error) {
+	var events []ChangeEvent
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		events, err = changesSinceInTx(tx, seq)
+		return err
+	})
+	return events, err
+}
+
+func changesSinceInTx(tx *bolt.Tx, seq uint64) ([]ChangeEvent, error) {
+	bucket := tx.Bucket([]byte("Outbox"))
+	if bucket == nil {
+		if seq > 0 {
+			return nil, fmt.Errorf("%w: no changes recorded (up to %d expected)", ErrChangelogTruncated, seq)
+		}
+		return nil, nil
+	}
+	if last := bucket.Sequence(); last < seq {
+		return nil, fmt.Errorf("%w: last change is %d, before %d", ErrChangelogTruncated, last, seq)
+	}
+	cursor := bucket.Cursor()
+	first, _ := cursor.First()
+	switch {
+	case first == nil && bucket.Sequence() > seq:
+		return nil, fmt.Errorf("%w: changes %d to %d are gone", ErrChangelogTruncated, seq+1, bucket.Sequence())
+	case first != nil && binary.BigEndian.Uint64(first) > seq+1:
+		return nil, fmt.Errorf("%w: changes %d to %d are gone", ErrChangelogTruncated, seq+1, binary.BigEndian.Uint64(first)-1)
+	}
+	var events []ChangeEvent
+	for k, v := cursor.Seek(itob(seq + 1)); k != nil; k, v = cursor.Next() {
+		var event ChangeEvent
+		if err := json.Unmarshal(v, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+/**
+ * Lists the devices the DB synced from, most recently synced first
+ * return: ([]PeerState, error)
+ */
+func (db *DB) SyncStatus() ([]PeerState, error) {
+	var peers []PeerState
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("SyncState"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var peer PeerState
+			if err := json.Unmarshal(v, &peer); err != nil {
+				return err
+			}
+			peers = append(peers, peer)
+			return nil
+		})
+	})
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].SyncedAt.After(peers[j].SyncedAt)
+	})
+	return peers, err
+}
+
+func getPeerState(tx *bolt.Tx, device string) (PeerState, bool, error) {
+	var peer PeerState
+	bucket := tx.Bucket([]byte("SyncState"))
+	if bucket == nil {
+		return peer, false, nil
+	}
+	encoded := bucket.Get([]byte(device))
+	if encoded == nil {
+		return peer, false, nil
+	}
+	return peer, true, json.Unmarshal(encoded, &peer)
+}
+
+func putPeerState(tx *bolt.Tx, peer PeerState) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("SyncState"))
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(peer.Device), encoded)
+}
+
+/**
+ * How to sync from another DB (see above)
+ *  - changed are the ids of the notes it changed since FromSeq, by notebook name; nil to compare everything,
+ *    fallback telling why (unless the DB never synced from it)
+ *  - peer is the state to record once synced
+ */
+type syncPlan struct {
+	changed  map[string][]uint64
+	fromSeq  uint64
+	fallback string
+	peer     PeerState
+}
+
+func (db *DB) planSync(otherTx *bolt.Tx, path string) (syncPlan, error) {
+	var plan syncPlan
+	settings, err := getSettings(otherTx)
+	if err != nil {
+		return plan, err
+	}
+	plan.peer = PeerState{Device: getDeviceId(otherTx), Path: path, Epoch: settings.OutboxEpoch}
+	if bucket := otherTx.Bucket([]byte("Outbox")); bucket != nil {
+		plan.peer.Seq = bucket.Sequence()
+	}
+	var last PeerState
+	var known bool
+	if err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		last, known, err = getPeerState(tx, plan.peer.Device)
+		return err
+	}); err != nil || !known || plan.peer.Device == "" {
+		return plan, err
+	}
+
+	switch {
+	case !settings.Outbox:
+		plan.fallback = "the other DB doesn't record changes (its outbox is off)"
+		return plan, nil
+	case settings.OutboxEpoch != last.Epoch:
+		plan.fallback = "the other DB's outbox was turned on (again) since the last sync"
+		return plan, nil
+	}
+	events, err := changesSinceInTx(otherTx, last.Seq)
+	if errors.Is(err, ErrChangelogTruncated) {
+		plan.fallback = fmt.Sprintf("the other DB's %v", err)
+		return plan, nil
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	names := make(map[string]bool)
+	if rootBucket := otherTx.Bucket([]byte("Notebook")); rootBucket != nil {
+		rootBucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				names[notebookDisplayName(otherTx, k)] = true
+			}
+			return nil
+		})
+	}
+	changed := make(map[string][]uint64)
+	seen := make(map[NoteRef]bool)
+	for _, event := range events {
+		if event.Kind == ChangeDeleted {
+			// (deletes aren't synced)
+			continue
+		}
+		if !names[event.Notebook] {
+			// (renamed or deleted since: its notes can't be told by the changelog)
+			plan.fallback = fmt.Sprintf("the other DB's changelog names notebook '%s' it no longer has", event.Notebook)
+			return plan, nil
+		}
+		if ref := (NoteRef{Notebook: event.Notebook, Id: event.NoteId}); !seen[ref] {
+			seen[ref] = true
+			changed[event.Notebook] = append(changed[event.Notebook], event.NoteId)
+		}
+	}
+	plan.changed, plan.fromSeq, plan.peer.Incremental = changed, last.Seq, true
+	return plan, nil
+}
@@ -0,0 +1,185 @@
+package models_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * Writes a copy of a DB's file, like the one a device keeps in a shared folder, returning its path
+ */
+func deviceCopy(t *testing.T, db *models.DB, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".db")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := db.Backup(file); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/**
+ * DB recording its changes in the outbox, with a notebook 'work' of n notes
+ */
+func newSyncingDB(t *testing.T, n int) (*models.DB, []uint64) {
+	t.Helper()
+	db := notestest.NewDB(t)
+	if err := db.EnableOutbox(true); err != nil {
+		t.Fatal(err)
+	}
+	contents := make([]string, n)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("note %d", i+1)
+	}
+	return db, notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"work": contents}})["work"]
+}
+
+func mustSync(t *testing.T, db *models.DB, path string) models.SyncReport {
+	t.Helper()
+	report, err := db.SyncFrom(path, models.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func mustUpdate(t *testing.T, db *models.DB, notebookName string, noteId uint64, content string) {
+	t.Helper()
+	if _, err := db.UpdateNote(notebookName, noteId, content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalSyncs(t *testing.T) {
+	phone, phoneIds := newSyncingDB(t, 100)
+	laptop := notestest.NewDB(t)
+	if err := laptop.EnableOutbox(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first sync from the phone reads everything
+	first := mustSync(t, laptop, deviceCopy(t, phone, "phone"))
+	if first.Incremental || first.Read != 100 || first.Created != 100 || first.Fallback != "" {
+		t.Fatalf("first sync %+v, want all 100 notes read and created", first)
+	}
+	laptopIds := make(map[string]uint64)
+	notes, err := laptop.ListNotes("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, note := range notes {
+		laptopIds[note.Content] = note.Id
+	}
+
+	// edits on both sides, the phone syncing from the laptop in between
+	mustUpdate(t, phone, "work", phoneIds[0], "note 1, edited on the phone")
+	mustUpdate(t, phone, "work", phoneIds[1], "note 2, edited on the phone")
+	notestest.MustAdd(t, phone, "work", "written on the phone")
+	mustUpdate(t, laptop, "work", laptopIds["note 50"], "note 50, edited on the laptop")
+	notestest.MustAdd(t, laptop, "work", "written on the laptop")
+	if back := mustSync(t, phone, deviceCopy(t, laptop, "laptop")); back.Incremental || back.Created != 1 || back.RemoteNewer != 1 {
+		t.Errorf("phone's first sync from the laptop %+v, want the laptop's note created and its edit taken", back)
+	}
+	// the second reads the phone's changes since the first: its own, and the two it took from the laptop
+	second := mustSync(t, laptop, deviceCopy(t, phone, "phone"))
+	if !second.Incremental || second.Read != 5 || second.RemoteNewer != 2 || second.Created != 1 || second.Unchanged != 2 || second.Fallback != "" {
+		t.Errorf("second sync %+v, want the 5 notes changed on the phone read", second)
+	}
+
+	// the third reads only what changed since the second: the laptop's edit the phone took, and the phone's own
+	mustUpdate(t, laptop, "work", laptopIds["note 60"], "note 60, edited on the laptop")
+	if back := mustSync(t, phone, deviceCopy(t, laptop, "laptop")); !back.Incremental || back.RemoteNewer != 1 {
+		t.Errorf("phone's second sync from the laptop %+v, want the edit of note 60 taken", back)
+	}
+	mustUpdate(t, phone, "work", phoneIds[2], "note 3, edited on the phone")
+	third := mustSync(t, laptop, deviceCopy(t, phone, "phone"))
+	if !third.Incremental || third.FromSeq <= second.FromSeq || third.Fallback != "" {
+		t.Errorf("third sync %+v, want it incremental from where the second got to", third)
+	}
+	if third.Read != 2 || third.RemoteNewer != 1 || third.Unchanged != 1 || third.Created != 0 {
+		t.Errorf("third sync %+v, want only the 2 notes changed on the phone since the second read", third)
+	}
+	changes, err := phone.ChangesSince(third.FromSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := make(map[uint64]bool)
+	for _, change := range changes {
+		changed[change.NoteId] = true
+	}
+	if len(changed) != 2 || !changed[phoneIds[2]] {
+		t.Errorf("changes on the phone since the second sync %+v, want changes of the 2 notes read", changes)
+	}
+	if note, _ := laptop.GetNote("work", laptopIds["note 3"]); note.Content != "note 3, edited on the phone" {
+		t.Errorf("note 3 on the laptop is %q", note.Content)
+	}
+	if note, _ := laptop.GetNote("work", laptopIds["note 60"]); note.Content != "note 60, edited on the laptop" {
+		t.Errorf("note 60 on the laptop is %q", note.Content)
+	}
+
+	peers, err := laptop.SyncStatus()
+	if err != nil || len(peers) != 1 {
+		t.Fatalf("laptop's peers %+v (%v)", peers, err)
+	}
+	if all, _ := phone.ChangesSince(0); !peers[0].Incremental || peers[0].Seq != all[len(all)-1].Seq || peers[0].SyncedAt.IsZero() {
+		t.Errorf("laptop's state of the phone %+v, want it at the phone's last change (%d)", peers[0], all[len(all)-1].Seq)
+	}
+}
+
+func TestSyncFallsBackOnTruncatedChangelog(t *testing.T) {
+	phone, ids := newSyncingDB(t, 20)
+	laptop := notestest.NewDB(t)
+	mustSync(t, laptop, deviceCopy(t, phone, "phone"))
+	seq := func() uint64 {
+		changes, err := phone.ChangesSince(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes[len(changes)-1].Seq
+	}()
+
+	// the phone's outbox is processed (dropping events) and a note edited
+	if _, err := phone.ProcessOutbox(func(models.ChangeEvent) error { return nil }, 1000); err != nil {
+		t.Fatal(err)
+	}
+	mustUpdate(t, phone, "work", ids[4], "note 5, edited")
+	mustUpdate(t, phone, "work", ids[5], "note 6, edited")
+	if _, err := phone.ProcessOutbox(func(models.ChangeEvent) error { return nil }, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := phone.ChangesSince(seq); !errors.Is(err, models.ErrChangelogTruncated) {
+		t.Fatalf("changes since %d with one of them processed: %v, want ErrChangelogTruncated", seq, err)
+	}
+	report := mustSync(t, laptop, deviceCopy(t, phone, "phone"))
+	if report.Incremental || report.Read != 20 || report.RemoteNewer != 2 || !strings.Contains(report.Fallback, "changelog truncated") {
+		t.Errorf("sync past a truncated changelog %+v, want everything compared and the reason reported", report)
+	}
+
+	// once compared again, syncs are incremental again
+	mustUpdate(t, phone, "work", ids[6], "note 7, edited")
+	if report := mustSync(t, laptop, deviceCopy(t, phone, "phone")); !report.Incremental || report.Read != 1 {
+		t.Errorf("sync after the fallback %+v, want 1 note read", report)
+	}
+
+	// while one after the phone's outbox was turned off and on compares everything again
+	if err := phone.EnableOutbox(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := phone.EnableOutbox(true); err != nil {
+		t.Fatal(err)
+	}
+	if report := mustSync(t, laptop, deviceCopy(t, phone, "phone")); report.Incremental || !strings.Contains(report.Fallback, "turned on") {
+		t.Errorf("sync after the outbox was turned on again %+v", report)
+	}
+}
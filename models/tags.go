@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Number of notes changed per write transaction by TagMatching
+ */
+const bulkTagBatch = 200
+
+/**
+ * Returned by TagMatching for tag changes that don't make sense (like no tags at all, or a
+ * tag both added and removed)
+ */
+var ErrInvalidTagChange = errors.New("invalid tag change")
+
+/**
+ * Outcome of TagMatching, by note id
+ *  - Modified: notes whose tags changed (or would change, on a dry run)
+ *  - Unchanged: notes already having the tags added and none of the tags removed
+ *  - Untagged: notes among Modified left without any tag
+ *  - Locked: notes locked read-only, left alone
+ */
+type BulkTagReport struct {
+	Notebook  string   `json:"notebook"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+	Modified  []uint64 `json:"modified"`
+	Unchanged []uint64 `json:"unchanged"`
+	Untagged  []uint64 `json:"untagged"`
+	Locked    []uint64 `json:"locked"`
+}
+
+/**
+ * Adds and removes tags on every note matching a query
+ *  - notes are changed in write transactions of bulkTagBatch notes each, so that a large result
+ *    doesn't hold up writers for long; notes are read afresh in them, those gone by then being skipped
+ *  - notes have no tag index (see Query), so there is nothing to update besides the notes themselves
+ * param: *Query   q
+ * param: []string addTags
+ * param: []string removeTags
+ * param: bool     dryRun Whether to only report what would change
+ * return: (BulkTagReport, error) Report covers the batches committed before an error (if any)
+ */
+func (db *DB) TagMatching(q *Query, addTags []string, removeTags []string, dryRun bool) (BulkTagReport, error) {
+	report := BulkTagReport{Notebook: q.notebookName, DryRun: dryRun}
+	if len(addTags) == 0 && len(removeTags) == 0 {
+		return report, fmt.Errorf("%w: no tags to add or remove", ErrInvalidTagChange)
+	}
+	for _, tag := range append(append([]string(nil), addTags...), removeTags...) {
+		if tag == "" {
+			return report, fmt.Errorf("%w: empty tag", ErrInvalidTagChange)
+		}
+		if containsString(addTags, tag) && containsString(removeTags, tag) {
+			return report, fmt.Errorf("%w: '%s' is both added and removed", ErrInvalidTagChange, tag)
+		}
+	}
+
+	matches, _, err := q.Execute()
+	if err != nil {
+		return report, err
+	}
+	if dryRun {
+		for _, note := range matches {
+			report.add(note, retag(note.Tags, addTags, removeTags))
+		}
+		return report, nil
+	}
+
+	for start := 0; start < len(matches); start += bulkTagBatch {
+		end := start + bulkTagBatch
+		if end > len(matches) {
+			end = len(matches)
+		}
+		var batch BulkTagReport
+		err := db.Update(func(tx *bolt.Tx) error {
+			batch = BulkTagReport{}
+			if err := db.checkNotArchived(tx, q.notebookName); err != nil {
+				return err
+			}
+			for _, match := range matches[start:end] {
+				_, note, err := db.getNoteInTx(tx, q.notebookName, match.Id)
+				if errors.Is(err, ErrNoteNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				tags := retag(note.Tags, addTags, removeTags)
+				if !batch.add(note, tags) {
+					continue
+				}
+				note.Tags = tags
+				db.bumpRevision(&note)
+				if err := db.putNote(tx, db.notebookKey(q.notebookName), note); err != nil {
+					return fmt.Errorf("tagging note %d: %w", note.Id, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+		report.Modified = append(report.Modified, batch.Modified...)
+		report.Unchanged = append(report.Unchanged, batch.Unchanged...)
+		report.Untagged = append(report.Untagged, batch.Untagged...)
+		report.Locked = append(report.Locked, batch.Locked...)
+	}
+	return report, nil
+}
+
+/**
+ * Records the outcome for a note getting given tags
+ * return: bool Whether the note is to be written
+ */
+func (r *BulkTagReport) add(note Note, tags []string) bool {
+	switch {
+	case sameStrings(note.Tags, tags):
+		r.Unchanged = append(r.Unchanged, note.Id)
+		return false
+	case note.ReadOnly:
+		r.Locked = append(r.Locked, note.Id)
+		return false
+	}
+	r.Modified = append(r.Modified, note.Id)
+	if len(tags) == 0 {
+		r.Untagged = append(r.Untagged, note.Id)
+	}
+	return true
+}
+
+/**
+ * Tags without removeTags, followed by those of addTags missing (keeping the order of both)
+ */
+func retag(tags []string, addTags []string, removeTags []string) []string {
+	var retagged []string
+	for _, tag := range tags {
+		if !containsString(removeTags, tag) {
+			retagged = append(retagged, tag)
+		}
+	}
+	for _, tag := range addTags {
+		if !containsString(retagged, tag) {
+			retagged = append(retagged, tag)
+		}
+	}
+	return retagged
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,441 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Takeouts are exports of all notebooks to a directory, to keep or to move elsewhere (see Takeout)
+ *  - every notebook goes to a file of its own under 'notebooks/', named after it (escaped as by
+ *    url.PathEscape): either an export as written by ExportNotebook ('<notebook>.json', carrying
+ *    attachments), or markdown to read ('<notebook>.md', attachments going to files
+ *    'attachments/<notebook>/<note id>/<name>')
+ *  - 'manifest.json' lists every file along with its SHA-256, and is written last: a takeout
+ *    interrupted midway has none
+ *  - notebooks are written by several workers at once, each notebook (with its attachments) being read in a
+ *    single transaction (see fanout.go); files are listed in the manifest in order of notebooks all the same
+ *  - JSON takeouts can be restored (see RestoreTakeout), whole or in part; notes get fresh ids, so
+ *    relations between notes of different notebooks are lost
+ */
+
+/**
+ * Version of the format of takeout manifests
+ */
+const TakeoutFormat = 1
+
+const takeoutManifestFile = "manifest.json"
+
+// returned by RestoreTakeout for directories that don't hold a takeout it can restore,
+// and for takeouts whose files are missing or don't match the manifest
+var ErrInvalidTakeout = errors.New("not a valid takeout")
+
+/**
+ * Options of Takeout
+ */
+type TakeoutOptions struct {
+	// "json" (the default, which can be restored) or "markdown"
+	Format string
+	// only take out these notebooks (all of them if empty)
+	Notebooks []string
+	// if set, notes of JSON takeouts are passed through it before they're written (see transform.go);
+	// it's called from several goroutines at once
+	Render RenderFunc
+}
+
+/**
+ * Contents of a takeout's 'manifest.json'
+ *  - NoteExportFormat and NotebookExportFormat are the versions notebook files of a JSON takeout are written in
+ *  - TxId is the id of the latest transaction notebooks were read in
+ */
+type TakeoutManifest struct {
+	Format               int           `json:"format"`
+	ExportFormat         string        `json:"export_format"`
+	NoteExportFormat     int           `json:"note_export_format,omitempty"`
+	NotebookExportFormat int           `json:"notebook_export_format,omitempty"`
+	ExportedAt           time.Time     `json:"exported_at"`
+	TxId                 int           `json:"tx_id"`
+	Files                []TakeoutFile `json:"files"`
+}
+
+/**
+ * A file of a takeout
+ *  - Path is relative to the takeout directory, with slashes
+ *  - Kind is "notebook" or "attachment"; Notes is the number of notes of notebook files
+ */
+type TakeoutFile struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Notebook string `json:"notebook"`
+	Archived bool   `json:"archived,omitempty"`
+	Notes    int    `json:"notes,omitempty"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+/**
+ * What Takeout wrote (the manifest, written to 'manifest.json')
+ */
+type TakeoutReport struct {
+	Dir      string          `json:"dir"`
+	Manifest TakeoutManifest `json:"manifest"`
+}
+
+/**
+ * What RestoreTakeout imported, by notebook
+ */
+type RestoreReport struct {
+	Notebooks []RestoredNotebook `json:"notebooks"`
+}
+
+type RestoredNotebook struct {
+	Notebook string `json:"notebook"`
+	ImportReport
+}
+
+/**
+ * Writes a takeout of notebooks (archived ones included) to given directory, created if need be
+ * (see above); files a previous takeout left in it are overwritten
+ * param: string         dir
+ * param: TakeoutOptions opts
+ * return: (TakeoutReport, error)
+ */
+func (db *DB) Takeout(dir string, opts TakeoutOptions) (TakeoutReport, error) {
+	return db.TakeoutContext(context.Background(), dir, opts)
+}
+
+/**
+ * Same as Takeout, stopping once ctx is cancelled (failing with ctx's error, and leaving no manifest)
+ * param: context.Context ctx
+ * param: string          dir
+ * param: TakeoutOptions  opts
+ * return: (TakeoutReport, error)
+ */
+func (db *DB) TakeoutContext(ctx context.Context, dir string, opts TakeoutOptions) (TakeoutReport, error) {
+	report := TakeoutReport{Dir: dir}
+	switch opts.Format {
+	case "":
+		opts.Format = "json"
+	case "json", "markdown":
+	default:
+		return report, fmt.Errorf("unknown takeout format '%s'", opts.Format)
+	}
+	manifest := TakeoutManifest{Format: TakeoutFormat, ExportFormat: opts.Format, ExportedAt: time.Now()}
+	if opts.Format == "json" {
+		manifest.NoteExportFormat = NoteExportFormat
+		manifest.NotebookExportFormat = NotebookExportFormat
+	}
+
+	// (a takeout interrupted midway must not be taken for the one it overwrites)
+	if err := os.Remove(filepath.Join(dir, takeoutManifestFile)); err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	var notebookNames []string
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookNames = notebookNamesInTx(tx, true)
+		if len(opts.Notebooks) > 0 {
+			notebookNames = nil
+			for _, notebookName := range opts.Notebooks {
+				notebookKey := db.notebookKey(notebookName)
+				if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+					return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+				}
+				notebookNames = append(notebookNames, notebookDisplayName(tx, notebookKey))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// (files are kept by notebook, so that workers share nothing)
+	files := make([][]TakeoutFile, len(notebookNames))
+	txIds := make([]int, len(notebookNames))
+	err = db.fanOutNotebooks(ctx, notebookNames, func(tx *bolt.Tx, i int, notebookName string) error {
+		txIds[i] = tx.ID()
+		notebookKey := db.notebookKey(notebookName)
+		file := TakeoutFile{
+			Kind:     "notebook",
+			Notebook: notebookName,
+			Archived: notebookArchived(tx, notebookKey),
+		}
+		var err error
+		if opts.Format == "json" {
+			file.Path = path.Join("notebooks", url.PathEscape(notebookName)+".json")
+			err = writeTakeoutFile(ctx, dir, &file, func(w io.Writer) (err error) {
+				file.Notes, err = db.exportNotebookInTx(tx, notebookKey, NoteFilter{IncludeExpired: true, Render: opts.Render}, w)
+				return err
+			})
+		} else {
+			file.Path = path.Join("notebooks", url.PathEscape(notebookName)+".md")
+			var attachments []TakeoutFile
+			err = writeTakeoutFile(ctx, dir, &file, func(w io.Writer) (err error) {
+				file.Notes, attachments, err = db.writeMarkdownTakeout(ctx, tx, dir, notebookKey, w)
+				return err
+			})
+			files[i] = append(files[i], attachments...)
+		}
+		files[i] = append(files[i], file)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+	for i := range notebookNames {
+		manifest.Files = append(manifest.Files, files[i]...)
+		if txIds[i] > manifest.TxId {
+			manifest.TxId = txIds[i]
+		}
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return report, err
+	}
+	report.Manifest = manifest
+	return report, writeFileAtomic(filepath.Join(dir, takeoutManifestFile), append(encoded, '\n'))
+}
+
+/**
+ * Writes the notes of a notebook as markdown, and their attachments to files of their own
+ * return: (int, []TakeoutFile, error) Number of notes written, and attachment files
+ */
+func (db *DB) writeMarkdownTakeout(ctx context.Context, tx *bolt.Tx, dir string, notebookKey []byte, w io.Writer) (int, []TakeoutFile, error) {
+	notebookName := notebookDisplayName(tx, notebookKey)
+	if _, err := fmt.Fprintf(w, "# %s\n", notebookName); err != nil {
+		return 0, nil, err
+	}
+	notes := 0
+	var files []TakeoutFile
+	err := db.forEachMatchingNote(tx, notebookKey, NoteFilter{IncludeExpired: true}, func(note Note) error {
+		notes++
+		fmt.Fprintf(w, "\n## %s\n\n", EscapeControlChars(note.Title()))
+		fmt.Fprintf(w, "id: %d, created: %s", note.Id, note.CreatedAt.Format(time.RFC3339))
+		if !note.UpdatedAt.IsZero() {
+			fmt.Fprintf(w, ", updated: %s", note.UpdatedAt.Format(time.RFC3339))
+		}
+		if len(note.Tags) > 0 {
+			fmt.Fprintf(w, ", tags: %s", strings.Join(note.Tags, ", "))
+		}
+		// (unlike mirror files, takeouts are for reading: control characters are escaped)
+		if _, err := fmt.Fprintf(w, "\n\n%s", EscapeControlChars(renderMirrorFile(note))); err != nil {
+			return err
+		}
+
+		attachments, err := listAttachmentsInTx(tx, notebookKey, note.Id)
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			content := readBlob(tx, attachment.Hash)
+			if content == nil {
+				return fmt.Errorf("%w: blob %s of attachment '%s'", ErrMissingChunks, attachment.Hash, attachment.Name)
+			}
+			file := TakeoutFile{
+				Path: path.Join("attachments", url.PathEscape(notebookName), strconv.FormatUint(note.Id, 10),
+					url.PathEscape(attachment.Name)),
+				Kind:     "attachment",
+				Notebook: notebookName,
+			}
+			if err := writeTakeoutFile(ctx, dir, &file, func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}); err != nil {
+				return err
+			}
+			files = append(files, file)
+			if _, err := fmt.Fprintf(w, "\nattached: [%s](../%s)\n", attachment.Name, file.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return notes, files, err
+}
+
+/**
+ * Writes a file of a takeout with given function, setting its size and hash
+ * Writes fail with ctx's error once it's cancelled, so that large notebooks stop midway
+ */
+func writeTakeoutFile(ctx context.Context, dir string, file *TakeoutFile, write func(io.Writer) error) error {
+	name := filepath.Join(dir, filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	counter := &countingWriter{ctx: ctx, w: io.MultiWriter(f, hash)}
+	if err := write(counter); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	file.Size, file.SHA256 = counter.n, hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+type countingWriter struct {
+	ctx context.Context
+	w   io.Writer
+	n   int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+/**
+ * Imports notebooks of a JSON takeout (see Takeout), each as by ImportNotebook into a notebook
+ * of the same name (archiving it again if it was archived)
+ *  - only notebooks named by opts.Notebooks are restored, if any are
+ *  - the manifest is checked first: if any file to be restored is missing, or differs from what
+ *    the manifest says, nothing is imported and ErrInvalidTakeout is returned
+ *  - opts.Mapping gets a single id-mapping covering all notebooks restored; opts.ResumeFrom and
+ *    opts.OnCheckpoint are ignored, as they're about a single notebook
+ * param: string        dir
+ * param: ImportOptions opts
+ * return: (RestoreReport, error)
+ */
+func (db *DB) RestoreTakeout(dir string, opts ImportOptions) (RestoreReport, error) {
+	var report RestoreReport
+	manifest, err := ReadTakeoutManifest(dir)
+	if err != nil {
+		return report, err
+	}
+	if manifest.ExportFormat != "json" {
+		return report, fmt.Errorf("%w: %s takeouts can't be restored", ErrInvalidTakeout, manifest.ExportFormat)
+	}
+
+	var files []TakeoutFile
+	wanted := make(map[string]bool)
+	for _, notebookName := range opts.Notebooks {
+		wanted[string(db.notebookKey(notebookName))] = true
+	}
+	for _, file := range manifest.Files {
+		if file.Kind != "notebook" {
+			continue
+		}
+		notebookKey := string(db.notebookKey(file.Notebook))
+		if len(wanted) > 0 && !wanted[notebookKey] {
+			continue
+		}
+		delete(wanted, notebookKey)
+		files = append(files, file)
+	}
+	for _, notebookName := range opts.Notebooks {
+		if wanted[string(db.notebookKey(notebookName))] {
+			return report, fmt.Errorf("%w: no notebook '%s' in takeout", ErrInvalidTakeout, notebookName)
+		}
+	}
+	var problems []string
+	for _, file := range files {
+		if err := checkTakeoutFile(dir, file); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return report, fmt.Errorf("%w: %s", ErrInvalidTakeout, strings.Join(problems, "; "))
+	}
+
+	opts.ResumeFrom, opts.OnCheckpoint = "", nil
+	var mapping []IdMapping
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return report, err
+		}
+		imported, err := db.importNotebook(file.Notebook, f, opts)
+		f.Close()
+		report.Notebooks = append(report.Notebooks, RestoredNotebook{Notebook: file.Notebook, ImportReport: imported})
+		mapping = append(mapping, imported.Mapping...)
+		if err == nil && file.Archived {
+			err = db.ArchiveNotebook(file.Notebook)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if opts.Mapping != nil {
+		if mappingErr := WriteIdMapping(opts.Mapping, mapping); err == nil {
+			err = mappingErr
+		}
+	}
+	return report, err
+}
+
+/**
+ * Reads the manifest of a takeout
+ * Fails with ErrInvalidTakeout if there's none (like for a takeout interrupted midway), or if it's malformed
+ * param: string dir
+ * return: (TakeoutManifest, error)
+ */
+func ReadTakeoutManifest(dir string) (TakeoutManifest, error) {
+	var manifest TakeoutManifest
+	encoded, err := ioutil.ReadFile(filepath.Join(dir, takeoutManifestFile))
+	if os.IsNotExist(err) {
+		return manifest, fmt.Errorf("%w: no %s in '%s'", ErrInvalidTakeout, takeoutManifestFile, dir)
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return manifest, fmt.Errorf("%w: %v", ErrInvalidTakeout, err)
+	}
+	if manifest.Format < 1 || manifest.Format > TakeoutFormat {
+		return manifest, fmt.Errorf("%w: unsupported format version %d", ErrInvalidTakeout, manifest.Format)
+	}
+	return manifest, nil
+}
+
+/**
+ * Checks a file of a takeout is there, with the size and hash the manifest says
+ */
+func checkTakeoutFile(dir string, file TakeoutFile) error {
+	cleaned := path.Clean(file.Path)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("'%s' is outside the takeout", file.Path)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(cleaned)))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("'%s' is missing", file.Path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("'%s' is corrupted", file.Path)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Returned by ToggleTask when the given line of the note isn't a task (anymore)
+ */
+var ErrTaskConflict = errors.New("line is not a task")
+
+/**
+ * Matches markdown checklist items like '- [ ] buy milk' or '  * [x] done'
+ */
+var taskPattern = regexp.MustCompile(`^(\s*[-*+] \[)([ xX])(\] ?)(.*)$`)
+
+/**
+ * A checklist item within a note's content
+ *  - Line is 1-based line number of the item in note's content
+ */
+type Task struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+/**
+ * A task along with the note it belongs to
+ */
+type TaskRef struct {
+	Ref  NoteRef `json:"ref"`
+	Task Task    `json:"task"`
+}
+
+/**
+ * Extracts checklist items ('- [ ]' / '- [x]') from note's content
+ */
+func (n Note) Tasks() []Task {
+	var tasks []Task
+	for i, line := range strings.Split(n.Content, "\n") {
+		if match := taskPattern.FindStringSubmatch(line); match != nil {
+			tasks = append(tasks, Task{Line: i + 1, Text: match[4], Done: match[2] != " "})
+		}
+	}
+	return tasks
+}
+
+/**
+ * Flips the checkbox of task on given (1-based) line of a note and saves it
+ *  - fails with ErrTaskConflict if that line doesn't look like a task,
+ *    which happens when content has changed since tasks were listed
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: int    line
+ * return: (Note, error)
+ */
+func (db *DB) ToggleTask(notebookName string, noteId uint64, line int) (Note, error) {
+	var note Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, currentNote, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+
+		lines := strings.Split(currentNote.Content, "\n")
+		if line < 1 || line > len(lines) {
+			return fmt.Errorf("%w: line %d of note %d", ErrTaskConflict, line, noteId)
+		}
+		match := taskPattern.FindStringSubmatch(lines[line-1])
+		if match == nil {
+			return fmt.Errorf("%w: line %d of note %d", ErrTaskConflict, line, noteId)
+		}
+		mark := "x"
+		if match[2] != " " {
+			mark = " "
+		}
+		lines[line-1] = match[1] + mark + match[3] + match[4]
+
+		note, err = db.updateNoteInTx(tx, notebookName, noteId, strings.Join(lines, "\n"), false)
+		return err
+	})
+	return note, err
+}
+
+/**
+ * Scans a notebook for unchecked checklist items
+ * param: string notebookName
+ * return: ([]TaskRef, error)
+ */
+func (db *DB) ListOpenTasks(notebookName string) ([]TaskRef, error) {
+	notebook, err := db.GetNotebook(notebookName)
+	if err != nil {
+		return nil, err
+	}
+
+	var taskRefs []TaskRef
+	for _, note := range notebook.Notes {
+		for _, task := range note.Tasks() {
+			if !task.Done {
+				taskRefs = append(taskRefs, TaskRef{Ref: NoteRef{Notebook: notebook.Name, Id: note.Id}, Task: task})
+			}
+		}
+	}
+	return taskRefs, nil
+}
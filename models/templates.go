@@ -0,0 +1,306 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notebook templates give new notebooks the structure every notebook of a kind starts with
+ * (like a project's README, decisions, meeting log and todo notes)
+ *  - a template is an ordered list of note specs; its notes are created in that order (so ids follow it),
+ *    all of them along with the notebook in a single transaction
+ *  - titles, contents and tags of specs can hold variables like '{{project}}', given when the template is
+ *    used; '{{notebook}}' is the name of the notebook created, unless given otherwise
+ *  - pinned notes are added to favorites (see favorites.go), in the template's order
+ *  - templates are shared between DBs by exporting them (see ExportNotebookTemplate)
+ * 'Templates' bucket: template name -> JSON NotebookTemplate
+ */
+
+var (
+	// returned when there is no template by given name
+	ErrTemplateNotFound = errors.New("notebook template not found")
+	// returned for templates without name or notes, using variables that aren't given, or malformed exports of them
+	ErrInvalidTemplate = errors.New("invalid notebook template")
+	// returned by CreateNotebookFromTemplate when the notebook already exists
+	ErrNotebookExists = errors.New("notebook already exists")
+)
+
+/**
+ * Version of the format ExportNotebookTemplate writes
+ */
+const NotebookTemplateFormat = 1
+
+/**
+ * A note of a template
+ *  - an empty Title leaves the note's title to be inferred from its content (see titles.go)
+ */
+type NoteSpec struct {
+	Title   string   `json:"title,omitempty"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags,omitempty"`
+	Pinned  bool     `json:"pinned,omitempty"`
+}
+
+/**
+ * A notebook template; Name is set by SaveNotebookTemplate
+ */
+type NotebookTemplate struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Notes       []NoteSpec `json:"notes"`
+}
+
+/**
+ * Document ExportNotebookTemplate writes
+ */
+type notebookTemplateExport struct {
+	Format   int              `json:"format"`
+	Template NotebookTemplate `json:"template"`
+}
+
+// variables of templates, like '{{project}}' (spaces inside braces being allowed)
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+/**
+ * Saves a template under given name, replacing the template saved under it (if any)
+ * Fails with ErrInvalidTemplate if the name is empty or the template has no notes
+ * param: string           name
+ * param: NotebookTemplate t
+ * return: error
+ */
+func (db *DB) SaveNotebookTemplate(name string, t NotebookTemplate) error {
+	t.Name = name
+	if err := checkNotebookTemplate(t); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("Templates"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), encoded)
+	})
+}
+
+/**
+ * Retrieves a template
+ * Fails with ErrTemplateNotFound if there is no template by given name
+ * param: string name
+ * return: (NotebookTemplate, error)
+ */
+func (db *DB) GetNotebookTemplate(name string) (NotebookTemplate, error) {
+	var t NotebookTemplate
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		t, err = getNotebookTemplate(tx, name)
+		return err
+	})
+	return t, err
+}
+
+/**
+ * Retrieves all templates, by name
+ * return: ([]NotebookTemplate, error)
+ */
+func (db *DB) ListNotebookTemplates() ([]NotebookTemplate, error) {
+	templates := []NotebookTemplate{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Templates"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var t NotebookTemplate
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			templates = append(templates, t)
+			return nil
+		})
+	})
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, err
+}
+
+/**
+ * Deletes a template (notebooks created from it are left as they are)
+ * Fails with ErrTemplateNotFound if there is no template by given name
+ * param: string name
+ * return: error
+ */
+func (db *DB) DeleteNotebookTemplate(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Templates"))
+		if bucket == nil || bucket.Get([]byte(name)) == nil {
+			return fmt.Errorf("%w: '%s'", ErrTemplateNotFound, name)
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+/**
+ * Creates a notebook holding the notes of a template (see above), with given variables
+ * Nothing is written if anything fails: with ErrNotebookExists if the notebook exists, ErrTemplateNotFound
+ * if the template doesn't, and ErrInvalidTemplate if it uses variables that aren't given
+ * param: string            notebookName
+ * param: string            templateName
+ * param: map[string]string vars
+ * return: ([]Note, error) Notes created, in the template's order
+ */
+func (db *DB) CreateNotebookFromTemplate(notebookName, templateName string, vars map[string]string) ([]Note, error) {
+	if err := db.checkNotebookTxNames([]string{notebookName}); err != nil {
+		return nil, err
+	}
+	var added []Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) != nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookExists, notebookDisplayName(tx, notebookKey))
+		}
+		t, err := getNotebookTemplate(tx, templateName)
+		if err != nil {
+			return err
+		}
+		notes, err := expandNotebookTemplate(t, notebookName, vars)
+		if err != nil {
+			return err
+		}
+		batch := preparedAdd{notebookName: notebookName, notes: notes}
+		if batch.prepared, err = prepareNotes(notes, NotebookDefaults{}, db.encodingFor(tx, db.notebookKey(notebookName))); err != nil {
+			return err
+		}
+		if added, err = db.commitAdd(tx, batch); err != nil {
+			return err
+		}
+
+		favorites, err := readFavorites(tx)
+		if err != nil {
+			return err
+		}
+		pinned := false
+		for i, spec := range t.Notes {
+			if spec.Pinned {
+				favorites, pinned = append(favorites, favoriteKey(notebookKey, added[i].Id)), true
+			}
+		}
+		if !pinned {
+			return nil
+		}
+		return writeFavorites(tx, favorites)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+/**
+ * Writes a template as a JSON document, which ImportNotebookTemplate reads (into this DB or another)
+ * Fails with ErrTemplateNotFound if there is no template by given name
+ * param: string    name
+ * param: io.Writer w
+ * return: error
+ */
+func (db *DB) ExportNotebookTemplate(name string, w io.Writer) error {
+	t, err := db.GetNotebookTemplate(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(notebookTemplateExport{Format: NotebookTemplateFormat, Template: t})
+}
+
+/**
+ * Saves a template written by ExportNotebookTemplate, under its own name unless given another
+ * one (replacing the template saved under that name, if any)
+ * Fails with ErrInvalidTemplate if the document isn't a valid template export, and with a
+ * *FormatVersionError if its format is newer than this version of notes reads
+ * param: io.Reader r
+ * param: string    name
+ * return: (NotebookTemplate, error) Template as saved
+ */
+func (db *DB) ImportNotebookTemplate(r io.Reader, name string) (NotebookTemplate, error) {
+	var export notebookTemplateExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return export.Template, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	switch {
+	case export.Format > NotebookTemplateFormat:
+		return export.Template, &FormatVersionError{Export: "notebook template", Version: export.Format, Supported: NotebookTemplateFormat}
+	case export.Format < 1:
+		return export.Template, fmt.Errorf("%w: missing format version", ErrInvalidTemplate)
+	}
+	if name == "" {
+		name = export.Template.Name
+	}
+	if err := db.SaveNotebookTemplate(name, export.Template); err != nil {
+		return export.Template, err
+	}
+	export.Template.Name = name
+	return export.Template, nil
+}
+
+func getNotebookTemplate(tx *bolt.Tx, name string) (NotebookTemplate, error) {
+	var t NotebookTemplate
+	var encoded []byte
+	if bucket := tx.Bucket([]byte("Templates")); bucket != nil {
+		encoded = bucket.Get([]byte(name))
+	}
+	if encoded == nil {
+		return t, fmt.Errorf("%w: '%s'", ErrTemplateNotFound, name)
+	}
+	err := json.Unmarshal(encoded, &t)
+	return t, err
+}
+
+func checkNotebookTemplate(t NotebookTemplate) error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: no name", ErrInvalidTemplate)
+	}
+	if len(t.Notes) == 0 {
+		return fmt.Errorf("%w: '%s' has no notes", ErrInvalidTemplate, t.Name)
+	}
+	return nil
+}
+
+/**
+ * Notes of a template, with its variables replaced by their values
+ */
+func expandNotebookTemplate(t NotebookTemplate, notebookName string, vars map[string]string) ([]Note, error) {
+	values := map[string]string{"notebook": notebookName}
+	for name, value := range vars {
+		values[name] = value
+	}
+	var missing error
+	expand := func(text string) string {
+		return templateVariable.ReplaceAllStringFunc(text, func(variable string) string {
+			name := templateVariable.FindStringSubmatch(variable)[1]
+			value, ok := values[name]
+			if !ok && missing == nil {
+				missing = fmt.Errorf("%w: variable '%s' of template '%s' not given", ErrInvalidTemplate, name, t.Name)
+			}
+			return value
+		})
+	}
+
+	notes := make([]Note, len(t.Notes))
+	for i, spec := range t.Notes {
+		notes[i] = Note{TitleText: expand(spec.Title), Content: expand(spec.Content)}
+		for _, tag := range spec.Tags {
+			notes[i].Tags = append(notes[i].Tags, expand(tag))
+		}
+	}
+	return notes, missing
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notes may have a title of their own (Note.TitleText); with title inference on, notes created
+ * without one get one derived from their content (see InferTitle), flagged as inferred
+ *  - titles given explicitly are never touched
+ *  - with ReinferOnUpdate, inferred titles follow content as it's updated
+ */
+type TitleInference struct {
+	Enabled bool `json:"enabled"`
+	// longest title inferred, in characters (DefaultTitleLength if zero)
+	MaxLength       int  `json:"max_length,omitempty"`
+	ReinferOnUpdate bool `json:"reinfer_on_update,omitempty"`
+}
+
+/**
+ * Longest title inferred, unless configured otherwise
+ */
+const DefaultTitleLength = 80
+
+/**
+ * Turns title inference on or off (see TitleInference); the setting is persisted in the DB
+ * Notes that already exist are left as they are, see InferMissingTitles
+ * param: TitleInference opts
+ * return: error
+ */
+func (db *DB) SetTitleInference(opts TitleInference) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		settings.Titles = opts
+		return putSettings(tx, settings)
+	})
+	if err != nil {
+		return err
+	}
+	db.titles = opts
+	return nil
+}
+
+/**
+ * Infers titles of notes of a notebook that have none, whether or not title inference is on
+ * (notes locked read-only are left alone)
+ * Fails with ErrNotebookArchived if the notebook is archived
+ * param: string notebookName
+ * return: (int, error) Number of notes given a title
+ */
+func (db *DB) InferMissingTitles(notebookName string) (int, error) {
+	inferred := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		if tx.Bucket([]byte("Notebook")).Bucket(notebookKey) == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		var untitled []Note
+		err := db.forEachMatchingNote(tx, notebookKey, NoteFilter{IncludeExpired: true}, func(note Note) error {
+			if note.TitleText == "" && !note.ReadOnly {
+				untitled = append(untitled, note)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, note := range untitled {
+			if err := db.writeCheckpoint(i, len(untitled)); err != nil {
+				return err
+			}
+			if note.TitleText = InferTitle(note.Content, db.titles.MaxLength); note.TitleText == "" {
+				continue
+			}
+			note.TitleInferred = true
+			db.bumpRevision(&note)
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return err
+			}
+			inferred++
+		}
+		return nil
+	})
+	return inferred, err
+}
+
+/**
+ * Title inferred from content: its first non-empty line, trimmed and with markdown heading marks
+ * stripped, cut down to maxLength characters (DefaultTitleLength if not positive) with an ellipsis
+ * param: string content
+ * param: int    maxLength
+ * return: string Empty for blank content
+ */
+func InferTitle(content string, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = DefaultTitleLength
+	}
+	var title string
+	for _, line := range strings.Split(content, "\n") {
+		if title = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#")); title != "" {
+			break
+		}
+	}
+	if utf8.RuneCountInString(title) <= maxLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:maxLength-1])) + "…"
+}
+
+/**
+ * Gives a note about to be created an inferred title, if it has none
+ */
+func (t TitleInference) onCreate(note Note) Note {
+	if t.Enabled && note.TitleText == "" {
+		note.TitleText = InferTitle(note.Content, t.MaxLength)
+		note.TitleInferred = note.TitleText != ""
+	}
+	if note.TitleText == "" {
+		note.TitleInferred = false
+	}
+	return note
+}
+
+/**
+ * Infers the title of a note whose content was updated afresh, if it was inferred before
+ */
+func (t TitleInference) onUpdate(note Note) Note {
+	if note.TitleInferred && t.ReinferOnUpdate {
+		note.TitleText = InferTitle(note.Content, t.MaxLength)
+		note.TitleInferred = note.TitleText != ""
+	}
+	return note
+}
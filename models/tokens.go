@@ -0,0 +1,312 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * API tokens authenticate clients of the REST API (see api.Authenticate)
+ *  - a token reads 'notes_<id>_<secret>'; only a SHA-256 hash of the secret is stored, so the
+ *    token is shown once (by CreateAPIToken) and can't be retrieved again
+ *  - secrets are compared with their hash in constant time
+ *  - every token carries scopes, telling what it may do (see Scope)
+ * 'APITokens' bucket: token id (8 byte big endian) -> JSON storedToken
+ */
+
+/**
+ * Level of access granted by a scope; higher levels include lower ones
+ */
+type ScopeLevel string
+
+const (
+	// reading notebooks and notes
+	ScopeRead ScopeLevel = "read"
+	// also adding, changing and deleting notes
+	ScopeReadWrite ScopeLevel = "read-write"
+	// also managing notebooks (like archiving them)
+	ScopeAdmin ScopeLevel = "admin"
+)
+
+/**
+ * Rank of each scope level
+ */
+var scopeRanks = map[ScopeLevel]int{ScopeRead: 1, ScopeReadWrite: 2, ScopeAdmin: 3}
+
+/**
+ * Access granted to a token: Level on Notebook, or on all notebooks if Notebook is empty
+ */
+type Scope struct {
+	Level    ScopeLevel `json:"level"`
+	Notebook string     `json:"notebook,omitempty"`
+}
+
+func (s Scope) String() string {
+	if s.Notebook == "" {
+		return string(s.Level)
+	}
+	return string(s.Level) + ":" + s.Notebook
+}
+
+/**
+ * An API token (without its secret)
+ */
+type APIToken struct {
+	Id        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+/**
+ * An API token as stored: with the hash of its secret
+ */
+type storedToken struct {
+	APIToken
+	SecretHash string `json:"secret_hash"`
+}
+
+/**
+ * Prefix of token strings
+ */
+const apiTokenPrefix = "notes_"
+
+/**
+ * Returned by AuthenticateAPIToken for tokens that are malformed, unknown or revoked
+ */
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+/**
+ * Returned when there is no API token of given name
+ */
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+/**
+ * Returned when creating an API token whose name is taken
+ */
+var ErrAPITokenExists = errors.New("API token already exists")
+
+/**
+ * Returned for scopes of unknown levels, and for tokens without scopes
+ */
+var ErrInvalidScope = errors.New("invalid scope")
+
+/**
+ * Parses a scope written as 'level' or 'level:notebook' (like 'read' or 'read-write:work')
+ * param: string s
+ * return: (Scope, error)
+ */
+func ParseScope(s string) (Scope, error) {
+	scope := Scope{Level: ScopeLevel(s)}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		scope = Scope{Level: ScopeLevel(s[:i]), Notebook: s[i+1:]}
+	}
+	if scopeRanks[scope.Level] == 0 {
+		return scope, fmt.Errorf("%w '%s' (expected read, read-write or admin, optionally followed by ':notebook')", ErrInvalidScope, s)
+	}
+	return scope, nil
+}
+
+/**
+ * Whether the token may act with given level of access on a notebook
+ * ("" for operations not confined to a notebook, which need a scope on all notebooks)
+ * param: ScopeLevel level
+ * param: string     notebookName
+ * return: bool
+ */
+func (t APIToken) Allows(level ScopeLevel, notebookName string) bool {
+	for _, scope := range t.Scopes {
+		if scopeRanks[scope.Level] >= scopeRanks[level] && (scope.Notebook == "" || scope.Notebook == notebookName) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Creates an API token with given (unique) name and scopes
+ * param: string  name
+ * param: []Scope scopes
+ * return: (string, error) The token; it can't be retrieved again
+ */
+func (db *DB) CreateAPIToken(name string, scopes []Scope) (string, error) {
+	if name == "" {
+		return "", errors.New("API token needs a name")
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("%w: API token needs at least one scope", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if scopeRanks[scope.Level] == 0 {
+			return "", fmt.Errorf("%w '%s'", ErrInvalidScope, scope)
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encodedSecret := hex.EncodeToString(secret)
+
+	var token string
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("APITokens"))
+		if err != nil {
+			return err
+		}
+		if _, found, err := findAPIToken(bucket, name); err != nil || found {
+			if err == nil {
+				err = fmt.Errorf("%w: '%s'", ErrAPITokenExists, name)
+			}
+			return err
+		}
+		stored := storedToken{
+			APIToken:   APIToken{Name: name, Scopes: scopes, CreatedAt: time.Now()},
+			SecretHash: hashSecret(encodedSecret),
+		}
+		if stored.Id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		token = fmt.Sprintf("%s%d_%s", apiTokenPrefix, stored.Id, encodedSecret)
+		return bucket.Put(itob(stored.Id), encoded)
+	})
+	return token, err
+}
+
+/**
+ * Revokes the API token of given name
+ * param: string name
+ * return: error
+ */
+func (db *DB) RevokeAPIToken(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("APITokens"))
+		if bucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrAPITokenNotFound, name)
+		}
+		stored, found, err := findAPIToken(bucket, name)
+		if err != nil || !found {
+			if err == nil {
+				err = fmt.Errorf("%w: '%s'", ErrAPITokenNotFound, name)
+			}
+			return err
+		}
+		return bucket.Delete(itob(stored.Id))
+	})
+}
+
+/**
+ * Retrieves all API tokens (without their secrets), oldest first
+ * return: ([]APIToken, error)
+ */
+func (db *DB) ListAPITokens() ([]APIToken, error) {
+	var tokens []APIToken
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("APITokens"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var stored storedToken
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			tokens = append(tokens, stored.APIToken)
+			return nil
+		})
+	})
+	return tokens, err
+}
+
+/**
+ * Looks up the API token a token string was created as
+ * Fails with ErrInvalidAPIToken if it's malformed, unknown, revoked or its secret doesn't match
+ * param: string token
+ * return: (APIToken, error)
+ */
+func (db *DB) AuthenticateAPIToken(token string) (APIToken, error) {
+	var authenticated APIToken
+	id, secret, ok := splitToken(apiTokenPrefix, token)
+	if !ok {
+		return authenticated, ErrInvalidAPIToken
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("APITokens"))
+		if bucket == nil {
+			return ErrInvalidAPIToken
+		}
+		encoded := bucket.Get(itob(id))
+		if encoded == nil {
+			return ErrInvalidAPIToken
+		}
+		var stored storedToken
+		if err := json.Unmarshal(encoded, &stored); err != nil {
+			return err
+		}
+		if !secretMatches(secret, stored.SecretHash) {
+			return ErrInvalidAPIToken
+		}
+		authenticated = stored.APIToken
+		return nil
+	})
+	return authenticated, err
+}
+
+/**
+ * Splits a token string ('<prefix><id>_<secret>') into the id and secret of the token
+ */
+func splitToken(prefix string, token string) (uint64, string, bool) {
+	if !strings.HasPrefix(token, prefix) {
+		return 0, "", false
+	}
+	parts := strings.SplitN(token[len(prefix):], "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	return id, parts[1], err == nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * Whether a secret has given hash; compares in constant time, so that timing tells nothing
+ * about how much of the hash matched
+ */
+func secretMatches(secret string, secretHash string) bool {
+	return constantTimeCompare([]byte(hashSecret(secret)), []byte(secretHash)) == 1
+}
+
+// compares hashes of secrets (replaced by tests, to check that every secret goes through it)
+var constantTimeCompare = subtle.ConstantTimeCompare
+
+func findAPIToken(bucket *bolt.Bucket, name string) (storedToken, bool, error) {
+	var found storedToken
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var stored storedToken
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return found, false, err
+		}
+		if stored.Name == name {
+			return stored, true, nil
+		}
+	}
+	return found, false, nil
+}
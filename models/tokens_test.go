@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateAPITokenComparesHashesInConstantTime(t *testing.T) {
+	db := newTestDB(t)
+	token, err := db.CreateAPIToken("ci", []Scope{{Level: ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare := constantTimeCompare
+	defer func() { constantTimeCompare = compare }()
+	var compared [][2]int
+	constantTimeCompare = func(x, y []byte) int {
+		compared = append(compared, [2]int{len(x), len(y)})
+		return compare(x, y)
+	}
+
+	// secrets wrong from their first byte, their last, of another length, and right: every one is
+	// compared as a whole hash, never byte by byte against the stored one
+	i := strings.LastIndexByte(token, '_') + 1
+	secret := token[i:]
+	flipped := func(b byte) string { return string(b ^ 1) }
+	for _, presented := range []string{
+		token[:i] + flipped(secret[0]) + secret[1:],
+		token[:i] + secret[:len(secret)-1] + flipped(secret[len(secret)-1]),
+		token[:i] + secret + "more",
+		token[:i] + "y",
+		token,
+	} {
+		compared = nil
+		_, err := db.AuthenticateAPIToken(presented)
+		if presented == token && err != nil {
+			t.Errorf("AuthenticateAPIToken(token) = %v", err)
+		}
+		if presented != token && !errors.Is(err, ErrInvalidAPIToken) {
+			t.Errorf("AuthenticateAPIToken(%q) = %v, want ErrInvalidAPIToken", presented, err)
+		}
+		if len(compared) != 1 || compared[0] != [2]int{64, 64} {
+			t.Errorf("AuthenticateAPIToken(%q) compared %v, want a single comparison of two SHA-256 hex hashes", presented, compared)
+		}
+	}
+}
+
+func TestAuthenticateAPITokenRejectsRevokedAndMalformed(t *testing.T) {
+	db := newTestDB(t)
+	token, err := db.CreateAPIToken("ci", []Scope{{Level: ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticated, err := db.AuthenticateAPIToken(token)
+	if err != nil || authenticated.Name != "ci" {
+		t.Fatalf("AuthenticateAPIToken = %+v, %v", authenticated, err)
+	}
+	if err := db.RevokeAPIToken("ci"); err != nil {
+		t.Fatal(err)
+	}
+	for _, presented := range []string{token, "", "notes_", "notes_1", "notes_x_secret", "bearer " + token} {
+		if _, err := db.AuthenticateAPIToken(presented); !errors.Is(err, ErrInvalidAPIToken) {
+			t.Errorf("AuthenticateAPIToken(%q) = %v, want ErrInvalidAPIToken", presented, err)
+		}
+	}
+	if code := ErrorCodeOf(ErrInvalidAPIToken); code != CodeLocked {
+		t.Errorf("ErrInvalidAPIToken is coded %s, want %s", code, CodeLocked)
+	}
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/**
+ * Importers and exporters take hooks transforming the records they read or write, so that other
+ * systems' conventions (like titles prefixed with '## ', or tags as trailing '#tag' words) can be
+ * mapped without a fork of the importer
+ *  - a TransformFunc is called with every record an import parsed, before it's deduplicated or its
+ *    conflicts are resolved; it returns the note to import instead, or ErrSkipRecord to leave the
+ *    record out (counted in ImportReport.TransformSkipped)
+ *  - a RenderFunc is called with every note export an export writes, and returns the one to write instead
+ *  - hooks are given copies, so whatever they do to them doesn't reach the notes (or transactions) they
+ *    were made of; errors of hooks abort the import / export, identifying the record (see RecordTransformError)
+ */
+
+/**
+ * Returned by a TransformFunc to leave a record out of an import
+ */
+var ErrSkipRecord = errors.New("record skipped by transform")
+
+/**
+ * Where an import read a record
+ *  - File is set by importers reading a record per file (ImportKeepTakeout: path within the takeout)
+ *  - Record is the (1-based) number of the record within a stream of them (ImportENEX, ImportNotebook),
+ *    and Offset the position in bytes of its end, as far as it's known
+ */
+type RecordSource struct {
+	File   string `json:"file,omitempty"`
+	Record int    `json:"record,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+func (s RecordSource) String() string {
+	var parts []string
+	if s.File != "" {
+		parts = append(parts, fmt.Sprintf("file '%s'", s.File))
+	}
+	if s.Record > 0 {
+		parts = append(parts, fmt.Sprintf("record %d", s.Record))
+	}
+	if s.Offset > 0 {
+		parts = append(parts, fmt.Sprintf("(byte %d)", s.Offset))
+	}
+	if len(parts) == 0 {
+		return "record"
+	}
+	return strings.Join(parts, " ")
+}
+
+/**
+ * A record read by an import, as parsed into a note
+ *  - SourceKey is the record's key in id-mappings (see IdMapping)
+ *  - Attachments are those the record comes with; of Keep notes, only their names are known
+ */
+type ImportedRecord struct {
+	Source      RecordSource
+	SourceKey   string
+	Note        Note
+	Attachments []Attachment
+}
+
+/**
+ * Hook of imports, mapping a parsed record onto the note to import (see above)
+ *  - attachments returned are those of the record to import along with the note, told apart by name:
+ *    a transform can leave some out, but not add any or rename them
+ */
+type TransformFunc func(raw ImportedRecord) (Note, []Attachment, error)
+
+/**
+ * Hook of exports, mapping the export of a note onto the one to write (see above)
+ *  - NoteExport.ContentHash is recomputed from the content returned, and attachments left out drop
+ *    their blob; blobs of attachments added must be added along with them
+ */
+type RenderFunc func(record NoteExport) (NoteExport, error)
+
+/**
+ * Returned when a TransformFunc or a RenderFunc fails (with an error other than ErrSkipRecord)
+ *  - Source is where the record was read, for imports; exports tell the note (Ref) instead
+ */
+type RecordTransformError struct {
+	Source RecordSource
+	Ref    *NoteRef
+	Err    error
+}
+
+func (e *RecordTransformError) Error() string {
+	if e.Ref != nil {
+		return fmt.Sprintf("rendering note %v: %v", *e.Ref, e.Err)
+	}
+	return fmt.Sprintf("transforming %v: %v", e.Source, e.Err)
+}
+
+func (e *RecordTransformError) Unwrap() error {
+	return e.Err
+}
+
+/**
+ * Option of exports passing every note export through given RenderFunc (see above)
+ */
+func RenderWith(render RenderFunc) ListOption {
+	return func(filter *NoteFilter) {
+		filter.Render = render
+	}
+}
+
+/**
+ * Passes a record through transform (if set), returning the note to import and the attachments kept
+ * return: (Note, []Attachment, bool, error) false if transform skipped the record (which is then
+ *         counted, and mapped, in report)
+ */
+func transformRecord(transform TransformFunc, record ImportedRecord, report *ImportReport) (Note, []Attachment, bool, error) {
+	if transform == nil {
+		return record.Note, record.Attachments, true, nil
+	}
+	note, attachments, err := transform(ImportedRecord{
+		Source:      record.Source,
+		SourceKey:   record.SourceKey,
+		Note:        copyNote(record.Note),
+		Attachments: append([]Attachment(nil), record.Attachments...),
+	})
+	if errors.Is(err, ErrSkipRecord) {
+		report.TransformSkipped++
+		report.Mapping = append(report.Mapping, IdMapping{SourceKey: record.SourceKey, Status: MappingSkipped, Error: err.Error()})
+		return note, nil, false, nil
+	}
+	if err != nil {
+		return note, nil, false, &RecordTransformError{Source: record.Source, Err: err}
+	}
+
+	var kept []Attachment
+	for _, attachment := range attachments {
+		found := false
+		for _, original := range record.Attachments {
+			if original.Name == attachment.Name {
+				kept, found = append(kept, original), true
+				break
+			}
+		}
+		if !found {
+			return note, nil, false, &RecordTransformError{Source: record.Source,
+				Err: fmt.Errorf("attachment '%s' isn't one of the record's", attachment.Name)}
+		}
+	}
+	return note, kept, true, nil
+}
+
+/**
+ * Passes the (checked, see checkNoteExport) export of a note read by an import through transform (see transformRecord)
+ */
+func transformNoteExport(transform TransformFunc, export NoteExport, source RecordSource, report *ImportReport) (NoteExport, bool, error) {
+	if transform == nil {
+		return export, true, nil
+	}
+	if err := checkNoteExport(export); err != nil {
+		return export, false, err
+	}
+	record := ImportedRecord{
+		Source:      source,
+		SourceKey:   NoteRef{Notebook: export.Notebook, Id: export.Note.Id}.String(),
+		Note:        export.Note,
+		Attachments: export.Attachments,
+	}
+	note, attachments, ok, err := transformRecord(transform, record, report)
+	if !ok || err != nil {
+		return export, ok, err
+	}
+	export.Note, export.ContentHash, export.Attachments = note, contentHash(note.Content), attachments
+	return export, true, nil
+}
+
+/**
+ * Passes the export of a note through render (if set), given a copy of it
+ */
+func renderNoteExport(render RenderFunc, export NoteExport) (NoteExport, error) {
+	if render == nil {
+		return export, nil
+	}
+	ref := NoteRef{Notebook: export.Notebook, Id: export.Note.Id}
+	rendered, err := render(copyNoteExport(export))
+	if err != nil {
+		return export, &RecordTransformError{Ref: &ref, Err: err}
+	}
+	rendered.ContentHash = contentHash(rendered.Note.Content)
+	for hash := range rendered.Blobs {
+		used := false
+		for _, attachment := range rendered.Attachments {
+			used = used || attachment.Hash == hash
+		}
+		if !used {
+			delete(rendered.Blobs, hash)
+		}
+	}
+	return rendered, nil
+}
+
+func copyNote(note Note) Note {
+	note.Tags = append([]string(nil), note.Tags...)
+	if note.ExpiresAt != nil {
+		expiresAt := *note.ExpiresAt
+		note.ExpiresAt = &expiresAt
+	}
+	if note.Chunks != nil {
+		chunks := *note.Chunks
+		note.Chunks = &chunks
+	}
+	if note.Clock != nil {
+		note.Clock = mergeClocks(note.Clock, nil)
+	}
+	return note
+}
+
+func copyNoteExport(export NoteExport) NoteExport {
+	export.Note = copyNote(export.Note)
+	export.History = append([]NoteRevision(nil), export.History...)
+	export.Attachments = append([]Attachment(nil), export.Attachments...)
+	export.Relations = append([]Relation(nil), export.Relations...)
+	if export.Blobs != nil {
+		blobs := make(map[string][]byte, len(export.Blobs))
+		for hash, content := range export.Blobs {
+			blobs[hash] = append([]byte(nil), content...)
+		}
+		export.Blobs = blobs
+	}
+	return export
+}
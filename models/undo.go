@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Number of undo entries retained by default
+ */
+const DefaultUndoLimit = 20
+
+var (
+	// returned by Undo when no entry exists for given operation id
+	ErrUndoEntryNotFound = errors.New("undo entry not found")
+	// returned by Undo when a stashed note's id has since been reused
+	ErrUndoConflict = errors.New("note id has been reused since the operation")
+)
+
+/**
+ * Records stashed before a destructive operation, so that it can be undone
+ *  - undo of an undo is not supported: Undo() restores the records and
+ *    drops the entry, it doesn't create a new entry of its own
+ *  - attachments are not stashed: notes restored by Undo() come back without them
+ */
+type UndoEntry struct {
+	Id        uint64    `json:"id"`
+	Operation string    `json:"operation"`
+	Notebook  string    `json:"notebook"`
+	Notes     []Note    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+/**
+ * Sets the number of undo entries retained (older ones are pruned on next destructive operation)
+ * Non-positive limit falls back to DefaultUndoLimit
+ */
+func (db *DB) SetUndoLimit(limit int) {
+	db.undoLimit = limit
+}
+
+/**
+ * Returns up to n most recent undo entries, newest first
+ * param: int n
+ * return: ([]UndoEntry, error)
+ */
+func (db *DB) LastOperations(n int) ([]UndoEntry, error) {
+	var entries []UndoEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("UndoLog"))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(entries) < n; k, v = cursor.Prev() {
+			var entry UndoEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if err := db.openNotes(entry.Notes); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+/**
+ * Restores records stashed by operation with given id and removes its undo entry
+ *  - fails with ErrUndoConflict (restoring nothing) if any stashed note's id is in use again
+ * param: uint64 opId
+ * return: error
+ */
+func (db *DB) Undo(opId uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		undoBucket := tx.Bucket([]byte("UndoLog"))
+		if undoBucket == nil || undoBucket.Get(itob(opId)) == nil {
+			return fmt.Errorf("%w: %d", ErrUndoEntryNotFound, opId)
+		}
+		var entry UndoEntry
+		if err := json.Unmarshal(undoBucket.Get(itob(opId)), &entry); err != nil {
+			return err
+		}
+		if err := db.openNotes(entry.Notes); err != nil {
+			return err
+		}
+
+		if err := db.checkNotArchived(tx, entry.Notebook); err != nil {
+			return err
+		}
+		notebookKey := db.notebookKey(entry.Notebook)
+		notebookBucket, err := tx.Bucket([]byte("Notebook")).CreateBucketIfNotExists(notebookKey)
+		if err != nil {
+			return err
+		}
+		if err := ensureNotebookMeta(tx, notebookKey, entry.Notebook); err != nil {
+			return err
+		}
+
+		for _, note := range entry.Notes {
+			noteKey := []byte(strconv.FormatUint(note.Id, 10))
+			if notebookBucket.Get(noteKey) != nil {
+				return fmt.Errorf("%w: note %d in notebook '%s'", ErrUndoConflict, note.Id, entry.Notebook)
+			}
+			db.bumpRevision(&note)
+			if err := db.putNote(tx, notebookKey, note); err != nil {
+				return err
+			}
+		}
+
+		return undoBucket.Delete(itob(opId))
+	})
+}
+
+/**
+ * Stashes given notes into 'UndoLog' bucket as a single undo entry and prunes
+ * entries beyond the configured limit
+ *  - meant to be called within the same transaction as the destructive write
+ * param: *bolt.Tx tx
+ * param: string   operation    Name of destructive operation (like "delete")
+ * param: string   notebookName
+ * param: []Note   notes
+ * return: error
+ */
+func (db *DB) stashForUndo(tx *bolt.Tx, operation string, notebookName string, notes []Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("UndoLog"))
+	if err != nil {
+		return err
+	}
+
+	opId, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	sealed, err := db.sealNotes(tx, db.notebookKey(notebookName), notes)
+	if err != nil {
+		return err
+	}
+	entry := UndoEntry{Id: opId, Operation: operation, Notebook: notebookName, Notes: sealed, CreatedAt: time.Now()}
+	encodedEntry, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(itob(opId), encodedEntry); err != nil {
+		return err
+	}
+
+	// prune oldest entries beyond limit
+	limit := db.undoLimit
+	if limit <= 0 {
+		limit = DefaultUndoLimit
+	}
+	var opIds [][]byte
+	cursor := bucket.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		opIds = append(opIds, append([]byte(nil), k...))
+	}
+	for i := 0; i < len(opIds)-limit; i++ {
+		if err := bucket.Delete(opIds[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Encodes uint64 into 8-byte big-endian key, so that keys sort numerically
+ * (unlike note ids, which are stored as decimal strings)
+ */
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
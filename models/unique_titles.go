@@ -0,0 +1,273 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Notebooks can require titles of their notes to be unique, like names of files (see SetUniqueTitles)
+ *  - titles are compared case-insensitively, surrounding space aside; notes without a title don't count,
+ *    and inferred titles (see titles.go) count as much as given ones
+ *  - every write of a note checks its title against the notebook's title index, within the write's own
+ *    transaction (see putUniqueTitle), so that concurrent writers can't both claim a title: writes of
+ *    notes whose title is taken fail with a *TitleTakenError
+ *  - with auto-suffixing, notes created (added or imported) with a taken title get the first free of
+ *    " (2)", " (3)" .. appended to it instead; updates and renames (see RenameNote) fail all the same
+ *  - requiring unique titles of a notebook whose titles already collide fails with a *TitleCollisionsError
+ *    listing them, changing nothing
+ *  - 'TitleIndex' bucket: notebook key -> 'unique' -> folded title -> note id (8 bytes)
+ *                                      -> 'unique_notes' -> note id -> folded title
+ *    kept only for notebooks requiring unique titles (its presence is what enforces them)
+ */
+
+// returned (wrapped in a *TitleTakenError or *TitleCollisionsError) when titles collide in a notebook requiring unique ones
+var ErrTitleTaken = errors.New("title already taken")
+
+/**
+ * Returned for writes of a note whose title another note of the notebook has
+ *  - NoteId is the note written (zero for notes being created), TakenBy the note having the title
+ */
+type TitleTakenError struct {
+	Notebook string
+	Title    string
+	NoteId   uint64
+	TakenBy  uint64
+}
+
+func (e *TitleTakenError) Error() string {
+	return fmt.Sprintf("%v: '%s' is the title of note %d in notebook '%s'", ErrTitleTaken, e.Title, e.TakenBy, e.Notebook)
+}
+
+func (e *TitleTakenError) Unwrap() error {
+	return ErrTitleTaken
+}
+
+/**
+ * Returned by SetUniqueTitles when titles of the notebook's notes already collide
+ *  - Collisions maps every title shared by several notes onto their ids
+ */
+type TitleCollisionsError struct {
+	Notebook   string
+	Collisions map[string][]uint64
+}
+
+func (e *TitleCollisionsError) Error() string {
+	var titles []string
+	for title := range e.Collisions {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	for i, title := range titles {
+		var ids []string
+		for _, id := range e.Collisions[title] {
+			ids = append(ids, strconv.FormatUint(id, 10))
+		}
+		titles[i] = fmt.Sprintf("'%s' (notes %s)", title, strings.Join(ids, ", "))
+	}
+	return fmt.Sprintf("%v: titles of notebook '%s' collide: %s", ErrTitleTaken, e.Notebook, strings.Join(titles, ", "))
+}
+
+func (e *TitleCollisionsError) Unwrap() error {
+	return ErrTitleTaken
+}
+
+/**
+ * Makes a notebook require unique titles (or stop requiring them); with autoSuffix, notes created with
+ * a taken title get a suffix rather than being rejected (see above)
+ * Fails with a *TitleCollisionsError if titles of the notebook's notes already collide, with
+ * ErrNotebookNotFound if it doesn't exist and with ErrNotebookArchived if it's archived
+ * param: string notebookName
+ * param: bool   unique
+ * param: bool   autoSuffix
+ * return: error
+ */
+func (db *DB) SetUniqueTitles(notebookName string, unique bool, autoSuffix bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		notebookBucket := tx.Bucket([]byte("Notebook")).Bucket(notebookKey)
+		if notebookBucket == nil {
+			return fmt.Errorf("%w: '%s'", ErrNotebookNotFound, notebookName)
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		meta := getNotebookMeta(tx, notebookKey)
+		meta.UniqueTitles, meta.AutoSuffixTitles = unique, unique && autoSuffix
+		if err := putNotebookMeta(tx, notebookKey, meta); err != nil {
+			return err
+		}
+		if err := deleteUniqueTitles(tx, notebookKey); err != nil || !unique {
+			return err
+		}
+
+		// records are read as they are: titles are never encrypted, so content needn't be
+		titles := make(map[string][]uint64)
+		var folded []string
+		if err := notebookBucket.ForEach(func(k, v []byte) error {
+			var note Note
+			if v == nil || json.Unmarshal(v, &note) != nil || foldTitle(note.TitleText) == "" {
+				return nil
+			}
+			noteId, _ := strconv.ParseUint(string(k), 10, 64)
+			title := foldTitle(note.TitleText)
+			if titles[title] == nil {
+				folded = append(folded, title)
+			}
+			titles[title] = append(titles[title], noteId)
+			return nil
+		}); err != nil {
+			return err
+		}
+		collisions := make(map[string][]uint64)
+		for title, ids := range titles {
+			if len(ids) > 1 {
+				sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+				collisions[title] = ids
+			}
+		}
+		if len(collisions) > 0 {
+			return &TitleCollisionsError{Notebook: notebookDisplayName(tx, notebookKey), Collisions: collisions}
+		}
+
+		buckets, err := createSuggestionBuckets(tx, "TitleIndex", notebookKey, "unique", "unique_notes")
+		if err != nil {
+			return err
+		}
+		for _, title := range folded {
+			noteId := titles[title][0]
+			if err := buckets[0].Put([]byte(title), itob(noteId)); err != nil {
+				return err
+			}
+			if err := buckets[1].Put([]byte(strconv.FormatUint(noteId, 10)), []byte(title)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Gives a note another title (an empty one removing its title), checking it's unique in notebooks
+ * requiring unique titles (see above)
+ * Fails with a *TitleTakenError if the title is taken, and with ErrNoteReadOnly if the note is locked read-only
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string title
+ * return: (Note, error) Note as renamed
+ */
+func (db *DB) RenameNote(notebookName string, noteId uint64, title string) (Note, error) {
+	var renamed Note
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, note, err := db.getNoteInTx(tx, notebookName, noteId)
+		if err != nil {
+			return err
+		}
+		if err := checkWritable(notebookName, note, false); err != nil {
+			return err
+		}
+		if err := db.checkNotArchived(tx, notebookName); err != nil {
+			return err
+		}
+		title = strings.TrimSpace(title)
+		if note.TitleText == title && !note.TitleInferred {
+			renamed = note
+			return nil
+		}
+		note.TitleText, note.TitleInferred = title, false
+		db.bumpRevision(&note)
+		renamed = note
+		return db.putNote(tx, db.notebookKey(notebookName), note)
+	})
+	return renamed, err
+}
+
+/**
+ * Title as compared for uniqueness
+ */
+func foldTitle(title string) string {
+	return strings.ToLower(strings.TrimSpace(title))
+}
+
+/**
+ * Replaces the unique title index entry of a note (an empty title removes it), failing with a
+ * *TitleTakenError if another note has the title; does nothing for notebooks not requiring unique titles
+ */
+func putUniqueTitle(tx *bolt.Tx, notebookKey []byte, noteId uint64, title string) error {
+	unique := suggestionBucket(tx, "TitleIndex", notebookKey, "unique")
+	notes := suggestionBucket(tx, "TitleIndex", notebookKey, "unique_notes")
+	if unique == nil || notes == nil {
+		return nil
+	}
+	folded := foldTitle(title)
+	if takenBy := uniqueTitleOwner(unique, folded); takenBy != 0 && takenBy != noteId {
+		return &TitleTakenError{Notebook: notebookDisplayName(tx, notebookKey), Title: title, NoteId: noteId, TakenBy: takenBy}
+	}
+	noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+	if previous := notes.Get(noteIdBytes); previous != nil && string(previous) != folded {
+		if err := unique.Delete(previous); err != nil {
+			return err
+		}
+	}
+	if folded == "" {
+		return notes.Delete(noteIdBytes)
+	}
+	if err := unique.Put([]byte(folded), itob(noteId)); err != nil {
+		return err
+	}
+	return notes.Put(noteIdBytes, []byte(folded))
+}
+
+/**
+ * Gives a note about to be created the first free title of " (2)", " (3)" .. appended to its own,
+ * if its own is taken
+ */
+func (db *DB) suffixTakenTitle(tx *bolt.Tx, notebookKey []byte, p preparedNote) (preparedNote, error) {
+	unique := suggestionBucket(tx, "TitleIndex", notebookKey, "unique")
+	if unique == nil || uniqueTitleOwner(unique, foldTitle(p.note.TitleText)) == 0 {
+		return p, nil
+	}
+	title := strings.TrimSpace(p.note.TitleText)
+	for n := 2; ; n++ {
+		if suffixed := fmt.Sprintf("%s (%d)", title, n); uniqueTitleOwner(unique, foldTitle(suffixed)) == 0 {
+			p.note.TitleText = suffixed
+			break
+		}
+	}
+	return encodeNote(p.note, db.encodingFor(tx, notebookKey))
+}
+
+/**
+ * Id of the note having given (folded) title; zero if none has it
+ */
+func uniqueTitleOwner(unique *bolt.Bucket, folded string) uint64 {
+	if folded == "" {
+		return 0
+	}
+	if owner := unique.Get([]byte(folded)); len(owner) == 8 {
+		return binary.BigEndian.Uint64(owner)
+	}
+	return 0
+}
+
+func deleteUniqueTitles(tx *bolt.Tx, notebookKey []byte) error {
+	index := tx.Bucket([]byte("TitleIndex"))
+	if index == nil || index.Bucket(notebookKey) == nil {
+		return nil
+	}
+	for _, name := range []string{"unique", "unique_notes"} {
+		if index.Bucket(notebookKey).Bucket([]byte(name)) != nil {
+			if err := index.Bucket(notebookKey).DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
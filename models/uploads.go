@@ -0,0 +1,281 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Attachments are streamed into blobs (see attachments.go) chunk by chunk, never held in memory whole
+ *  - content is staged into an upload as it's read, a batch of chunks per write transaction (no transaction
+ *    being held while reading), and hashed along the way
+ *  - once all of it is read, chunks are moved to the blob of their hash (unless stored already), again a
+ *    batch per write transaction, and the attachment is added in a last one
+ *  - commits take turns (on uploadsMu), so that chunks of a blob without metadata are those of the upload
+ *    being committed, which the upload records in case its process dies meanwhile
+ *  - content over the attachment size limit (see SetAttachmentLimit) fails with ErrAttachmentTooLarge as
+ *    soon as it's read; uploads that fail are discarded, and uploads left over when a DB is opened too
+ *  - 'Uploads' bucket: Uploads -> upload id (itob) -> chunk index (itob) -> bytes
+ *                                                 -> "blob" -> hash of the blob chunks are being moved to
+ */
+
+/**
+ * Size attachments are capped at unless set otherwise (see SetAttachmentLimit)
+ */
+const DefaultAttachmentLimit = 64 << 20
+
+/**
+ * Returned when content attached is over the attachment size limit
+ */
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// chunks written (or moved) per write transaction of an upload
+const uploadBatch = 16
+
+/**
+ * Sets how many bytes attachments are capped at (see above)
+ * Zero falls back to DefaultAttachmentLimit; a negative limit lets attachments be of any size
+ */
+func (db *DB) SetAttachmentLimit(limit int64) {
+	db.attachmentLimit = limit
+}
+
+func (db *DB) attachmentLimitSize() int64 {
+	if db.attachmentLimit == 0 {
+		return DefaultAttachmentLimit
+	}
+	return db.attachmentLimit
+}
+
+/**
+ * Content staged by stageUpload
+ */
+type upload struct {
+	id     uint64
+	hash   string
+	size   int64
+	chunks int
+}
+
+/**
+ * Reads content into a new upload, hashing it
+ * Fails (discarding the upload) with ErrAttachmentTooLarge once content is over the attachment size limit,
+ * or with the error reading fails with
+ */
+func (db *DB) stageUpload(r io.Reader) (upload, error) {
+	var staged upload
+	err := db.Update(func(tx *bolt.Tx) error {
+		uploadsBucket, err := tx.CreateBucketIfNotExists([]byte("Uploads"))
+		if err != nil {
+			return err
+		}
+		if staged.id, err = uploadsBucket.NextSequence(); err != nil {
+			return err
+		}
+		_, err = uploadsBucket.CreateBucket(itob(staged.id))
+		return err
+	})
+	if err != nil {
+		return staged, err
+	}
+
+	limit := db.attachmentLimitSize()
+	if limit >= 0 {
+		// (a byte more than the limit tells content over it from content right at it)
+		r = io.LimitReader(r, limit+1)
+	}
+	hash := sha256.New()
+	content := io.TeeReader(r, hash)
+	for done := false; !done; {
+		var batch [][]byte
+		for len(batch) < uploadBatch && !done {
+			chunk := make([]byte, chunkSize)
+			n, err := io.ReadFull(content, chunk)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				done = true
+			} else if err != nil {
+				db.discardUpload(staged.id)
+				return staged, err
+			}
+			staged.size += int64(n)
+			if limit >= 0 && staged.size > limit {
+				db.discardUpload(staged.id)
+				return staged, fmt.Errorf("%w: over %d bytes", ErrAttachmentTooLarge, limit)
+			}
+			// empty content is a single empty chunk, like splitChunks has it
+			if n > 0 || staged.chunks+len(batch) == 0 {
+				batch = append(batch, chunk[:n])
+			}
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			chunksBucket := uploadBucket(tx, staged.id)
+			if chunksBucket == nil {
+				return fmt.Errorf("%w: upload %d", ErrMissingChunks, staged.id)
+			}
+			for i, chunk := range batch {
+				if err := chunksBucket.Put(itob(uint64(staged.chunks+i)), chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.discardUpload(staged.id)
+			return staged, err
+		}
+		staged.chunks += len(batch)
+	}
+	staged.hash = hex.EncodeToString(hash.Sum(nil))
+	return staged, nil
+}
+
+/**
+ * Moves chunks of an upload to the blob of its hash (unless stored already), then calls attach within the
+ * transaction removing the upload, which attach is to reference the blob in
+ * Fails (discarding the upload) with the error attach fails with
+ */
+func (db *DB) commitUpload(staged upload, attach func(tx *bolt.Tx) error) error {
+	db.uploadsMu.Lock()
+	defer db.uploadsMu.Unlock()
+
+	for moved := 0; moved < staged.chunks; {
+		err := db.Update(func(tx *bolt.Tx) error {
+			n, err := moveUploadChunks(tx, staged, moved)
+			moved += n
+			return err
+		})
+		if err != nil {
+			db.discardUpload(staged.id)
+			return err
+		}
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, ok := getBlobMeta(tx, staged.hash); !ok {
+			// chunks moved may have been removed meanwhile, like by Repair
+			chunksBucket := blobBucket(tx, staged.hash)
+			for i := 0; i < staged.chunks; i++ {
+				if chunksBucket == nil || chunksBucket.Get(itob(uint64(i))) == nil {
+					return fmt.Errorf("%w: chunk %d of blob %s", ErrMissingChunks, i, staged.hash)
+				}
+			}
+			if err := putBlobMeta(tx, staged.hash, blobMeta{Size: staged.size, Chunks: staged.chunks}); err != nil {
+				return err
+			}
+		}
+		if err := attach(tx); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("Uploads")).DeleteBucket(itob(staged.id))
+	})
+	if err != nil {
+		db.discardUpload(staged.id)
+	}
+	return err
+}
+
+/**
+ * Moves a batch of chunks of an upload, from the one of given index on, to the blob of its hash
+ * Returns how many chunks were moved; all of them are once the blob is found stored already
+ */
+func moveUploadChunks(tx *bolt.Tx, staged upload, from int) (int, error) {
+	if _, ok := getBlobMeta(tx, staged.hash); ok {
+		return staged.chunks - from, nil
+	}
+	uploadChunks := uploadBucket(tx, staged.id)
+	if uploadChunks == nil {
+		return 0, fmt.Errorf("%w: upload %d", ErrMissingChunks, staged.id)
+	}
+	if err := uploadChunks.Put([]byte("blob"), []byte(staged.hash)); err != nil {
+		return 0, err
+	}
+	blobsBucket, err := tx.CreateBucketIfNotExists([]byte("Blobs"))
+	if err != nil {
+		return 0, err
+	}
+	blobChunks, err := blobsBucket.CreateBucketIfNotExists([]byte(staged.hash))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := from; i < staged.chunks && n < uploadBatch; i++ {
+		key := itob(uint64(i))
+		chunk := uploadChunks.Get(key)
+		if chunk == nil {
+			return n, fmt.Errorf("%w: chunk %d of upload %d", ErrMissingChunks, i, staged.id)
+		}
+		// (copied, as bolt's slice may be remapped by the puts)
+		if err := blobChunks.Put(key, append([]byte(nil), chunk...)); err != nil {
+			return n, err
+		}
+		if err := uploadChunks.Delete(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+/**
+ * Removes an upload, along with chunks it moved to a blob without metadata (which are its own: see above)
+ * Errors are left out, as discarding only follows failures
+ */
+func (db *DB) discardUpload(id uint64) {
+	db.Update(func(tx *bolt.Tx) error {
+		return discardUploadInTx(tx, itob(id))
+	})
+}
+
+func discardUploadInTx(tx *bolt.Tx, key []byte) error {
+	uploadsBucket := tx.Bucket([]byte("Uploads"))
+	if uploadsBucket == nil || uploadsBucket.Bucket(key) == nil {
+		return nil
+	}
+	if hash := uploadsBucket.Bucket(key).Get([]byte("blob")); hash != nil {
+		if _, ok := getBlobMeta(tx, string(hash)); !ok {
+			if err := deleteBlob(tx, string(hash)); err != nil {
+				return err
+			}
+		}
+	}
+	return uploadsBucket.DeleteBucket(key)
+}
+
+/**
+ * Discards all uploads (when a DB is opened, as those left were cut short)
+ */
+func discardUploads(tx *bolt.Tx) error {
+	uploadsBucket := tx.Bucket([]byte("Uploads"))
+	if uploadsBucket == nil {
+		return nil
+	}
+	var keys [][]byte
+	err := uploadsBucket.ForEach(func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := discardUploadInTx(tx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Retrieves (2nd order) chunks bucket of an upload; nil if it doesn't exist
+ */
+func uploadBucket(tx *bolt.Tx, id uint64) *bolt.Bucket {
+	uploadsBucket := tx.Bucket([]byte("Uploads"))
+	if uploadsBucket == nil {
+		return nil
+	}
+	return uploadsBucket.Bucket(itob(id))
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Content of given size, different at every offset of a chunk
+ */
+func uploadContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7 % 251)
+	}
+	return content
+}
+
+/**
+ * Reader of content failing with given error once n bytes of it are read, after calling before (if not nil)
+ */
+type failingReader struct {
+	content io.Reader
+	n       int
+	err     error
+	before  func()
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		if r.before != nil {
+			r.before()
+			r.before = nil
+		}
+		return 0, r.err
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.content.Read(p)
+	r.n -= n
+	return n, err
+}
+
+/**
+ * Fails the test unless no upload is left, and every blob has metadata and is referenced
+ */
+func assertNoOrphanBlobs(t *testing.T, db *DB) {
+	t.Helper()
+	err := db.View(func(tx *bolt.Tx) error {
+		if uploadsBucket := tx.Bucket([]byte("Uploads")); uploadsBucket != nil {
+			if key, _ := uploadsBucket.Cursor().First(); key != nil {
+				t.Errorf("upload %x left", key)
+			}
+		}
+		if blobsBucket := tx.Bucket([]byte("Blobs")); blobsBucket != nil {
+			return blobsBucket.ForEach(func(hash, _ []byte) error {
+				if meta, ok := getBlobMeta(tx, string(hash)); !ok || meta.Refs == 0 {
+					t.Errorf("blob %s left, with metadata %+v", hash, meta)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := db.CheckIntegrity(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity problems %+v (%v)", problems, err)
+	}
+}
+
+func TestAttachmentRoundTripAcrossChunks(t *testing.T) {
+	db := newTestDB(t)
+	note := mustAddNote(t, db, "work", Note{Content: "scans"})
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, uploadBatch*chunkSize + 3} {
+		content := uploadContent(size)
+		attachment, err := db.AddAttachment("work", note.Id, "scan.bin", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attachment.Size != int64(size) || attachment.Hash != contentHash(string(content)) {
+			t.Errorf("attachment of %d bytes %+v", size, attachment)
+		}
+		reader, _, err := db.GetAttachment("work", note.Id, "scan.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(read, content) {
+			t.Errorf("%d bytes read back of %d (%v)", len(read), size, err)
+		}
+	}
+	// every attachment replaced the previous one, releasing its blob
+	assertNoOrphanBlobs(t, db)
+}
+
+func TestAttachmentSeeks(t *testing.T) {
+	db := newTestDB(t)
+	note := mustAddNote(t, db, "work", Note{Content: "scans"})
+	content := uploadContent(3*chunkSize + 100)
+	if _, err := db.AddAttachment("work", note.Id, "scan.bin", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := db.GetAttachment("work", note.Id, "scan.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, seek := range []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{0, io.SeekStart, 0},
+		{chunkSize + 10, io.SeekStart, chunkSize + 10},
+		{chunkSize - 10, io.SeekCurrent, 2 * chunkSize},
+		{-50, io.SeekEnd, int64(len(content)) - 50},
+		{3 * chunkSize, io.SeekStart, 3 * chunkSize},
+		{10, io.SeekStart, 10},
+	} {
+		offset, err := reader.Seek(seek.offset, seek.whence)
+		if err != nil || offset != seek.want {
+			t.Fatalf("seeking %d from %d: %d (%v), want %d", seek.offset, seek.whence, offset, err, seek.want)
+		}
+		// reads span the end of the chunk sought in
+		read := make([]byte, 40)
+		n, err := io.ReadFull(reader, read)
+		if end := seek.want + 40; end > int64(len(content)) {
+			read, err = read[:n], nil
+		}
+		if err != nil || !bytes.Equal(read, content[seek.want:seek.want+int64(len(read))]) {
+			t.Errorf("read after seeking to %d differs (%v)", seek.want, err)
+		}
+		// (reads moved the offset on, which the next SeekCurrent accounts for)
+		if _, err := reader.Seek(seek.want, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+}
+
+func TestInterruptedUploadsLeaveNoOrphanBlobs(t *testing.T) {
+	db := newTestDB(t)
+	note := mustAddNote(t, db, "work", Note{Content: "scans"})
+	content := uploadContent(2*uploadBatch*chunkSize + 5)
+	kept, err := db.AddAttachment("work", note.Id, "kept.bin", bytes.NewReader(uploadContent(1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client going away midway, after batches of chunks were staged
+	broken := errors.New("connection reset")
+	reader := &failingReader{content: bytes.NewReader(content), n: uploadBatch*chunkSize + 7, err: broken}
+	if _, err := db.AddAttachment("work", note.Id, "scan.bin", reader); !errors.Is(err, broken) {
+		t.Errorf("upload cut short: %v, want the reader's error", err)
+	}
+	assertNoOrphanBlobs(t, db)
+
+	// content over the limit
+	db.SetAttachmentLimit(chunkSize)
+	if _, err := db.AddAttachment("work", note.Id, "scan.bin", bytes.NewReader(content)); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Errorf("attaching %d bytes over a limit of %d: %v, want ErrAttachmentTooLarge", len(content), chunkSize, err)
+	}
+	db.SetAttachmentLimit(0)
+	assertNoOrphanBlobs(t, db)
+
+	// the note becoming read-only while content is read: its chunks are moved to their blob, then discarded
+	reader = &failingReader{content: bytes.NewReader(content), n: len(content), err: io.EOF, before: func() {
+		if err := db.LockNoteReadOnly("work", note.Id); err != nil {
+			t.Error(err)
+		}
+	}}
+	if _, err := db.AddAttachment("work", note.Id, "scan.bin", reader); !errors.Is(err, ErrNoteReadOnly) {
+		t.Errorf("attaching to a note locked meanwhile: %v, want ErrNoteReadOnly", err)
+	}
+	assertNoOrphanBlobs(t, db)
+
+	attachments, err := db.ListAttachments("work", note.Id)
+	if err != nil || len(attachments) != 1 || attachments[0].Hash != kept.Hash {
+		t.Errorf("attachments after failed uploads %+v (%v), want only the one kept", attachments, err)
+	}
+}
+
+func TestUploadsLeftAreDiscardedOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	db, err := GetOrCreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a process dying while staging an upload, and another while moving its chunks to their blob
+	content := uploadContent(3 * uploadBatch * chunkSize)
+	if _, err := db.stageUpload(bytes.NewReader(content[:chunkSize+1])); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := db.stageUpload(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := moveUploadChunks(tx, staged, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = GetOrCreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assertNoOrphanBlobs(t, db)
+	err = db.View(func(tx *bolt.Tx) error {
+		if blobBucket(tx, staged.hash) != nil {
+			t.Errorf("chunks moved to blob %s left", staged.hash)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * URLs found in content of notes are indexed whenever a note is saved
+ *  - 'URLs' bucket: URLs -> notebook -> note id -> JSON list of the note's (distinct) URLs
+ *  - notes without URLs have no entry; DBs created before the index existed get it built on open
+ */
+
+/**
+ * Time a single link check may take
+ */
+const DefaultLinkTimeout = 10 * time.Second
+
+/**
+ * Minimum delay between two requests CheckLinks sends to the same host
+ */
+const DefaultHostDelay = time.Second
+
+/**
+ * http(s) URLs within text; trailing punctuation is trimmed off separately
+ */
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+/**
+ * Outcome of checking a link that turned out to be dead
+ *  - Status is the HTTP status received (0 if the request failed, Error saying why)
+ */
+type LinkStatus struct {
+	Ref    NoteRef `json:"ref"`
+	URL    string  `json:"url"`
+	Status int     `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+/**
+ * Sets network access of CheckLinks: the client requests are sent with (http.DefaultClient if nil)
+ * and the minimum delay between two requests to the same host (DefaultHostDelay if not positive)
+ */
+func (db *DB) SetLinkChecking(client *http.Client, hostDelay time.Duration) {
+	db.httpClient = client
+	db.hostDelay = hostDelay
+}
+
+/**
+ * Finds notes (of all notebooks) containing a URL that contains given text (case-insensitively)
+ * Fails with ErrIndexNotBuilt (finding nothing) if the DB has no URL index
+ * param: string urlSubstring
+ * return: ([]NoteRef, error) Refs of matching notes, by notebook and id
+ */
+func (db *DB) ListNotesWithURL(urlSubstring string) ([]NoteRef, error) {
+	var refs []NoteRef
+	urlSubstring = strings.ToLower(urlSubstring)
+	err := db.View(func(tx *bolt.Tx) error {
+		if err := indexBuilt(tx, "URLs"); err != nil {
+			return err
+		}
+		return forEachNoteBucketEntry(tx, "URLs", func(notebookKey, noteIdBytes, v []byte) error {
+			var urls []string
+			if err := json.Unmarshal(v, &urls); err != nil {
+				return err
+			}
+			for _, u := range urls {
+				if strings.Contains(strings.ToLower(u), urlSubstring) {
+					noteId, _ := strconv.ParseUint(string(noteIdBytes), 10, 64)
+					refs = append(refs, NoteRef{Notebook: notebookDisplayName(tx, notebookKey), Id: noteId})
+					break
+				}
+			}
+			return nil
+		})
+	})
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Notebook != refs[j].Notebook {
+			return refs[i].Notebook < refs[j].Notebook
+		}
+		return refs[i].Id < refs[j].Id
+	})
+	return refs, err
+}
+
+/**
+ * Checks the links of all notes of a notebook, reporting the dead ones
+ *  - every distinct URL is requested once (HEAD, falling back to GET for servers
+ *    that don't support HEAD), by up to `concurrency` requests at a time
+ *  - a link is dead if the request fails, times out (DefaultLinkTimeout) or gets a status >= 400
+ *  - requests to the same host are spaced out (see SetLinkChecking)
+ *  - cancelling ctx stops the check, failing with ctx's error
+ * param: context.Context ctx
+ * param: string          notebookName
+ * param: int             concurrency
+ * return: ([]LinkStatus, error) Dead links, grouped by note (by id, then URL)
+ */
+func (db *DB) CheckLinks(ctx context.Context, notebookName string, concurrency int) ([]LinkStatus, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	client := db.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hostDelay := db.hostDelay
+	if hostDelay <= 0 {
+		hostDelay = DefaultHostDelay
+	}
+
+	// note ids by URL
+	notesByURL := make(map[string][]uint64)
+	var displayName string
+	err := db.View(func(tx *bolt.Tx) error {
+		notebookKey := db.notebookKey(notebookName)
+		displayName = notebookDisplayName(tx, notebookKey)
+		bucket := tx.Bucket([]byte("URLs"))
+		if bucket == nil || bucket.Bucket(notebookKey) == nil {
+			return nil
+		}
+		return bucket.Bucket(notebookKey).ForEach(func(noteIdBytes, v []byte) error {
+			var urls []string
+			if err := json.Unmarshal(v, &urls); err != nil {
+				return err
+			}
+			noteId, _ := strconv.ParseUint(string(noteIdBytes), 10, 64)
+			for _, u := range urls {
+				notesByURL[u] = append(notesByURL[u], noteId)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &linkChecker{client: client, hostDelay: hostDelay, nextSlot: make(map[string]time.Time)}
+	urls := make(chan string)
+	var mu sync.Mutex
+	var dead []LinkStatus
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range urls {
+				status, err := checker.check(ctx, u)
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil && status < 400 {
+					continue
+				}
+				mu.Lock()
+				for _, noteId := range notesByURL[u] {
+					link := LinkStatus{Ref: NoteRef{Notebook: displayName, Id: noteId}, URL: u, Status: status}
+					if err != nil {
+						link.Error = err.Error()
+					}
+					dead = append(dead, link)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for u := range notesByURL {
+		select {
+		case urls <- u:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(urls)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		if dead[i].Ref.Id != dead[j].Ref.Id {
+			return dead[i].Ref.Id < dead[j].Ref.Id
+		}
+		return dead[i].URL < dead[j].URL
+	})
+	return dead, nil
+}
+
+/**
+ * Sends link checks, spacing out requests to the same host
+ */
+type linkChecker struct {
+	client    *http.Client
+	hostDelay time.Duration
+	mu        sync.Mutex
+	nextSlot  map[string]time.Time
+}
+
+/**
+ * Requests a URL, returning the status received
+ */
+func (c *linkChecker) check(ctx context.Context, rawURL string) (int, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.wait(ctx, parsed.Host); err != nil {
+		return 0, err
+	}
+	status, err := c.request(ctx, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, http.MethodGet, rawURL)
+	}
+	return status, err
+}
+
+func (c *linkChecker) request(ctx context.Context, method, rawURL string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultLinkTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	// drain a little of the body, so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+/**
+ * Waits for the next free slot of a host (slots of a host being hostDelay apart)
+ */
+func (c *linkChecker) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	now := time.Now()
+	slot := c.nextSlot[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	c.nextSlot[host] = slot.Add(c.hostDelay)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/**
+ * Distinct http(s) URLs within text, in order of first appearance
+ */
+func extractURLs(text string) []string {
+	var urls []string
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		u = trimURL(u)
+		if !containsString(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+/**
+ * Trims punctuation that ends a sentence (or closes brackets around the URL) off a URL
+ */
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?*", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"),
+			last == ']' && strings.Count(u, "[") < strings.Count(u, "]"):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+/**
+ * Replaces the URL index entry of a note (no URLs removes it)
+ */
+func putURLs(tx *bolt.Tx, notebookKey []byte, noteId uint64, urls []string) error {
+	noteIdBytes := []byte(strconv.FormatUint(noteId, 10))
+	if len(urls) == 0 {
+		bucket := tx.Bucket([]byte("URLs"))
+		if bucket == nil || bucket.Bucket(notebookKey) == nil {
+			return nil
+		}
+		return bucket.Bucket(notebookKey).Delete(noteIdBytes)
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("URLs"))
+	if err != nil {
+		return err
+	}
+	notebookURLsBucket, err := bucket.CreateBucketIfNotExists(notebookKey)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(urls)
+	if err != nil {
+		return err
+	}
+	return notebookURLsBucket.Put(noteIdBytes, encoded)
+}
+
+/**
+ * Indexes URLs of all notes (for DBs created before the index existed)
+ */
+func buildURLIndex(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucket([]byte("URLs")); err != nil {
+		return err
+	}
+	rootBucket := tx.Bucket([]byte("Notebook"))
+	return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := rootBucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(k, encodedNote []byte) error {
+			// (DBs predating the index predate encryption too)
+			note, err := readNoteRecord(tx, contentSealer{}, notebookKey, encodedNote)
+			if err != nil {
+				// notes with missing chunks are reported by CheckIntegrity, not fatal here
+				return nil
+			}
+			return putURLs(tx, notebookKey, note.Id, extractURLs(note.Content))
+		})
+	})
+}
+
+/**
+ * Calls fn for every value held in per-note entries of a notebook-keyed bucket (like 'URLs')
+ */
+func forEachNoteBucketEntry(tx *bolt.Tx, bucketName string, fn func(notebookKey, noteIdBytes, v []byte) error) error {
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(notebookKey, _ []byte) error {
+		notebookBucket := bucket.Bucket(notebookKey)
+		if notebookBucket == nil {
+			return nil
+		}
+		return notebookBucket.ForEach(func(noteIdBytes, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			return fn(notebookKey, noteIdBytes, v)
+		})
+	})
+}
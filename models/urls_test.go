@@ -0,0 +1,189 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+func TestListNotesWithURL(t *testing.T) {
+	db := notestest.NewDB(t)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{
+		"home": {"recipe at https://cooking.example.com/bread."},
+		"work": {
+			"design doc (https://docs.example.com/design) and https://wiki.example.com/Onboarding!",
+			"no links here, just example.com",
+			"same doc: https://docs.example.com/design",
+		},
+	}})
+	for _, test := range []struct {
+		substring string
+		want      []models.NoteRef
+	}{
+		{"docs.example.com/design", []models.NoteRef{{Notebook: "work", Id: 1}, {Notebook: "work", Id: 3}}},
+		{"EXAMPLE.COM", []models.NoteRef{{Notebook: "home", Id: 1}, {Notebook: "work", Id: 1}, {Notebook: "work", Id: 3}}},
+		// trailing punctuation and closing brackets aren't part of URLs
+		{"bread.", nil},
+		{"design)", nil},
+		{"onboarding", []models.NoteRef{{Notebook: "work", Id: 1}}},
+	} {
+		got, err := db.ListNotesWithURL(test.substring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ListNotesWithURL(%q) = %v, want %v", test.substring, got, test.want)
+		}
+	}
+
+	// the index follows updates
+	if _, err := db.UpdateNote("home", 1, "recipe lost"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.ListNotesWithURL("cooking"); err != nil || len(got) != 0 {
+		t.Errorf("ListNotesWithURL after the URL was removed = %v, %v", got, err)
+	}
+}
+
+/**
+ * Server answering /ok, /gone (404) and /no-head (405 to HEAD, 200 to GET), counting requests by path
+ */
+func newLinkServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/no-head" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	server, requests := newLinkServer(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	db := notestest.NewDB(t)
+	db.SetLinkChecking(server.Client(), time.Millisecond)
+	notestest.Seed(t, db, notestest.Spec{Notebooks: map[string][]string{"bookmarks": {
+		"alive " + server.URL + "/ok and " + server.URL + "/no-head",
+		"dead " + server.URL + "/gone",
+		"down " + closed.URL + "/ok, and dead again " + server.URL + "/gone",
+	}}})
+
+	dead, err := db.CheckLinks(context.Background(), "bookmarks", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 3 {
+		t.Fatalf("dead links = %+v, want 3", dead)
+	}
+	want := []struct {
+		id     uint64
+		url    string
+		status int
+	}{
+		{2, server.URL + "/gone", http.StatusNotFound},
+		{3, closed.URL + "/ok", 0},
+		{3, server.URL + "/gone", http.StatusNotFound},
+	}
+	if closed.URL > server.URL {
+		want[1], want[2] = want[2], want[1]
+	}
+	for i, link := range dead {
+		if link.Ref != (models.NoteRef{Notebook: "bookmarks", Id: want[i].id}) || link.URL != want[i].url || link.Status != want[i].status {
+			t.Errorf("dead link %d = %+v, want %+v", i, link, want[i])
+		}
+		if (link.Status == 0) != (link.Error != "") {
+			t.Errorf("dead link %d = %+v: only failed requests have an error", i, link)
+		}
+	}
+	// every distinct URL is requested once (and HEAD falls back to GET)
+	for path, n := range map[string]int{"/ok": 1, "/gone": 1, "/no-head": 2} {
+		if got := requests(path); got != n {
+			t.Errorf("%d requests for %s, want %d", got, path, n)
+		}
+	}
+}
+
+func TestCheckLinksSpacesOutRequestsToAHost(t *testing.T) {
+	const hostDelay = 40 * time.Millisecond
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	db := notestest.NewDB(t)
+	db.SetLinkChecking(server.Client(), hostDelay)
+	notestest.MustAdd(t, db, "bookmarks", server.URL+"/a "+server.URL+"/b "+server.URL+"/c "+server.URL+"/d")
+	if _, err := db.CheckLinks(context.Background(), "bookmarks", 4); err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 4 {
+		t.Fatalf("%d requests, want 4", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		// (a little slack for timers firing early relative to the server's clock readings)
+		if gap := times[i].Sub(times[i-1]); gap < hostDelay-5*time.Millisecond {
+			t.Errorf("requests %d and %d to the same host %v apart, want at least %v", i-1, i, gap, hostDelay)
+		}
+	}
+}
+
+func TestCheckLinksCancelled(t *testing.T) {
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	db := notestest.NewDB(t)
+	db.SetLinkChecking(server.Client(), time.Hour)
+	// one request to the host goes out at once, the others wait for the politeness delay
+	notestest.MustAdd(t, db, "bookmarks", server.URL+"/a "+server.URL+"/b "+server.URL+"/c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	start := time.Now()
+	_, err := db.CheckLinks(ctx, "bookmarks", 3)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled check: %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled check returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * Verification of backups against the DB they were taken of (see VerifyBackup)
+ *  - every note is hashed along with what makes it up besides its record (content chunks, and entries of
+ *    attachments, naming their blobs by content hash); hashes of a notebook's notes, in order of keys,
+ *    are hashed into the notebook's hash, so that notebooks are compared whole and notes only where they differ
+ *  - the live DB keeps changing after a backup: where the outbox (see EnableOutbox) is on in both files, it
+ *    tells whether notes that differ were all changed after the backup was taken (the backup is then behind,
+ *    a prefix of the live DB) or not (they diverged); events handed out and deleted since leave it unknown
+ *  - DBs carry no schema version: top-level buckets present in only one of the files are reported instead,
+ *    for information (buckets come with features being used, and some go once empty)
+ */
+
+/**
+ * Outcome of a backup verification
+ */
+type VerifyVerdict string
+
+const (
+	// the backup holds the same notes as the live DB
+	VerifyMatch VerifyVerdict = "match"
+	// notes differ, but only ones changed after the backup was taken (as per the outbox)
+	VerifyBehind VerifyVerdict = "behind"
+	// notes differ, and there's no telling whether they changed since (no outbox, or events gone)
+	VerifyDiffers VerifyVerdict = "differs"
+	// notes differ that weren't changed after the backup was taken, or the backup is ahead of the live DB
+	VerifyDiverged VerifyVerdict = "diverged"
+)
+
+/**
+ * What VerifyBackup found
+ *  - notebooks are named by their keys, as found in the files
+ *  - BackupSeq / LiveSeq are the outbox sequences of the files (zero without outbox); Unexplained are the
+ *    notes that differ without a change since BackupSeq accounting for them (checked only with an outbox)
+ */
+type VerifyReport struct {
+	LivePath               string                 `json:"live_path"`
+	BackupPath             string                 `json:"backup_path"`
+	Verdict                VerifyVerdict          `json:"verdict"`
+	Notebooks              []NotebookVerification `json:"notebooks"`
+	MissingInBackup        []string               `json:"missing_in_backup,omitempty"`
+	MissingInLive          []string               `json:"missing_in_live,omitempty"`
+	Mismatched             []string               `json:"mismatched,omitempty"`
+	BucketsMissingInBackup []string               `json:"buckets_missing_in_backup,omitempty"`
+	BucketsMissingInLive   []string               `json:"buckets_missing_in_live,omitempty"`
+	BackupSeq              uint64                 `json:"backup_seq,omitempty"`
+	LiveSeq                uint64                 `json:"live_seq,omitempty"`
+	Unexplained            []NoteRef              `json:"unexplained,omitempty"`
+}
+
+/**
+ * A notebook as found in either file (hashes are empty where it's missing)
+ */
+type NotebookVerification struct {
+	Notebook    string `json:"notebook"`
+	LiveNotes   int    `json:"live_notes"`
+	BackupNotes int    `json:"backup_notes"`
+	LiveHash    string `json:"live_hash,omitempty"`
+	BackupHash  string `json:"backup_hash,omitempty"`
+}
+
+/**
+ * Whether the backup is good: it matches the live DB, or is only behind it
+ */
+func (r VerifyReport) Passed() bool {
+	return r.Verdict == VerifyMatch || r.Verdict == VerifyBehind
+}
+
+/**
+ * Hashes of the notes of a file, and what else verifying needs of it
+ */
+type verifiedFile struct {
+	// note hashes by notebook key, then note key
+	notes map[string]map[string][]byte
+	// notebook hashes by notebook key
+	hashes  map[string][]byte
+	names   map[string]string
+	buckets map[string]bool
+	outbox  bool
+	seq     uint64
+	// outbox events after the sequence given to readVerifiedFile
+	events []ChangeEvent
+}
+
+/**
+ * Verifies a backup against the live DB it was taken of (see VerifyReport), opening both files read-only
+ * Fails with a *DatabaseLockedError if another process keeps either file open for writing
+ * param: string livePath
+ * param: string backupPath
+ * return: (VerifyReport, error)
+ */
+func VerifyBackup(livePath, backupPath string) (VerifyReport, error) {
+	report := VerifyReport{LivePath: livePath, BackupPath: backupPath}
+	backup, err := readVerifiedFile(backupPath, 0)
+	if err != nil {
+		return report, err
+	}
+	live, err := readVerifiedFile(livePath, backup.seq)
+	if err != nil {
+		return report, err
+	}
+	report.BackupSeq, report.LiveSeq = backup.seq, live.seq
+
+	var differing []NoteRef
+	for _, notebookKey := range unionKeys(live.hashes, backup.hashes) {
+		liveNotes, inLive := live.notes[notebookKey]
+		backupNotes, inBackup := backup.notes[notebookKey]
+		verification := NotebookVerification{Notebook: notebookKey, LiveNotes: len(liveNotes), BackupNotes: len(backupNotes)}
+		if inLive {
+			verification.LiveHash = hex.EncodeToString(live.hashes[notebookKey])
+		}
+		if inBackup {
+			verification.BackupHash = hex.EncodeToString(backup.hashes[notebookKey])
+		}
+		report.Notebooks = append(report.Notebooks, verification)
+
+		name := live.names[notebookKey]
+		switch {
+		case !inBackup:
+			report.MissingInBackup = append(report.MissingInBackup, notebookKey)
+		case !inLive:
+			report.MissingInLive = append(report.MissingInLive, notebookKey)
+			name = backup.names[notebookKey]
+			if len(backupNotes) == 0 {
+				// (notebooks are never deleted: an empty one can't be explained by changes)
+				differing = append(differing, NoteRef{Notebook: name})
+			}
+		case !bytes.Equal(live.hashes[notebookKey], backup.hashes[notebookKey]):
+			report.Mismatched = append(report.Mismatched, notebookKey)
+		default:
+			continue
+		}
+		for _, noteKey := range unionKeys(liveNotes, backupNotes) {
+			if !bytes.Equal(liveNotes[noteKey], backupNotes[noteKey]) {
+				noteId, _ := strconv.ParseUint(noteKey, 10, 64)
+				differing = append(differing, NoteRef{Notebook: name, Id: noteId})
+			}
+		}
+	}
+	for name := range live.buckets {
+		if !backup.buckets[name] {
+			report.BucketsMissingInBackup = append(report.BucketsMissingInBackup, name)
+		}
+	}
+	for name := range backup.buckets {
+		if !live.buckets[name] {
+			report.BucketsMissingInLive = append(report.BucketsMissingInLive, name)
+		}
+	}
+	sort.Strings(report.BucketsMissingInBackup)
+	sort.Strings(report.BucketsMissingInLive)
+
+	switch {
+	case len(differing) == 0:
+		report.Verdict = VerifyMatch
+	case !live.outbox || !backup.outbox:
+		report.Verdict = VerifyDiffers
+	case backup.seq > live.seq:
+		report.Verdict = VerifyDiverged
+	case uint64(len(live.events)) != live.seq-backup.seq:
+		// events since the backup were handed out (and deleted) already
+		report.Verdict = VerifyDiffers
+	default:
+		changed := make(map[NoteRef]bool)
+		for _, event := range live.events {
+			changed[NoteRef{Notebook: event.Notebook, Id: event.NoteId}] = true
+		}
+		for _, ref := range differing {
+			if !changed[ref] {
+				report.Unexplained = append(report.Unexplained, ref)
+			}
+		}
+		report.Verdict = VerifyBehind
+		if len(report.Unexplained) > 0 {
+			report.Verdict = VerifyDiverged
+		}
+	}
+	return report, nil
+}
+
+/**
+ * Hashes the notes of a DB file, reading the outbox events after given sequence
+ */
+func readVerifiedFile(path string, since uint64) (verifiedFile, error) {
+	file := verifiedFile{
+		notes:   make(map[string]map[string][]byte),
+		hashes:  make(map[string][]byte),
+		names:   make(map[string]string),
+		buckets: make(map[string]bool),
+	}
+	boltDB, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: DefaultOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return file, &DatabaseLockedError{Path: path, Holder: readLockInfo(path)}
+	}
+	if err != nil {
+		return file, err
+	}
+	defer boltDB.Close()
+
+	err = boltDB.View(func(tx *bolt.Tx) error {
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			file.buckets[string(name)] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		settings, err := getSettings(tx)
+		if err != nil {
+			return err
+		}
+		file.outbox = settings.Outbox
+		if outbox := tx.Bucket([]byte("Outbox")); outbox != nil {
+			file.seq = outbox.Sequence()
+			cursor := outbox.Cursor()
+			for k, v := cursor.Seek(itob(since + 1)); k != nil; k, v = cursor.Next() {
+				var event ChangeEvent
+				if err := json.Unmarshal(v, &event); err != nil {
+					return err
+				}
+				file.events = append(file.events, event)
+			}
+		}
+
+		rootBucket := tx.Bucket([]byte("Notebook"))
+		if rootBucket == nil {
+			return nil
+		}
+		return rootBucket.ForEach(func(notebookKey, _ []byte) error {
+			notebookBucket := rootBucket.Bucket(notebookKey)
+			if notebookBucket == nil {
+				return nil
+			}
+			notes := make(map[string][]byte)
+			notebookHash := sha256.New()
+			err := notebookBucket.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				noteHash := hashNote(tx, notebookKey, k, v)
+				notes[string(k)] = noteHash
+				hashField(notebookHash, k)
+				notebookHash.Write(noteHash)
+				return nil
+			})
+			file.notes[string(notebookKey)] = notes
+			file.hashes[string(notebookKey)] = notebookHash.Sum(nil)
+			file.names[string(notebookKey)] = notebookDisplayName(tx, notebookKey)
+			return err
+		})
+	})
+	return file, err
+}
+
+/**
+ * Hashes a note record along with its content chunks and attachment entries
+ */
+func hashNote(tx *bolt.Tx, notebookKey, key, record []byte) []byte {
+	hash := sha256.New()
+	hashField(hash, key)
+	hashField(hash, record)
+	for _, rootName := range []string{"Chunks", "Attachments"} {
+		rootBucket := tx.Bucket([]byte(rootName))
+		if rootBucket == nil || rootBucket.Bucket(notebookKey) == nil {
+			continue
+		}
+		if noteBucket := rootBucket.Bucket(notebookKey).Bucket(key); noteBucket != nil {
+			hash.Write([]byte(rootName))
+			noteBucket.ForEach(func(k, v []byte) error {
+				hashField(hash, k)
+				hashField(hash, v)
+				return nil
+			})
+		}
+	}
+	return hash.Sum(nil)
+}
+
+/**
+ * Writes a length-prefixed field to a hash, so that fields can't run into one another
+ */
+func hashField(hash interface{ Write([]byte) (int, error) }, field []byte) {
+	hash.Write(itob(uint64(len(field))))
+	hash.Write(field)
+}
+
+/**
+ * Sorted keys of either of two maps
+ */
+func unionKeys(a, b map[string][]byte) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,2156 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * A workspace is a single handle on several DB files, like personal and work notes kept apart
+ * for backup-policy reasons; it is a Datastore like DB
+ *  - every file is mounted under a name, and holds the notebooks whose names start with its prefix
+ *    (like 'work/'); the longest matching prefix wins, and a mount without prefix holds the rest
+ *  - notebooks can also be routed to a mount explicitly (see Route), whatever their name
+ *  - notebooks keep their full names in their file ('work/projects' is stored as such)
+ *  - calls about a notebook (or a note) go to its file; calls spanning notebooks of several files (relating
+ *    notes, multi-notebook transactions, batches, rollups ..) fail with ErrInvalidQuery
+ *  - calls across notebooks (like SearchAllNotebooks) go to every file, their results merged; so do
+ *    maintenance calls (like PurgeExpired), to every writable file
+ *  - settings of DBs (like SetContentNormalization) and notebook templates go to every writable file, so that
+ *    they all behave the same; recurring rules, favorites and shares are kept by the file of their notebook
+ *  - what's numbered by a file of its own (filing rules, API tokens, the undo log, the outbox, sync and mirroring
+ *    state) and takeouts are kept by the home mount: the one without prefix, or else the first by name;
+ *    settings are read from it too
+ *  - files that can't be opened leave their mount unavailable: calls routed to it fail with
+ *    ErrDatabaseUnavailable, and calls across notebooks return what the other files have, along with
+ *    a *WorkspaceUnavailableError naming the mounts left out
+ *  - read-only mounts have their file opened read-only (see OpenReadOnlyDB: it isn't created, and other
+ *    processes can read it meanwhile), and fail writes with ErrDatabaseReadOnly
+ *  - moving a note between files (see MoveNoteWithReport) copies it and then deletes it, which isn't atomic
+ */
+
+var (
+	// returned for calls routed to a mount whose file couldn't be opened
+	ErrDatabaseUnavailable = errors.New("database unavailable")
+	// returned for writes routed to a read-only mount
+	ErrDatabaseReadOnly = errors.New("database is read-only")
+	// returned for notebooks no mount holds (a workspace without a mount lacking prefix)
+	ErrNoDatabase = errors.New("no database holds notebook")
+)
+
+/**
+ * A DB file of a workspace (see above)
+ */
+type WorkspaceMount struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Prefix   string `json:"prefix,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+/**
+ * State of a mount, as reported by Workspace.Mounts: Err is why its file couldn't be opened, if it couldn't
+ */
+type WorkspaceMountStatus struct {
+	WorkspaceMount
+	Available bool  `json:"available"`
+	Err       error `json:"-"`
+}
+
+/**
+ * Returned (along with what the available files have) by calls across notebooks when some files are unavailable
+ */
+type WorkspaceUnavailableError struct {
+	Mounts []string
+}
+
+func (e *WorkspaceUnavailableError) Error() string {
+	return fmt.Sprintf("%v: results leave out mount(s) %s", ErrDatabaseUnavailable, strings.Join(e.Mounts, ", "))
+}
+
+func (e *WorkspaceUnavailableError) Unwrap() error {
+	return ErrDatabaseUnavailable
+}
+
+/**
+ * What MoveNoteWithReport did: where the note went, and whether that happened in a single transaction
+ *  - Warnings tell what moving between files couldn't take along (and that it isn't atomic)
+ */
+type WorkspaceMoveReport struct {
+	From     NoteRef  `json:"from"`
+	To       NoteRef  `json:"to"`
+	Atomic   bool     `json:"atomic"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type Workspace struct {
+	mu sync.RWMutex
+	// mounts by decreasing length of prefix (so that the longest matching one comes first)
+	mounts []*workspaceMount
+	// notebooks routed explicitly, by notebook name
+	routes map[string]*workspaceMount
+	closed bool
+}
+
+type workspaceMount struct {
+	WorkspaceMount
+	db  *DB
+	err error
+}
+
+/**
+ * File of an available mount, as taken by calls across files (see each)
+ */
+type mountedDB struct {
+	name     string
+	db       *DB
+	readOnly bool
+}
+
+var _ Datastore = (*Workspace)(nil)
+
+/**
+ * <Constructor for Workspace>
+ * Opens the files of given mounts (read-only ones with OpenReadOnlyDB); files that can't be opened leave their
+ * mount unavailable (see above) rather than failing
+ * Fails with ErrInvalidQuery if mounts lack names or share a name or prefix
+ * param: ...WorkspaceMount mounts
+ * return: (*Workspace, error)
+ */
+func OpenWorkspace(mounts ...WorkspaceMount) (*Workspace, error) {
+	names, prefixes := make(map[string]bool), make(map[string]bool)
+	for _, mount := range mounts {
+		switch {
+		case mount.Name == "":
+			return nil, fmt.Errorf("%w: mount of '%s' has no name", ErrInvalidQuery, mount.Path)
+		case names[mount.Name]:
+			return nil, fmt.Errorf("%w: mounts share name '%s'", ErrInvalidQuery, mount.Name)
+		case prefixes[mount.Prefix]:
+			return nil, fmt.Errorf("%w: mounts share prefix '%s'", ErrInvalidQuery, mount.Prefix)
+		}
+		names[mount.Name], prefixes[mount.Prefix] = true, true
+	}
+
+	w := &Workspace{routes: make(map[string]*workspaceMount)}
+	for _, mount := range mounts {
+		m := &workspaceMount{WorkspaceMount: mount}
+		m.db, m.err = openMount(mount)
+		w.mounts = append(w.mounts, m)
+	}
+	sort.SliceStable(w.mounts, func(i, j int) bool { return len(w.mounts[i].Prefix) > len(w.mounts[j].Prefix) })
+	return w, nil
+}
+
+func openMount(mount WorkspaceMount) (*DB, error) {
+	if mount.ReadOnly {
+		return OpenReadOnlyDB(mount.Path)
+	}
+	return GetOrCreateDB(mount.Path)
+}
+
+/**
+ * Closes the files of the workspace; calls running meanwhile fail with ErrClosed (or ErrDatabaseUnavailable)
+ * return: error The first failure to close a file, if any
+ */
+func (w *Workspace) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	var first error
+	for _, m := range w.mounts {
+		if m.db == nil {
+			continue
+		}
+		if err := m.db.Close(); err != nil && first == nil {
+			first = err
+		}
+		m.db, m.err = nil, ErrClosed
+	}
+	return first
+}
+
+/**
+ * Routes a notebook to a mount whatever its name (see above); an empty mount name drops the route
+ * Fails with ErrInvalidQuery if there's no mount by given name
+ * param: string notebookName
+ * param: string mountName
+ * return: error
+ */
+func (w *Workspace) Route(notebookName, mountName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if mountName == "" {
+		delete(w.routes, notebookName)
+		return nil
+	}
+	m, err := w.mount(mountName)
+	if err != nil {
+		return err
+	}
+	w.routes[notebookName] = m
+	return nil
+}
+
+/**
+ * Marks a mount read-only (or writable again), reopening its file accordingly; calls running on the file
+ * meanwhile fail with ErrClosed
+ * Fails with ErrInvalidQuery if there's no mount by given name, with ErrClosed if the workspace is closed,
+ * and as opening the file does (leaving the mount unavailable)
+ * param: string mountName
+ * param: bool   readOnly
+ * return: error
+ */
+func (w *Workspace) SetReadOnly(mountName string, readOnly bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m, err := w.mount(mountName)
+	switch {
+	case err != nil:
+		return err
+	case w.closed:
+		return ErrClosed
+	case m.ReadOnly == readOnly && m.db != nil:
+		return nil
+	}
+	if m.db != nil {
+		if err := m.db.Close(); err != nil {
+			return err
+		}
+	}
+	m.ReadOnly = readOnly
+	m.db, m.err = openMount(m.WorkspaceMount)
+	return m.err
+}
+
+func (w *Workspace) mount(mountName string) (*workspaceMount, error) {
+	for _, m := range w.mounts {
+		if m.Name == mountName {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no mount '%s'", ErrInvalidQuery, mountName)
+}
+
+/**
+ * Retrieves the mounts of the workspace, in order of names
+ * return: []WorkspaceMountStatus
+ */
+func (w *Workspace) Mounts() []WorkspaceMountStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var mounts []WorkspaceMountStatus
+	for _, m := range w.mounts {
+		mounts = append(mounts, WorkspaceMountStatus{WorkspaceMount: m.WorkspaceMount, Available: m.db != nil, Err: m.err})
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Name < mounts[j].Name })
+	return mounts
+}
+
+/**
+ * Name of the mount holding a notebook
+ * Fails with ErrNoDatabase if no mount holds it
+ * param: string notebookName
+ * return: (string, error)
+ */
+func (w *Workspace) MountOf(notebookName string) (string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	m, err := w.route(notebookName)
+	if err != nil {
+		return "", err
+	}
+	return m.Name, nil
+}
+
+func (w *Workspace) route(notebookName string) (*workspaceMount, error) {
+	if m, ok := w.routes[notebookName]; ok {
+		return m, nil
+	}
+	for _, m := range w.mounts {
+		if strings.HasPrefix(notebookName, m.Prefix) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrNoDatabase, notebookName)
+}
+
+/**
+ * Mount keeping what's numbered by a file of its own (see above): the one without prefix, or else the first by name
+ */
+func (w *Workspace) home() *workspaceMount {
+	var home *workspaceMount
+	for _, m := range w.mounts {
+		if m.Prefix == "" {
+			return m
+		}
+		if home == nil || m.Name < home.Name {
+			home = m
+		}
+	}
+	return home
+}
+
+/**
+ * File of a mount, checked to be available, and writable if it's for a write
+ */
+func (m *workspaceMount) open(write bool, what string) (*DB, error) {
+	switch {
+	case m.db == nil:
+		return nil, fmt.Errorf("%w: mount '%s' ('%s'): %v", ErrDatabaseUnavailable, m.Name, m.Path, m.err)
+	case write && m.ReadOnly:
+		return nil, fmt.Errorf("%w: mount '%s' holding %s", ErrDatabaseReadOnly, m.Name, what)
+	}
+	return m.db, nil
+}
+
+/**
+ * File holding a notebook, checked to be writable if it's for a write
+ */
+func (w *Workspace) open(notebookName string, write bool) (*DB, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	m, err := w.route(notebookName)
+	if err != nil {
+		return nil, err
+	}
+	return m.open(write, fmt.Sprintf("notebook '%s'", notebookName))
+}
+
+/**
+ * File holding all of given notebooks (the home mount's if there are none), checked to be writable if it's
+ * for a write
+ * Fails with ErrInvalidQuery if the notebooks are in different files
+ */
+func (w *Workspace) openSame(write bool, notebookNames ...string) (*DB, error) {
+	if len(notebookNames) == 0 {
+		return w.openHome(write)
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var first *workspaceMount
+	for _, notebookName := range notebookNames {
+		m, err := w.route(notebookName)
+		switch {
+		case err != nil:
+			return nil, err
+		case first == nil:
+			first = m
+		case m != first:
+			return nil, fmt.Errorf("%w: notebooks '%s' and '%s' are in different database files ('%s' and '%s')",
+				ErrInvalidQuery, notebookNames[0], notebookName, first.Name, m.Name)
+		}
+	}
+	return first.open(write, fmt.Sprintf("notebook '%s'", notebookNames[0]))
+}
+
+/**
+ * File of the home mount (see above), checked to be writable if it's for a write
+ */
+func (w *Workspace) openHome(write bool) (*DB, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	m := w.home()
+	if m == nil {
+		return nil, fmt.Errorf("%w: the workspace has no mount", ErrNoDatabase)
+	}
+	return m.open(write, "what the workspace keeps in one file")
+}
+
+/**
+ * Takes the files of the available mounts (in order of mount names), along with names of the others;
+ * the files are taken under the lock, so that Close (or SetReadOnly) can't swap them out meanwhile, but
+ * used after it's released (closed files then failing calls with ErrClosed)
+ */
+func (w *Workspace) available() ([]mountedDB, []string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var dbs []mountedDB
+	var unavailable []string
+	for _, m := range w.mounts {
+		if m.db == nil {
+			unavailable = append(unavailable, m.Name)
+			continue
+		}
+		dbs = append(dbs, mountedDB{name: m.Name, db: m.db, readOnly: m.ReadOnly})
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].name < dbs[j].name })
+	sort.Strings(unavailable)
+	return dbs, unavailable
+}
+
+/**
+ * Calls fn for the file of every available mount (writable ones only, if it's for a write), returning a
+ * *WorkspaceUnavailableError naming the others, if any
+ */
+func (w *Workspace) each(write bool, fn func(db *DB) error) error {
+	dbs, unavailable := w.available()
+	for _, mounted := range dbs {
+		if write && mounted.readOnly {
+			continue
+		}
+		if err := fn(mounted.db); err != nil {
+			return err
+		}
+	}
+	if len(unavailable) > 0 {
+		return &WorkspaceUnavailableError{Mounts: unavailable}
+	}
+	return nil
+}
+
+/**
+ * Calls fn for the file of every available mount (writable ones only, if it's for a write) until one has what
+ * it's looking for: calls failing with notFound move on to the next file
+ * Fails with notFound (wrapped as the last file did) if none has it
+ */
+func (w *Workspace) find(write bool, notFound error, fn func(db *DB) error) error {
+	err := notFound
+	dbs, _ := w.available()
+	for _, mounted := range dbs {
+		if write && mounted.readOnly {
+			continue
+		}
+		if err = fn(mounted.db); !errors.Is(err, notFound) {
+			return err
+		}
+	}
+	return err
+}
+
+// notebook-related operations
+
+func (w *Workspace) NotebookExists(notebookName string) (bool, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return false, err
+	}
+	return db.NotebookExists(notebookName)
+}
+
+func (w *Workspace) GetNotebook(notebookName string) (Notebook, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return Notebook{}, err
+	}
+	return db.GetNotebook(notebookName)
+}
+
+func (w *Workspace) AddNotebook(notebook Notebook) error {
+	db, err := w.open(notebook.Name, true)
+	if err != nil {
+		return err
+	}
+	return db.AddNotebook(notebook)
+}
+
+/**
+ * Retrieves the notebooks of all files (along with their notes), in order of names
+ */
+func (w *Workspace) GetAllNotebooks() ([]Notebook, error) {
+	var notebooks []Notebook
+	err := w.each(false, func(db *DB) error {
+		found, err := db.GetAllNotebooks()
+		notebooks = append(notebooks, found...)
+		return err
+	})
+	sort.Slice(notebooks, func(i, j int) bool { return notebooks[i].Name < notebooks[j].Name })
+	return notebooks, err
+}
+
+/**
+ * Retrieves names of the notebooks of all files, in order (see DB.GetAllNotebookNames)
+ */
+func (w *Workspace) GetAllNotebookNames(opts ...NotebookOption) ([]string, error) {
+	var notebookNames []string
+	err := w.each(false, func(db *DB) error {
+		names, err := db.GetAllNotebookNames(opts...)
+		notebookNames = append(notebookNames, names...)
+		return err
+	})
+	sort.Strings(notebookNames)
+	return notebookNames, err
+}
+
+func (w *Workspace) GetNotebookInfo(notebookName string) (NotebookInfo, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return NotebookInfo{}, err
+	}
+	return db.GetNotebookInfo(notebookName)
+}
+
+func (w *Workspace) SetNotebookDefaults(notebookName string, defaults NotebookDefaults) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.SetNotebookDefaults(notebookName, defaults)
+}
+
+func (w *Workspace) ArchiveNotebook(notebookName string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.ArchiveNotebook(notebookName)
+}
+
+func (w *Workspace) UnarchiveNotebook(notebookName string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.UnarchiveNotebook(notebookName)
+}
+
+func (w *Workspace) SetUniqueTitles(notebookName string, unique bool, autoSuffix bool) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.SetUniqueTitles(notebookName, unique, autoSuffix)
+}
+
+/**
+ * Adds up counts of all files (see DB.GetDBStats)
+ */
+func (w *Workspace) GetDBStats() (DBStats, error) {
+	var stats DBStats
+	err := w.each(false, func(db *DB) error {
+		fileStats, err := db.GetDBStats()
+		stats.ActiveNotebooks += fileStats.ActiveNotebooks
+		stats.ArchivedNotebooks += fileStats.ArchivedNotebooks
+		stats.Notes += fileStats.Notes
+		return err
+	})
+	return stats, err
+}
+
+// note-related operations
+
+func (w *Workspace) NoteExists(notebookName string, noteId uint64) (bool, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return false, err
+	}
+	return db.NoteExists(notebookName, noteId)
+}
+
+func (w *Workspace) GetNote(notebookName string, noteId uint64) (Note, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.GetNote(notebookName, noteId)
+}
+
+func (w *Workspace) SearchNotes(notebookName string, query string, opts ...SearchOption) ([]SearchResult, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.SearchNotes(notebookName, query, opts...)
+}
+
+func (w *Workspace) SearchEach(notebookName string, query string, fn func(SearchResult) error, opts ...SearchOption) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.SearchEach(notebookName, query, fn, opts...)
+}
+
+func (w *Workspace) SearchStream(notebookName string, query string, fn func(SearchResult) (more bool, err error), opts ...SearchOption) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.SearchStream(notebookName, query, fn, opts...)
+}
+
+/**
+ * Searches notebooks of all files, ranking results together (see DB.SearchAllNotebooks)
+ */
+func (w *Workspace) SearchAllNotebooks(query string, opts ...SearchOption) ([]SearchResult, error) {
+	return w.SearchAllNotebooksContext(context.Background(), query, opts...)
+}
+
+func (w *Workspace) SearchAllNotebooksContext(ctx context.Context, query string, opts ...SearchOption) ([]SearchResult, error) {
+	var results []SearchResult
+	err := w.each(false, func(db *DB) error {
+		found, err := db.SearchAllNotebooksContext(ctx, query, opts...)
+		results = append(results, found...)
+		return err
+	})
+	sortResults(results)
+	return results, err
+}
+
+func (w *Workspace) AddNotes(notebookName string, noteContents ...string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.AddNotes(notebookName, noteContents...)
+}
+
+func (w *Workspace) AddNote(notebookName string, note Note) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return note, err
+	}
+	return db.AddNote(notebookName, note)
+}
+
+func (w *Workspace) ReserveNoteIDs(notebookName string, n int) ([]uint64, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return nil, err
+	}
+	return db.ReserveNoteIDs(notebookName, n)
+}
+
+func (w *Workspace) PutNote(notebookName string, note Note) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return note, err
+	}
+	return db.PutNote(notebookName, note)
+}
+
+func (w *Workspace) ListNotes(notebookName string, opts ...ListOption) ([]Note, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListNotes(notebookName, opts...)
+}
+
+func (w *Workspace) ListNoteSummaries(notebookName string, previewLength int, opts ...ListOption) ([]NoteSummary, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListNoteSummaries(notebookName, previewLength, opts...)
+}
+
+/**
+ * Starts a query over notes of given notebook (see DB.Query); if no available file holds the notebook,
+ * running the query fails as routing it did
+ */
+func (w *Workspace) Query(notebookName string) *Query {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return &Query{notebookName: notebookName, err: err}
+	}
+	return db.Query(notebookName)
+}
+
+func (w *Workspace) DeleteNotes(notebookName string, noteIds ...uint64) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.DeleteNotes(notebookName, noteIds...)
+}
+
+func (w *Workspace) DeleteNotesWithOptions(notebookName string, noteIds []uint64, opts ...WriteOption) ([]uint64, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return nil, err
+	}
+	return db.DeleteNotesWithOptions(notebookName, noteIds, opts...)
+}
+
+func (w *Workspace) PlanDelete(notebookName string, noteIds []uint64, opts ...WriteOption) (DeletePlan, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return DeletePlan{}, err
+	}
+	return db.PlanDelete(notebookName, noteIds, opts...)
+}
+
+/**
+ * Carries out the delete planned by PlanDelete in whichever file planned it (see DB.ExecuteDelete)
+ */
+func (w *Workspace) ExecuteDelete(token string) ([]uint64, error) {
+	var skipped []uint64
+	err := w.find(true, ErrDeletePlanNotFound, func(db *DB) error {
+		var err error
+		skipped, err = db.ExecuteDelete(token)
+		return err
+	})
+	return skipped, err
+}
+
+func (w *Workspace) UpdateNote(notebookName string, noteId uint64, content string, opts ...WriteOption) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.UpdateNote(notebookName, noteId, content, opts...)
+}
+
+func (w *Workspace) LockNoteReadOnly(notebookName string, noteId uint64) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.LockNoteReadOnly(notebookName, noteId)
+}
+
+func (w *Workspace) UnlockNoteReadOnly(notebookName string, noteId uint64) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.UnlockNoteReadOnly(notebookName, noteId)
+}
+
+func (w *Workspace) SetNoteKind(notebookName string, noteId uint64, kind string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.SetNoteKind(notebookName, noteId, kind)
+}
+
+func (w *Workspace) RenameNote(notebookName string, noteId uint64, title string) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.RenameNote(notebookName, noteId, title)
+}
+
+/**
+ * Moves a note into another notebook, possibly of another file (see MoveNoteWithReport)
+ */
+func (w *Workspace) MoveNote(notebookName string, noteId uint64, targetName string) (Note, error) {
+	moved, _, err := w.moveNote(notebookName, noteId, targetName)
+	return moved, err
+}
+
+/**
+ * Moves a note into another notebook (see DB.MoveNote), telling how
+ *  - within a file, the move happens in a single transaction
+ *  - between files, the note is exported (with attachments and history) and imported into the other file,
+ *    then deleted: a failure in between leaves it in both notebooks, relations are dropped, and favorites
+ *    go to the end of the other file's favorites; the report warns about all of it
+ * param: string notebookName
+ * param: uint64 noteId
+ * param: string targetName
+ * return: (WorkspaceMoveReport, error)
+ */
+func (w *Workspace) MoveNoteWithReport(notebookName string, noteId uint64, targetName string) (WorkspaceMoveReport, error) {
+	_, report, err := w.moveNote(notebookName, noteId, targetName)
+	return report, err
+}
+
+func (w *Workspace) moveNote(notebookName string, noteId uint64, targetName string) (Note, WorkspaceMoveReport, error) {
+	report := WorkspaceMoveReport{From: NoteRef{Notebook: notebookName, Id: noteId}}
+	source, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, report, err
+	}
+	target, err := w.open(targetName, true)
+	if err != nil {
+		return Note{}, report, err
+	}
+	if source == target {
+		moved, err := source.MoveNote(notebookName, noteId, targetName)
+		report.To, report.Atomic = NoteRef{Notebook: targetName, Id: moved.Id}, err == nil
+		return moved, report, err
+	}
+
+	report.Warnings = append(report.Warnings, "moved between database files by copying then deleting: not atomic")
+	note, err := source.GetNote(notebookName, noteId)
+	if err != nil {
+		return Note{}, report, err
+	}
+	if err := checkWritable(notebookName, note, false); err != nil {
+		return Note{}, report, err
+	}
+	var export bytes.Buffer
+	if err := source.ExportNote(notebookName, noteId, &export, NoteExportOptions{IncludeHistory: true}); err != nil {
+		return Note{}, report, err
+	}
+	if related, err := source.GetRelations(report.From, "", AnyDirection); err == nil && len(related) > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d relation(s) of the note were dropped", len(related)))
+	}
+	favorite := false
+	if favorites, err := source.ListFavorites(); err == nil {
+		for _, result := range favorites {
+			favorite = favorite || result.Ref == report.From
+		}
+	}
+	moved, err := target.ImportNote(targetName, &export, ImportOptions{})
+	if err != nil {
+		return Note{}, report, err
+	}
+	report.To = NoteRef{Notebook: targetName, Id: moved.Id}
+	if favorite {
+		if err := target.AddFavorite(report.To); err == nil {
+			report.Warnings = append(report.Warnings, "the note went to the end of favorites of the other file")
+		}
+	}
+	if err := source.DeleteNotes(notebookName, noteId); err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("the note was copied but not deleted, so it's in both notebooks: %v", err))
+		return moved, report, err
+	}
+	return moved, report, nil
+}
+
+func (w *Workspace) SplitNote(notebookName string, noteId uint64, opts SplitOptions) ([]Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return nil, err
+	}
+	return db.SplitNote(notebookName, noteId, opts)
+}
+
+func (w *Workspace) MoveNoteBefore(notebookName string, noteId uint64, beforeId uint64) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.MoveNoteBefore(notebookName, noteId, beforeId)
+}
+
+func (w *Workspace) MoveNoteToEnd(notebookName string, noteId uint64) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.MoveNoteToEnd(notebookName, noteId)
+}
+
+func (w *Workspace) UpdateNoteIfRevision(notebookName string, noteId uint64, expectedRev uint64, content string, opts ...WriteOption) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.UpdateNoteIfRevision(notebookName, noteId, expectedRev, content, opts...)
+}
+
+func (w *Workspace) StaleNotes(notebookName string, olderThan time.Duration, limit int) ([]NoteRef, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.StaleNotes(notebookName, olderThan, limit)
+}
+
+/**
+ * Retrieves notes of all files linking to URLs having given substring, in order of notebooks and ids
+ */
+func (w *Workspace) ListNotesWithURL(urlSubstring string) ([]NoteRef, error) {
+	var refs []NoteRef
+	err := w.each(false, func(db *DB) error {
+		found, err := db.ListNotesWithURL(urlSubstring)
+		refs = append(refs, found...)
+		return err
+	})
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Notebook != refs[j].Notebook {
+			return refs[i].Notebook < refs[j].Notebook
+		}
+		return refs[i].Id < refs[j].Id
+	})
+	return refs, err
+}
+
+func (w *Workspace) ActivityHistogram(notebookName string, from, to time.Time, bucket time.Duration) ([]ActivityBucket, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ActivityHistogram(notebookName, from, to, bucket)
+}
+
+/**
+ * Adds up activity of all files, bucket by bucket (see DB.ActivityHistogramAll)
+ */
+func (w *Workspace) ActivityHistogramAll(from, to time.Time, bucket time.Duration) ([]ActivityBucket, error) {
+	var buckets []ActivityBucket
+	err := w.each(false, func(db *DB) error {
+		found, err := db.ActivityHistogramAll(from, to, bucket)
+		if buckets == nil {
+			buckets = found
+			return err
+		}
+		for i := range found {
+			if i < len(buckets) {
+				buckets[i].Created += found[i].Created
+				buckets[i].Updated += found[i].Updated
+				buckets[i].Deleted += found[i].Deleted
+			}
+		}
+		return err
+	})
+	return buckets, err
+}
+
+func (w *Workspace) DetectLanguages(notebookName string) (map[string]int, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.DetectLanguages(notebookName)
+}
+
+func (w *Workspace) RedetectLanguages(notebookName string) (int, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return 0, err
+	}
+	return db.RedetectLanguages(notebookName)
+}
+
+func (w *Workspace) CheckLinks(ctx context.Context, notebookName string, concurrency int) ([]LinkStatus, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.CheckLinks(ctx, notebookName, concurrency)
+}
+
+func (w *Workspace) ExportNote(notebookName string, noteId uint64, wr io.Writer, opts NoteExportOptions) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.ExportNote(notebookName, noteId, wr, opts)
+}
+
+func (w *Workspace) ImportNote(notebookName string, r io.Reader, opts ImportOptions) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.ImportNote(notebookName, r, opts)
+}
+
+func (w *Workspace) ExportNotebook(notebookName string, wr io.Writer, opts ...ListOption) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.ExportNotebook(notebookName, wr, opts...)
+}
+
+func (w *Workspace) ExportNotebookEncrypted(notebookName string, wr io.Writer, passphrase string, opts ...ListOption) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.ExportNotebookEncrypted(notebookName, wr, passphrase, opts...)
+}
+
+func (w *Workspace) ImportNotebook(notebookName string, r io.Reader, opts ImportOptions) (ImportReport, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return db.ImportNotebook(notebookName, r, opts)
+}
+
+func (w *Workspace) ImportENEX(notebookName string, r io.Reader, opts ENEXOptions) (ImportReport, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return db.ImportENEX(notebookName, r, opts)
+}
+
+func (w *Workspace) ImportKeepTakeout(notebookName, dir string, opts ImportOptions) (ImportReport, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return db.ImportKeepTakeout(notebookName, dir, opts)
+}
+
+func (w *Workspace) CaptureMessage(notebookName string, r io.Reader, opts CaptureOptions) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.CaptureMessage(notebookName, r, opts)
+}
+
+func (w *Workspace) ClipURL(ctx context.Context, notebookName string, rawURL string, client *http.Client) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.ClipURL(ctx, notebookName, rawURL, client)
+}
+
+/**
+ * Mirrors the notebooks of the home mount's file (see above and DB.MirrorToDir)
+ */
+func (w *Workspace) MirrorToDir(dir string, opts MirrorOptions) (MirrorReport, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return MirrorReport{}, err
+	}
+	return db.MirrorToDir(dir, opts)
+}
+
+/**
+ * Mirrors a directory into the home mount's file (see above and DB.MirrorFromDir)
+ */
+func (w *Workspace) MirrorFromDir(dir string, opts MirrorOptions) (MirrorReport, error) {
+	db, err := w.openHome(true)
+	if err != nil {
+		return MirrorReport{}, err
+	}
+	return db.MirrorFromDir(dir, opts)
+}
+
+/**
+ * Syncs the home mount's file from another DB file (see above and DB.SyncFrom)
+ */
+func (w *Workspace) SyncFrom(path string, opts SyncOptions) (SyncReport, error) {
+	db, err := w.openHome(true)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	return db.SyncFrom(path, opts)
+}
+
+func (w *Workspace) SyncStatus() ([]PeerState, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.SyncStatus()
+}
+
+/**
+ * Id of the device the home mount's file counts writes by ("" if it's unavailable)
+ */
+func (w *Workspace) DeviceID() string {
+	db, err := w.openHome(false)
+	if err != nil {
+		return ""
+	}
+	return db.DeviceID()
+}
+
+// filing-rule operations (kept by the home mount, see above)
+
+func (w *Workspace) AddFilingRule(rule FilingRule) error {
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.AddFilingRule(rule)
+}
+
+func (w *Workspace) ListFilingRules() ([]FilingRule, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListFilingRules()
+}
+
+func (w *Workspace) RemoveFilingRule(ruleId uint64) error {
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.RemoveFilingRule(ruleId)
+}
+
+/**
+ * Files notes of a notebook as per the filing rules of its file (see DB.ApplyFilingRules): only the home
+ * mount's file has rules added through the workspace
+ */
+func (w *Workspace) ApplyFilingRules(notebookName string, dryRun bool) (FilingReport, error) {
+	db, err := w.open(notebookName, !dryRun)
+	if err != nil {
+		return FilingReport{}, err
+	}
+	return db.ApplyFilingRules(notebookName, dryRun)
+}
+
+/**
+ * Files notes added to given notebook right away (see DB.SetAutoFiling); the notebook has to be the home
+ * mount's, whose filing rules apply
+ */
+func (w *Workspace) SetAutoFiling(notebookName string) error {
+	if notebookName != "" {
+		w.mu.RLock()
+		m, err := w.route(notebookName)
+		home := w.home()
+		w.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if m != home {
+			return fmt.Errorf("%w: auto-filing takes notebooks of the home mount ('%s'), not of '%s'",
+				ErrInvalidQuery, home.Name, m.Name)
+		}
+	}
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.SetAutoFiling(notebookName)
+}
+
+// tag-related operations
+
+func (w *Workspace) TagMatching(q *Query, addTags []string, removeTags []string, dryRun bool) (BulkTagReport, error) {
+	if q.err != nil {
+		return BulkTagReport{Notebook: q.notebookName, DryRun: dryRun}, q.err
+	}
+	db, err := w.open(q.notebookName, !dryRun)
+	if err != nil {
+		return BulkTagReport{Notebook: q.notebookName, DryRun: dryRun}, err
+	}
+	return db.TagMatching(q, addTags, removeTags, dryRun)
+}
+
+/**
+ * Notebooks steps of a batch plan read and write
+ */
+func batchNotebooks(plan BatchPlan) []string {
+	var notebookNames []string
+	for _, step := range plan.Steps {
+		notebookNames = append(notebookNames, step.Notebook)
+		if step.Target != "" {
+			notebookNames = append(notebookNames, step.Target)
+		}
+	}
+	return notebookNames
+}
+
+/**
+ * Previews a batch plan (see DB.PlanBatch); its notebooks have to be of the same file
+ */
+func (w *Workspace) PlanBatch(plan BatchPlan) (BatchPreview, error) {
+	db, err := w.openSame(false, batchNotebooks(plan)...)
+	if err != nil {
+		return BatchPreview{}, err
+	}
+	return db.PlanBatch(plan)
+}
+
+/**
+ * Runs a batch plan (see DB.ExecuteBatch); its notebooks have to be of the same file
+ */
+func (w *Workspace) ExecuteBatch(plan BatchPlan) (BatchResult, error) {
+	db, err := w.openSame(true, batchNotebooks(plan)...)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	return db.ExecuteBatch(plan)
+}
+
+// suggestion operations
+
+func (w *Workspace) SuggestTags(notebookName, prefix string, limit int) ([]string, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.SuggestTags(notebookName, prefix, limit)
+}
+
+func (w *Workspace) SuggestTitles(notebookName, prefix string, limit int) ([]string, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.SuggestTitles(notebookName, prefix, limit)
+}
+
+// notebook-snapshot operations
+
+func (w *Workspace) SnapshotNotebook(notebookName string, label string) (SnapshotID, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return 0, err
+	}
+	return db.SnapshotNotebook(notebookName, label)
+}
+
+func (w *Workspace) ListSnapshots(notebookName string) ([]NotebookSnapshot, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListSnapshots(notebookName)
+}
+
+func (w *Workspace) RestoreSnapshot(notebookName string, id SnapshotID, mode RestoreMode) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.RestoreSnapshot(notebookName, id, mode)
+}
+
+func (w *Workspace) DeleteSnapshot(notebookName string, id SnapshotID) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.DeleteSnapshot(notebookName, id)
+}
+
+// multi-notebook operations
+
+/**
+ * Runs fn over notebooks in a single transaction (see DB.MultiNotebookTx); the notebooks have to be of the
+ * same file
+ */
+func (w *Workspace) MultiNotebookTx(names []string, fn func(nbs map[string]*NotebookTx) error, opts ...NotebookTxOption) error {
+	db, err := w.openSame(true, names...)
+	if err != nil {
+		return err
+	}
+	return db.MultiNotebookTx(names, fn, opts...)
+}
+
+/**
+ * Takes the notebooks of the home mount's file out (see above and DB.Takeout)
+ */
+func (w *Workspace) Takeout(dir string, opts TakeoutOptions) (TakeoutReport, error) {
+	return w.TakeoutContext(context.Background(), dir, opts)
+}
+
+func (w *Workspace) TakeoutContext(ctx context.Context, dir string, opts TakeoutOptions) (TakeoutReport, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return TakeoutReport{}, err
+	}
+	return db.TakeoutContext(ctx, dir, opts)
+}
+
+/**
+ * Restores a takeout into the home mount's file (see above and DB.RestoreTakeout)
+ */
+func (w *Workspace) RestoreTakeout(dir string, opts ImportOptions) (RestoreReport, error) {
+	db, err := w.openHome(true)
+	if err != nil {
+		return RestoreReport{}, err
+	}
+	return db.RestoreTakeout(dir, opts)
+}
+
+// notebook-template operations (saved into every writable file, and read from the home mount's, see above)
+
+func (w *Workspace) SaveNotebookTemplate(name string, t NotebookTemplate) error {
+	return w.each(true, func(db *DB) error {
+		return db.SaveNotebookTemplate(name, t)
+	})
+}
+
+func (w *Workspace) GetNotebookTemplate(name string) (NotebookTemplate, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return NotebookTemplate{}, err
+	}
+	return db.GetNotebookTemplate(name)
+}
+
+func (w *Workspace) ListNotebookTemplates() ([]NotebookTemplate, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListNotebookTemplates()
+}
+
+/**
+ * Deletes a template from every writable file having it
+ * Fails with ErrTemplateNotFound if none has it
+ */
+func (w *Workspace) DeleteNotebookTemplate(name string) error {
+	deleted := false
+	err := w.each(true, func(db *DB) error {
+		err := db.DeleteNotebookTemplate(name)
+		if errors.Is(err, ErrTemplateNotFound) {
+			return nil
+		}
+		deleted = deleted || err == nil
+		return err
+	})
+	if err == nil && !deleted {
+		return fmt.Errorf("%w: '%s'", ErrTemplateNotFound, name)
+	}
+	return err
+}
+
+func (w *Workspace) CreateNotebookFromTemplate(notebookName, templateName string, vars map[string]string) ([]Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return nil, err
+	}
+	return db.CreateNotebookFromTemplate(notebookName, templateName, vars)
+}
+
+func (w *Workspace) ExportNotebookTemplate(name string, wr io.Writer) error {
+	db, err := w.openHome(false)
+	if err != nil {
+		return err
+	}
+	return db.ExportNotebookTemplate(name, wr)
+}
+
+/**
+ * Imports a template into every writable file (see DB.ImportNotebookTemplate)
+ */
+func (w *Workspace) ImportNotebookTemplate(r io.Reader, name string) (NotebookTemplate, error) {
+	encoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return NotebookTemplate{}, err
+	}
+	var imported NotebookTemplate
+	err = w.each(true, func(db *DB) error {
+		var err error
+		imported, err = db.ImportNotebookTemplate(bytes.NewReader(encoded), name)
+		return err
+	})
+	return imported, err
+}
+
+// content-class operations
+
+func (w *Workspace) GetNoteStats(notebookName string, noteId uint64) (NoteStats, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return NoteStats{}, err
+	}
+	return db.GetNoteStats(notebookName, noteId)
+}
+
+func (w *Workspace) FindBinaryNotes(notebookName string) ([]NoteStats, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.FindBinaryNotes(notebookName)
+}
+
+// fingerprint operations
+
+func (w *Workspace) FindByFingerprint(notebookName string, fingerprint string) (NoteRef, bool, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return NoteRef{}, false, err
+	}
+	return db.FindByFingerprint(notebookName, fingerprint)
+}
+
+func (w *Workspace) BackfillFingerprints() (int, error) {
+	backfilled := 0
+	err := w.each(true, func(db *DB) error {
+		n, err := db.BackfillFingerprints()
+		backfilled += n
+		return err
+	})
+	return backfilled, err
+}
+
+// favorite operations (every file keeps favorites of its notes)
+
+func (w *Workspace) AddFavorite(ref NoteRef) error {
+	db, err := w.open(ref.Notebook, true)
+	if err != nil {
+		return err
+	}
+	return db.AddFavorite(ref)
+}
+
+func (w *Workspace) RemoveFavorite(ref NoteRef) error {
+	db, err := w.open(ref.Notebook, true)
+	if err != nil {
+		return err
+	}
+	return db.RemoveFavorite(ref)
+}
+
+/**
+ * Moves a favorite before another one of the same file, or to the end of its file's favorites (see DB.MoveFavorite)
+ */
+func (w *Workspace) MoveFavorite(ref NoteRef, before NoteRef) error {
+	notebookNames := []string{ref.Notebook}
+	if before != (NoteRef{}) {
+		notebookNames = append(notebookNames, before.Notebook)
+	}
+	db, err := w.openSame(true, notebookNames...)
+	if err != nil {
+		return err
+	}
+	return db.MoveFavorite(ref, before)
+}
+
+/**
+ * Retrieves favorites of all files, those of every file in their order (files in order of mount names)
+ */
+func (w *Workspace) ListFavorites() ([]SearchResult, error) {
+	var favorites []SearchResult
+	err := w.each(false, func(db *DB) error {
+		found, err := db.ListFavorites()
+		favorites = append(favorites, found...)
+		return err
+	})
+	return favorites, err
+}
+
+func (w *Workspace) PruneFavorites() ([]NoteRef, error) {
+	var pruned []NoteRef
+	err := w.each(true, func(db *DB) error {
+		refs, err := db.PruneFavorites()
+		pruned = append(pruned, refs...)
+		return err
+	})
+	return pruned, err
+}
+
+// relation operations (between notes of the same file)
+
+func (w *Workspace) AddRelation(from NoteRef, to NoteRef, kind string) error {
+	db, err := w.openSame(true, from.Notebook, to.Notebook)
+	if err != nil {
+		return err
+	}
+	return db.AddRelation(from, to, kind)
+}
+
+func (w *Workspace) RemoveRelation(from NoteRef, to NoteRef, kind string) error {
+	db, err := w.openSame(true, from.Notebook, to.Notebook)
+	if err != nil {
+		return err
+	}
+	return db.RemoveRelation(from, to, kind)
+}
+
+func (w *Workspace) GetRelations(ref NoteRef, kind string, direction Direction) ([]Relation, error) {
+	db, err := w.open(ref.Notebook, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetRelations(ref, kind, direction)
+}
+
+// rollup operations
+
+/**
+ * Generates a daily rollup (see DB.GenerateDailyRollup); sources have to be of the file of the target notebook,
+ * and no sources stands for all notebooks of that file
+ */
+func (w *Workspace) GenerateDailyRollup(targetNotebook string, day time.Time, sources []string) (Note, error) {
+	db, err := w.openSame(true, append([]string{targetNotebook}, sources...)...)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.GenerateDailyRollup(targetNotebook, day, sources)
+}
+
+func (w *Workspace) GetDailyRollup(targetNotebook string, day time.Time) (DailyRollup, error) {
+	db, err := w.open(targetNotebook, false)
+	if err != nil {
+		return DailyRollup{}, err
+	}
+	return db.GetDailyRollup(targetNotebook, day)
+}
+
+// short-id operations
+
+/**
+ * Resolves a short id in every available file (see DB.ResolveShortID)
+ * Fails with ErrAmbiguousAbbreviation if it points to notebooks of several files, and as the first file did if
+ * it points to none
+ */
+func (w *Workspace) ResolveShortID(shortID string) (NoteRef, error) {
+	var resolved []NoteRef
+	var first error
+	dbs, _ := w.available()
+	for _, mounted := range dbs {
+		ref, err := mounted.db.ResolveShortID(shortID)
+		switch {
+		case err == nil:
+			resolved = append(resolved, ref)
+		case first == nil:
+			first = err
+		}
+	}
+	switch len(resolved) {
+	case 0:
+		if first == nil {
+			first = fmt.Errorf("%w: '%s' (no file is available)", ErrUnknownAbbreviation, shortID)
+		}
+		return NoteRef{}, first
+	case 1:
+		return resolved[0], nil
+	}
+	var notebooks []string
+	for _, ref := range resolved {
+		notebooks = append(notebooks, "'"+ref.Notebook+"'")
+	}
+	return NoteRef{}, fmt.Errorf("%w: '%s' could be %s, of different database files",
+		ErrAmbiguousAbbreviation, shortID, strings.Join(notebooks, " or "))
+}
+
+func (w *Workspace) NoteShortID(ref NoteRef) (string, error) {
+	db, err := w.open(ref.Notebook, false)
+	if err != nil {
+		return "", err
+	}
+	return db.NoteShortID(ref)
+}
+
+// attachment-related operations
+
+func (w *Workspace) AddAttachment(notebookName string, noteId uint64, name string, r io.Reader) (Attachment, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return db.AddAttachment(notebookName, noteId, name, r)
+}
+
+func (w *Workspace) ListAttachments(notebookName string, noteId uint64) ([]Attachment, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListAttachments(notebookName, noteId)
+}
+
+func (w *Workspace) GetAttachment(notebookName string, noteId uint64, name string) (io.ReadSeekCloser, Attachment, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, Attachment{}, err
+	}
+	return db.GetAttachment(notebookName, noteId, name)
+}
+
+func (w *Workspace) DeleteAttachment(notebookName string, noteId uint64, name string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.DeleteAttachment(notebookName, noteId, name)
+}
+
+// expiry-related operations
+
+func (w *Workspace) SetExpiry(notebookName string, noteId uint64, expiresAt *time.Time) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.SetExpiry(notebookName, noteId, expiresAt)
+}
+
+func (w *Workspace) PurgeExpired() (int, error) {
+	purged := 0
+	err := w.each(true, func(db *DB) error {
+		n, err := db.PurgeExpired()
+		purged += n
+		return err
+	})
+	return purged, err
+}
+
+// retention-related operations (the policy goes to every writable file, and is read from the home mount's)
+
+func (w *Workspace) SetRetentionPolicy(policy RetentionPolicy) error {
+	return w.each(true, func(db *DB) error {
+		return db.SetRetentionPolicy(policy)
+	})
+}
+
+func (w *Workspace) GetRetentionPolicy() (RetentionPolicy, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	return db.GetRetentionPolicy()
+}
+
+func (w *Workspace) ApplyRetention() (RetentionReport, error) {
+	var report RetentionReport
+	start := time.Now()
+	err := w.each(true, func(db *DB) error {
+		fileReport, err := db.ApplyRetention()
+		report.Changelog += fileReport.Changelog
+		report.History += fileReport.History
+		report.AccessLog += fileReport.AccessLog
+		return err
+	})
+	report.Took = time.Since(start)
+	return report, err
+}
+
+// notebook policy-related operations
+
+func (w *Workspace) SetNotebookPolicy(notebookName string, policy NotebookPolicy) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.SetNotebookPolicy(notebookName, policy)
+}
+
+func (w *Workspace) SchedulePolicies(scheduled bool) error {
+	return w.each(true, func(db *DB) error {
+		return db.SchedulePolicies(scheduled)
+	})
+}
+
+func (w *Workspace) PoliciesScheduled() (bool, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return false, err
+	}
+	return db.PoliciesScheduled()
+}
+
+func (w *Workspace) ApplyPolicies(dryRun bool) (PolicyReport, error) {
+	report := PolicyReport{DryRun: dryRun}
+	start := time.Now()
+	err := w.each(!dryRun, func(db *DB) error {
+		fileReport, err := db.ApplyPolicies(dryRun)
+		report.Notebooks = append(report.Notebooks, fileReport.Notebooks...)
+		return err
+	})
+	report.Took = time.Since(start)
+	return report, err
+}
+
+// task-related operations
+
+func (w *Workspace) ToggleTask(notebookName string, noteId uint64, line int) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.ToggleTask(notebookName, noteId, line)
+}
+
+func (w *Workspace) ListOpenTasks(notebookName string) ([]TaskRef, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListOpenTasks(notebookName)
+}
+
+// history-related operations
+
+func (w *Workspace) GetNoteHistory(notebookName string, noteId uint64) ([]NoteRevision, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetNoteHistory(notebookName, noteId)
+}
+
+func (w *Workspace) RestoreRevision(notebookName string, noteId uint64, revision uint64) (Note, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return Note{}, err
+	}
+	return db.RestoreRevision(notebookName, noteId, revision)
+}
+
+func (w *Workspace) DiffRevisions(notebookName string, noteId uint64, revA, revB uint64) ([]DiffHunk, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.DiffRevisions(notebookName, noteId, revA, revB)
+}
+
+func (w *Workspace) DiffAgainstCurrent(notebookName string, noteId uint64, rev uint64) ([]DiffHunk, error) {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.DiffAgainstCurrent(notebookName, noteId, rev)
+}
+
+func (w *Workspace) SetHistorySnapshotInterval(interval int) error {
+	return w.each(true, func(db *DB) error {
+		return db.SetHistorySnapshotInterval(interval)
+	})
+}
+
+/**
+ * Interval of the home mount's file (DefaultHistorySnapshotInterval if it's unavailable)
+ */
+func (w *Workspace) GetHistorySnapshotInterval() int {
+	db, err := w.openHome(false)
+	if err != nil {
+		return DefaultHistorySnapshotInterval
+	}
+	return db.GetHistorySnapshotInterval()
+}
+
+// undo operations (of the home mount's file, see above)
+
+func (w *Workspace) LastOperations(n int) ([]UndoEntry, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.LastOperations(n)
+}
+
+func (w *Workspace) Undo(opId uint64) error {
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.Undo(opId)
+}
+
+// db-settings operations (going to every writable file, see above)
+
+func (w *Workspace) SetCaseInsensitiveNotebooks(enabled bool) ([]NotebookNameCollision, error) {
+	var collisions []NotebookNameCollision
+	err := w.each(true, func(db *DB) error {
+		found, err := db.SetCaseInsensitiveNotebooks(enabled)
+		collisions = append(collisions, found...)
+		return err
+	})
+	return collisions, err
+}
+
+func (w *Workspace) SetContentNormalization(opts NormalizeOptions) error {
+	return w.each(true, func(db *DB) error {
+		return db.SetContentNormalization(opts)
+	})
+}
+
+func (w *Workspace) NormalizeExisting(notebookName string) (int, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return 0, err
+	}
+	return db.NormalizeExisting(notebookName)
+}
+
+func (w *Workspace) SetTitleInference(opts TitleInference) error {
+	return w.each(true, func(db *DB) error {
+		return db.SetTitleInference(opts)
+	})
+}
+
+func (w *Workspace) InferMissingTitles(notebookName string) (int, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return 0, err
+	}
+	return db.InferMissingTitles(notebookName)
+}
+
+/**
+ * Turns the outbox of the home mount's file on or off (see above and DB.EnableOutbox)
+ */
+func (w *Workspace) EnableOutbox(enabled bool) error {
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.EnableOutbox(enabled)
+}
+
+/**
+ * Encryption mode of the home mount's file (the zero mode if it's unavailable)
+ */
+func (w *Workspace) GetEncryptionMode() EncryptionMode {
+	db, err := w.openHome(false)
+	if err != nil {
+		var mode EncryptionMode
+		return mode
+	}
+	return db.GetEncryptionMode()
+}
+
+func (w *Workspace) MigrateEncryption(mode EncryptionMode) (int, error) {
+	migrated := 0
+	err := w.each(true, func(db *DB) error {
+		n, err := db.MigrateEncryption(mode)
+		migrated += n
+		return err
+	})
+	return migrated, err
+}
+
+// notebook-encryption operations
+
+func (w *Workspace) EncryptNotebook(notebookName string, passphrase string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.EncryptNotebook(notebookName, passphrase)
+}
+
+func (w *Workspace) UnlockNotebook(notebookName string, passphrase string) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.UnlockNotebook(notebookName, passphrase)
+}
+
+func (w *Workspace) LockNotebook(notebookName string) error {
+	db, err := w.open(notebookName, false)
+	if err != nil {
+		return err
+	}
+	return db.LockNotebook(notebookName)
+}
+
+func (w *Workspace) ChangeNotebookPassphrase(notebookName string, oldPassphrase string, newPassphrase string) error {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return err
+	}
+	return db.ChangeNotebookPassphrase(notebookName, oldPassphrase, newPassphrase)
+}
+
+// outbox operations (of the home mount's file, see above)
+
+func (w *Workspace) OutboxDepth() (int, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return 0, err
+	}
+	return db.OutboxDepth()
+}
+
+func (w *Workspace) ProcessOutbox(handler func(ChangeEvent) error, batch int) (int, error) {
+	db, err := w.openHome(true)
+	if err != nil {
+		return 0, err
+	}
+	return db.ProcessOutbox(handler, batch)
+}
+
+func (w *Workspace) ChangesSince(seq uint64) ([]ChangeEvent, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ChangesSince(seq)
+}
+
+// API-token operations (of the home mount's file, see above)
+
+func (w *Workspace) CreateAPIToken(name string, scopes []Scope) (string, error) {
+	db, err := w.openHome(true)
+	if err != nil {
+		return "", err
+	}
+	return db.CreateAPIToken(name, scopes)
+}
+
+func (w *Workspace) RevokeAPIToken(name string) error {
+	db, err := w.openHome(true)
+	if err != nil {
+		return err
+	}
+	return db.RevokeAPIToken(name)
+}
+
+func (w *Workspace) ListAPITokens() ([]APIToken, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return nil, err
+	}
+	return db.ListAPITokens()
+}
+
+func (w *Workspace) AuthenticateAPIToken(token string) (APIToken, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return APIToken{}, err
+	}
+	return db.AuthenticateAPIToken(token)
+}
+
+// note-share operations (every file keeps shares of its notes)
+
+func (w *Workspace) CreateNoteShareLink(notebookName string, noteId uint64, expiry time.Duration, maxViews int) (string, error) {
+	db, err := w.open(notebookName, true)
+	if err != nil {
+		return "", err
+	}
+	return db.CreateNoteShareLink(notebookName, noteId, expiry, maxViews)
+}
+
+/**
+ * Retrieves shares of all files (files in order of mount names); ids are those of their file, and so may repeat
+ */
+func (w *Workspace) ListNoteShares() ([]NoteShare, error) {
+	var shares []NoteShare
+	err := w.each(false, func(db *DB) error {
+		found, err := db.ListNoteShares()
+		shares = append(shares, found...)
+		return err
+	})
+	return shares, err
+}
+
+/**
+ * Revokes a share of whichever writable file has a share by given id
+ * Fails with ErrNoteShareNotFound if none has it, and with ErrInvalidQuery if several do
+ */
+func (w *Workspace) RevokeNoteShare(shareId uint64) error {
+	var holders []*DB
+	dbs, _ := w.available()
+	for _, mounted := range dbs {
+		if mounted.readOnly {
+			continue
+		}
+		shares, err := mounted.db.ListNoteShares()
+		if err != nil {
+			return err
+		}
+		for _, share := range shares {
+			if share.Id == shareId {
+				holders = append(holders, mounted.db)
+			}
+		}
+	}
+	switch len(holders) {
+	case 0:
+		return fmt.Errorf("%w: %d", ErrNoteShareNotFound, shareId)
+	case 1:
+		return holders[0].RevokeNoteShare(shareId)
+	}
+	return fmt.Errorf("%w: %d database files have a share %d", ErrInvalidQuery, len(holders), shareId)
+}
+
+/**
+ * Retrieves the note shared by a share token, in whichever writable file issued it (see DB.ViewSharedNote)
+ */
+func (w *Workspace) ViewSharedNote(token string) (Note, error) {
+	var note Note
+	err := w.find(true, ErrNoteShareNotFound, func(db *DB) error {
+		var err error
+		note, err = db.ViewSharedNote(token)
+		return err
+	})
+	return note, err
+}
+
+// db-backup operation
+
+/**
+ * Prints all data of all available files, in order of mount names
+ */
+func (w *Workspace) Dump() {
+	w.each(false, func(db *DB) error {
+		db.Dump()
+		return nil
+	})
+}
+
+// slow-op operations
+
+/**
+ * Slow operations of all files, newest first (see DB.SlowOps)
+ */
+func (w *Workspace) SlowOps(limit int) []SlowOp {
+	var ops []SlowOp
+	w.each(false, func(db *DB) error {
+		ops = append(ops, db.SlowOps(limit)...)
+		return nil
+	})
+	return newestSlowOps(ops, limit)
+}
+
+func (w *Workspace) PersistedSlowOps(limit int) ([]SlowOp, error) {
+	var ops []SlowOp
+	err := w.each(false, func(db *DB) error {
+		found, err := db.PersistedSlowOps(limit)
+		ops = append(ops, found...)
+		return err
+	})
+	return newestSlowOps(ops, limit), err
+}
+
+func newestSlowOps(ops []SlowOp, limit int) []SlowOp {
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].At.After(ops[j].At) })
+	if limit > 0 && len(ops) > limit {
+		ops = ops[:limit]
+	}
+	return ops
+}
+
+/**
+ * Notebooks of all files by number of operations (see DB.TopNotebooksByOps)
+ */
+func (w *Workspace) TopNotebooksByOps(window time.Duration) ([]NotebookOpCount, error) {
+	var counts []NotebookOpCount
+	err := w.each(false, func(db *DB) error {
+		found, err := db.TopNotebooksByOps(window)
+		counts = append(counts, found...)
+		return err
+	})
+	sort.SliceStable(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Ops != b.Ops {
+			return a.Ops > b.Ops
+		}
+		if a.Notes != b.Notes {
+			return a.Notes > b.Notes
+		}
+		return a.Notebook < b.Notebook
+	})
+	return counts, err
+}
+
+// index-maintenance operation
+
+/**
+ * What the index maintainer of the home mount's file did (nothing if it's unavailable)
+ */
+func (w *Workspace) MaintenanceStats() MaintenanceStats {
+	db, err := w.openHome(false)
+	if err != nil {
+		return MaintenanceStats{}
+	}
+	return db.MaintenanceStats()
+}
+
+// recurring-note operations (every rule is kept by the file of its notebook)
+
+func (w *Workspace) AddRecurringNote(rule RecurringRule) error {
+	db, err := w.open(rule.Notebook, true)
+	if err != nil {
+		return err
+	}
+	return db.AddRecurringNote(rule)
+}
+
+/**
+ * Retrieves recurring rules of all files, in order of names
+ */
+func (w *Workspace) ListRecurringNotes() ([]RecurringRule, error) {
+	var rules []RecurringRule
+	err := w.each(false, func(db *DB) error {
+		found, err := db.ListRecurringNotes()
+		rules = append(rules, found...)
+		return err
+	})
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules, err
+}
+
+/**
+ * Deletes a recurring rule from every writable file having one by given name
+ * Fails with ErrRecurringRuleNotFound if none has it
+ */
+func (w *Workspace) DeleteRecurringNote(name string) error {
+	deleted := false
+	err := w.each(true, func(db *DB) error {
+		err := db.DeleteRecurringNote(name)
+		if errors.Is(err, ErrRecurringRuleNotFound) {
+			return nil
+		}
+		deleted = deleted || err == nil
+		return err
+	})
+	if err == nil && !deleted {
+		return fmt.Errorf("%w: '%s'", ErrRecurringRuleNotFound, name)
+	}
+	return err
+}
+
+/**
+ * Fires recurring rules of every writable file (see DB.RunRecurring)
+ */
+func (w *Workspace) RunRecurring(now time.Time) (RecurringReport, error) {
+	var report RecurringReport
+	err := w.each(true, func(db *DB) error {
+		fileReport, err := db.RunRecurring(now)
+		report.Fired = append(report.Fired, fileReport.Fired...)
+		report.Skipped += fileReport.Skipped
+		report.Duplicates += fileReport.Duplicates
+		report.Failed = append(report.Failed, fileReport.Failed...)
+		return err
+	})
+	return report, err
+}
+
+func (w *Workspace) ScheduleRecurring(scheduled bool) error {
+	return w.each(true, func(db *DB) error {
+		return db.ScheduleRecurring(scheduled)
+	})
+}
+
+func (w *Workspace) RecurringScheduled() (bool, error) {
+	db, err := w.openHome(false)
+	if err != nil {
+		return false, err
+	}
+	return db.RecurringScheduled()
+}
+
+// capability operation
+
+/**
+ * Features the home mount's file has initialized (none if it's unavailable)
+ */
+func (w *Workspace) Capabilities() Capabilities {
+	db, err := w.openHome(false)
+	if err != nil {
+		return Capabilities{}
+	}
+	return db.Capabilities()
+}
+
+// db-integrity operations
+
+func (w *Workspace) CheckIntegrity() ([]IntegrityProblem, error) {
+	var problems []IntegrityProblem
+	err := w.each(false, func(db *DB) error {
+		found, err := db.CheckIntegrity()
+		problems = append(problems, found...)
+		return err
+	})
+	return problems, err
+}
+
+func (w *Workspace) Repair() ([]IntegrityProblem, error) {
+	var problems []IntegrityProblem
+	err := w.each(true, func(db *DB) error {
+		found, err := db.Repair()
+		problems = append(problems, found...)
+		return err
+	})
+	return problems, err
+}
+
+func (w *Workspace) QuarantineCorrupt() ([]CorruptRecord, error) {
+	var records []CorruptRecord
+	err := w.each(true, func(db *DB) error {
+		found, err := db.QuarantineCorrupt()
+		records = append(records, found...)
+		return err
+	})
+	return records, err
+}
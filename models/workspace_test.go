@@ -0,0 +1,388 @@
+package models_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Workspace of a personal file (holding notebooks of any other name), a work file ('work/') and an archive
+ * file within it ('work/archive/'), in a directory removed after the test
+ */
+func openTestWorkspace(t *testing.T, extra ...models.WorkspaceMount) (*models.Workspace, string) {
+	t.Helper()
+	dir := t.TempDir()
+	mounts := append([]models.WorkspaceMount{
+		{Name: "personal", Path: filepath.Join(dir, "personal.db")},
+		{Name: "work", Path: filepath.Join(dir, "work.db"), Prefix: "work/"},
+		{Name: "archive", Path: filepath.Join(dir, "archive.db"), Prefix: "work/archive/"},
+	}, extra...)
+	w, err := models.OpenWorkspace(mounts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, dir
+}
+
+/**
+ * Names of the notebooks a DB file holds, read once the workspace is closed
+ */
+func notebooksOfFile(t *testing.T, path string) string {
+	t.Helper()
+	db, err := models.OpenReadOnlyDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	names, err := db.GetAllNotebookNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestWorkspaceRouting(t *testing.T) {
+	w, dir := openTestWorkspace(t)
+	if err := w.Route("journal", "work"); err != nil {
+		t.Fatal(err)
+	}
+	for notebook, mount := range map[string]string{
+		"home": "personal", "work/projects": "work", "work/archive/2023": "archive", "workshop": "personal", "journal": "work",
+	} {
+		if got, err := w.MountOf(notebook); err != nil || got != mount {
+			t.Errorf("notebook '%s' routed to mount '%s' (%v), want '%s'", notebook, got, err, mount)
+		}
+		if err := w.AddNotes(notebook, "a note of "+notebook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Route("journal", "nowhere"); !errors.Is(err, models.ErrInvalidQuery) {
+		t.Errorf("routing to a missing mount: %v, want ErrInvalidQuery", err)
+	}
+
+	// calls about a note go to its file
+	note, err := w.GetNote("work/projects", 1)
+	if err != nil || note.Content != "a note of work/projects" {
+		t.Errorf("note of 'work/projects' read as %q (%v)", note.Content, err)
+	}
+	if _, err := w.UpdateNote("work/projects", 1, "updated"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := w.SearchNotes("work/projects", "updated")
+	if err != nil || len(results) != 1 {
+		t.Errorf("search of 'work/projects' found %d notes (%v), want 1", len(results), err)
+	}
+
+	// notes kept apart, notebooks under their full names
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		"personal.db": "home,workshop", "work.db": "journal,work/projects", "archive.db": "work/archive/2023",
+	} {
+		if got := notebooksOfFile(t, filepath.Join(dir, file)); got != want {
+			t.Errorf("%s holds notebooks %s, want %s", file, got, want)
+		}
+	}
+	if _, err := w.GetNote("home", 1); !errors.Is(err, models.ErrDatabaseUnavailable) {
+		t.Errorf("read of a closed workspace: %v, want ErrDatabaseUnavailable", err)
+	}
+
+	// without a mount lacking prefix, other notebooks belong nowhere
+	prefixed, err := models.OpenWorkspace(models.WorkspaceMount{Name: "work", Path: filepath.Join(dir, "work.db"), Prefix: "work/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prefixed.Close()
+	if err := prefixed.AddNotes("home", "lost"); !errors.Is(err, models.ErrNoDatabase) {
+		t.Errorf("note added to a notebook no mount holds: %v, want ErrNoDatabase", err)
+	}
+
+	for _, mounts := range [][]models.WorkspaceMount{
+		{{Name: "a", Path: "a.db"}, {Name: "a", Path: "b.db", Prefix: "b/"}},
+		{{Name: "a", Path: "a.db"}, {Name: "b", Path: "b.db"}},
+		{{Path: "a.db"}},
+	} {
+		if _, err := models.OpenWorkspace(mounts...); !errors.Is(err, models.ErrInvalidQuery) {
+			t.Errorf("workspace of mounts %+v: %v, want ErrInvalidQuery", mounts, err)
+		}
+	}
+}
+
+func TestWorkspaceMergedListings(t *testing.T) {
+	w, _ := openTestWorkspace(t)
+	for notebook, contents := range map[string][]string{
+		"home":              {"groceries: milk", "call the plumber"},
+		"work/projects":     {"plan the milk run", "quarterly review"},
+		"work/archive/2023": {"old milk invoices"},
+	} {
+		if err := w.AddNotes(notebook, contents...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.ArchiveNotebook("work/archive/2023"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := w.GetAllNotebookNames(models.WithArchivedNotebooks())
+	if err != nil || strings.Join(names, ",") != "home,work/archive/2023,work/projects" {
+		t.Errorf("notebooks %v (%v)", names, err)
+	}
+	if names, _ := w.GetAllNotebookNames(); strings.Join(names, ",") != "home,work/projects" {
+		t.Errorf("active notebooks %v", names)
+	}
+	stats, err := w.GetDBStats()
+	if err != nil || stats != (models.DBStats{ActiveNotebooks: 2, ArchivedNotebooks: 1, Notes: 5}) {
+		t.Errorf("stats %+v (%v)", stats, err)
+	}
+
+	results, err := w.SearchAllNotebooks("milk", models.IncludeArchivedNotebooks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, result := range results {
+		found = append(found, result.Ref.Notebook)
+	}
+	if len(found) != 3 || !strings.Contains(strings.Join(found, ","), "work/archive/2023") {
+		t.Errorf("search of all notebooks found notes of %v, want one of each notebook", found)
+	}
+
+	// favorites of every file, each file's in order (files in order of mount names)
+	for _, ref := range []models.NoteRef{{Notebook: "home", Id: 2}, {Notebook: "work/projects", Id: 1}, {Notebook: "home", Id: 1}} {
+		if err := w.AddFavorite(ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	favorites, err := w.ListFavorites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []string
+	for _, favorite := range favorites {
+		refs = append(refs, favorite.Ref.String())
+	}
+	if strings.Join(refs, ",") != "home/2,home/1,work/projects/1" {
+		t.Errorf("favorites %v", refs)
+	}
+
+	// settings go to every file
+	if err := w.SetHistorySnapshotInterval(7); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.GetHistorySnapshotInterval(); got != 7 {
+		t.Errorf("history snapshot interval %d, want 7", got)
+	}
+
+	// calls spanning notebooks of several files are refused
+	err = w.AddRelation(models.NoteRef{Notebook: "home", Id: 1}, models.NoteRef{Notebook: "work/projects", Id: 1}, "references")
+	if !errors.Is(err, models.ErrInvalidQuery) {
+		t.Errorf("relation across files: %v, want ErrInvalidQuery", err)
+	}
+	if err := w.AddRelation(models.NoteRef{Notebook: "home", Id: 1}, models.NoteRef{Notebook: "home", Id: 2}, "references"); err != nil {
+		t.Errorf("relation within a file: %v", err)
+	}
+}
+
+func TestWorkspaceUnavailableFile(t *testing.T) {
+	dir := t.TempDir()
+	// (a path under a regular file can't be created)
+	blocker := filepath.Join(dir, "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := openTestWorkspace(t, models.WorkspaceMount{Name: "broken", Path: filepath.Join(blocker, "broken.db"), Prefix: "broken/"})
+	if err := w.AddNotes("home", "still usable"); err != nil {
+		t.Fatal(err)
+	}
+
+	var broken models.WorkspaceMountStatus
+	for _, mount := range w.Mounts() {
+		if mount.Name == "broken" {
+			broken = mount
+		}
+	}
+	if broken.Available || broken.Err == nil {
+		t.Errorf("mount of a file that can't be opened %+v", broken)
+	}
+	if err := w.AddNotes("broken/notes", "lost"); !errors.Is(err, models.ErrDatabaseUnavailable) {
+		t.Errorf("write routed to the unavailable file: %v, want ErrDatabaseUnavailable", err)
+	}
+	if _, _, err := w.Query("broken/notes").Execute(); !errors.Is(err, models.ErrDatabaseUnavailable) {
+		t.Errorf("query routed to the unavailable file: %v, want ErrDatabaseUnavailable", err)
+	}
+
+	// listings across files return what the others have, saying what's left out
+	names, err := w.GetAllNotebookNames()
+	var unavailable *models.WorkspaceUnavailableError
+	if !errors.As(err, &unavailable) || strings.Join(unavailable.Mounts, ",") != "broken" {
+		t.Errorf("listing with a file unavailable: %v, want a *WorkspaceUnavailableError naming 'broken'", err)
+	}
+	if strings.Join(names, ",") != "home" {
+		t.Errorf("notebooks of the available files %v", names)
+	}
+	if stats, err := w.GetDBStats(); !errors.Is(err, models.ErrDatabaseUnavailable) || stats.Notes != 1 {
+		t.Errorf("stats with a file unavailable %+v (%v)", stats, err)
+	}
+}
+
+/**
+ * Logger keeping messages
+ */
+type loggedMessages []string
+
+func (m *loggedMessages) Printf(format string, v ...interface{}) {
+	*m = append(*m, fmt.Sprintf(format, v...))
+}
+
+func TestWorkspaceReadOnlyMount(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shared.db")
+	missing := filepath.Join(dir, "missing.db")
+	db, err := models.GetOrCreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddNotes("shared/handbook", "read me"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	w, err := models.OpenWorkspace(
+		models.WorkspaceMount{Name: "personal", Path: filepath.Join(dir, "personal.db")},
+		models.WorkspaceMount{Name: "shared", Path: path, Prefix: "shared/", ReadOnly: true},
+		models.WorkspaceMount{Name: "missing", Path: missing, Prefix: "missing/", ReadOnly: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// a read-only file isn't created, and can be read by others meanwhile
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("read-only mount created its file (%v)", err)
+	}
+	if _, err := w.ListNotes("missing/notes"); !errors.Is(err, models.ErrDatabaseUnavailable) {
+		t.Errorf("read of a missing read-only file: %v, want ErrDatabaseUnavailable", err)
+	}
+	reader, err := models.OpenReadOnlyDB(path)
+	if err != nil {
+		t.Fatalf("file of a read-only mount can't be read by others: %v", err)
+	}
+	// reading notes of a read-only DB leaves nothing to write on close (like access times)
+	var logged loggedMessages
+	reader.SetLogger(&logged)
+	if note, err := reader.GetNote("shared/handbook", 1); err != nil || note.Content != "read me" {
+		t.Errorf("note read by others as %q (%v)", note.Content, err)
+	}
+	if err := reader.Close(); err != nil || len(logged) != 0 {
+		t.Errorf("closing a read-only DB: %v, logging %q", err, logged)
+	}
+
+	note, err := w.GetNote("shared/handbook", 1)
+	if err != nil || note.Content != "read me" {
+		t.Errorf("note of the read-only file read as %q (%v)", note.Content, err)
+	}
+	if err := w.AddNotes("shared/handbook", "no"); !errors.Is(err, models.ErrDatabaseReadOnly) {
+		t.Errorf("write to the read-only file: %v, want ErrDatabaseReadOnly", err)
+	}
+	if _, err := w.MoveNote("shared/handbook", 1, "notes"); !errors.Is(err, models.ErrDatabaseReadOnly) {
+		t.Errorf("move out of the read-only file: %v, want ErrDatabaseReadOnly", err)
+	}
+	// writes to every file leave it alone
+	if err := w.SetHistorySnapshotInterval(5); err != nil && !errors.Is(err, models.ErrDatabaseUnavailable) {
+		t.Errorf("setting of all files: %v", err)
+	}
+
+	if err := w.SetReadOnly("shared", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddNotes("shared/handbook", "now writable"); err != nil {
+		t.Errorf("write after the mount was made writable: %v", err)
+	}
+}
+
+func TestWorkspaceMoveNote(t *testing.T) {
+	w, _ := openTestWorkspace(t)
+	if err := w.AddNotes("work/projects", "first", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFavorite(models.NoteRef{Notebook: "work/projects", Id: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddRelation(models.NoteRef{Notebook: "work/projects", Id: 2}, models.NoteRef{Notebook: "work/projects", Id: 1}, "references"); err != nil {
+		t.Fatal(err)
+	}
+
+	// within a file, in one transaction
+	report, err := w.MoveNoteWithReport("work/projects", 1, "work/done")
+	if err != nil || !report.Atomic || len(report.Warnings) != 0 {
+		t.Errorf("move within a file %+v (%v)", report, err)
+	}
+
+	// between files, copied then deleted, with warnings
+	report, err = w.MoveNoteWithReport("work/projects", 2, "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Atomic || len(report.Warnings) != 3 || !strings.Contains(report.Warnings[0], "not atomic") {
+		t.Errorf("move between files %+v", report)
+	}
+	moved, err := w.GetNote("home", report.To.Id)
+	if err != nil || moved.Content != "second" {
+		t.Errorf("moved note read as %q (%v)", moved.Content, err)
+	}
+	if exists, _ := w.NoteExists("work/projects", 2); exists {
+		t.Error("note moved between files left in its notebook")
+	}
+	favorites, err := w.ListFavorites()
+	if err != nil || len(favorites) != 1 || favorites[0].Ref != report.To {
+		t.Errorf("favorites after the move %+v (%v)", favorites, err)
+	}
+}
+
+func TestWorkspaceCallsRacingClose(t *testing.T) {
+	w, _ := openTestWorkspace(t)
+	for _, notebook := range []string{"home", "work/projects", "work/archive/2023"} {
+		if err := w.AddNotes(notebook, "note"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_, err := w.GetAllNotebookNames()
+				if err == nil {
+					_, err = w.SearchAllNotebooks("note")
+				}
+				if err != nil && !errors.Is(err, models.ErrClosed) && !errors.Is(err, models.ErrDatabaseUnavailable) {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("call racing Close: %v", err)
+	}
+	if err := w.SetReadOnly("work", true); !errors.Is(err, models.ErrClosed) {
+		t.Errorf("mount reopened after Close: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/**
+ * Write transactions can be given a time budget (see SetWriteTimeout), so that an operation
+ * running away with bolt's write lock doesn't starve every other writer of the process
+ *  - the budget is checked at checkpoints, between notes of the operation: when it's exceeded,
+ *    the operation fails with a *WriteTimeoutError and its transaction is rolled back
+ *  - a single note is written whole, however large: putting its record, chunks (see chunks.go)
+ *    and index entries can't be interrupted, so an operation can overrun its budget by as long
+ *    as writing one note takes
+ *  - the budget is per transaction: BulkLoad and imports commit every batch in its own
+ *    transaction, so only the batch running over is rolled back (batches committed before stay)
+ * Checkpoints are in adding notes (AddNotes, BulkLoad, imports), deleting notes and NormalizeExisting
+ * A slow hook set with SetBeforeSave is the typical cause of such overruns: it runs for every note saved,
+ * holding the write lock
+ */
+
+/**
+ * Returned (wrapped in a *WriteTimeoutError) by write operations that exceeded the write timeout
+ */
+var ErrWriteTimeout = errors.New("write transaction exceeded its time budget")
+
+/**
+ * Details of a write operation aborted for exceeding the write timeout
+ *  - Completed of Total notes had been written when it was aborted (all of them rolled back)
+ */
+type WriteTimeoutError struct {
+	Budget    time.Duration
+	Elapsed   time.Duration
+	Completed int
+	Total     int
+}
+
+func (e *WriteTimeoutError) Error() string {
+	return fmt.Sprintf("%v: aborted after %v (budget %v) with %d of %d note(s) written, rolled back",
+		ErrWriteTimeout, e.Elapsed, e.Budget, e.Completed, e.Total)
+}
+
+func (e *WriteTimeoutError) Unwrap() error {
+	return ErrWriteTimeout
+}
+
+/**
+ * Sets the longest a write transaction may run before being aborted at its next checkpoint
+ * Non-positive timeout turns the guard off (the default)
+ */
+func (db *DB) SetWriteTimeout(timeout time.Duration) {
+	db.writeTimeout = timeout
+}
+
+/**
+ * Records the start of a write transaction for writeCheckpoint; the returned func clears it,
+ * and must be called before the transaction ends (like with 'defer db.timeWrite()()' right after opening it)
+ */
+func (db *DB) timeWrite() func() {
+	db.writeStarted = time.Now()
+	return func() {
+		db.writeStarted = time.Time{}
+	}
+}
+
+/**
+ * Checkpoint of a write operation, between its notes: fails with a *WriteTimeoutError if the
+ * running write transaction exceeded the write timeout
+ * Only to be called within write transactions timed by timeWrite (as those of Update are)
+ * param: int completed Notes written so far
+ * param: int total     Notes the operation writes
+ */
+func (db *DB) writeCheckpoint(completed int, total int) error {
+	if db.writeTimeout <= 0 || db.writeStarted.IsZero() {
+		return nil
+	}
+	if elapsed := time.Since(db.writeStarted); elapsed > db.writeTimeout {
+		return &WriteTimeoutError{Budget: db.writeTimeout, Elapsed: elapsed, Completed: completed, Total: total}
+	}
+	return nil
+}
+
+/**
+ * Sets a hook called within the write transaction for every note about to be saved, added or updated,
+ * with the note as it is going to be stored; an error it returns aborts the operation (rolling it back)
+ *  - the hook runs holding bolt's write lock: the longer it takes, the longer other writers wait (see
+ *    SetWriteTimeout to bound that)
+ *  - nil removes the hook
+ * param: func(string, Note) error hook
+ */
+func (db *DB) SetBeforeSave(hook func(notebookName string, note Note) error) {
+	db.beforeSave = hook
+}
+
+/**
+ * Runs the BeforeSave hook (if any) on a note about to be saved
+ */
+func (db *DB) runBeforeSave(notebookName string, note Note) error {
+	if db.beforeSave == nil {
+		return nil
+	}
+	return db.beforeSave(notebookName, note)
+}
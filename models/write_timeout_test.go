@@ -0,0 +1,114 @@
+package models_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+	"github.com/noculture/notes/notestest"
+)
+
+/**
+ * BeforeSave hook taking delay for every note whose content starts with "slow"
+ */
+func slowHook(delay time.Duration) func(string, models.Note) error {
+	return func(_ string, note models.Note) error {
+		if len(note.Content) >= 4 && note.Content[:4] == "slow" {
+			time.Sleep(delay)
+		}
+		return nil
+	}
+}
+
+func TestWriteTimeoutAbortsSlowHook(t *testing.T) {
+	db := notestest.NewDB(t)
+	db.SetBeforeSave(slowHook(20 * time.Millisecond))
+	db.SetWriteTimeout(50 * time.Millisecond)
+
+	contents := make([]string, 10)
+	for i := range contents {
+		contents[i] = "slow note " + strconv.Itoa(i)
+	}
+	err := db.AddNotes("work", contents...)
+	var timeoutErr *models.WriteTimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, models.ErrWriteTimeout) {
+		t.Fatalf("AddNotes with a slow hook: %v, want a *WriteTimeoutError", err)
+	}
+	// aborted at the first checkpoint past the budget, between notes
+	if timeoutErr.Completed < 1 || timeoutErr.Completed > 4 || timeoutErr.Total != 10 || timeoutErr.Elapsed <= timeoutErr.Budget {
+		t.Errorf("aborted with %+v, want 3 or so of 10 notes written past the 50ms budget", timeoutErr)
+	}
+	// and rolled back
+	if notes, err := db.ListNotes("work"); err != nil || len(notes) != 0 {
+		t.Errorf("%d notes left by the aborted write (%v)", len(notes), err)
+	}
+
+	// the write lock is free again, and fast writes stay within the budget
+	if _, err := db.AddNote("work", models.Note{Content: "fast"}); err != nil {
+		t.Errorf("AddNote after the abort: %v", err)
+	}
+	db.SetWriteTimeout(0)
+	if err := db.AddNotes("work", contents[:5]...); err != nil {
+		t.Errorf("AddNotes without a write timeout: %v", err)
+	}
+}
+
+func TestWriteTimeoutKeepsCommittedBatches(t *testing.T) {
+	db := notestest.NewDB(t)
+	db.SetBeforeSave(slowHook(20 * time.Millisecond))
+	db.SetWriteTimeout(30 * time.Millisecond)
+
+	notes := make(chan string, 8)
+	for _, content := range []string{"a", "b", "c", "d", "slow e", "slow f", "slow g", "h"} {
+		notes <- content
+	}
+	close(notes)
+	report, err := db.BulkLoad("bulk", notes, 4)
+	if !errors.Is(err, models.ErrWriteTimeout) {
+		t.Fatalf("BulkLoad: %v, want ErrWriteTimeout", err)
+	}
+	if report.Inserted != 4 {
+		t.Errorf("report has %d notes inserted, want the 4 of the first batch", report.Inserted)
+	}
+	if stored, err := db.ListNotes("bulk"); err != nil || len(stored) != 4 {
+		t.Errorf("%d notes stored (%v), want the first batch's 4", len(stored), err)
+	}
+}
+
+func TestBeforeSaveErrorAbortsWrite(t *testing.T) {
+	db := notestest.NewDB(t)
+	note := notestest.MustAdd(t, db, "work", "first")
+	errRejected := errors.New("rejected")
+	var seen []string
+	db.SetBeforeSave(func(notebookName string, note models.Note) error {
+		seen = append(seen, notebookName+": "+note.Content)
+		if note.Content == "rejected" {
+			return errRejected
+		}
+		return nil
+	})
+
+	if _, err := db.UpdateNote("work", note.Id, "rejected"); !errors.Is(err, errRejected) {
+		t.Errorf("UpdateNote rejected by the hook: %v", err)
+	}
+	if current, _ := db.GetNote("work", note.Id); current.Content != "first" || current.Revision != note.Revision {
+		t.Errorf("note after a rejected update = %+v", current)
+	}
+	if _, err := db.AddNote("work", models.Note{Content: "rejected"}); !errors.Is(err, errRejected) {
+		t.Errorf("AddNote rejected by the hook: %v", err)
+	}
+	if _, err := db.UpdateNote("work", note.Id, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"work: rejected", "work: rejected", "work: second"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("hook saw %q, want %q", seen, want)
+	}
+
+	db.SetBeforeSave(nil)
+	if _, err := db.AddNote("work", models.Note{Content: "rejected"}); err != nil {
+		t.Errorf("AddNote once the hook is removed: %v", err)
+	}
+}
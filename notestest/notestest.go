@@ -0,0 +1,271 @@
+package notestest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/noculture/notes/models"
+)
+
+/**
+ * Helpers for tests of code embedding notes, so that they needn't build databases by hand
+ *  - NewDB gives a throwaway DB, closed and removed once the test is over
+ *  - Seed fills it from a Spec deterministically (notebooks in order of names, notes in given order,
+ *    created a minute apart from Spec.Start), MustAdd and MustAddNote add single notes
+ *  - AssertExportEqual compares exports of all notebooks against a golden file, rewritten instead
+ *    when NOTESTEST_UPDATE is set (`NOTESTEST_UPDATE=1 go test ./...`); values differing from run to run (times, fingerprints, clocks) are masked
+ *  - AssertGolden does the same for any output, like what a command prints
+ * The package lives apart from models so that models never depends on testing
+ */
+
+/**
+ * Environment variable which, set to a true value ("1", "true"), has AssertExportEqual and AssertGolden
+ * rewrite golden files rather than compare against them; an environment variable rather than a flag,
+ * since `go test ./...` passes flags to every test binary, and those not importing notestest reject them
+ */
+const UpdateEnv = "NOTESTEST_UPDATE"
+
+/**
+ * Tells whether golden files are to be rewritten (see UpdateEnv)
+ */
+func updating() bool {
+	update, _ := strconv.ParseBool(os.Getenv(UpdateEnv))
+	return update
+}
+
+/**
+ * Time notes seeded by a Spec without start are created from
+ */
+var DefaultStart = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+/**
+ * Keys of exports whose values differ from run to run, masked by AssertExportEqual
+ */
+var volatileKeys = map[string]bool{
+	"exported_at": true, "created_at": true, "updated_at": true, "added_at": true, "saved_at": true,
+	"fingerprint": true, "clock": true,
+}
+
+// what masked values are replaced with
+const masked = "<masked>"
+
+/**
+ * Content of a DB for Seed
+ *  - Notebooks: contents of notes by notebook name
+ *  - Tags: tags of every seeded note
+ *  - Start: creation time of the first note of every notebook, the others following a minute apart
+ *    (DefaultStart if zero)
+ */
+type Spec struct {
+	Notebooks map[string][]string
+	Tags      []string
+	Start     time.Time
+}
+
+/**
+ * Creates a throwaway DB (see models.OpenTemp), closed and removed once the test and its subtests are over
+ * param: testing.TB t
+ * return: *models.DB
+ */
+func NewDB(t testing.TB) *models.DB {
+	t.Helper()
+	db, cleanup, err := models.OpenTemp()
+	if err != nil {
+		t.Fatalf("notestest: creating DB: %v", err)
+	}
+	t.Cleanup(cleanup)
+	return db
+}
+
+/**
+ * Adds the notebooks and notes of spec to a DB (see above), failing the test if it can't
+ * param: testing.TB t
+ * param: *models.DB db
+ * param: Spec       spec
+ * return: map[string][]uint64 Ids of the notes added, by notebook, in the order of spec
+ */
+func Seed(t testing.TB, db *models.DB, spec Spec) map[string][]uint64 {
+	t.Helper()
+	start := spec.Start
+	if start.IsZero() {
+		start = DefaultStart
+	}
+	var names []string
+	for name := range spec.Notebooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ids := make(map[string][]uint64, len(names))
+	for _, name := range names {
+		for i, content := range spec.Notebooks[name] {
+			note := models.Note{Content: content, Tags: spec.Tags, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+			added, err := db.AddNote(name, note)
+			if err != nil {
+				t.Fatalf("notestest: seeding notebook '%s': %v", name, err)
+			}
+			ids[name] = append(ids[name], added.Id)
+		}
+	}
+	return ids
+}
+
+/**
+ * Adds a note to a notebook, failing the test if it can't
+ * param: testing.TB t
+ * param: *models.DB db
+ * param: string     notebookName
+ * param: string     content
+ * return: models.Note Note as added
+ */
+func MustAdd(t testing.TB, db *models.DB, notebookName string, content string) models.Note {
+	t.Helper()
+	note, err := db.AddNote(notebookName, models.Note{Content: content})
+	if err != nil {
+		t.Fatalf("notestest: adding note to '%s': %v", notebookName, err)
+	}
+	return note
+}
+
+/**
+ * Adds a note with more than content (tags, kind, times...) to a notebook, failing the test if it can't
+ * param: testing.TB  t
+ * param: *models.DB  db
+ * param: string      notebookName
+ * param: models.Note note
+ * return: models.Note Note as added
+ */
+func MustAddNote(t testing.TB, db *models.DB, notebookName string, note models.Note) models.Note {
+	t.Helper()
+	added, err := db.AddNote(notebookName, note)
+	if err != nil {
+		t.Fatalf("notestest: adding note to '%s': %v", notebookName, err)
+	}
+	return added
+}
+
+/**
+ * Compares exports of all notebooks (archived ones included, in order of names, see models.DB.ExportNotebook)
+ * with a golden file, as an indented JSON array of their records with volatile values masked (see above);
+ * with NOTESTEST_UPDATE set, the golden file is written (along with its directory) instead
+ * param: testing.TB t
+ * param: *models.DB db
+ * param: string     golden Path of the golden file, like "testdata/expected.json"
+ */
+func AssertExportEqual(t testing.TB, db *models.DB, golden string) {
+	t.Helper()
+	got, err := normalizedExport(db)
+	if err != nil {
+		t.Fatalf("notestest: exporting: %v", err)
+	}
+	AssertGolden(t, got, golden)
+}
+
+/**
+ * Compares output with a golden file; with NOTESTEST_UPDATE set, the golden file is written (along with its directory) instead
+ * param: testing.TB t
+ * param: []byte     got
+ * param: string     golden Path of the golden file, like "testdata/search.golden"
+ */
+func AssertGolden(t testing.TB, got []byte, golden string) {
+	t.Helper()
+	if updating() {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("notestest: updating '%s': %v", golden, err)
+		}
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("notestest: updating '%s': %v", golden, err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("notestest: reading '%s' (set NOTESTEST_UPDATE=1 to create it): %v", golden, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("notestest: output differs from '%s' (set NOTESTEST_UPDATE=1 to accept it):\n%s", golden, firstDifference(want, got))
+	}
+}
+
+/**
+ * Exports of all notebooks as compared by AssertExportEqual
+ */
+func normalizedExport(db *models.DB) ([]byte, error) {
+	names, err := db.GetAllNotebookNames(models.WithArchivedNotebooks())
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	records := []interface{}{}
+	for _, name := range names {
+		var export bytes.Buffer
+		if err := db.ExportNotebook(name, &export); err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(&export)
+		for {
+			var record interface{}
+			if err := decoder.Decode(&record); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, mask(record))
+		}
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(records)
+	return encoded.Bytes(), err
+}
+
+/**
+ * Replaces values of volatile keys (see above) found anywhere in a decoded JSON value
+ */
+func mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if volatileKeys[key] {
+				v[key] = masked
+			} else {
+				v[key] = mask(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = mask(inner)
+		}
+	}
+	return value
+}
+
+/**
+ * First differing line of two texts, for failure messages
+ */
+func firstDifference(want, got []byte) string {
+	wantLines, gotLines := bytes.Split(want, []byte("\n")), bytes.Split(got, []byte("\n"))
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if !bytes.Equal(w, g) {
+			return "line " + strconv.Itoa(i+1) + ":\n  want: " + string(w) + "\n  got:  " + string(g)
+		}
+	}
+	return ""
+}
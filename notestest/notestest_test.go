@@ -0,0 +1,64 @@
+package notestest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ * Sets UpdateEnv to value for the rest of the test
+ */
+func setUpdateEnv(t *testing.T, value string) {
+	t.Helper()
+	previous, set := os.LookupEnv(UpdateEnv)
+	if err := os.Setenv(UpdateEnv, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if set {
+			os.Setenv(UpdateEnv, previous)
+		} else {
+			os.Unsetenv(UpdateEnv)
+		}
+	})
+}
+
+func TestAssertGoldenUpdatesWithEnv(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "testdata", "out.golden")
+	setUpdateEnv(t, "1")
+	AssertGolden(t, []byte("first\n"), golden)
+	if written, err := ioutil.ReadFile(golden); err != nil || string(written) != "first\n" {
+		t.Fatalf("golden file holds %q (%v), want it written", written, err)
+	}
+
+	// once unset, or set to something false, output is compared against the golden file
+	for _, value := range []string{"", "0", "false"} {
+		setUpdateEnv(t, value)
+		if updating() {
+			t.Errorf("%s=%q rewrites golden files", UpdateEnv, value)
+		}
+		AssertGolden(t, []byte("first\n"), golden)
+	}
+	for _, value := range []string{"1", "true"} {
+		setUpdateEnv(t, value)
+		if !updating() {
+			t.Errorf("%s=%q doesn't rewrite golden files", UpdateEnv, value)
+		}
+	}
+}
+
+func TestAssertExportEqualMasksVolatileValues(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "expected.json")
+	setUpdateEnv(t, "1")
+	db := NewDB(t)
+	Seed(t, db, Spec{Notebooks: map[string][]string{"work": {"a", "b"}}})
+	AssertExportEqual(t, db, golden)
+
+	// the same notes seeded at another time export the same, once masked
+	setUpdateEnv(t, "")
+	other := NewDB(t)
+	Seed(t, other, Spec{Notebooks: map[string][]string{"work": {"a", "b"}}, Start: DefaultStart.AddDate(1, 0, 0)})
+	AssertExportEqual(t, other, golden)
+}
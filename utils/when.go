@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Human-friendly times, as taken by CLI flags and query parameters of the REST API
+ *  - dates and times: '2024-05-01', '2024-05-01 14:30', RFC 3339 ('2024-05-01T14:30:00Z')
+ *  - days: 'today', 'tomorrow', 'yesterday', 'next friday' (the first friday after today),
+ *    'last friday' (the last one before today), optionally at a time of day ('tomorrow 9am',
+ *    'next monday at 14:30', 'today noon'); days without a time of day start at midnight
+ *  - durations: '3d', '2h', '1w', '1h30m' ('d' and 'w' are calendar days and weeks), 'in 3d' or '3d ago'
+ *  - 'now'
+ * Everything is relative to the given now and location, so that the same input always gives the same
+ * time for the same now. Input that could mean several times (like 'friday', '9am' or '05/01/2024') is
+ * refused with suggestions rather than guessed at
+ */
+
+var (
+	// returned for times that can't be parsed, or that could mean several times
+	ErrInvalidTime = errors.New("invalid time")
+	// returned by ParseDuration for durations that can't be parsed
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+var (
+	whenDuration     = regexp.MustCompile(`^(\d+[wdhms])+$`)
+	whenDurationPart = regexp.MustCompile(`(\d+)([wdhms])`)
+	whenClock        = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	whenSlashedDate  = regexp.MustCompile(`^(\d{1,2})[/.](\d{1,2})[/.](\d{4})$`)
+	whenDateLayouts  = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+	whenDays         = map[string]int{"today": 0, "tomorrow": 1, "yesterday": -1}
+	whenClockWords   = map[string]int{"noon": 12, "midnight": 0}
+	whenWeekdays     = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	}
+)
+
+/**
+ * Parses a time as per the forms above, durations giving times after now ('3d' is in 3 days);
+ * for times things are due at
+ * param: string         s
+ * param: time.Time      now
+ * param: *time.Location loc Location days and dates are in (nil for time.Local)
+ * return: (time.Time, error)
+ */
+func ParseWhen(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	return parseWhen(s, now, loc, 1)
+}
+
+/**
+ * Parses a time as per the forms above, durations giving times before now ('3d' is 3 days ago);
+ * for times things are looked for since
+ * param: string         s
+ * param: time.Time      now
+ * param: *time.Location loc Location days and dates are in (nil for time.Local)
+ * return: (time.Time, error)
+ */
+func ParseSince(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	return parseWhen(s, now, loc, -1)
+}
+
+/**
+ * Parses a duration, allowing days and weeks ('90d', '2w', '1d12h'; of 24 hours and 7 days) besides
+ * what time.ParseDuration accepts
+ * param: string s
+ * return: (time.Duration, error)
+ */
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if days, rest, ok := parseDurationParts(s); ok {
+		return time.Duration(days)*24*time.Hour + rest, nil
+	}
+	if duration, err := time.ParseDuration(s); err == nil && duration >= 0 {
+		return duration, nil
+	}
+	return 0, fmt.Errorf("%w '%s': give days (like 90d), weeks (like 2w) or a duration (like 12h)", ErrInvalidDuration, s)
+}
+
+/**
+ * Core logic of ParseWhen and ParseSince; sign tells which way bare durations go from now
+ */
+func parseWhen(s string, now time.Time, loc *time.Location, sign int) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	now = now.In(loc)
+	input := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	if input == "" {
+		return time.Time{}, fmt.Errorf("%w: empty (%s)", ErrInvalidTime, whenForms)
+	}
+	if input == "now" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(input)); err == nil {
+		return t, nil
+	}
+	for _, layout := range whenDateLayouts {
+		if t, err := time.ParseInLocation(layout, input, loc); err == nil {
+			return t, nil
+		}
+	}
+	if m := whenSlashedDate.FindStringSubmatch(input); m != nil {
+		return time.Time{}, fmt.Errorf("%w '%s' is ambiguous: did you mean %s-%02s-%02s or %s-%02s-%02s?",
+			ErrInvalidTime, s, m[3], m[1], m[2], m[3], m[2], m[1])
+	}
+
+	// durations
+	durationSign, durationText := sign, input
+	if strings.HasPrefix(input, "in ") {
+		durationSign, durationText = 1, strings.TrimPrefix(input, "in ")
+	} else if strings.HasSuffix(input, " ago") {
+		durationSign, durationText = -1, strings.TrimSuffix(input, " ago")
+	}
+	if days, rest, ok := parseDurationParts(durationText); ok {
+		return now.AddDate(0, 0, durationSign*days).Add(time.Duration(durationSign) * rest), nil
+	}
+	if duration, err := time.ParseDuration(durationText); err == nil && duration >= 0 {
+		return now.Add(time.Duration(durationSign) * duration), nil
+	}
+
+	// a day, optionally at a time of day
+	words := strings.Fields(input)
+	var day *time.Time
+	hour, minute, clocked := 0, 0, false
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if offset, ok := whenDays[word]; ok && day == nil {
+			d := startOfDay(now).AddDate(0, 0, offset)
+			day = &d
+			continue
+		}
+		if (word == "next" || word == "last") && day == nil && i+1 < len(words) {
+			weekday, ok := whenWeekdays[words[i+1]]
+			if !ok {
+				return time.Time{}, unrecognized(s, words, i+1)
+			}
+			d := shiftToWeekday(startOfDay(now), weekday, word == "next")
+			day = &d
+			i++
+			continue
+		}
+		if word == "at" && !clocked && i+1 < len(words) {
+			continue
+		}
+		if !clocked && parseClock(word, &hour, &minute) {
+			clocked = true
+			continue
+		}
+		if _, ok := whenWeekdays[word]; ok && day == nil {
+			return time.Time{}, fmt.Errorf("%w '%s' is ambiguous: did you mean '%s' or '%s'?", ErrInvalidTime, s,
+				strings.Replace(input, word, "next "+word, 1), strings.Replace(input, word, "last "+word, 1))
+		}
+		return time.Time{}, unrecognized(s, words, i)
+	}
+	if day == nil {
+		// a bare time of day could be today's or tomorrow's
+		return time.Time{}, fmt.Errorf("%w '%s' is ambiguous: did you mean 'today %s' or 'tomorrow %s'?", ErrInvalidTime, s, input, input)
+	}
+	// (the time of day on the clock of that day, which adding hours to its midnight isn't across DST changes)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location()), nil
+}
+
+/**
+ * What parseWhen accepts, for errors
+ */
+const whenForms = "give a date like 2024-05-01, a day like today, tomorrow 9am or next friday, or a duration like 3d or 2h"
+
+/**
+ * Fails for the i-th word of an input, suggesting the keyword it's likely a typo of (if any)
+ */
+func unrecognized(s string, words []string, i int) error {
+	best, bestDistance := "", 3
+	for _, keyword := range whenKeywords() {
+		// (short keywords are a few typos away from anything)
+		if distance := editDistance(words[i], keyword); distance > 0 && distance < bestDistance && distance < len(keyword)/2 {
+			best, bestDistance = keyword, distance
+		}
+	}
+	if best != "" {
+		suggested := append(append(append([]string(nil), words[:i]...), best), words[i+1:]...)
+		return fmt.Errorf("%w '%s': did you mean '%s'?", ErrInvalidTime, s, strings.Join(suggested, " "))
+	}
+	return fmt.Errorf("%w '%s' (%s)", ErrInvalidTime, s, whenForms)
+}
+
+func whenKeywords() []string {
+	keywords := []string{"now", "next", "last", "at", "ago", "in"}
+	for word := range whenDays {
+		keywords = append(keywords, word)
+	}
+	for word := range whenClockWords {
+		keywords = append(keywords, word)
+	}
+	for word := range whenWeekdays {
+		keywords = append(keywords, word)
+	}
+	// sorted, so that suggestions don't depend on map order
+	sort.Strings(keywords)
+	return keywords
+}
+
+/**
+ * Parses a duration made of whole numbers of weeks, days, hours, minutes and seconds, like '1w2d' or '1h30m';
+ * returns the weeks and days as days, and the rest as a duration
+ */
+func parseDurationParts(s string) (int, time.Duration, bool) {
+	if !whenDuration.MatchString(s) {
+		return 0, 0, false
+	}
+	days, rest := 0, time.Duration(0)
+	for _, m := range whenDurationPart.FindAllStringSubmatch(s, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, 0, false
+		}
+		switch m[2] {
+		case "w":
+			days += 7 * n
+		case "d":
+			days += n
+		case "h":
+			rest += time.Duration(n) * time.Hour
+		case "m":
+			rest += time.Duration(n) * time.Minute
+		case "s":
+			rest += time.Duration(n) * time.Second
+		}
+	}
+	return days, rest, true
+}
+
+/**
+ * Parses a time of day like '9am', '9:30pm', '14:00' or 'noon'
+ */
+func parseClock(word string, hour, minute *int) bool {
+	if h, ok := whenClockWords[word]; ok {
+		*hour, *minute = h, 0
+		return true
+	}
+	m := whenClock.FindStringSubmatch(word)
+	// a bare number is no time of day
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min := 0
+	if m[2] != "" {
+		min, _ = strconv.Atoi(m[2])
+	}
+	switch {
+	case m[3] != "" && (h < 1 || h > 12):
+		return false
+	case m[3] == "am" && h == 12:
+		h = 0
+	case m[3] == "pm" && h != 12:
+		h += 12
+	}
+	if h > 23 || min > 59 {
+		return false
+	}
+	*hour, *minute = h, min
+	return true
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+/**
+ * First given weekday after day (next), or last one before it
+ */
+func shiftToWeekday(day time.Time, weekday time.Weekday, next bool) time.Time {
+	if next {
+		days := (int(weekday)-int(day.Weekday())+6)%7 + 1
+		return day.AddDate(0, 0, days)
+	}
+	days := (int(day.Weekday())-int(weekday)+6)%7 + 1
+	return day.AddDate(0, 0, -days)
+}
+
+/**
+ * Levenshtein distance between two words
+ */
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	// (DST cases don't depend on the zone database of the machine)
+	_ "time/tzdata"
+)
+
+func TestParseWhen(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a wednesday
+	now := time.Date(2024, time.May, 15, 10, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		input string
+		now   time.Time
+		loc   *time.Location
+		when  time.Time
+		since time.Time
+	}{
+		{input: "now", when: now, since: now},
+		{input: "2024-05-01", when: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{input: "2024-05-01 14:30", when: time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC)},
+		{input: "2024-05-01T14:30:00+02:00", when: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)},
+		{input: "today", when: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{input: "Tomorrow  9am", when: time.Date(2024, 5, 16, 9, 0, 0, 0, time.UTC)},
+		{input: "yesterday noon", when: time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)},
+		{input: "today 12am", when: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{input: "next friday", when: time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{input: "next wednesday at 14:30", when: time.Date(2024, 5, 22, 14, 30, 0, 0, time.UTC)},
+		{input: "last wednesday 9:15pm", when: time.Date(2024, 5, 8, 21, 15, 0, 0, time.UTC)},
+		{input: "3d", when: now.AddDate(0, 0, 3), since: now.AddDate(0, 0, -3)},
+		{input: "1w2h", when: now.AddDate(0, 0, 7).Add(2 * time.Hour), since: now.AddDate(0, 0, -7).Add(-2 * time.Hour)},
+		{input: "1h30m", when: now.Add(90 * time.Minute), since: now.Add(-90 * time.Minute)},
+		{input: "in 2h", when: now.Add(2 * time.Hour), since: now.Add(2 * time.Hour)},
+		{input: "2h ago", when: now.Add(-2 * time.Hour), since: now.Add(-2 * time.Hour)},
+		// days and dates are in the given location
+		{input: "today 9am", loc: newYork, when: time.Date(2024, 5, 15, 9, 0, 0, 0, newYork)},
+		{input: "2024-05-01", loc: newYork, when: time.Date(2024, 5, 1, 0, 0, 0, 0, newYork)},
+		// DST starts on 2024-03-10 at 2am (clocks skip to 3am), and ends on 2024-11-03 at 2am (back to 1am)
+		{input: "today 9am", now: time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC)},
+		{input: "tomorrow 9am", now: time.Date(2024, 3, 9, 18, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC)},
+		{input: "today 9am", now: time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 11, 3, 14, 0, 0, 0, time.UTC)},
+		{input: "next sunday noon", now: time.Date(2024, 10, 30, 16, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 11, 3, 17, 0, 0, 0, time.UTC)},
+		// days are calendar days, of 23 or 25 hours across DST changes
+		{input: "1d", now: time.Date(2024, 3, 9, 17, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 3, 10, 16, 0, 0, 0, time.UTC), since: time.Date(2024, 3, 8, 17, 0, 0, 0, time.UTC)},
+		{input: "24h", now: time.Date(2024, 3, 9, 17, 0, 0, 0, time.UTC), loc: newYork,
+			when: time.Date(2024, 3, 10, 17, 0, 0, 0, time.UTC), since: time.Date(2024, 3, 8, 17, 0, 0, 0, time.UTC)},
+	} {
+		testNow, loc := test.now, test.loc
+		if testNow.IsZero() {
+			testNow = now
+		}
+		if loc == nil {
+			loc = time.UTC
+		}
+		when, err := ParseWhen(test.input, testNow, loc)
+		if err != nil || !when.Equal(test.when) {
+			t.Errorf("ParseWhen(%q) = %v (%v), want %v", test.input, when, err, test.when)
+		}
+		// absolute times are the same either way
+		wantSince := test.since
+		if wantSince.IsZero() {
+			wantSince = test.when
+		}
+		if since, err := ParseSince(test.input, testNow, loc); err != nil || !since.Equal(wantSince) {
+			t.Errorf("ParseSince(%q) = %v (%v), want %v", test.input, since, err, wantSince)
+		}
+	}
+}
+
+func TestParseWhenRefusesAmbiguousInput(t *testing.T) {
+	now := time.Date(2024, time.May, 15, 10, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		input      string
+		suggestion string
+	}{
+		{"", "give a date like"},
+		{"friday", "did you mean 'next friday' or 'last friday'?"},
+		{"9am", "did you mean 'today 9am' or 'tomorrow 9am'?"},
+		{"05/01/2024", "did you mean 2024-05-01 or 2024-01-05?"},
+		{"tomorow", "did you mean 'tomorrow'?"},
+		{"next fridya 9am", "did you mean 'next friday 9am'?"},
+		{"today 13pm", "give a date like"},
+		{"whenever", "give a date like"},
+	} {
+		_, err := ParseWhen(test.input, now, time.UTC)
+		if !errors.Is(err, ErrInvalidTime) || !strings.Contains(err.Error(), test.suggestion) {
+			t.Errorf("ParseWhen(%q) failed with %v, want ErrInvalidTime suggesting %q", test.input, err, test.suggestion)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for input, want := range map[string]time.Duration{
+		"90d": 90 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "1d12h": 36 * time.Hour, "12h": 12 * time.Hour, "1.5h": 90 * time.Minute,
+	} {
+		if got, err := ParseDuration(input); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v (%v), want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "-3d", "soon", "3x"} {
+		if _, err := ParseDuration(input); !errors.Is(err, ErrInvalidDuration) {
+			t.Errorf("ParseDuration(%q) failed with %v, want ErrInvalidDuration", input, err)
+		}
+	}
+}